@@ -0,0 +1,211 @@
+// Package bootstrap generates what the agent needs set up in AWS, for the
+// bootstrap subcommand: an IAM policy allowing just what it does with the
+// options given, the statements the log buckets' policies need for access
+// logs to be delivered to them, and the DynamoDB table of --highavail. They
+// are printed as JSON, or as a CloudFormation template or Terraform
+// configuration to be applied as they are.
+package bootstrap
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/meta"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// Policy is an IAM or bucket policy document.
+type Policy struct {
+	Version   string      `json:"Version" yaml:"Version"`
+	Statement []Statement `json:"Statement" yaml:"Statement"`
+}
+
+// Statement is a statement of a Policy.
+type Statement struct {
+	Sid       string            `json:"Sid,omitempty" yaml:"Sid,omitempty"`
+	Effect    string            `json:"Effect" yaml:"Effect"`
+	Principal map[string]string `json:"Principal,omitempty" yaml:"Principal,omitempty"`
+	Action    []string          `json:"Action" yaml:"Action"`
+	Resource  []string          `json:"Resource" yaml:"Resource"`
+}
+
+func newPolicy(statements ...Statement) Policy {
+	return Policy{Version: "2012-10-17", Statement: statements}
+}
+
+func allow(sid string, actions []string, resources ...string) Statement {
+	return Statement{Sid: sid, Effect: "Allow", Action: actions, Resource: resources}
+}
+
+// Config is what the resources are generated for.
+type Config struct {
+	// Name names the generated resources, e.g. honeyalb.
+	Name string
+	// AccountID and Region are those of the DynamoDB table.
+	AccountID, Region string
+	// Deliveries are where the load balancers' logs are delivered to.
+	Deliveries []*logbucket.LogDelivery
+	Options    *options.Options
+	// Tags are the --aws_tags the table is created with.
+	Tags map[string]string
+}
+
+// Resources are what the agent needs set up in AWS.
+type Resources struct {
+	Name      string `json:"-"`
+	IAMPolicy Policy `json:"iam_policy"`
+	// BucketPolicies are by bucket, with only the statement allowing the
+	// logs to be delivered.
+	BucketPolicies map[string]Policy `json:"bucket_policies"`
+	// Table and TimeToLive are the input for creating the DynamoDB table and
+	// turning on its TTL, with --highavail.
+	Table      *dynamodb.CreateTableInput      `json:"dynamodb_table,omitempty"`
+	TimeToLive *dynamodb.UpdateTimeToLiveInput `json:"dynamodb_time_to_live,omitempty"`
+}
+
+// appendNew appends the values which s doesn't have yet.
+func appendNew(s []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range s {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+// queueARN returns the ARN of the SQS queue at the URL, e.g.
+// https://sqs.us-east-1.amazonaws.com/123456789012/honeyaws, or * if it
+// isn't one.
+func queueARN(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return "*"
+	}
+	host := strings.Split(u.Hostname(), ".")
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(host) < 3 || host[0] != "sqs" || len(path) != 2 {
+		return "*"
+	}
+	region := host[1]
+	return fmt.Sprintf("arn:%s:sqs:%s:%s:%s", meta.Partition(region), region, path[0], path[1])
+}
+
+// Generate returns the resources for the config's options and log
+// deliveries.
+func Generate(c Config) *Resources {
+	opt := c.Options
+	r := &Resources{Name: c.Name, BucketPolicies: make(map[string]Policy)}
+
+	describe := []string{
+		"elasticloadbalancing:DescribeLoadBalancers",
+		"elasticloadbalancing:DescribeLoadBalancerAttributes",
+		"elasticloadbalancing:DescribeTags",
+	}
+	if opt.EnrichTargets {
+		describe = append(describe, "ec2:DescribeInstances")
+	}
+	if opt.EnrichAuth {
+		describe = append(describe, "elasticloadbalancing:DescribeListeners", "elasticloadbalancing:DescribeRules")
+	}
+	statements := []Statement{
+		allow("DescribeLoadBalancers", describe, "*"),
+		allow("GetCallerIdentity", []string{"sts:GetCallerIdentity"}, "*"),
+	}
+	if len(opt.AssumeRoleARNs) > 0 {
+		statements = append(statements, allow("AssumeRoles", []string{"sts:AssumeRole", "sts:SetSourceIdentity"}, opt.AssumeRoleARNs...))
+	}
+
+	var buckets, objects []string
+	deliveries := make(map[string][]*logbucket.LogDelivery)
+	for _, d := range c.Deliveries {
+		buckets = appendNew(buckets, d.BucketARN())
+		objects = appendNew(objects, d.Resource())
+		deliveries[d.Bucket] = append(deliveries[d.Bucket], d)
+	}
+	if len(buckets) > 0 {
+		statements = append(statements,
+			allow("ListLogBuckets", []string{"s3:ListBucket"}, buckets...),
+			allow("ReadAccessLogs", []string{"s3:GetObject"}, objects...))
+		if opt.CheckPolicy || opt.FixPolicy {
+			actions := []string{"s3:GetBucketPolicy"}
+			if opt.FixPolicy {
+				actions = append(actions, "s3:PutBucketPolicy")
+			}
+			statements = append(statements, allow("CheckBucketPolicies", actions, buckets...))
+		}
+	}
+	if opt.KMSKeyARN != "" {
+		statements = append(statements, allow("DecryptAccessLogs", []string{"kms:Decrypt", "kms:DescribeKey"}, opt.KMSKeyARN))
+	}
+	if opt.SQSQueueURL != "" {
+		statements = append(statements, allow("ReceiveNotifications", []string{"sqs:ReceiveMessage", "sqs:DeleteMessage", "sqs:SendMessage"}, queueARN(opt.SQSQueueURL)))
+	}
+
+	if opt.HighAvail && opt.StateBackend == "" {
+		table := fmt.Sprintf("arn:%s:dynamodb:%s:%s:table/%s", meta.Partition(c.Region), c.Region, c.AccountID, opt.DynamoTable)
+		actions := []string{
+			"dynamodb:DescribeTable",
+			"dynamodb:GetItem",
+			"dynamodb:PutItem",
+			"dynamodb:DeleteItem",
+			"dynamodb:BatchGetItem",
+			"dynamodb:BatchWriteItem",
+			"dynamodb:Query",
+			"dynamodb:Scan",
+		}
+		if opt.CreateTable {
+			actions = append(actions, "dynamodb:CreateTable", "dynamodb:UpdateTable", "dynamodb:UpdateTimeToLive")
+			if len(c.Tags) > 0 {
+				actions = append(actions, "dynamodb:TagResource")
+			}
+		}
+		statements = append(statements, allow("KeepState", actions, table, table+"/index/*"))
+		r.Table = state.CreateTableInput(opt.DynamoTable, c.Tags)
+		r.TimeToLive = &dynamodb.UpdateTimeToLiveInput{
+			TableName:               aws.String(opt.DynamoTable),
+			TimeToLiveSpecification: state.TimeToLiveSpecification(),
+		}
+	}
+	r.IAMPolicy = newPolicy(statements...)
+
+	// The load balancers delivering to a bucket are in its region, so
+	// their principal is the same, and one statement has all their
+	// prefixes and accounts.
+	for bucket, ds := range deliveries {
+		key, principal := ds[0].Principal()
+		st := Statement{
+			Sid:       logbucket.LogDeliverySid,
+			Effect:    "Allow",
+			Principal: map[string]string{key: principal},
+			Action:    []string{"s3:PutObject"},
+		}
+		for _, d := range ds {
+			st.Resource = appendNew(st.Resource, d.Resource())
+		}
+		r.BucketPolicies[bucket] = newPolicy(st)
+	}
+	return r
+}
+
+// buckets returns the names of the buckets with policies, in order.
+func (r *Resources) buckets() []string {
+	var buckets []string
+	for bucket := range r.BucketPolicies {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	return buckets
+}
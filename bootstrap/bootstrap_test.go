@@ -0,0 +1,209 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func testConfig(opt *options.Options) Config {
+	return Config{
+		Name:      "honeyalb",
+		AccountID: "123456789012",
+		Region:    "us-east-1",
+		Options:   opt,
+		Deliveries: []*logbucket.LogDelivery{
+			{Service: logbucket.AWSElasticLoadBalancingV2, Bucket: "lb-logs.prod", Prefix: "api", AccountID: "123456789012", Region: "us-east-1"},
+			{Service: logbucket.AWSElasticLoadBalancingV2, Bucket: "lb-logs.prod", Prefix: "web/", AccountID: "123456789012", Region: "us-east-1"},
+			{Service: logbucket.AWSElasticLoadBalancingV2, Bucket: "lb-logs-eu", AccountID: "123456789012", Region: "eu-west-1"},
+		},
+	}
+}
+
+func statement(p Policy, sid string) *Statement {
+	for i := range p.Statement {
+		if p.Statement[i].Sid == sid {
+			return &p.Statement[i]
+		}
+	}
+	return nil
+}
+
+func TestGenerate(t *testing.T) {
+	r := Generate(testConfig(&options.Options{DynamoTable: "HoneyAWSAccessLogBuckets"}))
+	if st := statement(r.IAMPolicy, "ListLogBuckets"); st == nil || !reflect.DeepEqual(st.Resource, []string{"arn:aws:s3:::lb-logs.prod", "arn:aws:s3:::lb-logs-eu"}) {
+		t.Errorf("expected each bucket to be listable once, got %+v", st)
+	}
+	expected := []string{
+		"arn:aws:s3:::lb-logs.prod/api/AWSLogs/123456789012/*",
+		"arn:aws:s3:::lb-logs.prod/web/AWSLogs/123456789012/*",
+		"arn:aws:s3:::lb-logs-eu/AWSLogs/123456789012/*",
+	}
+	if st := statement(r.IAMPolicy, "ReadAccessLogs"); st == nil || !reflect.DeepEqual(st.Resource, expected) {
+		t.Errorf("expected the logs to be readable, got %+v", st)
+	}
+	for _, sid := range []string{"CheckBucketPolicies", "DecryptAccessLogs", "ReceiveNotifications", "KeepState", "AssumeRoles"} {
+		if st := statement(r.IAMPolicy, sid); st != nil {
+			t.Errorf("expected no %s statement without its options, got %+v", sid, st)
+		}
+	}
+	if r.Table != nil {
+		t.Errorf("expected no table without --highavail, got %v", r.Table)
+	}
+
+	st := statement(r.BucketPolicies["lb-logs.prod"], logbucket.LogDeliverySid)
+	if st == nil || st.Principal["AWS"] != "arn:aws:iam::127311923021:root" || len(st.Resource) != 2 {
+		t.Errorf("expected one statement for both prefixes, got %+v", r.BucketPolicies["lb-logs.prod"])
+	}
+	st = statement(r.BucketPolicies["lb-logs-eu"], logbucket.LogDeliverySid)
+	if st == nil || st.Principal["AWS"] != "arn:aws:iam::156460612806:root" {
+		t.Errorf("expected eu-west-1's log delivery account, got %+v", r.BucketPolicies["lb-logs-eu"])
+	}
+
+	r = Generate(testConfig(&options.Options{
+		HighAvail:      true,
+		CreateTable:    true,
+		DynamoTable:    "honeyaws-prod",
+		FixPolicy:      true,
+		KMSKeyARN:      "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+		SQSQueueURL:    "https://sqs.us-east-1.amazonaws.com/123456789012/honeyaws",
+		AssumeRoleARNs: []string{"arn:aws:iam::210987654321:role/honeyaws"},
+	}))
+	for sid, action := range map[string]string{
+		"CheckBucketPolicies":  "s3:PutBucketPolicy",
+		"DecryptAccessLogs":    "kms:Decrypt",
+		"ReceiveNotifications": "sqs:ReceiveMessage",
+		"KeepState":            "dynamodb:CreateTable",
+		"AssumeRoles":          "sts:AssumeRole",
+	} {
+		st := statement(r.IAMPolicy, sid)
+		if st == nil || !strings.Contains(strings.Join(st.Action, " "), action) {
+			t.Errorf("expected %s to allow %s, got %+v", sid, action, st)
+		}
+	}
+	if st := statement(r.IAMPolicy, "ReceiveNotifications"); st.Resource[0] != "arn:aws:sqs:us-east-1:123456789012:honeyaws" {
+		t.Errorf("unexpected queue ARN %v", st.Resource)
+	}
+	if st := statement(r.IAMPolicy, "KeepState"); st.Resource[0] != "arn:aws:dynamodb:us-east-1:123456789012:table/honeyaws-prod" {
+		t.Errorf("unexpected table ARN %v", st.Resource)
+	}
+	if r.Table == nil || *r.Table.TableName != "honeyaws-prod" || *r.TimeToLive.TimeToLiveSpecification.AttributeName != "TTL" {
+		t.Errorf("expected the table with --highavail, got %v", r.Table)
+	}
+	if st := statement(r.IAMPolicy, "KeepState"); strings.Contains(strings.Join(st.Action, " "), "dynamodb:TagResource") {
+		t.Errorf("expected no tagging without --aws_tags, got %v", st.Action)
+	}
+
+	c := testConfig(&options.Options{HighAvail: true, CreateTable: true, DynamoTable: "honeyaws-prod"})
+	c.Tags = map[string]string{"team": "observability"}
+	r = Generate(c)
+	if st := statement(r.IAMPolicy, "KeepState"); !strings.Contains(strings.Join(st.Action, " "), "dynamodb:TagResource") {
+		t.Errorf("expected the table to be tagged with --aws_tags, got %v", st.Action)
+	}
+	if len(r.Table.Tags) != 1 || *r.Table.Tags[0].Key != "team" {
+		t.Errorf("expected the table to have the tags, got %v", r.Table.Tags)
+	}
+}
+
+func TestQueueARN(t *testing.T) {
+	testCases := map[string]string{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/honeyaws":         "arn:aws:sqs:us-east-1:123456789012:honeyaws",
+		"https://sqs.cn-north-1.amazonaws.com.cn/123456789012/honeyaws":     "arn:aws-cn:sqs:cn-north-1:123456789012:honeyaws",
+		"http://localhost:9324/queue/honeyaws":                              "*",
+		"https://sqs.us-east-1.amazonaws.com/123456789012/honeyaws/another": "*",
+	}
+	for queueURL, expected := range testCases {
+		if arn := queueARN(queueURL); arn != expected {
+			t.Errorf("expected %s for %s, got %s", expected, queueURL, arn)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	c := testConfig(&options.Options{HighAvail: true, DynamoTable: "HoneyAWSAccessLogBuckets"})
+	c.Tags = map[string]string{"team": "observability"}
+	r := Generate(c)
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		IAMPolicy Policy                 `json:"iam_policy"`
+		Table     map[string]interface{} `json:"dynamodb_table"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc.IAMPolicy, r.IAMPolicy) {
+		t.Errorf("expected the IAM policy, got %+v", doc.IAMPolicy)
+	}
+	if _, ok := doc.Table["ProvisionedThroughput"]; ok || doc.Table["BillingMode"] != "PAY_PER_REQUEST" {
+		t.Errorf("expected the table's input without unset fields, got %v", doc.Table)
+	}
+
+	buf.Reset()
+	if err := r.Write(&buf, FormatCloudFormation); err != nil {
+		t.Fatal(err)
+	}
+	var template struct {
+		Resources map[string]struct {
+			Type       string                 `yaml:"Type"`
+			Properties map[string]interface{} `yaml:"Properties"`
+		} `yaml:"Resources"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &template); err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]string)
+	for id, resource := range template.Resources {
+		types[id] = resource.Type
+	}
+	expectedTypes := map[string]string{
+		"IAMPolicy":        "AWS::IAM::ManagedPolicy",
+		"LogBucketPolicy1": "AWS::S3::BucketPolicy",
+		"LogBucketPolicy2": "AWS::S3::BucketPolicy",
+		"StateTable":       "AWS::DynamoDB::Table",
+	}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Errorf("expected resources %v, got %v", expectedTypes, types)
+	}
+	if bucket := template.Resources["LogBucketPolicy1"].Properties["Bucket"]; bucket != "lb-logs-eu" {
+		t.Errorf("expected the bucket policies in order, got %v first", bucket)
+	}
+	if _, ok := template.Resources["StateTable"].Properties["TimeToLiveSpecification"]; !ok {
+		t.Errorf("expected the table to have TTL, got %v", template.Resources["StateTable"].Properties)
+	}
+	if _, ok := template.Resources["StateTable"].Properties["Tags"]; !ok {
+		t.Errorf("expected the table to have the tags, got %v", template.Resources["StateTable"].Properties)
+	}
+
+	buf.Reset()
+	if err := r.Write(&buf, FormatTerraform); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`resource "aws_iam_policy" "honeyalb" {`,
+		`resource "aws_s3_bucket_policy" "honeyalb_lb-logs_prod" {`,
+		`resource "aws_s3_bucket_policy" "honeyalb_lb-logs-eu" {`,
+		`  hash_key     = "S3Object"`,
+		`    range_key          = "S3Object"`,
+		`    non_key_attributes = ["Time"]`,
+		`    attribute_name = "TTL"`,
+		`    "team" = "observability"`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+
+	if err := r.Write(&buf, "pulumi"); err == nil {
+		t.Error("expected an unknown format to be an error")
+	}
+}
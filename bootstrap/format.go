@@ -0,0 +1,213 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The --bootstrap_format values.
+const (
+	FormatJSON           = "json"
+	FormatCloudFormation = "cloudformation"
+	FormatTerraform      = "terraform"
+)
+
+// Write prints the resources in the format.
+func (r *Resources) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON, "":
+		return r.writeJSON(w)
+	case FormatCloudFormation:
+		return r.writeCloudFormation(w)
+	case FormatTerraform:
+		return r.writeTerraform(w)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// apiValue returns the input of an AWS API call as it's sent, without the
+// fields which aren't set, which is also how CloudFormation has them.
+func apiValue(input interface{}) (interface{}, error) {
+	data, err := jsonutil.BuildJSON(input)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// writeJSON prints the policies, and the table as the input of
+// aws dynamodb create-table --cli-input-json.
+func (r *Resources) writeJSON(w io.Writer) error {
+	doc := struct {
+		IAMPolicy      Policy            `json:"iam_policy"`
+		BucketPolicies map[string]Policy `json:"bucket_policies"`
+		Table          interface{}       `json:"dynamodb_table,omitempty"`
+		TimeToLive     interface{}       `json:"dynamodb_time_to_live,omitempty"`
+	}{IAMPolicy: r.IAMPolicy, BucketPolicies: r.BucketPolicies}
+	if r.Table != nil {
+		var err error
+		if doc.Table, err = apiValue(r.Table); err != nil {
+			return err
+		}
+		if doc.TimeToLive, err = apiValue(r.TimeToLive); err != nil {
+			return err
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+type cfnResource struct {
+	Type       string                 `yaml:"Type"`
+	Properties map[string]interface{} `yaml:"Properties"`
+}
+
+func (r *Resources) writeCloudFormation(w io.Writer) error {
+	resources := map[string]cfnResource{
+		"IAMPolicy": {
+			Type: "AWS::IAM::ManagedPolicy",
+			Properties: map[string]interface{}{
+				"ManagedPolicyName": r.Name,
+				"PolicyDocument":    r.IAMPolicy,
+			},
+		},
+	}
+	for i, bucket := range r.buckets() {
+		resources[fmt.Sprintf("LogBucketPolicy%d", i+1)] = cfnResource{
+			Type: "AWS::S3::BucketPolicy",
+			Properties: map[string]interface{}{
+				"Bucket":         bucket,
+				"PolicyDocument": r.BucketPolicies[bucket],
+			},
+		}
+	}
+	if r.Table != nil {
+		table, err := apiValue(r.Table)
+		if err != nil {
+			return err
+		}
+		properties := table.(map[string]interface{})
+		if properties["TimeToLiveSpecification"], err = apiValue(r.TimeToLive.TimeToLiveSpecification); err != nil {
+			return err
+		}
+		resources["StateTable"] = cfnResource{Type: "AWS::DynamoDB::Table", Properties: properties}
+	}
+
+	data, err := yaml.Marshal(map[string]interface{}{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Description":              "What " + r.Name + " needs set up in AWS, from " + r.Name + " bootstrap. The bucket policies replace any others of the buckets.",
+		"Resources":                resources,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+var terraformInvalid = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// terraformName returns s as a name for a resource, e.g. that of a bucket,
+// whose dots are replaced.
+func terraformName(s string) string {
+	return terraformInvalid.ReplaceAllString(s, "_")
+}
+
+// indentedJSON returns the policy for a heredoc.
+func indentedJSON(v interface{}) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	return string(data), err
+}
+
+var terraformTemplate = template.Must(template.New("terraform").Funcs(template.FuncMap{
+	"name":  terraformName,
+	"value": aws.StringValue,
+}).Parse(`# What {{.Name}} needs set up in AWS, from {{.Name}} bootstrap.
+
+resource "aws_iam_policy" "{{name .Name}}" {
+  name   = "{{.Name}}"
+  policy = <<EOF
+{{.IAMPolicy}}
+EOF
+}
+{{range .BucketPolicies}}
+# Replaces any other policy of the bucket.
+resource "aws_s3_bucket_policy" "{{name $.Name}}_{{name .Bucket}}" {
+  bucket = "{{.Bucket}}"
+  policy = <<EOF
+{{.Policy}}
+EOF
+}
+{{end}}{{with .Table}}
+resource "aws_dynamodb_table" "{{name $.Name}}" {
+  name         = "{{value .TableName}}"
+  billing_mode = "{{value .BillingMode}}"
+{{- range .KeySchema}}
+  {{if eq (value .KeyType) "HASH"}}hash_key {{else}}range_key{{end}}    = "{{value .AttributeName}}"
+{{- end}}
+{{range .AttributeDefinitions}}
+  attribute {
+    name = "{{value .AttributeName}}"
+    type = "{{value .AttributeType}}"
+  }
+{{end}}
+{{- range .GlobalSecondaryIndexes}}
+  global_secondary_index {
+    name               = "{{value .IndexName}}"
+{{- range .KeySchema}}
+    {{if eq (value .KeyType) "HASH"}}hash_key {{else}}range_key{{end}}          = "{{value .AttributeName}}"
+{{- end}}
+    projection_type    = "{{value .Projection.ProjectionType}}"
+{{- with .Projection.NonKeyAttributes}}
+    non_key_attributes = [{{range $i, $a := .}}{{if $i}}, {{end}}"{{value $a}}"{{end}}]
+{{- end}}
+  }
+{{end}}
+  ttl {
+    attribute_name = "{{value $.TimeToLive.TimeToLiveSpecification.AttributeName}}"
+    enabled        = true
+  }
+{{- with .Tags}}
+
+  tags = {
+{{- range .}}
+    "{{value .Key}}" = "{{value .Value}}"
+{{- end}}
+  }
+{{- end}}
+}
+{{end}}`))
+
+func (r *Resources) writeTerraform(w io.Writer) error {
+	type bucketPolicy struct {
+		Bucket, Policy string
+	}
+	data := struct {
+		*Resources
+		IAMPolicy      string
+		BucketPolicies []bucketPolicy
+	}{Resources: r}
+
+	var err error
+	if data.IAMPolicy, err = indentedJSON(r.IAMPolicy); err != nil {
+		return err
+	}
+	for _, bucket := range r.buckets() {
+		policy, err := indentedJSON(r.BucketPolicies[bucket])
+		if err != nil {
+			return err
+		}
+		data.BucketPolicies = append(data.BucketPolicies, bucketPolicy{bucket, policy})
+	}
+	return terraformTemplate.Execute(w, data)
+}
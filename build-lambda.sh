@@ -0,0 +1,16 @@
+#!/bin/bash
+
+# Build a deployable AWS Lambda zip of honeylambda.
+set -e
+
+version=${1:-dev}
+out=${GOPATH:-$HOME/go}/bin
+
+mkdir -p $out
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build \
+    -ldflags "-X main.BuildID=${version}" \
+    -o $out/bootstrap \
+    ./cmd/honeylambda
+
+(cd $out && zip -q honeylambda-${version}.zip bootstrap && rm bootstrap)
+echo $out/honeylambda-${version}.zip
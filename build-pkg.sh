@@ -33,6 +33,10 @@ fpm -s dir -n honeyaws \
     $GOPATH/bin/honeycloudfront=/usr/bin/honeycloudfront \
     $GOPATH/bin/honeycloudtrail=/usr/bin/honeycloudtrail \
     $GOPATH/bin/honeyalb=/usr/bin/honeyalb \
+    $GOPATH/bin/honeynlb=/usr/bin/honeynlb \
+    $GOPATH/bin/honeyflowlogs=/usr/bin/honeyflowlogs \
+    $GOPATH/bin/honeywaf=/usr/bin/honeywaf \
+    $GOPATH/bin/honeyapigateway=/usr/bin/honeyapigateway \
     ./service/honeycloudfront.upstart=/etc/init/honeycloudfront.conf \
     ./service/honeycloudfront.service=/lib/systemd/system/honeycloudfront.service \
     ./service/honeyelb.upstart=/etc/init/honeyelb.conf \
@@ -40,4 +44,12 @@ fpm -s dir -n honeyaws \
     ./service/honeycloudtrail.upstart=/etc/init/honeycloudtrail.conf \
     ./service/honeycloudtrail.service=/lib/systemd/system/honeycloudtrail.service \
     ./service/honeyalb.upstart=/etc/init/honeyalb.conf \
-    ./service/honeyalb.service=/lib/systemd/system/honeyalb.service
+    ./service/honeyalb.service=/lib/systemd/system/honeyalb.service \
+    ./service/honeynlb.upstart=/etc/init/honeynlb.conf \
+    ./service/honeynlb.service=/lib/systemd/system/honeynlb.service \
+    ./service/honeyflowlogs.upstart=/etc/init/honeyflowlogs.conf \
+    ./service/honeyflowlogs.service=/lib/systemd/system/honeyflowlogs.service \
+    ./service/honeywaf.upstart=/etc/init/honeywaf.conf \
+    ./service/honeywaf.service=/lib/systemd/system/honeywaf.service \
+    ./service/honeyapigateway.upstart=/etc/init/honeyapigateway.conf \
+    ./service/honeyapigateway.service=/lib/systemd/system/honeyapigateway.service
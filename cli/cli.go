@@ -0,0 +1,209 @@
+// Package cli has what the cmd tools have in common: setting up from their
+// flags and --config, the subcommands which work the same whatever the logs,
+// and wiring an ingest's downloaders up to its publisher. Each tool keeps
+// finding what to ingest, and how, to itself.
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/daemon"
+	"github.com/honeycombio/honeyaws/health"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/tracing"
+	flag "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+// Command is one of the cmd tools, with what's particular to it.
+type Command struct {
+	// Name is the tool's, e.g. honeyalb, which traces and crash reports
+	// are from, and the default --k8s-lease-name.
+	Name    string
+	Version string
+
+	// Service is what the tool's state is kept under, e.g.
+	// logbucket.AWSElasticLoadBalancingV2.
+	Service string
+	// Dataset is the dataset events are sent to by default, e.g.
+	// aws-elb-access.
+	Dataset string
+	// NamesEnv is the environment variable which can have the names of
+	// what to ingest in it, e.g. HONEYAWS_LBS.
+	NamesEnv string
+	// Usage is the tool's subcommands and arguments, shown when it's run
+	// without any.
+	Usage string
+	// BucketLogType is what ingest with --bucket parses the logs as
+	// without --log_type. Tools without it can't ingest a bucket directly.
+	BucketLogType string
+	// EventParser returns the parser of the tool's logs.
+	EventParser func() publisher.EventParser
+
+	Opt    *options.Options
+	Parser *flag.Parser
+	// Out is where subcommands print to, os.Stdout unless it's set.
+	Out io.Writer
+
+	// names are those given to ingest, for the scope of a replay.
+	names []string
+}
+
+func (c *Command) out() io.Writer {
+	if c.Out == nil {
+		return os.Stdout
+	}
+	return c.Out
+}
+
+// ParseFlags parses the command line and the --config file into the options,
+// returning the arguments left. validate-config prints the options and exits.
+func (c *Command) ParseFlags() []string {
+	c.Parser = flag.NewParser(c.Opt, flag.Default)
+	args, err := c.Parser.Parse()
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := options.LoadConfig(c.Parser, c.Opt); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading --config:", err)
+		os.Exit(1)
+	}
+	options.ApplyModeDefaults(c.Parser, c.Opt)
+	args = options.Args(args, c.NamesEnv)
+
+	if len(args) > 0 && args[0] == "validate-config" {
+		if err := options.PrintConfig(c.Parser, c.Opt, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Error printing the options:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	return args
+}
+
+// Start sets up logging, tracing, the metrics, health, profiling and admin
+// servers, and crash reports, as the options have them, returning a func to
+// flush the traces with before exiting. It exits with --version, or without a
+// subcommand.
+func (c *Command) Start(args []string) func() {
+	opt := c.Opt
+	options.SetupLogging(opt)
+
+	logrus.WithField("version", c.Version).Debug("Program starting")
+
+	shutdownTracing := func() {}
+	if opt.OTelEndpoint != "" {
+		var err error
+		shutdownTracing, err = tracing.Init(opt.OTelEndpoint, opt.OTelInsecure, c.Name, c.Version)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("Could not set up tracing")
+		}
+	}
+
+	if opt.MetricsAddr != "" {
+		metrics.Serve(opt.MetricsAddr)
+	}
+
+	if opt.HealthAddr != "" {
+		health.Serve(opt.HealthAddr)
+	}
+
+	if opt.PprofAddr != "" {
+		metrics.ServeProfiles(opt.PprofAddr)
+	}
+	if opt.AdminAddr != "" {
+		daemon.ServeAdmin(opt.AdminAddr)
+	}
+
+	if err := logbucket.SetDownloadParts(opt.DownloadPartSize, opt.DownloadPartConc); err != nil {
+		logrus.WithField("error", err).Fatal("Invalid download parts")
+	}
+
+	if opt.Dataset == "aws-$SERVICE-access" {
+		opt.Dataset = c.Dataset
+	}
+
+	if _, err := os.Stat(opt.StateDir); os.IsNotExist(err) {
+		logrus.WithField("dir", opt.StateDir).Fatal("Specified state directory does not exist")
+	}
+	crash.Init(c.Name, c.Version, opt.StateDir, opt.CrashDataset, opt)
+
+	if opt.Version {
+		fmt.Println(c.Name, "version", c.Version)
+		os.Exit(0)
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, `Usage: `+os.Args[0]+` [--flags] `+c.Usage+`
+
+Use '`+os.Args[0]+` --help' to see available flags.`)
+		os.Exit(1)
+	}
+	return shutdownTracing
+}
+
+// Run runs the subcommand, if it's one every tool has, and the tool's own
+// otherwise, e.g. ls, or ingest.
+func (c *Command) Run(args []string, own func(args []string) error) error {
+	switch args[0] {
+	case "ingest-file":
+		return c.IngestFile(args[1:])
+	case "parse":
+		return c.Parse(args[1:])
+	case "state":
+		return c.State(args[1:])
+	case "replay":
+		return c.Replay(args[1:])
+	case "status":
+		return c.Status()
+	case "ingest":
+		c.names = args[1:]
+		if c.Opt.Bucket != "" && c.BucketLogType != "" {
+			return c.IngestBucket()
+		}
+	}
+	return own(args)
+}
+
+// RequireWriteKey exits unless there's a --writekey, or events aren't sent
+// anywhere which needs one.
+func (c *Command) RequireWriteKey() {
+	if c.Opt.WriteKey == "" && c.Opt.NeedsWriteKey() {
+		logrus.Fatal(`--writekey must be set to the proper write key for the Honeycomb team.
+Your write key is available at https://ui.honeycomb.io/account`)
+	}
+}
+
+// Session returns a session with the environment's AWS config, e.g. the
+// default profile.
+func Session() *session.Session {
+	// TODO: Would be nice to have this more highly configurable.
+	return session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+}
+
+// S3Session is Session for getting logs from S3 with: limited to
+// --s3_max_concurrency and --s3_request_rate, paying for requests with
+// --requester_pays, and having checked --kms-key-arn can be used.
+func (c *Command) S3Session() (*session.Session, error) {
+	opt := c.Opt
+	sess := Session()
+	logbucket.NewS3Limiter(opt.S3MaxConcurrency, opt.S3RequestRate).Add(sess)
+	if opt.RequesterPays {
+		logbucket.AddRequesterPays(sess)
+	}
+	if opt.KMSKeyARN != "" {
+		if err := logbucket.ValidateKMSKey(sess, opt.KMSKeyARN); err != nil {
+			return nil, err
+		}
+	}
+	return sess, nil
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+func TestRunOwnSubcommands(t *testing.T) {
+	c := &Command{Opt: &options.Options{}}
+
+	var ran []string
+	own := func(args []string) error {
+		ran = append(ran, args[0])
+		return nil
+	}
+	if err := c.Run([]string{"ls"}, own); err != nil {
+		t.Fatal(err)
+	}
+	// without --bucket the tool looks up what to ingest itself
+	if err := c.Run([]string{"ingest", "my-lb"}, own); err != nil {
+		t.Fatal(err)
+	}
+	// tools without a log type of their own can't ingest a bucket
+	c.Opt.Bucket = "logs"
+	if err := c.Run([]string{"ingest"}, own); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 3 || ran[0] != "ls" || ran[1] != "ingest" || ran[2] != "ingest" {
+		t.Errorf("expected the tool's own subcommands to be run, got %v", ran)
+	}
+
+	if err := c.Run([]string{"state"}, own); err == nil {
+		t.Error("expected state without a subcommand to be an error")
+	}
+	if len(ran) != 3 {
+		t.Errorf("expected state to be run by the command, got %v", ran)
+	}
+}
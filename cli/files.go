@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// Parse parses logs from stdin, or local files, writing the events to Out as
+// JSON, one per line, e.g. for jq. Nothing is sent to Honeycomb.
+func (c *Command) Parse(paths []string) error {
+	return publisher.ParseFiles(c.out(), c.EventParser(), paths)
+}
+
+// IngestFile publishes logs from local files, or stdin, e.g. ones already
+// downloaded or exported from another system. It doesn't need AWS access, and
+// no state is kept.
+func (c *Command) IngestFile(paths []string) error {
+	c.RequireWriteKey()
+
+	filePublisher := publisher.NewHoneycombPublisher(c.Opt, nil, c.EventParser())
+	downloadsCh := make(chan state.DownloadedObject)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- logbucket.LocalFiles(paths, downloadsCh)
+	}()
+
+	publisher.PublishObjects(filePublisher, downloadsCh, c.Opt.ParseWorkers)
+	filePublisher.Drain()
+	probeErr := filePublisher.ReportDryRun()
+	if err := <-errCh; err != nil {
+		return err
+	}
+	return probeErr
+}
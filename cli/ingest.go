@@ -0,0 +1,356 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/daemon"
+	"github.com/honeycombio/honeyaws/leader"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/retry"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// Ingest is what an ingest's downloaders share: the state, the publisher the
+// objects they download are published with, and how the options have them
+// listed and downloaded.
+type Ingest struct {
+	Stater    state.Stater
+	Publisher *publisher.HoneycombPublisher
+	// TimeRange is the range of logs ingested once with --start-time, and
+	// nil when the buckets are polled.
+	TimeRange *logbucket.TimeRange
+	// Shard divides the buckets to list between the instances sharing the
+	// state with --shard.
+	Shard *logbucket.Shard
+	// Context is cancelled to stop downloading when shutting down.
+	Context context.Context
+	// Downloads is what the objects downloaded are published from.
+	Downloads chan state.DownloadedObject
+
+	c           *Command
+	cancel      func()
+	elector     *leader.Elector
+	leaseLost   <-chan struct{}
+	sqsListener *logbucket.SQSListener
+	workQueue   *logbucket.WorkQueue
+	schedule    *logbucket.Schedule
+	pool        *logbucket.DownloadPool
+	inventory   *logbucket.InventoryBackfill
+	replayer    state.Replayer
+	replay      state.Replay
+}
+
+// NewIngest sets up ingesting with the options. With --k8s-leader-election it
+// waits for the lease first, so that only the replica holding it ingests.
+func (c *Command) NewIngest(sess *session.Session, eventParser publisher.EventParser) *Ingest {
+	opt := c.Opt
+	c.RequireWriteKey()
+
+	in := &Ingest{c: c}
+	if opt.K8sLeaderElection {
+		leaseName := opt.K8sLeaseName
+		if leaseName == "" {
+			leaseName = c.Name
+		}
+		var err error
+		if in.elector, err = leader.NewInClusterElector(leaseName); err != nil {
+			logrus.WithField("error", err).Fatal("Could not set up --k8s-leader-election")
+		}
+		in.leaseLost = in.elector.Acquire()
+	}
+
+	in.setupState(sess)
+	if err := in.startReplay(sess); err != nil {
+		logrus.WithField("error", err).Fatal("Could not replay the --start-time range")
+	}
+	logrus.WithField("hours", time.Duration(opt.BackfillHr)*time.Hour).Debug("Backfill will be")
+
+	if opt.Shard && !opt.DryRun {
+		leaser, ok := in.Stater.(state.Leaser)
+		if !ok {
+			logrus.Fatal("--shard requires --highavail, so that the instances lease out the work in DynamoDB")
+		}
+		in.Shard = logbucket.NewShard(leaser, opt.ShardID, time.Duration(opt.ShardLease)*time.Second)
+		go in.Shard.Run()
+	}
+
+	in.Publisher = publisher.NewHoneycombPublisher(opt, in.Stater, eventParser)
+	if opt.DeadLetterPath != "" {
+		deadLetters, err := publisher.NewDeadLetterWriter(sess, opt.DeadLetterPath)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("Could not use --dead-letter-path")
+		}
+		in.Publisher.DeadLetters = deadLetters
+	}
+
+	in.Downloads = make(chan state.DownloadedObject)
+	in.Context, in.cancel = context.WithCancel(context.Background())
+
+	if opt.Role != "" && (opt.SQSQueueURL == "" || (!opt.HighAvail && opt.StateBackend == "")) {
+		logrus.Fatal("--role requires --sqs_queue_url for the work queue, and --highavail or --state_backend so that state is shared between listers and workers")
+	}
+	if opt.Role == logbucket.RoleLister {
+		in.workQueue = logbucket.NewWorkQueue(sess, opt.SQSQueueURL)
+	} else if opt.SQSQueueURL != "" {
+		in.sqsListener = logbucket.NewSQSListener(sess, opt.SQSQueueURL)
+	}
+
+	var err error
+	if in.schedule, err = logbucket.ParseSchedule(opt.BackfillPause); err != nil {
+		logrus.WithField("error", err).Fatal("Could not parse --backfill_pause")
+	}
+	in.pool = logbucket.NewDownloadPool(opt.DownloadWorkers)
+
+	if opt.InventoryManifest != "" {
+		in.inventory = logbucket.NewInventoryBackfill(sess, opt.InventoryManifest)
+		in.inventory.Schedule = in.schedule
+	}
+	return in
+}
+
+// setupState sets the time range of --start-time, and the stater: kept in
+// memory for --dry_run and time ranges which aren't backfilling, so that
+// nothing is written, and objects already ingested can be ingested again.
+func (in *Ingest) setupState(sess *session.Session) {
+	opt := in.c.Opt
+	if opt.BackfillHr < 1 || opt.BackfillHr > 168 {
+		logrus.WithField("hours", opt.BackfillHr).Fatal("--backfill requires an hour input between 1 and 168")
+	}
+
+	start, end, err := opt.TimeRange(time.Now())
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not use --start-time")
+	}
+	if !start.IsZero() {
+		in.TimeRange = logbucket.NewTimeRange(start, end)
+		// --mode=backfill shares the state with --mode=tail
+		in.TimeRange.Backfill = opt.Mode == options.ModeBackfill
+		in.TimeRange.Workers = opt.DownloadWorkers
+	}
+
+	if opt.DryRun || (in.TimeRange != nil && !in.TimeRange.Backfill) {
+		in.Stater = state.NewMemoryStater(opt.BackfillHr)
+	} else {
+		in.Stater = in.c.NewStater(sess)
+	}
+}
+
+// NewDownloader returns a downloader of the objects, set up as the options
+// have it, for Start.
+func (in *Ingest) NewDownloader(sess *session.Session, objDownloader logbucket.ObjectDownloader) *logbucket.Downloader {
+	opt := in.c.Opt
+	downloader := logbucket.NewDownloader(sess, in.Stater, objDownloader, opt.BackfillHr)
+	downloader.WorkQueue = in.workQueue
+	downloader.NoPolling = opt.Role == logbucket.RoleWorker
+	downloader.Schedule = in.schedule
+	downloader.GapScan = opt.GapScan
+	downloader.ListByHour = opt.ListByHour
+	downloader.Dedupe = opt.Dedupe
+	downloader.ExactlyOnce = opt.ExactlyOnce
+	downloader.DownloadToFile = opt.DownloadToFile
+	downloader.ProgressInterval = time.Duration(opt.ProgressInterval) * time.Second
+	downloader.Tail = opt.Mode == options.ModeTail
+	downloader.Retry = retry.New(opt.MaxRetries)
+	downloader.MissingRechecks = opt.MissingRechecks
+	downloader.MissingRecheckDelay = time.Duration(opt.RecheckDelay) * time.Second
+	downloader.KMSKeyARN = opt.KMSKeyARN
+	downloader.Context = in.Context
+	if in.Publisher.Markers != nil {
+		downloader.Milestones = in.Publisher.Markers
+	}
+	downloader.Pool = in.pool
+	downloader.Shard = in.Shard
+	downloader.TimeRange = in.TimeRange
+	return downloader
+}
+
+// Start starts the downloader's goroutines and returns, having added it to the
+// time range before it can be waited on.
+func (in *Ingest) Start(downloader *logbucket.Downloader) {
+	if in.sqsListener != nil {
+		in.sqsListener.Add(downloader)
+	}
+	if in.inventory != nil {
+		in.inventory.Add(downloader)
+	}
+	downloader.Download(in.Downloads)
+}
+
+// Reload reads the --config file again, for SIGHUP, and the sampling, field
+// and URL rules apply to every event from then on.
+func (in *Ingest) Reload() error {
+	return in.c.ReloadConfig(in.Publisher)
+}
+
+// Rediscover returns a reload for Run which reads the --config file again with
+// reloadConfig and then rediscovers what to ingest, which it also does every
+// --discover_interval. Without rediscover, e.g. when names were given to
+// ingest, it only reloads. There's nothing to reload when logs are ingested
+// once, with --start-time, so it returns nil.
+func (in *Ingest) Rediscover(reloadConfig func() error, rediscover func()) func() error {
+	if in.TimeRange != nil {
+		return nil
+	}
+	reloads := make(chan chan error)
+	go func() {
+		var tick <-chan time.Time
+		if in.c.Opt.DiscoverInterval > 0 && rediscover != nil {
+			tick = time.Tick(time.Duration(in.c.Opt.DiscoverInterval) * time.Second)
+		}
+		for {
+			select {
+			case <-tick:
+			case done := <-reloads:
+				err := reloadConfig()
+				done <- err
+				if err != nil {
+					continue
+				}
+			}
+			if rediscover != nil {
+				rediscover()
+			}
+		}
+	}()
+	return func() error {
+		done := make(chan error)
+		reloads <- done
+		return <-done
+	}
+}
+
+// Run publishes what the downloaders started download until interrupted or,
+// with --start-time, until every object in the range has been published. On
+// SIGHUP reload is called, unless the logs are ingested once.
+func (in *Ingest) Run(reload func() error) error {
+	opt := in.c.Opt
+	hp := in.Publisher
+	if in.sqsListener != nil {
+		go in.sqsListener.Listen()
+	}
+
+	if in.inventory != nil {
+		if hp.Markers != nil {
+			in.inventory.Milestones = hp.Markers
+		}
+		go func() {
+			if err := in.inventory.Run(); err != nil {
+				logrus.WithField("error", err).Error("Could not backfill from S3 Inventory")
+			}
+		}()
+	}
+
+	if in.TimeRange != nil {
+		reload = nil
+	}
+	if err := daemon.Start(opt.PIDFile, reload); err != nil {
+		logrus.WithField("error", err).Fatal("Could not write --pid-file")
+	}
+	defer daemon.Stop()
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signalCh:
+			if !opt.DryRun {
+				// Another interrupt exits without waiting
+				// any longer.
+				go func() {
+					<-signalCh
+					crash.Flush()
+					logrus.Warn("Exiting due to interrupt.")
+					os.Exit(1)
+				}()
+				logrus.WithField("drain_timeout", opt.DrainTimeout).Warn("Shutting down due to interrupt, finishing the objects being published.")
+				daemon.Stop()
+				in.cancel()
+				hp.Shutdown(time.Duration(opt.DrainTimeout) * time.Second)
+				in.elector.Release()
+				in.Shard.Release()
+				os.Exit(0)
+			}
+		case <-in.leaseLost:
+			// Another replica may be taking over, so objects
+			// being published are left to it to resume.
+			logrus.Error("Lost the leader lease, exiting so as not to ingest alongside the new leader.")
+			daemon.Stop()
+			in.cancel()
+			hp.FlushState()
+			crash.Flush()
+			os.Exit(1)
+		case <-hp.DryRunDone():
+		}
+		daemon.Stop()
+		if err := hp.ReportDryRun(); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+
+	if in.TimeRange != nil {
+		hp.ReplayMarkers.ReplayStarted(in.TimeRange.Start, in.TimeRange.End)
+		// Once every object in the range has been downloaded,
+		// publishing them finishes.
+		rangeErr := make(chan error, 1)
+		go func() {
+			rangeErr <- in.TimeRange.Wait()
+			close(in.Downloads)
+		}()
+		publisher.PublishObjects(hp, in.Downloads, opt.ParseWorkers)
+		hp.Drain()
+		probeErr := hp.ReportDryRun()
+		if err := <-rangeErr; err != nil {
+			return err
+		}
+		if probeErr != nil {
+			return probeErr
+		}
+		hp.ReplayMarkers.ReplayFinished(in.TimeRange.Start, in.TimeRange.End)
+		in.finishReplay()
+		return nil
+	}
+
+	publisher.PublishObjects(hp, in.Downloads, opt.ParseWorkers)
+	return nil
+}
+
+// IngestBucket ingests the log objects under --prefix in --bucket, for logs
+// delivered somewhere the tool can't look up, parsing them as --log_type, or
+// the tool's own logs without it.
+func (c *Command) IngestBucket() error {
+	opt := c.Opt
+	logType := opt.LogType
+	if logType == "" {
+		logType = c.BucketLogType
+	}
+	eventParser, err := publisher.NewEventParser(opt, logType)
+	if err != nil {
+		return fmt.Errorf("Could not use --log_type: %s", err)
+	}
+
+	sess, err := c.S3Session()
+	if err != nil {
+		return err
+	}
+	in := c.NewIngest(sess, eventParser)
+
+	logrus.WithFields(logrus.Fields{
+		"bucket":   opt.Bucket,
+		"prefix":   opt.Prefix,
+		"log_type": logType,
+	}).Info("Ingesting the log objects under the prefix")
+	in.Start(in.NewDownloader(sess, logbucket.NewPrefixDownloader(opt.Bucket, opt.Prefix)))
+	return in.Run(in.Reload)
+}
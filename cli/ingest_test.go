@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestSetupState(t *testing.T) {
+	c, _, cleanup := newFileCommand(t)
+	defer cleanup()
+
+	in := &Ingest{c: c}
+	in.setupState(nil)
+	if _, ok := in.Stater.(*state.FileStater); !ok || in.TimeRange != nil {
+		t.Errorf("expected the buckets to be polled, with the state kept, got %T, %v", in.Stater, in.TimeRange)
+	}
+
+	c.Opt.DryRun = true
+	in = &Ingest{c: c}
+	in.setupState(nil)
+	if _, ok := in.Stater.(*state.MemoryStater); !ok {
+		t.Errorf("expected --dry_run to keep the state in memory, got %T", in.Stater)
+	}
+
+	c.Opt.DryRun = false
+	c.Opt.StartTime = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	in = &Ingest{c: c}
+	in.setupState(nil)
+	if _, ok := in.Stater.(*state.MemoryStater); !ok || in.TimeRange == nil {
+		t.Errorf("expected --start-time to ingest the range again, with the state in memory, got %T, %v", in.Stater, in.TimeRange)
+	}
+
+	c.Opt.Mode = options.ModeBackfill
+	in = &Ingest{c: c}
+	in.setupState(nil)
+	if _, ok := in.Stater.(*state.FileStater); !ok || in.TimeRange == nil || !in.TimeRange.Backfill {
+		t.Errorf("expected --mode=backfill to share the state, got %T, %v", in.Stater, in.TimeRange)
+	}
+}
+
+func TestRediscover(t *testing.T) {
+	in := &Ingest{c: &Command{Opt: &options.Options{}}}
+
+	events := make(chan string, 10)
+	reloadErr := errors.New("bad --config")
+	reloads := 0
+	reload := in.Rediscover(func() error {
+		events <- "reload"
+		if reloads++; reloads == 1 {
+			return reloadErr
+		}
+		return nil
+	}, func() { events <- "rediscover" })
+
+	if err := reload(); err != reloadErr {
+		t.Errorf("expected the reload's error, got %v", err)
+	}
+	if err := reload(); err != nil {
+		t.Fatal(err)
+	}
+	// only a successful reload is followed by rediscovering
+	for _, expected := range []string{"reload", "reload", "rediscover"} {
+		select {
+		case event := <-events:
+			if event != expected {
+				t.Fatalf("expected to %s, got %s", expected, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected to %s", expected)
+		}
+	}
+
+	in.TimeRange = logbucket.NewTimeRange(time.Now().Add(-time.Hour), time.Now())
+	if in.Rediscover(func() error { return nil }, nil) != nil {
+		t.Error("expected nothing to reload when ingesting a time range once")
+	}
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"github.com/honeycombio/honeyaws/meta"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadConfig reads the --config file again, for SIGHUP. The publisher's
+// sampling, field and URL rules apply from then on, and changes to any other
+// options are logged as needing a restart.
+func (c *Command) ReloadConfig(hp *publisher.HoneycombPublisher) error {
+	reloaded, changed, err := options.ReloadConfig(c.Parser, c.Opt)
+	if err != nil {
+		return err
+	}
+	restart, err := hp.ReloadConfig(c.Opt, reloaded, changed)
+	if err != nil {
+		return err
+	}
+	logReloaded(changed, restart)
+	return nil
+}
+
+// ReloadLBConfig is ReloadConfig for the load balancer tools: the load
+// balancer overrides and tag filters apply from then on too, the tag filters
+// being set in tagFilters for rediscovering load balancers with.
+func (c *Command) ReloadLBConfig(hp *publisher.HoneycombPublisher, tagFilters *map[string]string) error {
+	reloaded, changed, err := options.ReloadConfig(c.Parser, c.Opt)
+	if err != nil {
+		return err
+	}
+	filters, err := meta.ParseTagFilters(reloaded.TagFilters)
+	if err != nil {
+		return err
+	}
+	unapplied, err := hp.ReloadConfig(c.Opt, reloaded, changed)
+	if err != nil {
+		return err
+	}
+	c.Opt.LBConfigs = reloaded.LBConfigs
+	c.Opt.TagFilters = reloaded.TagFilters
+	*tagFilters = filters
+
+	var restart []string
+	for _, name := range unapplied {
+		if name != "load_balancers" && name != "tag_filter" {
+			restart = append(restart, name)
+		}
+	}
+	logReloaded(changed, restart)
+	return nil
+}
+
+func logReloaded(changed, restart []string) {
+	logger := logrus.WithField("changed", changed)
+	if len(restart) > 0 {
+		logger.WithField("needs_restart", restart).Warn("Reloaded --config, but some of the changes only take effect on restart")
+		return
+	}
+	logger.Info("Reloaded --config")
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// Replay publishes what was recorded as dead letters again, sampled and shaped
+// as configured now: replay lines the lines written out to --dead-letter-path
+// which couldn't be parsed, and replay objects the objects in --bucket which
+// couldn't be downloaded, just those of --lb from --since on if they're set.
+func (c *Command) Replay(args []string) error {
+	opt := c.Opt
+	if len(args) != 1 || (args[0] != "lines" && args[0] != "objects") {
+		return fmt.Errorf("Usage: %s [--flags] replay lines|objects", os.Args[0])
+	}
+	if args[0] == "lines" && opt.DeadLetterPath == "" {
+		return fmt.Errorf("replay lines requires --dead-letter-path")
+	}
+	if args[0] == "objects" && opt.Bucket == "" {
+		return fmt.Errorf("replay objects requires --bucket, the bucket the objects are in")
+	}
+	c.RequireWriteKey()
+	since, err := opt.Since()
+	if err != nil {
+		return err
+	}
+
+	sess := Session()
+	logbucket.NewS3Limiter(opt.S3MaxConcurrency, opt.S3RequestRate).Add(sess)
+	if opt.RequesterPays {
+		logbucket.AddRequesterPays(sess)
+	}
+	var stater state.Stater
+	if args[0] == "objects" {
+		stater = c.NewStater(sess)
+	}
+	replayPublisher := publisher.NewHoneycombPublisher(opt, stater, c.EventParser())
+	if opt.DeadLetterPath != "" {
+		deadLetters, err := publisher.NewDeadLetterWriter(sess, opt.DeadLetterPath)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("Could not use --dead-letter-path")
+		}
+		replayPublisher.DeadLetters = deadLetters
+	}
+
+	var replayed, failed int
+	if args[0] == "lines" {
+		replayed, failed, err = publisher.ReplayLines(replayPublisher, sess, opt.DeadLetterPath, opt.ParseWorkers)
+	} else if deadLetterer, ok := stater.(state.DeadLetterer); !ok {
+		err = fmt.Errorf("The state backend doesn't keep dead letters")
+	} else {
+		replayed, failed, err = publisher.ReplayObjects(replayPublisher, deadLetterer, state.NewMatcher(opt.StateLB, since), func(object string) (state.DownloadedObject, error) {
+			return logbucket.DownloadObject(sess, opt.Bucket, object)
+		}, opt.ParseWorkers)
+	}
+	replayPublisher.Drain()
+	if probeErr := replayPublisher.ReportDryRun(); err == nil {
+		err = probeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out(), "Replayed %d dead letters, %d of them failed again\n", replayed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d dead letters could not be replayed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// replayScope is what a --start-time range is a replay of: the tool's logs,
+// those of the names given (or all of them), or of --bucket, and where their
+// events go.
+func (c *Command) replayScope() string {
+	opt := c.Opt
+	names := "all"
+	if len(c.names) > 0 {
+		sorted := append([]string(nil), c.names...)
+		sort.Strings(sorted)
+		names = strings.Join(sorted, ",")
+	}
+	if opt.Bucket != "" {
+		names = "s3://" + opt.Bucket + "/" + opt.Prefix
+	}
+	return fmt.Sprintf("%s %s to %s", c.Name, names, opt.Dataset)
+}
+
+// replayID is the same for the same range of the same scope, e.g.
+// honeyalb-20180820T1400Z-20180820T1600Z-3f9a1c2e, so the events of a range
+// replayed again with --force are tagged alike.
+func replayID(name, scope string, start, end time.Time) string {
+	sum := sha256.Sum256([]byte(scope))
+	return fmt.Sprintf("%s-%s-%s-%s", name, start.UTC().Format("20060102T1504Z"), end.UTC().Format("20060102T1504Z"), hex.EncodeToString(sum[:4]))
+}
+
+// startReplay tags the events of a --start-time range with its replay ID, and
+// records the replay in the state, unless a replay of the same scope which
+// overlaps it was recorded already, which --force replays anyway. Nothing is
+// recorded with --dry_run.
+func (in *Ingest) startReplay(sess *session.Session) error {
+	opt := in.c.Opt
+	if in.TimeRange == nil || in.TimeRange.Backfill {
+		return nil
+	}
+	scope := in.c.replayScope()
+	opt.ReplayID = replayID(in.c.Name, scope, in.TimeRange.Start, in.TimeRange.End)
+	logger := logrus.WithFields(logrus.Fields{
+		"replay_id": opt.ReplayID,
+		"start":     in.TimeRange.Start.Format(time.RFC3339),
+		"end":       in.TimeRange.End.Format(time.RFC3339),
+	})
+	if opt.DryRun {
+		logger.Info("Replaying the range")
+		return nil
+	}
+
+	replayer, ok := in.c.NewStater(sess).(state.Replayer)
+	if !ok {
+		logger.Warn("The state backend doesn't keep replays, so replaying the range again won't be refused")
+		return nil
+	}
+	replays, err := replayer.Replays()
+	if err != nil {
+		return fmt.Errorf("Could not read the ranges replayed before: %s", err)
+	}
+	if id, r, ok := overlappingReplay(replays, scope, in.TimeRange.Start, in.TimeRange.End); ok && !opt.Force {
+		finished := "didn't finish"
+		if !r.Finished.IsZero() {
+			finished = "finished at " + r.Finished.Format(time.RFC3339)
+		}
+		return fmt.Errorf("The range overlaps replay %s of %s to %s, which started at %s and %s. Give --force to replay it anyway, sending its events to Honeycomb again.",
+			id, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Started.Format(time.RFC3339), finished)
+	}
+
+	in.replay = state.Replay{Scope: scope, Start: in.TimeRange.Start, End: in.TimeRange.End, Started: time.Now()}
+	if err := replayer.SetReplay(opt.ReplayID, in.replay); err != nil {
+		return fmt.Errorf("Could not record the replay: %s", err)
+	}
+	in.replayer = replayer
+	logger.Info("Replaying the range")
+	return nil
+}
+
+// finishReplay records the replay having finished, once the range has been
+// published.
+func (in *Ingest) finishReplay() {
+	if in.replayer == nil {
+		return
+	}
+	in.replay.Finished = time.Now()
+	if err := in.replayer.SetReplay(in.c.Opt.ReplayID, in.replay); err != nil {
+		logrus.WithField("error", err).Error("Could not record the replay having finished")
+	}
+}
+
+// overlappingReplay returns a replay of the scope overlapping the range, if
+// there is one.
+func overlappingReplay(replays map[string]state.Replay, scope string, start, end time.Time) (string, state.Replay, bool) {
+	ids := make([]string, 0, len(replays))
+	for id := range replays {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		r := replays[id]
+		if r.Scope == scope && r.Start.Before(end) && start.Before(r.End) {
+			return id, r, true
+		}
+	}
+	return "", state.Replay{}, false
+}
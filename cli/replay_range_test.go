@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestStartReplay(t *testing.T) {
+	c, _, cleanup := newFileCommand(t)
+	defer cleanup()
+	c.Opt.Dataset = "aws-elb-access"
+	c.Opt.StartTime = "2018-08-20T14:00:00Z"
+	c.Opt.EndTime = "2018-08-20T16:00:00Z"
+	c.names = []string{"lb-b", "lb-a"}
+
+	replay := func() (*Ingest, error) {
+		c.Opt.ReplayID = ""
+		in := &Ingest{c: c}
+		in.setupState(nil)
+		return in, in.startReplay(nil)
+	}
+	in, err := replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := c.Opt.ReplayID
+	if !strings.HasPrefix(id, "honeyelb-20180820T1400Z-20180820T1600Z-") {
+		t.Errorf("expected the replay ID to be of the range, got %s", id)
+	}
+	in.finishReplay()
+	replays, err := state.NewFileStater(c.Opt.StateDir, c.Service, 1).Replays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := replays[id]; r.Scope != "honeyelb lb-a,lb-b to aws-elb-access" || r.Finished.IsZero() {
+		t.Errorf("expected the finished replay to be recorded, got %+v", replays)
+	}
+
+	// the same load balancers given in another order are the same replay
+	c.names = []string{"lb-a", "lb-b"}
+	c.Opt.StartTime = "2018-08-20T15:00:00Z"
+	c.Opt.EndTime = "2018-08-20T17:00:00Z"
+	if _, err := replay(); err == nil || !strings.Contains(err.Error(), id) {
+		t.Errorf("expected replaying an overlapping range to be refused, got %v", err)
+	}
+
+	c.Opt.Force = true
+	if _, err := replay(); err != nil {
+		t.Errorf("expected --force to replay the range anyway, got %v", err)
+	}
+	c.Opt.Force = false
+
+	c.Opt.StartTime = "2018-08-20T16:00:00Z"
+	c.names = []string{"lb-c"}
+	if _, err := replay(); err != nil {
+		t.Errorf("expected another load balancer's range to be replayed, got %v", err)
+	}
+	c.names = nil
+	c.Opt.StartTime = "2018-08-20T18:00:00Z"
+	c.Opt.EndTime = "2018-08-20T19:00:00Z"
+	if _, err := replay(); err != nil {
+		t.Errorf("expected a range after the others to be replayed, got %v", err)
+	}
+
+	c.Opt.DryRun = true
+	if _, err := replay(); err != nil || c.Opt.ReplayID == "" {
+		t.Errorf("expected --dry_run to tag the events without checking the state, got %q (%v)", c.Opt.ReplayID, err)
+	}
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/meta"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// NewStater returns the stater ingest state is tracked with: --state_backend,
+// DynamoDB with --highavail, or the local file system.
+func (c *Command) NewStater(sess *session.Session) state.Stater {
+	opt := c.Opt
+	if opt.StateBackend != "" {
+		stater, err := state.NewBackendStater(opt.StateBackend, c.Service, opt.BackfillHr)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("--state_backend could not be used for state tracking")
+		}
+		logrus.Info("State tracking with high availability enabled - using --state_backend")
+		return stater
+	}
+
+	if opt.HighAvail {
+		if opt.CreateTable {
+			tags, err := meta.ParseAWSTags(opt.AWSTags)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("Could not use --aws_tags")
+			}
+			if err := state.CreateDynamoDBTable(sess, opt.DynamoTable, tags); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"tableName": opt.DynamoTable,
+					"error":     err,
+				}).Fatal("Could not create the DynamoDB table")
+			}
+		}
+		stater, err := state.NewDynamoDBStater(sess, opt.DynamoTable, opt.BackfillHr)
+		if err != nil {
+			logrus.WithField("tableName", opt.DynamoTable).Fatal("--highavail requires an existing DynamoDB table named appropriately (or --create_table), please refer to the README.")
+		}
+		logrus.Info("State tracking with high availability enabled - using DynamoDB")
+		return stater
+	}
+
+	logrus.Info("State tracking enabled - using local file system.")
+	return state.NewFileStater(opt.StateDir, c.Service, opt.BackfillHr)
+}
+
+// Status prints how far backfilling each load balancer (or distribution, or
+// trail) had got when its progress was last recorded in the state, by an
+// ingest reporting it with --progress_interval.
+func (c *Command) Status() error {
+	fmt.Fprintln(c.out(), "NAME\tPROCESSED\tREMAINING\tOLDEST UNPROCESSED\tETA\tUPDATED")
+	return state.PrintProgress(c.NewStater(Session()), c.out())
+}
+
+// State manages ingest state: state cleanup deletes the state recorded before
+// the retention window, which ingesting no longer needs, state dead-letters
+// lists the objects which couldn't be downloaded, state missing those which
+// weren't found, state list and state show <object> what's recorded of the
+// objects, and state reset forgets those of --lb from --since on, so that
+// they're processed again.
+func (c *Command) State(args []string) error {
+	opt := c.Opt
+	argCounts := map[string]int{"cleanup": 1, "dead-letters": 1, "missing": 1, "list": 1, "show": 2, "reset": 1}
+	if len(args) == 0 || argCounts[args[0]] != len(args) {
+		return fmt.Errorf("Usage: %s [--flags] state cleanup|dead-letters|missing|list|show <object>|reset --lb=<name> --since=<time>", os.Args[0])
+	}
+	if args[0] == "reset" && (opt.StateLB == "" || opt.StateSince == "") {
+		return fmt.Errorf("state reset requires --lb and --since")
+	}
+	since, err := opt.Since()
+	if err != nil {
+		return err
+	}
+
+	stater := c.NewStater(Session())
+	switch args[0] {
+	case "dead-letters":
+		return state.PrintDeadLetters(stater, c.out())
+	case "missing":
+		return state.PrintMissing(stater, c.out())
+	case "list":
+		return state.PrintObjects(stater, c.out(), state.NewMatcher(opt.StateLB, since))
+	case "show":
+		return state.PrintObject(stater, c.out(), args[1])
+	case "reset":
+		resetter, ok := stater.(state.Resetter)
+		if !ok {
+			return fmt.Errorf("The state backend doesn't support reset")
+		}
+		reset, err := resetter.Reset(state.NewMatcher(opt.StateLB, since))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out(), "Reset %d state entries of %s since %s\n", reset, opt.StateLB, since.Format(time.RFC3339))
+		return nil
+	}
+	cleaner, ok := stater.(state.Cleaner)
+	if !ok {
+		return fmt.Errorf("The state backend doesn't support cleanup")
+	}
+
+	retention := time.Duration(opt.BackfillHr) * time.Hour
+	if opt.RetentionHr > 0 {
+		retention = time.Duration(opt.RetentionHr) * time.Hour
+	}
+	deleted, err := cleaner.Cleanup(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out(), "Deleted %d state entries older than %s\n", deleted, retention)
+	return nil
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// newFileCommand returns a command keeping its state in a temporary
+// --statedir, which the returned func removes.
+func newFileCommand(t *testing.T) (*Command, *bytes.Buffer, func()) {
+	dir, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	c := &Command{
+		Name:    "honeyelb",
+		Service: logbucket.AWSElasticLoadBalancing,
+		Opt:     &options.Options{StateDir: dir, BackfillHr: 1},
+		Out:     out,
+	}
+	return c, out, func() { os.RemoveAll(dir) }
+}
+
+func TestNewStaterFile(t *testing.T) {
+	c, _, cleanup := newFileCommand(t)
+	defer cleanup()
+
+	f, ok := c.NewStater(nil).(*state.FileStater)
+	if !ok {
+		t.Fatal("expected the local file system to be used without --highavail")
+	}
+	if f.StateDir != c.Opt.StateDir || f.Service != logbucket.AWSElasticLoadBalancing {
+		t.Errorf("expected the state to be kept in the --statedir under the tool's service, got %s, %s", f.StateDir, f.Service)
+	}
+}
+
+func TestStateUsage(t *testing.T) {
+	c, _, cleanup := newFileCommand(t)
+	defer cleanup()
+
+	for _, args := range [][]string{nil, {"nope"}, {"list", "extra"}, {"show"}} {
+		if err := c.State(args); err == nil || !strings.Contains(err.Error(), "Usage:") {
+			t.Errorf("expected usage for %v, got %v", args, err)
+		}
+	}
+	if err := c.State([]string{"reset"}); err == nil || !strings.Contains(err.Error(), "--lb and --since") {
+		t.Errorf("expected reset to require --lb and --since, got %v", err)
+	}
+	c.Opt.StateLB, c.Opt.StateSince = "my-lb", "yesterday"
+	if err := c.State([]string{"reset"}); err == nil || !strings.Contains(err.Error(), "RFC3339") {
+		t.Errorf("expected an invalid --since to be an error, got %v", err)
+	}
+}
+
+func TestStateList(t *testing.T) {
+	c, out, cleanup := newFileCommand(t)
+	defer cleanup()
+
+	object := "AWSLogs/123/elasticloadbalancing/us-east-1/2018/08/20/123_elasticloadbalancing_us-east-1_my-lb_20180820T1200Z_10.0.0.1_abcd.log"
+	if err := state.NewFileStater(c.Opt.StateDir, c.Service, 1).SetProcessed(object); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.State([]string{"list"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), object) {
+		t.Errorf("expected the processed object to be listed, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := c.State([]string{"cleanup"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Deleted 0 state entries older than 1h0m0s") {
+		t.Errorf("expected the object within the retention window to be kept, got %q", out.String())
+	}
+}
+
+func TestReplayUsage(t *testing.T) {
+	c, _, cleanup := newFileCommand(t)
+	defer cleanup()
+
+	for args, expected := range map[string]string{
+		"":        "Usage:",
+		"again":   "Usage:",
+		"lines":   "--dead-letter-path",
+		"objects": "--bucket",
+	} {
+		if err := c.Replay(strings.Fields(args)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected replay %q to be an error mentioning %s, got %v", args, expected, err)
+		}
+	}
+}
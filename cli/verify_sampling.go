@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/verify"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// verifyDelay is how far before now the window verify-sampling checks ends, so
+// that the logs for it have been delivered and ingested.
+const verifyDelay = 15 * time.Minute
+
+// SampledLB is a load balancer whose logs verify-sampling counts the events
+// of, told apart from the other load balancers' events by the Filter, both in
+// the logs and in Honeycomb.
+type SampledLB struct {
+	Name string
+	// Filter is e.g. {"elb", "=", "my-lb"}, with "=" or "contains".
+	Filter verify.Filter
+	Logs   []SampledLogs
+}
+
+// SampledLogs is where a load balancer's access logs are in one region.
+type SampledLogs struct {
+	Sess             *session.Session
+	ObjectDownloader logbucket.ObjectDownloader
+}
+
+// matches returns whether the event parsed from the logs is one the filter
+// has Honeycomb count.
+func (lb SampledLB) matches(ev event.Event) bool {
+	value, _ := ev.Data[lb.Filter.Column].(string)
+	want := fmt.Sprint(lb.Filter.Value)
+	if lb.Filter.Op == "contains" {
+		return strings.Contains(value, want)
+	}
+	return value == want
+}
+
+// VerifySampling counts the events in the load balancers' logs for the
+// --window, sampling them with the parser as ingest would, and compares that
+// with the COUNT Honeycomb has of them, weighted by their sample rates.
+func (c *Command) VerifySampling(ep publisher.EventParser, lbs []SampledLB) error {
+	opt := c.Opt
+	if opt.QueryKey == "" {
+		return fmt.Errorf("--query_key must be set to a Honeycomb API key with permission to run queries")
+	}
+	window, err := time.ParseDuration(opt.VerifyWindow)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("--window must be a positive duration, e.g. 1h, got %q", opt.VerifyWindow)
+	}
+	datasets, err := publisher.ParseDatasetMap(opt.DatasetMap)
+	if err != nil {
+		return err
+	}
+
+	end := time.Now().Add(-verifyDelay).Truncate(time.Minute)
+	start := end.Add(-window)
+	logrus.WithFields(logrus.Fields{
+		"start": start.Format(time.RFC3339),
+		"end":   end.Format(time.RFC3339),
+	}).Info("Verifying sampling")
+
+	tally := &verify.Tally{}
+	parsedCh := make(chan event.Event)
+	sampledCh := make(chan event.Event)
+	go func() {
+		ep.DynSample(parsedCh, sampledCh)
+		close(sampledCh)
+	}()
+	sampled := make(chan struct{})
+	go func() {
+		for ev := range sampledCh {
+			tally.Keep(ev.SampleRate)
+		}
+		close(sampled)
+	}()
+
+	filters := make(map[string][]verify.Filter)
+	err = func() error {
+		defer close(parsedCh)
+		for _, lb := range lbs {
+			dataset := opt.Dataset
+			if d, ok := datasets[lb.Name]; ok {
+				dataset = d
+			}
+			filters[dataset] = append(filters[dataset], lb.Filter)
+
+			for _, logs := range lb.Logs {
+				objs, err := logbucket.ListWindow(logs.Sess, logs.ObjectDownloader, start, end)
+				if err != nil {
+					return err
+				}
+				for _, obj := range objs {
+					if err := countObject(lb, logs, ep, *obj.Key, start, end, tally, parsedCh); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}()
+	<-sampled
+	if err != nil {
+		return err
+	}
+
+	var honeycomb float64
+	client := verify.NewQueryClient(opt.APIHost, opt.QueryKey)
+	for dataset, datasetFilters := range filters {
+		count, err := client.Count(dataset, datasetFilters, start, end)
+		if err != nil {
+			return fmt.Errorf("querying %s: %s", dataset, err)
+		}
+		honeycomb += count
+	}
+
+	report := verify.Compare(tally, honeycomb)
+	report.Print(c.out())
+	if report.Drifted() {
+		return fmt.Errorf("Honeycomb's count is further from the logs' than sampling accounts for")
+	}
+	return nil
+}
+
+// countObject parses the load balancer's events in the window from the object,
+// counting them and handing them on to be sampled.
+func countObject(lb SampledLB, logs SampledLogs, ep publisher.EventParser, key string, start, end time.Time, tally *verify.Tally, parsedCh chan<- event.Event) error {
+	obj, err := logbucket.DownloadObject(logs.Sess, logs.ObjectDownloader.Bucket(), key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(obj.Filename)
+
+	evCh := make(chan event.Event)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ep.ParseEvents(obj, evCh)
+		close(evCh)
+	}()
+	for ev := range evCh {
+		if ev.Timestamp.Before(start) || !ev.Timestamp.Before(end) || !lb.matches(ev) {
+			continue
+		}
+		tally.Parse()
+		parsedCh <- ev
+	}
+	return <-errCh
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/verify"
+)
+
+func TestVerifySamplingELB(t *testing.T) {
+	// one object in the window, with the events of another load balancer
+	// in it too
+	at := time.Now().Add(-verifyDelay - 10*time.Minute).UTC()
+	key := fmt.Sprintf("AWSLogs/123456789012/elasticloadbalancing/us-east-1/%s/123456789012_elasticloadbalancing_us-east-1_my-lb_%s_10.0.0.1_abc.log",
+		at.Format("2006/01/02"), at.Truncate(5*time.Minute).Format("20060102T1504Z"))
+	var line bytes.Buffer
+	for _, lbName := range []string{"my-lb", "my-lb", "other-lb", "my-lb"} {
+		fmt.Fprintf(&line, "%s %s 192.168.131.39:2817 10.0.0.1:80 0.001069 0.000028 0.000041 200 200 82 305 \"GET http://example.com:80/ HTTP/1.1\" \"curl/7.38.0\" - -\n",
+			at.Format("2006-01-02T15:04:05.000000Z"), lbName)
+	}
+	s3srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("list-type") == "2" {
+			fmt.Fprintf(w, `<ListBucketResult><Name>logs</Name><KeyCount>1</KeyCount><IsTruncated>false</IsTruncated><Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents></ListBucketResult>`,
+				key, at.Format(time.RFC3339), line.Len())
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", line.Len()-1, line.Len()))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(line.Bytes())
+	}))
+	defer s3srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(s3srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+
+	honeycombCount := 3
+	var query struct {
+		Filters []verify.Filter `json:"filters"`
+	}
+	hnysrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1/queries/aws-elb-access":
+			json.NewDecoder(r.Body).Decode(&query)
+			w.Write([]byte(`{"id":"q1"}`))
+		case "/1/query_results/aws-elb-access":
+			fmt.Fprintf(w, `{"id":"r1","complete":true,"data":{"results":[{"data":{"COUNT":%d}}]}}`, honeycombCount)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer hnysrv.Close()
+
+	out := &bytes.Buffer{}
+	c := &Command{
+		Opt: &options.Options{
+			Dataset:      "aws-elb-access",
+			APIHost:      hnysrv.URL,
+			QueryKey:     "querykey",
+			VerifyWindow: "1h",
+			SampleRate:   1,
+			SamplerType:  "simple",
+		},
+		Out: out,
+	}
+	lbs := []SampledLB{{
+		Name:   "my-lb",
+		Filter: verify.Filter{Column: "elb", Op: "=", Value: "my-lb"},
+		Logs: []SampledLogs{{
+			Sess:             sess,
+			ObjectDownloader: &logbucket.ELBDownloader{AccountID: "123456789012", Region: "us-east-1", BucketName: "logs", LBName: "my-lb"},
+		}},
+	}}
+	if err := c.VerifySampling(publisher.NewELBEventParser(c.Opt), lbs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Parsed from logs:\t3\n") {
+		t.Errorf("expected only my-lb's events to be counted, got\n%s", out)
+	}
+	if len(query.Filters) != 1 || query.Filters[0] != lbs[0].Filter {
+		t.Errorf("expected Honeycomb to count my-lb's events, got %+v", query.Filters)
+	}
+
+	honeycombCount = 30
+	if err := c.VerifySampling(publisher.NewELBEventParser(c.Opt), lbs); err == nil {
+		t.Error("expected Honeycomb having ten times the logs' events to be drift")
+	}
+}
@@ -4,22 +4,28 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"time"
+	"syscall"
 
-	"github.com/sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/honeycombio/honeyaws/bootstrap"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/generate"
 	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/meta"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/publisher"
 	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeyaws/verify"
 	libhoney "github.com/honeycombio/libhoney-go"
-	flag "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	opt        = &options.Options{}
+	tool       *cli.Command
 	BuildID    string
 	versionStr string
 )
@@ -37,16 +43,24 @@ func init() {
 }
 
 func cmdALB(args []string) error {
-	// TODO: Would be nice to have this more highly configurable.
-	//
-	// Will just use environment config right now, e.g., default profile.
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
 
-	elbSvc := elbv2.New(sess, nil)
+	regions := meta.Regions(opt.Regions, opt.AllRegions, "elasticloadbalancing")
+	sessions := meta.RegionSessions(meta.AssumeRoleSessions(sess, opt.AssumeRoleARNs, meta.SessionName(versionStr)), regions)
 
-	describeLBResp, err := elbSvc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{})
+	tagFilters, err := meta.ParseTagFilters(opt.TagFilters)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "verify" {
+		// a load balancer which can't be described is reported, along
+		// with the other checks, rather than stopping there
+		return cmdVerify(sess, sessions, tagFilters, args[1:])
+	}
+	allLBNames, lbSessions, err := describeLoadBalancers(sessions, tagFilters)
 	if err != nil {
 		return err
 	}
@@ -54,176 +68,718 @@ func cmdALB(args []string) error {
 	if len(args) > 0 {
 		switch args[0] {
 		case "ls", "list":
-			for _, lb := range describeLBResp.LoadBalancers {
-				fmt.Println(*lb.LoadBalancerName)
+			var listings []meta.Listing
+			for _, lbName := range allLBNames {
+				lbSessList := lbSessions[lbName]
+				if len(regions) == 0 && opt.ListOutput == "" {
+					// just the name, once
+					lbSessList = lbSessList[:1]
+				}
+				for _, lbSess := range lbSessList {
+					listing := meta.Listing{Name: lbName}
+					if opt.ListOutput != "" {
+						var err error
+						if listing, err = meta.ELBV2Listing(lbSess, lbName); err != nil {
+							listing.Error = err.Error()
+						}
+					}
+					if len(regions) > 0 {
+						listing.Region = *lbSess.Config.Region
+					}
+					listings = append(listings, listing)
+				}
 			}
 
+			return meta.PrintListings(os.Stdout, opt.ListOutput, listings)
+
+		case "validate":
+			lbNames := args[1:]
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
+			}
+
+			failed := 0
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					return fmt.Errorf("ALB %q not found", lbName)
+				}
+				for _, lbSess := range lbSessList {
+					if err := validateLB(lbSess, lbName); err != nil {
+						fmt.Printf("%s\t%s\t%s\n", lbName, *lbSess.Config.Region, err)
+						failed++
+						continue
+					}
+					fmt.Printf("%s\t%s\tok\n", lbName, *lbSess.Config.Region)
+				}
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of the load balancers' access logs may not be delivered", failed)
+			}
 			return nil
 
-		case "ingest":
-			if opt.WriteKey == "" {
-				logrus.Fatal(`--writekey must be set to the proper write key for the Honeycomb team.
-Your write key is available at https://ui.honeycomb.io/account`)
+		case "bootstrap":
+			lbNames := args[1:]
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
 			}
+			return cmdBootstrap(sess, sessions, tagFilters, lbNames, lbSessions)
+
+		case "enable-logging":
+			lbNames := args[1:]
+			if len(lbNames) == 0 || opt.LogBucket == "" {
+				return fmt.Errorf("Usage: %s --log_bucket=<bucket> [--log_prefix=<prefix>] [--create_bucket] enable-logging <lb-name>...", os.Args[0])
+			}
+
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					return fmt.Errorf("ALB %q not found", lbName)
+				}
+				for _, lbSess := range lbSessList {
+					if err := enableLogging(lbSess, lbName); err != nil {
+						return fmt.Errorf("Could not enable access logs for ALB %q in %s: %s", lbName, *lbSess.Config.Region, err)
+					}
+					fmt.Printf("%s\t%s\tenabled\n", lbName, *lbSess.Config.Region)
+				}
+			}
+			return nil
+
+		case "verify-sampling":
+			lbNames := args[1:]
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
+			}
+			return cmdVerifySampling(lbNames, lbSessions)
+
+		case "ingest":
+			in := tool.NewIngest(sess, publisher.NewALBEventParser(opt))
 
 			lbNames := args[1:]
 
 			// Use all available load balancers by default if none
 			// are provided.
 			if len(lbNames) == 0 {
-				for _, lb := range describeLBResp.LoadBalancers {
-					lbNames = append(lbNames, *lb.LoadBalancerName)
+				lbNames = allLBNames
+			}
+
+			// With --classic-elbs classic load balancers are
+			// ingested too, their logs told apart from the ALBs'
+			// by the publisher.
+			var classicNames []string
+			classicSessions := make(map[string][]*session.Session)
+			if opt.ClassicELBs {
+				if classicNames, classicSessions, err = describeClassicLoadBalancers(sessions, tagFilters); err != nil {
+					return err
 				}
 			}
 
-			var stater state.Stater
+			var targetEnricher *publisher.TargetEnricher
+			if opt.EnrichTargets {
+				targetEnricher = publisher.NewTargetEnricher()
+				in.Publisher.Enricher = targetEnricher
+			}
+			var authEnricher *publisher.AuthEnricher
+			if opt.EnrichAuth {
+				authEnricher = publisher.NewAuthEnricher()
+				in.Publisher.AuthEnricher = authEnricher
+			}
 
-			if opt.BackfillHr < 1 || opt.BackfillHr > 168 {
-				logrus.WithField("hours", opt.BackfillHr).Fatal("--backfill requires an hour input between 1 and 168")
+			// lbTarget is a load balancer in one of the regions
+			// or accounts, classic with --classic-elbs, since
+			// classic load balancers and ALBs can share names.
+			type lbTarget struct {
+				name    string
+				sess    *session.Session
+				classic bool
 			}
 
-			if opt.HighAvail {
-				stater, err = state.NewDynamoDBStater(sess, opt.BackfillHr)
+			// ingestLB starts downloading the logs of a load
+			// balancer in the background, returning its
+			// downloaders: one for its access logs, and with
+			// --connection-logs one for its connection logs.
+			ingestLB := func(target lbTarget) ([]*logbucket.Downloader, error) {
+				lbName, lbSess := target.name, target.sess
+				kind, service, lookup := "ALB", logbucket.AWSElasticLoadBalancingV2, accessLogBucket
+				docs := "http://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#enable-access-logging"
+				if target.classic {
+					kind, service, lookup = "classic ELB", logbucket.AWSElasticLoadBalancing, meta.ELBAccessLogs
+					docs = "https://docs.aws.amazon.com/elasticloadbalancing/latest/classic/enable-access-logs.html"
+				}
+				logrus.WithFields(logrus.Fields{
+					"lbName": lbName,
+				}).Info("Attempting to ingest " + kind)
+
+				bucketName, bucketPrefix, enabled, err := lookup(lbSess, lbName)
 				if err != nil {
-					logrus.WithField("tableName", state.DynamoTableName).Fatal("--highavail requires an existing DynamoDB table named appropriately, please refer to the README.")
+					return nil, err
 				}
-				logrus.Info("State tracking with high availability enabled - using DynamoDB")
-			} else {
-				stater = state.NewFileStater(opt.StateDir, logbucket.AWSElasticLoadBalancingV2, opt.BackfillHr)
-				logrus.Info("State tracking enabled - using local file system.")
-			}
-			logrus.WithField("hours", time.Duration(opt.BackfillHr)*time.Hour).Debug("Backfill will be")
 
-			defaultPublisher := publisher.NewHoneycombPublisher(opt, stater, publisher.NewALBEventParser(opt))
-			downloadsCh := make(chan state.DownloadedObject)
+				if !enabled {
+					return nil, fmt.Errorf(`Access logs are not configured for %s %q. Please enable them to use the ingest tool.
 
-			// For now, just run one goroutine per-LB
-			for _, lbName := range lbNames {
+For reference see this link:
+
+%s`, kind, lbName, docs)
+				}
 				logrus.WithFields(logrus.Fields{
+					"bucket": bucketName,
 					"lbName": lbName,
-				}).Info("Attempting to ingest ALB")
+				}).Info("Access logs are enabled for " + kind + " ♥")
+
+				if opt.CheckPolicy {
+					logDelivery := logbucket.NewLogDelivery(lbSess, service, bucketName, bucketPrefix)
+					if err := logDelivery.Validate(opt.FixPolicy); err != nil {
+						logrus.WithFields(logrus.Fields{
+							"lbName": lbName,
+							"error":  err,
+						}).Error("Access logs may not be delivered")
+					}
+				}
 
-				elbSvc := elbv2.New(sess, nil)
+				albDownloader := logbucket.NewALBDownloader(lbSess, bucketName, bucketPrefix, lbName)
+				// the hours of its logs are listed by its ID
+				if opt.ListByHour && !target.classic {
+					if albDownloader.LBID, err = meta.ELBV2ID(lbSess, lbName); err != nil {
+						return nil, err
+					}
+				}
+				objDownloaders := []logbucket.ObjectDownloader{albDownloader}
+				if target.classic {
+					objDownloaders = []logbucket.ObjectDownloader{logbucket.NewELBDownloader(lbSess, bucketName, bucketPrefix, lbName)}
+				}
+				// classic load balancers have no connection logs or
+				// target groups
+				if opt.ConnectionLogs && !target.classic {
+					connBucket, connPrefix, connEnabled, err := meta.ELBV2ConnectionLogs(lbSess, lbName)
+					if err != nil {
+						return nil, err
+					}
+					if connEnabled {
+						logrus.WithFields(logrus.Fields{
+							"bucket": connBucket,
+							"lbName": lbName,
+						}).Info("Connection logs are enabled for ALB")
+						connDownloader := logbucket.NewALBConnectionLogDownloader(lbSess, connBucket, connPrefix, lbName)
+						connDownloader.LBID = albDownloader.LBID
+						objDownloaders = append(objDownloaders, connDownloader)
+					} else {
+						// Connection logs are only written for
+						// HTTPS listeners, so plenty of ALBs
+						// won't have them.
+						logrus.WithField("lbName", lbName).Warn("Connection logs are not enabled for ALB, only ingesting its access logs")
+					}
+				}
 
-				lbNameResp, err := elbSvc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
-					Names: []*string{
-						aws.String(lbName),
-					},
-				})
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
+				if targetEnricher != nil && !target.classic {
+					targetEnricher.Add(lbName, lbSess)
+				}
+				if authEnricher != nil && !target.classic {
+					authEnricher.Add(lbName, lbSess)
+				}
+
+				var downloaders []*logbucket.Downloader
+				for _, objDownloader := range objDownloaders {
+					downloader := in.NewDownloader(lbSess, objDownloader)
+					// The region is needed for pricing_region
+					// even when there's only the one.
+					if len(regions) > 0 || opt.CostFields {
+						downloader.Fields = map[string]interface{}{"aws_region": *lbSess.Config.Region}
+					}
+					downloader.Fields = opt.LBFields(lbName, downloader.Fields)
+					// connection logs have no status codes to
+					// rank by
+					if _, ok := objDownloader.(*logbucket.ALBConnectionLogDownloader); !ok && opt.ErrorsFirst {
+						downloader.ErrorRate = publisher.ErrorRate(in.Publisher.EventParser)
+					}
+					in.Start(downloader)
+					downloaders = append(downloaders, downloader)
+				}
+				return downloaders, nil
+			}
+
+			var targets []lbTarget
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				// a name given can be a classic load balancer's
+				if classicSessList := classicSessions[lbName]; len(args) > 1 && len(classicSessList) > 0 {
+					for _, lbSess := range classicSessList {
+						targets = append(targets, lbTarget{lbName, lbSess, true})
+					}
+				} else if !ok {
+					fmt.Fprintf(os.Stderr, "Load balancer %q not found. Try using ls to list available load balancers.\n", lbName)
 					os.Exit(1)
 				}
+				for _, lbSess := range lbSessList {
+					targets = append(targets, lbTarget{lbName, lbSess, false})
+				}
+			}
+			if len(args) == 1 {
+				for _, lbName := range classicNames {
+					for _, lbSess := range classicSessions[lbName] {
+						targets = append(targets, lbTarget{lbName, lbSess, true})
+					}
+				}
+			}
 
-				lbArn := lbNameResp.LoadBalancers[0].LoadBalancerArn
-				lbArnResp, err := elbSvc.DescribeLoadBalancerAttributes(&elbv2.DescribeLoadBalancerAttributesInput{
-					LoadBalancerArn: lbArn,
-				})
+			// For now, just run one goroutine per-LB
+			ingesting := make(map[lbTarget][]*logbucket.Downloader)
+			for _, target := range targets {
+				downloaders, err := ingestLB(target)
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
 					os.Exit(1)
 				}
+				ingesting[target] = downloaders
+			}
 
-				enabled := false
-				bucketName := ""
-				bucketPrefix := ""
-
-				for _, element := range lbArnResp.Attributes {
-					if *element.Key == "access_logs.s3.enabled" && *element.Value == "true" {
-						enabled = true
+			// Without explicit names, load balancers are
+			// rediscovered periodically: ones created (or tagged
+			// to match the tag filters) since startup are ingested,
+			// and ones which are gone are stopped.
+			rediscover := func() {
+				var discovered []lbTarget
+				for _, classic := range []bool{false, true} {
+					describe := describeLoadBalancers
+					if classic {
+						if !opt.ClassicELBs {
+							break
+						}
+						describe = describeClassicLoadBalancers
 					}
-					if *element.Key == "access_logs.s3.bucket" {
-						bucketName = *element.Value
+					lbNames, lbSessions, err := describe(sessions, tagFilters)
+					if err != nil {
+						logrus.WithField("error", err).Error("Could not rediscover load balancers")
+						return
 					}
-					if *element.Key == "access_logs.s3.prefix" {
-						bucketPrefix = *element.Value
+					for _, lbName := range lbNames {
+						for _, lbSess := range lbSessions[lbName] {
+							discovered = append(discovered, lbTarget{lbName, lbSess, classic})
+						}
 					}
 				}
 
-				if !enabled {
-					fmt.Fprintf(os.Stderr, `Access logs are not configured for ALB %q. Please enable them to use the ingest tool.
-
-For reference see this link:
+				found := make(map[lbTarget]bool)
+				for _, target := range discovered {
+					found[target] = true
+					if ingesting[target] != nil {
+						continue
+					}
+					downloaders, err := ingestLB(target)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"lbName": target.name,
+							"error":  err,
+						}).Error("Could not ingest newly discovered load balancer")
+						continue
+					}
+					in.Publisher.Markers.LoadBalancerDiscovered(target.name)
+					ingesting[target] = downloaders
+				}
 
-http://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#enable-access-logging
-`, lbName)
-					os.Exit(1)
+				for target, downloaders := range ingesting {
+					if !found[target] {
+						logrus.WithField("lbName", target.name).Info("Load balancer is gone, stopping ingestion")
+						for _, downloader := range downloaders {
+							downloader.Stop()
+						}
+						delete(ingesting, target)
+					}
 				}
-				logrus.WithFields(logrus.Fields{
-					"bucket": bucketName,
-					"lbName": lbName,
-				}).Info("Access logs are enabled for ALB ♥")
+			}
 
-				albDownloader := logbucket.NewALBDownloader(sess, bucketName, bucketPrefix, lbName)
-				downloader := logbucket.NewDownloader(sess, stater, albDownloader, opt.BackfillHr)
+			// On SIGHUP the --config file is read again and, without
+			// explicit names, load balancers are rediscovered straight
+			// away. Those still being ingested carry on where they
+			// were; only ones ingested from then on get the new load
+			// balancer overrides. The sampling, field and URL rules
+			// apply to every event from then on.
+			if len(args) > 1 {
+				rediscover = nil
+			}
+			return in.Run(in.Rediscover(func() error {
+				return tool.ReloadLBConfig(in.Publisher, &tagFilters)
+			}, rediscover))
+		}
+	}
 
-				// TODO: One-goroutine-per-LB feels a bit
-				// silly.
-				go downloader.Download(downloadsCh)
+	return fmt.Errorf("Subcommand %q not recognized", args[0])
+}
+
+// cmdVerifySampling compares the events in the load balancers' logs for the
+// --window with the COUNT Honeycomb has of them.
+func cmdVerifySampling(lbNames []string, lbSessions map[string][]*session.Session) error {
+	// ALB events are logged with e.g. app/my-lb/1db0c9806095122a as the
+	// load balancer.
+	var lbs []cli.SampledLB
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		if !ok {
+			return fmt.Errorf("ALB %q not found", lbName)
+		}
+		lb := cli.SampledLB{
+			Name:   lbName,
+			Filter: verify.Filter{Column: "elb", Op: "contains", Value: "app/" + lbName + "/"},
+		}
+		for _, lbSess := range lbSessList {
+			bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				return fmt.Errorf("access logs are not enabled for ALB %q", lbName)
 			}
+			lb.Logs = append(lb.Logs, cli.SampledLogs{
+				Sess:             lbSess,
+				ObjectDownloader: logbucket.NewALBDownloader(lbSess, bucketName, bucketPrefix, lbName),
+			})
+		}
+		lbs = append(lbs, lb)
+	}
+	return tool.VerifySampling(publisher.NewALBEventParser(opt), lbs)
+}
+
+// cmdGenerate publishes synthesized ALB logs to --sandbox_dataset until
+// interrupted. It doesn't need AWS access, only a write key.
+func cmdGenerate() error {
+	if opt.WriteKey == "" && opt.NeedsWriteKey() {
+		logrus.Fatal(`--writekey must be set to the proper write key for the Honeycomb team.
+Your write key is available at https://ui.honeycomb.io/account`)
+	}
+	if opt.GenFormat != "alb" {
+		return fmt.Errorf("--format %q is not supported, only alb", opt.GenFormat)
+	}
 
-			signalCh := make(chan os.Signal)
-			signal.Notify(signalCh, os.Interrupt)
+	rate, err := generate.ParseRate(opt.GenRate)
+	if err != nil {
+		return err
+	}
+	generator, err := generate.NewGenerator(opt.GenRoutes, opt.GenStatusMix, opt.GenLatency)
+	if err != nil {
+		return err
+	}
 
-			go func() {
-				<-signalCh
-				logrus.Fatal("Exiting due to interrupt.")
-			}()
+	// Keep synthesized events well away from real ones.
+	opt.Dataset = opt.SandboxDataset
+	opt.DatasetMap = nil
+	samplePublisher := publisher.NewHoneycombPublisher(opt, state.NewMemoryStater(1), publisher.NewALBEventParser(opt))
+
+	stop := make(chan struct{})
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signalCh
+		close(stop)
+	}()
+
+	logrus.WithFields(logrus.Fields{
+		"rate":    opt.GenRate,
+		"dataset": opt.Dataset,
+	}).Info("Generating sample ALB logs until interrupted")
+	if err := generator.Run(samplePublisher, rate, stop); err != nil {
+		return err
+	}
+	samplePublisher.Drain()
+	return nil
+}
 
-			for {
-				download := <-downloadsCh
-				if err := defaultPublisher.Publish(download); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"object": download,
-						"error":  err,
-					}).Error("Cannot properly publish downloaded object")
+// cmdVerify checks, before ingesting, that the load balancers can be
+// described, that their access logs are enabled and can be listed and got
+// from the bucket, that the DynamoDB table can be used with --highavail, and
+// that Honeycomb accepts the write key, printing whether each check passed.
+func cmdVerify(sess *session.Session, sessions []*session.Session, tagFilters map[string]string, lbNames []string) error {
+	var checks meta.Checks
+	var allLBNames []string
+	lbSessions := make(map[string][]*session.Session)
+	for _, regionSess := range sessions {
+		names, found, err := describeLoadBalancers([]*session.Session{regionSess}, tagFilters)
+		checks.Add("describe load balancers", aws.StringValue(regionSess.Config.Region), err)
+		for _, lbName := range names {
+			if _, ok := lbSessions[lbName]; !ok {
+				allLBNames = append(allLBNames, lbName)
+			}
+			lbSessions[lbName] = append(lbSessions[lbName], found[lbName]...)
+		}
+	}
+
+	if len(lbNames) == 0 {
+		lbNames = allLBNames
+	}
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		if !ok {
+			checks.Add("find load balancer", lbName, fmt.Errorf("ALB %q not found", lbName))
+			continue
+		}
+		for _, lbSess := range lbSessList {
+			target := aws.StringValue(lbSess.Config.Region) + "/" + lbName
+			bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+			if err == nil && !enabled {
+				err = fmt.Errorf("Access logs are not enabled")
+			}
+			checks.Add("access logs enabled", target, err)
+			if err != nil {
+				continue
+			}
+			logbucket.CheckAccess(lbSess, logbucket.NewALBDownloader(lbSess, bucketName, bucketPrefix, lbName), opt.KMSKeyARN).Record(&checks, target)
+			if opt.ConnectionLogs {
+				connBucket, connPrefix, connEnabled, err := meta.ELBV2ConnectionLogs(lbSess, lbName)
+				if err != nil {
+					checks.Add("connection logs enabled", target, err)
+				} else if connEnabled {
+					logbucket.CheckAccess(lbSess, logbucket.NewALBConnectionLogDownloader(lbSess, connBucket, connPrefix, lbName), opt.KMSKeyARN).Record(&checks, target+"/connections")
 				}
 			}
 		}
 	}
 
-	return fmt.Errorf("Subcommand %q not recognized", args[0])
+	if opt.StateBackend == "" && opt.HighAvail {
+		checks.Add("dynamodb table", opt.DynamoTable, state.CheckDynamoDBTable(sess, opt.DynamoTable))
+		if tags, err := meta.ParseAWSTags(opt.AWSTags); err != nil {
+			checks.Add("dynamodb table tags", opt.DynamoTable, err)
+		} else if len(tags) > 0 {
+			checks.Add("dynamodb table tags", opt.DynamoTable, state.CheckDynamoDBTableTags(sess, opt.DynamoTable, tags))
+		}
+	}
+	if opt.NeedsWriteKey() {
+		team, err := publisher.CheckWriteKey(opt)
+		if err == nil {
+			team = " (" + team + ")"
+		}
+		checks.Add("honeycomb write key", opt.APIHost+team, err)
+	}
+
+	if err := checks.Print(os.Stdout); err != nil {
+		return err
+	}
+	if failed := checks.Failed(); failed > 0 {
+		return fmt.Errorf("%d of the checks failed", failed)
+	}
+	return nil
 }
 
-func main() {
-	flagParser := flag.NewParser(opt, flag.Default)
-	args, err := flagParser.Parse()
+// cmdBootstrap prints the IAM policy, the bucket policy statements and the
+// DynamoDB table needed for ingesting the load balancers' access logs with
+// the options given, in --bootstrap_format. With --classic-elbs those of the
+// classic load balancers are included, and with --connection-logs the ALBs'
+// connection logs. Load balancers without access logs are left out.
+func cmdBootstrap(sess *session.Session, sessions []*session.Session, tagFilters map[string]string, lbNames []string, lbSessions map[string][]*session.Session) error {
+	classicSessions := make(map[string][]*session.Session)
+	if opt.ClassicELBs {
+		var err error
+		if _, classicSessions, err = describeClassicLoadBalancers(sessions, tagFilters); err != nil {
+			return err
+		}
+	}
+
+	var deliveries []*logbucket.LogDelivery
+	add := func(lbSess *session.Session, lbName, service string, lookup func(*session.Session, string) (string, string, bool, error)) error {
+		bucketName, bucketPrefix, enabled, err := lookup(lbSess, lbName)
+		if err != nil {
+			return fmt.Errorf("Could not look up the logs of %q in %s: %s", lbName, *lbSess.Config.Region, err)
+		}
+		if enabled {
+			deliveries = append(deliveries, logbucket.NewLogDelivery(lbSess, service, bucketName, bucketPrefix))
+		}
+		return nil
+	}
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		classicSessList := classicSessions[lbName]
+		if !ok && len(classicSessList) == 0 {
+			return fmt.Errorf("ALB %q not found", lbName)
+		}
+		for _, lbSess := range lbSessList {
+			if err := add(lbSess, lbName, logbucket.AWSElasticLoadBalancingV2, accessLogBucket); err != nil {
+				return err
+			}
+			if opt.ConnectionLogs {
+				if err := add(lbSess, lbName, logbucket.AWSElasticLoadBalancingV2, meta.ELBV2ConnectionLogs); err != nil {
+					return err
+				}
+			}
+		}
+		for _, lbSess := range classicSessList {
+			if err := add(lbSess, lbName, logbucket.AWSElasticLoadBalancing, meta.ELBAccessLogs); err != nil {
+				return err
+			}
+		}
+	}
+	if len(deliveries) == 0 {
+		logrus.Warn("None of the load balancers have access logs enabled, so no bucket policies are printed")
+	}
+
+	tags, err := meta.ParseAWSTags(opt.AWSTags)
 	if err != nil {
-		os.Exit(1)
+		return err
 	}
+	metadata := meta.Data(sess)
+	return bootstrap.Generate(bootstrap.Config{
+		Name:       "honeyalb",
+		AccountID:  metadata.AccountID,
+		Region:     metadata.Region,
+		Deliveries: deliveries,
+		Options:    opt,
+		Tags:       tags,
+	}).Write(os.Stdout, opt.BootstrapFormat)
+}
 
-	if opt.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
+// accessLogBucket returns the bucket and prefix the load balancer's access
+// logs are delivered to, and whether they're enabled.
+func accessLogBucket(lbSess *session.Session, lbName string) (string, string, bool, error) {
+	return meta.ELBV2AccessLogs(lbSess, lbName)
+}
+
+// validateLB checks that the load balancer's access logs are enabled and that
+// the bucket policy allows them to be delivered, fixing the policy with --fix.
+func validateLB(lbSess *session.Session, lbName string) error {
+	bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+	if err != nil {
+		return err
 	}
+	if !enabled {
+		return fmt.Errorf("Access logs are not enabled")
+	}
+	return logbucket.NewLogDelivery(lbSess, logbucket.AWSElasticLoadBalancingV2, bucketName, bucketPrefix).Validate(opt.FixPolicy)
+}
 
-	formatter := &logrus.TextFormatter{
-		FullTimestamp: true,
+// enableLogging has the load balancer deliver its access logs to --log_bucket,
+// creating the bucket with --create_bucket, once the bucket policy allows them
+// to be delivered (or is fixed to with --fix).
+func enableLogging(lbSess *session.Session, lbName string) error {
+	logDelivery := logbucket.NewLogDelivery(lbSess, logbucket.AWSElasticLoadBalancingV2, opt.LogBucket, opt.LogPrefix)
+	if opt.CreateBucket {
+		tags, err := meta.ParseAWSTags(opt.AWSTags)
+		if err != nil {
+			return err
+		}
+		logDelivery.Tags = tags
+		if err := logDelivery.CreateBucket(); err != nil {
+			return err
+		}
+	} else if err := logDelivery.Validate(opt.FixPolicy); err != nil {
+		return err
 	}
-	logrus.SetFormatter(formatter)
+	return meta.EnableELBV2AccessLogs(lbSess, lbName, opt.LogBucket, opt.LogPrefix)
+}
 
-	logrus.WithField("version", BuildID).Debug("Program starting")
+// describeLoadBalancers returns the names of the load balancers found using
+// each of the sessions, along with the sessions each name belongs to, since
+// load balancers can be spread across accounts and regions. With tag filters,
+// only the load balancers with matching tags are returned.
+func describeLoadBalancers(sessions []*session.Session, tagFilters map[string]string) ([]string, map[string][]*session.Session, error) {
+	var allLBNames []string
+	lbSessions := make(map[string][]*session.Session)
+	err := meta.EachRegion(sessions, func(lbSess *session.Session) error {
+		lbs, err := meta.ELBV2LoadBalancers(lbSess)
+		if err != nil {
+			return err
+		}
 
-	if opt.Dataset == "aws-$SERVICE-access" {
-		opt.Dataset = "aws-elb-access"
+		if len(tagFilters) > 0 {
+			if lbs, err = filterByTags(lbSess, lbs, tagFilters); err != nil {
+				return err
+			}
+		}
+		for _, lb := range lbs {
+			if _, ok := lbSessions[*lb.LoadBalancerName]; !ok {
+				allLBNames = append(allLBNames, *lb.LoadBalancerName)
+			}
+			lbSessions[*lb.LoadBalancerName] = append(lbSessions[*lb.LoadBalancerName], lbSess)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return allLBNames, lbSessions, nil
+}
 
-	if _, err := os.Stat(opt.StateDir); os.IsNotExist(err) {
-		logrus.WithField("dir", opt.StateDir).Fatal("Specified state directory does not exist")
+// describeClassicLoadBalancers is describeLoadBalancers for classic load
+// balancers, for --classic-elbs.
+func describeClassicLoadBalancers(sessions []*session.Session, tagFilters map[string]string) ([]string, map[string][]*session.Session, error) {
+	var allLBNames []string
+	lbSessions := make(map[string][]*session.Session)
+	err := meta.EachRegion(sessions, func(lbSess *session.Session) error {
+		lbs, err := meta.ELBLoadBalancers(lbSess)
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(lbs))
+		for _, lb := range lbs {
+			names = append(names, *lb.LoadBalancerName)
+		}
+		if len(tagFilters) > 0 && len(names) > 0 {
+			tags, err := meta.ELBTags(lbSess, names)
+			if err != nil {
+				return err
+			}
+			var matched []string
+			for _, name := range names {
+				if meta.MatchesTags(tags[name], tagFilters) {
+					matched = append(matched, name)
+				}
+			}
+			names = matched
+		}
+		for _, name := range names {
+			if _, ok := lbSessions[name]; !ok {
+				allLBNames = append(allLBNames, name)
+			}
+			lbSessions[name] = append(lbSessions[name], lbSess)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return allLBNames, lbSessions, nil
+}
 
-	if opt.Version {
-		fmt.Println("honeyalb version", versionStr)
-		os.Exit(0)
+func filterByTags(sess *session.Session, lbs []*elbv2.LoadBalancer, tagFilters map[string]string) ([]*elbv2.LoadBalancer, error) {
+	arns := make([]string, 0, len(lbs))
+	for _, lb := range lbs {
+		arns = append(arns, *lb.LoadBalancerArn)
+	}
+	tags, err := meta.ELBV2Tags(sess, arns)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, `Usage: `+os.Args[0]+` [--flags] [ls|ingest] [ALB names...]
+	var matched []*elbv2.LoadBalancer
+	for _, lb := range lbs {
+		if meta.MatchesTags(tags[*lb.LoadBalancerArn], tagFilters) {
+			matched = append(matched, lb)
+		}
+	}
+	return matched, nil
+}
 
-Use '`+os.Args[0]+` --help' to see available flags.`)
-		os.Exit(1)
+func main() {
+	tool = &cli.Command{
+		Name:          "honeyalb",
+		Version:       versionStr,
+		Service:       logbucket.AWSElasticLoadBalancingV2,
+		Dataset:       "aws-elb-access",
+		NamesEnv:      "HONEYAWS_LBS",
+		Usage:         "[ls|ingest|ingest-file|parse|validate|verify|bootstrap|verify-sampling|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status|generate] [ALB names...]",
+		BucketLogType: publisher.LogTypeALB,
+		EventParser:   func() publisher.EventParser { return publisher.NewALBEventParser(opt) },
+		Opt:           opt,
 	}
+	args := tool.ParseFlags()
+	defer tool.Start(args)()
+	defer crash.Recover()
 
-	if err := cmdALB(args); err != nil {
+	var err error
+	if args[0] == "generate" {
+		err = cmdGenerate()
+	} else {
+		err = tool.Run(args, cmdALB)
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error: ", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/apigatewayv2"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	opt        = &options.Options{}
+	tool       *cli.Command
+	BuildID    string
+	versionStr string
+)
+
+func init() {
+	// set the version string to our desired format
+	if BuildID == "" {
+		versionStr = "dev"
+	} else {
+		versionStr = BuildID
+	}
+
+	// init libhoney user agent properly
+	libhoney.UserAgentAddition = "honeyapigateway/" + versionStr
+}
+
+// stage is an API's stage whose access logs are delivered to S3 by a
+// Firehose delivery stream, straight from API Gateway or through a
+// subscription of the CloudWatch Logs log group they're sent to, or else are
+// read from the log group itself.
+type stage struct {
+	apiID, apiName, name string
+	bucket, prefix       string
+	logGroup             string
+}
+
+func (s stage) String() string {
+	return s.apiID + "/" + s.name
+}
+
+// stageSource finds the S3 bucket, and prefix, the access logs sent to the
+// destination end up in, or the log group they're to be read from when it
+// has no subscription to Firehose.
+func stageSource(sess *session.Session, destination string) (bucket, prefix, logGroup string, err error) {
+	if !logbucket.IsFirehoseARN(destination) {
+		firehoseARN, err := logbucket.SubscriptionFirehose(sess, destination)
+		if err != nil {
+			return "", "", "", err
+		}
+		if firehoseARN == "" {
+			logGroup, err := logbucket.LogGroupName(destination)
+			return "", "", logGroup, err
+		}
+		destination = firehoseARN
+	}
+	bucket, prefix, err = logbucket.FirehoseBucket(sess, destination)
+	return bucket, prefix, "", err
+}
+
+// addStage adds the stage to the stages, if it logs access in a JSON format.
+func addStage(sess *session.Session, stages []stage, s stage, destination, format string) []stage {
+	if destination == "" {
+		return stages
+	}
+	if !strings.HasPrefix(strings.TrimSpace(format), "{") {
+		logrus.WithField("stage", s.String()).Warn("Access logs are not in a JSON format, skipping")
+		return stages
+	}
+	bucket, prefix, logGroup, err := stageSource(sess, destination)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"stage": s.String(),
+			"error": err,
+		}).Warn("Could not find where the access logs of stage are, skipping")
+		return stages
+	}
+	s.bucket, s.prefix, s.logGroup = bucket, prefix, logGroup
+	return append(stages, s)
+}
+
+// listStages lists the stages of the REST, HTTP and WebSocket APIs which log
+// access.
+func listStages(sess *session.Session) ([]stage, error) {
+	var stages []stage
+
+	restSvc := apigateway.New(sess)
+	var restAPIs []*apigateway.RestApi
+	err := restSvc.GetRestApisPages(&apigateway.GetRestApisInput{}, func(page *apigateway.GetRestApisOutput, lastPage bool) bool {
+		restAPIs = append(restAPIs, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, api := range restAPIs {
+		out, err := restSvc.GetStages(&apigateway.GetStagesInput{RestApiId: api.Id})
+		if err != nil {
+			return nil, err
+		}
+		for _, st := range out.Item {
+			if settings := st.AccessLogSettings; settings != nil {
+				s := stage{apiID: *api.Id, apiName: aws.StringValue(api.Name), name: *st.StageName}
+				stages = addStage(sess, stages, s, aws.StringValue(settings.DestinationArn), aws.StringValue(settings.Format))
+			}
+		}
+	}
+
+	v2Svc := apigatewayv2.New(sess)
+	input := &apigatewayv2.GetApisInput{}
+	for {
+		page, err := v2Svc.GetApis(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, api := range page.Items {
+			out, err := v2Svc.GetStages(&apigatewayv2.GetStagesInput{ApiId: api.ApiId})
+			if err != nil {
+				return nil, err
+			}
+			for _, st := range out.Items {
+				if settings := st.AccessLogSettings; settings != nil {
+					s := stage{apiID: *api.ApiId, apiName: aws.StringValue(api.Name), name: *st.StageName}
+					stages = addStage(sess, stages, s, aws.StringValue(settings.DestinationArn), aws.StringValue(settings.Format))
+				}
+			}
+		}
+		if page.NextToken == nil || *page.NextToken == "" {
+			break
+		}
+		input.NextToken = page.NextToken
+	}
+	return stages, nil
+}
+
+func cmdAPIGateway(args []string) error {
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
+
+	stages, err := listStages(sess)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "ls", "list":
+			for _, s := range stages {
+				fmt.Println(s)
+			}
+			return nil
+
+		case "ingest":
+			in := tool.NewIngest(sess, publisher.NewAPIGatewayEventParser(opt))
+
+			stageNames := args[1:]
+
+			if len(stageNames) > 0 {
+				ingest := make(map[string]bool)
+				for _, name := range stageNames {
+					ingest[name] = true
+				}
+				var listed []stage
+				for _, s := range stages {
+					if ingest[s.String()] {
+						listed = append(listed, s)
+					}
+				}
+				stages = listed
+			}
+
+			if len(stages) == 0 {
+				logrus.Fatal(`No valid stages with access logs listed. Try using ls to list available stages or refer to the README.`)
+			}
+
+			// Stages may share a delivery stream or log group,
+			// which is only to be ingested once.
+			destinations := make(map[string][]stage)
+			var destinationOrder []string
+			for _, s := range stages {
+				key := s.bucket + "/" + s.prefix
+				if s.logGroup != "" {
+					key = "logs/" + s.logGroup
+				}
+				if destinations[key] == nil {
+					destinationOrder = append(destinationOrder, key)
+				}
+				destinations[key] = append(destinations[key], s)
+			}
+
+			for _, key := range destinationOrder {
+				shared := destinations[key]
+				s := shared[0]
+				var names []string
+				for _, other := range shared {
+					names = append(names, other.String())
+				}
+				// Which stage a record is of is only known when
+				// the stream or log group is the stage's own.
+				var fields map[string]interface{}
+				if len(shared) == 1 {
+					fields = map[string]interface{}{
+						"api_id":   s.apiID,
+						"api_name": s.apiName,
+						"stage":    s.name,
+					}
+				}
+
+				if s.logGroup != "" {
+					// Workers only download what the
+					// lister enqueues.
+					if opt.Role == logbucket.RoleWorker {
+						continue
+					}
+					if in.TimeRange != nil {
+						logrus.WithField("log_group", s.logGroup).Warn("--start-time only ingests access logs from S3, skipping the log group")
+						continue
+					}
+					logrus.WithFields(logrus.Fields{
+						"stages":    strings.Join(names, ","),
+						"log_group": s.logGroup,
+					}).Info("API Gateway access logs are only sent to CloudWatch Logs, reading them from the log group")
+
+					tailer := logbucket.NewLogGroupTailer(sess, in.Stater, s.logGroup, opt.BackfillHr)
+					tailer.Fields = fields
+					tailer.Context = in.Context
+					tailer.Shard = in.Shard
+					go tailer.Tail(in.Downloads)
+					continue
+				}
+
+				logrus.WithFields(logrus.Fields{
+					"stages": strings.Join(names, ","),
+					"bucket": s.bucket,
+					"prefix": s.prefix,
+				}).Info("API Gateway access logs are delivered to S3")
+
+				firehoseDownloader := logbucket.NewFirehoseDownloader(s.bucket, s.prefix, s.String())
+				downloader := in.NewDownloader(sess, firehoseDownloader)
+				downloader.Fields = fields
+				in.Start(downloader)
+			}
+
+			return in.Run(in.Reload)
+
+		}
+
+	}
+
+	return fmt.Errorf("Subcommand %q not recognized", args[0])
+}
+
+func main() {
+	tool = &cli.Command{
+		Name:        "honeyapigateway",
+		Version:     versionStr,
+		Service:     logbucket.AWSAPIGateway,
+		Dataset:     "aws-apigateway-access",
+		NamesEnv:    "HONEYAWS_STAGES",
+		Usage:       "[ls|ingest|ingest-file|parse|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status] [api-id/stage...]",
+		EventParser: func() publisher.EventParser { return publisher.NewAPIGatewayEventParser(opt) },
+		Opt:         opt,
+	}
+	args := tool.ParseFlags()
+	defer tool.Start(args)()
+	defer crash.Recover()
+
+	if err := tool.Run(args, cmdAPIGateway); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		os.Exit(1)
+	}
+}
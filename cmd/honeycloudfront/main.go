@@ -3,24 +3,23 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/signal"
 	"strings"
-	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
 	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/meta"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/publisher"
-	"github.com/honeycombio/honeyaws/state"
 	libhoney "github.com/honeycombio/libhoney-go"
-	flag "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	opt        = &options.Options{}
+	tool       *cli.Command
 	BuildID    string
 	versionStr string
 )
@@ -37,13 +36,42 @@ func init() {
 	libhoney.UserAgentAddition = "honeycloudfront/" + versionStr
 }
 
+// loggingBucket returns the name of the bucket from a distribution's logging
+// config, which has a bucket URL (e.g.,
+// nathanleclaire-cloudfront-test-access-logs.s3.amazonaws.com), so strip the
+// suffix from the bucket.
+//
+// TODO(nathanleclaire): Determine if this is acceptably robust.
+func loggingBucket(bucketURL string) string {
+	return strings.Replace(bucketURL, ".s3.amazonaws.com", "", -1)
+}
+
+// distributionListing returns the distribution's status and access log
+// settings, for ls. Distributions have no scheme.
+func distributionListing(svc *cloudfront.CloudFront, summary *cloudfront.DistributionSummary) (meta.Listing, error) {
+	l := meta.Listing{Name: *summary.Id, State: aws.StringValue(summary.Status)}
+	if !aws.BoolValue(summary.Enabled) {
+		l.State = "Disabled"
+	}
+	distConfigResp, err := svc.GetDistributionConfig(&cloudfront.GetDistributionConfigInput{
+		Id: summary.Id,
+	})
+	if err != nil {
+		return l, err
+	}
+	if logging := distConfigResp.DistributionConfig.Logging; logging != nil && aws.BoolValue(logging.Enabled) {
+		l.LoggingEnabled = true
+		l.Bucket = loggingBucket(aws.StringValue(logging.Bucket))
+		l.Prefix = aws.StringValue(logging.Prefix)
+	}
+	return l, nil
+}
+
 func cmdCloudFront(args []string) error {
-	// TODO: Would be nice to have this more highly configurable.
-	//
-	// Will just use environment config right now, e.g., default profile.
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
 
 	cloudfrontSvc := cloudfront.New(sess, nil)
 
@@ -55,17 +83,26 @@ func cmdCloudFront(args []string) error {
 	if len(args) > 0 {
 		switch args[0] {
 		case "ls", "list":
+			var listings []meta.Listing
 			for _, distributionSummary := range listDistributionsResp.DistributionList.Items {
-				fmt.Println(*distributionSummary.Id)
+				listing := meta.Listing{Name: *distributionSummary.Id}
+				if opt.ListOutput != "" {
+					var err error
+					if listing, err = distributionListing(cloudfrontSvc, distributionSummary); err != nil {
+						listing.Error = err.Error()
+					}
+				}
+				listings = append(listings, listing)
 			}
 
-			return nil
+			return meta.PrintListings(os.Stdout, opt.ListOutput, listings)
 
 		case "ingest":
-			if opt.WriteKey == "" {
-				logrus.Fatal(`--writekey must be set to the proper write key for the Honeycomb team.
-Your write key is available at https://ui.honeycomb.io/account`)
+			var eventParser publisher.EventParser = publisher.NewCloudFrontEventParser(opt)
+			if opt.KinesisStream != "" {
+				eventParser = publisher.NewCloudFrontRealtimeEventParser(opt)
 			}
+			in := tool.NewIngest(sess, eventParser)
 
 			distIds := args[1:]
 
@@ -76,29 +113,12 @@ Your write key is available at https://ui.honeycomb.io/account`)
 					distIds = append(distIds, *distributionSummary.Id)
 				}
 			}
-
-			var stater state.Stater
-
-			if opt.BackfillHr < 1 || opt.BackfillHr > 168 {
-				logrus.WithField("hours", opt.BackfillHr).Fatal("--backfill requires an hour input between 1 and 168")
-			}
-
-			if opt.HighAvail {
-				stater, err = state.NewDynamoDBStater(sess, opt.BackfillHr)
-
-				if err != nil {
-					logrus.WithField("tableName", state.DynamoTableName).Fatal("--highavail requires an existing DynamoDB table named appropriately, please refer to the README.")
-				}
-				logrus.Info("High availability enabled - using DynamoDB")
-
-			} else {
-				stater = state.NewFileStater(opt.StateDir, logbucket.AWSCloudFront, opt.BackfillHr)
-				logrus.Info("State tracking enabled - using local file system.")
+			if opt.KinesisStream != "" {
+				// Real-time logs come from the stream rather
+				// than from the distributions' log buckets.
+				distIds = nil
+				logbucket.NewKinesisConsumer(sess, in.Stater, opt.KinesisStream).Consume(in.Downloads)
 			}
-			logrus.WithField("hours", time.Duration(opt.BackfillHr)*time.Hour).Debug("Backfill will be")
-
-			downloadsCh := make(chan state.DownloadedObject)
-			defaultPublisher := publisher.NewHoneycombPublisher(opt, stater, publisher.NewCloudFrontEventParser(opt))
 
 			// For now, just run one goroutine per-distribution
 			for _, id := range distIds {
@@ -128,14 +148,7 @@ https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/AccessLogs.ht
 					os.Exit(1)
 				}
 
-				// loggingConfig.Bucket returns a bucket URL
-				// (e.g.,
-				// nathanleclaire-cloudfront-test-access-logs.s3.amazonaws.com)
-				// so strip the suffix from the bucket.
-				//
-				// TODO(nathanleclaire): Determine if this is
-				// acceptably robust.
-				bucket := strings.Replace(*loggingConfig.Bucket, ".s3.amazonaws.com", "", -1)
+				bucket := loggingBucket(*loggingConfig.Bucket)
 
 				logrus.WithFields(logrus.Fields{
 					"bucket": bucket,
@@ -143,26 +156,14 @@ https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/AccessLogs.ht
 				}).Info("Access logs are enabled for CloudFront distribution ♥")
 
 				cloudfrontDownloader := logbucket.NewCloudFrontDownloader(bucket, *loggingConfig.Prefix, id)
-				downloader := logbucket.NewDownloader(sess, stater, cloudfrontDownloader, opt.BackfillHr)
-				go downloader.Download(downloadsCh)
-			}
-
-			signalCh := make(chan os.Signal)
-			signal.Notify(signalCh, os.Interrupt)
-			go func() {
-				<-signalCh
-				logrus.Fatal("Exiting due to interrupt.")
-			}()
-
-			for {
-				download := <-downloadsCh
-				if err := defaultPublisher.Publish(download); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"object": download,
-						"error":  err,
-					}).Error("Cannot properly publish downloaded object")
+				downloader := in.NewDownloader(sess, cloudfrontDownloader)
+				if opt.ErrorsFirst {
+					downloader.ErrorRate = publisher.ErrorRate(in.Publisher.EventParser)
 				}
+				in.Start(downloader)
 			}
+
+			return in.Run(in.Reload)
 		}
 	}
 
@@ -170,44 +171,22 @@ https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/AccessLogs.ht
 }
 
 func main() {
-	flagParser := flag.NewParser(opt, flag.Default)
-	args, err := flagParser.Parse()
-	if err != nil {
-		os.Exit(1)
-	}
-
-	if opt.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
-	}
-
-	formatter := &logrus.TextFormatter{
-		FullTimestamp: true,
-	}
-	logrus.SetFormatter(formatter)
-
-	logrus.WithField("version", BuildID).Debug("Program starting")
-
-	if opt.Dataset == "aws-$SERVICE-access" {
-		opt.Dataset = "aws-cloudfront-access"
-	}
-
-	if _, err := os.Stat(opt.StateDir); os.IsNotExist(err) {
-		logrus.WithField("dir", opt.StateDir).Fatal("Specified state directory does not exist")
-	}
-
-	if opt.Version {
-		fmt.Println("honeycloudfront version", versionStr)
-		os.Exit(0)
-	}
-
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, `Usage: `+os.Args[0]+` [--flags] [ls|ingest] [CloudFront distribution IDs...]
-
-Use '`+os.Args[0]+` --help' to see available flags.`)
-		os.Exit(1)
+	tool = &cli.Command{
+		Name:          "honeycloudfront",
+		Version:       versionStr,
+		Service:       logbucket.AWSCloudFront,
+		Dataset:       "aws-cloudfront-access",
+		NamesEnv:      "HONEYAWS_DISTRIBUTIONS",
+		Usage:         "[ls|ingest|ingest-file|parse|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status] [CloudFront distribution IDs...]",
+		BucketLogType: publisher.LogTypeCloudFront,
+		EventParser:   func() publisher.EventParser { return publisher.NewCloudFrontEventParser(opt) },
+		Opt:           opt,
 	}
+	args := tool.ParseFlags()
+	defer tool.Start(args)()
+	defer crash.Recover()
 
-	if err := cmdCloudFront(args); err != nil {
+	if err := tool.Run(args, cmdCloudFront); err != nil {
 		fmt.Fprintln(os.Stderr, "Error: ", err)
 		os.Exit(1)
 	}
@@ -3,23 +3,21 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/signal"
-	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
 	"github.com/honeycombio/honeyaws/logbucket"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/publisher"
-	"github.com/honeycombio/honeyaws/state"
 	libhoney "github.com/honeycombio/libhoney-go"
-	flag "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	opt        = &options.Options{}
+	tool       *cli.Command
 	BuildID    string
 	versionStr string
 )
@@ -37,12 +35,10 @@ func init() {
 }
 
 func cmdCloudTrail(args []string) error {
-	// TODO: Would be nice to have this more highly configurable.
-	//
-	// Will just use environment config right now, e.g., default profile.
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
 
 	cloudtrailSvc := cloudtrail.New(sess, nil)
 
@@ -61,10 +57,7 @@ func cmdCloudTrail(args []string) error {
 			return nil
 
 		case "ingest":
-			if opt.WriteKey == "" {
-				logrus.Fatal(`--writekey must be set to the proper write key for the Honeycomb team.
-Your write key is available at https://ui.honeycomb.io/account`)
-			}
+			in := tool.NewIngest(sess, publisher.NewCloudTrailEventParser(opt))
 
 			trailNames := args[1:]
 
@@ -87,28 +80,6 @@ Your write key is available at https://ui.honeycomb.io/account`)
 				os.Exit(1)
 			}
 
-			var stater state.Stater
-
-			if opt.BackfillHr < 1 || opt.BackfillHr > 168 {
-				logrus.WithField("hours", opt.BackfillHr).Fatal("--backfill requires an hour input between 1 and 168")
-			}
-
-			if opt.HighAvail {
-				stater, err = state.NewDynamoDBStater(sess, opt.BackfillHr)
-				if err != nil {
-					logrus.WithField("tableName", state.DynamoTableName).Fatal("--highavail requires an existing DynamoDB table named appropriately, please refer to the README.")
-				}
-				logrus.Info("High availability enabled - using DynamoDB")
-
-			} else {
-				stater = state.NewFileStater(opt.StateDir, logbucket.AWSCloudTrail, opt.BackfillHr)
-				logrus.Info("State tracking enabled - using local file system.")
-			}
-			logrus.WithField("hours", time.Duration(opt.BackfillHr)*time.Hour).Debug("Backfill will be")
-
-			downloadsCh := make(chan state.DownloadedObject)
-			defaultPublisher := publisher.NewHoneycombPublisher(opt, stater, publisher.NewCloudTrailEventParser(opt))
-
 			for _, trail := range trailListResp.TrailList {
 
 				var prefix string
@@ -135,27 +106,10 @@ https://docs.aws.amazon.com/awscloudtrail/latest/userguide/cloudtrail-create-and
 				}).Info("Access logs are enabled for CloudTrail trails")
 
 				cloudtrailDownloader := logbucket.NewCloudTrailDownloader(sess, *s3Bucket, prefix, *trail.TrailARN)
-				downloader := logbucket.NewDownloader(sess, stater, cloudtrailDownloader, opt.BackfillHr)
-				go downloader.Download(downloadsCh)
-			}
-
-			signalCh := make(chan os.Signal)
-			signal.Notify(signalCh, os.Interrupt)
-			go func() {
-				<-signalCh
-				logrus.Fatal("Exiting due to interrupt.")
-			}()
-
-			for {
-				download := <-downloadsCh
-				if err := defaultPublisher.Publish(download); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"object": download,
-						"error":  err,
-					}).Error("Cannot properly publish downloaded object")
-				}
+				in.Start(in.NewDownloader(sess, cloudtrailDownloader))
 			}
 
+			return in.Run(in.Reload)
 		}
 
 	}
@@ -164,44 +118,21 @@ https://docs.aws.amazon.com/awscloudtrail/latest/userguide/cloudtrail-create-and
 }
 
 func main() {
-	flagParser := flag.NewParser(opt, flag.Default)
-	args, err := flagParser.Parse()
-	if err != nil {
-		os.Exit(1)
-	}
-
-	if opt.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
-	}
-
-	formatter := &logrus.TextFormatter{
-		FullTimestamp: true,
-	}
-	logrus.SetFormatter(formatter)
-
-	logrus.WithField("version", BuildID).Debug("Program starting")
-
-	if opt.Dataset == "aws-$SERVICE-access" {
-		opt.Dataset = "aws-cloudtrail-access"
-	}
-
-	if _, err := os.Stat(opt.StateDir); os.IsNotExist(err) {
-		logrus.WithField("dir", opt.StateDir).Fatal("Specified state directory does not exist")
-	}
-
-	if opt.Version {
-		fmt.Println("honeycloudtrail version", versionStr)
-		os.Exit(0)
-	}
-
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, `Usage: `+os.Args[0]+` [--flags] [ls|ingest] [CloudTrail distribution IDs...]
-
-Use '`+os.Args[0]+` --help' to see available flags.`)
-		os.Exit(1)
+	tool = &cli.Command{
+		Name:        "honeycloudtrail",
+		Version:     versionStr,
+		Service:     logbucket.AWSCloudTrail,
+		Dataset:     "aws-cloudtrail-access",
+		NamesEnv:    "HONEYAWS_TRAILS",
+		Usage:       "[ls|ingest|ingest-file|parse|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status] [CloudTrail distribution IDs...]",
+		EventParser: func() publisher.EventParser { return publisher.NewCloudTrailEventParser(opt) },
+		Opt:         opt,
 	}
+	args := tool.ParseFlags()
+	defer tool.Start(args)()
+	defer crash.Recover()
 
-	if err := cmdCloudTrail(args); err != nil {
+	if err := tool.Run(args, cmdCloudTrail); err != nil {
 		fmt.Fprintln(os.Stderr, "Error: ", err)
 		os.Exit(1)
 	}
@@ -3,23 +3,26 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/signal"
-	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/honeycombio/honeyaws/bootstrap"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
 	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/meta"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/publisher"
 	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeyaws/verify"
 	libhoney "github.com/honeycombio/libhoney-go"
-	flag "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	opt        = &options.Options{}
+	tool       *cli.Command
 	BuildID    string
 	versionStr string
 )
@@ -37,16 +40,24 @@ func init() {
 }
 
 func cmdELB(args []string) error {
-	// TODO: Would be nice to have this more highly configurable.
-	//
-	// Will just use environment config right now, e.g., default profile.
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
 
-	elbSvc := elb.New(sess, nil)
+	regions := meta.Regions(opt.Regions, opt.AllRegions, "elasticloadbalancing")
+	sessions := meta.RegionSessions(meta.AssumeRoleSessions(sess, opt.AssumeRoleARNs, meta.SessionName(versionStr)), regions)
 
-	describeLBResp, err := elbSvc.DescribeLoadBalancers(&elb.DescribeLoadBalancersInput{})
+	tagFilters, err := meta.ParseTagFilters(opt.TagFilters)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "verify" {
+		// a load balancer which can't be described is reported, along
+		// with the other checks, rather than stopping there
+		return cmdVerify(sess, sessions, tagFilters, args[1:])
+	}
+	allLBNames, lbSessions, err := describeLoadBalancers(sessions, tagFilters)
 	if err != nil {
 		return err
 	}
@@ -54,161 +65,527 @@ func cmdELB(args []string) error {
 	if len(args) > 0 {
 		switch args[0] {
 		case "ls", "list":
-			for _, lb := range describeLBResp.LoadBalancerDescriptions {
-				fmt.Println(*lb.LoadBalancerName)
+			var listings []meta.Listing
+			for _, lbName := range allLBNames {
+				lbSessList := lbSessions[lbName]
+				if len(regions) == 0 && opt.ListOutput == "" {
+					// just the name, once
+					lbSessList = lbSessList[:1]
+				}
+				for _, lbSess := range lbSessList {
+					listing := meta.Listing{Name: lbName}
+					if opt.ListOutput != "" {
+						var err error
+						if listing, err = lbListing(lbSess, lbName); err != nil {
+							listing.Error = err.Error()
+						}
+					}
+					if len(regions) > 0 {
+						listing.Region = *lbSess.Config.Region
+					}
+					listings = append(listings, listing)
+				}
 			}
 
-			return nil
+			return meta.PrintListings(os.Stdout, opt.ListOutput, listings)
 
-		case "ingest":
-			if opt.WriteKey == "" {
-				logrus.Fatal(`--writekey must be set to the proper write key for the Honeycomb team.
-Your write key is available at https://ui.honeycomb.io/account`)
+		case "validate":
+			lbNames := args[1:]
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
 			}
 
-			lbNames := args[1:]
+			failed := 0
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					return fmt.Errorf("ELB %q not found", lbName)
+				}
+				for _, lbSess := range lbSessList {
+					if err := validateLB(lbSess, lbName); err != nil {
+						fmt.Printf("%s\t%s\t%s\n", lbName, *lbSess.Config.Region, err)
+						failed++
+						continue
+					}
+					fmt.Printf("%s\t%s\tok\n", lbName, *lbSess.Config.Region)
+				}
+			}
 
-			// Use all available load balancers by default if none
-			// are provided.
+			if failed > 0 {
+				return fmt.Errorf("%d of the load balancers' access logs may not be delivered", failed)
+			}
+			return nil
+
+		case "bootstrap":
+			lbNames := args[1:]
 			if len(lbNames) == 0 {
-				for _, lb := range describeLBResp.LoadBalancerDescriptions {
-					lbNames = append(lbNames, *lb.LoadBalancerName)
-				}
+				lbNames = allLBNames
 			}
+			return cmdBootstrap(sess, lbNames, lbSessions)
 
-			var stater state.Stater
+		case "verify-sampling":
+			lbNames := args[1:]
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
+			}
+			return cmdVerifySampling(lbNames, lbSessions)
 
-			if opt.BackfillHr < 1 || opt.BackfillHr > 168 {
-				logrus.WithField("hours", opt.BackfillHr).Fatal("--backfill requires an hour input between 1 and 168")
+		case "enable-logging":
+			lbNames := args[1:]
+			if len(lbNames) == 0 || opt.LogBucket == "" {
+				return fmt.Errorf("Usage: %s --log_bucket=<bucket> [--log_prefix=<prefix>] [--create_bucket] enable-logging <lb-name>...", os.Args[0])
 			}
 
-			if opt.HighAvail {
-				stater, err = state.NewDynamoDBStater(sess, opt.BackfillHr)
-				if err != nil {
-					logrus.WithField("tableName", state.DynamoTableName).Fatal("--highavail requires an existing DynamoDB table named appropriately, please refer to the README.")
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					return fmt.Errorf("ELB %q not found", lbName)
+				}
+				for _, lbSess := range lbSessList {
+					if err := enableLogging(lbSess, lbName); err != nil {
+						return fmt.Errorf("Could not enable access logs for ELB %q in %s: %s", lbName, *lbSess.Config.Region, err)
+					}
+					fmt.Printf("%s\t%s\tenabled\n", lbName, *lbSess.Config.Region)
 				}
-				logrus.Info("High availability enabled - using DynamoDB")
+			}
+			return nil
 
-			} else {
-				stater = state.NewFileStater(opt.StateDir, logbucket.AWSElasticLoadBalancing, opt.BackfillHr)
-				logrus.Info("State tracking enabled - using local file system.")
+		case "ingest":
+			in := tool.NewIngest(sess, publisher.NewELBEventParser(opt))
+
+			lbNames := args[1:]
+
+			// Use all available load balancers by default if none
+			// are provided.
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
 			}
-			logrus.WithField("hours", time.Duration(opt.BackfillHr)*time.Hour).Debug("Backfill will be")
 
-			defaultPublisher := publisher.NewHoneycombPublisher(opt, stater, publisher.NewELBEventParser(opt))
-			downloadsCh := make(chan state.DownloadedObject)
+			var targetEnricher *publisher.TargetEnricher
+			if opt.EnrichTargets {
+				targetEnricher = publisher.NewTargetEnricher()
+				in.Publisher.Enricher = targetEnricher
+			}
 
-			// For now, just run one goroutine per-LB
-			for _, lbName := range lbNames {
+			// ingestLB starts downloading the logs of a load
+			// balancer in the background, returning its
+			// downloader.
+			ingestLB := func(lbName string, lbSess *session.Session) (*logbucket.Downloader, error) {
 				logrus.WithFields(logrus.Fields{
 					"lbName": lbName,
 				}).Info("Attempting to ingest LB")
 
-				elbSvc := elb.New(sess, nil)
-
-				lbResp, err := elbSvc.DescribeLoadBalancerAttributes(&elb.DescribeLoadBalancerAttributesInput{
-					LoadBalancerName: aws.String(lbName),
-				})
+				bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
 				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					os.Exit(1)
+					return nil, err
 				}
 
-				accessLog := lbResp.LoadBalancerAttributes.AccessLog
-
-				if !*accessLog.Enabled {
-					fmt.Fprintf(os.Stderr, `Access logs are not configured for ELB %q. Please enable them to use the ingest tool.
+				if !enabled {
+					return nil, fmt.Errorf(`Access logs are not configured for ELB %q. Please enable them to use the ingest tool.
 
 For reference see this link:
 
-http://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#enable-access-logging
-`, lbName)
-					os.Exit(1)
+http://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#enable-access-logging`, lbName)
 				}
 				logrus.WithFields(logrus.Fields{
-					"bucket": *accessLog.S3BucketName,
+					"bucket": bucketName,
 					"lbName": lbName,
 				}).Info("Access logs are enabled for ELB ♥")
 
-				elbDownloader := logbucket.NewELBDownloader(sess, *accessLog.S3BucketName, *accessLog.S3BucketPrefix, lbName)
-				downloader := logbucket.NewDownloader(sess, stater, elbDownloader, opt.BackfillHr)
+				if opt.CheckPolicy {
+					logDelivery := logbucket.NewLogDelivery(lbSess, logbucket.AWSElasticLoadBalancing, bucketName, bucketPrefix)
+					if err := logDelivery.Validate(opt.FixPolicy); err != nil {
+						logrus.WithFields(logrus.Fields{
+							"lbName": lbName,
+							"error":  err,
+						}).Error("Access logs may not be delivered")
+					}
+				}
+
+				elbDownloader := logbucket.NewELBDownloader(lbSess, bucketName, bucketPrefix, lbName)
+				downloader := in.NewDownloader(lbSess, elbDownloader)
+				// The region is needed for pricing_region
+				// even when there's only the one.
+				if len(regions) > 0 || opt.CostFields {
+					downloader.Fields = map[string]interface{}{"aws_region": *lbSess.Config.Region}
+				}
+				downloader.Fields = opt.LBFields(lbName, downloader.Fields)
+				if opt.ErrorsFirst {
+					downloader.ErrorRate = publisher.ErrorRate(in.Publisher.EventParser)
+				}
+				if targetEnricher != nil {
+					targetEnricher.Add(lbName, lbSess)
+				}
+				in.Start(downloader)
+				return downloader, nil
+			}
+
+			type lbTarget struct {
+				name string
+				sess *session.Session
+			}
+			var targets []lbTarget
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					fmt.Fprintf(os.Stderr, "Load balancer %q not found. Try using ls to list available load balancers.\n", lbName)
+					os.Exit(1)
+				}
+				for _, lbSess := range lbSessList {
+					targets = append(targets, lbTarget{lbName, lbSess})
+				}
+			}
 
-				// TODO: One-goroutine-per-LB feels a bit
-				// silly.
-				go downloader.Download(downloadsCh)
+			// For now, just run one goroutine per-LB
+			ingesting := make(map[lbTarget]*logbucket.Downloader)
+			for _, target := range targets {
+				downloader, err := ingestLB(target.name, target.sess)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				ingesting[target] = downloader
 			}
 
-			signalCh := make(chan os.Signal)
-			signal.Notify(signalCh, os.Interrupt)
+			// Without explicit names, load balancers are
+			// rediscovered periodically: ones created (or tagged
+			// to match the tag filters) since startup are ingested,
+			// and ones which are gone are stopped.
+			rediscover := func() {
+				lbNames, lbSessions, err := describeLoadBalancers(sessions, tagFilters)
+				if err != nil {
+					logrus.WithField("error", err).Error("Could not rediscover load balancers")
+					return
+				}
 
-			go func() {
-				<-signalCh
-				logrus.Fatal("Exiting due to interrupt.")
-				// TODO(nathanleclaire): Cleanup before
-				// exiting.
-				//
-				// 1. Delete format file, even
-				//    though it's in /tmp.
-				// 2. Also, wait for existing in-flight object
-				//    parsing / sending to finish so that state of
-				//    parsing "cursor" can be written to the JSON
-				//    file.
-			}()
+				found := make(map[lbTarget]bool)
+				for _, lbName := range lbNames {
+					for _, lbSess := range lbSessions[lbName] {
+						target := lbTarget{lbName, lbSess}
+						found[target] = true
+						if ingesting[target] != nil {
+							continue
+						}
+						downloader, err := ingestLB(lbName, lbSess)
+						if err != nil {
+							logrus.WithFields(logrus.Fields{
+								"lbName": lbName,
+								"error":  err,
+							}).Error("Could not ingest newly discovered load balancer")
+							continue
+						}
+						in.Publisher.Markers.LoadBalancerDiscovered(lbName)
+						ingesting[target] = downloader
+					}
+				}
 
-			for {
-				download := <-downloadsCh
-				if err := defaultPublisher.Publish(download); err != nil {
-					logrus.WithFields(logrus.Fields{
-						"object": download,
-						"error":  err,
-					}).Error("Cannot properly publish downloaded object")
+				for target, downloader := range ingesting {
+					if !found[target] {
+						logrus.WithField("lbName", target.name).Info("Load balancer is gone, stopping ingestion")
+						downloader.Stop()
+						delete(ingesting, target)
+					}
 				}
 			}
+
+			// On SIGHUP the --config file is read again and, without
+			// explicit names, load balancers are rediscovered straight
+			// away. Those still being ingested carry on where they
+			// were; only ones ingested from then on get the new load
+			// balancer overrides. The sampling, field and URL rules
+			// apply to every event from then on.
+			if len(args) > 1 {
+				rediscover = nil
+			}
+			return in.Run(in.Rediscover(func() error {
+				return tool.ReloadLBConfig(in.Publisher, &tagFilters)
+			}, rediscover))
 		}
 	}
 
 	return fmt.Errorf("Subcommand %q not recognized", args[0])
 }
 
-func main() {
-	flagParser := flag.NewParser(opt, flag.Default)
-	args, err := flagParser.Parse()
+// cmdVerify checks, before ingesting, that the load balancers can be
+// described, that their access logs are enabled and can be listed and got
+// from the bucket, that the DynamoDB table can be used with --highavail, and
+// that Honeycomb accepts the write key, printing whether each check passed.
+func cmdVerify(sess *session.Session, sessions []*session.Session, tagFilters map[string]string, lbNames []string) error {
+	var checks meta.Checks
+	var allLBNames []string
+	lbSessions := make(map[string][]*session.Session)
+	for _, regionSess := range sessions {
+		names, found, err := describeLoadBalancers([]*session.Session{regionSess}, tagFilters)
+		checks.Add("describe load balancers", aws.StringValue(regionSess.Config.Region), err)
+		for _, lbName := range names {
+			if _, ok := lbSessions[lbName]; !ok {
+				allLBNames = append(allLBNames, lbName)
+			}
+			lbSessions[lbName] = append(lbSessions[lbName], found[lbName]...)
+		}
+	}
+
+	if len(lbNames) == 0 {
+		lbNames = allLBNames
+	}
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		if !ok {
+			checks.Add("find load balancer", lbName, fmt.Errorf("ELB %q not found", lbName))
+			continue
+		}
+		for _, lbSess := range lbSessList {
+			target := aws.StringValue(lbSess.Config.Region) + "/" + lbName
+			bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+			if err == nil && !enabled {
+				err = fmt.Errorf("Access logs are not enabled")
+			}
+			checks.Add("access logs enabled", target, err)
+			if err != nil {
+				continue
+			}
+			logbucket.CheckAccess(lbSess, logbucket.NewELBDownloader(lbSess, bucketName, bucketPrefix, lbName), opt.KMSKeyARN).Record(&checks, target)
+		}
+	}
+
+	if opt.StateBackend == "" && opt.HighAvail {
+		checks.Add("dynamodb table", opt.DynamoTable, state.CheckDynamoDBTable(sess, opt.DynamoTable))
+		if tags, err := meta.ParseAWSTags(opt.AWSTags); err != nil {
+			checks.Add("dynamodb table tags", opt.DynamoTable, err)
+		} else if len(tags) > 0 {
+			checks.Add("dynamodb table tags", opt.DynamoTable, state.CheckDynamoDBTableTags(sess, opt.DynamoTable, tags))
+		}
+	}
+	if opt.NeedsWriteKey() {
+		team, err := publisher.CheckWriteKey(opt)
+		if err == nil {
+			team = " (" + team + ")"
+		}
+		checks.Add("honeycomb write key", opt.APIHost+team, err)
+	}
+
+	if err := checks.Print(os.Stdout); err != nil {
+		return err
+	}
+	if failed := checks.Failed(); failed > 0 {
+		return fmt.Errorf("%d of the checks failed", failed)
+	}
+	return nil
+}
+
+// cmdBootstrap prints the IAM policy, the bucket policy statements and the
+// DynamoDB table needed for ingesting the load balancers' access logs with
+// the options given, in --bootstrap_format. Load balancers without access
+// logs are left out.
+func cmdBootstrap(sess *session.Session, lbNames []string, lbSessions map[string][]*session.Session) error {
+	var deliveries []*logbucket.LogDelivery
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		if !ok {
+			return fmt.Errorf("ELB %q not found", lbName)
+		}
+		for _, lbSess := range lbSessList {
+			bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+			if err != nil {
+				return fmt.Errorf("Could not look up the access logs of %q in %s: %s", lbName, *lbSess.Config.Region, err)
+			}
+			if enabled {
+				deliveries = append(deliveries, logbucket.NewLogDelivery(lbSess, logbucket.AWSElasticLoadBalancing, bucketName, bucketPrefix))
+			}
+		}
+	}
+	if len(deliveries) == 0 {
+		logrus.Warn("None of the load balancers have access logs enabled, so no bucket policies are printed")
+	}
+
+	tags, err := meta.ParseAWSTags(opt.AWSTags)
 	if err != nil {
-		os.Exit(1)
+		return err
 	}
+	metadata := meta.Data(sess)
+	return bootstrap.Generate(bootstrap.Config{
+		Name:       "honeyelb",
+		AccountID:  metadata.AccountID,
+		Region:     metadata.Region,
+		Deliveries: deliveries,
+		Options:    opt,
+		Tags:       tags,
+	}).Write(os.Stdout, opt.BootstrapFormat)
+}
 
-	if opt.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
+// cmdVerifySampling compares the events in the load balancers' logs for the
+// --window with the COUNT Honeycomb has of them.
+func cmdVerifySampling(lbNames []string, lbSessions map[string][]*session.Session) error {
+	// Classic ELB events are logged with the load balancer's name.
+	var lbs []cli.SampledLB
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		if !ok {
+			return fmt.Errorf("ELB %q not found", lbName)
+		}
+		lb := cli.SampledLB{
+			Name:   lbName,
+			Filter: verify.Filter{Column: "elb", Op: "=", Value: lbName},
+		}
+		for _, lbSess := range lbSessList {
+			bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				return fmt.Errorf("access logs are not enabled for ELB %q", lbName)
+			}
+			lb.Logs = append(lb.Logs, cli.SampledLogs{
+				Sess:             lbSess,
+				ObjectDownloader: logbucket.NewELBDownloader(lbSess, bucketName, bucketPrefix, lbName),
+			})
+		}
+		lbs = append(lbs, lb)
 	}
+	return tool.VerifySampling(publisher.NewELBEventParser(opt), lbs)
+}
 
-	formatter := &logrus.TextFormatter{
-		FullTimestamp: true,
+// accessLogBucket returns the bucket and prefix the load balancer's access
+// logs are delivered to, and whether they're enabled.
+func accessLogBucket(lbSess *session.Session, lbName string) (string, string, bool, error) {
+	return meta.ELBAccessLogs(lbSess, lbName)
+}
+
+// enableAccessLogs turns on delivering the load balancer's access logs to the
+// bucket, under the prefix, every 5 minutes.
+func enableAccessLogs(lbSess *session.Session, lbName, bucket, prefix string) error {
+	_, err := elb.New(lbSess, nil).ModifyLoadBalancerAttributes(&elb.ModifyLoadBalancerAttributesInput{
+		LoadBalancerName: aws.String(lbName),
+		LoadBalancerAttributes: &elb.LoadBalancerAttributes{
+			AccessLog: &elb.AccessLog{
+				Enabled:        aws.Bool(true),
+				S3BucketName:   aws.String(bucket),
+				S3BucketPrefix: aws.String(prefix),
+				EmitInterval:   aws.Int64(5),
+			},
+		},
+	})
+	return err
+}
+
+// lbListing returns the load balancer's scheme and access log settings, for
+// ls. Classic load balancers have no state.
+func lbListing(lbSess *session.Session, lbName string) (meta.Listing, error) {
+	l := meta.Listing{Name: lbName}
+	lbResp, err := elb.New(lbSess, nil).DescribeLoadBalancers(&elb.DescribeLoadBalancersInput{
+		LoadBalancerNames: []*string{aws.String(lbName)},
+	})
+	if err != nil {
+		return l, err
 	}
-	logrus.SetFormatter(formatter)
+	if len(lbResp.LoadBalancerDescriptions) > 0 {
+		l.Scheme = aws.StringValue(lbResp.LoadBalancerDescriptions[0].Scheme)
+	}
+	l.Bucket, l.Prefix, l.LoggingEnabled, err = accessLogBucket(lbSess, lbName)
+	return l, err
+}
 
-	logrus.WithField("version", BuildID).Debug("Program starting")
+// validateLB checks that the load balancer's access logs are enabled and that
+// the bucket policy allows them to be delivered, fixing the policy with --fix.
+func validateLB(lbSess *session.Session, lbName string) error {
+	bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("Access logs are not enabled")
+	}
+	return logbucket.NewLogDelivery(lbSess, logbucket.AWSElasticLoadBalancing, bucketName, bucketPrefix).Validate(opt.FixPolicy)
+}
 
-	if opt.Dataset == "aws-$SERVICE-access" {
-		opt.Dataset = "aws-elb-access"
+// enableLogging has the load balancer deliver its access logs to --log_bucket,
+// creating the bucket with --create_bucket, once the bucket policy allows them
+// to be delivered (or is fixed to with --fix).
+func enableLogging(lbSess *session.Session, lbName string) error {
+	logDelivery := logbucket.NewLogDelivery(lbSess, logbucket.AWSElasticLoadBalancing, opt.LogBucket, opt.LogPrefix)
+	if opt.CreateBucket {
+		tags, err := meta.ParseAWSTags(opt.AWSTags)
+		if err != nil {
+			return err
+		}
+		logDelivery.Tags = tags
+		if err := logDelivery.CreateBucket(); err != nil {
+			return err
+		}
+	} else if err := logDelivery.Validate(opt.FixPolicy); err != nil {
+		return err
 	}
+	return enableAccessLogs(lbSess, lbName, opt.LogBucket, opt.LogPrefix)
+}
 
-	if _, err := os.Stat(opt.StateDir); os.IsNotExist(err) {
-		logrus.WithField("dir", opt.StateDir).Fatal("Specified state directory does not exist")
+// describeLoadBalancers returns the names of the load balancers found using
+// each of the sessions, along with the sessions each name belongs to, since
+// load balancers can be spread across accounts and regions. With tag filters,
+// only the load balancers with matching tags are returned.
+func describeLoadBalancers(sessions []*session.Session, tagFilters map[string]string) ([]string, map[string][]*session.Session, error) {
+	var allLBNames []string
+	lbSessions := make(map[string][]*session.Session)
+	err := meta.EachRegion(sessions, func(lbSess *session.Session) error {
+		lbs, err := meta.ELBLoadBalancers(lbSess)
+		if err != nil {
+			return err
+		}
+
+		if len(tagFilters) > 0 {
+			if lbs, err = filterByTags(lbSess, lbs, tagFilters); err != nil {
+				return err
+			}
+		}
+		for _, lb := range lbs {
+			if _, ok := lbSessions[*lb.LoadBalancerName]; !ok {
+				allLBNames = append(allLBNames, *lb.LoadBalancerName)
+			}
+			lbSessions[*lb.LoadBalancerName] = append(lbSessions[*lb.LoadBalancerName], lbSess)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return allLBNames, lbSessions, nil
+}
 
-	if opt.Version {
-		fmt.Println("honeyelb version", versionStr)
-		os.Exit(0)
+func filterByTags(sess *session.Session, lbs []*elb.LoadBalancerDescription, tagFilters map[string]string) ([]*elb.LoadBalancerDescription, error) {
+	names := make([]string, 0, len(lbs))
+	for _, lb := range lbs {
+		names = append(names, *lb.LoadBalancerName)
+	}
+	tags, err := meta.ELBTags(sess, names)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, `Usage: `+os.Args[0]+` [--flags] [ls|ingest] [ELB names...]
+	var matched []*elb.LoadBalancerDescription
+	for _, lb := range lbs {
+		if meta.MatchesTags(tags[*lb.LoadBalancerName], tagFilters) {
+			matched = append(matched, lb)
+		}
+	}
+	return matched, nil
+}
 
-Use '`+os.Args[0]+` --help' to see available flags.`)
-		os.Exit(1)
+func main() {
+	tool = &cli.Command{
+		Name:          "honeyelb",
+		Version:       versionStr,
+		Service:       logbucket.AWSElasticLoadBalancing,
+		Dataset:       "aws-elb-access",
+		NamesEnv:      "HONEYAWS_LBS",
+		Usage:         "[ls|ingest|ingest-file|parse|validate|verify|bootstrap|verify-sampling|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status] [ELB names...]",
+		BucketLogType: publisher.LogTypeELB,
+		EventParser:   func() publisher.EventParser { return publisher.NewELBEventParser(opt) },
+		Opt:           opt,
 	}
+	args := tool.ParseFlags()
+	defer tool.Start(args)()
+	defer crash.Recover()
 
-	if err := cmdELB(args); err != nil {
+	if err := tool.Run(args, cmdELB); err != nil {
 		fmt.Fprintln(os.Stderr, "Error: ", err)
 		os.Exit(1)
 	}
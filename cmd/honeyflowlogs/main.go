@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/globalaccelerator"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	opt        = &options.Options{}
+	tool       *cli.Command
+	BuildID    string
+	versionStr string
+)
+
+func init() {
+	// set the version string to our desired format
+	if BuildID == "" {
+		versionStr = "dev"
+	} else {
+		versionStr = BuildID
+	}
+
+	// init libhoney user agent properly
+	libhoney.UserAgentAddition = "honeyflowlogs/" + versionStr
+}
+
+// flowLogBucket parses the S3 bucket, and prefix, out of a flow log's
+// destination, e.g. arn:aws:s3:::my-bucket/my-prefix.
+func flowLogBucket(destination string) (bucket, prefix string, err error) {
+	const arnPrefix = "arn:aws:s3:::"
+	if !strings.HasPrefix(destination, arnPrefix) {
+		return "", "", fmt.Errorf("%q is not the ARN of an S3 bucket", destination)
+	}
+	path := strings.TrimPrefix(destination, arnPrefix)
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[:i], strings.Trim(path[i+1:], "/"), nil
+	}
+	return path, "", nil
+}
+
+// flowLogSource is a VPC flow log, or with --global_accelerator an
+// accelerator, whose flow logs are delivered to S3.
+type flowLogSource struct {
+	id, bucket, prefix string
+
+	// unsupported says why its flow logs can't be ingested, if they can't.
+	unsupported error
+}
+
+func (s flowLogSource) downloader(sess *session.Session) logbucket.ObjectDownloader {
+	if opt.GlobalAccelerator {
+		return logbucket.NewGlobalAcceleratorDownloader(sess, s.bucket, s.prefix, s.id)
+	}
+	return logbucket.NewFlowLogDownloader(sess, s.bucket, s.prefix, s.id)
+}
+
+// vpcFlowLogSources lists the VPC flow logs in the account and region which
+// are delivered to S3.
+func vpcFlowLogSources(sess *session.Session) ([]flowLogSource, error) {
+	ec2Svc := ec2.New(sess, nil)
+
+	describeFlowLogsInput := &ec2.DescribeFlowLogsInput{
+		Filter: []*ec2.Filter{{
+			Name:   aws.String("log-destination-type"),
+			Values: aws.StringSlice([]string{ec2.LogDestinationTypeS3}),
+		}},
+	}
+
+	var flowLogs []*ec2.FlowLog
+	err := ec2Svc.DescribeFlowLogsPages(describeFlowLogsInput, func(page *ec2.DescribeFlowLogsOutput, lastPage bool) bool {
+		flowLogs = append(flowLogs, page.FlowLogs...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []flowLogSource
+	for _, flowLog := range flowLogs {
+		source := flowLogSource{id: aws.StringValue(flowLog.FlowLogId)}
+		source.bucket, source.prefix, source.unsupported = flowLogBucket(aws.StringValue(flowLog.LogDestination))
+		// Only the text format, in the default partitions, is
+		// supported.
+		if opts := flowLog.DestinationOptions; source.unsupported == nil && opts != nil &&
+			(aws.StringValue(opts.FileFormat) == ec2.DestinationFileFormatParquet ||
+				aws.BoolValue(opts.HiveCompatiblePartitions) ||
+				aws.BoolValue(opts.PerHourPartition)) {
+			source.unsupported = errors.New("flow logs in Parquet, Hive-compatible or hourly partitions are not supported")
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// acceleratorSources lists the Global Accelerator accelerators in the account
+// whose flow logs are enabled. Accelerators are global, and the API is only
+// in us-west-2.
+func acceleratorSources(sess *session.Session) ([]flowLogSource, error) {
+	svc := globalaccelerator.New(sess, aws.NewConfig().WithRegion(logbucket.GlobalAcceleratorRegion))
+
+	var accelerators []*globalaccelerator.Accelerator
+	err := svc.ListAcceleratorsPages(&globalaccelerator.ListAcceleratorsInput{}, func(page *globalaccelerator.ListAcceleratorsOutput, lastPage bool) bool {
+		accelerators = append(accelerators, page.Accelerators...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []flowLogSource
+	for _, accelerator := range accelerators {
+		resp, err := svc.DescribeAcceleratorAttributes(&globalaccelerator.DescribeAcceleratorAttributesInput{
+			AcceleratorArn: accelerator.AcceleratorArn,
+		})
+		if err != nil {
+			return nil, err
+		}
+		attributes := resp.AcceleratorAttributes
+		if attributes == nil || !aws.BoolValue(attributes.FlowLogsEnabled) {
+			continue
+		}
+		sources = append(sources, flowLogSource{
+			id:     acceleratorID(aws.StringValue(accelerator.AcceleratorArn)),
+			bucket: aws.StringValue(attributes.FlowLogsS3Bucket),
+			prefix: strings.Trim(aws.StringValue(attributes.FlowLogsS3Prefix), "/"),
+		})
+	}
+	return sources, nil
+}
+
+// acceleratorID returns the ID its flow logs are named for from an
+// accelerator's ARN, e.g.
+// arn:aws:globalaccelerator::12345:accelerator/1234abcd-abcd-1234-abcd-1234abcdefgh.
+func acceleratorID(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// eventParser returns the parser of the flow logs being ingested.
+func eventParser() publisher.EventParser {
+	if opt.GlobalAccelerator {
+		return publisher.NewGlobalAcceleratorEventParser(opt)
+	}
+	return publisher.NewFlowLogEventParser(opt)
+}
+
+func cmdFlowLogs(args []string) error {
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
+
+	var sources []flowLogSource
+	if opt.GlobalAccelerator {
+		sources, err = acceleratorSources(sess)
+	} else {
+		sources, err = vpcFlowLogSources(sess)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "ls", "list":
+			for _, source := range sources {
+				fmt.Println(source.id)
+			}
+			return nil
+
+		case "ingest":
+			in := tool.NewIngest(sess, eventParser())
+
+			flowLogIDs := args[1:]
+
+			if len(flowLogIDs) > 0 {
+				ingest := make(map[string]bool)
+				for _, id := range flowLogIDs {
+					ingest[id] = true
+				}
+				var listed []flowLogSource
+				for _, source := range sources {
+					if ingest[source.id] {
+						listed = append(listed, source)
+					}
+				}
+				sources = listed
+			}
+
+			if len(sources) == 0 {
+				logrus.Fatal(`No valid flow logs delivered to S3 listed. Try using ls to list available flow logs or refer to the README.`)
+			}
+
+			for _, source := range sources {
+				if source.unsupported != nil {
+					logrus.WithFields(logrus.Fields{
+						"flowLog": source.id,
+						"error":   source.unsupported,
+					}).Error("Could not ingest flow log, skipping")
+					continue
+				}
+
+				logrus.WithFields(logrus.Fields{
+					"flowLog": source.id,
+					"bucket":  source.bucket,
+					"prefix":  source.prefix,
+				}).Info("Flow logs are delivered to S3")
+
+				in.Start(in.NewDownloader(sess, source.downloader(sess)))
+			}
+
+			return in.Run(in.Reload)
+
+		}
+
+	}
+
+	return fmt.Errorf("Subcommand %q not recognized", args[0])
+}
+
+// service is what the state of the flow logs being ingested is kept under.
+func service() string {
+	if opt.GlobalAccelerator {
+		return logbucket.AWSGlobalAccelerator
+	}
+	return logbucket.AWSVPCFlowLogs
+}
+
+func main() {
+	tool = &cli.Command{
+		Name:        "honeyflowlogs",
+		Version:     versionStr,
+		Dataset:     "aws-flowlogs-access",
+		NamesEnv:    "HONEYAWS_FLOW_LOGS",
+		Usage:       "[ls|ingest|ingest-file|parse|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status] [flow log or accelerator IDs...]",
+		EventParser: eventParser,
+		Opt:         opt,
+	}
+	args := tool.ParseFlags()
+	tool.Service = service()
+	if opt.GlobalAccelerator {
+		tool.Dataset = "aws-globalaccelerator-access"
+	}
+	defer tool.Start(args)()
+	defer crash.Recover()
+
+	if err := tool.Run(args, cmdFlowLogs); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/lambdahandler"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/tracing"
+	libhoney "github.com/honeycombio/libhoney-go"
+	flag "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	opt        = &options.Options{}
+	BuildID    string
+	versionStr string
+)
+
+func init() {
+	// set the version string to our desired format
+	if BuildID == "" {
+		versionStr = "dev"
+	} else {
+		versionStr = BuildID
+	}
+
+	// init libhoney user agent properly
+	libhoney.UserAgentAddition = "honeylambda/" + versionStr
+}
+
+func main() {
+	// There is no command line in Lambda, so flags are passed in through
+	// the environment instead, e.g.
+	// HONEYAWS_FLAGS="--writekey=abc123 --samplerate=20"
+	args := strings.Fields(os.Getenv("HONEYAWS_FLAGS"))
+	if _, err := flag.NewParser(opt, flag.Default).ParseArgs(args); err != nil {
+		os.Exit(1)
+	}
+
+	options.SetupLogging(opt)
+	// CloudWatch Logs are searched as JSON whatever --log-format is
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+
+	logrus.WithField("version", BuildID).Debug("Program starting")
+
+	if opt.OTelEndpoint != "" {
+		shutdownTracing, err := tracing.Init(opt.OTelEndpoint, opt.OTelInsecure, "honeylambda", versionStr)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("Could not set up tracing")
+		}
+		defer shutdownTracing()
+	}
+
+	logType := os.Getenv("HONEYAWS_LOG_TYPE")
+
+	if err := logbucket.SetDownloadParts(opt.DownloadPartSize, opt.DownloadPartConc); err != nil {
+		logrus.WithField("error", err).Fatal("Invalid download parts")
+	}
+
+	if opt.Dataset == "aws-$SERVICE-access" {
+		switch logType {
+		case publisher.LogTypeALB, publisher.LogTypeELB:
+			opt.Dataset = "aws-elb-access"
+		default:
+			opt.Dataset = "aws-" + logType + "-access"
+		}
+	}
+
+	if opt.WriteKey == "" && opt.NeedsWriteKey() {
+		logrus.Fatal(`--writekey must be set in HONEYAWS_FLAGS to the proper write key for the Honeycomb team.
+Your write key is available at https://ui.honeycomb.io/account`)
+	}
+
+	sess := session.Must(session.NewSession())
+
+	handler, err := lambdahandler.New(sess, opt, logType)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	lambda.Start(handler.Handle)
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/honeycombio/honeyaws/bootstrap"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/meta"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/state"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	opt        = &options.Options{}
+	tool       *cli.Command
+	BuildID    string
+	versionStr string
+)
+
+func init() {
+	// set the version string to our desired format
+	if BuildID == "" {
+		versionStr = "dev"
+	} else {
+		versionStr = BuildID
+	}
+
+	// init libhoney user agent properly
+	libhoney.UserAgentAddition = "honeynlb/" + versionStr
+}
+
+func cmdNLB(args []string) error {
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
+
+	regions := meta.Regions(opt.Regions, opt.AllRegions, "elasticloadbalancing")
+	sessions := meta.RegionSessions(meta.AssumeRoleSessions(sess, opt.AssumeRoleARNs, meta.SessionName(versionStr)), regions)
+
+	tagFilters, err := meta.ParseTagFilters(opt.TagFilters)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "verify" {
+		// a load balancer which can't be described is reported, along
+		// with the other checks, rather than stopping there
+		return cmdVerify(sess, sessions, tagFilters, args[1:])
+	}
+	allLBNames, lbSessions, err := describeLoadBalancers(sessions, tagFilters)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "ls", "list":
+			var listings []meta.Listing
+			for _, lbName := range allLBNames {
+				lbSessList := lbSessions[lbName]
+				if len(regions) == 0 && opt.ListOutput == "" {
+					// just the name, once
+					lbSessList = lbSessList[:1]
+				}
+				for _, lbSess := range lbSessList {
+					listing := meta.Listing{Name: lbName}
+					if opt.ListOutput != "" {
+						var err error
+						if listing, err = meta.ELBV2Listing(lbSess, lbName); err != nil {
+							listing.Error = err.Error()
+						}
+					}
+					if len(regions) > 0 {
+						listing.Region = *lbSess.Config.Region
+					}
+					listings = append(listings, listing)
+				}
+			}
+
+			return meta.PrintListings(os.Stdout, opt.ListOutput, listings)
+
+		case "validate":
+			lbNames := args[1:]
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
+			}
+
+			failed := 0
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					return fmt.Errorf("NLB %q not found", lbName)
+				}
+				for _, lbSess := range lbSessList {
+					if err := validateLB(lbSess, lbName); err != nil {
+						fmt.Printf("%s\t%s\t%s\n", lbName, *lbSess.Config.Region, err)
+						failed++
+						continue
+					}
+					fmt.Printf("%s\t%s\tok\n", lbName, *lbSess.Config.Region)
+				}
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of the load balancers' access logs may not be delivered", failed)
+			}
+			return nil
+
+		case "bootstrap":
+			lbNames := args[1:]
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
+			}
+			return cmdBootstrap(sess, lbNames, lbSessions)
+
+		case "enable-logging":
+			lbNames := args[1:]
+			if len(lbNames) == 0 || opt.LogBucket == "" {
+				return fmt.Errorf("Usage: %s --log_bucket=<bucket> [--log_prefix=<prefix>] [--create_bucket] enable-logging <lb-name>...", os.Args[0])
+			}
+
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					return fmt.Errorf("NLB %q not found", lbName)
+				}
+				for _, lbSess := range lbSessList {
+					if err := enableLogging(lbSess, lbName); err != nil {
+						return fmt.Errorf("Could not enable access logs for NLB %q in %s: %s", lbName, *lbSess.Config.Region, err)
+					}
+					fmt.Printf("%s\t%s\tenabled\n", lbName, *lbSess.Config.Region)
+				}
+			}
+			return nil
+
+		case "ingest":
+			in := tool.NewIngest(sess, publisher.NewNLBEventParser(opt))
+
+			lbNames := args[1:]
+
+			// Use all available load balancers by default if none
+			// are provided.
+			if len(lbNames) == 0 {
+				lbNames = allLBNames
+			}
+
+			// ingestLB starts downloading the logs of a load
+			// balancer in the background, returning its
+			// downloader.
+			ingestLB := func(lbName string, lbSess *session.Session) (*logbucket.Downloader, error) {
+				logrus.WithFields(logrus.Fields{
+					"lbName": lbName,
+				}).Info("Attempting to ingest NLB")
+
+				bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+				if err != nil {
+					return nil, err
+				}
+
+				if !enabled {
+					return nil, fmt.Errorf(`Access logs are not configured for NLB %q. Please enable them to use the ingest tool.
+
+For reference see this link:
+
+https://docs.aws.amazon.com/elasticloadbalancing/latest/network/load-balancer-access-logs.html#enable-access-logging`, lbName)
+				}
+				logrus.WithFields(logrus.Fields{
+					"bucket": bucketName,
+					"lbName": lbName,
+				}).Info("Access logs are enabled for NLB ♥")
+
+				if opt.CheckPolicy {
+					logDelivery := logbucket.NewLogDelivery(lbSess, logbucket.AWSNetworkLoadBalancing, bucketName, bucketPrefix)
+					if err := logDelivery.Validate(opt.FixPolicy); err != nil {
+						logrus.WithFields(logrus.Fields{
+							"lbName": lbName,
+							"error":  err,
+						}).Error("Access logs may not be delivered")
+					}
+				}
+
+				nlbDownloader := logbucket.NewNLBDownloader(lbSess, bucketName, bucketPrefix, lbName)
+				// the hours of its logs are listed by its ID
+				if opt.ListByHour {
+					if nlbDownloader.LBID, err = meta.ELBV2ID(lbSess, lbName); err != nil {
+						return nil, err
+					}
+				}
+				downloader := in.NewDownloader(lbSess, nlbDownloader)
+				// The region is needed for pricing_region
+				// even when there's only the one.
+				if len(regions) > 0 || opt.CostFields {
+					downloader.Fields = map[string]interface{}{"aws_region": *lbSess.Config.Region}
+				}
+				downloader.Fields = opt.LBFields(lbName, downloader.Fields)
+				in.Start(downloader)
+				return downloader, nil
+			}
+
+			type lbTarget struct {
+				name string
+				sess *session.Session
+			}
+			var targets []lbTarget
+			for _, lbName := range lbNames {
+				lbSessList, ok := lbSessions[lbName]
+				if !ok {
+					fmt.Fprintf(os.Stderr, "Load balancer %q not found. Try using ls to list available load balancers.\n", lbName)
+					os.Exit(1)
+				}
+				for _, lbSess := range lbSessList {
+					targets = append(targets, lbTarget{lbName, lbSess})
+				}
+			}
+
+			// For now, just run one goroutine per-LB
+			ingesting := make(map[lbTarget]*logbucket.Downloader)
+			for _, target := range targets {
+				downloader, err := ingestLB(target.name, target.sess)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				ingesting[target] = downloader
+			}
+
+			// Without explicit names, load balancers are
+			// rediscovered periodically: ones created (or tagged
+			// to match the tag filters) since startup are ingested,
+			// and ones which are gone are stopped.
+			rediscover := func() {
+				lbNames, lbSessions, err := describeLoadBalancers(sessions, tagFilters)
+				if err != nil {
+					logrus.WithField("error", err).Error("Could not rediscover load balancers")
+					return
+				}
+
+				found := make(map[lbTarget]bool)
+				for _, lbName := range lbNames {
+					for _, lbSess := range lbSessions[lbName] {
+						target := lbTarget{lbName, lbSess}
+						found[target] = true
+						if ingesting[target] != nil {
+							continue
+						}
+						downloader, err := ingestLB(lbName, lbSess)
+						if err != nil {
+							logrus.WithFields(logrus.Fields{
+								"lbName": lbName,
+								"error":  err,
+							}).Error("Could not ingest newly discovered load balancer")
+							continue
+						}
+						in.Publisher.Markers.LoadBalancerDiscovered(lbName)
+						ingesting[target] = downloader
+					}
+				}
+
+				for target, downloader := range ingesting {
+					if !found[target] {
+						logrus.WithField("lbName", target.name).Info("Load balancer is gone, stopping ingestion")
+						downloader.Stop()
+						delete(ingesting, target)
+					}
+				}
+			}
+
+			// On SIGHUP the --config file is read again and, without
+			// explicit names, load balancers are rediscovered straight
+			// away. Those still being ingested carry on where they
+			// were; only ones ingested from then on get the new load
+			// balancer overrides. The sampling, field and URL rules
+			// apply to every event from then on.
+			if len(args) > 1 {
+				rediscover = nil
+			}
+			return in.Run(in.Rediscover(func() error {
+				return tool.ReloadLBConfig(in.Publisher, &tagFilters)
+			}, rediscover))
+		}
+	}
+
+	return fmt.Errorf("Subcommand %q not recognized", args[0])
+}
+
+// cmdVerify checks, before ingesting, that the load balancers can be
+// described, that their access logs are enabled and can be listed and got
+// from the bucket, that the DynamoDB table can be used with --highavail, and
+// that Honeycomb accepts the write key, printing whether each check passed.
+func cmdVerify(sess *session.Session, sessions []*session.Session, tagFilters map[string]string, lbNames []string) error {
+	var checks meta.Checks
+	var allLBNames []string
+	lbSessions := make(map[string][]*session.Session)
+	for _, regionSess := range sessions {
+		names, found, err := describeLoadBalancers([]*session.Session{regionSess}, tagFilters)
+		checks.Add("describe load balancers", aws.StringValue(regionSess.Config.Region), err)
+		for _, lbName := range names {
+			if _, ok := lbSessions[lbName]; !ok {
+				allLBNames = append(allLBNames, lbName)
+			}
+			lbSessions[lbName] = append(lbSessions[lbName], found[lbName]...)
+		}
+	}
+
+	if len(lbNames) == 0 {
+		lbNames = allLBNames
+	}
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		if !ok {
+			checks.Add("find load balancer", lbName, fmt.Errorf("NLB %q not found", lbName))
+			continue
+		}
+		for _, lbSess := range lbSessList {
+			target := aws.StringValue(lbSess.Config.Region) + "/" + lbName
+			bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+			if err == nil && !enabled {
+				err = fmt.Errorf("Access logs are not enabled")
+			}
+			checks.Add("access logs enabled", target, err)
+			if err != nil {
+				continue
+			}
+			logbucket.CheckAccess(lbSess, logbucket.NewNLBDownloader(lbSess, bucketName, bucketPrefix, lbName), opt.KMSKeyARN).Record(&checks, target)
+		}
+	}
+
+	if opt.StateBackend == "" && opt.HighAvail {
+		checks.Add("dynamodb table", opt.DynamoTable, state.CheckDynamoDBTable(sess, opt.DynamoTable))
+		if tags, err := meta.ParseAWSTags(opt.AWSTags); err != nil {
+			checks.Add("dynamodb table tags", opt.DynamoTable, err)
+		} else if len(tags) > 0 {
+			checks.Add("dynamodb table tags", opt.DynamoTable, state.CheckDynamoDBTableTags(sess, opt.DynamoTable, tags))
+		}
+	}
+	if opt.NeedsWriteKey() {
+		team, err := publisher.CheckWriteKey(opt)
+		if err == nil {
+			team = " (" + team + ")"
+		}
+		checks.Add("honeycomb write key", opt.APIHost+team, err)
+	}
+
+	if err := checks.Print(os.Stdout); err != nil {
+		return err
+	}
+	if failed := checks.Failed(); failed > 0 {
+		return fmt.Errorf("%d of the checks failed", failed)
+	}
+	return nil
+}
+
+// cmdBootstrap prints the IAM policy, the bucket policy statements and the
+// DynamoDB table needed for ingesting the load balancers' access logs with
+// the options given, in --bootstrap_format. Load balancers without access
+// logs are left out.
+func cmdBootstrap(sess *session.Session, lbNames []string, lbSessions map[string][]*session.Session) error {
+	var deliveries []*logbucket.LogDelivery
+	for _, lbName := range lbNames {
+		lbSessList, ok := lbSessions[lbName]
+		if !ok {
+			return fmt.Errorf("NLB %q not found", lbName)
+		}
+		for _, lbSess := range lbSessList {
+			bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+			if err != nil {
+				return fmt.Errorf("Could not look up the access logs of %q in %s: %s", lbName, *lbSess.Config.Region, err)
+			}
+			if enabled {
+				deliveries = append(deliveries, logbucket.NewLogDelivery(lbSess, logbucket.AWSNetworkLoadBalancing, bucketName, bucketPrefix))
+			}
+		}
+	}
+	if len(deliveries) == 0 {
+		logrus.Warn("None of the load balancers have access logs enabled, so no bucket policies are printed")
+	}
+
+	tags, err := meta.ParseAWSTags(opt.AWSTags)
+	if err != nil {
+		return err
+	}
+	metadata := meta.Data(sess)
+	return bootstrap.Generate(bootstrap.Config{
+		Name:       "honeynlb",
+		AccountID:  metadata.AccountID,
+		Region:     metadata.Region,
+		Deliveries: deliveries,
+		Options:    opt,
+		Tags:       tags,
+	}).Write(os.Stdout, opt.BootstrapFormat)
+}
+
+// accessLogBucket returns the bucket and prefix the load balancer's access
+// logs are delivered to, and whether they're enabled.
+func accessLogBucket(lbSess *session.Session, lbName string) (string, string, bool, error) {
+	return meta.ELBV2AccessLogs(lbSess, lbName)
+}
+
+// validateLB checks that the load balancer's access logs are enabled and that
+// the bucket policy allows them to be delivered, fixing the policy with --fix.
+func validateLB(lbSess *session.Session, lbName string) error {
+	bucketName, bucketPrefix, enabled, err := accessLogBucket(lbSess, lbName)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("Access logs are not enabled")
+	}
+	return logbucket.NewLogDelivery(lbSess, logbucket.AWSNetworkLoadBalancing, bucketName, bucketPrefix).Validate(opt.FixPolicy)
+}
+
+// enableLogging has the load balancer deliver its access logs to --log_bucket,
+// creating the bucket with --create_bucket, once the bucket policy allows them
+// to be delivered (or is fixed to with --fix).
+func enableLogging(lbSess *session.Session, lbName string) error {
+	logDelivery := logbucket.NewLogDelivery(lbSess, logbucket.AWSNetworkLoadBalancing, opt.LogBucket, opt.LogPrefix)
+	if opt.CreateBucket {
+		tags, err := meta.ParseAWSTags(opt.AWSTags)
+		if err != nil {
+			return err
+		}
+		logDelivery.Tags = tags
+		if err := logDelivery.CreateBucket(); err != nil {
+			return err
+		}
+	} else if err := logDelivery.Validate(opt.FixPolicy); err != nil {
+		return err
+	}
+	return meta.EnableELBV2AccessLogs(lbSess, lbName, opt.LogBucket, opt.LogPrefix)
+}
+
+// describeLoadBalancers returns the names of the load balancers found using
+// each of the sessions, along with the sessions each name belongs to, since
+// load balancers can be spread across accounts and regions. With tag filters,
+// only the load balancers with matching tags are returned.
+func describeLoadBalancers(sessions []*session.Session, tagFilters map[string]string) ([]string, map[string][]*session.Session, error) {
+	var allLBNames []string
+	lbSessions := make(map[string][]*session.Session)
+	err := meta.EachRegion(sessions, func(lbSess *session.Session) error {
+		lbs, err := meta.ELBV2LoadBalancers(lbSess)
+		if err != nil {
+			return err
+		}
+
+		if len(tagFilters) > 0 {
+			if lbs, err = filterByTags(lbSess, lbs, tagFilters); err != nil {
+				return err
+			}
+		}
+
+		// The elbv2 API returns application and network load
+		// balancers alike, so only keep the network ones around.
+		for _, lb := range lbs {
+			if aws.StringValue(lb.Type) == elbv2.LoadBalancerTypeEnumNetwork {
+				if _, ok := lbSessions[*lb.LoadBalancerName]; !ok {
+					allLBNames = append(allLBNames, *lb.LoadBalancerName)
+				}
+				lbSessions[*lb.LoadBalancerName] = append(lbSessions[*lb.LoadBalancerName], lbSess)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return allLBNames, lbSessions, nil
+}
+
+func filterByTags(sess *session.Session, lbs []*elbv2.LoadBalancer, tagFilters map[string]string) ([]*elbv2.LoadBalancer, error) {
+	arns := make([]string, 0, len(lbs))
+	for _, lb := range lbs {
+		arns = append(arns, *lb.LoadBalancerArn)
+	}
+	tags, err := meta.ELBV2Tags(sess, arns)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*elbv2.LoadBalancer
+	for _, lb := range lbs {
+		if meta.MatchesTags(tags[*lb.LoadBalancerArn], tagFilters) {
+			matched = append(matched, lb)
+		}
+	}
+	return matched, nil
+}
+
+func main() {
+	tool = &cli.Command{
+		Name:          "honeynlb",
+		Version:       versionStr,
+		Service:       logbucket.AWSNetworkLoadBalancing,
+		Dataset:       "aws-nlb-access",
+		NamesEnv:      "HONEYAWS_LBS",
+		Usage:         "[ls|ingest|ingest-file|parse|validate|verify|bootstrap|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status] [NLB names...]",
+		BucketLogType: publisher.LogTypeNLB,
+		EventParser:   func() publisher.EventParser { return publisher.NewNLBEventParser(opt) },
+		Opt:           opt,
+	}
+	args := tool.ParseFlags()
+	defer tool.Start(args)()
+	defer crash.Recover()
+
+	if err := tool.Run(args, cmdNLB); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		os.Exit(1)
+	}
+}
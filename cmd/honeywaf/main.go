@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/wafv2"
+	"github.com/honeycombio/honeyaws/cli"
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	opt        = &options.Options{}
+	tool       *cli.Command
+	BuildID    string
+	versionStr string
+)
+
+func init() {
+	// set the version string to our desired format
+	if BuildID == "" {
+		versionStr = "dev"
+	} else {
+		versionStr = BuildID
+	}
+
+	// init libhoney user agent properly
+	libhoney.UserAgentAddition = "honeywaf/" + versionStr
+}
+
+// webACL is a web ACL whose logs are delivered to S3, directly or by a
+// Firehose delivery stream.
+type webACL struct {
+	name             string
+	bucket, prefix   string
+	firehose, global bool
+}
+
+// listWebACLs lists the web ACLs which log to S3: the regional ones, and in
+// us-east-1 those of CloudFront distributions too.
+func listWebACLs(sess *session.Session) ([]webACL, error) {
+	wafSvc := wafv2.New(sess)
+	scopes := []string{wafv2.ScopeRegional}
+	if aws.StringValue(sess.Config.Region) == "us-east-1" {
+		scopes = append(scopes, wafv2.ScopeCloudfront)
+	}
+
+	var acls []webACL
+	for _, scope := range scopes {
+		input := &wafv2.ListWebACLsInput{Scope: aws.String(scope)}
+		for {
+			page, err := wafSvc.ListWebACLs(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, summary := range page.WebACLs {
+				logging, err := wafSvc.GetLoggingConfiguration(&wafv2.GetLoggingConfigurationInput{
+					ResourceArn: summary.ARN,
+				})
+				if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == wafv2.ErrCodeWAFNonexistentItemException {
+					// logging isn't enabled
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				for _, destination := range logging.LoggingConfiguration.LogDestinationConfigs {
+					acl := webACL{name: *summary.Name, global: scope == wafv2.ScopeCloudfront}
+					if bucket, prefix, ok := logbucket.BucketARN(*destination); ok {
+						acl.bucket, acl.prefix = bucket, prefix
+					} else if logbucket.IsFirehoseARN(*destination) {
+						bucket, prefix, err := logbucket.FirehoseBucket(sess, *destination)
+						if err != nil {
+							logrus.WithFields(logrus.Fields{
+								"webACL": *summary.Name,
+								"error":  err,
+							}).Error("Could not find the S3 bucket of web ACL, skipping")
+							continue
+						}
+						acl.bucket, acl.prefix, acl.firehose = bucket, prefix, true
+					} else {
+						// e.g. a CloudWatch Logs log group
+						continue
+					}
+					acls = append(acls, acl)
+				}
+			}
+			if page.NextMarker == nil || *page.NextMarker == "" {
+				break
+			}
+			input.NextMarker = page.NextMarker
+		}
+	}
+	return acls, nil
+}
+
+func cmdWAF(args []string) error {
+	sess, err := tool.S3Session()
+	if err != nil {
+		return err
+	}
+
+	acls, err := listWebACLs(sess)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "ls", "list":
+			for _, acl := range acls {
+				fmt.Println(acl.name)
+			}
+			return nil
+
+		case "ingest":
+			in := tool.NewIngest(sess, publisher.NewWAFEventParser(opt))
+
+			aclNames := args[1:]
+
+			if len(aclNames) > 0 {
+				ingest := make(map[string]bool)
+				for _, name := range aclNames {
+					ingest[name] = true
+				}
+				var listed []webACL
+				for _, acl := range acls {
+					if ingest[acl.name] {
+						listed = append(listed, acl)
+					}
+				}
+				acls = listed
+			}
+
+			if len(acls) == 0 {
+				logrus.Fatal(`No valid web ACLs logging to S3 listed. Try using ls to list available web ACLs or refer to the README.`)
+			}
+
+			for _, acl := range acls {
+				logrus.WithFields(logrus.Fields{
+					"webACL":   acl.name,
+					"bucket":   acl.bucket,
+					"prefix":   acl.prefix,
+					"firehose": acl.firehose,
+				}).Info("WAF logs are delivered to S3")
+
+				var objectDownloader logbucket.ObjectDownloader
+				if acl.firehose {
+					objectDownloader = logbucket.NewFirehoseDownloader(acl.bucket, acl.prefix, acl.name)
+				} else {
+					wafDownloader := logbucket.NewWAFDownloader(sess, acl.bucket, acl.prefix, acl.name)
+					if acl.global {
+						wafDownloader.Region = logbucket.WAFCloudFrontRegion
+					}
+					objectDownloader = wafDownloader
+				}
+				in.Start(in.NewDownloader(sess, objectDownloader))
+			}
+
+			return in.Run(in.Reload)
+
+		}
+
+	}
+
+	return fmt.Errorf("Subcommand %q not recognized", args[0])
+}
+
+func main() {
+	tool = &cli.Command{
+		Name:        "honeywaf",
+		Version:     versionStr,
+		Service:     logbucket.AWSWAFLogs,
+		Dataset:     "aws-waf-access",
+		NamesEnv:    "HONEYAWS_WEB_ACLS",
+		Usage:       "[ls|ingest|ingest-file|parse|validate-config|state cleanup|state dead-letters|state missing|state list|state show|state reset|replay lines|replay objects|status] [web ACL names...]",
+		EventParser: func() publisher.EventParser { return publisher.NewWAFEventParser(opt) },
+		Opt:         opt,
+	}
+	args := tool.ParseFlags()
+	defer tool.Start(args)()
+	defer crash.Recover()
+
+	if err := tool.Run(args, cmdWAF); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,223 @@
+// Package crash writes a report when the agent dies of a fatal error or a
+// panic, after flushing what it safely can, so that post-mortems of agent
+// crashes have something to start from.
+package crash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	reportFileFormat = "%s-crash-%s.json"
+	// Stacks of every goroutine are kept up to this size.
+	maxStackSize = 1 << 20
+	// Honeycomb truncates string fields longer than this.
+	maxFieldSize = 64 << 10
+)
+
+// Flusher is what's flushed before the process dies, and reported on: a
+// publisher.
+type Flusher interface {
+	// InFlight returns the objects being published, and when each started.
+	InFlight() map[string]time.Time
+
+	// FlushState sends what can be sent, and records how far publishing
+	// has got.
+	FlushState()
+}
+
+// Report is what's known about a crash.
+type Report struct {
+	Time       time.Time              `json:"time"`
+	Program    string                 `json:"program"`
+	Version    string                 `json:"version"`
+	Reason     string                 `json:"reason"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+	ConfigHash string                 `json:"config_hash"`
+	InFlight   map[string]time.Time   `json:"in_flight"`
+	Stack      string                 `json:"stack"`
+}
+
+var (
+	mu         sync.Mutex
+	program    string
+	version    string
+	dir        string
+	dataset    string
+	configHash string
+	flushers   []Flusher
+	once       sync.Once
+)
+
+// ConfigHash identifies the configuration, e.g. to tell whether crashing
+// agents were configured alike, without the report containing any of it.
+func ConfigHash(config interface{}) string {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Init sets up reports of the program crashing to be written to dir, and to
+// be sent to the Honeycomb dataset too if it's set. Fatal errors are reported
+// from then on, and panics in main by deferring Recover.
+func Init(prog, ver, reportDir, reportDataset string, config interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	program, version, dir, dataset = prog, ver, reportDir, reportDataset
+	configHash = ConfigHash(config)
+	logrus.AddHook(hook{})
+}
+
+// Watch registers a publisher to be flushed, and its objects in flight
+// reported, should the process die. Until there is one, the agent hasn't got
+// as far as ingesting anything, so fatal errors (e.g. bad flags) aren't
+// reported as crashes.
+func Watch(f Flusher) {
+	mu.Lock()
+	defer mu.Unlock()
+	flushers = append(flushers, f)
+}
+
+func watched() []Flusher {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Flusher(nil), flushers...)
+}
+
+// Flush flushes every watched publisher, for exiting on purpose, e.g. on
+// interrupt, which isn't a crash.
+func Flush() {
+	for _, f := range watched() {
+		f.FlushState()
+	}
+}
+
+// Recover reports a panic, before carrying on panicking. It's to be deferred
+// in main, since panics can't be recovered from in other goroutines (the
+// downloaders and publishers recover from their own).
+func Recover() {
+	if r := recover(); r != nil {
+		report(fmt.Sprintf("panic: %v", r), nil)
+		panic(r)
+	}
+}
+
+type hook struct{}
+
+func (hook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel}
+}
+
+func (hook) Fire(entry *logrus.Entry) error {
+	fields := make(map[string]interface{}, len(entry.Data))
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		fields[k] = v
+	}
+	report(entry.Message, fields)
+	return nil
+}
+
+// report writes the report of the crash, once, after flushing the publishers.
+func report(reason string, fields map[string]interface{}) {
+	fs := watched()
+	if len(fs) == 0 {
+		return
+	}
+
+	once.Do(func() {
+		stack := make([]byte, maxStackSize)
+		stack = stack[:runtime.Stack(stack, true)]
+
+		mu.Lock()
+		r := Report{
+			Time:       time.Now().UTC(),
+			Program:    program,
+			Version:    version,
+			Reason:     reason,
+			Fields:     fields,
+			ConfigHash: configHash,
+			InFlight:   make(map[string]time.Time),
+			Stack:      string(stack),
+		}
+		reportDir, reportDataset := dir, dataset
+		mu.Unlock()
+
+		for _, f := range fs {
+			for obj, since := range f.InFlight() {
+				r.InFlight[obj] = since
+			}
+		}
+
+		filename := filepath.Join(reportDir, fmt.Sprintf(reportFileFormat, r.Program, r.Time.Format("20060102T150405Z")))
+		if err := writeReport(filename, r); err != nil {
+			logrus.WithField("error", err).Error("Could not write crash report")
+		} else {
+			logrus.WithField("file", filename).Error("Wrote crash report")
+		}
+
+		for _, f := range fs {
+			f.FlushState()
+		}
+
+		if reportDataset != "" {
+			sendReport(reportDataset, r)
+		}
+	})
+}
+
+func writeReport(filename string, r Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+func truncate(s string) string {
+	if len(s) > maxFieldSize {
+		return s[:maxFieldSize]
+	}
+	return s
+}
+
+// sendReport sends the report as an event, using libhoney as the publishers
+// have set it up.
+func sendReport(dataset string, r Report) {
+	inFlight, _ := json.Marshal(r.InFlight)
+
+	ev := libhoney.NewEvent()
+	ev.Dataset = dataset
+	ev.Timestamp = r.Time
+	ev.AddField("program", r.Program)
+	ev.AddField("version", r.Version)
+	ev.AddField("crash.reason", r.Reason)
+	ev.AddField("crash.config_hash", r.ConfigHash)
+	ev.AddField("crash.in_flight", string(inFlight))
+	ev.AddField("crash.in_flight_count", len(r.InFlight))
+	ev.AddField("crash.stack", truncate(r.Stack))
+	for k, v := range r.Fields {
+		ev.AddField("crash.fields."+k, v)
+	}
+	if err := ev.Send(); err != nil {
+		logrus.WithField("error", err).Error("Could not send crash report")
+		return
+	}
+	libhoney.Flush()
+}
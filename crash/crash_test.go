@@ -0,0 +1,70 @@
+package crash
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testFlusher struct {
+	inFlight map[string]time.Time
+	flushed  int
+}
+
+func (f *testFlusher) InFlight() map[string]time.Time { return f.inFlight }
+func (f *testFlusher) FlushState()                    { f.flushed++ }
+
+func TestReport(t *testing.T) {
+	reportDir, err := ioutil.TempDir("", "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(reportDir)
+
+	Init("honeytest", "dev", reportDir, "", map[string]string{"dataset": "aws-alb-access"})
+
+	// Nothing's reported until there's a publisher.
+	report("bad flags", nil)
+	if files, _ := ioutil.ReadDir(reportDir); len(files) != 0 {
+		t.Fatalf("expected no report before a publisher is watched, got %d", len(files))
+	}
+
+	since := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	f := &testFlusher{inFlight: map[string]time.Time{"AWSLogs/1.log.gz": since}}
+	Watch(f)
+	report("Could not publish", map[string]interface{}{"object": "AWSLogs/1.log.gz"})
+	report("again", nil)
+
+	files, err := filepath.Glob(filepath.Join(reportDir, "honeytest-crash-*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one crash report, got %v (%v)", files, err)
+	}
+	if f.flushed != 1 {
+		t.Errorf("expected the publisher to be flushed once, got %d", f.flushed)
+	}
+
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Program != "honeytest" || r.Reason != "Could not publish" || r.Fields["object"] != "AWSLogs/1.log.gz" {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if !r.InFlight["AWSLogs/1.log.gz"].Equal(since) {
+		t.Errorf("expected the object in flight to be reported, got %v", r.InFlight)
+	}
+	if r.ConfigHash != ConfigHash(map[string]string{"dataset": "aws-alb-access"}) || len(r.ConfigHash) != 12 {
+		t.Errorf("unexpected config hash %q", r.ConfigHash)
+	}
+	if !strings.Contains(r.Stack, "TestReport") {
+		t.Error("expected the stack to be reported")
+	}
+}
@@ -0,0 +1,222 @@
+// Package daemon makes ingesting behave under a supervisor such as systemd:
+// it writes a PID file, tells systemd when the agent is ready, reloading and
+// stopping, pings the systemd watchdog while the health checks pass, and
+// hands SIGHUP, or a POST to /reload on the admin address, to a reload
+// function instead of letting it kill the process.
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeyaws/health"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	mu      sync.Mutex
+	pidFile string
+	reload  func() error
+
+	// reloading serializes reloads, so a SIGHUP and a POST to /reload at
+	// once don't apply half of each.
+	reloading sync.Mutex
+)
+
+// Start writes the PID file, if there is one, has SIGHUP call reload, starts
+// pinging the watchdog if systemd asked for it, and tells systemd the agent
+// is ready. A nil reload means there's nothing to reload, and SIGHUP is
+// ignored.
+func Start(path string, reloadFunc func() error) error {
+	if path != "" {
+		if err := writePIDFile(path); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	pidFile = path
+	reload = reloadFunc
+	mu.Unlock()
+
+	hup := make(chan os.Signal, 1)
+	// with no signals, Notify would relay every one
+	if len(reloadSignals) > 0 {
+		signal.Notify(hup, reloadSignals...)
+	}
+	go handleReloads(hup)
+
+	if interval := watchdogInterval(); interval > 0 {
+		go watchdog(interval)
+	}
+
+	if err := Notify("READY=1"); err != nil {
+		logrus.WithField("error", err).Warn("Could not tell systemd the agent is ready")
+	}
+	return nil
+}
+
+// Stop tells systemd the agent is stopping, and removes the PID file. It's
+// safe to call more than once.
+func Stop() {
+	if err := Notify("STOPPING=1"); err != nil {
+		logrus.WithField("error", err).Debug("Could not tell systemd the agent is stopping")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if pidFile == "" {
+		return
+	}
+	// Only our own, in case another instance has since taken it over.
+	if pid, err := readPIDFile(pidFile); err == nil && pid == os.Getpid() {
+		os.Remove(pidFile)
+	}
+	pidFile = ""
+}
+
+func handleReloads(hup <-chan os.Signal) {
+	for range hup {
+		logrus.Info("Reloading due to SIGHUP")
+		if err := Reload(); err != nil {
+			logrus.WithField("error", err).Error("Could not reload, carrying on as before")
+		}
+	}
+}
+
+// Reload calls the reload function given to Start, telling systemd the agent
+// is reloading while it does. It's an error if there's nothing to reload.
+func Reload() error {
+	mu.Lock()
+	r := reload
+	mu.Unlock()
+	if r == nil {
+		return fmt.Errorf("there's nothing to reload while ingesting these logs")
+	}
+
+	reloading.Lock()
+	defer reloading.Unlock()
+	Notify("RELOADING=1")
+	defer Notify("READY=1")
+	return r()
+}
+
+// ServeAdmin serves /reload on the given address, e.g. "localhost:6061",
+// which reloads on a POST the way SIGHUP does, for when sending a signal
+// isn't an option, e.g. from another container of the pod.
+func ServeAdmin(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reload", serveReload)
+
+	logrus.WithField("addr", addr).Info("Serving the admin endpoints")
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logrus.WithField("error", err).Fatal("Could not serve the admin endpoints")
+		}
+	}()
+}
+
+func serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "reload with a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	logrus.Info("Reloading due to a POST to /reload")
+	if err := Reload(); err != nil {
+		logrus.WithField("error", err).Error("Could not reload, carrying on as before")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Notify sends the state, e.g. READY=1, to systemd, if it's supervising the
+// agent with Type=notify. Otherwise it does nothing.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// systemd may use an abstract socket, written with a leading @.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often to ping the watchdog: half the
+// WatchdogSec systemd gave, so a ping late by a little isn't a restart. It's
+// 0 without a watchdog, or if the watchdog is for another process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// watchdog pings the systemd watchdog while the liveness checks pass, so that
+// systemd restarts the agent when the pipeline wedges, the way an
+// orchestrator failing /healthz would.
+func watchdog(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := health.Alive(); err != nil {
+			logrus.WithField("error", err).Warn("Not pinging the systemd watchdog, the agent isn't making progress")
+			continue
+		}
+		if err := Notify("WATCHDOG=1"); err != nil {
+			logrus.WithField("error", err).Warn("Could not ping the systemd watchdog")
+		}
+	}
+}
+
+// writePIDFile writes the process's PID to the file, unless it has the PID
+// of another process which is still running, e.g. a second instance started
+// with the same --statedir by mistake.
+func writePIDFile(path string) error {
+	if pid, err := readPIDFile(path); err == nil && pid != os.Getpid() && running(pid) {
+		return fmt.Errorf("%s: already running as PID %d", path, pid)
+	}
+	// Written alongside and renamed, so it's never read half written.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintf(tmp, "%d\n", os.Getpid()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readPIDFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
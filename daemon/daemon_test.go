@@ -0,0 +1,127 @@
+package daemon
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	if err := Notify("READY=1"); err != nil {
+		t.Errorf("expected nothing to be done without systemd, got %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if err := Notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("expected systemd to be told READY=1, got %q", buf[:n])
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	if interval := watchdogInterval(); interval != 0 {
+		t.Errorf("expected no watchdog by default, got %s", interval)
+	}
+	os.Setenv("WATCHDOG_USEC", "60000000")
+	if interval := watchdogInterval(); interval != 30*time.Second {
+		t.Errorf("expected pings at half the watchdog timeout, got %s", interval)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if interval := watchdogInterval(); interval != 0 {
+		t.Errorf("expected another process's watchdog to be left alone, got %s", interval)
+	}
+}
+
+func TestPIDFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "honeyalb.pid")
+
+	if err := Start(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := readPIDFile(path); err != nil || pid != os.Getpid() {
+		t.Errorf("expected the PID file to have our PID, got %d %v", pid, err)
+	}
+	Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the PID file to be removed, got %v", err)
+	}
+
+	// PID 1 is always running
+	if err := ioutil.WriteFile(path, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePIDFile(path); err == nil {
+		t.Error("expected a PID file of a running process not to be overwritten")
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	defer Stop()
+	post := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serveReload(w, httptest.NewRequest(http.MethodPost, "/reload", nil))
+		return w
+	}
+
+	if err := Start("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if w := post(); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected a reload with nothing to reload to fail, got %d", w.Code)
+	}
+
+	reloads := 0
+	var reloadErr error
+	if err := Start("", func() error { reloads++; return reloadErr }); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	serveReload(w, httptest.NewRequest(http.MethodGet, "/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed || reloads != 0 {
+		t.Errorf("expected a GET not to reload, got %d", w.Code)
+	}
+	if w := post(); w.Code != http.StatusOK || reloads != 1 {
+		t.Errorf("expected a POST to reload, got %d", w.Code)
+	}
+	reloadErr = errors.New("invalid --sample-rate-by-status")
+	if w := post(); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "--sample-rate-by-status") {
+		t.Errorf("expected a failed reload to return the error, got %d %q", w.Code, w.Body.String())
+	}
+}
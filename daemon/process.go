@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// reloadSignals are what reload the agent.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// running reports whether the process with the PID is still running.
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// a process of another user's can't be signalled, but is running
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
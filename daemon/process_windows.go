@@ -0,0 +1,30 @@
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are what reload the agent. Windows has no SIGHUP, so there's
+// nothing to reload it with.
+var reloadSignals []os.Signal
+
+// stillActive is the exit code of a process which hasn't exited yet.
+const stillActive = 259
+
+// running reports whether the process with the PID is still running. Windows
+// processes can't be sent signal 0, so it's asked for the process's exit code
+// instead.
+func running(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// a process of another user's can't be opened, but is running
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
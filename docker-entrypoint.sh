@@ -0,0 +1,26 @@
+#!/bin/sh
+
+# Entrypoint for the container image, which has every tool in it. Runs the
+# tool named by the first argument, or by HONEYAWS_TOOL if there isn't one,
+# passing along the rest of the arguments. With no arguments at all, the
+# tools read their flags, subcommand and names from the environment.
+set -e
+
+tools="honeyelb honeyalb honeynlb honeycloudfront honeycloudtrail honeyflowlogs honeywaf honeyapigateway"
+
+for tool in $tools; do
+    if [ "$1" = "$tool" ]; then
+        shift
+        exec "/usr/bin/$tool" "$@"
+    fi
+done
+
+for tool in $tools; do
+    if [ "$HONEYAWS_TOOL" = "$tool" ]; then
+        exec "/usr/bin/$tool" "$@"
+    fi
+done
+
+echo "Usage: docker-entrypoint.sh [TOOL] [--flags] [ls|ingest] [names...]" >&2
+echo "TOOL (or HONEYAWS_TOOL) must be one of: $tools" >&2
+exit 2
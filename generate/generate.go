@@ -0,0 +1,282 @@
+// Package generate synthesizes load balancer access logs, for demos, load
+// testing, and trying out new settings without touching real traffic.
+// Everything in them is made up: clients are from the documentation address
+// ranges (RFC 5737), and the load balancer, targets and traces are random.
+package generate
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// The z-score of the 99th percentile of a normal distribution, for turning
+// a median and p99 into a log-normal latency distribution.
+const p99Z = 2.326
+
+var (
+	defaultRoutes = []string{
+		"GET /=10",
+		"GET /api/users/:id=30",
+		"POST /api/users=5",
+		"GET /api/orders/:id=20",
+		"PUT /api/orders/:id=5",
+		"GET /static/app.js=20",
+		"GET /healthz=10",
+	}
+	clientRanges = []string{"192.0.2.", "198.51.100.", "203.0.113."}
+	userAgents   = []string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.1 Safari/605.1.15",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/537.36",
+		"curl/7.85.0",
+		"ELB-HealthChecker/2.0",
+	}
+)
+
+type route struct {
+	method, path string
+}
+
+type weighted struct {
+	weight int
+	value  interface{}
+}
+
+// pick returns the value of one of the choices, in proportion to its weight.
+func pick(r *rand.Rand, choices []weighted) interface{} {
+	total := 0
+	for _, c := range choices {
+		total += c.weight
+	}
+	n := r.Intn(total)
+	for _, c := range choices {
+		if n < c.weight {
+			return c.value
+		}
+		n -= c.weight
+	}
+	return choices[len(choices)-1].value
+}
+
+// splitWeight splits e.g. "GET /users=30" into "GET /users" and 30.
+func splitWeight(s string) (string, int, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return s, 1, nil
+	}
+	weight, err := strconv.Atoi(s[i+1:])
+	if err != nil || weight < 1 {
+		return "", 0, fmt.Errorf("Invalid weight in %q, expected a positive number after =", s)
+	}
+	return s[:i], weight, nil
+}
+
+// Generator writes ALB access log lines.
+type Generator struct {
+	routes, statuses []weighted
+	median, p99      time.Duration
+	lb, targetGroup  string
+	rand             *rand.Rand
+}
+
+// NewGenerator returns a generator requesting the routes (e.g. "GET
+// /users/:id=30", where :params are filled in at random and the optional
+// =weight is how often the route is requested relative to the others),
+// responding with the status mix (e.g. "200=90,404=5,500=5"), and taking the
+// latency given as its median and 99th percentile (e.g. "30ms,400ms").
+func NewGenerator(routes []string, statusMix, latency string) (*Generator, error) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	g := &Generator{
+		lb:          fmt.Sprintf("app/sample-alb/%016x", r.Uint64()),
+		targetGroup: fmt.Sprintf("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/sample-targets/%016x", r.Uint64()),
+		rand:        r,
+	}
+
+	if len(routes) == 0 {
+		routes = defaultRoutes
+	}
+	for _, spec := range routes {
+		spec, weight, err := splitWeight(spec)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Fields(spec)
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], "/") {
+			return nil, fmt.Errorf("Invalid --route %q, expected e.g. 'GET /users/:id=30'", spec)
+		}
+		g.routes = append(g.routes, weighted{weight, route{strings.ToUpper(parts[0]), parts[1]}})
+	}
+
+	for _, spec := range strings.Split(statusMix, ",") {
+		spec, weight, err := splitWeight(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		status, err := strconv.Atoi(spec)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("Invalid --status_mix status %q", spec)
+		}
+		g.statuses = append(g.statuses, weighted{weight, status})
+	}
+
+	parts := strings.Split(latency, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid --latency %q, expected the median and p99, e.g. 30ms,400ms", latency)
+	}
+	var err error
+	if g.median, err = time.ParseDuration(strings.TrimSpace(parts[0])); err != nil {
+		return nil, fmt.Errorf("Invalid --latency median: %s", err)
+	}
+	if g.p99, err = time.ParseDuration(strings.TrimSpace(parts[1])); err != nil {
+		return nil, fmt.Errorf("Invalid --latency p99: %s", err)
+	}
+	if g.median <= 0 || g.p99 < g.median {
+		return nil, fmt.Errorf("Invalid --latency %q, expected the p99 to be at least the median", latency)
+	}
+
+	return g, nil
+}
+
+// ParseRate parses a rate of lines such as "100/s", "600/m" or just "100"
+// (per second), returning it per second.
+func ParseRate(s string) (float64, error) {
+	per := time.Second
+	if i := strings.Index(s, "/"); i >= 0 {
+		switch s[i+1:] {
+		case "s":
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return 0, fmt.Errorf("Invalid --rate %q, expected e.g. 100/s", s)
+		}
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid --rate %q, expected e.g. 100/s", s)
+	}
+	return n / per.Seconds(), nil
+}
+
+// latency returns a request's latency, in seconds.
+func (g *Generator) latency() float64 {
+	sigma := math.Log(g.p99.Seconds()/g.median.Seconds()) / p99Z
+	return g.median.Seconds() * math.Exp(sigma*g.rand.NormFloat64())
+}
+
+func (g *Generator) path(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			segments[i] = strconv.Itoa(g.rand.Intn(100000))
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Line returns a log line of a request which was responded to at the time.
+func (g *Generator) Line(at time.Time) string {
+	rt := pick(g.rand, g.routes).(route)
+	status := pick(g.rand, g.statuses).(int)
+	latency := g.latency()
+
+	requestTime, responseTime := 0.00002+g.rand.Float64()*0.00003, 0.00001+g.rand.Float64()*0.00002
+	backendTime := latency - requestTime - responseTime
+	if backendTime < 0 {
+		backendTime = 0
+	}
+	backendStatus := strconv.Itoa(status)
+	target := fmt.Sprintf("10.0.%d.%d:8080", g.rand.Intn(4), 10+g.rand.Intn(20))
+	times := fmt.Sprintf("%.6f %.6f %.6f", requestTime, backendTime, responseTime)
+	// The load balancer answers for targets which couldn't be reached
+	// or timed out.
+	if status == 502 || status == 503 || status == 504 {
+		backendStatus, target, times = "-", "-", fmt.Sprintf("%.6f -1 -1", requestTime)
+	}
+
+	const timeFormat = "2006-01-02T15:04:05.000000Z"
+	created := at.Add(-time.Duration(latency * float64(time.Second)))
+	return fmt.Sprintf(`https %s %s %s%d:%d %s %s %d %s %d %d "%s https://sample.example.com:443%s HTTP/1.1" "%s" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 %s "Root=1-%08x-%08x%016x" "sample.example.com" "-" 1 %s "forward" "-" "-" "%s" "%s"`,
+		at.UTC().Format(timeFormat),
+		g.lb,
+		clientRanges[g.rand.Intn(len(clientRanges))], 1+g.rand.Intn(254), 1024+g.rand.Intn(60000),
+		target,
+		times,
+		status, backendStatus,
+		100+g.rand.Intn(2000), 200+g.rand.Intn(20000),
+		rt.method, g.path(rt.path),
+		userAgents[g.rand.Intn(len(userAgents))],
+		g.targetGroup,
+		created.Unix(), g.rand.Uint32(), g.rand.Uint64(),
+		created.UTC().Format(timeFormat),
+		target, backendStatus,
+	)
+}
+
+// write writes n lines of requests responded to over the second before now
+// to a new temporary file, returning its name.
+func (g *Generator) write(n int, now time.Time) (string, error) {
+	f, err := ioutil.TempFile("", "honeyaws-generated")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	for i := 0; i < n; i++ {
+		at := now.Add(-time.Second + time.Duration(i)*time.Second/time.Duration(n))
+		fmt.Fprintln(w, g.Line(at))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// Run publishes rate lines a second with the publisher, as an object of each
+// second's lines, until stop is closed.
+func (g *Generator) Run(p publisher.Publisher, rate float64, stop <-chan struct{}) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	owed := 0.0
+	for n := 0; ; n++ {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-stop:
+			return nil
+		}
+
+		// Rates below one a second add up over several seconds.
+		owed += rate
+		lines := int(owed)
+		owed -= float64(lines)
+		if lines == 0 {
+			continue
+		}
+
+		filename, err := g.write(lines, now)
+		if err != nil {
+			return fmt.Errorf("Error writing generated logs: %s", err)
+		}
+		if err := p.Publish(state.DownloadedObject{
+			Object:   fmt.Sprintf("generated/%d.log", n),
+			Filename: filename,
+		}); err != nil {
+			logrus.WithField("error", err).Error("Cannot properly publish generated logs")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package generate
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, expected := range map[string]float64{"100/s": 100, "600/m": 10, "100": 100, "0.5/s": 0.5} {
+		if rate, err := ParseRate(s); err != nil || rate != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", s, expected, rate, err)
+		}
+	}
+	for _, s := range []string{"", "fast", "100/d", "-1/s"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestNewGeneratorInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		routes             []string
+		statusMix, latency string
+	}{
+		{[]string{"/no-method"}, "200", "10ms,100ms"},
+		{[]string{"GET /users=lots"}, "200", "10ms,100ms"},
+		{nil, "200=90,OK=10", "10ms,100ms"},
+		{nil, "200", "100ms"},
+		{nil, "200", "100ms,10ms"},
+	} {
+		if _, err := NewGenerator(tc.routes, tc.statusMix, tc.latency); err == nil {
+			t.Errorf("expected an error for %+v", tc)
+		}
+	}
+}
+
+func TestLinesParse(t *testing.T) {
+	g, err := NewGenerator([]string{"GET /users/:id=3", "POST /orders"}, "200=8,404=1,504=1", "20ms,200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := g.write(1000, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	parser := publisher.NewALBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"})
+	out := make(chan event.Event, 1000)
+	if err := parser.ParseEvents(state.DownloadedObject{Object: "generated/0.log", Filename: filename}, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	statuses := make(map[int64]int)
+	var latencies []float64
+	for ev := range out {
+		statuses[ev.Data["elb_status_code"].(int64)]++
+		request := ev.Data["request"].(string)
+		if !strings.HasPrefix(request, "GET https://sample.example.com:443/users/") && !strings.HasPrefix(request, "POST https://sample.example.com:443/orders ") {
+			t.Fatalf("unexpected request %q", request)
+		}
+		if backend, ok := ev.Data["backend_processing_time"].(float64); ok {
+			latencies = append(latencies, backend)
+		}
+	}
+
+	total := 0
+	for _, n := range statuses {
+		total += n
+	}
+	if total != 1000 {
+		t.Fatalf("expected every line to parse, got %d events", total)
+	}
+	if statuses[200] < 700 || statuses[404] == 0 || statuses[504] == 0 {
+		t.Errorf("unexpected status mix %v", statuses)
+	}
+
+	sort.Float64s(latencies)
+	if median := latencies[len(latencies)/2]; median < 0.015 || median > 0.025 {
+		t.Errorf("expected a median latency of about 20ms, got %v", median)
+	}
+}
+
+type countingPublisher struct {
+	lines int
+}
+
+func (p *countingPublisher) Publish(obj state.DownloadedObject) error {
+	defer os.Remove(obj.Filename)
+	f, err := os.Open(obj.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		p.lines++
+	}
+	return scanner.Err()
+}
+
+func TestRun(t *testing.T) {
+	g, err := NewGenerator(nil, "200", "10ms,100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &countingPublisher{}
+	stop := make(chan struct{})
+	time.AfterFunc(1500*time.Millisecond, func() { close(stop) })
+	if err := g.Run(p, 20, stop); err != nil {
+		t.Fatal(err)
+	}
+	if p.lines != 20 {
+		t.Errorf("expected a second's worth of lines, got %d", p.lines)
+	}
+}
@@ -3,16 +3,25 @@ module github.com/honeycombio/honeyaws
 go 1.14
 
 require (
-	github.com/aws/aws-sdk-go v1.38.12
-	github.com/golang/protobuf v1.4.2 // indirect
+	github.com/aws/aws-lambda-go v1.23.0
+	github.com/aws/aws-sdk-go v1.45.0
+	github.com/gomodule/redigo v1.8.2
 	github.com/honeycombio/dynsampler-go v0.2.1
-	github.com/honeycombio/gonx v1.3.1-0.20180426150627-7443e4e8f28c // indirect
+	github.com/honeycombio/gonx v1.3.1-0.20180426150627-7443e4e8f28c
 	github.com/honeycombio/honeytail v1.3.0
 	github.com/honeycombio/libhoney-go v1.15.2
 	github.com/honeycombio/urlshaper v0.0.0-20170302202025-2baba9ae5b5f
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/klauspost/compress v1.11.4
+	github.com/lib/pq v1.10.9
+	github.com/pierrec/lz4/v4 v4.1.2
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sirupsen/logrus v1.8.1
-	golang.org/x/net v0.0.0-20201202161906-c7110b5ffcbb // indirect
-	golang.org/x/sys v0.0.0-20210112080510-489259a85091 // indirect
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	gopkg.in/yaml.v2 v2.3.0
 )
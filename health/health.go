@@ -0,0 +1,120 @@
+// Package health serves liveness and readiness endpoints, so that
+// orchestrators such as Kubernetes can restart the process when the pipeline
+// wedges, and hold off on it until Honeycomb is reachable.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Checks are run on every request, except for readiness checks which talk to
+// the outside world, whose results are cached for this long.
+const readyCacheTTL = 30 * time.Second
+
+type check struct {
+	sync.Mutex
+	fn      func() error
+	cache   bool
+	err     error
+	checked time.Time
+}
+
+func (c *check) run() error {
+	c.Lock()
+	defer c.Unlock()
+	if !c.cache || time.Since(c.checked) > readyCacheTTL {
+		c.err = c.fn()
+		c.checked = time.Now()
+	}
+	return c.err
+}
+
+var (
+	mu    sync.Mutex
+	live  = make(map[string]*check)
+	ready = make(map[string]*check)
+)
+
+// Live registers a check that the named part of the pipeline is still making
+// progress, replacing any check already registered under the name. Failing
+// liveness checks fail both /healthz and /readyz.
+func Live(name string, fn func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+	live[name] = &check{fn: fn}
+}
+
+// Ready registers a check of something the pipeline depends on, such as
+// Honeycomb being reachable. Failing readiness checks only fail /readyz.
+func Ready(name string, fn func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+	ready[name] = &check{fn: fn, cache: true}
+}
+
+func run(checks ...map[string]*check) []string {
+	// Don't hold the lock while checking, so slow readiness checks
+	// don't hold up liveness checks.
+	mu.Lock()
+	toRun := make(map[string]*check)
+	for _, cs := range checks {
+		for name, c := range cs {
+			toRun[name] = c
+		}
+	}
+	mu.Unlock()
+
+	var failures []string
+	for name, c := range toRun {
+		if err := c.run(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	sort.Strings(failures)
+	return failures
+}
+
+// Alive runs the liveness checks, for reporting liveness other than over
+// HTTP, such as to the systemd watchdog.
+func Alive() error {
+	if failures := run(live); len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func handler(checks ...map[string]*check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failures := run(checks...)
+		if len(failures) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, strings.Join(failures, "\n"))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// Serve exposes /healthz and /readyz on the given address, e.g. ":8080", as
+// well as the status of each supervised unit as JSON on /status.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", handler(live))
+	mux.Handle("/readyz", handler(live, ready))
+	mux.HandleFunc("/status", statusHandler)
+
+	logrus.WithField("addr", addr).Info("Serving health checks")
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logrus.WithField("error", err).Fatal("Could not serve health checks")
+		}
+	}()
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func status(h http.Handler) int {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	return rec.Code
+}
+
+func TestHandlers(t *testing.T) {
+	live = make(map[string]*check)
+	ready = make(map[string]*check)
+	healthz, readyz := handler(live), handler(live, ready)
+
+	var liveErr, readyErr error
+	Live("poller", func() error { return liveErr })
+	Ready("honeycomb", func() error { return readyErr })
+
+	if status(healthz) != http.StatusOK || status(readyz) != http.StatusOK {
+		t.Fatal("Expected both endpoints to be OK when all checks pass")
+	}
+
+	// Readiness check results are cached, so register it again to
+	// clear the cache.
+	readyErr = errors.New("unreachable")
+	Ready("honeycomb", func() error { return readyErr })
+	if status(healthz) != http.StatusOK {
+		t.Error("Expected failing readiness checks not to fail /healthz")
+	}
+	if status(readyz) != http.StatusServiceUnavailable {
+		t.Error("Expected failing readiness checks to fail /readyz")
+	}
+
+	readyErr = nil
+	liveErr = errors.New("wedged")
+	Ready("honeycomb", func() error { return readyErr })
+	if status(healthz) != http.StatusServiceUnavailable || status(readyz) != http.StatusServiceUnavailable {
+		t.Error("Expected failing liveness checks to fail both endpoints")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	SetStatus("poller logs my-lb", Status{State: StateBackoff, Restarts: 2, LastError: "access denied"})
+	defer ClearStatus("poller logs my-lb")
+
+	rec := httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest("GET", "/status", nil))
+	var statuses map[string]Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if s := statuses["poller logs my-lb"]; s.State != StateBackoff || s.Restarts != 2 || s.LastError != "access denied" {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	live = make(map[string]*check)
+	ready = make(map[string]*check)
+	Ready("honeycomb", func() error { return errors.New("unreachable") })
+	if err := Alive(); err != nil {
+		t.Errorf("expected failing readiness checks not to matter, got %s", err)
+	}
+	Live("poller", func() error { return errors.New("stuck") })
+	if err := Alive(); err == nil || err.Error() != "poller: stuck" {
+		t.Errorf("expected the failing liveness check, got %v", err)
+	}
+}
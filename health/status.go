@@ -0,0 +1,56 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// The states a supervised unit can be in.
+const (
+	StateRunning = "running"
+	// The unit failed and is waiting to be restarted.
+	StateBackoff = "backoff"
+)
+
+// Status is the state of one of the units ingestion is split into, such as
+// the poller of a single load balancer, which fail and restart independently.
+type Status struct {
+	State     string    `json:"state"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+var statuses = make(map[string]Status)
+
+// SetStatus records the status of the named unit, for /status.
+func SetStatus(name string, s Status) {
+	mu.Lock()
+	defer mu.Unlock()
+	statuses[name] = s
+}
+
+// ClearStatus forgets the named unit, e.g. once its load balancer has been
+// deleted.
+func ClearStatus(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(statuses, name)
+}
+
+// Statuses returns the status of every unit, by name.
+func Statuses() map[string]Status {
+	mu.Lock()
+	defer mu.Unlock()
+	all := make(map[string]Status, len(statuses))
+	for name, s := range statuses {
+		all[name] = s
+	}
+	return all
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Statuses())
+}
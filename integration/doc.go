@@ -0,0 +1,11 @@
+// Package integration has end to end tests of the ingest pipeline, from an
+// ALB's access logs in S3 (and SQS notifications) to a mock of Honeycomb's
+// API, with state in DynamoDB, all against LocalStack rather than AWS. They're
+// built with the integration tag:
+//
+//	cd integration && docker compose up -d
+//	go test -tags=integration ./integration/...
+//
+// LOCALSTACK_ENDPOINT overrides where LocalStack is, http://localhost:4566 by
+// default.
+package integration
@@ -0,0 +1,307 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/meta"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	bucket  = "honeyaws-integration"
+	table   = "HoneyAWSIntegration"
+	dataset = "aws-alb-access"
+	lbName  = "my-lb"
+	prefix  = "alb"
+	timeout = time.Minute
+)
+
+// honeycomb is a mock of Honeycomb's API, recording the events sent to it.
+type honeycomb struct {
+	sync.Mutex
+	events map[string][]map[string]interface{}
+}
+
+func (h *honeycomb) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/1/auth" {
+		fmt.Fprint(w, `{"team":{"slug":"integration"},"environment":{"slug":""}}`)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/1/batch/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "zstd":
+		dec, err := zstd.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer dec.Close()
+		body = dec
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	var batch []struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(body).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.Lock()
+	name := strings.TrimPrefix(r.URL.Path, "/1/batch/")
+	statuses := make([]map[string]int, len(batch))
+	for i, ev := range batch {
+		h.events[name] = append(h.events[name], ev.Data)
+		statuses[i] = map[string]int{"status": http.StatusAccepted}
+	}
+	h.Unlock()
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func (h *honeycomb) sent(name string) []map[string]interface{} {
+	h.Lock()
+	defer h.Unlock()
+	return append([]map[string]interface{}(nil), h.events[name]...)
+}
+
+// waitFor polls until cond is true, failing the test after timeout.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func localStackSession(t *testing.T) *session.Session {
+	endpoint := os.Getenv("LOCALSTACK_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://localhost:4566"
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("test", "test", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(endpoint + "/_localstack/health"); err != nil {
+		t.Fatalf("LocalStack isn't running at %s (see integration/docker-compose.yml): %s", endpoint, err)
+	}
+	return sess
+}
+
+// putLogObject uploads the fixture log, gzipped, as an ALB log object named
+// for now.
+func putLogObject(t *testing.T, sess *session.Session, d logbucket.ObjectDownloader, now time.Time, suffix string) string {
+	fixture, err := ioutil.ReadFile("testdata/alb.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(fixture)
+	gz.Close()
+
+	key := d.ObjectPrefix(now) + ".50dc6c495c0c9188_" + now.Format("20060102T1504Z") + "_10.0.0.1_" + suffix + ".log.gz"
+	if _, err := s3.New(sess).PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(buf.Bytes()),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// createLoadBalancer creates the ALB, in a VPC of its own, with its access logs
+// delivered to the bucket, unless it's there from an earlier run.
+func createLoadBalancer(t *testing.T, sess *session.Session) {
+	elbSvc := elbv2.New(sess)
+	lbs, err := elbSvc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{Names: []*string{aws.String(lbName)}})
+	if err == nil && len(lbs.LoadBalancers) > 0 {
+		return
+	}
+
+	ec2Svc := ec2.New(sess)
+	vpc, err := ec2Svc.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subnets []*string
+	for i, az := range []string{"us-east-1a", "us-east-1b"} {
+		subnet, err := ec2Svc.CreateSubnet(&ec2.CreateSubnetInput{
+			VpcId:            vpc.Vpc.VpcId,
+			CidrBlock:        aws.String(fmt.Sprintf("10.0.%d.0/24", i)),
+			AvailabilityZone: aws.String(az),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subnets = append(subnets, subnet.Subnet.SubnetId)
+	}
+
+	lb, err := elbSvc.CreateLoadBalancer(&elbv2.CreateLoadBalancerInput{
+		Name:    aws.String(lbName),
+		Subnets: subnets,
+		Type:    aws.String(elbv2.LoadBalancerTypeEnumApplication),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := elbSvc.ModifyLoadBalancerAttributes(&elbv2.ModifyLoadBalancerAttributesInput{
+		LoadBalancerArn: lb.LoadBalancers[0].LoadBalancerArn,
+		Attributes: []*elbv2.LoadBalancerAttribute{
+			{Key: aws.String("access_logs.s3.enabled"), Value: aws.String("true")},
+			{Key: aws.String("access_logs.s3.bucket"), Value: aws.String(bucket)},
+			{Key: aws.String("access_logs.s3.prefix"), Value: aws.String(prefix)},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func notification(key string, now time.Time) string {
+	return fmt.Sprintf(`{"Records":[{"eventName":"ObjectCreated:Put","eventTime":%q,"s3":{"bucket":{"name":%q},"object":{"key":%q,"size":1}}}]}`,
+		now.Format(time.RFC3339), bucket, key)
+}
+
+// TestPipeline finds the bucket an ALB's access logs are delivered to, ingests
+// the objects in it, then one it's notified of over SQS, publishing them to the
+// mock Honeycomb with state in DynamoDB.
+func TestPipeline(t *testing.T) {
+	sess := localStackSession(t)
+
+	s3Svc := s3.New(sess)
+	if _, err := s3Svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil && !strings.Contains(err.Error(), "BucketAlready") {
+		t.Fatal(err)
+	}
+	queue, err := sqs.New(sess).CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("honeyaws-integration")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.CreateDynamoDBTable(sess, table, nil); err != nil {
+		t.Fatal(err)
+	}
+	stater, err := state.NewDynamoDBStater(sess, table, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &honeycomb{events: make(map[string][]map[string]interface{})}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	opt := &options.Options{
+		Dataset:         dataset,
+		SampleRate:      1,
+		SamplerType:     "simple",
+		SamplerInterval: 300,
+		WriteKey:        "integration",
+		APIHost:         server.URL,
+		BackfillHr:      1,
+		ParseWorkers:    2,
+	}
+	hp := publisher.NewHoneycombPublisher(opt, stater, publisher.NewALBEventParser(opt))
+
+	createLoadBalancer(t, sess)
+	bucketName, bucketPrefix, enabled, err := meta.ELBV2AccessLogs(sess, lbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enabled || bucketName != bucket || bucketPrefix != prefix {
+		t.Fatalf("expected access logs to be delivered to %s/%s, got %s/%s (enabled: %v)", bucket, prefix, bucketName, bucketPrefix, enabled)
+	}
+
+	objectDownloader := logbucket.NewALBDownloader(sess, bucketName, bucketPrefix, lbName)
+	now := time.Now().UTC()
+	backfilled := putLogObject(t, sess, objectDownloader, now.Add(-10*time.Minute), "backfill")
+
+	downloader := logbucket.NewDownloader(sess, stater, objectDownloader, opt.BackfillHr)
+	listener := logbucket.NewSQSListener(sess, aws.StringValue(queue.QueueUrl))
+	listener.Add(downloader)
+	defer downloader.Stop()
+
+	downloads := make(chan state.DownloadedObject)
+	downloader.Download(downloads)
+	go listener.Listen()
+	go publisher.PublishObjects(hp, downloads, opt.ParseWorkers)
+
+	// The fixture has 3 requests.
+	waitFor(t, "the backfilled object's events", func() bool { return len(mock.sent(dataset)) >= 3 })
+	waitFor(t, "the backfilled object to be processed", func() bool {
+		processed, err := stater.ProcessedObjects()
+		_, ok := processed[backfilled]
+		return err == nil && ok
+	})
+
+	notified := putLogObject(t, sess, objectDownloader, now, "notified")
+	if _, err := sqs.New(sess).SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    queue.QueueUrl,
+		MessageBody: aws.String(notification(notified, now)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the notified object's events", func() bool { return len(mock.sent(dataset)) >= 6 })
+	waitFor(t, "the notified object to be processed", func() bool {
+		processed, err := stater.ProcessedObjects()
+		_, ok := processed[notified]
+		return err == nil && ok
+	})
+
+	events := mock.sent(dataset)
+	if len(events) != 6 {
+		t.Errorf("expected each object to be published once, got %d events", len(events))
+	}
+	statuses := make(map[interface{}]int)
+	for _, ev := range events {
+		statuses[ev["elb_status_code"]]++
+	}
+	for _, code := range []float64{200, 503, 404} {
+		if statuses[code] != 2 {
+			t.Errorf("expected 2 events with elb_status_code %v, got %v", code, statuses)
+		}
+	}
+}
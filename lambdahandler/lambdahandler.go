@@ -0,0 +1,205 @@
+// Package lambdahandler runs the download, parse and publish pipeline as an
+// AWS Lambda function triggered by S3 object creation events, so that logs can
+// be ingested without a long running host.
+package lambdahandler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/meta"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/publisher"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+type Handler struct {
+	state.Stater
+	Publisher
+	Sess *session.Session
+
+	// download fetches the object from its bucket, and is swapped out in
+	// tests.
+	download     func(sess *session.Session, bucket, key string) (state.DownloadedObject, error)
+	flushTimeout time.Duration
+}
+
+// Publisher publishes the objects of every invocation the function container
+// handles, flushing their events at the end of each.
+type Publisher interface {
+	publisher.Publisher
+	Flush(timeout time.Duration) bool
+}
+
+// The daemons keep state in their backend under their service, so a function
+// sharing one with them uses the same.
+var logServices = map[string]string{
+	publisher.LogTypeELB:        logbucket.AWSElasticLoadBalancing,
+	publisher.LogTypeALB:        logbucket.AWSElasticLoadBalancingV2,
+	publisher.LogTypeNLB:        logbucket.AWSNetworkLoadBalancing,
+	publisher.LogTypeCloudFront: logbucket.AWSCloudFront,
+	publisher.LogTypeCloudTrail: logbucket.AWSCloudTrail,
+	publisher.LogTypeFlowLogs:   logbucket.AWSVPCFlowLogs,
+	publisher.LogTypeWAF:        logbucket.AWSWAFLogs,
+	publisher.LogTypeAPIGateway: logbucket.AWSAPIGateway,
+
+	publisher.LogTypeGlobalAccelerator: logbucket.AWSGlobalAccelerator,
+}
+
+// New builds a handler for logs of the given type (see publisher.LogTypeALB,
+// etc.). Lambda has no durable local filesystem, so state is kept in DynamoDB
+// when opt.HighAvail is set (or the opt.StateBackend server), and in memory for
+// the lifetime of the function container otherwise.
+func New(sess *session.Session, opt *options.Options, logType string) (*Handler, error) {
+	eventParser, err := publisher.NewEventParser(opt, logType)
+	if err != nil {
+		return nil, err
+	}
+
+	var stater state.Stater
+	if opt.StateBackend != "" {
+		stater, err = state.NewBackendStater(opt.StateBackend, logServices[logType], opt.BackfillHr)
+		if err != nil {
+			return nil, err
+		}
+	} else if opt.HighAvail {
+		if opt.CreateTable {
+			tags, err := meta.ParseAWSTags(opt.AWSTags)
+			if err != nil {
+				return nil, err
+			}
+			if err := state.CreateDynamoDBTable(sess, opt.DynamoTable, tags); err != nil {
+				return nil, err
+			}
+		}
+		stater, err = state.NewDynamoDBStater(sess, opt.DynamoTable, opt.BackfillHr)
+		if err != nil {
+			return nil, fmt.Errorf("--highavail requires an existing DynamoDB table named %s: %s", opt.DynamoTable, err)
+		}
+	} else {
+		stater = state.NewMemoryStater(opt.BackfillHr)
+	}
+
+	if opt.RequesterPays {
+		logbucket.AddRequesterPays(sess)
+	}
+
+	// Heartbeats and rollups run on timers of their own, which doesn't
+	// make sense for short lived invocations.
+	opt.HeartbeatInterval = 0
+	opt.RollupInterval = 0
+
+	// The publisher lives as long as the function container does, since
+	// building it sets up the dataset and its markers, and registers its
+	// flushers and health checks.
+	return &Handler{
+		Stater:       stater,
+		Publisher:    publisher.NewHoneycombPublisher(opt, stater, eventParser),
+		Sess:         sess,
+		download:     logbucket.DownloadObject,
+		flushTimeout: time.Duration(opt.DrainTimeout) * time.Second,
+	}, nil
+}
+
+// Handle ingests every object in the event, and returns once all of the
+// resulting events have been flushed to Honeycomb. Lambda freezes the function
+// container once the handler returns, so if events are still pending when the
+// flush times out, the objects published are unclaimed and an error returned,
+// for Lambda to retry the invocation rather than lose them.
+func (h *Handler) Handle(ctx context.Context, s3Event events.S3Event) (err error) {
+	var published []string
+	defer func() {
+		if h.Flush(h.flushTimeout) {
+			return
+		}
+		for _, key := range published {
+			h.unclaim(key)
+		}
+		if err == nil {
+			err = fmt.Errorf("Events of %d objects still pending after %s, failing for the invocation to be retried", len(published), h.flushTimeout)
+		}
+	}()
+
+	for _, rec := range s3Event.Records {
+		key := rec.S3.Object.URLDecodedKey
+		claimed, err := h.handleObject(rec.S3.Bucket.Name, key)
+		if claimed {
+			published = append(published, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleObject claims, downloads and publishes the object, unless it's already
+// been processed, returning whether it did. An object which can't be
+// downloaded or published has its claim cleared, so that it's retried rather
+// than skipped when Lambda invokes the function again.
+func (h *Handler) handleObject(bucket, key string) (bool, error) {
+	processedObjects, err := h.ProcessedObjects()
+	if err != nil {
+		logrus.Error(err)
+	}
+	if _, ok := processedObjects[key]; ok {
+		logrus.WithField("object", key).Debug("Already processed, skipping")
+		return false, nil
+	}
+
+	// The object is claimed before it's downloaded, so that with DynamoDB
+	// only the first invocation to get to it does.
+	if err := h.SetProcessed(key); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"object": key,
+			"error":  err,
+		}).Debug("Error setting state of object as processed, skipping")
+		return false, nil
+	}
+
+	downloadedObj, err := h.download(h.Sess, bucket, key)
+	if err != nil {
+		h.unclaim(key)
+		return false, err
+	}
+	defer func() {
+		if err := os.Remove(downloadedObj.Filename); err != nil && !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"object": key,
+				"error":  err,
+			}).Warn("Could not remove downloaded object")
+		}
+	}()
+
+	logrus.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"object": key,
+	}).Info("Publishing object")
+
+	if err := h.Publish(downloadedObj); err != nil {
+		h.unclaim(key)
+		return false, fmt.Errorf("Cannot properly publish downloaded object %s: %s", key, err)
+	}
+	return true, nil
+}
+
+// unclaim forgets that the object was processed, if the stater can.
+func (h *Handler) unclaim(key string) {
+	u, ok := h.Stater.(state.Unclaimer)
+	if !ok {
+		return
+	}
+	if err := u.ClearProcessed(key); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"object": key,
+			"error":  err,
+		}).Error("Could not clear the claim on the object")
+	}
+}
@@ -0,0 +1,140 @@
+package lambdahandler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+type fakePublisher struct {
+	err       error
+	published []string
+	flushes   int
+	// pending has Flush time out with events still pending.
+	pending bool
+}
+
+func (p *fakePublisher) Publish(obj state.DownloadedObject) error {
+	p.published = append(p.published, obj.Object)
+	return p.err
+}
+
+func (p *fakePublisher) Flush(timeout time.Duration) bool {
+	p.flushes++
+	return !p.pending
+}
+
+// newTestHandler returns a handler which "downloads" objects to temp files,
+// recording their names.
+func newTestHandler(p Publisher, files *[]string) *Handler {
+	return &Handler{
+		Stater:    state.NewMemoryStater(1),
+		Publisher: p,
+		download: func(sess *session.Session, bucket, key string) (state.DownloadedObject, error) {
+			f, err := ioutil.TempFile("", "lambdahandler-test")
+			if err != nil {
+				return state.DownloadedObject{}, err
+			}
+			f.Close()
+			*files = append(*files, f.Name())
+			return state.DownloadedObject{Filename: f.Name(), Object: key}, nil
+		},
+	}
+}
+
+func s3Event(keys ...string) events.S3Event {
+	var ev events.S3Event
+	for _, key := range keys {
+		var rec events.S3EventRecord
+		rec.S3.Bucket.Name = "logs"
+		rec.S3.Object.URLDecodedKey = key
+		ev.Records = append(ev.Records, rec)
+	}
+	return ev
+}
+
+func TestHandlePublishFails(t *testing.T) {
+	var files []string
+	p := &fakePublisher{err: errors.New("publish failed")}
+	h := newTestHandler(p, &files)
+
+	if err := h.Handle(context.Background(), s3Event("a.log.gz")); err == nil {
+		t.Fatal("expected the publishing error")
+	}
+	if processed, _ := h.ProcessedObjects(); len(processed) != 0 {
+		t.Errorf("expected the claim on the object to be cleared, got %v", processed)
+	}
+	for _, name := range files {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	if p.flushes != 1 {
+		t.Errorf("expected the events to be flushed once, got %d", p.flushes)
+	}
+
+	// the next invocation retries it
+	p.err = nil
+	if err := h.Handle(context.Background(), s3Event("a.log.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != 2 {
+		t.Errorf("expected the object to be published again, got %v", p.published)
+	}
+	if processed, _ := h.ProcessedObjects(); len(processed) != 1 {
+		t.Errorf("expected the object to be processed, got %v", processed)
+	}
+}
+
+func TestHandleSkipsProcessed(t *testing.T) {
+	var files []string
+	p := &fakePublisher{}
+	h := newTestHandler(p, &files)
+
+	if err := h.Handle(context.Background(), s3Event("a.log.gz", "b.log.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Handle(context.Background(), s3Event("a.log.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != 2 || len(files) != 2 {
+		t.Errorf("expected each object to be downloaded and published once, got %v and %v", p.published, files)
+	}
+	for _, name := range files {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestHandleFlushTimesOut(t *testing.T) {
+	var files []string
+	p := &fakePublisher{pending: true}
+	h := newTestHandler(p, &files)
+
+	if err := h.Handle(context.Background(), s3Event("a.log.gz", "b.log.gz")); err == nil {
+		t.Fatal("expected events still pending to fail the invocation")
+	}
+	if processed, _ := h.ProcessedObjects(); len(processed) != 0 {
+		t.Errorf("expected the claims on the objects to be cleared, got %v", processed)
+	}
+
+	// Lambda retries the invocation
+	p.pending = false
+	if err := h.Handle(context.Background(), s3Event("a.log.gz", "b.log.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != 4 {
+		t.Errorf("expected the objects to be published again, got %v", p.published)
+	}
+	if processed, _ := h.ProcessedObjects(); len(processed) != 2 {
+		t.Errorf("expected the objects to be processed, got %v", processed)
+	}
+}
@@ -0,0 +1,357 @@
+// Package leader elects one of several replicas of an ingest process to do
+// the ingesting, with a Kubernetes Lease, so that an HA pair of pods doesn't
+// ingest everything twice. It talks to the API server directly, as the pod's
+// service account, which needs permission to get, create and update leases
+// in its namespace.
+package leader
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// The defaults are those of client-go's leader election: a lease lasts 15
+// seconds, the leader renews it every 2, and gives up leading if it hasn't
+// managed to for 10, so that it has stopped before anyone else can take over.
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+// Kubernetes' MicroTime, which leases are timestamped with.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// lease is a coordination.k8s.io/v1 Lease.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+// Elector acquires and renews a Lease for Identity.
+type Elector struct {
+	Namespace, Name string
+
+	// Identity is who holds the lease, the pod's name.
+	Identity string
+
+	LeaseDuration, RenewDeadline, RetryPeriod time.Duration
+
+	host      string
+	client    *http.Client
+	token     func() (string, error)
+	now       func() time.Time
+	leaseLock sync.Mutex
+	released  bool
+
+	// observed is the spec of the lease when it was last seen to change,
+	// at observedAt by our clock: the lease has expired once its holder
+	// hasn't renewed it for LeaseDuration since, which doesn't depend on
+	// the clocks of the holder and this process agreeing.
+	observed   leaseSpec
+	observedAt time.Time
+}
+
+// NewInClusterElector returns an Elector for the named lease in the pod's
+// namespace, using its service account.
+func NewInClusterElector(name string) (*Elector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT aren't set")
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		data, err := ioutil.ReadFile(serviceAccountDir + "namespace")
+		if err != nil {
+			return nil, fmt.Errorf("reading the pod's namespace: %s", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		var err error
+		if identity, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+
+	caCert, err := ioutil.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading the API server's CA certificate: %s", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates in %sca.crt", serviceAccountDir)
+	}
+
+	e := newElector(namespace, name, identity, "https://"+net.JoinHostPort(host, port), &http.Client{
+		Timeout:   defaultRetryPeriod * 2,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	})
+	// The token is read every time, since the kubelet rotates it.
+	e.token = func() (string, error) {
+		data, err := ioutil.ReadFile(serviceAccountDir + "token")
+		return strings.TrimSpace(string(data)), err
+	}
+	return e, nil
+}
+
+func newElector(namespace, name, identity, host string, client *http.Client) *Elector {
+	return &Elector{
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      identity,
+		LeaseDuration: defaultLeaseDuration,
+		RenewDeadline: defaultRenewDeadline,
+		RetryPeriod:   defaultRetryPeriod,
+		host:          host,
+		client:        client,
+		token:         func() (string, error) { return "", nil },
+		now:           time.Now,
+	}
+}
+
+func (e *Elector) log() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"lease":    e.Namespace + "/" + e.Name,
+		"identity": e.Identity,
+	})
+}
+
+// Acquire blocks until this process holds the lease, then renews it in the
+// background until Release. The returned channel is closed if renewing it
+// fails for RenewDeadline, since another replica may be about to take over,
+// so the process should stop ingesting straight away.
+func (e *Elector) Acquire() <-chan struct{} {
+	e.log().Info("Waiting to be elected leader")
+	for {
+		ok, err := e.tryAcquireOrRenew()
+		if err != nil {
+			e.log().WithField("error", err).Error("Could not acquire the lease")
+		}
+		if ok {
+			break
+		}
+		time.Sleep(e.RetryPeriod)
+	}
+	e.log().Info("Elected leader")
+
+	lost := make(chan struct{})
+	go e.renew(lost)
+	return lost
+}
+
+func (e *Elector) renew(lost chan struct{}) {
+	t := time.NewTicker(e.RetryPeriod)
+	defer t.Stop()
+	renewed := e.now()
+	for range t.C {
+		ok, err := e.tryAcquireOrRenew()
+		if e.isReleased() {
+			return
+		}
+		if ok {
+			renewed = e.now()
+			continue
+		}
+		if err != nil {
+			e.log().WithField("error", err).Warn("Could not renew the lease")
+		}
+		// Without an error someone else holds the lease.
+		if err == nil || e.now().Sub(renewed) >= e.RenewDeadline {
+			e.log().Error("Lost the lease")
+			close(lost)
+			return
+		}
+	}
+}
+
+// Release stops renewing the lease and gives it up, for another replica to
+// take over without waiting for it to expire, e.g. when shutting down. It
+// does nothing on a nil Elector, for when there's no leader election.
+func (e *Elector) Release() {
+	if e == nil {
+		return
+	}
+	e.leaseLock.Lock()
+	defer e.leaseLock.Unlock()
+	e.released = true
+	l, status, err := e.get()
+	if err != nil || status != http.StatusOK || l.Spec.HolderIdentity != e.Identity {
+		return
+	}
+	l.Spec.HolderIdentity = ""
+	l.Spec.LeaseDurationSeconds = 1
+	l.Spec.RenewTime = e.now().UTC().Format(microTime)
+	if _, err := e.do("PUT", e.leasePath(), l, nil); err != nil {
+		e.log().WithField("error", err).Warn("Could not release the lease")
+		return
+	}
+	e.log().Info("Released the lease")
+}
+
+// tryAcquireOrRenew takes the lease if it's free or has expired, or renews
+// it if it's already ours, returning whether we hold it.
+func (e *Elector) tryAcquireOrRenew() (bool, error) {
+	e.leaseLock.Lock()
+	defer e.leaseLock.Unlock()
+	if e.released {
+		return false, nil
+	}
+	now := e.now()
+	stamp := now.UTC().Format(microTime)
+	spec := leaseSpec{
+		HolderIdentity:       e.Identity,
+		LeaseDurationSeconds: int(e.LeaseDuration / time.Second),
+		AcquireTime:          stamp,
+		RenewTime:            stamp,
+	}
+
+	l, status, err := e.get()
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusNotFound {
+		l := lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: e.Name, Namespace: e.Namespace},
+			Spec:       spec,
+		}
+		status, err := e.do("POST", e.leasesPath(), l, nil)
+		if status == http.StatusConflict {
+			// someone else created it first
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		e.observe(spec, now)
+		return true, nil
+	}
+
+	if l.Spec != e.observed {
+		e.observe(l.Spec, now)
+	}
+	if l.Spec.HolderIdentity != e.Identity {
+		duration := time.Duration(l.Spec.LeaseDurationSeconds) * time.Second
+		if l.Spec.HolderIdentity != "" && now.Before(e.observedAt.Add(duration)) {
+			return false, nil
+		}
+		spec.LeaseTransitions = l.Spec.LeaseTransitions + 1
+	} else {
+		spec.AcquireTime = l.Spec.AcquireTime
+		spec.LeaseTransitions = l.Spec.LeaseTransitions
+	}
+
+	l.Spec = spec
+	status, err = e.do("PUT", e.leasePath(), l, nil)
+	if status == http.StatusConflict {
+		// someone else updated it first
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e.observe(spec, now)
+	return true, nil
+}
+
+func (e *Elector) isReleased() bool {
+	e.leaseLock.Lock()
+	defer e.leaseLock.Unlock()
+	return e.released
+}
+
+func (e *Elector) observe(spec leaseSpec, now time.Time) {
+	e.observed = spec
+	e.observedAt = now
+}
+
+func (e *Elector) leasesPath() string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + e.Namespace + "/leases"
+}
+
+func (e *Elector) leasePath() string {
+	return e.leasesPath() + "/" + e.Name
+}
+
+func (e *Elector) get() (lease, int, error) {
+	var l lease
+	status, err := e.do("GET", e.leasePath(), nil, &l)
+	if status == http.StatusNotFound {
+		err = nil
+	}
+	return l, status, err
+}
+
+// do makes the API request, returning its status code, and an error if there
+// was no response or it wasn't a success.
+func (e *Elector) do(method, path string, body, out interface{}) (int, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, e.host+path, &buf)
+	if err != nil {
+		return 0, err
+	}
+	token, err := e.token()
+	if err != nil {
+		return 0, fmt.Errorf("reading the service account token: %s", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(respBody))
+	}
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,135 @@
+package leader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeases is enough of the API server for one lease, checking
+// resourceVersion on updates as it does.
+type fakeLeases struct {
+	sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (f *fakeLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch r.Method {
+	case "GET":
+		if f.lease == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+		return
+	}
+
+	var l lease
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.Method == "POST" && f.lease != nil:
+		http.Error(w, "already exists", http.StatusConflict)
+		return
+	case r.Method == "PUT" && (f.lease == nil || l.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion):
+		http.Error(w, "the object has been modified", http.StatusConflict)
+		return
+	}
+	f.version++
+	l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.lease = &l
+	json.NewEncoder(w).Encode(f.lease)
+}
+
+func (f *fakeLeases) holder() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.lease.Spec.HolderIdentity
+}
+
+func TestElector(t *testing.T) {
+	leases := &fakeLeases{}
+	srv := httptest.NewServer(leases)
+	defer srv.Close()
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+	a := newElector("default", "honeyalb", "honeyalb-a", srv.URL, srv.Client())
+	b := newElector("default", "honeyalb", "honeyalb-b", srv.URL, srv.Client())
+	a.now, b.now = clock, clock
+
+	// creating the lease
+	if ok, err := a.tryAcquireOrRenew(); !ok || err != nil {
+		t.Fatalf("expected a to create the lease, got %v, %v", ok, err)
+	}
+	if ok, err := b.tryAcquireOrRenew(); ok || err != nil {
+		t.Fatalf("expected b to wait for the lease, got %v, %v", ok, err)
+	}
+
+	// renewing it keeps it
+	now = now.Add(10 * time.Second)
+	if ok, err := a.tryAcquireOrRenew(); !ok || err != nil {
+		t.Fatalf("expected a to renew the lease, got %v, %v", ok, err)
+	}
+	now = now.Add(10 * time.Second)
+	if ok, _ := b.tryAcquireOrRenew(); ok {
+		t.Fatal("expected b not to take a renewed lease")
+	}
+
+	// and once it hasn't been renewed for long enough, it's up for grabs
+	now = now.Add(defaultLeaseDuration)
+	if ok, err := b.tryAcquireOrRenew(); !ok || err != nil {
+		t.Fatalf("expected b to take over the expired lease, got %v, %v", ok, err)
+	}
+	if holder := leases.holder(); holder != "honeyalb-b" || leases.lease.Spec.LeaseTransitions != 1 {
+		t.Fatalf("unexpected lease %+v", leases.lease.Spec)
+	}
+	if ok, _ := a.tryAcquireOrRenew(); ok {
+		t.Fatal("expected a to have lost the lease")
+	}
+
+	// releasing it hands it over straight away
+	b.Release()
+	if holder := leases.holder(); holder != "" {
+		t.Fatalf("expected the lease to be released, got %s", holder)
+	}
+	if ok, _ := b.tryAcquireOrRenew(); ok {
+		t.Fatal("expected b not to take the lease again once released")
+	}
+	if ok, err := a.tryAcquireOrRenew(); !ok || err != nil {
+		t.Fatalf("expected a to take the released lease, got %v, %v", ok, err)
+	}
+}
+
+func TestElectorLost(t *testing.T) {
+	leases := &fakeLeases{}
+	srv := httptest.NewServer(leases)
+	defer srv.Close()
+
+	a := newElector("default", "honeyalb", "honeyalb-a", srv.URL, srv.Client())
+	a.RetryPeriod = 10 * time.Millisecond
+	lost := a.Acquire()
+
+	// someone else takes the lease, e.g. after we were partitioned for long
+	// enough for it to expire
+	leases.Lock()
+	leases.lease.Spec.HolderIdentity = "honeyalb-b"
+	leases.version++
+	leases.lease.Metadata.ResourceVersion = strconv.Itoa(leases.version)
+	leases.Unlock()
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("expected losing the lease to be noticed")
+	}
+}
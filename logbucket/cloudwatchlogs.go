@@ -0,0 +1,241 @@
+package logbucket
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// logGroupPoll is how often a log group is read for new events.
+const logGroupPoll = 10 * time.Second
+
+// logGroupSettle is how far back each read of a log group starts from the
+// newest event read before, since events can take a while to show up in
+// FilterLogEvents. Those read already are recognised by their ids.
+const logGroupSettle = time.Minute
+
+// LogGroupTailer reads the events of a CloudWatch Logs log group with
+// FilterLogEvents, for logs which are only sent there (e.g. API Gateway access
+// logs without a subscription to Firehose). Each page of events is written out
+// to a file and sent along as a DownloadedObject, in the format CloudWatch
+// Logs exports to S3 in, one event per line after its time.
+//
+// If the Stater is also a state.Cursorer, the time of the newest event read
+// is kept there so that a restarted tailer picks up where it left off.
+// Otherwise, or for log groups it has never read, it starts the backfill
+// interval ago.
+type LogGroupTailer struct {
+	state.Stater
+	Sess             *session.Session
+	LogGroup         string
+	BackfillInterval time.Duration
+
+	// Fields are added to every event parsed from the log group.
+	Fields map[string]interface{}
+
+	// Context, when cancelled, stops the tailer reading the log group.
+	Context context.Context
+
+	// Shard, if set, has this instance read the log group only while it
+	// holds the log group's lease.
+	Shard *Shard
+
+	// svc is made from Sess when the log group is first read.
+	svc cloudwatchlogsiface.CloudWatchLogsAPI
+
+	// from is when the tailer started reading the log group from, and
+	// newest the timestamp of the newest event read, in milliseconds.
+	from, newest int64
+
+	// seen has the ids of the events read which are recent enough to be
+	// read again, and their timestamps.
+	seen map[string]int64
+}
+
+func NewLogGroupTailer(sess *session.Session, stater state.Stater, logGroup string, backfillHr int) *LogGroupTailer {
+	return &LogGroupTailer{
+		Stater:           stater,
+		Sess:             sess,
+		LogGroup:         logGroup,
+		BackfillInterval: time.Hour * time.Duration(backfillHr),
+		Context:          context.Background(),
+		seen:             make(map[string]int64),
+	}
+}
+
+func (t *LogGroupTailer) log() *logrus.Entry {
+	return logrus.WithField("log_group", t.LogGroup)
+}
+
+func (t *LogGroupTailer) cursorPrefix() string {
+	return "logs/" + t.LogGroup
+}
+
+// start returns when to read the log group from, in milliseconds, when
+// nothing has been read from it yet.
+func (t *LogGroupTailer) start(now time.Time) int64 {
+	if cursorer, ok := t.Stater.(state.Cursorer); ok {
+		cursor, err := cursorer.Cursor(t.cursorPrefix())
+		if err != nil {
+			t.log().Error(err)
+		}
+		if cursor != "" {
+			// Events at the cursor have been read, and which
+			// they were was forgotten on restarting.
+			if ms, err := strconv.ParseInt(cursor, 10, 64); err == nil {
+				return ms + 1
+			}
+		}
+	}
+	return toMillis(now.Add(-t.BackfillInterval))
+}
+
+// LogGroupName returns the name of a log group from its ARN, e.g.
+// arn:aws:logs:us-east-1:12345:log-group:my-group:*.
+func LogGroupName(logGroupARN string) (string, error) {
+	i := strings.Index(logGroupARN, ":log-group:")
+	if i < 0 {
+		return "", fmt.Errorf("%q is not the ARN of a log group", logGroupARN)
+	}
+	return strings.TrimSuffix(logGroupARN[i+len(":log-group:"):], ":*"), nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// writeLogEvents writes the events out to a temporary file, which is removed
+// by the publisher once it has been processed.
+func writeLogEvents(events []*cloudwatchlogs.FilteredLogEvent) (string, error) {
+	f, err := ioutil.TempFile("", "hc-entity-ingest")
+	if err != nil {
+		return "", fmt.Errorf("Error creating tmp file: %s", err)
+	}
+	defer f.Close()
+
+	for _, ev := range events {
+		ts := time.Unix(0, aws.Int64Value(ev.Timestamp)*int64(time.Millisecond)).UTC()
+		if _, err := fmt.Fprintf(f, "%s %s\n", ts.Format("2006-01-02T15:04:05.000Z"), aws.StringValue(ev.Message)); err != nil {
+			os.Remove(f.Name())
+			return "", fmt.Errorf("Error writing log events to tmp file: %s", err)
+		}
+	}
+
+	return f.Name(), nil
+}
+
+// poll reads the events of the log group since it was last read, sending them
+// along a page at a time.
+func (t *LogGroupTailer) poll(now time.Time, downloadedObjects chan state.DownloadedObject) error {
+	if t.svc == nil {
+		t.svc = cloudwatchlogs.New(t.Sess)
+	}
+	if t.from == 0 {
+		t.from = t.start(now)
+		t.newest = t.from - 1
+	}
+	// Reading again from before it started could read events twice.
+	since := t.newest + 1 - int64(logGroupSettle/time.Millisecond)
+	if since < t.from {
+		since = t.from
+	}
+	for id, ts := range t.seen {
+		if ts < since {
+			delete(t.seen, id)
+		}
+	}
+
+	var sendErr error
+	err := t.svc.FilterLogEventsPagesWithContext(t.Context, &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: aws.String(t.LogGroup),
+		StartTime:    aws.Int64(since),
+		EndTime:      aws.Int64(toMillis(now)),
+	}, func(page *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
+		var events []*cloudwatchlogs.FilteredLogEvent
+		for _, ev := range page.Events {
+			id := aws.StringValue(ev.EventId)
+			if _, ok := t.seen[id]; ok {
+				continue
+			}
+			t.seen[id] = aws.Int64Value(ev.Timestamp)
+			events = append(events, ev)
+		}
+		if len(events) == 0 {
+			return true
+		}
+
+		filename, err := writeLogEvents(events)
+		if err != nil {
+			sendErr = err
+			return false
+		}
+		first := aws.Int64Value(events[0].Timestamp)
+		last := first
+		for _, ev := range events {
+			if ts := aws.Int64Value(ev.Timestamp); ts > last {
+				last = ts
+			}
+		}
+		select {
+		case downloadedObjects <- state.DownloadedObject{
+			Object:   fmt.Sprintf("%s/%d-%d/%s", t.cursorPrefix(), first, last, aws.StringValue(events[0].EventId)),
+			Filename: filename,
+			Fields:   t.Fields,
+		}:
+		case <-t.Context.Done():
+			os.Remove(filename)
+			return false
+		}
+		if last > t.newest {
+			t.newest = last
+		}
+		return true
+	})
+	if err == nil {
+		err = sendErr
+	}
+
+	if cursorer, ok := t.Stater.(state.Cursorer); ok {
+		if err := cursorer.SetCursor(t.cursorPrefix(), strconv.FormatInt(t.newest, 10)); err != nil {
+			t.log().Error(err)
+		}
+	}
+	return err
+}
+
+// Tail reads the log group every logGroupPoll until the tailer's Context is
+// cancelled.
+func (t *LogGroupTailer) Tail(downloadedObjects chan state.DownloadedObject) {
+	t.log().Info("Reading log events from CloudWatch Logs")
+	if t.Shard != nil {
+		t.Shard.Add(t.cursorPrefix())
+		defer t.Shard.Remove(t.cursorPrefix())
+	}
+	ticker := time.NewTicker(logGroupPoll)
+	defer ticker.Stop()
+	for {
+		if t.Shard != nil && !t.Shard.Holds(t.cursorPrefix()) {
+			// Whoever holds the lease moves the cursor on, which
+			// is to be read again on taking it over.
+			t.from = 0
+		} else if err := t.poll(time.Now(), downloadedObjects); err != nil && t.Context.Err() == nil {
+			t.log().WithField("error", err).Error("Error reading log events from CloudWatch Logs")
+		}
+		select {
+		case <-t.Context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
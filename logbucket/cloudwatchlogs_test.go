@@ -0,0 +1,108 @@
+package logbucket
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// fakeLogs returns the events in the time range asked for, a page each.
+type fakeLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	events []*cloudwatchlogs.FilteredLogEvent
+	starts []int64
+}
+
+func (f *fakeLogs) add(id string, ts int64, message string) {
+	f.events = append(f.events, &cloudwatchlogs.FilteredLogEvent{
+		EventId:   aws.String(id),
+		Timestamp: aws.Int64(ts),
+		Message:   aws.String(message),
+	})
+}
+
+func (f *fakeLogs) FilterLogEventsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool, opts ...request.Option) error {
+	f.starts = append(f.starts, *input.StartTime)
+	var matched []*cloudwatchlogs.FilteredLogEvent
+	for _, ev := range f.events {
+		if *ev.Timestamp >= *input.StartTime && *ev.Timestamp < *input.EndTime {
+			matched = append(matched, ev)
+		}
+	}
+	for i, ev := range matched {
+		if !fn(&cloudwatchlogs.FilterLogEventsOutput{Events: []*cloudwatchlogs.FilteredLogEvent{ev}}, i == len(matched)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// pollMessages polls the tailer, returning what was written out.
+func pollMessages(t *testing.T, tailer *LogGroupTailer, now time.Time) []string {
+	ch := make(chan state.DownloadedObject, 10)
+	if err := tailer.poll(now, ch); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	close(ch)
+	var messages []string
+	for obj := range ch {
+		data, err := ioutil.ReadFile(obj.Filename)
+		os.Remove(obj.Filename)
+		if err != nil {
+			t.Fatal("Shouldn't have err but did: ", err)
+		}
+		messages = append(messages, string(data))
+	}
+	return messages
+}
+
+func TestLogGroupTailer(t *testing.T) {
+	now := time.Date(2018, 8, 20, 11, 20, 0, 0, time.UTC)
+	stater := state.NewMemoryStater(1)
+	logs := &fakeLogs{}
+	tailer := NewLogGroupTailer(nil, stater, "API-Gateway-Access-Logs", 1)
+	tailer.svc = logs
+
+	ms := toMillis(now)
+	logs.add("1", ms-int64(2*time.Hour/time.Millisecond), `{"too":"old"}`)
+	logs.add("2", ms-1000, `{"status":"200"}`)
+
+	messages := pollMessages(t, tailer, now)
+	if len(messages) != 1 || messages[0] != "2018-08-20T11:19:59.000Z {\"status\":\"200\"}\n" {
+		t.Fatalf("Expected the event within the backfill, exported, got %q", messages)
+	}
+	if expected := ms - int64(time.Hour/time.Millisecond); logs.starts[0] != expected {
+		t.Errorf("Expected to start the backfill interval ago, at %d, got %d", expected, logs.starts[0])
+	}
+
+	// A late event older than the newest one is still read, and those read
+	// already aren't again.
+	logs.add("3", ms-2000, `{"status":"502"}`)
+	logs.add("4", ms+1000, `{"status":"404"}`)
+	messages = pollMessages(t, tailer, now.Add(5*time.Second))
+	if len(messages) != 2 || messages[0] != "2018-08-20T11:19:58.000Z {\"status\":\"502\"}\n" || messages[1] != "2018-08-20T11:20:01.000Z {\"status\":\"404\"}\n" {
+		t.Fatalf("Expected the late and new events, got %q", messages)
+	}
+
+	cursor, err := stater.Cursor(tailer.cursorPrefix())
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if expected := "1534764001000"; cursor != expected {
+		t.Errorf("Expected cursor %s, got %s", expected, cursor)
+	}
+
+	// Restarted, the tailer picks up after the cursor.
+	restarted := NewLogGroupTailer(nil, stater, "API-Gateway-Access-Logs", 1)
+	restarted.svc = logs
+	if messages := pollMessages(t, restarted, now.Add(10*time.Second)); len(messages) != 0 {
+		t.Errorf("Expected no events after the cursor, got %q", messages)
+	}
+}
@@ -0,0 +1,127 @@
+package logbucket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed formats are recognized by their magic bytes rather than by the
+// service or the object's key, since e.g. ALB logs which have been archived
+// may have been recompressed with zstd.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type decompressReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (d *decompressReader) Close() error {
+	var firstErr error
+	for _, c := range d.closers {
+		if err := c(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// OpenObject opens a downloaded log object, decompressing it if it's gzip or
+// zstd compressed and reading it as is otherwise.
+func OpenObject(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r, closeReader, err := Decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decompressReader{
+		Reader:  r,
+		closers: []func() error{closeReader, f.Close},
+	}, nil
+}
+
+// Open opens the object for reading, decompressed: its body as it's streamed
+// from S3, or the file it was downloaded to.
+func Open(obj state.DownloadedObject) (io.ReadCloser, error) {
+	if obj.Body == nil {
+		return OpenObject(obj.Filename)
+	}
+	r, closeReader, err := Decompress(streamReader{obj.Body})
+	if err != nil {
+		obj.Body.Close()
+		return nil, err
+	}
+	return &decompressReader{
+		Reader:  r,
+		closers: []func() error{closeReader, obj.Body.Close},
+	}, nil
+}
+
+// StreamError is an error reading an object's body from S3 part way through,
+// e.g. the connection being reset, as opposed to the object being corrupt.
+// Streaming it again may well work.
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return "reading object from S3: " + e.Err.Error()
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// streamReader tells the errors reading an object's body apart from those
+// decompressing or parsing it.
+type streamReader struct {
+	io.Reader
+}
+
+func (r streamReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = &StreamError{err}
+	}
+	return n, err
+}
+
+// Decompress returns a reader of the decompressed contents of r, going by its
+// first few bytes, and a func to release the decompressor once done.
+func Decompress(r io.Reader) (io.Reader, func() error, error) {
+	br := bufio.NewReader(r)
+	// A short read just means the object is too small to be compressed.
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, gz.Close, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() error {
+			zr.Close()
+			return nil
+		}, nil
+	}
+	return br, func() error { return nil }, nil
+}
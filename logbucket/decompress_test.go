@@ -0,0 +1,112 @@
+package logbucket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/klauspost/compress/zstd"
+)
+
+const testLogLines = "line one\nline two\n"
+
+func TestOpenObject(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(testLogLines))
+	gw.Close()
+
+	var zs bytes.Buffer
+	zw, err := zstd.NewWriter(&zs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(testLogLines))
+	zw.Close()
+
+	cases := []struct {
+		name     string
+		contents []byte
+		expected string
+	}{
+		{"gzip", gz.Bytes(), testLogLines},
+		{"zstd", zs.Bytes(), testLogLines},
+		{"plain", []byte(testLogLines), testLogLines},
+		{"short", []byte("x"), "x"},
+		{"empty", nil, ""},
+	}
+
+	for _, c := range cases {
+		f, err := ioutil.TempFile("", "object")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(c.contents)
+		f.Close()
+		defer os.Remove(f.Name())
+
+		r, err := OpenObject(f.Name())
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+		if string(data) != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, data)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+	}
+}
+
+func TestOpenObjectCorrupt(t *testing.T) {
+	f, err := ioutil.TempFile("", "object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the gzip magic bytes, but not a valid gzip header
+	f.Write([]byte{0x1f, 0x8b, 0x00})
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, err := OpenObject(f.Name()); err == nil {
+		t.Error("expected an error opening a corrupt gzip object")
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestOpenStreamError(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(testLogLines))
+	gz.Close()
+	// the connection dropping half way through the body
+	body := io.MultiReader(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), errReader{errors.New("connection reset by peer")})
+
+	r, err := Open(state.DownloadedObject{Object: "streamed.log.gz", Body: ioutil.NopCloser(body)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) {
+		t.Errorf("expected a StreamError reading the body, got %v", err)
+	}
+}
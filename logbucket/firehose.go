@@ -0,0 +1,116 @@
+package logbucket
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/firehose"
+)
+
+// FirehoseDownloader downloads the logs a Kinesis Data Firehose delivery
+// stream delivers to S3, under its prefix and the hour, e.g.
+// waf/2018/08/20/11/aws-waf-logs-my-stream-1-2018-08-20-11-20-00-uuid.gz.
+// Name is what the logs are of, e.g. a web ACL.
+type FirehoseDownloader struct {
+	Prefix, BucketName, Name string
+}
+
+func NewFirehoseDownloader(bucketName, bucketPrefix, name string) *FirehoseDownloader {
+	return &FirehoseDownloader{
+		BucketName: bucketName,
+		Prefix:     bucketPrefix,
+		Name:       name,
+	}
+}
+
+func (d *FirehoseDownloader) ObjectPrefix(day time.Time) string {
+	// Firehose appends the time to the prefix as it is, without a "/"
+	// between them.
+	return d.Prefix + day.Format("2006/01/02/")
+}
+
+func (d *FirehoseDownloader) String() string {
+	return d.Name
+}
+
+func (d *FirehoseDownloader) Bucket() string {
+	return d.BucketName
+}
+
+// BucketARN parses the S3 bucket, and prefix, out of the ARN of a bucket, e.g.
+// arn:aws:s3:::my-bucket/my-prefix, returning false if it isn't one.
+func BucketARN(arn string) (bucket, prefix string, ok bool) {
+	const arnPrefix = "arn:aws:s3:::"
+	if !strings.HasPrefix(arn, arnPrefix) {
+		return "", "", false
+	}
+	path := strings.TrimPrefix(arn, arnPrefix)
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[:i], strings.Trim(path[i+1:], "/"), true
+	}
+	return path, "", true
+}
+
+// IsFirehoseARN returns whether the ARN is that of a Firehose delivery stream.
+func IsFirehoseARN(arn string) bool {
+	return strings.HasPrefix(arn, "arn:aws:firehose:") && strings.Contains(arn, ":deliverystream/")
+}
+
+// FirehoseBucket looks up the S3 bucket, and prefix, a Firehose delivery
+// stream delivers to from its ARN, e.g.
+// arn:aws:firehose:us-east-1:12345:deliverystream/my-stream. Only streams
+// delivering with the default, time based, keys are supported.
+func FirehoseBucket(sess *session.Session, arn string) (bucket, prefix string, err error) {
+	if !IsFirehoseARN(arn) {
+		return "", "", fmt.Errorf("%q is not the ARN of a Firehose delivery stream", arn)
+	}
+	name := arn[strings.Index(arn, ":deliverystream/")+len(":deliverystream/"):]
+	out, err := firehose.New(sess).DescribeDeliveryStream(&firehose.DescribeDeliveryStreamInput{
+		DeliveryStreamName: aws.String(name),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	for _, dest := range out.DeliveryStreamDescription.Destinations {
+		var bucketARN, streamPrefix *string
+		if s3Dest := dest.ExtendedS3DestinationDescription; s3Dest != nil {
+			bucketARN, streamPrefix = s3Dest.BucketARN, s3Dest.Prefix
+		} else if s3Dest := dest.S3DestinationDescription; s3Dest != nil {
+			bucketARN, streamPrefix = s3Dest.BucketARN, s3Dest.Prefix
+		} else {
+			continue
+		}
+		if strings.Contains(aws.StringValue(streamPrefix), "!{") {
+			return "", "", fmt.Errorf("delivery stream %s has a custom prefix with expressions in it, which isn't supported", name)
+		}
+		bucket, _, _ := BucketARN(aws.StringValue(bucketARN))
+		return bucket, aws.StringValue(streamPrefix), nil
+	}
+	return "", "", fmt.Errorf("delivery stream %s doesn't deliver to S3", name)
+}
+
+// SubscriptionFirehose returns the ARN of the Firehose delivery stream a
+// CloudWatch Logs log group is subscribed to, if any, from the log group's
+// ARN, e.g. arn:aws:logs:us-east-1:12345:log-group:my-group:*.
+func SubscriptionFirehose(sess *session.Session, logGroupARN string) (string, error) {
+	name, err := LogGroupName(logGroupARN)
+	if err != nil {
+		return "", err
+	}
+	out, err := cloudwatchlogs.New(sess).DescribeSubscriptionFilters(&cloudwatchlogs.DescribeSubscriptionFiltersInput{
+		LogGroupName: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, filter := range out.SubscriptionFilters {
+		if arn := aws.StringValue(filter.DestinationArn); IsFirehoseARN(arn) {
+			return arn, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,90 @@
+package logbucket
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// What the downloader does about gaps when it starts: objects in the backfill
+// window which were never processed, e.g. because the process died between
+// downloading one and its state being written, after the listing cursor had
+// moved past it.
+const (
+	GapScanOff    = "off"
+	GapScanReport = "report"
+	GapScanRepair = "repair"
+)
+
+// findGaps returns the objects which should have been processed by now, but
+// weren't. Recent objects, those being resumed and those held back while
+// backfill is paused aren't gaps.
+func (d *Downloader) findGaps(objs []*s3.Object, processedObjects map[string]time.Time, offsets map[string]state.Offset, now time.Time) []*s3.Object {
+	var gaps []*s3.Object
+	for _, obj := range objs {
+		if _, ok := processedObjects[*obj.Key]; ok {
+			continue
+		}
+		if _, ok := offsets[*obj.Key]; ok {
+			continue
+		}
+		logTime := objectTime(obj)
+		if now.Sub(*obj.LastModified) < cursorSettle || now.Sub(logTime) >= d.BackfillInterval || d.Schedule.isBackfill(logTime, now) {
+			continue
+		}
+		gaps = append(gaps, obj)
+	}
+	return gaps
+}
+
+// scanGaps compares the state with the objects in the bucket for the backfill
+// window, logging the gaps, and queueing them to be processed with
+// GapScanRepair.
+func (d *Downloader) scanGaps() error {
+	now := time.Now()
+	objs, err := ListWindow(d.Sess, d.ObjectDownloader, now.Add(-d.BackfillInterval), now.Add(-cursorSettle))
+	if err != nil {
+		return err
+	}
+	processedObjects, err := d.ProcessedObjects()
+	if err != nil {
+		return err
+	}
+	offsets, err := d.Offsets()
+	if err != nil {
+		return err
+	}
+
+	gaps := d.findGaps(objs, processedObjects, offsets, now)
+	if len(gaps) == 0 {
+		d.log().WithField("objects", len(objs)).Info("No gaps found in the backfill window")
+		return nil
+	}
+
+	oldest := objectTime(gaps[0])
+	for _, obj := range gaps {
+		d.log().WithField("object", *obj.Key).Debug("Object was never processed")
+		if t := objectTime(obj); t.Before(oldest) {
+			oldest = t
+		}
+	}
+	fields := logrus.Fields{
+		"gaps":   len(gaps),
+		"oldest": oldest.Format(time.RFC3339),
+	}
+	if d.GapScan != GapScanRepair {
+		d.log().WithFields(fields).Warn("Found objects in the backfill window which were never processed, use --gap_scan=repair to process them")
+		return nil
+	}
+
+	d.log().WithFields(fields).Warn("Found objects in the backfill window which were never processed, repairing")
+	for _, obj := range gaps {
+		if d.stopped() {
+			return nil
+		}
+		d.backfillObject(processedObjects, obj)
+	}
+	return nil
+}
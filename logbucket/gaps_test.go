@@ -0,0 +1,34 @@
+package logbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestFindGaps(t *testing.T) {
+	d := &Downloader{BackfillInterval: 24 * time.Hour}
+	now := time.Now().UTC()
+	processed := backfillObject(now.Add(-3*time.Hour), 0, 1)
+	missed := backfillObject(now.Add(-2*time.Hour), 0, 1)
+	resuming := backfillObject(now.Add(-2*time.Hour), 5, 1)
+	recent := backfillObject(now.Add(-5*time.Minute), 0, 1)
+	expired := backfillObject(now.Add(-48*time.Hour), 0, 1)
+
+	// replicated just now, so not settled yet despite its age
+	replicated := backfillObject(now.Add(-4*time.Hour), 0, 1)
+	replicated.LastModified = aws.Time(now.Add(-time.Minute))
+
+	gaps := d.findGaps(
+		[]*s3.Object{processed, missed, resuming, recent, expired, replicated},
+		map[string]time.Time{*processed.Key: now},
+		map[string]state.Offset{*resuming.Key: {Lines: 10, Time: now}},
+		now,
+	)
+	if len(gaps) != 1 || gaps[0] != missed {
+		t.Errorf("expected only the missed object to be a gap, got %v", gaps)
+	}
+}
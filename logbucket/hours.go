@@ -0,0 +1,109 @@
+package logbucket
+
+import (
+	"time"
+)
+
+// HourPrefixer is implemented by the ObjectDownloaders whose keys have the
+// hour their logs are for right after their ObjectPrefix, so that with
+// --list_by_hour the hours of the backfill interval can be listed by
+// themselves, rather than the whole of today.
+type HourPrefixer interface {
+	// HourPrefix returns the prefix of the objects named for a time
+	// within the hour, or false if it can't be told, e.g. for an ALB
+	// whose ID isn't known.
+	HourPrefix(hour time.Time) (string, bool)
+}
+
+// intervalHour is the hour of the keys named for the end of a 5 minute
+// interval, e.g. 20180820T11 of ..._20180820T1120Z_....
+const intervalHour = "20060102T15"
+
+// HourPrefix is the classic load balancer's name, then the hour, e.g.
+// ..._elasticloadbalancing_us-east-1_my-lb_20180820T11.
+func (d *ELBDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.ObjectPrefix(hour) + "_" + hour.Format(intervalHour), true
+}
+
+// hourPrefix is the load balancer's ID, then the hour, after the prefix,
+// e.g. ..._app.my-lb.1db0c9806095122a_20180820T11.
+func (d *ELBDownloader) hourPrefix(prefix string, hour time.Time) (string, bool) {
+	if d.LBID == "" {
+		return "", false
+	}
+	return prefix + "." + d.LBID + "_" + hour.Format(intervalHour), true
+}
+
+func (d *ALBDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.hourPrefix(d.ObjectPrefix(hour), hour)
+}
+
+func (d *ALBConnectionLogDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.hourPrefix(d.ObjectPrefix(hour), hour)
+}
+
+func (d *NLBDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.hourPrefix(d.ObjectPrefix(hour), hour)
+}
+
+// HourPrefix is the distribution's date, then the hour, e.g.
+// E123.2018-08-20-11.
+func (d *CloudFrontDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.ObjectPrefix(hour) + hour.Format("-15."), true
+}
+
+func (d *CloudTrailDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.ObjectPrefix(hour) + "_" + hour.Format(intervalHour), true
+}
+
+func (d *FlowLogDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.ObjectPrefix(hour) + "_" + hour.Format(intervalHour), true
+}
+
+func (d *GlobalAcceleratorDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.ObjectPrefix(hour) + "_" + hour.Format(intervalHour), true
+}
+
+// HourPrefix is the hour's directory, e.g. .../my-acl/2018/08/20/11/.
+func (d *WAFDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.ObjectPrefix(hour) + hour.Format("15/"), true
+}
+
+// HourPrefix is the hour's directory, e.g. waf/2018/08/20/11/.
+func (d *FirehoseDownloader) HourPrefix(hour time.Time) (string, bool) {
+	return d.ObjectPrefix(hour) + hour.Format("15/"), true
+}
+
+// hourListing is an hour of the backfill interval to list by its prefix.
+type hourListing struct {
+	hour   time.Time
+	prefix string
+}
+
+// hourListings returns the hours from the start of the backfill interval to
+// now, with the prefixes to list them by, or false if the downloader doesn't
+// list by hour or its objects' keys can't be told.
+func (d *Downloader) hourListings(now time.Time) ([]hourListing, bool) {
+	hp, ok := d.ObjectDownloader.(HourPrefixer)
+	if !d.ListByHour || !ok {
+		return nil, false
+	}
+	now = now.UTC()
+	var listings []hourListing
+	for hour := now.Add(-d.BackfillInterval).Truncate(time.Hour); !hour.After(now); hour = hour.Add(time.Hour) {
+		prefix, ok := hp.HourPrefix(hour)
+		if !ok {
+			return nil, false
+		}
+		listings = append(listings, hourListing{hour, prefix})
+	}
+	return listings, true
+}
+
+// hourSettled returns whether a listing of the hour which started at
+// listed found all of its objects: those named for its last interval are
+// delivered within minutes of its end, so once cursorSettle has passed
+// since, no more are on their way.
+func hourSettled(hour, listed time.Time) bool {
+	return listed.Sub(hour.Add(time.Hour)) > cursorSettle
+}
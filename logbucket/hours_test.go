@@ -0,0 +1,149 @@
+package logbucket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestHourPrefixes(t *testing.T) {
+	elb := &ELBDownloader{AccountID: "12345", Region: "us-east-1", LBName: "service1", LBID: "1db0c9806095122a"}
+	testCases := []struct {
+		downloader ObjectDownloader
+		prefix     string
+	}{
+		{elb, "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_service1_20180820T11"},
+		{&ALBDownloader{elb}, "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_app.service1.1db0c9806095122a_20180820T11"},
+		{&ALBConnectionLogDownloader{elb}, "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/conn_log.12345_elasticloadbalancing_us-east-1_app.service1.1db0c9806095122a_20180820T11"},
+		{&NLBDownloader{elb}, "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_net.service1.1db0c9806095122a_20180820T11"},
+		{&CloudFrontDownloader{Prefix: "cf/", DistributionID: "E123"}, "cf/E123.2018-08-20-11."},
+		{&CloudTrailDownloader{AccountID: "12345", Region: "us-east-1"}, "AWSLogs/12345/CloudTrail/us-east-1/2018/08/20/12345_CloudTrail_us-east-1_20180820T11"},
+		{&FlowLogDownloader{AccountID: "12345", Region: "us-east-1", FlowLogID: "fl-1234abcd"}, "AWSLogs/12345/vpcflowlogs/us-east-1/2018/08/20/12345_vpcflowlogs_us-east-1_fl-1234abcd_20180820T11"},
+		{&GlobalAcceleratorDownloader{AccountID: "12345", AcceleratorID: "1234abcd"}, "AWSLogs/12345/globalaccelerator/us-west-2/2018/08/20/12345_globalaccelerator_1234abcd_20180820T11"},
+		{&WAFDownloader{AccountID: "12345", Region: "cloudfront", WebACLName: "my-acl"}, "AWSLogs/12345/WAFLogs/cloudfront/my-acl/2018/08/20/11/"},
+		{&FirehoseDownloader{Prefix: "waf/"}, "waf/2018/08/20/11/"},
+	}
+	hour := time.Date(2018, time.August, 20, 11, 0, 0, 0, time.UTC)
+	for _, tc := range testCases {
+		prefix, ok := tc.downloader.(HourPrefixer).HourPrefix(hour)
+		if !ok || prefix != tc.prefix {
+			t.Errorf("expected %s, got %s", tc.prefix, prefix)
+		}
+	}
+
+	if _, ok := (&ALBDownloader{&ELBDownloader{LBName: "service1"}}).HourPrefix(hour); ok {
+		t.Error("expected no hour prefix for an ALB without its ID")
+	}
+}
+
+func TestHourListings(t *testing.T) {
+	d := NewDownloader(nil, state.NewMemoryStater(3), &CloudFrontDownloader{DistributionID: "E123"}, 3)
+	now := time.Date(2018, time.August, 21, 1, 30, 0, 0, time.UTC)
+	if _, ok := d.hourListings(now); ok {
+		t.Error("expected no hours without ListByHour")
+	}
+
+	d.ListByHour = true
+	listings, ok := d.hourListings(now)
+	if !ok || len(listings) != 4 {
+		t.Fatalf("expected the 4 hours the backfill interval overlaps, got %v", listings)
+	}
+	if listings[0].prefix != "E123.2018-08-20-22." || listings[3].prefix != "E123.2018-08-21-01." {
+		t.Errorf("expected the hours from across midnight, got %v", listings)
+	}
+
+	d.ObjectDownloader = &ALBDownloader{&ELBDownloader{LBName: "service1"}}
+	if _, ok := d.hourListings(now); ok {
+		t.Error("expected the day to be listed when the hours can't be told")
+	}
+	d.ObjectDownloader = &PrefixDownloader{Prefix: "logs/"}
+	if _, ok := d.hourListings(now); ok {
+		t.Error("expected prefixes not to be listed by hour")
+	}
+}
+
+func TestListPrefixSettles(t *testing.T) {
+	var listed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := r.URL.Query().Get("prefix")
+		listed = append(listed, prefix)
+		fmt.Fprintf(w, `<ListBucketResult><Name>logs</Name><Prefix>%s</Prefix><KeyCount>0</KeyCount><IsTruncated>false</IsTruncated></ListBucketResult>`, prefix)
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	svc := s3.New(sess)
+
+	d := NewDownloader(sess, state.NewMemoryStater(3), &CloudFrontDownloader{BucketName: "logs", DistributionID: "E123"}, 3)
+	d.ListByHour = true
+	listings, _ := d.hourListings(time.Now())
+	for _, h := range listings {
+		l := &prefixListing{hourListing: h, input: &s3.ListObjectsV2Input{Bucket: aws.String("logs"), Prefix: aws.String(h.prefix)}}
+		if err := d.listPrefix(svc, nil, l, map[string]time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(listed) != len(listings) {
+		t.Errorf("expected each hour to be listed, got %v", listed)
+	}
+	for _, h := range listings {
+		if settled := d.settledHours[h.hour]; settled != hourSettled(h.hour, time.Now()) {
+			t.Errorf("%s: expected settled to be %v", h.hour, !settled)
+		}
+	}
+	if d.settledHours[listings[len(listings)-1].hour] {
+		t.Error("expected the current hour not to have settled")
+	}
+}
+
+// offsetCounter counts the reads of the offsets.
+type offsetCounter struct {
+	*state.MemoryStater
+	reads int
+}
+
+func (o *offsetCounter) Offsets() (map[string]state.Offset, error) {
+	o.reads++
+	return o.MemoryStater.Offsets()
+}
+
+func TestPollReadsOffsetsOnce(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<ListBucketResult><Name>logs</Name><Prefix>%s</Prefix><KeyCount>0</KeyCount><IsTruncated>false</IsTruncated></ListBucketResult>`, r.URL.Query().Get("prefix"))
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+
+	stater := &offsetCounter{MemoryStater: state.NewMemoryStater(3)}
+	d := NewDownloader(sess, stater, &CloudFrontDownloader{BucketName: "logs", DistributionID: "E123"}, 3)
+	d.ListByHour = true
+	d.BackfillOnly = true
+	if listings, _ := d.hourListings(time.Now()); len(listings) < 2 {
+		t.Fatalf("expected several hours to be listed, got %v", listings)
+	}
+	if err := d.pollObjects(); err != nil {
+		t.Fatal(err)
+	}
+	if stater.reads != 1 {
+		t.Errorf("expected the offsets to be read once for all the hours, got %d reads", stater.reads)
+	}
+}
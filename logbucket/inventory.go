@@ -0,0 +1,231 @@
+package logbucket
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// S3 Inventory manifest, see
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/storage-inventory-location.html
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+func parseInventoryManifest(data []byte) (*inventoryManifest, error) {
+	var manifest inventoryManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.FileFormat != "CSV" {
+		return nil, fmt.Errorf("S3 Inventory file format %q is not supported, only CSV is", manifest.FileFormat)
+	}
+	return &manifest, nil
+}
+
+// readInventoryFile reads the objects out of a (gzipped CSV) inventory file,
+// whose columns are given by the manifest's fileSchema.
+func readInventoryFile(r io.Reader, schema string, fn func(bucket string, obj *s3.Object)) error {
+	cols := make(map[string]int)
+	for i, col := range strings.Split(schema, ",") {
+		cols[strings.TrimSpace(col)] = i
+	}
+	for _, col := range []string{"Bucket", "Key", "Size", "LastModifiedDate"} {
+		if _, ok := cols[col]; !ok {
+			return fmt.Errorf("S3 Inventory must include the %s field", col)
+		}
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	rows := csv.NewReader(gz)
+	rows.FieldsPerRecord = -1
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) < len(cols) {
+			continue
+		}
+
+		// Keys in inventory files are URL encoded.
+		key, err := url.QueryUnescape(row[cols["Key"]])
+		if err != nil {
+			logrus.WithField("key", row[cols["Key"]]).Error("Could not decode object key from S3 Inventory")
+			continue
+		}
+		size, _ := strconv.ParseInt(row[cols["Size"]], 10, 64)
+		lastModified, err := time.Parse(time.RFC3339, row[cols["LastModifiedDate"]])
+		if err != nil {
+			logrus.WithField("key", key).Error("Could not parse last modified date from S3 Inventory")
+			continue
+		}
+
+		obj := &s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(size),
+			LastModified: aws.Time(lastModified),
+		}
+		// optional, but needed for --dedupe
+		if i, ok := cols["ETag"]; ok && row[i] != "" {
+			obj.ETag = aws.String(row[i])
+		}
+		fn(row[cols["Bucket"]], obj)
+	}
+}
+
+// InventoryBackfill backfills the downloaders added to it from an S3 Inventory
+// report of their log bucket, instead of listing the bucket. For buckets with
+// millions of log objects this is far cheaper and quicker, and unlike
+// --backfill isn't limited to the last week: every object in the inventory
+// which hasn't been processed yet is ingested.
+type InventoryBackfill struct {
+	*sync.Mutex
+	Sess        *session.Session
+	ManifestURL string
+
+	// Schedule, if set, pauses the backfill while it says to.
+	Schedule *Schedule
+
+	// Milestones, if set, is told when the backfill starts and finishes.
+	Milestones Milestones
+
+	downloaders []*Downloader
+}
+
+func NewInventoryBackfill(sess *session.Session, manifestURL string) *InventoryBackfill {
+	return &InventoryBackfill{
+		Mutex:       &sync.Mutex{},
+		Sess:        sess,
+		ManifestURL: manifestURL,
+	}
+}
+
+func (b *InventoryBackfill) Add(d *Downloader) {
+	b.Lock()
+	defer b.Unlock()
+	b.downloaders = append(b.downloaders, d)
+}
+
+// downloaderFor returns the downloader the object belongs to, if any. Log
+// objects are named for the day they cover, which is the day of their log
+// time, or the day before for logs covering the interval up to midnight.
+func (b *InventoryBackfill) downloaderFor(bucket string, obj *s3.Object) *Downloader {
+	day := objectTime(obj).UTC()
+	for _, d := range b.downloaders {
+		if d.stopped() || d.Bucket() != bucket {
+			continue
+		}
+		if strings.HasPrefix(*obj.Key, d.ObjectPrefix(day)) || strings.HasPrefix(*obj.Key, d.ObjectPrefix(day.AddDate(0, 0, -1))) {
+			return d
+		}
+	}
+	return nil
+}
+
+func (b *InventoryBackfill) getObject(bucket, key string) (io.ReadCloser, error) {
+	resp, err := s3.New(b.Sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Run reads every inventory file in the manifest, sending the objects which
+// belong to one of the downloaders along to be downloaded.
+func (b *InventoryBackfill) Run() error {
+	u, err := url.Parse(b.ManifestURL)
+	if err != nil || u.Scheme != "s3" {
+		return fmt.Errorf("S3 Inventory manifest should be an s3://bucket/key URL, got %q", b.ManifestURL)
+	}
+
+	body, err := b.getObject(u.Host, strings.TrimPrefix(u.Path, "/"))
+	if err != nil {
+		return fmt.Errorf("Error getting S3 Inventory manifest: %s", err)
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("Error reading S3 Inventory manifest: %s", err)
+	}
+	manifest, err := parseInventoryManifest(data)
+	if err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.Milestones != nil {
+		b.Milestones.BackfillStarted(b.ManifestURL)
+	}
+	processed := make(map[*Downloader]map[string]time.Time)
+	for _, d := range b.downloaders {
+		if processed[d], err = d.ProcessedObjects(); err != nil {
+			logrus.Error(err)
+		}
+	}
+
+	// The files live in the destination bucket, given as an ARN
+	inventoryBucket := strings.TrimPrefix(manifest.DestinationBucket, "arn:aws:s3:::")
+	objects := 0
+	for i, file := range manifest.Files {
+		logrus.WithFields(logrus.Fields{
+			"file":  file.Key,
+			"count": fmt.Sprintf("%d/%d", i+1, len(manifest.Files)),
+		}).Info("Reading S3 Inventory file")
+
+		body, err := b.getObject(inventoryBucket, file.Key)
+		if err != nil {
+			return fmt.Errorf("Error getting S3 Inventory file: %s", err)
+		}
+		err = readInventoryFile(body, manifest.FileSchema, func(bucket string, obj *s3.Object) {
+			if d := b.downloaderFor(bucket, obj); d != nil {
+				b.Schedule.Wait()
+				d.backfillObject(processed[d], obj)
+				objects++
+			}
+		})
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("Error reading S3 Inventory file %s: %s", file.Key, err)
+		}
+	}
+
+	logrus.WithField("objects", objects).Info("S3 Inventory backfill complete")
+	if b.Milestones != nil {
+		b.Milestones.BackfillFinished(b.ManifestURL)
+	}
+
+	return nil
+}
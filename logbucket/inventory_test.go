@@ -0,0 +1,73 @@
+package logbucket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestParseInventoryManifest(t *testing.T) {
+	manifest, err := parseInventoryManifest([]byte(`{
+		"sourceBucket": "mylogs",
+		"destinationBucket": "arn:aws:s3:::myinventory",
+		"version": "2016-11-30",
+		"fileFormat": "CSV",
+		"fileSchema": "Bucket, Key, Size, LastModifiedDate",
+		"files": [{"key": "mylogs/all/data/1.csv.gz", "size": 2046, "MD5checksum": "f11166069f1990abeb9c97ace9cdfabc"}]
+	}`))
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if manifest.DestinationBucket != "arn:aws:s3:::myinventory" || len(manifest.Files) != 1 || manifest.Files[0].Key != "mylogs/all/data/1.csv.gz" {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+
+	if _, err := parseInventoryManifest([]byte(`{"fileFormat": "ORC"}`)); err == nil {
+		t.Error("Expected an error for an unsupported file format")
+	}
+}
+
+func TestReadInventoryFile(t *testing.T) {
+	var buf bytes.Buffer
+	zipper := gzip.NewWriter(&buf)
+	zipper.Write([]byte(`"mylogs","AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_service1_20180820T0005Z_10.0.0.1_abc.log","1024","2018-08-20T00:05:12.000Z"
+"mylogs","some%20other%2Fkey","12","2018-08-20T00:05:12.000Z"
+`))
+	zipper.Close()
+
+	var objs []*s3.Object
+	if err := readInventoryFile(&buf, "Bucket, Key, Size, LastModifiedDate", func(bucket string, obj *s3.Object) {
+		if bucket != "mylogs" {
+			t.Errorf("Unexpected bucket %q", bucket)
+		}
+		objs = append(objs, obj)
+	}); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	if len(objs) != 2 {
+		t.Fatalf("Expected 2 objects, got %d", len(objs))
+	}
+	if *objs[1].Key != "some other/key" || *objs[0].Size != 1024 || !objs[0].LastModified.Equal(time.Date(2018, 8, 20, 0, 5, 12, 0, time.UTC)) {
+		t.Errorf("Unexpected objects: %v", objs)
+	}
+
+	// The downloader for the load balancer is found from the key, even
+	// though the object is from just after midnight
+	lb := &Downloader{ObjectDownloader: &ELBDownloader{
+		AccountID:  "12345",
+		Region:     "us-east-1",
+		BucketName: "mylogs",
+		LBName:     "service1",
+	}}
+	backfill := &InventoryBackfill{downloaders: []*Downloader{lb}}
+	if d := backfill.downloaderFor("mylogs", objs[0]); d != lb {
+		t.Error("Expected the object to belong to the load balancer's downloader")
+	}
+	if d := backfill.downloaderFor("mylogs", objs[1]); d != nil {
+		t.Error("Expected the object not to belong to any downloader")
+	}
+}
@@ -0,0 +1,219 @@
+package logbucket
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// KinesisConsumer reads records (e.g. CloudFront real-time logs) from every
+// shard of a Kinesis data stream. Each batch of records is written out to a
+// file and sent along as a DownloadedObject, one record per line, so that it
+// can be published just like an object downloaded from a bucket.
+//
+// If the Stater is also a state.Cursorer, the last sequence number read from
+// each shard is kept there so that a restarted consumer picks up where it left
+// off. Otherwise, or for shards it has never seen, it starts at the latest
+// record.
+type KinesisConsumer struct {
+	*sync.Mutex
+	state.Stater
+	Sess              *session.Session
+	StreamName        string
+	DownloadedObjects chan state.DownloadedObject
+	shards            map[string]bool
+}
+
+func NewKinesisConsumer(sess *session.Session, stater state.Stater, streamName string) *KinesisConsumer {
+	return &KinesisConsumer{
+		Mutex:      &sync.Mutex{},
+		Stater:     stater,
+		Sess:       sess,
+		StreamName: streamName,
+		shards:     make(map[string]bool),
+	}
+}
+
+func (c *KinesisConsumer) cursorPrefix(shardID string) string {
+	return "kinesis/" + c.StreamName + "/" + shardID
+}
+
+func (c *KinesisConsumer) shardIterator(svc *kinesis.Kinesis, shardID string) (*string, error) {
+	input := &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String(c.StreamName),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(kinesis.ShardIteratorTypeLatest),
+	}
+
+	if cursorer, ok := c.Stater.(state.Cursorer); ok {
+		seq, err := cursorer.Cursor(c.cursorPrefix(shardID))
+		if err != nil {
+			logrus.Error(err)
+		}
+		if seq != "" {
+			input.ShardIteratorType = aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber)
+			input.StartingSequenceNumber = aws.String(seq)
+		}
+	}
+
+	resp, err := svc.GetShardIterator(input)
+	if err != nil {
+		return nil, err
+	}
+	return resp.ShardIterator, nil
+}
+
+// writeRecords writes the records out to a temporary file, which is removed
+// by the publisher once it has been processed.
+func writeRecords(records []*kinesis.Record) (string, error) {
+	f, err := ioutil.TempFile("", "hc-entity-ingest")
+	if err != nil {
+		return "", fmt.Errorf("Error creating tmp file: %s", err)
+	}
+	defer f.Close()
+
+	for _, rec := range records {
+		data := rec.Data
+		if len(data) == 0 || data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		if _, err := f.Write(data); err != nil {
+			os.Remove(f.Name())
+			return "", fmt.Errorf("Error writing records to tmp file: %s", err)
+		}
+	}
+
+	return f.Name(), nil
+}
+
+func (c *KinesisConsumer) consumeShard(shardID string) {
+	svc := kinesis.New(c.Sess)
+
+	iter, err := c.shardIterator(svc, shardID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting Kinesis shard iterator: ", err)
+		os.Exit(1)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"stream": c.StreamName,
+		"shard":  shardID,
+	}).Info("Consuming records from Kinesis shard")
+
+	// A nil iterator means the shard has been closed (e.g. by a
+	// reshard) and fully read, its children are picked up by
+	// listShards.
+	for iter != nil {
+		resp, err := svc.GetRecords(&kinesis.GetRecordsInput{
+			ShardIterator: iter,
+		})
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"stream": c.StreamName,
+				"shard":  shardID,
+				"error":  err,
+			}).Error("Error getting records from Kinesis")
+			time.Sleep(5 * time.Second)
+
+			// Iterators expire after 5 minutes, so get a new
+			// one starting from the last record we read.
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == kinesis.ErrCodeExpiredIteratorException {
+				if iter, err = c.shardIterator(svc, shardID); err != nil {
+					fmt.Fprintln(os.Stderr, "Error getting Kinesis shard iterator: ", err)
+					os.Exit(1)
+				}
+			}
+			continue
+		}
+		iter = resp.NextShardIterator
+
+		if len(resp.Records) == 0 {
+			// Shards only allow for 5 reads a second
+			time.Sleep(time.Second)
+			continue
+		}
+
+		first := *resp.Records[0].SequenceNumber
+		last := *resp.Records[len(resp.Records)-1].SequenceNumber
+
+		filename, err := writeRecords(resp.Records)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+
+		c.DownloadedObjects <- state.DownloadedObject{
+			Object:   c.cursorPrefix(shardID) + "/" + first + "-" + last,
+			Filename: filename,
+		}
+
+		if cursorer, ok := c.Stater.(state.Cursorer); ok {
+			if err := cursorer.SetCursor(c.cursorPrefix(shardID), last); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"stream": c.StreamName,
+		"shard":  shardID,
+	}).Info("Kinesis shard closed")
+}
+
+// listShards starts consuming any shards of the stream which aren't being
+// consumed already.
+func (c *KinesisConsumer) listShards(svc *kinesis.Kinesis) error {
+	input := &kinesis.ListShardsInput{
+		StreamName: aws.String(c.StreamName),
+	}
+
+	for {
+		resp, err := svc.ListShards(input)
+		if err != nil {
+			return err
+		}
+
+		c.Lock()
+		for _, shard := range resp.Shards {
+			if c.shards[*shard.ShardId] {
+				continue
+			}
+			c.shards[*shard.ShardId] = true
+			go c.consumeShard(*shard.ShardId)
+		}
+		c.Unlock()
+
+		if resp.NextToken == nil {
+			return nil
+		}
+		input = &kinesis.ListShardsInput{NextToken: resp.NextToken}
+	}
+}
+
+func (c *KinesisConsumer) Consume(downloadedObjects chan state.DownloadedObject) {
+	c.DownloadedObjects = downloadedObjects
+
+	svc := kinesis.New(c.Sess)
+	if err := c.listShards(svc); err != nil {
+		fmt.Fprintln(os.Stderr, "Error listing Kinesis shards: ", err)
+		os.Exit(1)
+	}
+
+	// Pick up new shards as the stream is resharded
+	go func() {
+		for range time.NewTicker(time.Minute).C {
+			if err := c.listShards(svc); err != nil {
+				logrus.WithField("error", err).Error("Error listing Kinesis shards")
+			}
+		}
+	}()
+}
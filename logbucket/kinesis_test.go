@@ -0,0 +1,28 @@
+package logbucket
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+func TestWriteRecords(t *testing.T) {
+	filename, err := writeRecords([]*kinesis.Record{
+		{Data: []byte("first\trecord\n")},
+		{Data: []byte("second\trecord")},
+	})
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	defer os.Remove(filename)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if expected := "first\trecord\nsecond\trecord\n"; string(data) != expected {
+		t.Errorf("Expected one record per line, got %q", data)
+	}
+}
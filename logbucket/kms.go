@@ -0,0 +1,58 @@
+package logbucket
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// ValidateKMSKey checks that the KMS key the log bucket's objects are
+// encrypted with, for --kms-key-arn, can be described and is enabled, so that
+// a key which is gone or disabled is reported on startup rather than by every
+// download failing. Being able to describe the key doesn't mean being allowed
+// kms:Decrypt with it, which only downloading an object shows.
+func ValidateKMSKey(sess *session.Session, keyARN string) error {
+	return validateKMSKey(kms.New(sess), keyARN)
+}
+
+func validateKMSKey(svc kmsiface.KMSAPI, keyARN string) error {
+	resp, err := svc.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(keyARN)})
+	if err != nil {
+		return fmt.Errorf("Could not describe KMS key %s, check that it exists and that the agent is allowed kms:DescribeKey and kms:Decrypt on it: %s", keyARN, err)
+	}
+	if state := aws.StringValue(resp.KeyMetadata.KeyState); state != kms.KeyStateEnabled {
+		return fmt.Errorf("KMS key %s is %s, so objects encrypted with it can't be decrypted", keyARN, state)
+	}
+	return nil
+}
+
+// explainAccessDenied adds why downloading the object may have been denied
+// to the error, if it was: objects encrypted with SSE-KMS can't be downloaded
+// without kms:Decrypt on their key, even with s3:GetObject, which HeadObject
+// tells apart since it doesn't need the key.
+func explainAccessDenied(svc s3iface.S3API, bucket, key, kmsKeyARN string, err error) error {
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "AccessDenied" {
+		return err
+	}
+	head, headErr := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if headErr != nil {
+		return fmt.Errorf("%s (check that the agent is allowed s3:GetObject on the bucket, and --requester-pays if it's a requester pays bucket)", err)
+	}
+	if aws.StringValue(head.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms {
+		return err
+	}
+	objectKey := aws.StringValue(head.SSEKMSKeyId)
+	if kmsKeyARN != "" && objectKey != kmsKeyARN {
+		return fmt.Errorf("%s (the object is encrypted with KMS key %s, not --kms-key-arn %s)", err, objectKey, kmsKeyARN)
+	}
+	return fmt.Errorf("%s (the object is encrypted with KMS key %s, check that the agent is allowed kms:Decrypt on it)", err, objectKey)
+}
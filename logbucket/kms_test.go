@@ -0,0 +1,80 @@
+package logbucket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	state string
+}
+
+func (f *fakeKMS) DescribeKey(input *kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	if f.state == "" {
+		return nil, awserr.New("NotFoundException", "key not found", nil)
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{KeyId: input.KeyId, KeyState: aws.String(f.state)}}, nil
+}
+
+type fakeHeadS3 struct {
+	s3iface.S3API
+	head *s3.HeadObjectOutput
+}
+
+func (f *fakeHeadS3) HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if f.head == nil {
+		return nil, awserr.New("Forbidden", "Forbidden", nil)
+	}
+	return f.head, nil
+}
+
+func TestValidateKMSKey(t *testing.T) {
+	const key = "arn:aws:kms:us-east-1:123456789012:key/1234abcd"
+	if err := validateKMSKey(&fakeKMS{state: kms.KeyStateEnabled}, key); err != nil {
+		t.Error("Shouldn't have err but did: ", err)
+	}
+	if err := validateKMSKey(&fakeKMS{state: kms.KeyStateDisabled}, key); err == nil {
+		t.Error("Expected err for a disabled key")
+	}
+	if err := validateKMSKey(&fakeKMS{}, key); err == nil {
+		t.Error("Expected err for a missing key")
+	}
+}
+
+func TestExplainAccessDenied(t *testing.T) {
+	const key = "arn:aws:kms:us-east-1:123456789012:key/1234abcd"
+	denied := awserr.New("AccessDenied", "Access Denied", nil)
+	encrypted := &fakeHeadS3{head: &s3.HeadObjectOutput{
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAwsKms),
+		SSEKMSKeyId:          aws.String(key),
+	}}
+
+	testCases := []struct {
+		svc       s3iface.S3API
+		kmsKeyARN string
+		err       error
+		expected  string
+	}{
+		{encrypted, "", errors.New("connection reset"), "connection reset"},
+		{encrypted, "", denied, "allowed kms:Decrypt on it"},
+		{encrypted, key, denied, "allowed kms:Decrypt on it"},
+		{encrypted, "arn:aws:kms:us-east-1:123456789012:key/other", denied, "not --kms-key-arn"},
+		{&fakeHeadS3{head: &s3.HeadObjectOutput{}}, "", denied, "Access Denied"},
+		{&fakeHeadS3{}, "", denied, "--requester-pays"},
+	}
+	for _, tc := range testCases {
+		err := explainAccessDenied(tc.svc, "logs", "key", tc.kmsKeyARN, tc.err)
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Expected %q to explain %q", err, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package logbucket
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const s3LimiterHandler = "honeyaws.S3Limiter"
+
+// S3Limiter limits the S3 API calls (listing, downloading, checking bucket
+// policies...) made with the sessions it's added to, so that big backfills
+// don't get throttled by S3, however many load balancers (or distributions,
+// or trails) are being ingested. Retries count as calls too.
+type S3Limiter struct {
+	// slots has one for each call in flight, if concurrency is limited.
+	slots chan struct{}
+
+	// tokens is a token bucket refilled at rate a second, up to burst, if
+	// the rate is limited.
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewS3Limiter returns a limiter allowing at most maxConcurrency S3 calls at
+// once and requestsPerSecond of them, where 0 doesn't limit either. It returns
+// nil, which doesn't limit anything, if neither is limited.
+func NewS3Limiter(maxConcurrency int, requestsPerSecond float64) *S3Limiter {
+	if maxConcurrency <= 0 && requestsPerSecond <= 0 {
+		return nil
+	}
+	l := &S3Limiter{now: time.Now, sleep: time.Sleep}
+	if maxConcurrency > 0 {
+		l.slots = make(chan struct{}, maxConcurrency)
+	}
+	if requestsPerSecond > 0 {
+		// a second's worth of calls can be made at once
+		l.rate = requestsPerSecond
+		l.burst = math.Max(1, math.Ceil(requestsPerSecond))
+		l.tokens = l.burst
+		l.last = l.now()
+	}
+	return l
+}
+
+// Add limits the S3 calls made with the session, and the sessions copied from
+// it afterwards, e.g. for other regions or assumed roles.
+func (l *S3Limiter) Add(sess *session.Session) {
+	if l == nil {
+		return
+	}
+	// added once, even if the session is shared
+	sess.Handlers.Send.Remove(request.NamedHandler{Name: s3LimiterHandler})
+	sess.Handlers.CompleteAttempt.Remove(request.NamedHandler{Name: s3LimiterHandler})
+
+	sess.Handlers.Send.PushFrontNamed(request.NamedHandler{
+		Name: s3LimiterHandler,
+		Fn: func(r *request.Request) {
+			if r.ClientInfo.ServiceName == s3.ServiceName {
+				l.acquire()
+			}
+		},
+	})
+	sess.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{
+		Name: s3LimiterHandler,
+		Fn: func(r *request.Request) {
+			if r.ClientInfo.ServiceName == s3.ServiceName {
+				l.release()
+			}
+		},
+	})
+}
+
+// acquire waits for a token, then for a slot.
+func (l *S3Limiter) acquire() {
+	if l.rate > 0 {
+		l.lock.Lock()
+		now := l.now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		// Tokens are taken before they've been refilled, so that the
+		// calls waiting are spaced out rather than all let go at once.
+		l.tokens--
+		wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+		l.lock.Unlock()
+		if wait > 0 {
+			l.sleep(wait)
+		}
+	}
+	if l.slots != nil {
+		l.slots <- struct{}{}
+	}
+}
+
+func (l *S3Limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,108 @@
+package logbucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestS3LimiterRate(t *testing.T) {
+	l := NewS3Limiter(0, 2)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	var slept time.Duration
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	l.last = now
+	l.tokens = l.burst
+
+	// a second's worth at once, then one every half a second
+	for i := 0; i < 2; i++ {
+		l.acquire()
+	}
+	if slept != 0 {
+		t.Errorf("expected the burst not to wait, slept %s", slept)
+	}
+	for i := 0; i < 4; i++ {
+		l.acquire()
+	}
+	if slept != 2*time.Second {
+		t.Errorf("expected 4 more calls at 2/s to wait 2s, slept %s", slept)
+	}
+
+	// idle time refills the bucket, but only up to the burst
+	now = now.Add(time.Minute)
+	slept = 0
+	for i := 0; i < 3; i++ {
+		l.acquire()
+	}
+	if slept != 500*time.Millisecond {
+		t.Errorf("expected only the call after the burst to wait, slept %s", slept)
+	}
+}
+
+func TestS3LimiterSession(t *testing.T) {
+	var inFlight, maxInFlight, calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		atomic.AddInt32(&calls, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`<ListBucketResult><IsTruncated>false</IsTruncated></ListBucketResult>`))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+	}))
+	l := NewS3Limiter(2, 0)
+	l.Add(sess)
+	l.Add(sess)
+	// copies, e.g. for other regions, share the limit
+	copied := sess.Copy(&aws.Config{Region: aws.String("us-west-2")})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(sess *session.Session) {
+			defer wg.Done()
+			if _, err := s3.New(sess).ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("bucket")}); err != nil {
+				t.Error(err)
+			}
+		}([]*session.Session{sess, copied}[i%2])
+	}
+	wg.Wait()
+
+	if calls != 8 {
+		t.Errorf("expected 8 calls, got %d", calls)
+	}
+	if maxInFlight != 2 {
+		t.Errorf("expected at most 2 calls at once, got %d", maxInFlight)
+	}
+	if len(l.slots) != 0 {
+		t.Errorf("expected every slot to be released, %d are held", len(l.slots))
+	}
+
+	if NewS3Limiter(0, 0) != nil {
+		t.Error("expected no limiter without limits")
+	}
+}
@@ -0,0 +1,86 @@
+package logbucket
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// Stdin is the path LocalFiles reads from standard input for.
+const Stdin = "-"
+
+// localPaths returns the files to ingest, in order, with every file under a
+// directory in place of the directory.
+func localPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if path == Stdin {
+			files = append(files, path)
+			continue
+		}
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// stdinObject writes standard input to a temporary file, since objects are
+// parsed from files, to be removed once published as a download would be.
+func stdinObject(stdin io.Reader) (state.DownloadedObject, error) {
+	f, err := ioutil.TempFile("", "hc-entity-ingest")
+	if err != nil {
+		return state.DownloadedObject{}, fmt.Errorf("Error creating tmp file: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, stdin); err != nil {
+		os.Remove(f.Name())
+		return state.DownloadedObject{}, fmt.Errorf("Error reading stdin: %s", err)
+	}
+	return state.DownloadedObject{Object: "stdin", Filename: f.Name()}, nil
+}
+
+// LocalFiles sends log files from the local filesystem on downloads to be
+// published as if they'd been downloaded from a bucket, e.g. ones exported
+// from another system, closing it once done. Directories are read
+// recursively, and Stdin (or no paths at all) reads standard input. They may
+// be compressed as objects can be, and unlike downloaded objects they're left
+// in place once published.
+func LocalFiles(paths []string, downloads chan<- state.DownloadedObject) error {
+	defer close(downloads)
+
+	if len(paths) == 0 {
+		paths = []string{Stdin}
+	}
+	files, err := localPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file == Stdin {
+			obj, err := stdinObject(os.Stdin)
+			if err != nil {
+				return err
+			}
+			downloads <- obj
+			continue
+		}
+		downloads <- state.DownloadedObject{Object: file, Filename: file, Local: true}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package logbucket
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestLocalFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"b.log", "a/1.log.gz", "a/2.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	downloads := make(chan state.DownloadedObject, 10)
+	if err := LocalFiles([]string{filepath.Join(dir, "b.log"), filepath.Join(dir, "a")}, downloads); err != nil {
+		t.Fatal(err)
+	}
+	var objects []string
+	for obj := range downloads {
+		if !obj.Local || obj.Filename != obj.Object {
+			t.Errorf("expected %v to be published in place", obj)
+		}
+		objects = append(objects, strings.TrimPrefix(obj.Object, dir+"/"))
+	}
+	if strings.Join(objects, ",") != "b.log,a/1.log.gz,a/2.log" {
+		t.Errorf("unexpected objects %v", objects)
+	}
+
+	if err := LocalFiles([]string{filepath.Join(dir, "missing.log")}, make(chan state.DownloadedObject)); err == nil {
+		t.Error("expected a missing file to be an error")
+	}
+}
+
+func TestStdinObject(t *testing.T) {
+	obj, err := stdinObject(strings.NewReader("line\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(obj.Filename)
+
+	if obj.Local {
+		t.Error("expected the copy of stdin to be removed once published")
+	}
+	data, err := ioutil.ReadFile(obj.Filename)
+	if err != nil || string(data) != "line\n" {
+		t.Errorf("expected stdin to be copied, got %q (%v)", data, err)
+	}
+}
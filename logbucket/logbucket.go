@@ -1,26 +1,54 @@
 package logbucket
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/honeycombio/honeyaws/health"
 	"github.com/honeycombio/honeyaws/meta"
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/retry"
 	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeyaws/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Buckets are polled every 5 minutes, so a poller which hasn't made any
+// progress listing in this long is considered to be wedged.
+const pollTimeout = 15 * time.Minute
+
+// Logs are delivered every 5 minutes, so give stragglers plenty of room to land
+// before listing past them.
+const cursorSettle = 15 * time.Minute
+
+// Progress through an object being published is recorded every few seconds,
+// so one whose progress hasn't been recorded in this long was left unfinished.
+const offsetStale = 5 * time.Minute
+
 const (
 	AWSElasticLoadBalancing   = "elasticloadbalancing"
 	AWSElasticLoadBalancingV2 = "elasticloadbalancingv2"
+	AWSNetworkLoadBalancing   = "networkloadbalancing"
 	AWSCloudFront             = "cloudfront"
 	AWSCloudTrail             = "cloudtrail"
+	AWSVPCFlowLogs            = "vpcflowlogs"
+	AWSWAFLogs                = "waflogs"
+	AWSAPIGateway             = "apigateway"
+	AWSGlobalAccelerator      = "globalaccelerator"
 	alb                       = "alb"
 	elb                       = "elb"
 )
@@ -37,6 +65,13 @@ type ObjectDownloader interface {
 	Bucket() string
 }
 
+// Milestones is told when ingesting reaches a milestone, e.g. to mark it in
+// Honeycomb.
+type Milestones interface {
+	BackfillStarted(entity string)
+	BackfillFinished(entity string)
+}
+
 // Wrapper struct used to unite the specific structs with common methods.
 type Downloader struct {
 	state.Stater
@@ -45,6 +80,135 @@ type Downloader struct {
 	DownloadedObjects chan state.DownloadedObject
 	ObjectsToDownload chan *s3.Object
 	BackfillInterval  time.Duration
+
+	// BackfillOnly stops polling the bucket after the first listing, for
+	// when new objects are delivered by an SQSListener instead.
+	BackfillOnly bool
+
+	// Fields are added to every event parsed from the downloaded objects.
+	Fields map[string]interface{}
+
+	// WorkQueue, if set, is handed the objects found by polling the
+	// bucket instead of them being downloaded, for --role=lister.
+	WorkQueue *WorkQueue
+
+	// NoPolling stops the bucket from being listed at all, for
+	// --role=worker where objects come from the work queue.
+	NoPolling bool
+
+	// Schedule, if set, holds back objects older than liveWindow while
+	// backfill is paused.
+	Schedule *Schedule
+
+	// Pool, if set, is shared with other downloaders to limit how many
+	// objects are downloaded at once.
+	Pool *DownloadPool
+
+	// ErrorRate, if set, parses a downloaded object for the fraction of
+	// its requests which got a 5xx, so that the hours of backfill with
+	// the most errors are ingested first.
+	ErrorRate func(obj state.DownloadedObject) (float64, error)
+
+	// GapScan is what to do, when first listing the bucket, about objects
+	// in the backfill window which were never processed: GapScanReport,
+	// GapScanRepair or nothing.
+	GapScan     string
+	gapsScanned bool
+
+	// Milestones, if set, is told when the first listing of the bucket,
+	// which backfills the objects in the backfill window, starts and
+	// finishes. Listings which fail are retried without telling it again.
+	Milestones       Milestones
+	backfillStarted  bool
+	backfillFinished bool
+
+	// Shard, if set, has this instance list the bucket only while it holds
+	// the downloader's lease, for --shard.
+	Shard *Shard
+
+	// TimeRange, if set, has the downloader download the objects in the
+	// range once instead of polling the bucket, for --start-time.
+	TimeRange *TimeRange
+
+	// KMSKeyARN, if set, is the key the bucket's objects are expected to
+	// be encrypted with, for --kms-key-arn, which downloads denied access
+	// are checked against.
+	KMSKeyARN string
+
+	// Retry is how many times, and how far apart, downloading an object is
+	// retried before it's recorded as a dead letter, if the Stater keeps
+	// them.
+	Retry retry.Policy
+
+	// MissingRechecks is how many times objects which weren't found when
+	// they were downloaded, e.g. because S3 listed them before it would
+	// serve them, are checked for again, MissingRecheckDelay apart, for
+	// --missing_rechecks and --missing_recheck_delay, instead of being
+	// retried right away and recorded as dead letters. They're recorded as
+	// missing in the state meanwhile, if the Stater is a Rechecker. 0
+	// treats them like any other failure.
+	MissingRechecks     int
+	MissingRecheckDelay time.Duration
+	rechecking          sync.WaitGroup
+
+	// Context, if set, stops the downloader once it's done, e.g. when
+	// shutting down.
+	Context context.Context
+
+	// ListByHour, for --list_by_hour, has the bucket polled by the
+	// prefixes of the hours of the backfill interval rather than by that
+	// of the whole of today, if the ObjectDownloader is a HourPrefixer.
+	// Hours which have settled since they were listed aren't listed again.
+	ListByHour   bool
+	settledHours map[time.Time]bool
+
+	// Dedupe skips objects whose contents, going by their ETags, were
+	// already processed under another key, if the Stater keeps them, for
+	// --dedupe.
+	Dedupe bool
+
+	// ExactlyOnce, for --exactly_once, has unfinished objects which were
+	// published in full left alone rather than resumed, and the others
+	// claimed before they're resumed, so that only one instance resumes
+	// each, if the Stater is a Confirmer.
+	ExactlyOnce bool
+
+	// DownloadToFile downloads each object to a temporary file before it's
+	// parsed, for --download-to-file, instead of its body being parsed as
+	// it's streamed from S3.
+	DownloadToFile bool
+
+	// ProgressInterval, if set, is how often progress through the backfill
+	// is logged, exported and recorded in the state, for
+	// --progress_interval.
+	ProgressInterval time.Duration
+	progress         progress
+
+	// Tail, for --mode=tail, has the downloader skip the objects delivered
+	// before it started, leaving them to be backfilled, e.g. by an
+	// instance with --mode=backfill.
+	Tail      bool
+	tailSince time.Time
+
+	// backfill has the objects held back from the current listing to be
+	// queued by error density.
+	backfill []*s3.Object
+
+	// heldBack is set when the current listing held back objects, so
+	// that the cursor isn't moved past them.
+	heldBack bool
+
+	pollLock sync.Mutex
+	polled   time.Time
+	pollDone bool
+
+	// resuming has how far publishing got into unfinished objects which
+	// have been queued to be published again.
+	resumeLock sync.Mutex
+	resuming   map[string]int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func NewDownloader(sess *session.Session, stater state.Stater, downloader ObjectDownloader, backfill int) *Downloader {
@@ -55,17 +219,44 @@ func NewDownloader(sess *session.Session, stater state.Stater, downloader Object
 		DownloadedObjects: make(chan state.DownloadedObject),
 		ObjectsToDownload: make(chan *s3.Object),
 		BackfillInterval:  time.Hour * time.Duration(backfill),
+		stop:              make(chan struct{}),
 	}
 }
 
+// log returns a logger with the fields every message about the downloader
+// has: the entity (load balancer, distribution, trail or flow log) and its
+// bucket.
+func (d *Downloader) log() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"entity": d.String(),
+		"bucket": d.Bucket(),
+	})
+}
+
 type ELBDownloader struct {
 	Prefix, BucketName, AccountID, Region, LBName, LBType string
+
+	// LBID is the ALB or NLB's ID, e.g. 1db0c9806095122a of
+	// app/my-lb/1db0c9806095122a, which the hours of its logs are
+	// listed by with --list_by_hour.
+	LBID string
 }
 
 type ALBDownloader struct {
 	*ELBDownloader
 }
 
+type NLBDownloader struct {
+	*ELBDownloader
+}
+
+// ALBConnectionLogDownloader downloads an ALB's connection logs, which record
+// the TLS handshake of each connection to its listeners. They're delivered
+// alongside the access logs, with conn_log. ahead of the account ID.
+type ALBConnectionLogDownloader struct {
+	*ELBDownloader
+}
+
 type CloudFrontDownloader struct {
 	Prefix, BucketName, DistributionID string
 }
@@ -100,6 +291,108 @@ func (d *CloudTrailDownloader) Bucket() string {
 	return d.BucketName
 }
 
+// FlowLogDownloader downloads the VPC Flow Logs of a flow log delivered to
+// S3, e.g. ..._vpcflowlogs_us-east-1_fl-1234abcd_20180820T1120Z_hash.log.gz.
+type FlowLogDownloader struct {
+	Prefix, BucketName, AccountID, Region, FlowLogID string
+}
+
+func NewFlowLogDownloader(sess *session.Session, bucketName, bucketPrefix, flowLogID string) *FlowLogDownloader {
+	metadata := meta.Data(sess)
+	return &FlowLogDownloader{
+		AccountID:  metadata.AccountID,
+		Region:     metadata.Region,
+		BucketName: bucketName,
+		Prefix:     bucketPrefix,
+		FlowLogID:  flowLogID,
+	}
+}
+
+func (d *FlowLogDownloader) ObjectPrefix(day time.Time) string {
+	dayPath := day.Format("2006/01/02")
+	return filepath.Join(d.Prefix, "AWSLogs", d.AccountID, AWSVPCFlowLogs,
+		d.Region, dayPath, d.AccountID+"_"+AWSVPCFlowLogs+"_"+d.Region+"_"+d.FlowLogID)
+}
+
+func (d *FlowLogDownloader) String() string {
+	return d.FlowLogID
+}
+
+func (d *FlowLogDownloader) Bucket() string {
+	return d.BucketName
+}
+
+// GlobalAcceleratorRegion is the region the flow logs of accelerators, which
+// are global, are delivered under.
+const GlobalAcceleratorRegion = "us-west-2"
+
+// GlobalAcceleratorDownloader downloads the flow logs of a Global Accelerator
+// accelerator delivered to S3, e.g.
+// .../AWSLogs/12345/globalaccelerator/us-west-2/2020/05/06/12345_globalaccelerator_1234abcd-abcd-1234-abcd-1234abcdefgh_20200506T0100Z_hash.log.gz.
+type GlobalAcceleratorDownloader struct {
+	Prefix, BucketName, AccountID, AcceleratorID string
+}
+
+func NewGlobalAcceleratorDownloader(sess *session.Session, bucketName, bucketPrefix, acceleratorID string) *GlobalAcceleratorDownloader {
+	metadata := meta.Data(sess)
+	return &GlobalAcceleratorDownloader{
+		AccountID:     metadata.AccountID,
+		BucketName:    bucketName,
+		Prefix:        bucketPrefix,
+		AcceleratorID: acceleratorID,
+	}
+}
+
+func (d *GlobalAcceleratorDownloader) ObjectPrefix(day time.Time) string {
+	dayPath := day.Format("2006/01/02")
+	return filepath.Join(d.Prefix, "AWSLogs", d.AccountID, AWSGlobalAccelerator,
+		GlobalAcceleratorRegion, dayPath, d.AccountID+"_"+AWSGlobalAccelerator+"_"+d.AcceleratorID)
+}
+
+func (d *GlobalAcceleratorDownloader) String() string {
+	return d.AcceleratorID
+}
+
+func (d *GlobalAcceleratorDownloader) Bucket() string {
+	return d.BucketName
+}
+
+// WAFCloudFrontRegion is the region the logs of web ACLs for CloudFront
+// distributions, which are global, are delivered under.
+const WAFCloudFrontRegion = "cloudfront"
+
+// WAFDownloader downloads the logs of a WAF web ACL delivered to S3 directly,
+// e.g. .../AWSLogs/12345/WAFLogs/us-east-1/my-acl/2018/08/20/11/20/12345_waflogs_us-east-1_my-acl_20180820T1120Z_hash.log.gz.
+// Those delivered by Firehose are downloaded with a FirehoseDownloader.
+type WAFDownloader struct {
+	Prefix, BucketName, AccountID, Region, WebACLName string
+}
+
+func NewWAFDownloader(sess *session.Session, bucketName, bucketPrefix, webACLName string) *WAFDownloader {
+	metadata := meta.Data(sess)
+	return &WAFDownloader{
+		AccountID:  metadata.AccountID,
+		Region:     metadata.Region,
+		BucketName: bucketName,
+		Prefix:     bucketPrefix,
+		WebACLName: webACLName,
+	}
+}
+
+func (d *WAFDownloader) ObjectPrefix(day time.Time) string {
+	dayPath := day.Format("2006/01/02")
+	return filepath.Join(d.Prefix, "AWSLogs", d.AccountID, "WAFLogs",
+		d.Region, d.WebACLName, dayPath) + "/"
+}
+
+func (d *WAFDownloader) String() string {
+	return d.WebACLName
+}
+
+func (d *WAFDownloader) Bucket() string {
+	return d.BucketName
+}
+
 func NewCloudFrontDownloader(bucketName, bucketPrefix, distID string) *CloudFrontDownloader {
 	return &CloudFrontDownloader{
 		BucketName:     bucketName,
@@ -156,46 +449,176 @@ func (d *ALBDownloader) ObjectPrefix(day time.Time) string {
 		d.AccountID+"_"+AWSElasticLoadBalancing+"_"+d.Region+"_app."+d.LBName)
 }
 
+func NewALBConnectionLogDownloader(sess *session.Session, bucketName, bucketPrefix, lbName string) *ALBConnectionLogDownloader {
+	return &ALBConnectionLogDownloader{NewELBDownloader(sess, bucketName, bucketPrefix, lbName)}
+}
+
+func (d *ALBConnectionLogDownloader) ObjectPrefix(day time.Time) string {
+	dayPath := day.Format("/2006/01/02")
+	return filepath.Join(d.Prefix, "AWSLogs/", d.AccountID, AWSElasticLoadBalancing, d.Region+dayPath,
+		"conn_log."+d.AccountID+"_"+AWSElasticLoadBalancing+"_"+d.Region+"_app."+d.LBName)
+}
+
+// String tells the connection logs apart from the same ALB's access logs in
+// logs, metrics and health checks.
+func (d *ALBConnectionLogDownloader) String() string {
+	return d.LBName + "/connections"
+}
+
+func NewNLBDownloader(sess *session.Session, bucketName, bucketPrefix, lbName string) *NLBDownloader {
+	return &NLBDownloader{NewELBDownloader(sess, bucketName, bucketPrefix, lbName)}
+}
+
+func (d *NLBDownloader) ObjectPrefix(day time.Time) string {
+	dayPath := day.Format("/2006/01/02")
+	return filepath.Join(d.Prefix, "AWSLogs/", d.AccountID, AWSElasticLoadBalancing, d.Region+dayPath,
+		d.AccountID+"_"+AWSElasticLoadBalancing+"_"+d.Region+"_net."+d.LBName)
+}
+
+// errStopped is returned for objects given up on because the downloader has
+// been stopped.
+var errStopped = errors.New("downloader stopped")
+
 func (d *Downloader) downloadObject(obj *s3.Object) error {
-	logrus.WithFields(logrus.Fields{
-		"key":           *obj.Key,
+	if !d.Pool.acquire(d.stop) {
+		return errStopped
+	}
+	defer d.Pool.release()
+
+	d.log().WithFields(logrus.Fields{
+		"object":        *obj.Key,
 		"size":          *obj.Size,
 		"from_time_ago": time.Since(*obj.LastModified),
-		"entity":        d.String(),
 	}).Info("Downloading access logs from object")
 
+	// The object span covers the object's whole trip through the
+	// pipeline, and is ended once it has been published.
+	ctx, objSpan := tracing.Tracer().Start(context.Background(), "object", trace.WithAttributes(
+		attribute.String("object.key", *obj.Key),
+		attribute.Int64("object.size", *obj.Size),
+		attribute.String("entity", d.String()),
+	))
+	_, downloadSpan := tracing.Tracer().Start(ctx, "download")
+	var downloadedObj state.DownloadedObject
+	var err error
+	if d.DownloadToFile {
+		downloadedObj, err = downloadObject(d.Sess, d.Bucket(), *obj.Key, d.KMSKeyARN)
+	} else {
+		downloadedObj, err = streamObject(d.Sess, d.Bucket(), *obj.Key, d.KMSKeyARN)
+	}
+	if err != nil {
+		downloadSpan.RecordError(err)
+		downloadSpan.SetStatus(codes.Error, err.Error())
+		downloadSpan.End()
+		objSpan.End()
+		return err
+	}
+	downloadSpan.End()
+	metrics.ObserveDownload(d.String(), *obj.LastModified)
+	downloadedObj.Context = ctx
+	downloadedObj.Fields = d.Fields
+	downloadedObj.Size = *obj.Size
+	downloadedObj.Offset, downloadedObj.Resumed = d.resumeOffset(*obj.Key)
+	if d.DownloadToFile {
+		d.log().WithFields(logrus.Fields{
+			"object": *obj.Key,
+			"file":   downloadedObj.Filename,
+		}).Info("Successfully downloaded object")
+	} else {
+		d.log().WithField("object", *obj.Key).Info("Streaming object")
+	}
+
+	select {
+	case d.DownloadedObjects <- downloadedObj:
+	case <-d.stop:
+		downloadedObj.Release()
+		objSpan.End()
+		return errStopped
+	}
+	d.takeResume(*obj.Key)
+
+	return nil
+}
+
+// The size of the byte ranges objects are downloaded in, and how many of an
+// object's are downloaded at once, the SDK's defaults until SetDownloadParts.
+var (
+	partSize        int64 = s3manager.DefaultDownloadPartSize
+	partConcurrency       = s3manager.DefaultDownloadConcurrency
+)
+
+// SetDownloadParts sets the size, in MB, of the byte ranges objects are
+// downloaded in, and how many of an object's ranges are downloaded at once,
+// for --download-part-size and --download-part-concurrency. Each range is
+// its own GetObject call, so counts towards --s3-max-concurrency and
+// --s3-requests-per-second.
+func SetDownloadParts(sizeMB, concurrency int) error {
+	if sizeMB <= 0 {
+		return fmt.Errorf("--download-part-size must be positive, got %d", sizeMB)
+	}
+	if concurrency <= 0 {
+		return fmt.Errorf("--download-part-concurrency must be positive, got %d", concurrency)
+	}
+	partSize = int64(sizeMB) * 1024 * 1024
+	partConcurrency = concurrency
+	return nil
+}
+
+// DownloadObject downloads the object to a temporary file, which should be
+// removed by the caller once it has been processed.
+func DownloadObject(sess *session.Session, bucket, key string) (state.DownloadedObject, error) {
+	return downloadObject(sess, bucket, key, "")
+}
+
+// downloadObject downloads the object, explaining why it may have been denied
+// access, going by the KMS key the bucket's objects are expected to be
+// encrypted with if there is one.
+func downloadObject(sess *session.Session, bucket, key, kmsKeyARN string) (state.DownloadedObject, error) {
 	f, err := ioutil.TempFile("", "hc-entity-ingest")
 	if err != nil {
-		return fmt.Errorf("Error creating tmp file: %s", err)
+		return state.DownloadedObject{}, fmt.Errorf("Error creating tmp file: %s", err)
 	}
+	defer f.Close()
 
-	downloader := s3manager.NewDownloader(d.Sess)
+	// The ranges are written to the file where they belong as they arrive,
+	// out of order, since it's parsed (and resumed) from the file once it's
+	// all there.
+	downloader := s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+		d.PartSize = partSize
+		d.Concurrency = partConcurrency
+	})
 
 	nBytes, err := downloader.Download(f, &s3.GetObjectInput{
-		Bucket: aws.String(d.Bucket()),
-		Key:    aws.String(*obj.Key),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		return fmt.Errorf("Error downloading object file: %s", err)
+		os.Remove(f.Name())
+		if missing, ok := notFound(key, err).(*MissingObjectError); ok {
+			return state.DownloadedObject{}, missing
+		}
+		err = explainAccessDenied(s3.New(sess), bucket, key, kmsKeyARN, err)
+		return state.DownloadedObject{}, fmt.Errorf("Error downloading object file: %s", err)
 	}
 	logrus.WithFields(logrus.Fields{
 		"bytes":  nBytes,
-		"file":   f.Name(),
-		"entity": d.String(),
-	}).Info("Successfully downloaded object")
+		"bucket": bucket,
+		"object": key,
+	}).Debug("Downloaded object")
 
-	d.DownloadedObjects <- state.DownloadedObject{
+	return state.DownloadedObject{
 		Filename: f.Name(),
-		Object:   *obj.Key,
-	}
-
-	return nil
+		Object:   key,
+	}, nil
 }
 
 func (d *Downloader) downloadObjects() {
-	for obj := range d.ObjectsToDownload {
-		if err := d.downloadObject(obj); err != nil {
-			logrus.Error(err)
+	for {
+		select {
+		case obj := <-d.ObjectsToDownload:
+			d.downloadWithRetries(obj)
+		case <-d.stop:
+			return
 		}
 		// TODO: Should we sleep in between downloads here? Watching
 		// many load balancers concurrently could potentially result in
@@ -204,74 +627,591 @@ func (d *Downloader) downloadObjects() {
 	}
 }
 
-func (d *Downloader) accessLogBucketPageCallback(processedObjects map[string]time.Time, bucketResp *s3.ListObjectsOutput, lastPage bool) bool {
-	logrus.WithFields(logrus.Fields{
+// downloadWithRetries downloads the object, retrying with backoff if it
+// fails, and records it as a dead letter if it still fails after that. Objects
+// which aren't there are checked for again later instead, with
+// MissingRechecks.
+func (d *Downloader) downloadWithRetries(obj *s3.Object) {
+	d.downloadChecked(obj, 0)
+}
+
+// downloadChecked downloads the object, which has been found to be missing
+// checks times already, as downloadWithRetries does.
+func (d *Downloader) downloadChecked(obj *s3.Object, checks int) {
+	var missing *MissingObjectError
+	attempts, err := d.Retry.Do(d.stop, func() error {
+		err := d.downloadObject(obj)
+		if d.MissingRechecks > 0 && errors.As(err, &missing) {
+			// checked for again later, rather than retried now
+			return nil
+		}
+		if err != nil && err != errStopped {
+			d.log().WithFields(logrus.Fields{
+				"object": *obj.Key,
+				"error":  err,
+			}).Warn("Error downloading object")
+		}
+		return err
+	})
+	if missing != nil {
+		d.recheck(obj, missing, checks+1)
+		return
+	}
+	if checks > 0 {
+		d.clearMissing(*obj.Key)
+	}
+	if err == nil {
+		return
+	}
+	if d.stopped() {
+		d.checkpoint(*obj.Key)
+		return
+	}
+
+	metrics.ObjectsFailed.WithLabelValues(d.String()).Inc()
+	d.log().WithFields(logrus.Fields{
+		"object":   *obj.Key,
+		"attempts": attempts,
+		"error":    err,
+	}).Error("Giving up on downloading object")
+	if deadLetterer, ok := d.Stater.(state.DeadLetterer); ok {
+		if err := deadLetterer.SetDeadLetter(*obj.Key, state.DeadLetter{
+			Error:    err.Error(),
+			Attempts: attempts,
+			Time:     time.Now(),
+		}); err != nil {
+			d.log().WithField("error", err).Error("Could not record the dead letter")
+		}
+	}
+}
+
+// queueObject sends the object along to be downloaded, unless it has already
+// been processed, was delivered before tailing started, or falls outside of
+// the backfill interval. Objects held back because backfill is paused are left
+// for a later listing.
+func (d *Downloader) queueObject(processedObjects map[string]time.Time, obj *s3.Object) {
+	if _, ok := processedObjects[*obj.Key]; ok {
+		d.log().WithField("object", *obj.Key).Debug("Already processed, skipping")
+		return
+	}
+
+	if d.beforeTail(obj) {
+		d.log().WithField("object", *obj.Key).Debug("Delivered before tailing started, skipping")
+		return
+	}
+
+	if logTime := objectTime(obj); time.Since(logTime) < d.BackfillInterval {
+		if d.Schedule.isBackfill(logTime, time.Now()) {
+			d.log().WithField("object", *obj.Key).Debug("Backfill paused, holding back")
+			d.heldBack = true
+			return
+		}
+		d.sendObject(obj)
+	}
+}
+
+// beforeTail returns whether the object was delivered before the downloader
+// started, with Tail.
+func (d *Downloader) beforeTail(obj *s3.Object) bool {
+	return d.Tail && obj.LastModified != nil && obj.LastModified.Before(d.tailSince)
+}
+
+// backfillObject sends the object along to be downloaded unless it has
+// already been processed, no matter how old it is.
+func (d *Downloader) backfillObject(processedObjects map[string]time.Time, obj *s3.Object) {
+	if _, ok := processedObjects[*obj.Key]; ok {
+		d.log().WithField("object", *obj.Key).Debug("Already processed, skipping")
+		return
+	}
+
+	d.sendObject(obj)
+}
+
+func (d *Downloader) sendObject(obj *s3.Object) {
+	d.progress.next(obj)
+	// Listers leave processing, and so setting the object as processed,
+	// to the workers.
+	if d.WorkQueue != nil {
+		if err := d.WorkQueue.Enqueue(d.Bucket(), obj); err != nil {
+			d.log().WithFields(logrus.Fields{
+				"object": *obj.Key,
+				"error":  err,
+			}).Error("Error adding object to the work queue")
+			return
+		}
+		metrics.ObjectsDiscovered.WithLabelValues(d.String()).Inc()
+		d.progress.objectProcessed()
+		return
+	}
+
+	if lines, ok := d.resumeOffset(*obj.Key); ok {
+		// Unfinished objects are already set as processed, so
+		// record progress instead, which keeps other instances from
+		// resuming them too.
+		if err := d.SetOffset(*obj.Key, lines); err != nil {
+			d.log().WithField("object", *obj.Key).Debug("Error setting offset of object being resumed")
+			return
+		}
+	} else if err := d.SetProcessed(*obj.Key); err != nil {
+		d.log().WithField("object", *obj.Key).Debug("Error setting state of object as processed")
+		return
+	} else if d.duplicate(obj) {
+		d.progress.objectProcessed()
+		return
+	}
+	metrics.ObjectsDiscovered.WithLabelValues(d.String()).Inc()
+	d.progress.objectProcessed()
+	// we want to set the object as processed as
+	// soon as it's ready to downloaded
+	// to avoid duplicates in downloading
+	select {
+	case d.ObjectsToDownload <- obj:
+	case <-d.stop:
+		d.checkpoint(*obj.Key)
+	}
+}
+
+// duplicate returns whether the object is a copy of one already processed
+// under another key, with Dedupe. Copies are set as processed all the same,
+// so they aren't found again by the next listing.
+func (d *Downloader) duplicate(obj *s3.Object) bool {
+	deduper, ok := d.Stater.(state.Deduper)
+	etag := strings.Trim(aws.StringValue(obj.ETag), `"`)
+	if !d.Dedupe || !ok || etag == "" {
+		return false
+	}
+	object := d.Bucket() + "/" + *obj.Key
+	first, err := deduper.SetContentProcessed(etag, object)
+	if err != nil {
+		// Better published twice than not at all.
+		d.log().WithFields(logrus.Fields{
+			"object": *obj.Key,
+			"error":  err,
+		}).Warn("Could not record the object's contents, publishing it regardless")
+		return false
+	}
+	if first == object {
+		return false
+	}
+	metrics.ObjectsDuplicated.WithLabelValues(d.String()).Inc()
+	d.log().WithFields(logrus.Fields{
+		"object":   *obj.Key,
+		"original": first,
+	}).Info("Object has the same contents as one already processed, skipping")
+	return true
+}
+
+// checkpoint records an object the downloader was stopped before it could be
+// published as unfinished, since it's already set as processed, so that it's
+// resumed next time rather than lost: from the start, or from wherever it got
+// to if it was being resumed already.
+func (d *Downloader) checkpoint(key string) {
+	if err := d.SetOffset(key, d.takeResume(key)); err != nil {
+		d.log().WithFields(logrus.Fields{
+			"object": key,
+			"error":  err,
+		}).Error("Could not record object as unfinished")
+	}
+}
+
+func (d *Downloader) accessLogBucketPageCallback(processedObjects map[string]time.Time, bucketResp *s3.ListObjectsV2Output, lastPage bool) bool {
+	d.log().WithFields(logrus.Fields{
 		"objects":   len(bucketResp.Contents),
 		"truncated": *bucketResp.IsTruncated,
 	}).Debug("Start S3 bucket page")
 	for _, obj := range bucketResp.Contents {
-		_, ok := processedObjects[*obj.Key]
-
-		if ok {
-			logrus.WithField("object", *obj.Key).Debug("Already processed, skipping")
+		if d.deferBackfill(processedObjects, obj) {
 			continue
 		}
-
-		if time.Since(*obj.LastModified) < d.BackfillInterval {
-			if err := d.SetProcessed(*obj.Key); err != nil {
-				logrus.Debug("Error setting state of object as processed: ", *obj.Key)
-				continue
-			}
-			// we want to set the object as processed as
-			// soon as it's ready to downloaded
-			// to avoid duplicates in downloading
-			d.ObjectsToDownload <- obj
-		}
+		d.queueObject(processedObjects, obj)
+		// Queueing blocks while earlier objects are processed,
+		// which can take a while for big backfills, but is
+		// still progress.
+		d.setPolled(false)
 	}
 
-	logrus.WithField("lastPage", lastPage).Debug("End S3 bucket page")
+	d.log().WithField("lastPage", lastPage).Debug("End S3 bucket page")
 
 	return true
 }
-func (d *Downloader) pollObjects() {
+
+// cursorAfter returns the last key in the listing which is safe to start the
+// next listing after: keys are ordered by their timestamp within a prefix, so
+// once an object is older than cursorSettle, nothing sorting before it should
+// still be on its way.
+func cursorAfter(cursor string, objs []*s3.Object, now time.Time) string {
+	for _, obj := range objs {
+		if now.Sub(*obj.LastModified) > cursorSettle && *obj.Key > cursor {
+			cursor = *obj.Key
+		}
+	}
+	return cursor
+}
+
+// pollObjects lists the bucket for new objects until the downloader is
+// stopped, or backfill is complete if that's all there is to do. Listing
+// errors are returned, for the poller to be restarted.
+func (d *Downloader) pollObjects() error {
 	// get new logs every 5 minutes
-	ticker := time.NewTicker(5 * time.Minute).C
+	t := time.NewTicker(5 * time.Minute)
+	defer t.Stop()
+	ticker := t.C
 
 	s3svc := s3.New(d.Sess, nil)
 
+	if (d.GapScan == GapScanReport || d.GapScan == GapScanRepair) && !d.gapsScanned {
+		if err := d.scanGaps(); err != nil {
+			d.log().WithField("error", err).Error("Could not scan the backfill window for gaps")
+		}
+		d.gapsScanned = true
+	}
+
 	// Start the loop to continually ingest access logs.
 	for {
-		// For now, get objects for just today.
-		totalPrefix := d.ObjectPrefix(time.Now().UTC())
+		if !d.waitForShard() {
+			return nil
+		}
 
-		logrus.WithFields(logrus.Fields{
-			"prefix": totalPrefix,
-			"entity": d.String(),
-		}).Info("Getting recent objects")
+		// For now, get objects for just today, or with ListByHour
+		// the hours of the backfill interval.
+		now := time.Now()
+		hours, byHour := d.hourListings(now)
+		if !byHour {
+			hours = []hourListing{{prefix: d.ObjectPrefix(now.UTC())}}
+		}
+
+		if d.Milestones != nil && !d.backfillStarted {
+			d.Milestones.BackfillStarted(d.String())
+			d.backfillStarted = true
+		}
 
 		processedObjects, err := d.ProcessedObjects()
 		if err != nil {
-			logrus.Error(err)
+			d.log().Error(err)
+		}
+
+		// Pick up where the last listing of the prefix left off, if
+		// the stater keeps track of that. Hours are listed whole.
+		cursorer, _ := d.Stater.(state.Cursorer)
+		if _, ok := d.ObjectDownloader.(*PrefixDownloader); ok || byHour {
+			cursorer = nil
+		}
+		// The offsets are read once for every prefix, since reading
+		// them may scan the state. Listers leave publishing objects
+		// to the workers.
+		var offsets map[string]state.Offset
+		if d.WorkQueue == nil {
+			if offsets, err = d.Offsets(); err != nil {
+				d.log().Error(err)
+			}
+		}
+		var listings []*prefixListing
+		for _, h := range hours {
+			resuming := d.queueResumes(processedObjects, offsets, h.prefix, now)
+			// Unfinished objects may well be before the cursor, or
+			// in an hour which has settled.
+			if d.settledHours[h.hour] && !resuming {
+				continue
+			}
+			l := &prefixListing{hourListing: h, input: &s3.ListObjectsV2Input{
+				Bucket: aws.String(d.Bucket()),
+				Prefix: aws.String(h.prefix),
+			}}
+			if cursorer != nil && !resuming {
+				if l.cursor, err = cursorer.Cursor(h.prefix); err != nil {
+					d.log().Error(err)
+				}
+			}
+			if l.cursor != "" {
+				l.input.StartAfter = aws.String(l.cursor)
+			}
+			listings = append(listings, l)
+		}
+		if len(listings) > 0 {
+			d.log().WithFields(logrus.Fields{
+				"prefix":   listings[0].prefix,
+				"prefixes": len(listings),
+			}).Info("Getting recent objects")
+		}
+
+		if d.ProgressInterval > 0 && !d.backfillFinished && !d.Tail {
+			inputs := make([]*s3.ListObjectsV2Input, len(listings))
+			for i, l := range listings {
+				inputs[i] = l.input
+			}
+			d.countBackfill(s3svc, processedObjects, inputs...)
+		}
+
+		for _, l := range listings {
+			if err := d.listPrefix(s3svc, cursorer, l, processedObjects); err != nil {
+				d.backfill = nil
+				return err
+			}
+		}
+		d.queueBackfill(processedObjects)
+		if !d.backfillFinished {
+			d.progress.finish()
+			if d.Milestones != nil {
+				d.Milestones.BackfillFinished(d.String())
+			}
+			d.backfillFinished = true
+		}
+
+		if d.BackfillOnly {
+			d.setPolled(true)
+			d.log().Info("Backfill complete, waiting on S3 event notifications for new logs")
+			return nil
+		}
+		d.setPolled(false)
+		d.log().Info("Bucket polling paused until the next set of logs are available")
+		select {
+		case <-ticker:
+		case <-d.stop:
+			d.setPolled(true)
+			return nil
+		}
+	}
+}
+
+// prefixListing is a listing of a prefix, today's or an hour's, picking up
+// after the cursor if there is one.
+type prefixListing struct {
+	hourListing
+	input  *s3.ListObjectsV2Input
+	cursor string
+}
+
+// listPrefix lists the objects under the prefix, queueing them to be
+// downloaded, and moves its cursor past those which have settled, or with
+// ListByHour records whether the hour has.
+func (d *Downloader) listPrefix(s3svc *s3.S3, cursorer state.Cursorer, l *prefixListing, processedObjects map[string]time.Time) error {
+	_, listSpan := tracing.Tracer().Start(context.Background(), "list", trace.WithAttributes(
+		attribute.String("bucket", d.Bucket()),
+		attribute.String("prefix", l.prefix),
+		attribute.String("entity", d.String()),
+	))
+	defer listSpan.End()
+
+	started := time.Now()
+	pages := 0
+	newCursor := l.cursor
+	d.heldBack = false
+	cb := func(bucketResp *s3.ListObjectsV2Output, lastPage bool) bool {
+		pages++
+		newCursor = cursorAfter(newCursor, bucketResp.Contents, time.Now())
+		return d.accessLogBucketPageCallback(processedObjects, bucketResp, lastPage)
+	}
+	if err := s3svc.ListObjectsV2Pages(l.input, cb); err != nil {
+		return fmt.Errorf("Error listing/paging bucket objects: %s", err)
+	}
+	listSpan.SetAttributes(attribute.Int("pages", pages), attribute.String("start_after", l.cursor))
+
+	if cursorer != nil && newCursor != l.cursor && !d.heldBack {
+		if err := cursorer.SetCursor(l.prefix, newCursor); err != nil {
+			d.log().Error(err)
 		}
+	}
+	// Hours with objects held back are listed again for them.
+	if d.ListByHour && !l.hour.IsZero() && hourSettled(l.hour, started) && !d.heldBack {
+		if d.settledHours == nil {
+			d.settledHours = make(map[time.Time]bool)
+		}
+		d.settledHours[l.hour] = true
+	}
+	return nil
+}
 
-		cb := func(bucketResp *s3.ListObjectsOutput, lastPage bool) bool {
-			return d.accessLogBucketPageCallback(processedObjects, bucketResp, lastPage)
+// waitForShard waits until this instance holds the downloader's lease, if
+// it's sharded, returning false if the downloader is stopped first. Waiting
+// isn't being wedged, so it doesn't fail the poller's liveness check.
+func (d *Downloader) waitForShard() bool {
+	if d.Shard == nil || d.Shard.Holds(d.shardName()) {
+		return true
+	}
+	d.log().Info("Another instance holds the lease, waiting for it")
+	t := time.NewTicker(shardPoll)
+	defer t.Stop()
+	for {
+		d.setPolled(true)
+		select {
+		case <-t.C:
+		case <-d.stop:
+			return false
 		}
+		if d.Shard.Holds(d.shardName()) {
+			d.log().Info("Took the lease, polling the bucket")
+			d.setPolled(false)
+			return true
+		}
+	}
+}
+
+func (d *Downloader) shardName() string {
+	return d.Bucket() + "/" + d.String()
+}
 
-		if err := s3svc.ListObjectsPages(&s3.ListObjectsInput{
-			Bucket: aws.String(d.Bucket()),
-			Prefix: aws.String(totalPrefix),
-		}, cb); err != nil {
-			fmt.Fprintln(os.Stderr, "Error listing/paging bucket objects: ", err)
-			os.Exit(1)
+// queueResumes finds the objects under the prefix which were left unfinished,
+// going by the offsets, removing them from the processed objects so that
+// they're queued again to be resumed where they got to. It returns whether
+// there are any.
+func (d *Downloader) queueResumes(processedObjects map[string]time.Time, offsets map[string]state.Offset, prefix string, now time.Time) bool {
+	d.resumeLock.Lock()
+	defer d.resumeLock.Unlock()
+	resuming := false
+	for key, offset := range offsets {
+		if !strings.HasPrefix(key, prefix) || now.Sub(offset.Time) < offsetStale {
+			continue
+		}
+		if !d.claimResume(key, offset) {
+			continue
+		}
+		if d.resuming == nil {
+			d.resuming = make(map[string]int64)
 		}
-		logrus.WithField("entity", d.String()).Info("Bucket polling paused until the next set of logs are available")
-		<-ticker
+		d.resuming[key] = offset.Lines
+		delete(processedObjects, key)
+		resuming = true
+		d.log().WithFields(logrus.Fields{
+			"object": key,
+			"lines":  offset.Lines,
+		}).Info("Object was left unfinished, resuming it")
+	}
+	return resuming
+}
+
+// claimResume returns whether the unfinished object is to be resumed: with
+// ExactlyOnce, unless every event of it was accepted after all, or another
+// instance claimed it first.
+func (d *Downloader) claimResume(key string, offset state.Offset) bool {
+	confirmer, ok := d.Stater.(state.Confirmer)
+	if !d.ExactlyOnce || !ok {
+		return true
+	}
+	published, err := confirmer.Published(key)
+	if err != nil {
+		d.log().WithFields(logrus.Fields{
+			"object": key,
+			"error":  err,
+		}).Error("Could not tell whether the unfinished object was published")
+		return false
+	}
+	if published {
+		d.log().WithField("object", key).Info("Object was left unfinished, but was published in full")
+		if err := d.ClearOffset(key); err != nil {
+			d.log().WithField("error", err).Error("Could not clear progress through object")
+		}
+		return false
+	}
+	if err := confirmer.ClaimOffset(key, offset); err != nil {
+		d.log().WithFields(logrus.Fields{
+			"object": key,
+			"error":  err,
+		}).Debug("Object is being resumed by another instance")
+		return false
+	}
+	return true
+}
+
+func (d *Downloader) resumeOffset(key string) (int64, bool) {
+	d.resumeLock.Lock()
+	defer d.resumeLock.Unlock()
+	lines, ok := d.resuming[key]
+	return lines, ok
+}
+
+// takeResume returns how far into the object to resume from, if it's being
+// resumed.
+func (d *Downloader) takeResume(key string) int64 {
+	d.resumeLock.Lock()
+	defer d.resumeLock.Unlock()
+	lines := d.resuming[key]
+	delete(d.resuming, key)
+	return lines
+}
+
+func (d *Downloader) setPolled(done bool) {
+	d.pollLock.Lock()
+	defer d.pollLock.Unlock()
+	d.polled = time.Now()
+	d.pollDone = done
+}
+
+// checkPolling is the liveness check for the poller.
+func (d *Downloader) checkPolling() error {
+	d.pollLock.Lock()
+	defer d.pollLock.Unlock()
+	if !d.pollDone && time.Since(d.polled) > pollTimeout {
+		return fmt.Errorf("no progress listing the bucket since %s", d.polled.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Stop stops polling the bucket and downloading objects for good, e.g. once
+// the load balancer has been deleted. Objects being queued or downloaded at
+// the time are recorded as unfinished, to be resumed from the start.
+func (d *Downloader) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		if d.Shard != nil {
+			d.Shard.Remove(d.shardName())
+		}
+	})
+}
+
+func (d *Downloader) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
 	}
 }
 
 func (d *Downloader) Download(downloadedObjects chan state.DownloadedObject) {
 	d.DownloadedObjects = downloadedObjects
-	go d.pollObjects()
-	go d.downloadObjects()
+	d.tailSince = time.Now()
+	if d.Context != nil {
+		go func() {
+			select {
+			case <-d.Context.Done():
+				d.Stop()
+			case <-d.stop:
+			}
+		}()
+	}
+	if d.TimeRange != nil {
+		d.TimeRange.wg.Add(1)
+		go d.downloadTimeRange()
+		return
+	}
+	if !d.NoPolling {
+		if d.Shard != nil {
+			d.Shard.Add(d.shardName())
+		}
+		d.setPolled(false)
+		name := "poller " + d.Bucket() + " " + d.String()
+		health.Live(name, d.checkPolling)
+		// there's no backfill to report on when tailing
+		if d.ProgressInterval > 0 && !d.Tail {
+			go d.reportProgress()
+		}
+		go d.supervise(name, func() error {
+			err := d.pollObjects()
+			if err != nil {
+				// Backing off from a failing listing isn't being
+				// wedged, and the status shows the error.
+				d.setPolled(false)
+			}
+			return err
+		})
+		// Sharded instances would each check for every missing
+		// object, whichever of them holds its lease.
+		if d.Shard == nil {
+			d.resumeRechecks()
+		}
+	}
+	go d.supervise("downloader "+d.Bucket()+" "+d.String(), func() error {
+		d.downloadObjects()
+		return nil
+	})
 }
@@ -1,9 +1,24 @@
 package logbucket
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/retry"
+	"github.com/honeycombio/honeyaws/state"
 )
 
 func TestObjectPrefixes(t *testing.T) {
@@ -27,6 +42,24 @@ func TestObjectPrefixes(t *testing.T) {
 				LBName:     "service1",
 			},
 		}, "noslash/AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_app.service1"},
+		{&ALBConnectionLogDownloader{
+			ELBDownloader: &ELBDownloader{
+				AccountID:  "12345",
+				Region:     "us-east-1",
+				BucketName: "mylogs",
+				Prefix:     "",
+				LBName:     "service1",
+			},
+		}, "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/conn_log.12345_elasticloadbalancing_us-east-1_app.service1"},
+		{&NLBDownloader{
+			ELBDownloader: &ELBDownloader{
+				AccountID:  "12345",
+				Region:     "us-east-1",
+				BucketName: "mylogs",
+				Prefix:     "",
+				LBName:     "service1",
+			},
+		}, "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_net.service1"},
 		{&CloudFrontDownloader{
 			BucketName:     "mylogs",
 			Prefix:         "trailingslash/",
@@ -39,6 +72,31 @@ func TestObjectPrefixes(t *testing.T) {
 			Prefix:     "",
 			TrailID:    "MADEUP0",
 		}, "AWSLogs/12345/CloudTrail/us-east-1/2018/08/20/12345_CloudTrail_us-east-1"},
+		{&FlowLogDownloader{
+			AccountID:  "12345",
+			Region:     "us-east-1",
+			BucketName: "mylogs",
+			Prefix:     "flows",
+			FlowLogID:  "fl-1234abcd",
+		}, "flows/AWSLogs/12345/vpcflowlogs/us-east-1/2018/08/20/12345_vpcflowlogs_us-east-1_fl-1234abcd"},
+		{&GlobalAcceleratorDownloader{
+			AccountID:     "12345",
+			BucketName:    "mylogs",
+			Prefix:        "ga",
+			AcceleratorID: "1234abcd-abcd-1234-abcd-1234abcdefgh",
+		}, "ga/AWSLogs/12345/globalaccelerator/us-west-2/2018/08/20/12345_globalaccelerator_1234abcd-abcd-1234-abcd-1234abcdefgh"},
+		{&WAFDownloader{
+			AccountID:  "12345",
+			Region:     "cloudfront",
+			BucketName: "aws-waf-logs-mylogs",
+			Prefix:     "",
+			WebACLName: "my-acl",
+		}, "AWSLogs/12345/WAFLogs/cloudfront/my-acl/2018/08/20/"},
+		{&FirehoseDownloader{
+			BucketName: "mylogs",
+			Prefix:     "waf/",
+			Name:       "my-acl",
+		}, "waf/2018/08/20/"},
 	}
 
 	for _, testCase := range testCases {
@@ -49,3 +107,284 @@ func TestObjectPrefixes(t *testing.T) {
 		log.Print(prefix)
 	}
 }
+
+func TestCursorAfter(t *testing.T) {
+	now := time.Date(2018, 8, 20, 12, 0, 0, 0, time.UTC)
+	objs := []*s3.Object{
+		{Key: aws.String("a_20180820T1120Z"), LastModified: aws.Time(now.Add(-40 * time.Minute))},
+		{Key: aws.String("a_20180820T1135Z"), LastModified: aws.Time(now.Add(-25 * time.Minute))},
+		{Key: aws.String("a_20180820T1155Z"), LastModified: aws.Time(now.Add(-5 * time.Minute))},
+	}
+
+	// Objects which might still have stragglers sorting before them
+	// shouldn't move the cursor
+	if cursor := cursorAfter("", objs, now); cursor != "a_20180820T1135Z" {
+		t.Errorf("expected cursor to stop at the last settled object, got %q", cursor)
+	}
+
+	// The cursor only moves forward
+	if cursor := cursorAfter("a_20180820T1140Z", objs, now); cursor != "a_20180820T1140Z" {
+		t.Errorf("expected cursor not to move backward, got %q", cursor)
+	}
+}
+
+func TestDownloaderStop(t *testing.T) {
+	d := NewDownloader(nil, state.NewMemoryStater(1), &CloudFrontDownloader{DistributionID: "E123"}, 1)
+	if d.stopped() {
+		t.Error("expected downloader not to be stopped yet")
+	}
+
+	d.Stop()
+	d.Stop()
+	if !d.stopped() {
+		t.Error("expected downloader to be stopped")
+	}
+
+	// Nothing is downloading objects any more, so sending one along
+	// mustn't block
+	done := make(chan struct{})
+	go func() {
+		d.sendObject(&s3.Object{Key: aws.String("E123.2018-08-20-12.abcd.gz")})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected sending an object to a stopped downloader not to block")
+	}
+
+	// it's set as processed by then, so is left to be resumed instead
+	offsets, err := d.Offsets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := offsets["E123.2018-08-20-12.abcd.gz"]; !ok || offset.Lines != 0 {
+		t.Errorf("expected the object to be left unfinished, got %v", offsets)
+	}
+}
+
+func TestDownloaderContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewDownloader(nil, state.NewMemoryStater(1), &CloudFrontDownloader{DistributionID: "E123"}, 1)
+	d.NoPolling = true
+	d.Context = ctx
+	d.Download(make(chan state.DownloadedObject))
+
+	cancel()
+	for i := 0; i < 100 && !d.stopped(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !d.stopped() {
+		t.Error("expected the downloader to stop once its context was done")
+	}
+}
+
+func TestDownloaderResumes(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	d := NewDownloader(nil, stater, &CloudFrontDownloader{DistributionID: "E123"}, 1)
+
+	stater.SetProcessed("E123.2018-08-20-11.abcd.gz")
+	stater.SetOffset("E123.2018-08-20-11.abcd.gz", 5000)
+	stater.SetProcessed("E456.2018-08-20-11.abcd.gz")
+	stater.SetOffset("E456.2018-08-20-11.abcd.gz", 1000)
+	processed, _ := stater.ProcessedObjects()
+	offsets, _ := stater.Offsets()
+
+	// progress was just recorded, so the objects may still be publishing
+	if d.queueResumes(processed, offsets, "E123.", time.Now()) {
+		t.Error("expected objects with recent progress not to be resumed")
+	}
+
+	if !d.queueResumes(processed, offsets, "E123.", time.Now().Add(offsetStale+time.Minute)) {
+		t.Fatal("expected the unfinished object to be resumed")
+	}
+	if _, ok := processed["E123.2018-08-20-11.abcd.gz"]; ok {
+		t.Error("expected the unfinished object to be queued again")
+	}
+	if _, ok := processed["E456.2018-08-20-11.abcd.gz"]; !ok {
+		t.Error("expected objects under other prefixes to be left alone")
+	}
+
+	if lines := d.takeResume("E123.2018-08-20-11.abcd.gz"); lines != 5000 {
+		t.Errorf("expected to resume after 5000 lines, got %d", lines)
+	}
+	if lines := d.takeResume("E123.2018-08-20-11.abcd.gz"); lines != 0 {
+		t.Errorf("expected the object to only be resumed once, got %d", lines)
+	}
+}
+
+func TestDownloaderResumesExactlyOnce(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	d := NewDownloader(nil, stater, &CloudFrontDownloader{DistributionID: "E123"}, 1)
+	d.ExactlyOnce = true
+
+	stater.SetOffset("E123.2018-08-20-11.abcd.gz", 5000)
+	stater.SetOffset("E123.2018-08-20-11.efgh.gz", 1000)
+	stater.SetPublished("E123.2018-08-20-11.efgh.gz")
+	offsets, _ := stater.Offsets()
+
+	if !d.queueResumes(map[string]time.Time{}, offsets, "E123.", time.Now().Add(offsetStale+time.Minute)) {
+		t.Fatal("expected the unfinished object to be resumed")
+	}
+	if lines := d.takeResume("E123.2018-08-20-11.efgh.gz"); lines != 0 {
+		t.Errorf("expected the object published in full not to be resumed, got %d", lines)
+	}
+	if offsets, _ := stater.Offsets(); offsets["E123.2018-08-20-11.efgh.gz"].Lines != 0 {
+		t.Errorf("expected the published object's offset to be cleared, got %v", offsets)
+	}
+
+	// another instance which read the offset before it was claimed
+	other := NewDownloader(nil, stater, &CloudFrontDownloader{DistributionID: "E123"}, 1)
+	other.ExactlyOnce = true
+	if other.claimResume("E123.2018-08-20-11.abcd.gz", offsets["E123.2018-08-20-11.abcd.gz"]) {
+		t.Error("expected the object only to be resumed by the instance which claimed it")
+	}
+	if lines := d.takeResume("E123.2018-08-20-11.abcd.gz"); lines != 5000 {
+		t.Errorf("expected to resume after 5000 lines, got %d", lines)
+	}
+}
+
+func TestDownloaderDedupe(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	newDownloader := func(bucket string) *Downloader {
+		d := NewDownloader(nil, stater, &CloudFrontDownloader{BucketName: bucket, DistributionID: "E123"}, 1)
+		d.ObjectsToDownload = make(chan *s3.Object, 1)
+		d.Dedupe = true
+		return d
+	}
+	logs, replica := newDownloader("logs"), newDownloader("logs-replica")
+	object := func(key string) *s3.Object {
+		return &s3.Object{
+			Key:          aws.String(key),
+			ETag:         aws.String(`"9b2cf535f27731c974343645a3985328"`),
+			LastModified: aws.Time(time.Now()),
+		}
+	}
+
+	logs.sendObject(object("E123.2018-08-20-12.abcd.gz"))
+	if len(logs.ObjectsToDownload) != 1 {
+		t.Fatal("expected the original to be downloaded")
+	}
+	replica.sendObject(object("E123.2018-08-20-12.abcd.gz"))
+	if len(replica.ObjectsToDownload) != 0 {
+		t.Error("expected the copy in the replica not to be downloaded")
+	}
+	if processed, _ := stater.ProcessedObjects(); len(processed) != 1 {
+		t.Errorf("expected the copy to be set as processed, got %v", processed)
+	}
+
+	replica.Dedupe = false
+	replica.sendObject(object("E123.2018-08-20-13.abcd.gz"))
+	if len(replica.ObjectsToDownload) != 1 {
+		t.Error("expected copies to be downloaded without Dedupe")
+	}
+}
+
+func TestDownloaderTail(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	d := NewDownloader(nil, stater, &CloudFrontDownloader{BucketName: "logs", DistributionID: "E123"}, 1)
+	d.ObjectsToDownload = make(chan *s3.Object, 2)
+	d.Tail = true
+	d.tailSince = time.Now()
+	object := func(key string, delivered time.Time) *s3.Object {
+		return &s3.Object{Key: aws.String(key), LastModified: aws.Time(delivered)}
+	}
+
+	now := time.Now().UTC()
+	earlier := "E123." + now.Add(-10*time.Minute).Format("2006-01-02-15") + ".abcd.gz"
+	later := "E123." + now.Format("2006-01-02-15") + ".efgh.gz"
+	processed := map[string]time.Time{}
+	d.queueObject(processed, object(earlier, d.tailSince.Add(-time.Minute)))
+	d.queueObject(processed, object(later, d.tailSince.Add(time.Minute)))
+	if len(d.ObjectsToDownload) != 1 || *(<-d.ObjectsToDownload).Key != later {
+		t.Error("expected only the object delivered since tailing started to be downloaded")
+	}
+	if processed, _ := stater.ProcessedObjects(); len(processed) != 1 {
+		t.Errorf("expected the earlier object to be left to be backfilled, got %v", processed)
+	}
+}
+
+func TestDownloadObjectParts(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 3*1024*1024/16+100)
+	var ranges int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranges, 1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	defer func(size int64, concurrency int) {
+		partSize, partConcurrency = size, concurrency
+	}(partSize, partConcurrency)
+	if err := SetDownloadParts(1, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := DownloadObject(sess, "logs", "E123.2018-08-20-12.abcd.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(obj.Filename)
+	if ranges != 4 {
+		t.Errorf("expected the object to be downloaded in 4 ranges, got %d", ranges)
+	}
+	downloaded, err := ioutil.ReadFile(obj.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, body) {
+		t.Errorf("expected the ranges to be put back together in order, got %d bytes", len(downloaded))
+	}
+
+	if err := SetDownloadParts(0, 3); err == nil {
+		t.Error("expected a part size of 0 to be refused")
+	}
+}
+
+func TestDownloaderDeadLetters(t *testing.T) {
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// HEADs explain the access being denied
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	stater := state.NewMemoryStater(1)
+	d := NewDownloader(sess, stater, &CloudFrontDownloader{BucketName: "logs", DistributionID: "E123"}, 1)
+	d.Retry = retry.Policy{Retries: 2, Base: time.Millisecond, Max: time.Millisecond}
+
+	d.downloadWithRetries(&s3.Object{
+		Key:          aws.String("E123.2018-08-20-12.abcd.gz"),
+		Size:         aws.Int64(100),
+		LastModified: aws.Time(time.Now()),
+	})
+
+	if gets != 3 {
+		t.Errorf("expected the download to be tried 3 times, got %d", gets)
+	}
+	letters, _ := stater.DeadLetters()
+	letter, ok := letters["E123.2018-08-20-12.abcd.gz"]
+	if !ok || letter.Attempts != 3 || !strings.Contains(letter.Error, "AccessDenied") {
+		t.Errorf("expected a dead letter for the object, got %v", letters)
+	}
+}
@@ -0,0 +1,158 @@
+package logbucket
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// MissingObjectError is returned for objects S3 had nothing at when they were
+// downloaded, e.g. a key ListObjects returned before GetObject would serve it
+// during a backfill, so that they can be checked for again later rather than
+// retried right away and given up on.
+type MissingObjectError struct {
+	Key string
+	Err error
+}
+
+func (e *MissingObjectError) Error() string {
+	return fmt.Sprintf("Object %s not found: %s", e.Key, e.Err)
+}
+
+func (e *MissingObjectError) Unwrap() error {
+	return e.Err
+}
+
+// notFound returns err as a MissingObjectError if it's S3 saying there's no
+// object at the key, and as it is otherwise.
+func notFound(key string, err error) error {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return &MissingObjectError{Key: key, Err: err}
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return &MissingObjectError{Key: key, Err: err}
+	}
+	return err
+}
+
+// recheck records that the object was found to be missing for the checks'th
+// time, and has it checked for again after MissingRecheckDelay, until the
+// MissingRechecks are used up and it's given up on. Objects given up on stay
+// recorded as missing, rather than becoming dead letters, since there was
+// nothing to download.
+func (d *Downloader) recheck(obj *s3.Object, missing *MissingObjectError, checks int) {
+	if r, ok := d.Stater.(state.Rechecker); ok {
+		if err := r.SetMissing(*obj.Key, state.Missing{
+			Error:  missing.Err.Error(),
+			Checks: checks,
+			Time:   time.Now(),
+		}); err != nil {
+			d.log().WithField("error", err).Error("Could not record the missing object")
+		}
+	}
+	if checks > d.MissingRechecks {
+		metrics.ObjectsMissing.WithLabelValues(d.String()).Inc()
+		d.log().WithFields(logrus.Fields{
+			"object": *obj.Key,
+			"checks": checks,
+			"error":  missing.Err,
+		}).Error("Giving up on object which is still missing")
+		return
+	}
+
+	d.log().WithFields(logrus.Fields{
+		"object":     *obj.Key,
+		"checks":     checks,
+		"recheck_in": d.MissingRecheckDelay,
+	}).Warn("Object is missing, checking for it again later")
+	d.scheduleRecheck(obj, checks, d.MissingRecheckDelay)
+}
+
+// scheduleRecheck downloads the object, which has been found to be missing
+// checks times, again after the delay. Objects still waiting to be checked for
+// when the downloader is stopped are left recorded as missing, to be checked
+// for once it's started again.
+func (d *Downloader) scheduleRecheck(obj *s3.Object, checks int, delay time.Duration) {
+	d.rechecking.Add(1)
+	go func() {
+		defer d.rechecking.Done()
+		select {
+		case <-time.After(delay):
+		case <-d.stop:
+			return
+		}
+		d.downloadChecked(obj, checks)
+	}()
+}
+
+// clearMissing forgets that the object was missing, once it's turned up, or
+// failed for some other reason.
+func (d *Downloader) clearMissing(key string) {
+	r, ok := d.Stater.(state.Rechecker)
+	if !ok {
+		return
+	}
+	if err := r.ClearMissing(key); err != nil {
+		d.log().WithFields(logrus.Fields{
+			"object": key,
+			"error":  err,
+		}).Error("Could not clear the missing object")
+	}
+}
+
+// resumeRechecks has the downloader's objects which an earlier run found to
+// be missing, and hadn't given up on, checked for again when they would have
+// been.
+func (d *Downloader) resumeRechecks() {
+	r, ok := d.Stater.(state.Rechecker)
+	if !ok || d.MissingRechecks == 0 {
+		return
+	}
+	missing, err := r.MissingObjects()
+	if err != nil {
+		d.log().WithField("error", err).Error("Could not get the missing objects to check for again")
+		return
+	}
+	for key, m := range missing {
+		if m.Checks > d.MissingRechecks || !d.owns(key, m.Time) {
+			continue
+		}
+		delay := d.MissingRecheckDelay - time.Since(m.Time)
+		if delay < 0 {
+			delay = 0
+		}
+		// What the listing had of the object isn't recorded, so its
+		// delivery lag is measured from when it was found missing.
+		d.scheduleRecheck(&s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(0),
+			LastModified: aws.Time(m.Time),
+		}, m.Checks, delay)
+	}
+}
+
+// owns returns whether the key is of one of the downloader's objects, going
+// by whether it's under the downloader's prefix for the day of its logs, or
+// either side of it for logs delivered around midnight. Keys without a time
+// go by when they were recorded instead.
+func (d *Downloader) owns(key string, recorded time.Time) bool {
+	t, ok := state.KeyTime(key)
+	if !ok {
+		t = recorded
+	}
+	day := t.UTC().Truncate(24 * time.Hour)
+	for _, day := range []time.Time{day.Add(-24 * time.Hour), day, day.Add(24 * time.Hour)} {
+		if strings.HasPrefix(key, d.ObjectPrefix(day)) {
+			return true
+		}
+	}
+	return false
+}
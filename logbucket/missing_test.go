@@ -0,0 +1,142 @@
+package logbucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/retry"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// missingServer serves a 404 for the first missing GETs, and the object after
+// that, counting the GETs.
+func missingServer(missing int32, gets *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(gets, 1) <= missing {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			return
+		}
+		w.Write([]byte("log line\n"))
+	}))
+}
+
+func newMissingDownloader(srv *httptest.Server, stater state.Stater) *Downloader {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	d := NewDownloader(sess, stater, &CloudFrontDownloader{BucketName: "logs", DistributionID: "E123"}, 1)
+	d.DownloadedObjects = make(chan state.DownloadedObject, 1)
+	d.Retry = retry.Policy{Retries: 2, Base: time.Millisecond, Max: time.Millisecond}
+	d.MissingRechecks = 2
+	d.MissingRecheckDelay = 10 * time.Millisecond
+	return d
+}
+
+func TestDownloaderRechecksMissing(t *testing.T) {
+	var gets int32
+	srv := missingServer(2, &gets)
+	defer srv.Close()
+	stater := state.NewMemoryStater(1)
+	d := newMissingDownloader(srv, stater)
+
+	d.downloadWithRetries(&s3.Object{
+		Key:          aws.String("E123.2018-08-20-12.abcd.gz"),
+		Size:         aws.Int64(9),
+		LastModified: aws.Time(time.Now()),
+	})
+	missing, _ := stater.MissingObjects()
+	if m := missing["E123.2018-08-20-12.abcd.gz"]; m.Checks != 1 {
+		t.Errorf("expected the object to be recorded as missing, got %v", missing)
+	}
+	d.rechecking.Wait()
+
+	if gets != 3 {
+		t.Errorf("expected the object to be checked for twice more rather than retried, got %d GETs", gets)
+	}
+	if obj := <-d.DownloadedObjects; obj.Object != "E123.2018-08-20-12.abcd.gz" {
+		t.Errorf("expected the object to be downloaded once it turned up, got %v", obj)
+	}
+	if missing, _ := stater.MissingObjects(); len(missing) != 0 {
+		t.Errorf("expected the object to no longer be missing, got %v", missing)
+	}
+	if letters, _ := stater.DeadLetters(); len(letters) != 0 {
+		t.Errorf("expected no dead letters, got %v", letters)
+	}
+}
+
+func TestDownloaderGivesUpOnMissing(t *testing.T) {
+	var gets int32
+	srv := missingServer(100, &gets)
+	defer srv.Close()
+	stater := state.NewMemoryStater(1)
+	d := newMissingDownloader(srv, stater)
+
+	d.downloadWithRetries(&s3.Object{
+		Key:          aws.String("E123.2018-08-20-12.abcd.gz"),
+		Size:         aws.Int64(9),
+		LastModified: aws.Time(time.Now()),
+	})
+	d.rechecking.Wait()
+
+	if gets != 3 {
+		t.Errorf("expected the object to be checked for 3 times in all, got %d GETs", gets)
+	}
+	missing, _ := stater.MissingObjects()
+	if m := missing["E123.2018-08-20-12.abcd.gz"]; m.Checks != 3 || m.Error == "" {
+		t.Errorf("expected the object to be left recorded as missing, got %v", missing)
+	}
+	if letters, _ := stater.DeadLetters(); len(letters) != 0 {
+		t.Errorf("expected missing objects not to be dead letters, got %v", letters)
+	}
+
+	// without re-checks, they're failures like any other
+	d.MissingRechecks = 0
+	d.downloadWithRetries(&s3.Object{
+		Key:          aws.String("E123.2018-08-20-13.abcd.gz"),
+		Size:         aws.Int64(9),
+		LastModified: aws.Time(time.Now()),
+	})
+	if letters, _ := stater.DeadLetters(); letters["E123.2018-08-20-13.abcd.gz"].Attempts != 3 {
+		t.Errorf("expected a dead letter after retrying, got %v", letters)
+	}
+}
+
+func TestDownloaderResumesRechecks(t *testing.T) {
+	var gets int32
+	srv := missingServer(0, &gets)
+	defer srv.Close()
+	stater := state.NewMemoryStater(1)
+	d := newMissingDownloader(srv, stater)
+
+	found := time.Now().Add(-time.Minute)
+	stater.SetMissing("E123.2018-08-20-12.abcd.gz", state.Missing{Error: "NoSuchKey", Checks: 1, Time: found})
+	// given up on
+	stater.SetMissing("E123.2018-08-20-12.efgh.gz", state.Missing{Error: "NoSuchKey", Checks: 3, Time: found})
+	// another distribution's
+	stater.SetMissing("E456.2018-08-20-12.abcd.gz", state.Missing{Error: "NoSuchKey", Checks: 1, Time: found})
+
+	d.resumeRechecks()
+	d.rechecking.Wait()
+
+	if gets != 1 {
+		t.Errorf("expected just the downloader's object still being checked for to be, got %d GETs", gets)
+	}
+	if obj := <-d.DownloadedObjects; obj.Object != "E123.2018-08-20-12.abcd.gz" {
+		t.Errorf("unexpected object %v", obj)
+	}
+	if missing, _ := stater.MissingObjects(); len(missing) != 2 {
+		t.Errorf("expected the other objects to be left alone, got %v", missing)
+	}
+}
@@ -0,0 +1,20 @@
+package logbucket
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// objectTime returns the time the object's logs are for, going by its key,
+// falling back to when it was last modified. The two are usually within a few
+// minutes of each other, except for objects which have been copied, e.g. by
+// S3 Cross-Region Replication into a replica bucket, whose LastModified is
+// when they were replicated.
+func objectTime(obj *s3.Object) time.Time {
+	if t, ok := state.KeyTime(*obj.Key); ok {
+		return t
+	}
+	return *obj.LastModified
+}
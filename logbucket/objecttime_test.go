@@ -0,0 +1,28 @@
+package logbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestObjectTime(t *testing.T) {
+	replicated := time.Date(2018, 8, 21, 3, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		key      string
+		expected time.Time
+	}{
+		{"AWSLogs/123456789012/elasticloadbalancing/us-east-1/2018/08/20/123456789012_elasticloadbalancing_us-east-1_app.my-lb.1db0c9806095122a_20180820T1120Z_10.0.0.1_2kzkd1j2.log.gz", time.Date(2018, 8, 20, 11, 20, 0, 0, time.UTC)},
+		{"AWSLogs/123456789012/CloudTrail/us-east-1/2018/08/20/123456789012_CloudTrail_us-east-1_20180820T2355Z_abcd.json.gz", time.Date(2018, 8, 20, 23, 55, 0, 0, time.UTC)},
+		{"cf/E123.2018-08-20-11.abcd1234.gz", time.Date(2018, 8, 20, 12, 0, 0, 0, time.UTC)},
+		{"something/else.log", replicated},
+	}
+	for _, tc := range testCases {
+		obj := &s3.Object{Key: aws.String(tc.key), LastModified: aws.Time(replicated)}
+		if ts := objectTime(obj); !ts.Equal(tc.expected) {
+			t.Errorf("Expected time of %s to be %s, got %s", tc.key, tc.expected, ts)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package logbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/meta"
+	"github.com/sirupsen/logrus"
+)
+
+// LogDeliverySid is the statement restored by --fix, replacing any earlier
+// one of ours.
+const LogDeliverySid = "HoneyawsLogDelivery"
+
+// Classic and Application Load Balancers deliver logs from an AWS account of
+// their region, see
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/enable-access-logging.html#attach-bucket-policy
+// Regions which aren't listed deliver them from elbLogDeliveryService
+// instead, and Network Load Balancers from nlbLogDeliveryService.
+var elbAccountIDs = map[string]string{
+	"us-east-1":      "127311923021",
+	"us-east-2":      "033677994240",
+	"us-west-1":      "027434742980",
+	"us-west-2":      "797873946194",
+	"af-south-1":     "098369216593",
+	"ap-east-1":      "754344448648",
+	"ap-southeast-3": "589379963580",
+	"ap-south-1":     "718504428378",
+	"ap-northeast-3": "383597477331",
+	"ap-northeast-2": "600734575887",
+	"ap-southeast-1": "114774131450",
+	"ap-southeast-2": "783225319266",
+	"ap-northeast-1": "582318560864",
+	"ca-central-1":   "985666609251",
+	"eu-central-1":   "054676820928",
+	"eu-west-1":      "156460612806",
+	"eu-west-2":      "652711504416",
+	"eu-south-1":     "635631232127",
+	"eu-west-3":      "009996457667",
+	"eu-north-1":     "897822967062",
+	"me-south-1":     "076674570225",
+	"sa-east-1":      "507241528517",
+	"us-gov-west-1":  "048591011584",
+	"us-gov-east-1":  "190560391635",
+	"cn-north-1":     "638102146993",
+	"cn-northwest-1": "037604701340",
+}
+
+const (
+	elbLogDeliveryService = "logdelivery.elasticloadbalancing.amazonaws.com"
+	nlbLogDeliveryService = "delivery.logs.amazonaws.com"
+)
+
+// LogDelivery is where a load balancer's access logs are delivered to, for
+// checking that the bucket policy still allows them to be. Policy drift, e.g.
+// the statement being dropped when someone else edits the policy, stops logs
+// without any error showing up anywhere.
+type LogDelivery struct {
+	Sess                                       *session.Session
+	Service, Bucket, Prefix, AccountID, Region string
+	// Tags are the --aws_tags CreateBucket tags a bucket it creates with.
+	Tags map[string]string
+}
+
+// NewLogDelivery returns where the logs of a load balancer of the service
+// (AWSElasticLoadBalancing, AWSElasticLoadBalancingV2 or
+// AWSNetworkLoadBalancing) in the session's account and region go.
+func NewLogDelivery(sess *session.Session, service, bucket, prefix string) *LogDelivery {
+	metadata := meta.Data(sess)
+	return &LogDelivery{
+		Sess:      sess,
+		Service:   service,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		AccountID: metadata.AccountID,
+		Region:    metadata.Region,
+	}
+}
+
+func (l *LogDelivery) partition() string {
+	return meta.Partition(l.Region)
+}
+
+// BucketARN is the ARN of the bucket the logs are written to.
+func (l *LogDelivery) BucketARN() string {
+	return fmt.Sprintf("arn:%s:s3:::%s", l.partition(), l.Bucket)
+}
+
+// Resource is the ARN of the objects the logs are written to.
+func (l *LogDelivery) Resource() string {
+	path := l.Bucket
+	if prefix := strings.Trim(l.Prefix, "/"); prefix != "" {
+		path += "/" + prefix
+	}
+	return fmt.Sprintf("arn:%s:s3:::%s/AWSLogs/%s/*", l.partition(), path, l.AccountID)
+}
+
+// Principal returns the principal which delivers the logs, as the key
+// ("AWS" or "Service") and value it has in a policy.
+func (l *LogDelivery) Principal() (string, string) {
+	if l.Service == AWSNetworkLoadBalancing {
+		return "Service", nlbLogDeliveryService
+	}
+	if id, ok := elbAccountIDs[l.Region]; ok {
+		return "AWS", fmt.Sprintf("arn:%s:iam::%s:root", l.partition(), id)
+	}
+	return "Service", elbLogDeliveryService
+}
+
+// stringOrSlice is a policy element which may be a string or a list of them.
+type stringOrSlice []string
+
+func (s *stringOrSlice) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = []string{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+type policyStatement struct {
+	Effect    string
+	Principal json.RawMessage
+	Action    stringOrSlice
+	Resource  stringOrSlice
+}
+
+func (st *policyStatement) principals() map[string][]string {
+	var all string
+	if err := json.Unmarshal(st.Principal, &all); err == nil {
+		return map[string][]string{"*": {all}}
+	}
+	var principals map[string]stringOrSlice
+	json.Unmarshal(st.Principal, &principals)
+	ps := make(map[string][]string, len(principals))
+	for k, v := range principals {
+		ps[k] = v
+	}
+	return ps
+}
+
+// wildcardMatch matches s against a policy pattern, in which * matches any
+// characters and ? any one character.
+func wildcardMatch(pattern, s string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\*`, ".*", -1)
+	re = strings.Replace(re, `\?`, ".", -1)
+	ok, _ := regexp.MatchString("(?i)^"+re+"$", s)
+	return ok
+}
+
+func matchesAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if wildcardMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the statement lets the principal put the resource.
+// Conditions aren't taken into account.
+func (st *policyStatement) allows(key, principal, resource string) bool {
+	if st.Effect != "Allow" || !matchesAny(st.Action, "s3:PutObject") || !matchesAny(st.Resource, resource) {
+		return false
+	}
+	ps := st.principals()
+	if _, ok := ps["*"]; ok {
+		return true
+	}
+	for _, p := range ps[key] {
+		// Accounts may be given by ID as well as by root ARN.
+		if p == "*" || p == principal || (key == "AWS" && strings.HasSuffix(principal, ":"+p+":root")) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyAllows reports whether the (JSON) policy has a statement allowing the
+// logs to be delivered. Deny statements aren't taken into account.
+func (l *LogDelivery) policyAllows(policy string) (bool, error) {
+	var doc struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return false, err
+	}
+
+	var statements []policyStatement
+	if err := json.Unmarshal(doc.Statement, &statements); err != nil {
+		var one policyStatement
+		if err := json.Unmarshal(doc.Statement, &one); err != nil {
+			return false, err
+		}
+		statements = []policyStatement{one}
+	}
+
+	key, principal := l.Principal()
+	for _, st := range statements {
+		if st.allows(key, principal, l.Resource()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// policy returns the bucket's policy, or "" if it doesn't have one.
+func (l *LogDelivery) policy() (string, error) {
+	resp, err := s3.New(l.Sess).GetBucketPolicy(&s3.GetBucketPolicyInput{
+		Bucket: aws.String(l.Bucket),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchBucketPolicy" {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.Policy), nil
+}
+
+// Permitted reports whether the bucket policy allows the logs to be
+// delivered.
+func (l *LogDelivery) Permitted() (bool, error) {
+	policy, err := l.policy()
+	if err != nil || policy == "" {
+		return false, err
+	}
+	return l.policyAllows(policy)
+}
+
+// fixedPolicy adds the statement allowing the logs to be delivered to the
+// (JSON) policy, leaving the rest of it alone.
+func (l *LogDelivery) fixedPolicy(policy string) (string, error) {
+	doc := map[string]interface{}{"Version": "2012-10-17"}
+	if policy != "" {
+		if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+			return "", err
+		}
+	}
+
+	var statements []interface{}
+	switch st := doc["Statement"].(type) {
+	case []interface{}:
+		statements = st
+	case map[string]interface{}:
+		statements = []interface{}{st}
+	}
+
+	kept := statements[:0]
+	for _, st := range statements {
+		if m, ok := st.(map[string]interface{}); ok && m["Sid"] == LogDeliverySid {
+			continue
+		}
+		kept = append(kept, st)
+	}
+
+	key, principal := l.Principal()
+	doc["Statement"] = append(kept, map[string]interface{}{
+		"Sid":       LogDeliverySid,
+		"Effect":    "Allow",
+		"Principal": map[string]string{key: principal},
+		"Action":    "s3:PutObject",
+		"Resource":  l.Resource(),
+	})
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Fix restores the statement in the bucket policy allowing the logs to be
+// delivered.
+func (l *LogDelivery) Fix() error {
+	policy, err := l.policy()
+	if err != nil {
+		return err
+	}
+	fixed, err := l.fixedPolicy(policy)
+	if err != nil {
+		return err
+	}
+	_, err = s3.New(l.Sess).PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(l.Bucket),
+		Policy: aws.String(fixed),
+	})
+	return err
+}
+
+// Validate returns an error if the bucket policy doesn't allow the logs to be
+// delivered, unless fix is set and the policy could be fixed.
+func (l *LogDelivery) Validate(fix bool) error {
+	ok, err := l.Permitted()
+	if err != nil {
+		return fmt.Errorf("Could not check the policy of bucket %q: %s", l.Bucket, err)
+	}
+	if ok {
+		return nil
+	}
+
+	_, principal := l.Principal()
+	if !fix {
+		return fmt.Errorf("The policy of bucket %q doesn't allow %s to deliver access logs to %s, use --fix to restore it", l.Bucket, principal, l.Resource())
+	}
+	if err := l.Fix(); err != nil {
+		return fmt.Errorf("Could not fix the policy of bucket %q: %s", l.Bucket, err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"bucket":    l.Bucket,
+		"principal": principal,
+		"resource":  l.Resource(),
+	}).Warn("Restored the bucket policy statement allowing access logs to be delivered")
+	return nil
+}
+
+// createBucketInput creates the bucket in the region of the load balancer,
+// which us-east-1 is the default of.
+func (l *LogDelivery) createBucketInput() *s3.CreateBucketInput {
+	input := &s3.CreateBucketInput{Bucket: aws.String(l.Bucket)}
+	if l.Region != "" && l.Region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(l.Region),
+		}
+	}
+	return input
+}
+
+// taggingInput tags the bucket with the Tags, sorted by key.
+func (l *LogDelivery) taggingInput() *s3.PutBucketTaggingInput {
+	keys := make([]string, 0, len(l.Tags))
+	for k := range l.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tagging := &s3.Tagging{TagSet: []*s3.Tag{}}
+	for _, k := range keys {
+		tagging.TagSet = append(tagging.TagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(l.Tags[k])})
+	}
+	return &s3.PutBucketTaggingInput{Bucket: aws.String(l.Bucket), Tagging: tagging}
+}
+
+// CreateBucket creates the bucket for the logs, for enable-logging, along with
+// the policy allowing them to be delivered, and tags it with the Tags. A
+// bucket of ours which exists already is only given the policy statement, so
+// that tags someone else has given it aren't replaced.
+func (l *LogDelivery) CreateBucket() error {
+	svc := s3.New(l.Sess)
+	_, err := svc.CreateBucket(l.createBucketInput())
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeBucketAlreadyOwnedByYou {
+		err = nil
+	} else if err == nil {
+		logrus.WithFields(logrus.Fields{
+			"bucket": l.Bucket,
+			"region": l.Region,
+		}).Info("Created bucket for access logs")
+		if len(l.Tags) > 0 {
+			if _, err := svc.PutBucketTagging(l.taggingInput()); err != nil {
+				return fmt.Errorf("Could not tag bucket %q: %s", l.Bucket, err)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Could not create bucket %q: %s", l.Bucket, err)
+	}
+	if err := l.Fix(); err != nil {
+		return fmt.Errorf("Could not set the policy of bucket %q: %s", l.Bucket, err)
+	}
+	return nil
+}
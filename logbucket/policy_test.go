@@ -0,0 +1,103 @@
+package logbucket
+
+import (
+	"testing"
+)
+
+func TestLogDeliveryResource(t *testing.T) {
+	l := &LogDelivery{Bucket: "logs", Prefix: "/prod/", AccountID: "123456789012", Region: "us-east-1"}
+	if r := l.Resource(); r != "arn:aws:s3:::logs/prod/AWSLogs/123456789012/*" {
+		t.Errorf("unexpected resource %q", r)
+	}
+
+	l = &LogDelivery{Bucket: "logs", AccountID: "123456789012", Region: "us-gov-west-1"}
+	if r := l.Resource(); r != "arn:aws-us-gov:s3:::logs/AWSLogs/123456789012/*" {
+		t.Errorf("unexpected resource %q", r)
+	}
+}
+
+func TestLogDeliveryPolicyAllows(t *testing.T) {
+	alb := &LogDelivery{Service: AWSElasticLoadBalancingV2, Bucket: "logs", AccountID: "123456789012", Region: "us-east-1"}
+	nlb := &LogDelivery{Service: AWSNetworkLoadBalancing, Bucket: "logs", AccountID: "123456789012", Region: "us-east-1"}
+	newRegion := &LogDelivery{Service: AWSElasticLoadBalancingV2, Bucket: "logs", AccountID: "123456789012", Region: "ap-south-2"}
+
+	cases := []struct {
+		name     string
+		delivery *LogDelivery
+		policy   string
+		allowed  bool
+	}{
+		{"region account", alb, `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::127311923021:root"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/AWSLogs/123456789012/*"}]}`, true},
+		{"account id and wildcards", alb, `{"Statement": {"Effect": "Allow", "Principal": {"AWS": ["111111111111", "127311923021"]}, "Action": ["s3:Put*"], "Resource": ["arn:aws:s3:::logs/*"]}}`, true},
+		{"wrong region account", alb, `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::797873946194:root"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/*"}]}`, false},
+		{"wrong prefix", alb, `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::127311923021:root"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/other/*"}]}`, false},
+		{"only get", alb, `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::logs/*"}]}`, false},
+		{"deny", alb, `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::logs/*"}]}`, false},
+		{"nlb service", nlb, `{"Statement": [{"Effect": "Allow", "Principal": {"Service": "delivery.logs.amazonaws.com"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/AWSLogs/123456789012/*"}]}`, true},
+		{"nlb needs the service", nlb, `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::127311923021:root"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/*"}]}`, false},
+		{"newer region service", newRegion, `{"Statement": [{"Effect": "Allow", "Principal": {"Service": "logdelivery.elasticloadbalancing.amazonaws.com"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/*"}]}`, true},
+	}
+
+	for _, c := range cases {
+		allowed, err := c.delivery.policyAllows(c.policy)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if allowed != c.allowed {
+			t.Errorf("%s: expected allowed to be %v", c.name, c.allowed)
+		}
+	}
+}
+
+func TestLogDeliveryFixedPolicy(t *testing.T) {
+	l := &LogDelivery{Service: AWSElasticLoadBalancingV2, Bucket: "logs", AccountID: "123456789012", Region: "eu-west-1"}
+
+	for _, policy := range []string{
+		"",
+		`{"Version": "2012-10-17", "Statement": {"Sid": "Other", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::logs/*"}}`,
+		// an outdated statement of ours is replaced
+		`{"Version": "2012-10-17", "Statement": [{"Sid": "HoneyawsLogDelivery", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::127311923021:root"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/*"}]}`,
+	} {
+		fixed, err := l.fixedPolicy(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed, err := l.policyAllows(fixed); err != nil || !allowed {
+			t.Errorf("expected the fixed policy to allow delivery: %s (%v)", fixed, err)
+		}
+	}
+
+	fixed, err := l.fixedPolicy(`{"Version": "2012-10-17", "Statement": [{"Sid": "Other", "Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::logs/*"}, {"Sid": "HoneyawsLogDelivery", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::127311923021:root"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::logs/*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Statement":[{"Action":"s3:GetObject","Effect":"Allow","Principal":"*","Resource":"arn:aws:s3:::logs/*","Sid":"Other"},{"Action":"s3:PutObject","Effect":"Allow","Principal":{"AWS":"arn:aws:iam::156460612806:root"},"Resource":"arn:aws:s3:::logs/AWSLogs/123456789012/*","Sid":"HoneyawsLogDelivery"}],"Version":"2012-10-17"}`
+	if fixed != expected {
+		t.Errorf("unexpected fixed policy:\n%s\nexpected:\n%s", fixed, expected)
+	}
+}
+
+func TestLogDeliveryCreateBucketInput(t *testing.T) {
+	l := &LogDelivery{Bucket: "logs", Region: "us-east-1"}
+	if input := l.createBucketInput(); input.CreateBucketConfiguration != nil {
+		t.Errorf("expected no location constraint in us-east-1, got %v", input)
+	}
+
+	l = &LogDelivery{Bucket: "logs", Region: "eu-west-1"}
+	input := l.createBucketInput()
+	if input.CreateBucketConfiguration == nil || *input.CreateBucketConfiguration.LocationConstraint != "eu-west-1" {
+		t.Errorf("expected the bucket to be created in eu-west-1, got %v", input)
+	}
+}
+
+func TestLogDeliveryTaggingInput(t *testing.T) {
+	l := &LogDelivery{Bucket: "logs", Tags: map[string]string{"team": "observability", "cost-center": "42"}}
+	input := l.taggingInput()
+	if *input.Bucket != "logs" || len(input.Tagging.TagSet) != 2 {
+		t.Fatalf("expected the bucket to be tagged, got %v", input)
+	}
+	if *input.Tagging.TagSet[0].Key != "cost-center" || *input.Tagging.TagSet[1].Value != "observability" {
+		t.Errorf("expected the tags sorted by key, got %v", input.Tagging.TagSet)
+	}
+}
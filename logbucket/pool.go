@@ -0,0 +1,38 @@
+package logbucket
+
+// DownloadPool limits how many objects are downloaded at once across every
+// Downloader sharing it, no matter how many load balancers (or distributions,
+// or trails) are being ingested. A slot is held until the downloaded object
+// has been handed to the publisher, so at most that many downloaded objects
+// are waiting on disk to be published too.
+type DownloadPool struct {
+	slots chan struct{}
+}
+
+func NewDownloadPool(workers int) *DownloadPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &DownloadPool{slots: make(chan struct{}, workers)}
+}
+
+// acquire waits for a free slot, returning false if stop is closed first. A
+// nil pool doesn't limit downloads.
+func (p *DownloadPool) acquire(stop <-chan struct{}) bool {
+	if p == nil {
+		return true
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (p *DownloadPool) release() {
+	if p == nil {
+		return
+	}
+	<-p.slots
+}
@@ -0,0 +1,34 @@
+package logbucket
+
+import (
+	"testing"
+)
+
+func TestDownloadPool(t *testing.T) {
+	p := NewDownloadPool(2)
+	stop := make(chan struct{})
+
+	if !p.acquire(stop) || !p.acquire(stop) {
+		t.Fatal("expected to acquire both slots")
+	}
+
+	// with every slot taken, acquiring waits until stopped
+	close(stop)
+	if p.acquire(stop) {
+		t.Error("expected acquiring a third slot to give up once stopped")
+	}
+
+	p.release()
+	if !p.acquire(make(chan struct{})) {
+		t.Error("expected to acquire the released slot")
+	}
+
+	// a nil pool doesn't limit anything
+	var nilPool *DownloadPool
+	for i := 0; i < 10; i++ {
+		if !nilPool.acquire(stop) {
+			t.Fatal("expected a nil pool to always acquire")
+		}
+	}
+	nilPool.release()
+}
@@ -0,0 +1,34 @@
+package logbucket
+
+import (
+	"time"
+)
+
+// PrefixDownloader downloads whatever log objects are under a prefix of a
+// bucket, for --bucket, without knowing which load balancer or distribution
+// they're from. Since keys under a prefix like that aren't ordered by time,
+// e.g. with the logs of several load balancers, the listing isn't picked up
+// from a cursor, and the whole prefix is listed each time.
+type PrefixDownloader struct {
+	Prefix, BucketName string
+}
+
+func NewPrefixDownloader(bucketName, bucketPrefix string) *PrefixDownloader {
+	return &PrefixDownloader{
+		BucketName: bucketName,
+		Prefix:     bucketPrefix,
+	}
+}
+
+// ObjectPrefix is the same whatever the day.
+func (d *PrefixDownloader) ObjectPrefix(day time.Time) string {
+	return d.Prefix
+}
+
+func (d *PrefixDownloader) String() string {
+	return "s3://" + d.BucketName + "/" + d.Prefix
+}
+
+func (d *PrefixDownloader) Bucket() string {
+	return d.BucketName
+}
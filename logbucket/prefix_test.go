@@ -0,0 +1,49 @@
+package logbucket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestListWindowPrefix(t *testing.T) {
+	listings := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listings++
+		if prefix := r.URL.Query().Get("prefix"); prefix != "alb-logs/" {
+			t.Errorf("Expected to list alb-logs/, got %q", prefix)
+		}
+		contents := ""
+		for _, key := range []string{
+			"alb-logs/AWSLogs/1234/elasticloadbalancing/us-east-1/2018/08/19/1234_elasticloadbalancing_us-east-1_app.a.1_20180819T2355Z_10.0.0.1_x.log.gz",
+			"alb-logs/AWSLogs/1234/elasticloadbalancing/us-east-1/2018/08/20/1234_elasticloadbalancing_us-east-1_app.b.1_20180820T0005Z_10.0.0.1_x.log.gz",
+		} {
+			contents += fmt.Sprintf("<Contents><Key>%s</Key><Size>4</Size><LastModified>2018-08-20T01:00:00Z</LastModified></Contents>", key)
+		}
+		w.Write([]byte("<ListBucketResult><IsTruncated>false</IsTruncated>" + contents + "</ListBucketResult>"))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	start := time.Date(2018, 8, 19, 23, 50, 0, 0, time.UTC)
+	objs, err := ListWindow(sess, NewPrefixDownloader("logs", "alb-logs/"), start, start.Add(20*time.Minute))
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	// The window spans two days, but the prefix is the same for both.
+	if listings != 1 || len(objs) != 2 {
+		t.Errorf("Expected the prefix to be listed once, for both objects, got %d listings of %d objects", listings, len(objs))
+	}
+}
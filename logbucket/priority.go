@@ -0,0 +1,126 @@
+package logbucket
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// backfillHour is the backfill objects with logs for an hour, and how many
+// 5xx responses they're estimated to have.
+type backfillHour struct {
+	hour    time.Time
+	objects []*s3.Object
+	errors  float64
+}
+
+// deferBackfill holds back an object to be backfilled, for it to be queued
+// along with the rest of the listing's backfill in order of error density,
+// returning whether it was. Live objects, and those which aren't to be queued
+// at all, are left to queueObject.
+func (d *Downloader) deferBackfill(processedObjects map[string]time.Time, obj *s3.Object) bool {
+	if d.ErrorRate == nil || d.beforeTail(obj) {
+		return false
+	}
+	if _, ok := processedObjects[*obj.Key]; ok {
+		return false
+	}
+	logTime, now := objectTime(obj), time.Now()
+	if age := now.Sub(logTime); age <= liveWindow || age >= d.BackfillInterval || d.Schedule.isBackfill(logTime, now) {
+		return false
+	}
+	d.backfill = append(d.backfill, obj)
+	return true
+}
+
+// hourlyBackfill groups the objects by the hour their logs are for.
+func hourlyBackfill(objs []*s3.Object) []*backfillHour {
+	byHour := make(map[time.Time]*backfillHour)
+	var hours []*backfillHour
+	for _, obj := range objs {
+		hour := objectTime(obj).Truncate(time.Hour)
+		h, ok := byHour[hour]
+		if !ok {
+			h = &backfillHour{hour: hour}
+			byHour[hour] = h
+			hours = append(hours, h)
+		}
+		h.objects = append(h.objects, obj)
+	}
+	return hours
+}
+
+// orderBackfill estimates the 5xx responses in each hour from the error rate
+// of one of its objects and the size of them all, ordering the hours with the
+// most first. Hours estimated alike are backfilled most recent first.
+func orderBackfill(hours []*backfillHour, errorRate func(obj *s3.Object) (float64, error)) {
+	for _, h := range hours {
+		sample := h.objects[len(h.objects)/2]
+		rate, err := errorRate(sample)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"object": *sample.Key,
+				"error":  err,
+			}).Error("Could not sample the error rate of backfill object")
+			continue
+		}
+		var size int64
+		for _, obj := range h.objects {
+			if obj.Size != nil {
+				size += *obj.Size
+			}
+		}
+		h.errors = rate * float64(size)
+	}
+
+	sort.SliceStable(hours, func(i, j int) bool {
+		if hours[i].errors != hours[j].errors {
+			return hours[i].errors > hours[j].errors
+		}
+		return hours[i].hour.After(hours[j].hour)
+	})
+}
+
+// sampleErrorRate downloads and parses the object for its error rate.
+func (d *Downloader) sampleErrorRate(obj *s3.Object) (float64, error) {
+	downloadedObj, err := DownloadObject(d.Sess, d.Bucket(), *obj.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(downloadedObj.Filename)
+	return d.ErrorRate(downloadedObj)
+}
+
+// queueBackfill queues the backfill held back from the listing, the hours
+// with the most errors first.
+func (d *Downloader) queueBackfill(processedObjects map[string]time.Time) {
+	objs := d.backfill
+	d.backfill = nil
+	if len(objs) == 0 {
+		return
+	}
+
+	hours := hourlyBackfill(objs)
+	d.log().WithFields(logrus.Fields{
+		"objects": len(objs),
+		"hours":   len(hours),
+	}).Info("Sampling backfill to ingest the hours with the most errors first")
+	orderBackfill(hours, d.sampleErrorRate)
+
+	for _, h := range hours {
+		d.log().WithFields(logrus.Fields{
+			"hour":    h.hour.Format(time.RFC3339),
+			"objects": len(h.objects),
+		}).Debug("Backfilling hour")
+		for _, obj := range h.objects {
+			if d.stopped() {
+				return
+			}
+			d.queueObject(processedObjects, obj)
+			d.setPolled(false)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package logbucket
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func backfillObject(hour time.Time, minute int, size int64) *s3.Object {
+	t := hour.Add(time.Duration(minute) * time.Minute)
+	return &s3.Object{
+		Key:          aws.String(fmt.Sprintf("AWSLogs/123_elasticloadbalancing_us-east-1_app.my-lb.1db0c9806095122a_%s_10.0.0.1_abcd.log.gz", t.Format("20060102T1504Z"))),
+		Size:         aws.Int64(size),
+		LastModified: aws.Time(t),
+	}
+}
+
+func TestOrderBackfill(t *testing.T) {
+	base := time.Now().UTC().Truncate(time.Hour).Add(-6 * time.Hour)
+	quiet, incident, failing, busy := base, base.Add(time.Hour), base.Add(2*time.Hour), base.Add(3*time.Hour)
+	objs := []*s3.Object{
+		backfillObject(quiet, 5, 100), backfillObject(quiet, 10, 100),
+		backfillObject(incident, 5, 100), backfillObject(incident, 10, 100),
+		backfillObject(failing, 5, 100),
+		backfillObject(busy, 5, 1000), backfillObject(busy, 10, 1000),
+	}
+
+	hours := hourlyBackfill(objs)
+	if len(hours) != 4 || len(hours[0].objects) != 2 || !hours[0].hour.Equal(quiet) {
+		t.Fatalf("unexpected grouping by hour %v", hours)
+	}
+
+	rates := map[time.Time]float64{quiet: 0, incident: 0.5, busy: 0.01}
+	orderBackfill(hours, func(obj *s3.Object) (float64, error) {
+		hour := objectTime(obj).Truncate(time.Hour)
+		if hour.Equal(failing) {
+			return 0, errors.New("access denied")
+		}
+		return rates[hour], nil
+	})
+
+	// 0.5*200 > 0.01*2000, and the rest have none, recent first.
+	expected := []time.Time{incident, busy, failing, quiet}
+	for i, h := range hours {
+		if !h.hour.Equal(expected[i]) {
+			t.Errorf("expected hour %d to be %v, got %v", i, expected[i], h.hour)
+		}
+	}
+}
+
+func TestDeferBackfill(t *testing.T) {
+	d := &Downloader{BackfillInterval: 24 * time.Hour}
+	now := time.Now().UTC()
+	old := backfillObject(now.Add(-3*time.Hour), 0, 1)
+	live := backfillObject(now.Add(-10*time.Minute), 0, 1)
+	expired := backfillObject(now.Add(-48*time.Hour), 0, 1)
+	processed := backfillObject(now.Add(-4*time.Hour), 0, 1)
+
+	if d.deferBackfill(nil, old) {
+		t.Error("expected backfill not to be held back without ErrorRate")
+	}
+
+	d.ErrorRate = func(state.DownloadedObject) (float64, error) { return 0, nil }
+	processedObjects := map[string]time.Time{*processed.Key: now}
+	for _, obj := range []*s3.Object{old, live, expired, processed} {
+		d.deferBackfill(processedObjects, obj)
+	}
+	if len(d.backfill) != 1 || d.backfill[0] != old {
+		t.Errorf("expected only the unprocessed backfill to be held back, got %v", d.backfill)
+	}
+}
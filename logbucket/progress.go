@@ -0,0 +1,240 @@
+package logbucket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// progress is how far a downloader has got through the objects in its
+// backfill window, for --progress_interval. The objects are counted before
+// the first listing, so how many are left is known from the start.
+type progress struct {
+	sync.Mutex
+	started   time.Time
+	processed int64
+	remaining int64
+	oldest    time.Time
+	done      bool
+}
+
+// allProgress has the progress of every downloader reporting it, for the
+// totals across them.
+var allProgress = struct {
+	sync.Mutex
+	once       sync.Once
+	downloader map[string]*progress
+}{downloader: make(map[string]*progress)}
+
+// countBackfill counts the objects the listings will send along to be
+// processed, those in the backfill window which haven't been already.
+func (d *Downloader) countBackfill(svc *s3.S3, processedObjects map[string]time.Time, inputs ...*s3.ListObjectsV2Input) {
+	var remaining int64
+	var err error
+	for _, input := range inputs {
+		if err = svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				if _, ok := processedObjects[*obj.Key]; ok {
+					continue
+				}
+				if time.Since(objectTime(obj)) < d.BackfillInterval {
+					remaining++
+				}
+			}
+			return !d.stopped()
+		}); err != nil {
+			break
+		}
+	}
+	d.progress.Lock()
+	defer d.progress.Unlock()
+	if err != nil {
+		d.log().WithField("error", err).Warn("Could not count the objects to backfill, not reporting progress")
+		d.progress.done = true
+		return
+	}
+	// a poller restarted part way through carries on where it got to
+	if d.progress.started.IsZero() {
+		d.progress.started = time.Now()
+	}
+	d.progress.remaining = remaining
+	d.log().WithField("remaining", remaining).Info("Counted the objects to backfill")
+}
+
+// next records the object as the one being processed, which is the oldest
+// not yet done with, since objects are listed in order.
+func (p *progress) next(obj *s3.Object) {
+	p.Lock()
+	defer p.Unlock()
+	if p.started.IsZero() || p.done {
+		return
+	}
+	p.oldest = objectTime(obj)
+}
+
+// objectProcessed records that an object has been sent along to be
+// processed.
+func (p *progress) objectProcessed() {
+	p.Lock()
+	defer p.Unlock()
+	if p.started.IsZero() || p.done {
+		return
+	}
+	p.processed++
+	if p.remaining > 0 {
+		p.remaining--
+	}
+}
+
+// finish records that the backfill has been listed in full, so there's
+// nothing left, whatever was counted.
+func (p *progress) finish() {
+	p.Lock()
+	defer p.Unlock()
+	if p.started.IsZero() {
+		return
+	}
+	p.remaining = 0
+	p.oldest = time.Time{}
+	p.done = true
+}
+
+// counting returns whether the objects to backfill haven't been counted yet.
+func (p *progress) counting() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.started.IsZero()
+}
+
+// snapshot returns the progress as of now, with the ETA going by how fast
+// objects have been processed since the backfill started.
+func (p *progress) snapshot(now time.Time) (state.Progress, bool) {
+	p.Lock()
+	defer p.Unlock()
+	sp := state.Progress{
+		Processed: p.processed,
+		Remaining: p.remaining,
+		Oldest:    p.oldest,
+		Time:      now,
+	}
+	if p.done || p.remaining == 0 {
+		sp.Oldest = time.Time{}
+	} else if p.processed > 0 {
+		perObject := now.Sub(p.started) / time.Duration(p.processed)
+		sp.ETA = now.Add(perObject * time.Duration(p.remaining))
+	}
+	return sp, p.done
+}
+
+// reportProgress logs, exports and records in the state how far the backfill
+// has got every interval, until it's done.
+func (d *Downloader) reportProgress() {
+	allProgress.Lock()
+	allProgress.downloader[d.String()] = &d.progress
+	allProgress.Unlock()
+	allProgress.once.Do(func() {
+		go reportTotalProgress(d.ProgressInterval)
+	})
+
+	t := time.NewTicker(d.ProgressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-d.stop:
+			return
+		}
+		p, done := d.progress.snapshot(time.Now())
+		if d.progress.counting() {
+			if done {
+				// couldn't be counted
+				return
+			}
+			continue
+		}
+		d.recordProgress(p)
+		if done {
+			d.log().WithField("processed", p.Processed).Info("Backfill complete")
+			return
+		}
+	}
+}
+
+func (d *Downloader) recordProgress(p state.Progress) {
+	fields := logrus.Fields{
+		"processed": p.Processed,
+		"remaining": p.Remaining,
+	}
+	oldest, eta := 0.0, 0.0
+	if !p.Oldest.IsZero() {
+		fields["oldest_unprocessed"] = p.Oldest.Format(time.RFC3339)
+		oldest = float64(p.Oldest.Unix())
+	}
+	if !p.ETA.IsZero() {
+		fields["eta"] = p.ETA.Format(time.RFC3339)
+		eta = float64(p.ETA.Unix())
+	}
+	d.log().WithFields(fields).Info("Backfill progress")
+
+	metrics.BackfillProcessed.WithLabelValues(d.String()).Set(float64(p.Processed))
+	metrics.BackfillRemaining.WithLabelValues(d.String()).Set(float64(p.Remaining))
+	metrics.BackfillOldest.WithLabelValues(d.String()).Set(oldest)
+	metrics.BackfillETA.WithLabelValues(d.String()).Set(eta)
+
+	if progresser, ok := d.Stater.(state.Progresser); ok {
+		if err := progresser.SetProgress(d.String(), p); err != nil {
+			d.log().WithField("error", err).Error("Could not record backfill progress")
+		}
+	}
+}
+
+// reportTotalProgress logs the progress across every downloader every
+// interval, while any of them is still backfilling.
+func reportTotalProgress(interval time.Duration) {
+	for range time.Tick(interval) {
+		total, backfilling := totalProgress(time.Now())
+		if backfilling == 0 {
+			continue
+		}
+		fields := logrus.Fields{
+			"backfilling": backfilling,
+			"processed":   total.Processed,
+			"remaining":   total.Remaining,
+		}
+		if !total.Oldest.IsZero() {
+			fields["oldest_unprocessed"] = total.Oldest.Format(time.RFC3339)
+		}
+		if !total.ETA.IsZero() {
+			fields["eta"] = total.ETA.Format(time.RFC3339)
+		}
+		logrus.WithFields(fields).Info("Backfill progress in total")
+	}
+}
+
+// totalProgress adds up the progress of every downloader, the ETA being that
+// of the last to be done, and returns how many are still backfilling.
+func totalProgress(now time.Time) (state.Progress, int) {
+	allProgress.Lock()
+	defer allProgress.Unlock()
+	total := state.Progress{Time: now}
+	backfilling := 0
+	for _, dp := range allProgress.downloader {
+		p, done := dp.snapshot(now)
+		if !done && !dp.counting() {
+			backfilling++
+		}
+		total.Processed += p.Processed
+		total.Remaining += p.Remaining
+		if !p.Oldest.IsZero() && (total.Oldest.IsZero() || p.Oldest.Before(total.Oldest)) {
+			total.Oldest = p.Oldest
+		}
+		if p.ETA.After(total.ETA) {
+			total.ETA = p.ETA
+		}
+	}
+	return total, backfilling
+}
@@ -0,0 +1,47 @@
+package logbucket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestProgress(t *testing.T) {
+	start := time.Now().Add(-10 * time.Minute)
+	p := &progress{started: start, remaining: 30}
+
+	logTime := time.Date(2018, 8, 20, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		p.next(&s3.Object{
+			Key:          aws.String(fmt.Sprintf("logs/%d.log.gz", i)),
+			LastModified: aws.Time(logTime.Add(time.Duration(i) * 5 * time.Minute)),
+		})
+		p.objectProcessed()
+	}
+
+	now := start.Add(10 * time.Minute)
+	snap, done := p.snapshot(now)
+	if done || snap.Processed != 10 || snap.Remaining != 20 {
+		t.Errorf("expected 10 objects processed and 20 left, got %+v", snap)
+	}
+	if !snap.Oldest.Equal(logTime.Add(45 * time.Minute)) {
+		t.Errorf("expected the oldest left to be the last object handed along, got %s", snap.Oldest)
+	}
+	// a minute an object
+	if !snap.ETA.Equal(now.Add(20 * time.Minute)) {
+		t.Errorf("expected to be done in 20 minutes, got %s", snap.ETA)
+	}
+
+	p.finish()
+	snap, done = p.snapshot(now)
+	if !done || snap.Remaining != 0 || !snap.Oldest.IsZero() || !snap.ETA.IsZero() {
+		t.Errorf("expected nothing left once the backfill has been listed, got %+v", snap)
+	}
+	p.objectProcessed()
+	if snap, _ := p.snapshot(now); snap.Processed != 10 {
+		t.Errorf("expected live objects not to count as backfill, got %d processed", snap.Processed)
+	}
+}
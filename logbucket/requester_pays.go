@@ -0,0 +1,36 @@
+package logbucket
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const requesterPaysHandler = "honeyaws.RequesterPays"
+
+// requesterPaysOperations are the S3 calls made on the objects in log buckets,
+// which are refused for requester pays buckets unless the requester agrees to
+// pay for them.
+var requesterPaysOperations = map[string]bool{
+	"ListObjects":   true,
+	"ListObjectsV2": true,
+	"GetObject":     true,
+	"HeadObject":    true,
+}
+
+// AddRequesterPays has the listing and downloading of objects with the
+// session, and the sessions copied from it afterwards, set RequestPayer, for
+// --requester-pays buckets. The downloads are then charged to the agent's
+// account rather than the bucket owner's.
+func AddRequesterPays(sess *session.Session) {
+	// added once, even if the session is shared
+	sess.Handlers.Build.Remove(request.NamedHandler{Name: requesterPaysHandler})
+	sess.Handlers.Build.PushBackNamed(request.NamedHandler{
+		Name: requesterPaysHandler,
+		Fn: func(r *request.Request) {
+			if r.ClientInfo.ServiceName == s3.ServiceName && requesterPaysOperations[r.Operation.Name] {
+				r.HTTPRequest.Header.Set("X-Amz-Request-Payer", s3.RequestPayerRequester)
+			}
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package logbucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestAddRequesterPays(t *testing.T) {
+	payers := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := r.Method
+		if _, ok := r.URL.Query()["policy"]; ok {
+			op = "policy"
+		}
+		payers[op] = r.Header.Get("X-Amz-Request-Payer")
+		switch op {
+		case "policy":
+			w.Write([]byte("{}"))
+		case http.MethodGet:
+			w.Write([]byte("<ListBucketResult><IsTruncated>false</IsTruncated></ListBucketResult>"))
+		}
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	AddRequesterPays(sess)
+	// added once, however many times it's added
+	AddRequesterPays(sess)
+	svc := s3.New(sess.Copy())
+
+	if _, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("logs")}); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if _, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("logs"), Key: aws.String("key")}); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if _, err := svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String("logs")}); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	if payers[http.MethodGet] != "requester" || payers[http.MethodHead] != "requester" {
+		t.Errorf("Expected listing and heads to be paid for by the requester, got %v", payers)
+	}
+	if payers["policy"] != "" {
+		t.Errorf("Expected the bucket policy call to be left alone, got %q", payers["policy"])
+	}
+}
@@ -0,0 +1,149 @@
+package logbucket
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Objects written more recently than this are live tailing rather than
+// backfill, and are ingested even while backfill is paused.
+const liveWindow = time.Hour
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type pauseWindow struct {
+	days       [7]bool
+	start, end int // minutes into the day
+}
+
+// Schedule is when backfill is paused, e.g. during business hours so that
+// heavy historical ingestion doesn't compete with production traffic, as
+// given by --backfill_pause. A nil Schedule never pauses.
+type Schedule struct {
+	windows []pauseWindow
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, spec := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.ToLower(spec), "-", 2)
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return days, fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// ParseSchedule parses --backfill_pause windows of the form
+// [days ]HH:MM-HH:MM in local time, e.g. "Mon-Fri 09:00-17:00" or
+// "22:00-06:00" for every night. Windows ending before they start carry over
+// into the next day.
+func ParseSchedule(opt []string) (*Schedule, error) {
+	if len(opt) == 0 {
+		return nil, nil
+	}
+
+	s := &Schedule{}
+	for _, o := range opt {
+		w := pauseWindow{days: [7]bool{true, true, true, true, true, true, true}}
+		fields := strings.Fields(o)
+		if len(fields) == 2 {
+			days, err := parseDays(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid --backfill_pause %q: %s", o, err)
+			}
+			w.days = days
+			fields = fields[1:]
+		}
+
+		clocks := []string{}
+		if len(fields) == 1 {
+			clocks = strings.SplitN(fields[0], "-", 2)
+		}
+		if len(clocks) != 2 {
+			return nil, fmt.Errorf("Invalid --backfill_pause %q, expected [days ]HH:MM-HH:MM", o)
+		}
+		var err error
+		if w.start, err = parseClock(clocks[0]); err != nil {
+			return nil, fmt.Errorf("Invalid --backfill_pause %q: %s", o, err)
+		}
+		if w.end, err = parseClock(clocks[1]); err != nil {
+			return nil, fmt.Errorf("Invalid --backfill_pause %q: %s", o, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// Paused reports whether backfill is paused at the given time.
+func (s *Schedule) Paused(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	yesterday := (t.Weekday() + 6) % 7
+	for _, w := range s.windows {
+		if w.start <= w.end {
+			if w.days[t.Weekday()] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		if (w.days[t.Weekday()] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBackfill reports whether an object is old enough to be held back while
+// backfill is paused.
+func (s *Schedule) isBackfill(obj time.Time, now time.Time) bool {
+	return s.Paused(now) && now.Sub(obj) > liveWindow
+}
+
+// Wait blocks for as long as backfill is paused.
+func (s *Schedule) Wait() {
+	if !s.Paused(time.Now()) {
+		return
+	}
+	logrus.Info("Backfill paused by --backfill_pause")
+	for s.Paused(time.Now()) {
+		time.Sleep(time.Minute)
+	}
+	logrus.Info("Backfill resumed")
+}
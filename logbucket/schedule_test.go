@@ -0,0 +1,55 @@
+package logbucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	s, err := ParseSchedule([]string{"Mon-Fri 09:00-17:00", "Fri,Sat 22:00-06:00"})
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	testCases := []struct {
+		t        string
+		expected bool
+	}{
+		{"2021-06-07 10:00", true},  // Monday
+		{"2021-06-07 08:59", false}, // Monday
+		{"2021-06-07 17:00", false}, // Monday
+		{"2021-06-05 10:00", false}, // Saturday
+		{"2021-06-04 23:00", true},  // Friday night
+		{"2021-06-05 05:59", true},  // Saturday morning, Friday's window
+		{"2021-06-06 05:59", true},  // Sunday morning, Saturday's window
+		{"2021-06-07 05:59", false}, // Monday morning
+	}
+	for _, tc := range testCases {
+		now, err := time.ParseInLocation("2006-01-02 15:04", tc.t, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paused := s.Paused(now); paused != tc.expected {
+			t.Errorf("Expected paused to be %v at %s, got %v", tc.expected, tc.t, paused)
+		}
+	}
+
+	monday, _ := time.ParseInLocation("2006-01-02 15:04", "2021-06-07 10:00", time.Local)
+	if s.isBackfill(monday.Add(-time.Minute), monday) {
+		t.Error("Expected live objects not to be held back")
+	}
+	if !s.isBackfill(monday.Add(-2*time.Hour), monday) {
+		t.Error("Expected old objects to be held back")
+	}
+
+	var never *Schedule
+	if never.Paused(monday) {
+		t.Error("Expected nil schedule to never pause")
+	}
+
+	for _, bad := range []string{"09:00", "Mon-Fri", "Someday 09:00-17:00", "Mon 9-17"} {
+		if _, err := ParseSchedule([]string{bad}); err == nil {
+			t.Errorf("Expected err for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package logbucket
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// Leases are named for what they're for, so that the instances' own leases
+// can be counted apart from those of the downloaders.
+const (
+	memberLeasePrefix = "member:"
+	shardLeasePrefix  = "shard:"
+)
+
+// shardPoll is how often a downloader without its lease checks whether it has
+// been given it.
+const shardPoll = 5 * time.Second
+
+// Shard divides the downloaders between the instances sharing the state, for
+// --shard: each instance holds leases on its fair share of them, and only
+// lists the buckets of those. Each instance holds a lease of its own too, for
+// the others to count it by, and the leases of an instance which dies run out
+// for the others to take them over.
+type Shard struct {
+	state.Leaser
+	Owner         string
+	LeaseDuration time.Duration
+
+	lock  sync.Mutex
+	names map[string]bool
+
+	// held has the leases this instance holds, and when each was last
+	// renewed.
+	held map[string]time.Time
+
+	// members is how many instances there were at the last count.
+	members int
+
+	// claimLock keeps Release from racing with claiming leases.
+	claimLock sync.Mutex
+	released  bool
+}
+
+// NewShard returns a Shard holding leases as owner, or as the hostname and
+// process id if it's empty.
+func NewShard(leaser state.Leaser, owner string, leaseDuration time.Duration) *Shard {
+	if owner == "" {
+		hostname, _ := os.Hostname()
+		owner = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return &Shard{
+		Leaser:        leaser,
+		Owner:         owner,
+		LeaseDuration: leaseDuration,
+		names:         make(map[string]bool),
+		held:          make(map[string]time.Time),
+		members:       1,
+	}
+}
+
+func (s *Shard) log() *logrus.Entry {
+	return logrus.WithField("owner", s.Owner)
+}
+
+// Add has the shard hold a lease on the name, if it's this instance's to
+// hold.
+func (s *Shard) Add(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.names[name] = true
+}
+
+// Remove drops the name from the shard, e.g. once the load balancer is gone.
+// Its lease is released the next time leases are claimed.
+func (s *Shard) Remove(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.names, name)
+}
+
+// Holds reports whether this instance holds the name's lease.
+func (s *Shard) Holds(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.held[name]
+	return ok
+}
+
+// Run takes and renews leases until Release, three times each lease duration
+// so that a renewal failing now and then doesn't lose them.
+func (s *Shard) Run() {
+	t := time.NewTicker(s.LeaseDuration / 3)
+	defer t.Stop()
+	for ; ; <-t.C {
+		released, err := s.claim(time.Now())
+		if released {
+			return
+		}
+		if err != nil {
+			s.log().WithField("error", err).Error("Could not claim the shard's leases")
+		}
+	}
+}
+
+// share is how many of the names each of the members should hold.
+func share(names, members int) int {
+	if members < 1 {
+		members = 1
+	}
+	return (names + members - 1) / members
+}
+
+// claim renews this instance's leases, releasing those which are more than
+// its share, and takes unheld ones until it has its share. It returns whether
+// the shard has been released instead.
+func (s *Shard) claim(now time.Time) (bool, error) {
+	s.claimLock.Lock()
+	defer s.claimLock.Unlock()
+	if s.released {
+		return true, nil
+	}
+
+	until := now.Add(s.LeaseDuration)
+	// Failing to count the instances, the leases held are still renewed
+	// as if there were as many as last time.
+	members, err := s.countMembers(now, until)
+	if err != nil {
+		members = s.members
+	}
+	s.members = members
+
+	s.lock.Lock()
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	wasHeld := make(map[string]time.Time, len(s.held))
+	for name, renewed := range s.held {
+		wasHeld[name] = renewed
+	}
+	s.lock.Unlock()
+
+	// Each instance prefers different names, so they don't all contend
+	// for the same ones.
+	sort.Slice(names, func(i, j int) bool {
+		return s.preference(names[i]) < s.preference(names[j])
+	})
+	want := share(len(names), members)
+
+	held := make(map[string]time.Time)
+	for _, name := range names {
+		renewed, ok := wasHeld[name]
+		if !ok || len(held) >= want {
+			continue
+		}
+		delete(wasHeld, name)
+		ok, renewErr := s.Lease(shardLeasePrefix+name, s.Owner, until)
+		switch {
+		case renewErr != nil && now.Sub(renewed) < s.LeaseDuration:
+			// It's still ours until it runs out.
+			s.log().WithFields(logrus.Fields{"name": name, "error": renewErr}).Warn("Could not renew lease")
+			held[name] = renewed
+		case renewErr != nil:
+			s.log().WithFields(logrus.Fields{"name": name, "error": renewErr}).Error("Could not renew lease before it ran out")
+		case ok:
+			held[name] = now
+		default:
+			s.log().WithField("name", name).Warn("Lost lease to another instance")
+		}
+	}
+	// Those left are more than our share, or gone.
+	for name := range wasHeld {
+		if err := s.ReleaseLease(shardLeasePrefix+name, s.Owner); err != nil {
+			s.log().WithFields(logrus.Fields{"name": name, "error": err}).Warn("Could not release lease")
+			continue
+		}
+		s.log().WithField("name", name).Info("Released lease")
+	}
+	for _, name := range names {
+		if _, ok := held[name]; ok || len(held) >= want {
+			continue
+		}
+		ok, takeErr := s.Lease(shardLeasePrefix+name, s.Owner, until)
+		if takeErr != nil {
+			s.log().WithFields(logrus.Fields{"name": name, "error": takeErr}).Warn("Could not take lease")
+			continue
+		}
+		if ok {
+			s.log().WithField("name", name).Info("Took lease")
+			held[name] = now
+		}
+	}
+
+	s.lock.Lock()
+	s.held = held
+	s.lock.Unlock()
+	return false, err
+}
+
+// countMembers renews this instance's own lease and counts the instances
+// whose leases haven't run out.
+func (s *Shard) countMembers(now, until time.Time) (int, error) {
+	if _, err := s.Lease(memberLeasePrefix+s.Owner, s.Owner, until); err != nil {
+		return 0, err
+	}
+	leases, err := s.Leases(memberLeasePrefix)
+	if err != nil {
+		return 0, err
+	}
+	// The index may not have our own lease in it yet.
+	members := 1
+	for name, renewed := range leases {
+		if name != memberLeasePrefix+s.Owner && now.Sub(renewed) < s.LeaseDuration {
+			members++
+		}
+	}
+	return members, nil
+}
+
+func (s *Shard) preference(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s.Owner + "\x00" + name))
+	return h.Sum32()
+}
+
+// Release stops renewing the leases and gives them up, for the other
+// instances to take over straight away, e.g. when shutting down. It does
+// nothing on a nil Shard.
+func (s *Shard) Release() {
+	if s == nil {
+		return
+	}
+	s.claimLock.Lock()
+	defer s.claimLock.Unlock()
+	s.released = true
+
+	s.lock.Lock()
+	held := s.held
+	s.held = make(map[string]time.Time)
+	s.lock.Unlock()
+	for name := range held {
+		if err := s.ReleaseLease(shardLeasePrefix+name, s.Owner); err != nil {
+			s.log().WithFields(logrus.Fields{"name": name, "error": err}).Warn("Could not release lease")
+		}
+	}
+	if err := s.ReleaseLease(memberLeasePrefix+s.Owner, s.Owner); err != nil {
+		s.log().WithField("error", err).Warn("Could not release lease")
+	}
+}
@@ -0,0 +1,161 @@
+package logbucket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeaser keeps leases in memory as DynamoDBStater does in its table.
+type fakeLeaser struct {
+	sync.Mutex
+	owners  map[string]string
+	until   map[string]time.Time
+	renewed map[string]time.Time
+	now     time.Time
+}
+
+func newFakeLeaser() *fakeLeaser {
+	return &fakeLeaser{
+		owners:  make(map[string]string),
+		until:   make(map[string]time.Time),
+		renewed: make(map[string]time.Time),
+		now:     time.Now(),
+	}
+}
+
+func (f *fakeLeaser) Lease(name, owner string, until time.Time) (bool, error) {
+	f.Lock()
+	defer f.Unlock()
+	if held, ok := f.owners[name]; ok && held != owner && !f.until[name].Before(f.now) {
+		return false, nil
+	}
+	f.owners[name] = owner
+	f.until[name] = until
+	f.renewed[name] = f.now
+	return true, nil
+}
+
+func (f *fakeLeaser) ReleaseLease(name, owner string) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.owners[name] == owner {
+		delete(f.owners, name)
+		delete(f.until, name)
+		delete(f.renewed, name)
+	}
+	return nil
+}
+
+func (f *fakeLeaser) Leases(prefix string) (map[string]time.Time, error) {
+	f.Lock()
+	defer f.Unlock()
+	leases := make(map[string]time.Time)
+	for name, renewed := range f.renewed {
+		if len(name) >= len(prefix) && name[:len(prefix)] == prefix {
+			leases[name] = renewed
+		}
+	}
+	return leases, nil
+}
+
+func (f *fakeLeaser) advance(d time.Duration) time.Time {
+	f.Lock()
+	defer f.Unlock()
+	f.now = f.now.Add(d)
+	return f.now
+}
+
+func held(s *Shard, names []string) int {
+	n := 0
+	for _, name := range names {
+		if s.Holds(name) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestShard(t *testing.T) {
+	leaser := newFakeLeaser()
+	a := NewShard(leaser, "a", time.Minute)
+	b := NewShard(leaser, "b", time.Minute)
+	names := []string{"bucket/lb-1", "bucket/lb-2", "bucket/lb-3", "bucket/lb-4"}
+	for _, name := range names {
+		a.Add(name)
+		b.Add(name)
+	}
+
+	// a starts first and takes everything, then hands half over once b
+	// has joined
+	a.claim(leaser.now)
+	if n := held(a, names); n != 4 {
+		t.Fatalf("expected a to hold all 4 leases alone, got %d", n)
+	}
+	b.claim(leaser.now)
+	now := leaser.advance(time.Second)
+	a.claim(now)
+	b.claim(now)
+	if na, nb := held(a, names), held(b, names); na != 2 || nb != 2 {
+		t.Fatalf("expected the leases to be split 2/2, got %d/%d", na, nb)
+	}
+	for _, name := range names {
+		if a.Holds(name) == b.Holds(name) {
+			t.Errorf("expected %s to be held by exactly one of a and b", name)
+		}
+	}
+
+	// releasing hands them over straight away
+	b.Release()
+	if released, _ := b.claim(now); !released {
+		t.Error("expected b not to claim leases once released")
+	}
+	now = leaser.advance(time.Second)
+	a.claim(now)
+	if n := held(a, names); n != 4 {
+		t.Fatalf("expected a to take over b's leases, got %d", n)
+	}
+}
+
+func TestShardExpiry(t *testing.T) {
+	leaser := newFakeLeaser()
+	a := NewShard(leaser, "a", time.Minute)
+	b := NewShard(leaser, "b", time.Minute)
+	names := []string{"bucket/lb-1", "bucket/lb-2"}
+	for _, name := range names {
+		a.Add(name)
+		b.Add(name)
+	}
+	a.claim(leaser.now)
+	b.claim(leaser.now)
+	now := leaser.advance(time.Second)
+	a.claim(now)
+	b.claim(now)
+	if na, nb := held(a, names), held(b, names); na != 1 || nb != 1 {
+		t.Fatalf("expected the leases to be split 1/1, got %d/%d", na, nb)
+	}
+
+	// b dies without releasing its leases, which a can't take until they
+	// run out
+	now = leaser.advance(30 * time.Second)
+	a.claim(now)
+	if n := held(a, names); n != 1 {
+		t.Fatalf("expected a not to take b's lease before it runs out, got %d", n)
+	}
+	now = leaser.advance(time.Minute)
+	a.claim(now)
+	a.claim(now)
+	if n := held(a, names); n != 2 {
+		t.Fatalf("expected a to take over b's expired lease, got %d", n)
+	}
+
+	// names which are removed are released
+	a.Remove("bucket/lb-2")
+	a.claim(now)
+	if a.Holds("bucket/lb-2") {
+		t.Error("expected the removed name's lease to be released")
+	}
+	if _, ok := leaser.owners[shardLeasePrefix+"bucket/lb-2"]; ok {
+		t.Error("expected the removed name's lease to be given up")
+	}
+}
@@ -0,0 +1,199 @@
+package logbucket
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+)
+
+// S3 event notification, see
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html
+type s3EventNotification struct {
+	Records []s3EventRecord `json:"Records"`
+}
+
+type s3EventRecord struct {
+	EventName string    `json:"eventName"`
+	EventTime time.Time `json:"eventTime"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key  string `json:"key"`
+			Size int64  `json:"size"`
+			ETag string `json:"eTag,omitempty"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// Notifications fanned out via SNS before landing in SQS are wrapped in an
+// envelope with the original notification in "Message".
+type snsEnvelope struct {
+	Message string `json:"Message"`
+}
+
+// SQSListener consumes S3 ObjectCreated notifications from an SQS queue and
+// hands new objects to the Downloader responsible for them, so that logs are
+// ingested as soon as they land instead of on the next bucket poll.
+type SQSListener struct {
+	*sync.Mutex
+	Sess        *session.Session
+	QueueURL    string
+	downloaders []*Downloader
+}
+
+func NewSQSListener(sess *session.Session, queueURL string) *SQSListener {
+	return &SQSListener{
+		Mutex:    &sync.Mutex{},
+		Sess:     sess,
+		QueueURL: queueURL,
+	}
+}
+
+// Add registers the downloader for notifications. The downloader will still
+// list the bucket once on startup to pick up the backfill, but will rely on
+// the listener for anything delivered after that.
+func (l *SQSListener) Add(d *Downloader) {
+	l.Lock()
+	defer l.Unlock()
+	d.BackfillOnly = true
+	l.downloaders = append(l.downloaders, d)
+}
+
+func parseS3EventNotification(body string) ([]s3EventRecord, error) {
+	var notification s3EventNotification
+	if err := json.Unmarshal([]byte(body), &notification); err != nil {
+		return nil, err
+	}
+	if len(notification.Records) == 0 {
+		var envelope snsEnvelope
+		if err := json.Unmarshal([]byte(body), &envelope); err == nil && envelope.Message != "" {
+			return parseS3EventNotification(envelope.Message)
+		}
+	}
+	return notification.Records, nil
+}
+
+// handleRecord hands the object the record is of to the downloader ingesting
+// it, returning whether the record was dealt with, so that the message can be
+// left on the queue for its redrive policy otherwise.
+func (l *SQSListener) handleRecord(rec s3EventRecord) bool {
+	if !strings.HasPrefix(rec.EventName, "ObjectCreated:") {
+		return true
+	}
+
+	// Keys in event notifications are URL encoded.
+	key, err := url.QueryUnescape(rec.S3.Object.Key)
+	if err != nil {
+		logrus.WithField("key", rec.S3.Object.Key).Error("Could not decode object key from S3 event notification")
+		return false
+	}
+
+	obj := &s3.Object{
+		Key:          aws.String(key),
+		Size:         aws.Int64(rec.S3.Object.Size),
+		LastModified: aws.Time(rec.EventTime),
+	}
+	if rec.S3.Object.ETag != "" {
+		obj.ETag = aws.String(rec.S3.Object.ETag)
+	}
+
+	d := l.downloaderFor(rec.S3.Bucket.Name, key, rec.EventTime)
+	if d == nil {
+		logrus.WithFields(logrus.Fields{
+			"bucket": rec.S3.Bucket.Name,
+			"key":    key,
+		}).Warn("No entity is ingesting this object, leaving the notification on the queue")
+		return false
+	}
+
+	processedObjects, err := d.ProcessedObjects()
+	if err != nil {
+		logrus.Error(err)
+	}
+	// Notified objects are new, or were already found to be within the
+	// backfill window by a lister. Handing them over blocks until the
+	// downloader takes them, so it's done without holding the lock.
+	d.backfillObject(processedObjects, obj)
+	return true
+}
+
+// downloaderFor returns the downloader the object belongs to, going by its
+// bucket and prefix for the day of its logs, or either side of it for objects
+// delivered around midnight, or nil if none of them do.
+func (l *SQSListener) downloaderFor(bucket, key string, eventTime time.Time) *Downloader {
+	l.Lock()
+	defer l.Unlock()
+	for _, d := range l.downloaders {
+		if !d.stopped() && d.Bucket() == bucket && d.owns(key, eventTime) {
+			return d
+		}
+	}
+	return nil
+}
+
+// Listen long polls the queue forever.
+func (l *SQSListener) Listen() {
+	sqsSvc := sqs.New(l.Sess)
+
+	logrus.WithField("queue", l.QueueURL).Info("Listening for S3 event notifications")
+
+	for {
+		resp, err := sqsSvc.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(l.QueueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"queue": l.QueueURL,
+				"error": err,
+			}).Error("Error receiving messages from SQS")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, msg := range resp.Messages {
+			// Messages which can't be parsed, or that have objects
+			// no downloader is ingesting in them, are left for the
+			// queue's redrive policy rather than deleted.
+			records, err := parseS3EventNotification(aws.StringValue(msg.Body))
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"message_id": aws.StringValue(msg.MessageId),
+					"error":      err,
+				}).Error("Could not parse S3 event notification")
+				continue
+			}
+
+			handled := true
+			for _, rec := range records {
+				if !l.handleRecord(rec) {
+					handled = false
+				}
+			}
+			if !handled {
+				continue
+			}
+
+			if _, err := sqsSvc.DeleteMessage(&sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(l.QueueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			}); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"message_id": aws.StringValue(msg.MessageId),
+					"error":      err,
+				}).Error("Error deleting message from SQS")
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package logbucket
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestParseS3EventNotification(t *testing.T) {
+	notification := `{"Records":[{"eventVersion":"2.1","eventSource":"aws:s3","eventTime":"2018-08-20T23:05:00.000Z","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"mylogs"},"object":{"key":"AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_app.service1.abc_20180820T2305Z_10.0.0.1_xyz.log.gz","size":1024}}}]}`
+
+	testCases := []struct {
+		body string
+	}{
+		{notification},
+		// same notification, delivered through an SNS topic
+		{`{"Type":"Notification","Message":` + strconv.Quote(notification) + `}`},
+	}
+
+	for _, tc := range testCases {
+		records, err := parseS3EventNotification(tc.body)
+		if err != nil {
+			t.Fatal("Shouldn't have err but did: ", err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("expected 1 record, got %d", len(records))
+		}
+		rec := records[0]
+		if rec.EventName != "ObjectCreated:Put" || rec.S3.Bucket.Name != "mylogs" || rec.S3.Object.Size != 1024 {
+			t.Errorf("record did not match expected: %+v", rec)
+		}
+	}
+}
+
+func newSQSTestRecord(key string, eventTime time.Time) s3EventRecord {
+	var rec s3EventRecord
+	rec.EventName = "ObjectCreated:Put"
+	rec.EventTime = eventTime
+	rec.S3.Bucket.Name = "mylogs"
+	rec.S3.Object.Key = key
+	rec.S3.Object.Size = 1024
+	return rec
+}
+
+func TestSQSListenerHandleRecord(t *testing.T) {
+	l := NewSQSListener(nil, "queue")
+	d := NewDownloader(nil, state.NewMemoryStater(1), &ELBDownloader{
+		AccountID:  "12345",
+		Region:     "us-east-1",
+		BucketName: "mylogs",
+		LBName:     "service1",
+	}, 1)
+	l.Add(d)
+
+	// the last logs of the 20th, delivered just after midnight
+	key := "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_service1_20180820T2355Z_10.0.0.1_xyz.log"
+	handled := make(chan bool)
+	go func() {
+		handled <- l.handleRecord(newSQSTestRecord(key, time.Date(2018, 8, 21, 0, 2, 0, 0, time.UTC)))
+	}()
+
+	// the listener isn't held up while the downloader is busy
+	added := make(chan struct{})
+	go func() {
+		l.Add(NewDownloader(nil, state.NewMemoryStater(1), &ELBDownloader{BucketName: "otherlogs"}, 1))
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock to be released while handing over the object")
+	}
+
+	select {
+	case obj := <-d.ObjectsToDownload:
+		if *obj.Key != key {
+			t.Errorf("unexpected object %s", *obj.Key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the object to be handed to the downloader")
+	}
+	if !<-handled {
+		t.Error("expected the record to be handled")
+	}
+
+	// objects of other load balancers are left on the queue
+	other := "AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_service2_20180820T2355Z_10.0.0.1_xyz.log"
+	if l.handleRecord(newSQSTestRecord(other, time.Date(2018, 8, 21, 0, 2, 0, 0, time.UTC))) {
+		t.Error("expected the record of an object no downloader is ingesting not to be handled")
+	}
+	// as are those whose keys can't be decoded
+	if l.handleRecord(newSQSTestRecord("AWSLogs/%zz", time.Now())) {
+		t.Error("expected the record with an invalid key not to be handled")
+	}
+	// notifications of anything else have nothing to do
+	rec := newSQSTestRecord(key, time.Now())
+	rec.EventName = "ObjectRemoved:Delete"
+	if !l.handleRecord(rec) {
+		t.Error("expected other notifications to be handled")
+	}
+}
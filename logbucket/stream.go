@@ -0,0 +1,207 @@
+package logbucket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// partRetries is how many times getting one of the ranges after the first is
+// tried, since it's too late by then for the object to be retried as a whole.
+const partRetries = 3
+
+// streamObject gets the object, for its body to be parsed as it's read. Its
+// first range (of --download-part-size) is requested here, so errors such as
+// access being denied are explained and retried as for downloads. Objects
+// bigger than that have their other ranges got, several at once, ahead of
+// being read, and read in order, so the connection dropping part way through
+// is only found by the publisher, as a StreamError.
+func streamObject(sess *session.Session, bucket, key, kmsKeyARN string) (state.DownloadedObject, error) {
+	svc := s3.New(sess)
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange(0, partSize)),
+	})
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusRequestedRangeNotSatisfiable {
+		// empty objects don't have a first byte to start the range at
+		out, err = svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+	}
+	if err != nil {
+		if missing, ok := notFound(key, err).(*MissingObjectError); ok {
+			return state.DownloadedObject{}, missing
+		}
+		err = explainAccessDenied(svc, bucket, key, kmsKeyARN, err)
+		return state.DownloadedObject{}, fmt.Errorf("Error getting object: %s", err)
+	}
+
+	size, ok := totalSize(aws.StringValue(out.ContentRange))
+	if !ok || size <= partSize {
+		return state.DownloadedObject{Object: key, Body: out.Body}, nil
+	}
+	return state.DownloadedObject{
+		Object: key,
+		Body:   newRangeReader(svc, bucket, key, aws.StringValue(out.ETag), size, out.Body),
+	}, nil
+}
+
+// byteRange is the Range header for the part of an object from offset.
+func byteRange(offset, size int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)
+}
+
+// totalSize returns the size of the object from the Content-Range of a
+// ranged GetObject, e.g. "bytes 0-5242879/12345678".
+func totalSize(contentRange string) (int64, bool) {
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	return size, err == nil
+}
+
+type part struct {
+	data []byte
+	err  error
+}
+
+// rangeReader reads an object a range at a time, in order, while the next
+// ranges (up to --download-part-concurrency of them) are got in the
+// background, so an object is held in memory a few ranges at a time rather
+// than downloaded to disk.
+type rangeReader struct {
+	svc               s3iface.S3API
+	bucket, key, etag string
+	size              int64
+
+	current io.ReadCloser
+	parts   chan chan part
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// newRangeReader returns the reader of the object, the first range of which
+// is body.
+func newRangeReader(svc s3iface.S3API, bucket, key, etag string, size int64, body io.ReadCloser) *rangeReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &rangeReader{
+		svc:     svc,
+		bucket:  bucket,
+		key:     key,
+		etag:    etag,
+		size:    size,
+		current: body,
+		// the range being read is one of those got at once
+		parts:  make(chan chan part, partConcurrency-1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go r.getParts()
+	return r
+}
+
+// getParts starts getting each of the ranges after the first, queueing them
+// to be read in order, and waiting for one to be read before starting on
+// another once enough are queued.
+func (r *rangeReader) getParts() {
+	defer close(r.parts)
+	for offset := partSize; offset < r.size; offset += partSize {
+		size := partSize
+		if offset+size > r.size {
+			size = r.size - offset
+		}
+		ch := make(chan part, 1)
+		select {
+		case r.parts <- ch:
+		case <-r.ctx.Done():
+			return
+		}
+		go func(offset, size int64) {
+			ch <- r.getPart(offset, size)
+		}(offset, size)
+	}
+}
+
+func (r *rangeReader) getPart(offset, size int64) part {
+	var err error
+	for i := 0; i < partRetries; i++ {
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(r.bucket),
+			Key:    aws.String(r.key),
+			Range:  aws.String(byteRange(offset, size)),
+		}
+		if r.etag != "" {
+			// not a range of the object having since been replaced
+			input.IfMatch = aws.String(r.etag)
+		}
+		var out *s3.GetObjectOutput
+		out, err = r.svc.GetObjectWithContext(r.ctx, input)
+		if err != nil {
+			if r.ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		var data []byte
+		data, err = ioutil.ReadAll(out.Body)
+		out.Body.Close()
+		if err == nil && int64(len(data)) != size {
+			err = fmt.Errorf("got %d bytes of the range at %d, expected %d", len(data), offset, size)
+		}
+		if err == nil {
+			return part{data: data}
+		}
+	}
+	return part{err: err}
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.current.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		ch, ok := <-r.parts
+		if !ok {
+			return 0, io.EOF
+		}
+		next := <-ch
+		if next.err != nil {
+			return 0, next.err
+		}
+		r.current.Close()
+		r.current = ioutil.NopCloser(bytes.NewReader(next.data))
+	}
+}
+
+// Close stops getting the ranges not yet got.
+func (r *rangeReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		r.cancel()
+		err = r.current.Close()
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+package logbucket
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestStreamObject(t *testing.T) {
+	var body []byte
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&gets, 1)
+		w.Header().Set("ETag", `"abc123"`)
+		// as S3 does, unlike ServeContent
+		if len(body) == 0 && r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			w.Write([]byte(`<Error><Code>InvalidRange</Code><Message>The requested range is not satisfiable</Message></Error>`))
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	defer func(size int64, concurrency int) {
+		partSize, partConcurrency = size, concurrency
+	}(partSize, partConcurrency)
+	if err := SetDownloadParts(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		size int
+		gets int32
+	}{
+		{0, 2},
+		{100, 1},
+		{3*1024*1024 + 100, 4},
+	}
+	for _, tc := range testCases {
+		body = bytes.Repeat([]byte("a line of log\n"), tc.size/14+1)[:tc.size]
+		gets = 0
+		obj, err := streamObject(sess, "logs", "E123.2018-08-20-12.abcd.gz", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := Open(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(streamed, body) {
+			t.Errorf("expected the %d byte object to be streamed in order, got %d bytes", tc.size, len(streamed))
+		}
+		if gets != tc.gets {
+			t.Errorf("expected the %d byte object to take %d requests, got %d", tc.size, tc.gets, gets)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package logbucket
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/honeycombio/honeyaws/health"
+	"github.com/sirupsen/logrus"
+)
+
+// Failed units are restarted after a backoff which doubles with every
+// failure, up to maxBackoff, and goes back to minBackoff once a unit has run
+// for longer than that without failing.
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// runRecovered runs fn, turning a panic into an error.
+func runRecovered(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// supervise runs fn as the named unit until it returns nil or the downloader
+// is stopped, restarting it with backoff whenever it panics or returns an
+// error. That way a load balancer whose bucket can't be listed, or whose logs
+// trip a bug, doesn't take down ingestion of the others. The unit's status is
+// reported on the health server's /status.
+func (d *Downloader) supervise(name string, fn func() error) {
+	defer health.ClearStatus(name)
+
+	backoff := minBackoff
+	status := health.Status{State: health.StateRunning, Since: time.Now()}
+	for {
+		health.SetStatus(name, status)
+		err := runRecovered(fn)
+		if err == nil || d.stopped() {
+			return
+		}
+		if time.Since(status.Since) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"unit":    name,
+			"error":   err,
+			"backoff": backoff,
+		}).Error("Unit failed, restarting it after backoff")
+		status = health.Status{
+			State:     health.StateBackoff,
+			Restarts:  status.Restarts + 1,
+			LastError: err.Error(),
+			Since:     time.Now(),
+		}
+		health.SetStatus(name, status)
+
+		select {
+		case <-time.After(backoff):
+		case <-d.stop:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		status.State = health.StateRunning
+		status.Since = time.Now()
+	}
+}
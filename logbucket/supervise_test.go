@@ -0,0 +1,62 @@
+package logbucket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/health"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestSupervise(t *testing.T) {
+	d := NewDownloader(nil, state.NewMemoryStater(1), &CloudFrontDownloader{DistributionID: "E123"}, 1)
+
+	// The unit panics the first time, and finishes the second.
+	runs := 0
+	backingOff := make(chan health.Status, 1)
+	done := make(chan struct{})
+	go func() {
+		d.supervise("test", func() error {
+			runs++
+			if runs == 1 {
+				panic("bad log line")
+			}
+			backingOff <- health.Statuses()["test"]
+			return nil
+		})
+		close(done)
+	}()
+
+	select {
+	case status := <-backingOff:
+		if status.State != health.StateRunning || status.Restarts != 1 || status.LastError != "panic: bad log line" {
+			t.Errorf("unexpected status after restarting: %+v", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the unit to be restarted after panicking")
+	}
+	<-done
+	if _, ok := health.Statuses()["test"]; ok {
+		t.Error("expected the status of a finished unit to be cleared")
+	}
+
+	// Stopping the downloader stops a unit backing off.
+	done = make(chan struct{})
+	go func() {
+		d.supervise("failing", func() error {
+			return errors.New("access denied")
+		})
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if status := health.Statuses()["failing"]; status.State != health.StateBackoff || status.LastError != "access denied" {
+		t.Errorf("unexpected status while backing off: %+v", status)
+	}
+	d.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected stopping the downloader to stop the unit")
+	}
+}
@@ -0,0 +1,113 @@
+package logbucket
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// TimeRange has downloaders download the objects with logs from between Start
+// and End once, for --start-time, instead of polling their buckets.
+type TimeRange struct {
+	Start, End time.Time
+
+	// Backfill, for --mode=backfill, skips the objects already processed
+	// and sets those downloaded as processed, since the state is shared
+	// with the instances tailing the logs, rather than ingesting the range
+	// again.
+	Backfill bool
+
+	// Workers is how many of each downloader's objects are downloaded at
+	// once, within the limit of its Pool. Defaults to 1.
+	Workers int
+
+	wg     sync.WaitGroup
+	lock   sync.Mutex
+	failed int
+}
+
+func NewTimeRange(start, end time.Time) *TimeRange {
+	return &TimeRange{Start: start, End: end}
+}
+
+// Wait waits for every downloader to have sent along the objects in the range,
+// returning an error if listing those of any of them failed.
+func (r *TimeRange) Wait() error {
+	r.wg.Wait()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.failed > 0 {
+		return fmt.Errorf("Could not list the objects in the time range for %d of the downloaders", r.failed)
+	}
+	return nil
+}
+
+// downloadTimeRange lists the objects in the time range, whether or not
+// they've been processed unless it's a Backfill, and downloads them, Workers
+// of them at a time.
+func (d *Downloader) downloadTimeRange() {
+	defer d.TimeRange.wg.Done()
+	// the objects found missing are checked for again before it's done
+	defer d.rechecking.Wait()
+
+	objs, err := ListWindow(d.Sess, d.ObjectDownloader, d.TimeRange.Start, d.TimeRange.End)
+	if err != nil {
+		d.log().WithField("error", err).Error("Could not list the objects in the time range")
+		d.TimeRange.lock.Lock()
+		d.TimeRange.failed++
+		d.TimeRange.lock.Unlock()
+		return
+	}
+	d.log().WithFields(logrus.Fields{
+		"objects": len(objs),
+		"start":   d.TimeRange.Start.Format(time.RFC3339),
+		"end":     d.TimeRange.End.Format(time.RFC3339),
+	}).Info("Downloading the objects in the time range")
+
+	processedObjects := map[string]time.Time{}
+	if d.TimeRange.Backfill {
+		if processedObjects, err = d.ProcessedObjects(); err != nil {
+			d.log().Error(err)
+		}
+	}
+
+	workers := d.TimeRange.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	objCh := make(chan *s3.Object)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range objCh {
+				d.downloadWithRetries(obj)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(objCh)
+
+	for _, obj := range objs {
+		if d.stopped() {
+			return
+		}
+		if d.TimeRange.Backfill {
+			if _, ok := processedObjects[*obj.Key]; ok {
+				d.log().WithField("object", *obj.Key).Debug("Already processed, skipping")
+				continue
+			}
+			// as it would be if it was listed by polling, which
+			// also keeps the instances tailing from taking it
+			if err := d.SetProcessed(*obj.Key); err != nil {
+				d.log().WithField("object", *obj.Key).Debug("Error setting state of object as processed")
+				continue
+			}
+		}
+		objCh <- obj
+	}
+}
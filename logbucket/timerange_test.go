@@ -0,0 +1,110 @@
+package logbucket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestDownloadTimeRange(t *testing.T) {
+	keys := []string{
+		"E123.2018-08-20-12.abcd.gz",
+		"E123.2018-08-20-14.abcd.gz",
+		"E123.2018-08-20-15.abcd.gz",
+		"E123.2018-08-20-17.abcd.gz",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("list-type") == "2" {
+			var contents string
+			for _, key := range keys {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+					contents += fmt.Sprintf("<Contents><Key>%s</Key><Size>4</Size><LastModified>2018-08-20T18:00:00Z</LastModified></Contents>", key)
+				}
+			}
+			w.Write([]byte("<ListBucketResult><IsTruncated>false</IsTruncated>" + contents + "</ListBucketResult>"))
+			return
+		}
+		w.Write([]byte("logs"))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	stater := state.NewMemoryStater(1)
+	// Processed objects are downloaded again all the same.
+	stater.SetProcessed("E123.2018-08-20-14.abcd.gz")
+
+	start := time.Date(2018, 8, 20, 14, 0, 0, 0, time.UTC)
+	timeRange := NewTimeRange(start, start.Add(2*time.Hour))
+	d := NewDownloader(sess, stater, NewCloudFrontDownloader("logs", "", "E123"), 1)
+	d.TimeRange = timeRange
+
+	downloads := make(chan state.DownloadedObject)
+	d.Download(downloads)
+	done := make(chan error, 1)
+	go func() {
+		done <- timeRange.Wait()
+		close(downloads)
+	}()
+
+	var downloaded []string
+	for obj := range downloads {
+		os.Remove(obj.Filename)
+		downloaded = append(downloaded, obj.Object)
+	}
+	if err := <-done; err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	sort.Strings(downloaded)
+	if len(downloaded) != 2 || downloaded[0] != keys[1] || downloaded[1] != keys[2] {
+		t.Errorf("Expected the objects for 14:00 to 16:00, got %v", downloaded)
+	}
+
+	// --mode=backfill skips those processed already, and sets the rest
+	// as processed
+	timeRange = NewTimeRange(start.Add(-2*time.Hour), start.Add(2*time.Hour))
+	timeRange.Backfill = true
+	timeRange.Workers = 2
+	d = NewDownloader(sess, stater, NewCloudFrontDownloader("logs", "", "E123"), 1)
+	d.TimeRange = timeRange
+	downloads = make(chan state.DownloadedObject)
+	d.Download(downloads)
+	go func() {
+		done <- timeRange.Wait()
+		close(downloads)
+	}()
+	downloaded = nil
+	for obj := range downloads {
+		obj.Release()
+		downloaded = append(downloaded, obj.Object)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(downloaded)
+	if len(downloaded) != 2 || downloaded[0] != keys[0] || downloaded[1] != keys[2] {
+		t.Errorf("Expected the objects for 12:00 to 16:00 not processed yet, got %v", downloaded)
+	}
+	processed, err := stater.ProcessedObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := processed[keys[2]]; !ok {
+		t.Errorf("Expected the objects backfilled to be set as processed, got %v", processed)
+	}
+}
@@ -0,0 +1,82 @@
+package logbucket
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/honeycombio/honeyaws/meta"
+)
+
+// AccessCheck is whether the objects of an ObjectDownloader could be listed
+// and got, for verify.
+type AccessCheck struct {
+	ListErr error
+	// Object is the object got, or "" if there were none in the last day
+	// to get.
+	Object string
+	GetErr error
+}
+
+// CheckAccess lists the downloader's objects from today, or yesterday if
+// there are none yet, and gets the first byte of the first one found, which
+// needs the same permissions as downloading it: s3:ListBucket, s3:GetObject
+// and, for SSE-KMS, kms:Decrypt.
+func CheckAccess(sess *session.Session, d ObjectDownloader, kmsKeyARN string) AccessCheck {
+	svc := s3.New(sess)
+	now := time.Now().UTC()
+	var check AccessCheck
+	for _, day := range []time.Time{now, now.Add(-24 * time.Hour)} {
+		out, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:  aws.String(d.Bucket()),
+			Prefix:  aws.String(d.ObjectPrefix(day)),
+			MaxKeys: aws.Int64(1),
+		})
+		if err != nil {
+			check.ListErr = fmt.Errorf("Error listing objects: %s", err)
+			return check
+		}
+		if len(out.Contents) > 0 {
+			check.Object = aws.StringValue(out.Contents[0].Key)
+			break
+		}
+	}
+	if check.Object == "" {
+		return check
+	}
+
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(d.Bucket()),
+		Key:    aws.String(check.Object),
+		Range:  aws.String(byteRange(0, 1)),
+	})
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusRequestedRangeNotSatisfiable {
+		// it's empty, but could be got
+		return check
+	}
+	if err != nil {
+		err = explainAccessDenied(svc, d.Bucket(), check.Object, kmsKeyARN, err)
+		check.GetErr = fmt.Errorf("Error getting object: %s", err)
+		return check
+	}
+	out.Body.Close()
+	return check
+}
+
+// Record adds the checks to those verify reports, for the downloader's
+// objects, as target.
+func (c AccessCheck) Record(checks *meta.Checks, target string) {
+	checks.Add("list log bucket", target, c.ListErr)
+	switch {
+	case c.ListErr != nil:
+		checks.Skip("get log object", target, "the log bucket couldn't be listed")
+	case c.Object == "":
+		checks.Skip("get log object", target, "no logs delivered in the last day")
+	default:
+		checks.Add("get log object", target, c.GetErr)
+	}
+}
@@ -0,0 +1,84 @@
+package logbucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestCheckAccess(t *testing.T) {
+	var keys []string
+	denyGet := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/denied" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		if r.URL.Path == "/mylogs" {
+			prefix := r.URL.Query().Get("prefix")
+			w.Write([]byte(`<ListBucketResult><Name>mylogs</Name>`))
+			for _, key := range keys {
+				if strings.HasPrefix(key, prefix) {
+					w.Write([]byte(`<Contents><Key>` + key + `</Key><Size>1</Size></Contents>`))
+					break
+				}
+			}
+			w.Write([]byte(`</ListBucketResult>`))
+			return
+		}
+		if denyGet {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		w.Write([]byte("a"))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	d := &ELBDownloader{
+		AccountID:  "12345",
+		Region:     "us-east-1",
+		BucketName: "mylogs",
+		LBName:     "service1",
+	}
+
+	check := CheckAccess(sess, d, "")
+	if check.ListErr != nil || check.Object != "" || check.GetErr != nil {
+		t.Errorf("expected nothing to get without objects, got %+v", check)
+	}
+
+	keys = []string{"AWSLogs/12345/elasticloadbalancing/elsewhere.log"}
+	// the first object of yesterday's, since there are none today
+	yesterday := d.ObjectPrefix(time.Now().UTC().Add(-24*time.Hour)) + "_20180820T0000Z_10.0.0.1_abc.log"
+	keys = append(keys, yesterday)
+	check = CheckAccess(sess, d, "")
+	if check.ListErr != nil || check.Object != yesterday || check.GetErr != nil {
+		t.Errorf("expected %s to be got, got %+v", yesterday, check)
+	}
+
+	denyGet = true
+	check = CheckAccess(sess, d, "")
+	if check.GetErr == nil || !strings.Contains(check.GetErr.Error(), "s3:GetObject") {
+		t.Errorf("expected getting to be denied with an explanation, got %+v", check)
+	}
+
+	d.BucketName = "denied"
+	check = CheckAccess(sess, d, "")
+	if check.ListErr == nil || check.Object != "" {
+		t.Errorf("expected listing to be denied, got %+v", check)
+	}
+}
@@ -0,0 +1,56 @@
+package logbucket
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// objectInterval is the most time the logs in an object cover before the time
+// it's named for, that of 5 minute intervals. CloudFront's hourly objects
+// aren't listed by window.
+const objectInterval = 5 * time.Minute
+
+// ListWindow lists the downloader's objects with logs for the time between
+// start and end, whether or not they've been processed.
+func ListWindow(sess *session.Session, d ObjectDownloader, start, end time.Time) ([]*s3.Object, error) {
+	s3svc := s3.New(sess, nil)
+
+	var objs []*s3.Object
+	listed := make(map[string]bool)
+	for day := start.UTC().Truncate(24 * time.Hour); day.Before(end.Add(objectInterval)); day = day.Add(24 * time.Hour) {
+		// e.g. a PrefixDownloader's prefix is the same every day
+		prefix := d.ObjectPrefix(day)
+		if listed[prefix] {
+			continue
+		}
+		listed[prefix] = true
+		err := s3svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+			Bucket: aws.String(d.Bucket()),
+			Prefix: aws.String(prefix),
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			objs = append(objs, windowObjects(page.Contents, start, end)...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// windowObjects returns the objects which may have logs for the time between
+// start and end, including the one after it, since requests are logged when
+// they complete, going by which their entries can land in the next interval.
+func windowObjects(objs []*s3.Object, start, end time.Time) []*s3.Object {
+	var inWindow []*s3.Object
+	for _, obj := range objs {
+		t := objectTime(obj)
+		if t.After(start) && !t.After(end.Add(objectInterval)) {
+			inWindow = append(inWindow, obj)
+		}
+	}
+	return inWindow
+}
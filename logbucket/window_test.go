@@ -0,0 +1,25 @@
+package logbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestWindowObjects(t *testing.T) {
+	start := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	objs := []*s3.Object{
+		backfillObject(start, 0, 1),   // 08:55-09:00
+		backfillObject(start, 5, 1),   // 09:00-09:05
+		backfillObject(start, 60, 1),  // 09:55-10:00
+		backfillObject(start, 65, 1),  // 10:00-10:05
+		backfillObject(start, 120, 1), // 10:55-11:00
+	}
+
+	inWindow := windowObjects(objs, start, end)
+	if len(inWindow) != 3 || inWindow[0] != objs[1] || inWindow[2] != objs[3] {
+		t.Errorf("expected the objects from 09:00 to 10:05, got %v", inWindow)
+	}
+}
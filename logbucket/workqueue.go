@@ -0,0 +1,103 @@
+package logbucket
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Roles for splitting listing buckets and processing objects across
+// processes, see --role.
+const (
+	RoleLister = "lister"
+	RoleWorker = "worker"
+)
+
+// Objects stay in the lister's memory long enough for the bucket listing to
+// have moved past them (see cursorSettle), after which the shared state keeps
+// them from being enqueued again once they have been processed.
+const enqueuedTTL = time.Hour
+
+// WorkQueue is used by listers to hand the objects they find over to workers
+// through an SQS queue. Messages are in the same format as S3 event
+// notifications, so workers consume them with an SQSListener just like they
+// would notifications delivered by S3 itself.
+type WorkQueue struct {
+	*sync.Mutex
+	Sess     *session.Session
+	QueueURL string
+
+	// Objects stay unprocessed until a worker gets to them, so keep
+	// track of what's been enqueued to avoid enqueueing it again on
+	// every poll.
+	enqueued map[string]time.Time
+	reaped   time.Time
+}
+
+func NewWorkQueue(sess *session.Session, queueURL string) *WorkQueue {
+	return &WorkQueue{
+		Mutex:    &sync.Mutex{},
+		Sess:     sess,
+		QueueURL: queueURL,
+		enqueued: make(map[string]time.Time),
+	}
+}
+
+func workQueueMessage(bucket string, obj *s3.Object) (string, error) {
+	var rec s3EventRecord
+	rec.EventName = "ObjectCreated:Put"
+	rec.EventTime = *obj.LastModified
+	rec.S3.Bucket.Name = bucket
+	// Keys in event notifications are URL encoded, and QueryEscape
+	// encodes spaces as "+" like S3 does, but also "/" which S3 doesn't.
+	rec.S3.Object.Key = strings.Replace(url.QueryEscape(*obj.Key), "%2F", "/", -1)
+	rec.S3.Object.Size = aws.Int64Value(obj.Size)
+	rec.S3.Object.ETag = aws.StringValue(obj.ETag)
+
+	data, err := json.Marshal(s3EventNotification{Records: []s3EventRecord{rec}})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Enqueue adds the object to the queue, unless it was enqueued recently.
+func (q *WorkQueue) Enqueue(bucket string, obj *s3.Object) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if time.Since(q.reaped) > time.Minute {
+		for k, v := range q.enqueued {
+			if time.Since(v) > enqueuedTTL {
+				delete(q.enqueued, k)
+			}
+		}
+		q.reaped = time.Now()
+	}
+	if _, ok := q.enqueued[bucket+"/"+*obj.Key]; ok {
+		return nil
+	}
+
+	body, err := workQueueMessage(bucket, obj)
+	if err != nil {
+		return err
+	}
+
+	if _, err := sqs.New(q.Sess).SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.QueueURL),
+		MessageBody: aws.String(body),
+	}); err != nil {
+		return err
+	}
+
+	q.enqueued[bucket+"/"+*obj.Key] = time.Now()
+
+	return nil
+}
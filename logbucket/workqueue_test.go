@@ -0,0 +1,43 @@
+package logbucket
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestWorkQueueMessage(t *testing.T) {
+	lastModified := time.Date(2018, 8, 20, 0, 5, 12, 0, time.UTC)
+	obj := &s3.Object{
+		Key:          aws.String("my prefix/AWSLogs/12345/elasticloadbalancing/us-east-1/2018/08/20/12345_elasticloadbalancing_us-east-1_service1_20180820T0005Z_10.0.0.1_abc.log"),
+		Size:         aws.Int64(1024),
+		LastModified: aws.Time(lastModified),
+	}
+
+	body, err := workQueueMessage("mylogs", obj)
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	// Workers must see the same thing they'd see in a notification from
+	// S3 itself
+	records, err := parseS3EventNotification(body)
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	key, err := url.QueryUnescape(rec.S3.Object.Key)
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if rec.EventName != "ObjectCreated:Put" || rec.S3.Bucket.Name != "mylogs" || key != *obj.Key ||
+		rec.S3.Object.Size != 1024 || !rec.EventTime.Equal(lastModified) {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+}
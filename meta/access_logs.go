@@ -0,0 +1,129 @@
+package meta
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// ELBAccessLogs returns the bucket and prefix the classic load balancer's
+// access logs are delivered to, and whether they're enabled.
+func ELBAccessLogs(sess *session.Session, name string) (string, string, bool, error) {
+	resp, err := elb.New(sess, nil).DescribeLoadBalancerAttributes(&elb.DescribeLoadBalancerAttributesInput{
+		LoadBalancerName: aws.String(name),
+	})
+	if err != nil {
+		return "", "", false, err
+	}
+	accessLog := resp.LoadBalancerAttributes.AccessLog
+	return aws.StringValue(accessLog.S3BucketName), aws.StringValue(accessLog.S3BucketPrefix), aws.BoolValue(accessLog.Enabled), nil
+}
+
+// ELBV2AccessLogs returns the bucket and prefix the application or network
+// load balancer's access logs are delivered to, and whether they're enabled.
+func ELBV2AccessLogs(sess *session.Session, name string) (string, string, bool, error) {
+	l, err := ELBV2Listing(sess, name)
+	return l.Bucket, l.Prefix, l.LoggingEnabled, err
+}
+
+// ELBV2ConnectionLogs returns the bucket and prefix the application load
+// balancer's connection logs are delivered to, and whether they're enabled.
+func ELBV2ConnectionLogs(sess *session.Session, name string) (string, string, bool, error) {
+	_, attrs, err := elbv2Attributes(elbv2.New(sess, nil), name)
+	if err != nil {
+		return "", "", false, err
+	}
+	return attrs["connection_logs.s3.bucket"], attrs["connection_logs.s3.prefix"], attrs["connection_logs.s3.enabled"] == "true", nil
+}
+
+// ELBV2Listing returns the application or network load balancer's scheme,
+// state and access log settings, for ls.
+func ELBV2Listing(sess *session.Session, name string) (Listing, error) {
+	l := Listing{Name: name}
+	lb, attrs, err := elbv2Attributes(elbv2.New(sess, nil), name)
+	if err != nil {
+		return l, err
+	}
+	l.Scheme = aws.StringValue(lb.Scheme)
+	if lb.State != nil {
+		l.State = aws.StringValue(lb.State.Code)
+	}
+	l.LoggingEnabled = attrs["access_logs.s3.enabled"] == "true"
+	l.Bucket = attrs["access_logs.s3.bucket"]
+	l.Prefix = attrs["access_logs.s3.prefix"]
+	return l, nil
+}
+
+// ELBV2ID returns the ID of the application or network load balancer, e.g.
+// 1db0c9806095122a of arn:aws:elasticloadbalancing:...:loadbalancer/app/my-lb/1db0c9806095122a,
+// which its log objects are named with.
+func ELBV2ID(sess *session.Session, name string) (string, error) {
+	lbs, err := elbv2.New(sess, nil).DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+		Names: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(lbs.LoadBalancers) == 0 {
+		return "", fmt.Errorf("load balancer %q not found", name)
+	}
+	arn := aws.StringValue(lbs.LoadBalancers[0].LoadBalancerArn)
+	return arn[strings.LastIndex(arn, "/")+1:], nil
+}
+
+// elbv2Attributes looks up the load balancer by name, returning it and its
+// attributes.
+func elbv2Attributes(svc *elbv2.ELBV2, name string) (*elbv2.LoadBalancer, map[string]string, error) {
+	lbs, err := svc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+		Names: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(lbs.LoadBalancers) == 0 {
+		return nil, nil, fmt.Errorf("load balancer %q not found", name)
+	}
+	lb := lbs.LoadBalancers[0]
+
+	out, err := svc.DescribeLoadBalancerAttributes(&elbv2.DescribeLoadBalancerAttributesInput{
+		LoadBalancerArn: lb.LoadBalancerArn,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	attrs := make(map[string]string, len(out.Attributes))
+	for _, attr := range out.Attributes {
+		attrs[aws.StringValue(attr.Key)] = aws.StringValue(attr.Value)
+	}
+	return lb, attrs, nil
+}
+
+// EnableELBV2AccessLogs turns on delivering the application or network load
+// balancer's access logs to the bucket, under the prefix.
+func EnableELBV2AccessLogs(sess *session.Session, name, bucket, prefix string) error {
+	svc := elbv2.New(sess, nil)
+
+	lbs, err := svc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+		Names: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return err
+	}
+	if len(lbs.LoadBalancers) == 0 {
+		return fmt.Errorf("load balancer %q not found", name)
+	}
+
+	_, err = svc.ModifyLoadBalancerAttributes(&elbv2.ModifyLoadBalancerAttributesInput{
+		LoadBalancerArn: lbs.LoadBalancers[0].LoadBalancerArn,
+		Attributes: []*elbv2.LoadBalancerAttribute{
+			{Key: aws.String("access_logs.s3.enabled"), Value: aws.String("true")},
+			{Key: aws.String("access_logs.s3.bucket"), Value: aws.String(bucket)},
+			{Key: aws.String("access_logs.s3.prefix"), Value: aws.String(prefix)},
+		},
+	})
+	return err
+}
@@ -0,0 +1,59 @@
+package meta
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Check is one of the checks verify makes before ingesting, e.g. that a load
+// balancer's log bucket can be listed.
+type Check struct {
+	Name   string
+	Target string
+	Err    error
+	// Skipped is why the check couldn't be made, if it couldn't, e.g.
+	// there being no objects yet to get.
+	Skipped string
+}
+
+// Checks are the checks verify has made, in order.
+type Checks []Check
+
+// Add records the check, which passed if err is nil.
+func (c *Checks) Add(name, target string, err error) {
+	*c = append(*c, Check{Name: name, Target: target, Err: err})
+}
+
+// Skip records that the check couldn't be made, and why.
+func (c *Checks) Skip(name, target, reason string) {
+	*c = append(*c, Check{Name: name, Target: target, Skipped: reason})
+}
+
+// Failed returns how many of the checks failed.
+func (c Checks) Failed() int {
+	failed := 0
+	for _, check := range c {
+		if check.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Print prints the checks as a table, with why those which failed did.
+func (c Checks) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tTARGET\tRESULT\tDETAIL")
+	for _, check := range c {
+		result, detail := "PASS", ""
+		switch {
+		case check.Err != nil:
+			result, detail = "FAIL", check.Err.Error()
+		case check.Skipped != "":
+			result, detail = "SKIP", check.Skipped
+		}
+		fmt.Fprintln(tw, check.Name+"\t"+dash(check.Target)+"\t"+result+"\t"+detail)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,41 @@
+package meta
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChecks(t *testing.T) {
+	var checks Checks
+	checks.Add("describe load balancers", "us-east-1", nil)
+	checks.Add("list log bucket", "foo-lb", errors.New("Access Denied"))
+	checks.Skip("get log object", "foo-lb", "no access logs in the last day")
+	checks.Add("honeycomb write key", "", nil)
+
+	if failed := checks.Failed(); failed != 1 {
+		t.Errorf("expected 1 check to have failed, got %d", failed)
+	}
+
+	var buf bytes.Buffer
+	if err := checks.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected a header and a row per check, got %q", buf.String())
+	}
+	expected := []string{
+		"CHECK TARGET RESULT DETAIL",
+		"describe load balancers us-east-1 PASS",
+		"list log bucket foo-lb FAIL Access Denied",
+		"get log object foo-lb SKIP no access logs in the last day",
+		"honeycomb write key - PASS",
+	}
+	for i, line := range lines {
+		if got := strings.Join(strings.Fields(line), " "); got != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// The formats ls prints listings in with --ls_output.
+const (
+	ListOutputTable = "table"
+	ListOutputJSON  = "json"
+)
+
+// Listing is what ls shows about a load balancer or distribution, so that
+// whether its access logs are enabled, and where they go, can be audited.
+type Listing struct {
+	Name           string `json:"name"`
+	Region         string `json:"region,omitempty"`
+	Scheme         string `json:"scheme,omitempty"`
+	State          string `json:"state,omitempty"`
+	LoggingEnabled bool   `json:"logging_enabled"`
+	Bucket         string `json:"bucket,omitempty"`
+	Prefix         string `json:"prefix,omitempty"`
+
+	// Error is why the rest couldn't be looked up, if it couldn't.
+	Error string `json:"error,omitempty"`
+}
+
+// PrintListings prints the listings as a JSON array, or a table with the
+// region column only when they're in several regions, or else just their
+// names as ls does by default.
+func PrintListings(w io.Writer, format string, listings []Listing) error {
+	if format == ListOutputJSON {
+		if listings == nil {
+			listings = []Listing{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(listings)
+	}
+
+	regions := false
+	for _, l := range listings {
+		regions = regions || l.Region != ""
+	}
+	if format != ListOutputTable {
+		for _, l := range listings {
+			line := l.Name
+			if regions {
+				line += "\t" + l.Region
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	header := "NAME\t"
+	if regions {
+		header += "REGION\t"
+	}
+	fmt.Fprintln(tw, header+"SCHEME\tSTATE\tLOGGING\tBUCKET\tPREFIX")
+	for _, l := range listings {
+		logging := "disabled"
+		switch {
+		case l.Error != "":
+			logging = "error: " + l.Error
+		case l.LoggingEnabled:
+			logging = "enabled"
+		}
+		line := l.Name + "\t"
+		if regions {
+			line += l.Region + "\t"
+		}
+		fmt.Fprintln(tw, line+dash(l.Scheme)+"\t"+dash(l.State)+"\t"+logging+"\t"+dash(l.Bucket)+"\t"+dash(l.Prefix))
+	}
+	return tw.Flush()
+}
+
+// dash stands in for empty columns, so that the table's columns line up for
+// tools such as awk.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,47 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrintListings(t *testing.T) {
+	listings := []Listing{
+		{Name: "foo-lb", Scheme: "internet-facing", State: "active", LoggingEnabled: true, Bucket: "logs", Prefix: "foo"},
+		{Name: "bar-lb", Scheme: "internal", State: "active"},
+	}
+
+	var buf bytes.Buffer
+	if err := PrintListings(&buf, "", listings); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "foo-lb\nbar-lb\n" {
+		t.Errorf("expected just the names by default, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := PrintListings(&buf, ListOutputTable, listings); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[0]), " ") != "NAME SCHEME STATE LOGGING BUCKET PREFIX" {
+		t.Fatalf("expected a header and a row per listing, got %q", buf.String())
+	}
+	if got := strings.Join(strings.Fields(lines[2]), " "); got != "bar-lb internal active disabled - -" {
+		t.Errorf("expected empty columns to be dashes, got %q", got)
+	}
+
+	buf.Reset()
+	if err := PrintListings(&buf, ListOutputJSON, listings); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Listing
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0] != listings[0] || decoded[1] != listings[1] {
+		t.Errorf("expected the listings back from the JSON, got %+v", decoded)
+	}
+}
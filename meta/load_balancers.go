@@ -0,0 +1,29 @@
+package meta
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// ELBLoadBalancers returns every classic load balancer the session can see,
+// reading each page of DescribeLoadBalancers rather than just the first 400.
+func ELBLoadBalancers(sess *session.Session) ([]*elb.LoadBalancerDescription, error) {
+	var lbs []*elb.LoadBalancerDescription
+	err := elb.New(sess, nil).DescribeLoadBalancersPages(&elb.DescribeLoadBalancersInput{}, func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		lbs = append(lbs, page.LoadBalancerDescriptions...)
+		return true
+	})
+	return lbs, err
+}
+
+// ELBV2LoadBalancers returns every application and network load balancer the
+// session can see, reading each page of DescribeLoadBalancers.
+func ELBV2LoadBalancers(sess *session.Session) ([]*elbv2.LoadBalancer, error) {
+	var lbs []*elbv2.LoadBalancer
+	err := elbv2.New(sess, nil).DescribeLoadBalancersPages(&elbv2.DescribeLoadBalancersInput{}, func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		lbs = append(lbs, page.LoadBalancers...)
+		return true
+	})
+	return lbs, err
+}
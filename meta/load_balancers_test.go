@@ -0,0 +1,93 @@
+package meta
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestELBV2LoadBalancersPages(t *testing.T) {
+	// Each page has one load balancer, and the marker for the next.
+	pages := map[string]string{
+		"":   "<member><LoadBalancerName>first-lb</LoadBalancerName></member></LoadBalancers><NextMarker>m1",
+		"m1": "<member><LoadBalancerName>second-lb</LoadBalancerName></member></LoadBalancers><NextMarker>m2",
+		"m2": "<member><LoadBalancerName>third-lb</LoadBalancerName></member></LoadBalancers><NextMarker>",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		page, ok := pages[r.Form.Get("Marker")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "<DescribeLoadBalancersResponse><DescribeLoadBalancersResult><LoadBalancers>%s</NextMarker></DescribeLoadBalancersResult></DescribeLoadBalancersResponse>", page)
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		MaxRetries:  aws.Int(0),
+	}))
+	lbs, err := ELBV2LoadBalancers(sess)
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if len(lbs) != 3 || aws.StringValue(lbs[2].LoadBalancerName) != "third-lb" {
+		t.Errorf("Expected the load balancers from every page, got %v", lbs)
+	}
+}
+
+func TestEachRegionSkipsRegionsNotEnabled(t *testing.T) {
+	// ap-east-1 is an opt-in region the account hasn't enabled
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Authorization"), "/ap-east-1/") {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<ErrorResponse><Error><Type>Sender</Type><Code>InvalidClientTokenId</Code><Message>The security token included in the request is invalid.</Message></Error></ErrorResponse>")
+			return
+		}
+		fmt.Fprint(w, "<DescribeLoadBalancersResponse><DescribeLoadBalancersResult><LoadBalancers><member><LoadBalancerName>lb</LoadBalancerName></member></LoadBalancers></DescribeLoadBalancersResult></DescribeLoadBalancersResponse>")
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:    aws.String(srv.URL),
+		MaxRetries:  aws.Int(0),
+	}))
+	sessions := RegionSessions([]*session.Session{sess}, []string{"ap-east-1", "us-east-1"})
+
+	var found []string
+	err := EachRegion(sessions, func(sess *session.Session) error {
+		lbs, err := ELBV2LoadBalancers(sess)
+		for _, lb := range lbs {
+			found = append(found, aws.StringValue(sess.Config.Region)+"/"+aws.StringValue(lb.LoadBalancerName))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal("Expected the region which isn't enabled to be skipped, got: ", err)
+	}
+	if len(found) != 1 || found[0] != "us-east-1/lb" {
+		t.Errorf("Expected the other region's load balancer, got %v", found)
+	}
+
+	// other errors aren't skipped
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "<ErrorResponse><Error><Type>Sender</Type><Code>ValidationError</Code><Message>bad</Message></Error></ErrorResponse>")
+	})
+	if err := EachRegion(sessions, func(sess *session.Session) error {
+		_, err := ELBV2LoadBalancers(sess)
+		return err
+	}); err == nil {
+		t.Error("Expected other errors to be returned")
+	}
+}
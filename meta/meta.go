@@ -13,7 +13,18 @@ type Metadata struct {
 	AccountID, Region string
 }
 
-//TODO: write test and maybe return error also?
+// Partition returns the AWS partition of the region, which its ARNs are in.
+func Partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	}
+	return "aws"
+}
+
+// TODO: write test and maybe return error also?
 func userIDFromARN(arn string) string {
 	splitARN := strings.Split(arn, ":")
 	return splitARN[4]
@@ -0,0 +1,135 @@
+package meta
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/sirupsen/logrus"
+)
+
+// Role session names and source identities can only be 64 characters long,
+// and only have these characters in them.
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// SessionName returns the name to give assumed-role sessions, so that
+// CloudTrail attributes the API calls made with them to the process making
+// them, e.g. honeyaws-1.2.0-ip-10-0-0-1. STS doesn't allow slashes in names,
+// so honeyaws/<version>/<hostname> is written with dashes instead.
+func SessionName(version string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	name := invalidSessionNameChars.ReplaceAllString(
+		strings.Join([]string{"honeyaws", version, hostname}, "-"), "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// AssumeRoleSessions returns a session for each of the given IAM roles,
+// assumed using the credentials from sess, so that resources in other
+// accounts can be accessed. If there are no roles, sess is returned on its
+// own. The name is used as both the role session name and source identity.
+func AssumeRoleSessions(sess *session.Session, roleARNs []string, name string) []*session.Session {
+	if len(roleARNs) == 0 {
+		return []*session.Session{sess}
+	}
+
+	sessions := make([]*session.Session, 0, len(roleARNs))
+	for _, arn := range roleARNs {
+		sessions = append(sessions, sess.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(sess, arn, func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = name
+				p.SourceIdentity = aws.String(name)
+			}),
+		}))
+	}
+	return sessions
+}
+
+// RegionSessions returns a copy of each session for every one of the given
+// regions, or the sessions unchanged if there are no regions.
+func RegionSessions(sessions []*session.Session, regions []string) []*session.Session {
+	if len(regions) == 0 {
+		return sessions
+	}
+
+	regionSessions := make([]*session.Session, 0, len(sessions)*len(regions))
+	for _, sess := range sessions {
+		for _, region := range regions {
+			regionSessions = append(regionSessions, sess.Copy(&aws.Config{
+				Region: aws.String(region),
+			}))
+		}
+	}
+	return regionSessions
+}
+
+// Regions returns the regions to use given the --regions and --all_regions
+// options: every region the service is available in for the latter, or the
+// comma separated list for the former.
+func Regions(regions []string, allRegions bool, service string) []string {
+	if allRegions {
+		var all []string
+		for region := range endpoints.AwsPartition().Services()[service].Regions() {
+			all = append(all, region)
+		}
+		sort.Strings(all)
+		return all
+	}
+
+	var split []string
+	for _, r := range regions {
+		for _, region := range strings.Split(r, ",") {
+			if region = strings.TrimSpace(region); region != "" {
+				split = append(split, region)
+			}
+		}
+	}
+	return split
+}
+
+// RegionNotEnabled returns whether err is AWS turning down the credentials
+// because the region isn't enabled for the account, as the opt-in regions
+// --all_regions includes aren't until they're turned on.
+func RegionNotEnabled(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "UnrecognizedClientException", "InvalidClientTokenId", "AuthFailure", "OptInRequired":
+		return true
+	}
+	return false
+}
+
+// EachRegion calls describe with each of the sessions, skipping those in
+// regions which aren't enabled for the account with a warning, rather than
+// giving up on every region. Any other error is returned right away.
+func EachRegion(sessions []*session.Session, describe func(sess *session.Session) error) error {
+	for _, sess := range sessions {
+		err := describe(sess)
+		if RegionNotEnabled(err) {
+			logrus.WithFields(logrus.Fields{
+				"region": aws.StringValue(sess.Config.Region),
+				"error":  err,
+			}).Warn("Skipping region which isn't enabled for the account")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
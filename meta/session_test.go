@@ -0,0 +1,28 @@
+package meta
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSessionName(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("No hostname: ", err)
+	}
+
+	name := SessionName("1.2.0/dev build")
+	if !strings.HasPrefix(name, "honeyaws-1.2.0-dev-build-") {
+		t.Errorf("Expected session name to start with honeyaws-<version>-, got %q", name)
+	}
+	if invalidSessionNameChars.MatchString(name) {
+		t.Errorf("Expected session name to only have valid characters, got %q", name)
+	}
+	if len(name) > 64 {
+		t.Errorf("Expected session name to be at most 64 characters, got %q", name)
+	}
+	if len(hostname) < 30 && !strings.HasSuffix(name, invalidSessionNameChars.ReplaceAllString(hostname, "-")) {
+		t.Errorf("Expected session name to end with the hostname, got %q", name)
+	}
+}
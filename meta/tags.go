@@ -0,0 +1,108 @@
+package meta
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// DescribeTags takes at most this many load balancers at a time.
+const describeTagsBatch = 20
+
+// ParseTagFilters parses --tag_filter options of the form key=value, which
+// may be comma separated (e.g. team=payments,env=prod), into a map of tag key
+// to the value it must have.
+func ParseTagFilters(opt []string) (map[string]string, error) {
+	return parseTags("--tag_filter", opt)
+}
+
+// ParseAWSTags parses --aws_tags options, of the same form as --tag_filter,
+// into the tags to give the AWS resources the tools create.
+func ParseAWSTags(opt []string) (map[string]string, error) {
+	return parseTags("--aws_tags", opt)
+}
+
+func parseTags(flag string, opt []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, o := range opt {
+		for _, f := range strings.Split(o, ",") {
+			if f = strings.TrimSpace(f); f == "" {
+				continue
+			}
+			parts := strings.SplitN(f, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("Invalid %s %q, expected key=value", flag, f)
+			}
+			tags[parts[0]] = parts[1]
+		}
+	}
+	return tags, nil
+}
+
+// MatchesTags reports whether the tags have every key in filters, set to the
+// value it's filtered on.
+func MatchesTags(tags, filters map[string]string) bool {
+	for k, v := range filters {
+		if tag, ok := tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ELBTags returns the tags of each of the named classic load balancers.
+func ELBTags(sess *session.Session, names []string) (map[string]map[string]string, error) {
+	svc := elb.New(sess, nil)
+	tags := make(map[string]map[string]string, len(names))
+	for i := 0; i < len(names); i += describeTagsBatch {
+		end := i + describeTagsBatch
+		if end > len(names) {
+			end = len(names)
+		}
+		resp, err := svc.DescribeTags(&elb.DescribeTagsInput{
+			LoadBalancerNames: aws.StringSlice(names[i:end]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range resp.TagDescriptions {
+			lbTags := make(map[string]string, len(desc.Tags))
+			for _, tag := range desc.Tags {
+				lbTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			tags[aws.StringValue(desc.LoadBalancerName)] = lbTags
+		}
+	}
+	return tags, nil
+}
+
+// ELBV2Tags returns the tags of each of the application or network load
+// balancers with the given ARNs.
+func ELBV2Tags(sess *session.Session, arns []string) (map[string]map[string]string, error) {
+	svc := elbv2.New(sess, nil)
+	tags := make(map[string]map[string]string, len(arns))
+	for i := 0; i < len(arns); i += describeTagsBatch {
+		end := i + describeTagsBatch
+		if end > len(arns) {
+			end = len(arns)
+		}
+		resp, err := svc.DescribeTags(&elbv2.DescribeTagsInput{
+			ResourceArns: aws.StringSlice(arns[i:end]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range resp.TagDescriptions {
+			lbTags := make(map[string]string, len(desc.Tags))
+			for _, tag := range desc.Tags {
+				lbTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			tags[aws.StringValue(desc.ResourceArn)] = lbTags
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,46 @@
+package meta
+
+import (
+	"testing"
+)
+
+func TestTagFilters(t *testing.T) {
+	filters, err := ParseTagFilters([]string{"team=payments,env=prod", "tier="})
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	testCases := []struct {
+		tags     map[string]string
+		expected bool
+	}{
+		{map[string]string{"team": "payments", "env": "prod", "tier": ""}, true},
+		{map[string]string{"team": "payments", "env": "prod", "tier": "", "owner": "someone"}, true},
+		{map[string]string{"team": "payments", "env": "staging", "tier": ""}, false},
+		{map[string]string{"team": "payments", "env": "prod"}, false},
+		{nil, false},
+	}
+	for _, tc := range testCases {
+		if matches := MatchesTags(tc.tags, filters); matches != tc.expected {
+			t.Errorf("Expected MatchesTags(%v) to be %v", tc.tags, tc.expected)
+		}
+	}
+
+	if _, err := ParseTagFilters([]string{"team"}); err == nil {
+		t.Error("Expected err for a filter without a value")
+	}
+}
+
+func TestParseAWSTags(t *testing.T) {
+	tags, err := ParseAWSTags([]string{"team=observability,cost-center=42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags["team"] != "observability" || tags["cost-center"] != "42" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	if _, err := ParseAWSTags([]string{"=observability"}); err == nil || err.Error() != `Invalid --aws_tags "=observability", expected key=value` {
+		t.Errorf("expected an error naming --aws_tags, got %v", err)
+	}
+}
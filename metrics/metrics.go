@@ -0,0 +1,202 @@
+// Package metrics exposes Prometheus metrics about the ingest pipeline, so
+// that operators can tell whether logs are making their way to Honeycomb and
+// how far behind they are.
+package metrics
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const namespace = "honeyaws"
+
+var (
+	ObjectsDiscovered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_discovered_total",
+		Help:      "Log objects found which haven't been processed yet, and were queued for download.",
+	}, []string{"entity"})
+
+	ObjectsDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_downloaded_total",
+		Help:      "Log objects downloaded.",
+	}, []string{"entity"})
+
+	ObjectsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_failed_total",
+		Help:      "Log objects which still couldn't be downloaded after --max_retries, and were recorded as dead letters.",
+	}, []string{"entity"})
+
+	ObjectsMissing = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_missing_total",
+		Help:      "Log objects which still weren't found after --missing_rechecks, and were left recorded as missing.",
+	}, []string{"entity"})
+
+	ObjectsDuplicated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_duplicated_total",
+		Help:      "Log objects skipped with --dedupe, having the same contents as one already processed under another key.",
+	}, []string{"entity"})
+
+	EventsParsed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_parsed_total",
+		Help:      "Events parsed from log lines, before sampling.",
+	})
+
+	ParseFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "parse_failures_total",
+		Help:      "Log objects or records which could not be parsed.",
+	})
+
+	EventsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_sent_total",
+		Help:      "Events sent to Honeycomb, after sampling.",
+	})
+
+	EventsRetried = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_retried_total",
+		Help:      "Events sent to Honeycomb again after a 429 or 5xx response.",
+	})
+
+	EventsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_failed_total",
+		Help:      "Events given up on after Honeycomb still responded with a 429 or 5xx after --max_retries.",
+	})
+
+	EventsOutOfWindow = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_out_of_window_total",
+		Help:      "Events with timestamps too far in the past or future for Honeycomb to accept, by direction (past or future).",
+	}, []string{"direction"})
+
+	APIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Events Honeycomb failed to accept, by status code (0 for network errors).",
+	}, []string{"status_code"})
+
+	SinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_errors_total",
+		Help:      "Events which couldn't be written to a --sink other than Honeycomb, by sink (s3 or kafka).",
+	}, []string{"sink"})
+
+	QueueOverflows = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_overflows_total",
+		Help:      "Events dropped without being sent because --pending-work-capacity events were already waiting to be sent.",
+	})
+
+	ProcessingLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "processing_lag_seconds",
+		Help:      "Time between the last downloaded log object being written to S3 and it being downloaded.",
+	}, []string{"entity"})
+
+	BackfillProcessed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backfill_objects_processed",
+		Help:      "Log objects in the backfill window processed since backfilling started.",
+	}, []string{"entity"})
+
+	BackfillRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backfill_objects_remaining",
+		Help:      "Log objects in the backfill window still to be processed.",
+	}, []string{"entity"})
+
+	BackfillOldest = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backfill_oldest_unprocessed_timestamp_seconds",
+		Help:      "Time of the oldest log object in the backfill window still to be processed, 0 once there are none.",
+	}, []string{"entity"})
+
+	BackfillETA = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backfill_eta_timestamp_seconds",
+		Help:      "When backfilling is expected to be done, going by how fast it's been so far, 0 until that's known.",
+	}, []string{"entity"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ObjectsDiscovered,
+		ObjectsDownloaded,
+		ObjectsFailed,
+		ObjectsMissing,
+		ObjectsDuplicated,
+		EventsParsed,
+		ParseFailures,
+		EventsSent,
+		EventsRetried,
+		EventsFailed,
+		EventsOutOfWindow,
+		APIErrors,
+		SinkErrors,
+		QueueOverflows,
+		ProcessingLag,
+		BackfillProcessed,
+		BackfillRemaining,
+		BackfillOldest,
+		BackfillETA,
+	)
+}
+
+// ObserveDownload records an object for the entity being downloaded, which
+// was last modified at the given time.
+func ObserveDownload(entity string, lastModified time.Time) {
+	ObjectsDownloaded.WithLabelValues(entity).Inc()
+	ProcessingLag.WithLabelValues(entity).Set(time.Since(lastModified).Seconds())
+}
+
+// ObserveResponse records a response from Honeycomb to an event being sent.
+func ObserveResponse(statusCode int, err error) {
+	if err != nil || statusCode < 200 || statusCode >= 300 {
+		APIErrors.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+	}
+}
+
+// ServeProfiles exposes the net/http/pprof profiles at /debug/pprof/ on the
+// given address, e.g. "localhost:6060".
+func ServeProfiles(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	logrus.WithField("addr", addr).Info("Serving profiles")
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logrus.WithField("error", err).Fatal("Could not serve profiles")
+		}
+	}()
+}
+
+// Serve exposes the metrics at /metrics on the given address, e.g. ":9090".
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	logrus.WithField("addr", addr).Info("Serving metrics")
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logrus.WithField("error", err).Fatal("Could not serve metrics")
+		}
+	}()
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveResponse(t *testing.T) {
+	ObserveResponse(202, nil)
+	ObserveResponse(401, nil)
+	ObserveResponse(401, nil)
+	ObserveResponse(0, errors.New("connection refused"))
+
+	if n := testutil.ToFloat64(APIErrors.WithLabelValues("202")); n != 0 {
+		t.Errorf("Expected successful responses not to be counted, got %v", n)
+	}
+	if n := testutil.ToFloat64(APIErrors.WithLabelValues("401")); n != 2 {
+		t.Errorf("Expected 2 errors for 401, got %v", n)
+	}
+	if n := testutil.ToFloat64(APIErrors.WithLabelValues("0")); n != 1 {
+		t.Errorf("Expected 1 network error, got %v", n)
+	}
+}
+
+func TestObserveDownload(t *testing.T) {
+	ObserveDownload("my-lb", time.Now().Add(-time.Minute))
+
+	if n := testutil.ToFloat64(ObjectsDownloaded.WithLabelValues("my-lb")); n != 1 {
+		t.Errorf("Expected 1 object downloaded, got %v", n)
+	}
+	if lag := testutil.ToFloat64(ProcessingLag.WithLabelValues("my-lb")); lag < 60 || lag > 70 {
+		t.Errorf("Expected a lag of about a minute, got %vs", lag)
+	}
+}
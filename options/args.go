@@ -0,0 +1,34 @@
+package options
+
+import (
+	"os"
+	"strings"
+)
+
+// CommandEnv is the environment variable giving the subcommand (e.g. ingest)
+// to run when there's none on the command line, as in a container with no
+// args.
+const CommandEnv = "HONEYAWS_COMMAND"
+
+// Args returns the subcommand and its arguments. They're taken from the
+// command line when given there, and otherwise from HONEYAWS_COMMAND followed
+// by the comma separated names in namesEnv (e.g. HONEYAWS_LBS), much like
+// every flag can be set with its HONEYAWS_ environment variable.
+func Args(args []string, namesEnv string) []string {
+	if len(args) > 0 {
+		return args
+	}
+
+	cmd := strings.TrimSpace(os.Getenv(CommandEnv))
+	if cmd == "" {
+		return args
+	}
+
+	args = []string{cmd}
+	for _, name := range strings.Split(os.Getenv(namesEnv), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			args = append(args, name)
+		}
+	}
+	return args
+}
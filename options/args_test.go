@@ -0,0 +1,61 @@
+package options
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	flag "github.com/jessevdk/go-flags"
+)
+
+func TestArgs(t *testing.T) {
+	defer os.Unsetenv(CommandEnv)
+	defer os.Unsetenv("HONEYAWS_LBS")
+
+	if args := Args(nil, "HONEYAWS_LBS"); len(args) != 0 {
+		t.Errorf("expected no args without %s, got %v", CommandEnv, args)
+	}
+
+	os.Setenv(CommandEnv, "ingest")
+	os.Setenv("HONEYAWS_LBS", "lb-a, lb-b,")
+	if args := Args(nil, "HONEYAWS_LBS"); !reflect.DeepEqual(args, []string{"ingest", "lb-a", "lb-b"}) {
+		t.Errorf("unexpected args from the environment: %v", args)
+	}
+
+	// the command line wins
+	if args := Args([]string{"ls"}, "HONEYAWS_LBS"); !reflect.DeepEqual(args, []string{"ls"}) {
+		t.Errorf("expected the command line args, got %v", args)
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	env := map[string]string{
+		"HONEYAWS_WRITEKEY":       "abc123",
+		"HONEYAWS_SAMPLERATE":     "20",
+		"HONEYAWS_HIGHAVAIL":      "true",
+		"HONEYAWS_REGIONS":        "us-east-1,eu-west-1",
+		"HONEYAWS_BACKFILL_PAUSE": "Mon,Wed 09:00-17:00;22:00-06:00",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	opt := &Options{}
+	if _, err := flag.NewParser(opt, flag.Default).ParseArgs([]string{"--samplerate=5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if opt.WriteKey != "abc123" || !opt.HighAvail {
+		t.Errorf("expected options to be set from the environment, got %+v", opt)
+	}
+	if opt.SampleRate != 5 {
+		t.Errorf("expected the flag to win over the environment, got %d", opt.SampleRate)
+	}
+	if !reflect.DeepEqual(opt.Regions, []string{"us-east-1", "eu-west-1"}) {
+		t.Errorf("unexpected regions: %v", opt.Regions)
+	}
+	if !reflect.DeepEqual(opt.BackfillPause, []string{"Mon,Wed 09:00-17:00", "22:00-06:00"}) {
+		t.Errorf("unexpected backfill pauses: %v", opt.BackfillPause)
+	}
+}
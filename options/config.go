@@ -0,0 +1,409 @@
+package options
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	flag "github.com/jessevdk/go-flags"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// lbConfigKey is the section of the --config file with per load balancer
+// overrides, rather than an option.
+const lbConfigKey = "load_balancers"
+
+// datasetConfigKey is the section of the --config file with per dataset
+// destinations.
+const datasetConfigKey = "datasets"
+
+// extractConfigKey is the section of the --config file with the rules for
+// extracting fields from others.
+const extractConfigKey = "extract"
+
+// fanoutConfigKey is the section of the --config file with the destinations
+// sent a copy of the events.
+const fanoutConfigKey = "fanout"
+
+// Options whose values validate-config doesn't print.
+var secretOptions = map[string]bool{
+	"writekey":          true,
+	"fallback_writekey": true,
+	"query_key":         true,
+	"otlp_headers":      true,
+	"ip-hash-key":       true,
+}
+
+// ${VAR} in the --config file is replaced with the environment variable. $VAR
+// isn't, since e.g. the default --dataset has $SERVICE in it.
+var configEnvVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LBConfig is what the --config file can override for a load balancer.
+type LBConfig struct {
+	// Dataset is sent the load balancer's events, like --dataset_map.
+	Dataset string `yaml:"dataset,omitempty"`
+
+	// Fields are added to every event of the load balancer.
+	Fields map[string]interface{} `yaml:"fields,omitempty"`
+}
+
+// DatasetConfig is where the --config file sends a dataset's events, when it
+// isn't the --api_host with the --writekey, e.g. for a team using EU
+// Honeycomb.
+type DatasetConfig struct {
+	APIHost  string `yaml:"api_host,omitempty"`
+	WriteKey string `yaml:"writekey,omitempty"`
+}
+
+// FanoutConfig is a destination the --config file sends a copy of the events
+// to, as well as where they're sent anyway, e.g. a platform team's dataset, or
+// a product team's with its own write key.
+type FanoutConfig struct {
+	Dataset  string `yaml:"dataset"`
+	APIHost  string `yaml:"api_host,omitempty"`
+	WriteKey string `yaml:"writekey,omitempty"`
+
+	// SampleRate keeps 1 in this many of the events sent anyway.
+	SampleRate int `yaml:"sample_rate,omitempty"`
+
+	// LoadBalancers, if given, are the only ones whose events are copied.
+	LoadBalancers []string `yaml:"load_balancers,omitempty"`
+}
+
+// ExtractRule is a rule in the --config file for extracting fields from
+// another: each named group of the regex, e.g. (?P<customer_id>[^/]+), is
+// added as a field when it matches.
+type ExtractRule struct {
+	Field string `yaml:"field"`
+	Regex string `yaml:"regex"`
+}
+
+// expandConfigEnv replaces ${VAR} in the config with the environment
+// variable, which must be set.
+func expandConfigEnv(config []byte) ([]byte, error) {
+	var missing []string
+	expanded := configEnvVar.ReplaceAllFunc(config, func(ref []byte) []byte {
+		name := string(configEnvVar.FindSubmatch(ref)[1])
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return []byte(value)
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("unset environment variables: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// fromCommandLine returns whether the option was given as a flag, or in its
+// environment variable, which take precedence over the --config file.
+func fromCommandLine(option *flag.Option) bool {
+	if option.IsSet() && !option.IsSetDefault() {
+		return true
+	}
+	if option.EnvDefaultKey != "" {
+		if _, ok := os.LookupEnv(option.EnvDefaultKey); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// configStrings returns the value of an option in the config as strings,
+// the way it would be given as flags.
+func configStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// setOption sets the field of the option to the value from the config.
+func setOption(field reflect.Value, option *flag.Option, value interface{}) error {
+	values := configStrings(value)
+	if field.Kind() != reflect.Slice && len(values) != 1 {
+		return fmt.Errorf("%s takes a single value, got %v", option.LongName, value)
+	}
+	if len(option.Choices) > 0 {
+		for _, v := range values {
+			// e.g. role is unset unless it's given
+			if v == "" && len(option.Default) == 0 {
+				continue
+			}
+			if !contains(option.Choices, v) {
+				return fmt.Errorf("%s must be one of %s, got %q", option.LongName, strings.Join(option.Choices, ", "), v)
+			}
+		}
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(values[0])
+	case reflect.Int:
+		n, err := strconv.Atoi(values[0])
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number, got %q", option.LongName, values[0])
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number, got %q", option.LongName, values[0])
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", option.LongName, values[0])
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("%s can't be set in the config", option.LongName)
+	}
+	return nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig reads the YAML --config file, if there is one, into the options
+// parsed by the parser. Its keys are the options' flag names, e.g. writekey
+// or dynsample_keys, and load_balancers has overrides for each load balancer
+// by name. Files of older versions of the schema are migrated first. Flags
+// and environment variables take precedence over it.
+func LoadConfig(parser *flag.Parser, opt *Options) error {
+	if opt.ConfigFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(opt.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if data, err = expandConfigEnv(data); err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+	if err := migrateConfig(opt.ConfigFile, config); err != nil {
+		return err
+	}
+
+	if lbs, ok := config[lbConfigKey]; ok {
+		delete(config, lbConfigKey)
+		// round tripped to unmarshal it into LBConfigs
+		lbData, err := yaml.Marshal(lbs)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(lbData, &opt.LBConfigs); err != nil {
+			return fmt.Errorf("%s: %s", lbConfigKey, err)
+		}
+	}
+
+	if datasets, ok := config[datasetConfigKey]; ok {
+		delete(config, datasetConfigKey)
+		datasetData, err := yaml.Marshal(datasets)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(datasetData, &opt.DatasetConfigs); err != nil {
+			return fmt.Errorf("%s: %s", datasetConfigKey, err)
+		}
+	}
+
+	if rules, ok := config[extractConfigKey]; ok {
+		delete(config, extractConfigKey)
+		rulesData, err := yaml.Marshal(rules)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(rulesData, &opt.ExtractRules); err != nil {
+			return fmt.Errorf("%s: %s", extractConfigKey, err)
+		}
+	}
+
+	if fanout, ok := config[fanoutConfigKey]; ok {
+		delete(config, fanoutConfigKey)
+		fanoutData, err := yaml.Marshal(fanout)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(fanoutData, &opt.FanoutConfigs); err != nil {
+			return fmt.Errorf("%s: %s", fanoutConfigKey, err)
+		}
+	}
+
+	structValue := reflect.ValueOf(opt).Elem()
+	for key, value := range config {
+		// e.g. help is an option, but not one of ours
+		option := parser.FindOptionByLongName(key)
+		if option == nil || key == "config" || !structValue.FieldByName(option.Field().Name).IsValid() {
+			return fmt.Errorf("unknown option %q", key)
+		}
+		if fromCommandLine(option) {
+			continue
+		}
+		if err := setOption(structValue.FieldByName(option.Field().Name), option, value); err != nil {
+			return err
+		}
+	}
+
+	// Load balancers with a dataset in --dataset_map keep it.
+	for name, lb := range opt.LBConfigs {
+		if lb.Dataset == "" {
+			continue
+		}
+		mapped := false
+		for _, m := range opt.DatasetMap {
+			if strings.HasPrefix(m, name+"=") {
+				mapped = true
+			}
+		}
+		if !mapped {
+			opt.DatasetMap = append(opt.DatasetMap, name+"="+lb.Dataset)
+		}
+	}
+	return nil
+}
+
+// LBFields returns the fields the --config file adds to the load balancer's
+// events, along with the given ones, which take precedence.
+func (opt *Options) LBFields(name string, fields map[string]interface{}) map[string]interface{} {
+	lb, ok := opt.LBConfigs[name]
+	if !ok || len(lb.Fields) == 0 {
+		return fields
+	}
+	merged := make(map[string]interface{}, len(lb.Fields)+len(fields))
+	for k, v := range lb.Fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return merged
+}
+
+// ReloadConfig reads the --config file again, e.g. on SIGHUP, returning the
+// options as they now are, and the names of those which changed, along with
+// load_balancers, datasets, extract and fanout. opt is left as it is, for the
+// caller to take what it can change while running. Options removed from the
+// file keep their values until a restart.
+func ReloadConfig(parser *flag.Parser, opt *Options) (*Options, []string, error) {
+	reloaded := *opt
+	// unmarshaled into and appended to, so not to be shared with opt
+	reloaded.LBConfigs = nil
+	reloaded.DatasetConfigs = nil
+	reloaded.ExtractRules = nil
+	reloaded.FanoutConfigs = nil
+	reloaded.DatasetMap = append([]string(nil), opt.DatasetMap...)
+	if err := LoadConfig(parser, &reloaded); err != nil {
+		return nil, nil, err
+	}
+
+	var changed []string
+	before, after := reflect.ValueOf(opt).Elem(), reflect.ValueOf(&reloaded).Elem()
+	for _, group := range parser.Groups() {
+		for _, option := range group.Options() {
+			name := option.Field().Name
+			if option.LongName == "" || !before.FieldByName(name).IsValid() {
+				continue
+			}
+			if !reflect.DeepEqual(before.FieldByName(name).Interface(), after.FieldByName(name).Interface()) {
+				changed = append(changed, option.LongName)
+			}
+		}
+	}
+	if !reflect.DeepEqual(opt.LBConfigs, reloaded.LBConfigs) {
+		changed = append(changed, lbConfigKey)
+	}
+	if !reflect.DeepEqual(opt.DatasetConfigs, reloaded.DatasetConfigs) {
+		changed = append(changed, datasetConfigKey)
+	}
+	if !reflect.DeepEqual(opt.ExtractRules, reloaded.ExtractRules) {
+		changed = append(changed, extractConfigKey)
+	}
+	if !reflect.DeepEqual(opt.FanoutConfigs, reloaded.FanoutConfigs) {
+		changed = append(changed, fanoutConfigKey)
+	}
+	return &reloaded, changed, nil
+}
+
+// PrintConfig writes the options, as loaded from flags, the environment and
+// the --config file, to w as YAML that --config could read, for
+// validate-config. Secrets such as the write key are redacted.
+func PrintConfig(parser *flag.Parser, opt *Options, w io.Writer) error {
+	config := map[string]interface{}{configVersionKey: configVersion}
+	structValue := reflect.ValueOf(opt).Elem()
+	for _, group := range parser.Groups() {
+		for _, option := range group.Options() {
+			field := structValue.FieldByName(option.Field().Name)
+			if option.LongName == "" || option.LongName == "config" || option.LongName == "version" || !field.IsValid() {
+				continue
+			}
+			value := field.Interface()
+			if secretOptions[option.LongName] && !reflect.ValueOf(value).IsZero() {
+				value = "REDACTED"
+			}
+			config[option.LongName] = value
+		}
+	}
+	if len(opt.LBConfigs) > 0 {
+		config[lbConfigKey] = opt.LBConfigs
+	}
+	if len(opt.DatasetConfigs) > 0 {
+		datasets := make(map[string]DatasetConfig, len(opt.DatasetConfigs))
+		for name, dataset := range opt.DatasetConfigs {
+			if dataset.WriteKey != "" {
+				dataset.WriteKey = "REDACTED"
+			}
+			datasets[name] = dataset
+		}
+		config[datasetConfigKey] = datasets
+	}
+	if len(opt.ExtractRules) > 0 {
+		config[extractConfigKey] = opt.ExtractRules
+	}
+	if len(opt.FanoutConfigs) > 0 {
+		fanout := make([]FanoutConfig, len(opt.FanoutConfigs))
+		for i, dest := range opt.FanoutConfigs {
+			if dest.WriteKey != "" {
+				dest.WriteKey = "REDACTED"
+			}
+			fanout[i] = dest
+		}
+		config[fanoutConfigKey] = fanout
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
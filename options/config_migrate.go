@@ -0,0 +1,129 @@
+package options
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configVersionKey is the version of the --config file's schema, rather than
+// an option. Files without one are version 1.
+const configVersionKey = "version"
+
+// configVersion is the version of the --config file's schema this build
+// reads. Older files are migrated to it as they're loaded.
+const configVersion = 2
+
+// configMigrations migrate the --config file from each version to the next:
+// the first from version 1 to 2, and so on.
+var configMigrations = []func(config map[string]interface{}) error{
+	// version 2 keys --backfill by its flag name, like every other option,
+	// rather than as backfill_hr
+	renameConfigKey("backfill_hr", "backfill"),
+}
+
+// migrationOut is where the changes migrating the --config file made are
+// printed, for the file to be updated with.
+var migrationOut io.Writer = os.Stderr
+
+// renameConfigKey returns a migration renaming the key, which mustn't be in
+// the file under both names.
+func renameConfigKey(from, to string) func(config map[string]interface{}) error {
+	return func(config map[string]interface{}) error {
+		value, ok := config[from]
+		if !ok {
+			return nil
+		}
+		if _, ok := config[to]; ok {
+			return fmt.Errorf("both %s and %s are set, but %s is the old name of %s", from, to, from, to)
+		}
+		delete(config, from)
+		config[to] = value
+		return nil
+	}
+}
+
+// migrateConfig migrates the config from the version it says it is to the
+// current one, printing the changes to migrationOut if it made any, and
+// removes the version from it.
+func migrateConfig(path string, config map[string]interface{}) error {
+	version := 1
+	if v, ok := config[configVersionKey]; ok {
+		n, ok := v.(int)
+		if !ok || n < 1 {
+			return fmt.Errorf("%s must be a whole number from 1, got %v", configVersionKey, v)
+		}
+		version = n
+	}
+	delete(config, configVersionKey)
+	if version > configVersion {
+		return fmt.Errorf("the file is version %d, but this build only reads versions up to %d; upgrade it", version, configVersion)
+	}
+	if version == configVersion {
+		return nil
+	}
+
+	before := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		before[k] = v
+	}
+	for _, migrate := range configMigrations[version-1:] {
+		if err := migrate(config); err != nil {
+			return fmt.Errorf("migrating from version %d: %s", version, err)
+		}
+	}
+	diff, err := configDiff(before, config)
+	if err != nil || len(diff) == 0 {
+		return err
+	}
+	fmt.Fprintf(migrationOut, "%s is version %d of the config file, and was migrated to version %d. Update it with:\n", path, version, configVersion)
+	fmt.Fprintf(migrationOut, "%s+ %s: %d\n", diff, configVersionKey, configVersion)
+	return nil
+}
+
+// configDiff returns the keys of the config which a migration removed or
+// changed as YAML prefixed with -, and those it added or changed prefixed
+// with +, with secrets redacted.
+func configDiff(before, after map[string]interface{}) (string, error) {
+	var removed, added []string
+	for k, v := range before {
+		if a, ok := after[k]; !ok || !reflect.DeepEqual(v, a) {
+			removed = append(removed, k)
+		}
+	}
+	for k, v := range after {
+		if b, ok := before[k]; !ok || !reflect.DeepEqual(v, b) {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+
+	var diff bytes.Buffer
+	for _, change := range []struct {
+		prefix string
+		keys   []string
+		config map[string]interface{}
+	}{{"- ", removed, before}, {"+ ", added, after}} {
+		for _, k := range change.keys {
+			value := change.config[k]
+			if secretOptions[k] {
+				value = "REDACTED"
+			}
+			data, err := yaml.Marshal(map[string]interface{}{k: value})
+			if err != nil {
+				return "", err
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+				diff.WriteString(change.prefix + line + "\n")
+			}
+		}
+	}
+	return diff.String(), nil
+}
@@ -0,0 +1,315 @@
+package options
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	flag "github.com/jessevdk/go-flags"
+)
+
+// loadConfig parses the args, with --config pointed at a file of the given
+// YAML, and loads it. The file is gone again when it returns.
+func loadConfig(t *testing.T, config string, args ...string) (*flag.Parser, *Options, error) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := &Options{}
+	parser := flag.NewParser(opt, flag.Default)
+	if _, err := parser.ParseArgs(append([]string{"--config", path}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return parser, opt, LoadConfig(parser, opt)
+}
+
+func TestLoadConfig(t *testing.T) {
+	os.Setenv("HONEYAWS_REGIONS", "us-west-2")
+	defer os.Unsetenv("HONEYAWS_REGIONS")
+	os.Setenv("TEST_WRITEKEY", "abc123")
+	defer os.Unsetenv("TEST_WRITEKEY")
+
+	_, opt, err := loadConfig(t, `
+writekey: ${TEST_WRITEKEY}
+samplerate: 20
+dataset: from-config
+regions: [eu-west-1]
+dynsample_keys:
+  - elb_status_code
+  - request_path
+highavail: true
+spool_codec: zstd
+`, "--dataset=from-flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opt.WriteKey != "abc123" {
+		t.Errorf("expected ${TEST_WRITEKEY} to be expanded, got %q", opt.WriteKey)
+	}
+	if opt.SampleRate != 20 || !opt.HighAvail || opt.SpoolCodec != "zstd" {
+		t.Errorf("expected the config to override the defaults, got %+v", opt)
+	}
+	if !reflect.DeepEqual(opt.DynSampleKeys, []string{"elb_status_code", "request_path"}) {
+		t.Errorf("unexpected dynsample keys: %v", opt.DynSampleKeys)
+	}
+	if opt.Dataset != "from-flag" {
+		t.Errorf("expected the flag to win over the config, got %q", opt.Dataset)
+	}
+	if !reflect.DeepEqual(opt.Regions, []string{"us-west-2"}) {
+		t.Errorf("expected the environment to win over the config, got %v", opt.Regions)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	os.Unsetenv("TEST_UNSET")
+	for config, expected := range map[string]string{
+		"writekey: ${TEST_UNSET}":      "TEST_UNSET",
+		"no_such_option: true":         `unknown option "no_such_option"`,
+		"help: true":                   `unknown option "help"`,
+		"spool_codec: brotli":          "spool_codec must be one of",
+		"samplerate: lots":             "samplerate must be a whole number",
+		"dataset: [a, b]":              "dataset takes a single value",
+		"load_balancers: {lb: {x: 1}}": "load_balancers",
+	} {
+		if _, _, err := loadConfig(t, config); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected an error with %q, got %v", config, expected, err)
+		}
+	}
+}
+
+func TestLoadConfigLoadBalancers(t *testing.T) {
+	_, opt, err := loadConfig(t, `
+load_balancers:
+  lb-a:
+    dataset: lb-a-access
+    fields:
+      team: payments
+      aws_region: overridden
+  lb-b:
+    dataset: lb-b-access
+`, "--dataset_map", "lb-b=lb-b-flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(opt.DatasetMap, []string{"lb-b=lb-b-flag", "lb-a=lb-a-access"}) {
+		t.Errorf("unexpected dataset map: %v", opt.DatasetMap)
+	}
+
+	fields := opt.LBFields("lb-a", map[string]interface{}{"aws_region": "us-east-1"})
+	if !reflect.DeepEqual(fields, map[string]interface{}{"team": "payments", "aws_region": "us-east-1"}) {
+		t.Errorf("unexpected fields for lb-a: %v", fields)
+	}
+	if fields := opt.LBFields("lb-b", nil); fields != nil {
+		t.Errorf("expected no fields for lb-b, got %v", fields)
+	}
+}
+
+func TestLoadConfigDatasets(t *testing.T) {
+	_, opt, err := loadConfig(t, `
+datasets:
+  eu-access:
+    api_host: https://api.eu1.honeycomb.io/
+    writekey: eu123
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]DatasetConfig{"eu-access": {APIHost: "https://api.eu1.honeycomb.io/", WriteKey: "eu123"}}
+	if !reflect.DeepEqual(opt.DatasetConfigs, expected) {
+		t.Errorf("unexpected datasets: %v", opt.DatasetConfigs)
+	}
+
+	if _, _, err := loadConfig(t, "datasets: {eu-access: {host: x}}"); err == nil || !strings.Contains(err.Error(), "datasets") {
+		t.Errorf("expected an unknown dataset setting to be an error, got %v", err)
+	}
+}
+
+func TestLoadConfigExtract(t *testing.T) {
+	_, opt, err := loadConfig(t, `
+extract:
+  - field: request_path
+    regex: ^/api/v2/customers/(?P<customer_id>[^/]+)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ExtractRule{{Field: "request_path", Regex: "^/api/v2/customers/(?P<customer_id>[^/]+)"}}
+	if !reflect.DeepEqual(opt.ExtractRules, expected) {
+		t.Errorf("unexpected extract rules: %v", opt.ExtractRules)
+	}
+
+	if _, _, err := loadConfig(t, "extract: [{field: request_path, pattern: x}]"); err == nil || !strings.Contains(err.Error(), "extract") {
+		t.Errorf("expected an unknown extract setting to be an error, got %v", err)
+	}
+}
+
+func TestLoadConfigFanout(t *testing.T) {
+	_, opt, err := loadConfig(t, `
+fanout:
+  - dataset: platform-access
+    sample_rate: 10
+  - dataset: checkout-access
+    writekey: checkout123
+    load_balancers: [checkout-alb]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FanoutConfig{
+		{Dataset: "platform-access", SampleRate: 10},
+		{Dataset: "checkout-access", WriteKey: "checkout123", LoadBalancers: []string{"checkout-alb"}},
+	}
+	if !reflect.DeepEqual(opt.FanoutConfigs, expected) {
+		t.Errorf("unexpected fanout: %v", opt.FanoutConfigs)
+	}
+
+	var buf bytes.Buffer
+	if err := PrintConfig(flag.NewParser(opt, flag.Default), opt, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "checkout123") {
+		t.Errorf("expected the fanout write key to be redacted, got %s", buf.String())
+	}
+
+	if _, _, err := loadConfig(t, "fanout: [{dataset: x, samplerate: 10}]"); err == nil || !strings.Contains(err.Error(), "fanout") {
+		t.Errorf("expected an unknown fanout setting to be an error, got %v", err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("samplerate: 20\nload_balancers: {lb-a: {fields: {team: payments}}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opt := &Options{}
+	parser := flag.NewParser(opt, flag.Default)
+	if _, err := parser.ParseArgs([]string{"--config", path, "--dataset", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(parser, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("samplerate: 50\ndataset: from-config\ntag_filter: [team=payments]\nload_balancers: {lb-a: {fields: {team: checkout}}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, changed, err := ReloadConfig(parser, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(changed)
+	if !reflect.DeepEqual(changed, []string{"load_balancers", "samplerate", "tag_filter"}) {
+		t.Errorf("unexpected changed options: %v", changed)
+	}
+	if reloaded.SampleRate != 50 || reloaded.Dataset != "from-flag" || reloaded.LBFields("lb-a", nil)["team"] != "checkout" {
+		t.Errorf("unexpected reloaded options: %+v", reloaded)
+	}
+	if opt.SampleRate != 20 || opt.LBFields("lb-a", nil)["team"] != "payments" {
+		t.Errorf("expected the options to be left for the caller to change, got %+v", opt)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("samplerate: lots\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReloadConfig(parser, opt); err == nil {
+		t.Error("expected an invalid config to be an error")
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	parser, opt, err := loadConfig(t, `
+writekey: abc123
+samplerate: 20
+load_balancers:
+  lb-a:
+    dataset: lb-a-access
+datasets:
+  lb-a-access:
+    writekey: def456
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := PrintConfig(parser, opt, &buf); err != nil {
+		t.Fatal(err)
+	}
+	printed := buf.String()
+	for _, expected := range []string{"writekey: REDACTED\n", "samplerate: 20\n", "dataset: lb-a-access\n"} {
+		if !strings.Contains(printed, expected) {
+			t.Errorf("expected %q in the printed config:\n%s", expected, printed)
+		}
+	}
+	if strings.Contains(printed, "abc123") || strings.Contains(printed, "def456") || strings.Contains(printed, "config:") {
+		t.Errorf("unexpected printed config:\n%s", printed)
+	}
+
+	// what's printed loads back the same
+	printed = strings.Replace(printed, "writekey: REDACTED", "writekey: def456", 1)
+	_, reloaded, err := loadConfig(t, strings.Replace(printed, "REDACTED", "abc123", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.ConfigFile = opt.ConfigFile
+	if !reflect.DeepEqual(reloaded, opt) {
+		t.Errorf("expected the printed config to load back the same:\n%+v\n%+v", reloaded, opt)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	var out bytes.Buffer
+	migrationOut = &out
+	defer func() { migrationOut = os.Stderr }()
+
+	_, opt, err := loadConfig(t, `
+backfill_hr: 24
+writekey: abc123
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.BackfillHr != 24 || opt.WriteKey != "abc123" {
+		t.Errorf("expected backfill_hr to be migrated to backfill, got %d", opt.BackfillHr)
+	}
+	diff := out.String()
+	if !strings.Contains(diff, "is version 1 of the config file, and was migrated to version 2") ||
+		!strings.HasSuffix(diff, "\n- backfill_hr: 24\n+ backfill: 24\n+ version: 2\n") {
+		t.Errorf("expected the migration's diff to be printed, got:\n%s", diff)
+	}
+
+	out.Reset()
+	if _, opt, err := loadConfig(t, "version: 2\nbackfill: 12\n"); err != nil || opt.BackfillHr != 12 {
+		t.Errorf("expected a current file to load as it is, got %d (%v)", opt.BackfillHr, err)
+	}
+	if _, _, err := loadConfig(t, "samplerate: 5\n"); err != nil || out.Len() != 0 {
+		t.Errorf("expected nothing to be printed for a file the migrations don't change, got %q (%v)", out.String(), err)
+	}
+	if _, _, err := loadConfig(t, "version: 2\nbackfill_hr: 24\n"); err == nil {
+		t.Error("expected backfill_hr to be an unknown option in a version 2 file")
+	}
+	if _, _, err := loadConfig(t, "backfill_hr: 24\nbackfill: 12\n"); err == nil {
+		t.Error("expected both names of an option to be an error")
+	}
+	if _, _, err := loadConfig(t, "version: 3\n"); err == nil || !strings.Contains(err.Error(), "upgrade") {
+		t.Errorf("expected a newer version to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package options
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// SetupLogging sets the level and format of logrus' output from --log-level,
+// --debug and --log-format.
+func SetupLogging(opt *Options) {
+	level, err := logrus.ParseLevel(opt.LogLevel)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	if opt.Debug {
+		level = logrus.DebugLevel
+	}
+	logrus.SetLevel(level)
+
+	if opt.LogFormat == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+		return
+	}
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+}
@@ -0,0 +1,29 @@
+package options
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetupLogging(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	defer logrus.SetFormatter(logrus.StandardLogger().Formatter)
+
+	SetupLogging(&Options{LogFormat: "json", LogLevel: "warn"})
+	if logrus.GetLevel() != logrus.WarnLevel {
+		t.Errorf("expected warn level, got %s", logrus.GetLevel())
+	}
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf("expected the JSON formatter, got %T", logrus.StandardLogger().Formatter)
+	}
+
+	// --debug wins over --log-level
+	SetupLogging(&Options{LogFormat: "text", LogLevel: "error", Debug: true})
+	if logrus.GetLevel() != logrus.DebugLevel {
+		t.Errorf("expected debug level, got %s", logrus.GetLevel())
+	}
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.TextFormatter); !ok {
+		t.Errorf("expected the text formatter, got %T", logrus.StandardLogger().Formatter)
+	}
+}
@@ -0,0 +1,43 @@
+package options
+
+import (
+	"reflect"
+	"strconv"
+
+	flag "github.com/jessevdk/go-flags"
+)
+
+// The --mode values.
+const (
+	ModeAll      = "all"
+	ModeTail     = "tail"
+	ModeBackfill = "backfill"
+)
+
+// backfillDefaults are the defaults --mode=backfill changes, for throughput
+// rather than latency: more objects downloaded at once, and fuller batches.
+var backfillDefaults = map[string]int{
+	"download_workers": 16,
+	"batch-timeout":    1000,
+}
+
+// ApplyModeDefaults changes the defaults of the options --mode tunes, for
+// those which are still at their defaults, so that whatever is given with a
+// flag, environment variable or the --config file is kept.
+func ApplyModeDefaults(parser *flag.Parser, opt *Options) {
+	if opt.Mode != ModeBackfill {
+		return
+	}
+	structValue := reflect.ValueOf(opt).Elem()
+	for name, value := range backfillDefaults {
+		option := parser.FindOptionByLongName(name)
+		if option == nil || fromCommandLine(option) || len(option.Default) != 1 {
+			continue
+		}
+		field := structValue.FieldByName(option.Field().Name)
+		// otherwise it was set in the --config file
+		if strconv.FormatInt(field.Int(), 10) == option.Default[0] {
+			field.SetInt(int64(value))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package options
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyModeDefaults(t *testing.T) {
+	parser, opt, err := loadConfig(t, `
+mode: backfill
+batch-timeout: 200
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyModeDefaults(parser, opt)
+	if opt.DownloadWorkers != 16 {
+		t.Errorf("expected more download workers for backfill, got %d", opt.DownloadWorkers)
+	}
+	if opt.BatchTimeout != 200 {
+		t.Errorf("expected --batch-timeout from the config to be kept, got %d", opt.BatchTimeout)
+	}
+
+	// given as a flag, even if it's the default
+	parser, opt, err = loadConfig(t, `mode: backfill`, "--download_workers=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyModeDefaults(parser, opt)
+	if opt.DownloadWorkers != 4 || opt.BatchTimeout != 1000 {
+		t.Errorf("expected --download_workers to be kept, and --batch-timeout raised, got %d and %d", opt.DownloadWorkers, opt.BatchTimeout)
+	}
+
+	parser, opt, err = loadConfig(t, `mode: tail`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyModeDefaults(parser, opt)
+	if opt.DownloadWorkers != 4 || opt.BatchTimeout != 100 {
+		t.Errorf("expected the defaults to be left alone for tail, got %d and %d", opt.DownloadWorkers, opt.BatchTimeout)
+	}
+}
+
+func TestBackfillModeTimeRange(t *testing.T) {
+	now := time.Date(2018, 8, 21, 0, 0, 0, 0, time.UTC)
+
+	start, end, err := (&Options{Mode: ModeBackfill, BackfillHr: 6}).TimeRange(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(now.Add(-6*time.Hour)) || !end.Equal(now) {
+		t.Errorf("expected the --backfill hours before now, got %s-%s", start, end)
+	}
+
+	// a range of its own
+	start, _, err = (&Options{Mode: ModeBackfill, BackfillHr: 6, StartTime: "2018-08-19T00:00:00Z"}).TimeRange(now)
+	if err != nil || !start.Equal(now.Add(-48*time.Hour)) {
+		t.Errorf("expected --start-time to be kept, got %s, %v", start, err)
+	}
+
+	for _, opt := range []*Options{
+		{Mode: ModeTail, StartTime: "2018-08-20T14:00:00Z"},
+		{Mode: ModeBackfill, BackfillHr: 6, Shard: true},
+	} {
+		if _, _, err := opt.TimeRange(now); err == nil {
+			t.Errorf("expected an error for --mode=%s", opt.Mode)
+		}
+	}
+}
@@ -1,18 +1,202 @@
 package options
 
 type Options struct {
-	Dataset         string  `short:"d" long:"dataset" description:"Name of the dataset" default:"aws-$SERVICE-access"`
-	SampleRate      int     `long:"samplerate" description:"Only send 1 / N log lines" default:"1"`
-	WriteKey        string  `short:"k" long:"writekey" description:"Honeycomb team write key"`
-	StateDir        string  `long:"statedir" description:"Directory where ingest state is stored" default:"."`
-	HighAvail       bool    `long:"highavail" description:"Enable high availability ingestion using DynamoDB"`
-	BackfillHr      int     `long:"backfill" description:"The number of hours to increase backfill of log ingestion to with max of 168 hours (1 week)" default:"1"`
-	EdgeMode        bool    `long:"edge_mode" description:"Ignore any parent trace id, if present, from a load balancer"`
-	SamplerType     string  `long:"sampler_type" default:"simple" description:"Type of dynamic sampler to use. Options are 'simple' and 'ema'"`
-	SamplerInterval int     `long:"sampler_interval" default:"300" description:"Interval between sample rate calculation, in seconds."`
-	SamplerDecay    float64 `long:"sampler_decay" default:"0.5" description:"Used only when sampler_type is set to 'ema'. A value between (0,1) that controls how fast new observations are factored into the moving average. Larger values mean the sample rates are more sensitive to recent observations."`
+	ConfigFile        string   `long:"config" env:"HONEYAWS_CONFIG" description:"YAML file of options, named as their flags, and per load balancer overrides under load_balancers, e.g. /etc/honeyaws/config.yaml. ${VAR} is replaced with the environment variable. Flags and environment variables take precedence. See the README."`
+	Dataset           string   `short:"d" long:"dataset" env:"HONEYAWS_DATASET" description:"Name of the dataset" default:"aws-$SERVICE-access"`
+	DatasetMap        []string `long:"dataset_map" env:"HONEYAWS_DATASET_MAP" env-delim:"," description:"Send the events of a load balancer to its own dataset instead of --dataset, as lb-name=dataset. May be repeated."`
+	SampleRate        int      `long:"samplerate" env:"HONEYAWS_SAMPLERATE" description:"Only send 1 / N log lines" default:"1"`
+	WriteKey          string   `short:"k" long:"writekey" env:"HONEYAWS_WRITEKEY" description:"Honeycomb team write key"`
+	StateDir          string   `long:"statedir" env:"HONEYAWS_STATEDIR" description:"Directory where ingest state is stored" default:"."`
+	HighAvail         bool     `long:"highavail" env:"HONEYAWS_HIGHAVAIL" description:"Enable high availability ingestion using DynamoDB"`
+	DynamoTable       string   `long:"dynamo_table" env:"HONEYAWS_DYNAMO_TABLE" description:"Name of the DynamoDB table used by --highavail" default:"HoneyAWSAccessLogBuckets"`
+	K8sLeaderElection bool     `long:"k8s-leader-election" env:"HONEYAWS_K8S_LEADER_ELECTION" description:"Elect a leader among the replicas of ingest with a Kubernetes Lease, so that only the leader ingests and the others wait to take over from it, as an alternative to --highavail for an HA pair of pods"`
+	K8sLeaseName      string   `long:"k8s-lease-name" env:"HONEYAWS_K8S_LEASE_NAME" description:"Name of the Lease used by --k8s-leader-election, in the pod's namespace. Defaults to the tool's name, e.g. honeyalb."`
+	Shard             bool     `long:"shard" env:"HONEYAWS_SHARD" description:"Divide the load balancers (or distributions, trails or flow logs) between the instances sharing --highavail state, each listing the buckets of its fair share of them only. Those of an instance which stops are taken over once their leases run out."`
+	ShardID           string   `long:"shard_id" env:"HONEYAWS_SHARD_ID" description:"Name of this instance for --shard, unique among them. Defaults to the hostname and process id."`
+	ShardLease        int      `long:"shard_lease" env:"HONEYAWS_SHARD_LEASE" description:"Seconds a --shard lease lasts without being renewed, so how long before the work of an instance which stops is taken over" default:"60"`
+	CreateTable       bool     `long:"create_table" env:"HONEYAWS_CREATE_TABLE" description:"Create the --dynamo_table table with on-demand billing if it doesn't exist yet"`
+	StateBackend      string   `long:"state_backend" env:"HONEYAWS_STATE_BACKEND" description:"Keep ingest state in Redis or PostgreSQL instead of DynamoDB for high availability, as a redis:// or postgres:// URL"`
+	BackfillHr        int      `long:"backfill" env:"HONEYAWS_BACKFILL" description:"The number of hours to increase backfill of log ingestion to with max of 168 hours (1 week)" default:"1"`
+	ListOutput        string   `long:"ls_output" env:"HONEYAWS_LS_OUTPUT" choice:"table" choice:"json" description:"Have ls print a table, or JSON, of each load balancer's (or distribution's) scheme, state, whether access logs are enabled and the bucket and prefix they're delivered to, instead of just the names"`
+	BootstrapFormat   string   `long:"bootstrap_format" env:"HONEYAWS_BOOTSTRAP_FORMAT" choice:"json" choice:"cloudformation" choice:"terraform" default:"json" description:"What bootstrap prints the IAM policy, bucket policies and --highavail DynamoDB table as: JSON documents, a CloudFormation template, or a Terraform configuration"`
+	Mode              string   `long:"mode" env:"HONEYAWS_MODE" choice:"all" choice:"tail" choice:"backfill" default:"all" description:"What to ingest: all of it, tail for only the logs delivered since starting (with low latency), or backfill for only the --backfill hours (or --start-time range) before starting, with higher concurrency, exiting once they're published. Tail and backfill instances can share the state."`
+	StartTime         string   `long:"start-time" env:"HONEYAWS_START_TIME" description:"Ingest the logs from this time (RFC3339, e.g. 2018-08-20T14:00:00Z) until --end-time only, whether or not regular ingest has ingested them, and exit once they're published. The state kept for regular ingest is left alone. The events are tagged with a replay.id, and a range overlapping one replayed before is refused without --force."`
+	EndTime           string   `long:"end-time" env:"HONEYAWS_END_TIME" description:"End of the --start-time range (RFC3339). Defaults to now."`
+	Force             bool     `long:"force" env:"HONEYAWS_FORCE" description:"Replay a --start-time range even though it overlaps one replayed before"`
+	RetentionHr       int      `long:"retention" env:"HONEYAWS_RETENTION" description:"The number of hours of state kept by state cleanup, defaulting to --backfill"`
+	StateLB           string   `long:"lb" env:"HONEYAWS_LB" description:"Only the objects of this load balancer (or distribution, flow log or web ACL), going by its name in their keys, for state list, state reset and replay objects"`
+	StateSince        string   `long:"since" env:"HONEYAWS_SINCE" description:"Only the objects with logs from this time (RFC3339, e.g. 2018-08-20T14:00:00Z) on, for state list, state reset and replay objects"`
+	BackfillPause     []string `long:"backfill_pause" env:"HONEYAWS_BACKFILL_PAUSE" env-delim:";" description:"Pause backfilling objects older than an hour during this window, as [days ]HH:MM-HH:MM in local time, e.g. 'Mon-Fri 09:00-17:00'. Recent logs are still ingested. May be repeated."`
+	GapScan           string   `long:"gap_scan" env:"HONEYAWS_GAP_SCAN" choice:"off" choice:"report" choice:"repair" description:"On startup, compare the state with the objects in the bucket for the backfill window and report those which were never processed, e.g. after a crash, or also repair them by processing them" default:"report"`
+	ListByHour        bool     `long:"list_by_hour" env:"HONEYAWS_LIST_BY_HOUR" description:"List the bucket by the prefix of each hour of the backfill interval, rather than by that of the whole of today, leaving hours alone once their logs have settled, which cuts down listing huge buckets. Hours are listed whole rather than picked up from a cursor"`
+	Dedupe            bool     `long:"dedupe" env:"HONEYAWS_DEDUPE" description:"Publish log objects with the same contents, going by their S3 ETags, only once, even when they're found under different keys, e.g. in a bucket and its replica, or under a prefix shared by two load balancers. Costs a write to the state per object."`
+	ExactlyOnce       bool     `long:"exactly_once" env:"HONEYAWS_EXACTLY_ONCE" description:"Record log objects as published, and progress through them, only once Honeycomb has accepted the events sent from them, so that an object isn't published again after a crash, nor resumed by two instances at once. Costs a couple more writes to the state per object."`
+	ErrorsFirst       bool     `long:"backfill_errors_first" env:"HONEYAWS_BACKFILL_ERRORS_FIRST" description:"Sample an object from each hour of backfill for its rate of 5xx responses, and backfill the hours with the most 5xx first rather than in order"`
+	MaxEventAgeHr     int      `long:"max_event_age" env:"HONEYAWS_MAX_EVENT_AGE" description:"Events older than this many hours are outside of the dataset's retention, and counted and warned about as likely to be dropped by Honeycomb. 0 disables the check." default:"1440"`
+	MaxEventSkew      int      `long:"max_event_skew" env:"HONEYAWS_MAX_EVENT_SKEW" description:"Events more than this many seconds in the future, e.g. from a skewed clock, are counted and warned about as likely to be dropped by Honeycomb. 0 disables the check." default:"300"`
+	Restamp           bool     `long:"restamp" env:"HONEYAWS_RESTAMP" description:"Send events outside --max_event_age and --max_event_skew with the current time, keeping their own as original_timestamp, instead of having them dropped"`
+	EdgeMode          bool     `long:"edge_mode" env:"HONEYAWS_EDGE_MODE" description:"Ignore any parent trace id, if present, from a load balancer"`
+	TraceIDFormat     string   `long:"trace_id_format" env:"HONEYAWS_TRACE_ID_FORMAT" description:"Format of the trace.trace_id field parsed from X-Amzn-Trace-Id: 'xray' as logged (1-5759e988-bd862e3fe1be46a994272793), or 'w3c' (5759e988bd862e3fe1be46a994272793) to join traces from OpenTelemetry instrumented services" default:"xray"`
+	StatusRates       string   `long:"sample-rate-by-status" env:"HONEYAWS_SAMPLE_RATE_BY_STATUS" description:"Fixed sample rates for responses by status code or class, e.g. '5xx=1,4xx=5,2xx=100', instead of the dynamic sampler's. Codes take precedence over their class, and responses without a rate are sampled dynamically."`
+	SamplerType       string   `long:"sampler_type" env:"HONEYAWS_SAMPLER_TYPE" default:"simple" description:"Type of dynamic sampler to use. Options are 'simple' and 'ema'"`
+	SamplerInterval   int      `long:"sampler_interval" env:"HONEYAWS_SAMPLER_INTERVAL" default:"300" description:"Interval between sample rate calculation, in seconds."`
+	SamplerDecay      float64  `long:"sampler_decay" env:"HONEYAWS_SAMPLER_DECAY" default:"0.5" description:"Used only when sampler_type is set to 'ema'. A value between (0,1) that controls how fast new observations are factored into the moving average. Larger values mean the sample rates are more sensitive to recent observations."`
+	DynSampleKeys     []string `long:"dynsample_keys" env:"HONEYAWS_DYNSAMPLE_KEYS" env-delim:"," description:"Comma separated list of fields to key the dynamic sampler on, e.g. elb_status_code,request_path. Distinct combinations of values are sampled independently, so rare ones are kept at full fidelity. Defaults to the status codes and load balancer (or distribution) name."`
+	URLRules          string   `long:"url_rules" env:"HONEYAWS_URL_RULES" description:"YAML file of rules for normalizing paths, allowlisting query parameters and redacting sensitive values in URLs before events are sent. See the README."`
+	NoShaping         bool     `long:"no_shaping" env:"HONEYAWS_NO_SHAPING" description:"Skip parsing request URLs into request_uri, request_path, request_query, request_shape, request_queryshape and request_path_* fields, for higher throughput on very busy load balancers. request_method and request_protocol_version are still added, and --url_rules still apply."`
+	Fingerprint       bool     `long:"fingerprint" env:"HONEYAWS_FINGERPRINT" description:"Add a request_fingerprint field, a hash of the client's IP prefix (/24 or /48), user agent family and path shape, for grouping traffic that's likely from the same actor"`
+	CorrelationID     bool     `long:"correlation_id" env:"HONEYAWS_CORRELATION_ID" description:"Add a correlation_id field, which applications behind the load balancer can work out from the X-Amzn-Trace-Id header of the same request, for joining their events with the load balancer's. See the README."`
+	ParseUserAgent    bool     `long:"parse-user-agent" env:"HONEYAWS_PARSE_USER_AGENT" description:"Add ua_browser, ua_browser_version, ua_os, ua_device_type and ua_is_bot fields parsed from the user agent of ELB, ALB and CloudFront events"`
+	ProxyCIDRs        []string `long:"proxy_cidrs" env:"HONEYAWS_PROXY_CIDRS" env-delim:"," description:"Comma separated CIDRs (or IPs) of known proxies in front of the load balancer, e.g. a CDN. Events from clients in them are tagged with client_is_known_proxy=true, since their client IP isn't the real client's."`
+	CostFields        bool     `long:"cost_fields" env:"HONEYAWS_COST_FIELDS" description:"Add transfer_bytes, the bytes received and sent for each request, and pricing_region, the location the load balancer's region is priced as, for rough cost attribution"`
+	DurationUnit      string   `long:"duration-unit" env:"HONEYAWS_DURATION_UNIT" choice:"ms" choice:"s" description:"Send the processing times, total_time, time_to_first_byte, time_taken and the other latencies in milliseconds (ms) or seconds (s), whichever they're logged in, so they can be queried alongside application events. By default they're sent as logged: seconds for ELB, ALB and CloudFront, milliseconds for NLB and API Gateway"`
+	KeepFields        []string `long:"keep-fields" env:"HONEYAWS_KEEP_FIELDS" env-delim:"," description:"Only send these fields of events, e.g. elb_status_code,request_path_*. May be glob patterns, and may be repeated."`
+	DropFields        []string `long:"drop-fields" env:"HONEYAWS_DROP_FIELDS" env-delim:"," description:"Don't send these fields of events, e.g. high-cardinality or sensitive ones such as request_query. May be glob patterns, and may be repeated."`
+	RenameFields      []string `long:"rename-field" env:"HONEYAWS_RENAME_FIELD" env-delim:"," description:"Send a field of events under another name, as old=new, e.g. elb_status_code=http.status_code. Applied after --keep-fields and --drop-fields. May be repeated."`
+	AddFields         []string `long:"add-field" env:"HONEYAWS_ADD_FIELD" env-delim:"," description:"Add a field with a constant value to every event, as name=value, e.g. env=prod. Fields of the events themselves take precedence. May be repeated."`
+	HostMetadata      bool     `long:"host-metadata" env:"HONEYAWS_HOST_METADATA" description:"Add meta.hostname, meta.instance_id (on EC2) and meta.honeyaws_version fields to every event, so events can be traced back to the agent which ingested them"`
+	IPHandling        string   `long:"ip-handling" env:"HONEYAWS_IP_HANDLING" choice:"keep" choice:"drop" choice:"hash" choice:"truncate" default:"keep" description:"What to do with client IPs (client_authority, c_ip, client_ip and x_forwarded_for) before sending events: keep them, drop them, hash them with --ip-hash-key, or truncate them to their /24 (IPv4) or /48 (IPv6)"`
+	IPHashKey         string   `long:"ip-hash-key" env:"HONEYAWS_IP_HASH_KEY" description:"Secret key client IPs are hashed with for --ip-handling=hash, so they can't be recovered by hashing every IP. Defaults to a random key, so hashes change every run."`
+	CardinalityFields []string `long:"cardinality_fields" env:"HONEYAWS_CARDINALITY_FIELDS" env-delim:"," description:"Comma separated list of fields, e.g. request_path, to keep from having more than --cardinality_limit distinct values in a dataset. Values past the limit are replaced, and a warning event is sent."`
+	CardinalityLimit  int      `long:"cardinality_limit" env:"HONEYAWS_CARDINALITY_LIMIT" default:"10000" description:"Most distinct values each of --cardinality_fields may have in a dataset since startup"`
+	CardinalityAction string   `long:"cardinality_action" env:"HONEYAWS_CARDINALITY_ACTION" choice:"hash" choice:"truncate" default:"hash" description:"What to replace values past --cardinality_limit with: hash folds them into 256 hashed-xx values, truncate keeps their first path segment (e.g. /users/*), or first 8 characters"`
+	EventsPerMinute   int      `long:"max-events-per-minute" env:"HONEYAWS_MAX_EVENTS_PER_MINUTE" description:"Most events to send to Honeycomb a minute, to cap spend. Beyond it, the sample rate is raised automatically, and each minute throttled is reported with a budget_throttled event. 0 is no limit."`
+	BytesPerHour      int      `long:"max-bytes-per-hour" env:"HONEYAWS_MAX_BYTES_PER_HOUR" description:"Most bytes of events to send to Honeycomb an hour, to cap spend, the same way as --max-events-per-minute. 0 is no limit."`
+	EventSources      []string `long:"event-source" env:"HONEYAWS_EVENT_SOURCE" env-delim:"," description:"Only send CloudTrail events from this source, e.g. s3.amazonaws.com. May be a glob pattern, and may be repeated."`
+	EventNames        []string `long:"event-name" env:"HONEYAWS_EVENT_NAME" env-delim:"," description:"Only send CloudTrail events with this name, e.g. DeleteBucket. May be a glob pattern such as Delete*, and may be repeated."`
+	ExcludeReadOnly   bool     `long:"exclude-readonly" env:"HONEYAWS_EXCLUDE_READONLY" description:"Don't send read-only CloudTrail events, such as Describe* and List* calls"`
+	GlobalAccelerator bool     `long:"global_accelerator" env:"HONEYAWS_GLOBAL_ACCELERATOR" description:"For honeyflowlogs: list and ingest the flow logs of the Global Accelerator accelerators in the account, instead of VPC Flow Logs"`
+	EnrichTargets     bool     `long:"enrich_targets" env:"HONEYAWS_ENRICH_TARGETS" description:"Add target_group_name, instance_id, instance_name_tag and availability_zone fields for the targets of load balancer requests, looked up with the EC2 API"`
+	EnrichAuth        bool     `long:"enrich_auth" env:"HONEYAWS_ENRICH_AUTH" description:"Add auth_idp (oidc or cognito) and auth_provider (the OIDC issuer or Cognito user pool ARN) fields for ALB requests an authenticate action ran for, looked up from the listener rules with the ELBv2 API"`
+	ServiceCatalog    string   `long:"service_catalog" env:"HONEYAWS_SERVICE_CATALOG" description:"YAML or JSON file, or http(s):// URL, mapping load balancer and target group names to metadata such as owner, tier and oncall, added to their events as service_* fields. See the README."`
+	CatalogRefresh    int      `long:"service_catalog_refresh" env:"HONEYAWS_SERVICE_CATALOG_REFRESH" description:"Interval between reading --service_catalog again, in seconds" default:"300"`
+	ServiceNameRegex  []string `long:"service_name_regex" env:"HONEYAWS_SERVICE_NAME_REGEX" env-delim:";" description:"Regexp with a (?P<service>...) group, e.g. 'svc-(?P<service>[a-z]+)-prod', matched against the target group name, then the load balancer name, of each event to set service.name by naming convention. May be repeated."`
+	GenFormat         string   `long:"format" env:"HONEYAWS_FORMAT" description:"Format of the logs synthesized by generate, only alb for now" default:"alb"`
+	GenRate           string   `long:"rate" env:"HONEYAWS_RATE" description:"How many log lines generate synthesizes, e.g. 100/s or 600/m" default:"10/s"`
+	GenRoutes         []string `long:"route" env:"HONEYAWS_ROUTE" env-delim:"," description:"Route requested in the logs synthesized by generate, as 'METHOD /path[=weight]' where :params in the path are filled in at random, e.g. 'GET /users/:id=30'. May be repeated."`
+	GenStatusMix      string   `long:"status_mix" env:"HONEYAWS_STATUS_MIX" description:"Statuses of the requests synthesized by generate, as status=weight, e.g. 200=90,404=5,500=5" default:"200=90,304=4,404=4,500=1,504=1"`
+	GenLatency        string   `long:"latency" env:"HONEYAWS_LATENCY" description:"Median and 99th percentile latency of the requests synthesized by generate" default:"30ms,400ms"`
+	SandboxDataset    string   `long:"sandbox_dataset" env:"HONEYAWS_SANDBOX_DATASET" description:"Dataset generate sends synthesized events to, instead of --dataset" default:"aws-alb-sample"`
+	DownloadWorkers   int      `long:"download_workers" env:"HONEYAWS_DOWNLOAD_WORKERS" default:"4" description:"Number of log objects downloaded at once, across all of the load balancers (or distributions, or trails) being ingested"`
+	ParseWorkers      int      `long:"parse_workers" env:"HONEYAWS_PARSE_WORKERS" default:"2" description:"Number of downloaded log objects parsed and published at once"`
+	S3MaxConcurrency  int      `long:"s3-max-concurrency" env:"HONEYAWS_S3_MAX_CONCURRENCY" description:"Most S3 API calls (listing, downloading...) made at once, across all of the load balancers (or distributions, or trails) being ingested. 0 doesn't limit them."`
+	S3RequestRate     float64  `long:"s3-requests-per-second" env:"HONEYAWS_S3_REQUESTS_PER_SECOND" description:"Most S3 API calls made a second, across all of the load balancers being ingested, to stay clear of S3's throttling when backfilling big buckets. 0 doesn't limit them."`
+	DownloadPartSize  int      `long:"download-part-size" env:"HONEYAWS_DOWNLOAD_PART_SIZE" description:"Size in MB of the byte ranges log objects are downloaded in, several at once, so big (e.g. CloudFront or CloudTrail) objects download faster. Objects no bigger than this are downloaded with a single request." default:"5"`
+	DownloadPartConc  int      `long:"download-part-concurrency" env:"HONEYAWS_DOWNLOAD_PART_CONCURRENCY" description:"Number of byte ranges of each log object downloaded at once, see --download-part-size. 1 downloads objects a range at a time." default:"5"`
+	DownloadToFile    bool     `long:"download-to-file" env:"HONEYAWS_DOWNLOAD_TO_FILE" description:"Download each log object to a temporary file before parsing it, as older versions did, e.g. to debug parsing, instead of parsing it as it's streamed from S3"`
+	ProgressInterval  int      `long:"progress_interval" env:"HONEYAWS_PROGRESS_INTERVAL" description:"Seconds between logging each load balancer's (or distribution's, or trail's) progress through the backfill, and in total: objects processed and remaining, the oldest remaining and an ETA, also exported as metrics and recorded in the state for status. 0 doesn't count the objects to backfill or report progress." default:"60"`
+	MaxRetries        int      `long:"max_retries" env:"HONEYAWS_MAX_RETRIES" description:"Times to retry downloading a log object, or sending events Honeycomb responded to with a 429 or 5xx, backing off exponentially from 1s up to 1m. Objects still failing are recorded as dead letters in the state, see state dead-letters." default:"3"`
+	MissingRechecks   int      `long:"missing_rechecks" env:"HONEYAWS_MISSING_RECHECKS" description:"Times to check again for a log object S3 had nothing at when it was downloaded, e.g. one listed before it could be read during a backfill, --missing_recheck_delay apart, rather than retrying it right away. Objects still missing are recorded as such in the state, see state missing, rather than as dead letters. 0 retries them like any other failure." default:"3"`
+	RecheckDelay      int      `long:"missing_recheck_delay" env:"HONEYAWS_MISSING_RECHECK_DELAY" description:"Seconds between checks for a log object which is missing, see --missing_rechecks" default:"300"`
+	DeadLetterPath    string   `long:"dead-letter-path" env:"HONEYAWS_DEAD_LETTER_PATH" description:"Local file to append the log lines which couldn't be parsed to, or s3://bucket/prefix URL to upload them under, one JSON object per line with the object and line number. A parse_failures event counting them is sent to Honeycomb for each object with any."`
+	DrainTimeout      int      `long:"drain_timeout" env:"HONEYAWS_DRAIN_TIMEOUT" description:"Seconds to wait, on SIGTERM or SIGINT, for the objects being published to finish before exiting. Objects still unfinished then are resumed where they got to next time." default:"30"`
+	CheckPolicy       bool     `long:"check_bucket_policy" env:"HONEYAWS_CHECK_BUCKET_POLICY" description:"Check that the log bucket's policy still allows each load balancer's access logs to be delivered when starting to ingest it, logging an error if not"`
+	FixPolicy         bool     `long:"fix" env:"HONEYAWS_FIX" description:"Restore the statement allowing access logs to be delivered to the bucket policy when validate (or --check_bucket_policy) finds it missing. Requires s3:PutBucketPolicy."`
+	LogBucket         string   `long:"log_bucket" env:"HONEYAWS_LOG_BUCKET" description:"S3 bucket for enable-logging to have the load balancers deliver their access logs to, in their region"`
+	LogPrefix         string   `long:"log_prefix" env:"HONEYAWS_LOG_PREFIX" description:"Prefix in --log_bucket for enable-logging to have access logs delivered under"`
+	CreateBucket      bool     `long:"create_bucket" env:"HONEYAWS_CREATE_BUCKET" description:"Have enable-logging create --log_bucket if it doesn't exist, with a policy allowing access logs to be delivered to it"`
+	AWSTags           []string `long:"aws_tags" env:"HONEYAWS_AWS_TAGS" env-delim:"," description:"Tags to give the AWS resources the tools create, the --create_table table and the --create_bucket bucket, as comma separated key=value pairs, e.g. team=observability,cost-center=42. verify checks the --dynamo_table table has them."`
+	Bucket            string   `long:"bucket" env:"HONEYAWS_BUCKET" description:"Ingest whatever log objects are under s3://<bucket>/<prefix> directly, without looking up any load balancers or distributions, so that no elasticloadbalancing:Describe* or cloudfront:List* permissions are needed. Also the bucket replay objects downloads the dead letters from"`
+	Prefix            string   `long:"prefix" env:"HONEYAWS_PREFIX" description:"Prefix in --bucket to ingest the log objects under, e.g. AWSLogs/123456789012/elasticloadbalancing/"`
+	LogType           string   `long:"log_type" env:"HONEYAWS_LOG_TYPE" description:"Format of the logs under --bucket: alb, elb, nlb or cloudfront. Defaults to the tool's own."`
+	RequesterPays     bool     `long:"requester-pays" env:"HONEYAWS_REQUESTER_PAYS" description:"Agree to pay for listing and downloading the objects in the log bucket(s), which requester pays buckets require"`
+	KMSKeyARN         string   `long:"kms-key-arn" env:"HONEYAWS_KMS_KEY_ARN" description:"ARN of the KMS key the log bucket's objects are encrypted with (SSE-KMS), checked on startup; downloads denied access are told apart from objects encrypted with another key"`
+	ConnectionLogs    bool     `long:"connection-logs" env:"HONEYAWS_CONNECTION_LOGS" description:"Also ingest the connection logs of ALBs which have them enabled, an event per TLS handshake with its protocol, cipher, latency and client certificate"`
+	ClassicELBs       bool     `long:"classic-elbs" env:"HONEYAWS_CLASSIC_ELBS" description:"With honeyalb, also ingest the classic load balancers found, in the same process and through the same publisher as the ALBs"`
+	SQSQueueURL       string   `long:"sqs_queue_url" env:"HONEYAWS_SQS_QUEUE_URL" description:"URL of an SQS queue receiving S3 ObjectCreated notifications for the log bucket(s). New objects are ingested as they are delivered instead of by polling the bucket."`
+	InventoryManifest string   `long:"inventory_manifest" env:"HONEYAWS_INVENTORY_MANIFEST" description:"s3://bucket/key URL of the manifest.json of an S3 Inventory report (CSV) for the log bucket(s). Every object in it not yet processed is backfilled, regardless of --backfill, without listing the bucket."`
+	KinesisStream     string   `long:"kinesis_stream" env:"HONEYAWS_KINESIS_STREAM" description:"Name of a Kinesis data stream receiving CloudFront real-time logs to ingest from, instead of the distributions' standard logs in S3 (honeycloudfront only)"`
+	RealtimeFields    []string `long:"realtime_fields" env:"HONEYAWS_REALTIME_FIELDS" env-delim:"," description:"Comma separated list of the fields chosen in the CloudFront real-time log configuration, in order. Defaults to every available field."`
+	Role              string   `long:"role" env:"HONEYAWS_ROLE" choice:"lister" choice:"worker" description:"Split ingestion across processes: listers poll the log buckets and send new objects to --sqs_queue_url, and workers download and publish the objects from it. Requires --highavail."`
+	SetupDatasets     bool     `long:"setup-datasets" env:"HONEYAWS_SETUP_DATASETS" description:"On startup, create the dataset(s) events are sent to if they don't exist, along with the columns of known fields, typed and described (e.g. latencies as floats, status codes as integers), which they don't have yet. The write key needs permission to create datasets and columns."`
+	CreateMarkers     bool     `long:"create-markers" env:"HONEYAWS_CREATE_MARKERS" description:"Create Honeycomb markers on the dataset of a load balancer (or distribution, trail or flow log) when its backfill starts and finishes, and when it is newly discovered. The write key needs permission to create markers."`
+	HeartbeatInterval int      `long:"heartbeat_interval" env:"HONEYAWS_HEARTBEAT_INTERVAL" default:"0" description:"Interval between heartbeat events reporting the p50/p95/p99 backend_processing_time of each load balancer, in seconds. Computed over all traffic, before sampling. 0 disables heartbeats."`
+	RollupInterval    int      `long:"rollup_interval" env:"HONEYAWS_ROLLUP_INTERVAL" default:"0" description:"Also send a rollup event per load balancer per this many seconds (e.g. 60) with its exact request count, sent and received bytes, 4xx and 5xx counts and latency distribution, computed over all traffic before sampling. 0 disables rollups."`
+	RollupBy          []string `long:"rollup_by" env:"HONEYAWS_ROLLUP_BY" env-delim:"," choice:"route" choice:"status" description:"Also break the rollups down by route (request_shape) and/or status (elb_status_code), rather than just by load balancer. May be repeated."`
+	RollupsOnly       bool     `long:"rollups_only" env:"HONEYAWS_ROLLUPS_ONLY" description:"Only send the rollup events, not an event per request, for load balancers with too much traffic to keep even sampled requests. Requires --rollup_interval."`
+	FallbackWriteKey  string   `long:"fallback_writekey" env:"HONEYAWS_FALLBACK_WRITEKEY" description:"Honeycomb write key to fail over to if the primary write key is persistently rejected (revoked, over quota, etc.)"`
+	FallbackAPIHost   string   `long:"fallback_api_host" env:"HONEYAWS_FALLBACK_API_HOST" description:"Host for the Honeycomb API to use along with the fallback write key. Defaults to the primary API host."`
+	BatchSize         int      `long:"batch-size" env:"HONEYAWS_BATCH_SIZE" default:"500" description:"Most events to send to Honeycomb in one batch"`
+	BatchTimeout      int      `long:"batch-timeout" env:"HONEYAWS_BATCH_TIMEOUT" default:"100" description:"Milliseconds to wait for a batch to fill before sending it anyway"`
+	ConcurrentBatches int      `long:"max-concurrent-batches" env:"HONEYAWS_MAX_CONCURRENT_BATCHES" default:"80" description:"Most batches to be sending to Honeycomb at once"`
+	PendingWork       int      `long:"pending-work-capacity" env:"HONEYAWS_PENDING_WORK_CAPACITY" default:"10000" description:"Most events to queue up waiting to be sent. Events beyond it are dropped, and counted in honeyaws_queue_overflows_total, so raise it (or --max-concurrent-batches) if backfills overflow it."`
+	HTTPProxy         string   `long:"http_proxy" env:"HONEYAWS_HTTP_PROXY" description:"URL of the HTTP(S) proxy to send to the Honeycomb API through, e.g. an egress proxy. Defaults to the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables."`
+	FallbackAfter     int      `long:"fallback_after" env:"HONEYAWS_FALLBACK_AFTER" default:"10" description:"Number of consecutive events rejected by Honeycomb before failing over to the fallback write key"`
+	SpoolDir          string   `long:"spool_dir" env:"HONEYAWS_SPOOL_DIR" description:"Write events as Honeycomb batch API request bodies to files in this directory, for a forwarder to send on, instead of sending them to Honeycomb directly. --writekey isn't needed. See the README."`
+	SpoolCodec        string   `long:"spool_codec" env:"HONEYAWS_SPOOL_CODEC" choice:"none" choice:"gzip" choice:"zstd" choice:"lz4" description:"Compress the files written to --spool_dir with this codec, adding its extension (.gz, .zst or .lz4) to their names" default:"none"`
+	SpoolLevel        int      `long:"spool_level" env:"HONEYAWS_SPOOL_LEVEL" description:"Compression level of --spool_codec: 1-9 for gzip and lz4, 1-22 for zstd. 0 is the codec's default."`
+	Output            string   `long:"output" env:"HONEYAWS_OUTPUT" description:"Where events go: 'honeycomb' sends them to Honeycomb's events API, 'otlp' exports each as a span over OTLP/gRPC to --otlp_endpoint instead" default:"honeycomb"`
+	Sinks             []string `long:"sink" env:"HONEYAWS_SINKS" env-delim:"," description:"Where events are written, as 'honeycomb' (wherever --output says, the default), an s3://bucket/prefix URL for gzipped newline-delimited JSON objects, or a kafka://host:port/topic URL of a Kafka REST Proxy. May be repeated to write to each. See the README."`
+	OTLPEndpoint      string   `long:"otlp_endpoint" env:"HONEYAWS_OTLP_ENDPOINT" description:"host:port of the OTLP/gRPC endpoint (e.g. an OpenTelemetry collector, or api.honeycomb.io:443) --output=otlp exports spans to"`
+	OTLPInsecure      bool     `long:"otlp_insecure" env:"HONEYAWS_OTLP_INSECURE" description:"Export spans to --otlp_endpoint without TLS"`
+	OTLPHeaders       []string `long:"otlp_headers" env:"HONEYAWS_OTLP_HEADERS" env-delim:"," description:"Headers sent with exported spans, as name=value, e.g. x-honeycomb-team=<writekey>. May be repeated."`
+	DryRun            bool     `long:"dry-run" env:"HONEYAWS_DRY_RUN" description:"Download and parse logs as ingest would, but print sample events and statistics about their fields to stdout instead of sending them to Honeycomb, and don't write any state. --writekey isn't needed."`
+	DryRunSamples     int      `long:"dry-run-samples" env:"HONEYAWS_DRY_RUN_SAMPLES" description:"The number of sample events --dry-run prints before exiting" default:"10"`
+	DryRunProbe       bool     `long:"dry-run-probe" env:"HONEYAWS_DRY_RUN_PROBE" description:"With --dry-run, also send the first sample event of each dataset to Honeycomb with --writekey, tagged meta.dry_run_probe, to check the dataset name, the key's permissions and the event's size before a full run. This writes one event to each dataset."`
+	AssumeRoleARNs    []string `long:"assume_role_arn" env:"HONEYAWS_ASSUME_ROLE_ARN" env-delim:"," description:"ARN of an IAM role to assume for discovering load balancers and reading their logs in another AWS account. May be repeated to ingest from several accounts."`
+	TagFilters        []string `long:"tag_filter" env:"HONEYAWS_TAG_FILTER" env-delim:"," description:"Only discover and ingest load balancers with all of these tags, as comma separated key=value pairs, e.g. team=payments,env=prod. Load balancers that come to match are picked up while ingesting."`
+	DiscoverInterval  int      `long:"rediscover_interval" env:"HONEYAWS_REDISCOVER_INTERVAL" default:"300" description:"Interval between rediscovering load balancers while ingesting all of them (no names given), in seconds: new ones are ingested and deleted ones stopped. 0 disables rediscovery."`
+	Regions           []string `long:"regions" env:"HONEYAWS_REGIONS" env-delim:"," description:"Comma separated list of AWS regions to discover and ingest load balancers in, instead of just the default region. Events are tagged with their aws_region."`
+	AllRegions        bool     `long:"all_regions" env:"HONEYAWS_ALL_REGIONS" description:"Discover and ingest load balancers in every AWS region"`
+	MetricsAddr       string   `long:"metrics_addr" env:"HONEYAWS_METRICS_ADDR" description:"Address (e.g. :9090) to serve Prometheus metrics about the ingest pipeline on at /metrics"`
+	HealthAddr        string   `long:"health_addr" env:"HONEYAWS_HEALTH_ADDR" description:"Address (e.g. :8080) to serve /healthz and /readyz on, for liveness and readiness probes. Must differ from --metrics_addr."`
+	PIDFile           string   `long:"pid-file" env:"HONEYAWS_PID_FILE" description:"File to write the PID to while ingesting, e.g. /run/honeyalb.pid, for supervisors which track the process by it. Ingesting refuses to start if it has the PID of a process which is still running."`
+	PprofAddr         string   `long:"pprof-addr" env:"HONEYAWS_PPROF_ADDR" description:"Address (e.g. localhost:6060) to serve Go's runtime profiles on at /debug/pprof/, for finding where ingest spends its time. Leave it off public interfaces."`
+	AdminAddr         string   `long:"admin_addr" env:"HONEYAWS_ADMIN_ADDR" description:"Address (e.g. localhost:6061) to serve /reload on, which reloads the --config file on a POST, the same as SIGHUP. Leave it off public interfaces."`
+	CrashDataset      string   `long:"crash_dataset" env:"HONEYAWS_CRASH_DATASET" description:"Also send the report written to --statedir when the agent crashes to this Honeycomb dataset"`
+	AuditDataset      string   `long:"audit_dataset" env:"HONEYAWS_AUDIT_DATASET" description:"Also send an event for each object processed, with its size, line count, parse errors, duration and the number of events parsed from it, to this Honeycomb dataset, e.g. honeyaws-ops"`
+	QueryKey          string   `long:"query_key" env:"HONEYAWS_QUERY_KEY" description:"Honeycomb API key with permission to run queries, for verify-sampling to count the events Honeycomb has with the Query API"`
+	VerifyWindow      string   `long:"window" env:"HONEYAWS_WINDOW" description:"How far back verify-sampling compares the events in the logs with Honeycomb's count of them, e.g. 1h. The window ends 15 minutes ago, so that its logs have been ingested." default:"1h"`
+	OTelEndpoint      string   `long:"otel_endpoint" env:"HONEYAWS_OTEL_ENDPOINT" description:"host:port of an OTLP/HTTP endpoint (e.g. an OpenTelemetry collector) to send traces of the agent's own list, download, parse and publish operations to"`
+	OTelInsecure      bool     `long:"otel_insecure" env:"HONEYAWS_OTEL_INSECURE" description:"Send traces to --otel_endpoint over plain HTTP instead of HTTPS"`
+	LogFormat         string   `long:"log-format" env:"HONEYAWS_LOG_FORMAT" choice:"text" choice:"json" description:"Format of the agent's own log output: text, or json with a field per key for log aggregators" default:"text"`
+	LogLevel          string   `long:"log-level" env:"HONEYAWS_LOG_LEVEL" choice:"debug" choice:"info" choice:"warn" choice:"error" description:"Least severe level of the agent's own log output. --debug is the same as debug." default:"info"`
+
+	// LBConfigs are the per load balancer overrides from --config.
+	LBConfigs map[string]LBConfig `no-flag:"true"`
+
+	// DatasetConfigs are the per dataset destinations from --config.
+	DatasetConfigs map[string]DatasetConfig `no-flag:"true"`
+
+	// ExtractRules are the rules for extracting fields from --config.
+	ExtractRules []ExtractRule `no-flag:"true"`
+
+	// FanoutConfigs are the destinations sent a copy of the events from
+	// --config.
+	FanoutConfigs []FanoutConfig `no-flag:"true"`
+
+	// ReplayID is set by ingest for a --start-time range, for its events
+	// to be tagged with as replay.id and its markers to be created with.
+	ReplayID string `no-flag:"true"`
 
 	Version bool   `short:"V" long:"version" description:"Show version"`
-	APIHost string `hidden:"true" long:"api_host" description:"Host for the Honeycomb API" default:"https://api.honeycomb.io/"`
-	Debug   bool   `long:"debug" description:"Print debugging output"`
+	APIHost string `long:"api_host" env:"HONEYAWS_API_HOST" description:"Host for the Honeycomb API, e.g. https://api.eu1.honeycomb.io/ for EU teams" default:"https://api.honeycomb.io/"`
+	Debug   bool   `long:"debug" env:"HONEYAWS_DEBUG" description:"Print debugging output"`
+}
+
+// OutputOTLP is the --output exporting events as spans over OTLP.
+const OutputOTLP = "otlp"
+
+// SinkHoneycomb is the --sink sending events wherever --output says.
+const SinkHoneycomb = "honeycomb"
+
+// NeedsWriteKey reports whether events are sent to Honeycomb with --writekey,
+// rather than spooled, printed by --dry-run, exported over OTLP or only
+// written to other sinks.
+func (opt *Options) NeedsWriteKey() bool {
+	return opt.SpoolDir == "" && !opt.DryRun && opt.Output != OutputOTLP && opt.HoneycombSink()
+}
+
+// HoneycombSink reports whether events are sent wherever --output says: with
+// no --sink, or with SinkHoneycomb one of them.
+func (opt *Options) HoneycombSink() bool {
+	if len(opt.Sinks) == 0 {
+		return true
+	}
+	for _, sink := range opt.Sinks {
+		if sink == SinkHoneycomb {
+			return true
+		}
+	}
+	return false
 }
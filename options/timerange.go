@@ -0,0 +1,70 @@
+package options
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeRange returns the range of time --start-time and --end-time ingest the
+// logs of, with the end defaulting to now, or zero times without --start-time.
+// Ingesting a range is a one-off, so it can't be combined with the options
+// which keep ingesting, or which split the work between instances.
+//
+// With --mode=backfill the range defaults to the --backfill hours before now.
+func (opt *Options) TimeRange(now time.Time) (start, end time.Time, err error) {
+	name := "--start-time"
+	switch {
+	case opt.Mode == ModeTail && opt.StartTime != "":
+		return start, end, fmt.Errorf("--start-time can't be used with --mode=tail")
+	case opt.Mode == ModeBackfill && opt.StartTime == "":
+		if opt.EndTime != "" {
+			return start, end, fmt.Errorf("--end-time requires --start-time")
+		}
+		name = "--mode=backfill"
+		start = now.Add(-time.Duration(opt.BackfillHr) * time.Hour)
+	case opt.StartTime == "":
+		if opt.EndTime != "" {
+			return start, end, fmt.Errorf("--end-time requires --start-time")
+		}
+		return start, end, nil
+	default:
+		if start, err = time.Parse(time.RFC3339, opt.StartTime); err != nil {
+			return start, end, fmt.Errorf("--start-time must be an RFC3339 time, e.g. 2018-08-20T14:00:00Z: %s", err)
+		}
+	}
+	end = now
+	if opt.EndTime != "" {
+		if end, err = time.Parse(time.RFC3339, opt.EndTime); err != nil {
+			return start, end, fmt.Errorf("--end-time must be an RFC3339 time, e.g. 2018-08-20T16:00:00Z: %s", err)
+		}
+	}
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("--start-time must be before --end-time")
+	}
+
+	switch {
+	case opt.Shard:
+		err = fmt.Errorf("%s can't be used with --shard", name)
+	case opt.Role != "":
+		err = fmt.Errorf("%s can't be used with --role", name)
+	case opt.SQSQueueURL != "":
+		err = fmt.Errorf("%s can't be used with --sqs_queue_url", name)
+	case opt.InventoryManifest != "":
+		err = fmt.Errorf("%s can't be used with --inventory_manifest", name)
+	case opt.KinesisStream != "":
+		err = fmt.Errorf("%s can't be used with --kinesis_stream", name)
+	}
+	return start, end, err
+}
+
+// Since returns the time of --since, or zero without it.
+func (opt *Options) Since() (time.Time, error) {
+	if opt.StateSince == "" {
+		return time.Time{}, nil
+	}
+	since, err := time.Parse(time.RFC3339, opt.StateSince)
+	if err != nil {
+		return since, fmt.Errorf("--since must be an RFC3339 time, e.g. 2018-08-20T14:00:00Z: %s", err)
+	}
+	return since, nil
+}
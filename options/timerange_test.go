@@ -0,0 +1,40 @@
+package options
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRange(t *testing.T) {
+	now := time.Date(2018, 8, 21, 0, 0, 0, 0, time.UTC)
+
+	start, end, err := (&Options{}).TimeRange(now)
+	if err != nil || !start.IsZero() || !end.IsZero() {
+		t.Errorf("expected no range without --start-time, got %s-%s, %v", start, end, err)
+	}
+
+	start, end, err = (&Options{StartTime: "2018-08-20T14:00:00Z", EndTime: "2018-08-20T16:00:00+00:00"}).TimeRange(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2018, 8, 20, 14, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2018, 8, 20, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 14:00-16:00, got %s-%s", start, end)
+	}
+
+	// the end defaults to now
+	if _, end, err = (&Options{StartTime: "2018-08-20T14:00:00Z"}).TimeRange(now); err != nil || !end.Equal(now) {
+		t.Errorf("expected the range to end now, got %s, %v", end, err)
+	}
+
+	for _, opt := range []*Options{
+		{EndTime: "2018-08-20T16:00:00Z"},
+		{StartTime: "last tuesday"},
+		{StartTime: "2018-08-20T16:00:00Z", EndTime: "2018-08-20T14:00:00Z"},
+		{StartTime: "2018-08-20T14:00:00Z", Shard: true},
+		{StartTime: "2018-08-20T14:00:00Z", SQSQueueURL: "https://sqs.us-east-1.amazonaws.com/12345/logs"},
+	} {
+		if _, _, err := opt.TimeRange(now); err == nil {
+			t.Errorf("expected an error for --start-time %q --end-time %q", opt.StartTime, opt.EndTime)
+		}
+	}
+}
@@ -1,83 +1,126 @@
 package publisher
 
 import (
-	"bufio"
-	"compress/gzip"
 	"fmt"
 	"math/rand"
-	"os"
-	"runtime"
+	"path"
+	"regexp"
 	"strings"
 
-	dynsampler "github.com/honeycombio/dynsampler-go"
+	"github.com/honeycombio/honeyaws/logbucket"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/sampler"
 	"github.com/honeycombio/honeyaws/state"
 	"github.com/honeycombio/honeytail/event"
-	"github.com/honeycombio/honeytail/parsers/nginx"
+	"github.com/honeycombio/honeytail/httime"
 	"github.com/sirupsen/logrus"
 )
 
 type ALBEventParser struct {
-	sampler dynsampler.Sampler
+	dynamicSampling
 }
 
 func NewALBEventParser(opt *options.Options) *ALBEventParser {
-	s, err := sampler.NewSamplerFromOptions(opt)
-	if err != nil {
-		logrus.WithField("err", err).Fatal("couldn't build sampler from arguments")
-	}
+	return &ALBEventParser{dynamicSampling: newDynamicSampling(opt)}
+}
 
-	ep := &ALBEventParser{sampler: s}
+// albFormat is the ALB log format, parsed in place rather than with the
+// nginx parser's regexp since ALB logs are the bulk of what's ingested.
+var albFormat = mustCompileLineFormat(logFormat, AWSApplicationLoadBalancerFormat)
+
+// albExtraFields are the fields ALBs have added to the end of their log lines
+// since, in order, which lines have as many of as the load balancer logged
+// when they were written. They're parsed after the aws_alb format, which has
+// to stay as the nginx parser had it.
+var albExtraFields = []string{
+	"actions_executed",
+	"redirect_url",
+	"error_reason",
+	"target_port_list",
+	"target_status_code_list",
+	"classification",
+	"classification_reason",
+	"conn_trace_id",
+}
 
-	if err := ep.sampler.Start(); err != nil {
-		logrus.WithField("err", err).Fatal("Couldn't start dynamic sampler")
+// parseALBExtraFields adds the albExtraFields in rest, the line after the
+// aws_alb format, to data. They're quoted, except for conn_trace_id, and
+// typed and left out when they're "-" like the others.
+func parseALBExtraFields(rest string, data map[string]interface{}) {
+	for _, field := range albExtraFields {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			return
+		}
+		var v string
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return
+			}
+			v, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			v, rest = rest[:end], rest[end:]
+		}
+		if typed, ok := typedValue(v); ok {
+			data[field] = typed
+		}
 	}
-
-	return ep
 }
 
-func (ep *ALBEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
-	np := &nginx.Parser{}
-	err := np.Init(&nginx.Options{
-		ConfigFile:      formatFileName,
-		TimeFieldName:   "timestamp",
-		TimeFieldFormat: "2006-01-02T15:04:05.9999Z",
-		LogFormatName:   AWSApplicationLoadBalancerFormat,
-		NumParsers:      runtime.NumCPU(),
-	})
-	if err != nil {
-		logrus.Fatal("Can't initialize the nginx parser")
-	}
+// albTimeFormat is the format of request_creation_time, which is used as the
+// event's timestamp.
+const albTimeFormat = "2006-01-02T15:04:05.9999Z"
 
-	linesCh := make(chan string)
+// classicELBKey matches the access logs of classic load balancers, which
+// honeyalb ingests alongside ALBs with --classic_elbs. Their names can't have
+// dots, unlike app.<name>.<id>.
+var classicELBKey = regexp.MustCompile(`^\d+_elasticloadbalancing_[a-z0-9-]+_[A-Za-z0-9-]+_\d{8}T\d{4}Z_`)
 
-	go np.ProcessLines(linesCh, out, nil)
+func (ep *ALBEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	if classicELBKey.MatchString(path.Base(obj.Object)) {
+		// the sampler keys are the same, so the events are sampled
+		// along with the ALBs'
+		return (&ELBEventParser{}).ParseEvents(obj, out)
+	}
 
-	f, err := os.Open(obj.Filename)
+	r, err := logbucket.Open(obj)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
+	defer r.Close()
 
-	r, err := gzip.NewReader(f)
-	if err != nil {
-		return err
-	}
+	scanner := newLineScanner(obj, r)
 
-	scanner := bufio.NewScanner(r)
+	if lbID, ok := connectionLogLB(obj.Object); ok {
+		return parseConnectionLog(scanner, lbID, out)
+	}
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		linesCh <- line
+		data, n, err := albFormat.parsePrefix(line)
+		if err != nil {
+			scanner.unparseable(line, err)
+			continue
+		}
+		parseALBExtraFields(line[n:], data)
+		addLatencyFields(data)
+		addErrorClass(data)
+		addAuthFields(data)
+		out <- event.Event{
+			Timestamp: httime.GetTimestamp(data, "timestamp", albTimeFormat),
+			Data:      data,
+		}
 	}
 
-	close(linesCh)
-
 	return scanner.Err()
 }
 
@@ -85,6 +128,11 @@ func (ep *ALBEventParser) DynSample(in <-chan event.Event, out chan<- event.Even
 	for ev := range in {
 		// use backend_status_code and elb_status_code to set sample rate
 		var key string
+		// connection log events have no status codes, just whether
+		// the client's certificate was verified
+		if verifyStatus, ok := ev.Data["tls_verify_status"].(string); ok {
+			key = verifyStatus
+		}
 		if backendStatusCode, ok := ev.Data["backend_status_code"]; ok {
 			if bsc, ok := backendStatusCode.(int64); ok {
 				key = fmt.Sprintf("%d", bsc)
@@ -118,6 +166,11 @@ func (ep *ALBEventParser) DynSample(in <-chan event.Event, out chan<- event.Even
 			}
 		}
 
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
 		rate := ep.sampler.GetSampleRate(key)
 		if rate <= 0 {
 			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
@@ -126,6 +179,8 @@ func (ep *ALBEventParser) DynSample(in <-chan event.Event, out chan<- event.Even
 		if rand.Intn(rate) == 0 {
 			ev.SampleRate = rate
 			out <- ev
+		} else {
+			discard(ev)
 		}
 	}
 }
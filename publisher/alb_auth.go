@@ -0,0 +1,252 @@
+package publisher
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// The auth_action values.
+const (
+	authAuthenticated = "authenticated"
+	authRedirected    = "redirected"
+	authDenied        = "denied"
+	authError         = "error"
+)
+
+// hasAction reports whether the ALB event's actions_executed, e.g.
+// authenticate,forward, has the action.
+func hasAction(data map[string]interface{}, action string) bool {
+	actions, _ := data["actions_executed"].(string)
+	for _, a := range strings.Split(actions, ",") {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// addAuthFields adds auth_action to ALB events of requests which an
+// authenticate-oidc or authenticate-cognito action ran for, saying what
+// came of it:
+//
+//   - authenticated, the user was authenticated and the request passed on
+//     to the rule's next action
+//   - redirected, the user was redirected to the identity provider to log
+//     in, or back from it to what they requested
+//   - denied, the user wasn't authenticated and the rule denies them (401)
+//   - error, authenticating the user failed, with an Auth error_reason
+//
+// The log doesn't say who the user is, only whether they were authenticated.
+func addAuthFields(data map[string]interface{}) {
+	if !hasAction(data, "authenticate") {
+		return
+	}
+	actions, _ := data["actions_executed"].(string)
+	status, _ := data["elb_status_code"].(int64)
+	reason, _ := data["error_reason"].(string)
+	switch {
+	case strings.HasPrefix(reason, "Auth") || status == 561:
+		data["auth_action"] = authError
+	case status == 401:
+		data["auth_action"] = authDenied
+	case strings.HasSuffix(actions, "authenticate") && status == 302:
+		data["auth_action"] = authRedirected
+	default:
+		data["auth_action"] = authAuthenticated
+	}
+}
+
+// authRuleKey is a listener rule of a load balancer, by the port of its
+// listener and its priority, which ALBs log as matched_rule_priority: 0 for
+// the default rule.
+type authRuleKey struct {
+	port     int64
+	priority int64
+}
+
+// authProvider is the identity provider of a rule's authenticate action.
+type authProvider struct {
+	// idp is oidc or cognito.
+	idp string
+	// provider is the OIDC issuer, or the Cognito user pool's ARN.
+	provider string
+}
+
+type cachedAuthRules struct {
+	rules   map[authRuleKey]authProvider
+	expires time.Time
+}
+
+// AuthEnricher adds the identity provider of the authenticate action which
+// ran for each request, going by the load balancer's listener rules, which
+// are looked up with the ELBv2 API of the account and region of the load
+// balancer the event came from. See --enrich_auth.
+type AuthEnricher struct {
+	*sync.Mutex
+	sessions map[string]*session.Session
+	cache    map[string]cachedAuthRules
+
+	// describeAuthRules looks up the rules of the named load balancer
+	// which authenticate, and is swapped out in tests.
+	describeAuthRules func(sess *session.Session, lbName string) (map[authRuleKey]authProvider, error)
+}
+
+func NewAuthEnricher() *AuthEnricher {
+	return &AuthEnricher{
+		Mutex:             &sync.Mutex{},
+		sessions:          make(map[string]*session.Session),
+		cache:             make(map[string]cachedAuthRules),
+		describeAuthRules: describeAuthRules,
+	}
+}
+
+// Add registers the session to look up the rules of the named load balancer
+// with.
+func (e *AuthEnricher) Add(lbName string, sess *session.Session) {
+	e.Lock()
+	defer e.Unlock()
+	e.sessions[lbName] = sess
+}
+
+// authActionProvider returns the provider of the first authenticate action
+// of the actions, if there is one.
+func authActionProvider(actions []*elbv2.Action) (authProvider, bool) {
+	for _, action := range actions {
+		switch aws.StringValue(action.Type) {
+		case elbv2.ActionTypeEnumAuthenticateOidc:
+			if action.AuthenticateOidcConfig != nil {
+				return authProvider{"oidc", aws.StringValue(action.AuthenticateOidcConfig.Issuer)}, true
+			}
+		case elbv2.ActionTypeEnumAuthenticateCognito:
+			if action.AuthenticateCognitoConfig != nil {
+				return authProvider{"cognito", aws.StringValue(action.AuthenticateCognitoConfig.UserPoolArn)}, true
+			}
+		}
+	}
+	return authProvider{}, false
+}
+
+func describeAuthRules(sess *session.Session, lbName string) (map[authRuleKey]authProvider, error) {
+	svc := elbv2.New(sess)
+	lbs, err := svc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+		Names: []*string{aws.String(lbName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[authRuleKey]authProvider)
+	for _, lb := range lbs.LoadBalancers {
+		var listeners []*elbv2.Listener
+		err := svc.DescribeListenersPages(&elbv2.DescribeListenersInput{
+			LoadBalancerArn: lb.LoadBalancerArn,
+		}, func(page *elbv2.DescribeListenersOutput, lastPage bool) bool {
+			listeners = append(listeners, page.Listeners...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, listener := range listeners {
+			input := &elbv2.DescribeRulesInput{ListenerArn: listener.ListenerArn}
+			for {
+				resp, err := svc.DescribeRules(input)
+				if err != nil {
+					return nil, err
+				}
+				for _, rule := range resp.Rules {
+					provider, ok := authActionProvider(rule.Actions)
+					if !ok {
+						continue
+					}
+					key := authRuleKey{port: aws.Int64Value(listener.Port)}
+					if !aws.BoolValue(rule.IsDefault) {
+						if key.priority, err = strconv.ParseInt(aws.StringValue(rule.Priority), 10, 64); err != nil {
+							continue
+						}
+					}
+					rules[key] = provider
+				}
+				if resp.NextMarker == nil {
+					break
+				}
+				input.Marker = resp.NextMarker
+			}
+		}
+	}
+	return rules, nil
+}
+
+// requestPort returns the port of the listener the ALB event's request came
+// to, which is in its URL, e.g. GET https://example.com:443/ HTTP/1.1.
+func requestPort(data map[string]interface{}) (int64, bool) {
+	request, _ := data["request"].(string)
+	parts := strings.Fields(request)
+	if len(parts) < 2 {
+		return 0, false
+	}
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return 0, false
+	}
+	port, err := strconv.ParseInt(u.Port(), 10, 64)
+	return port, err == nil
+}
+
+func (e *AuthEnricher) lookup(lbName string, sess *session.Session) map[authRuleKey]authProvider {
+	if cached, ok := e.cache[lbName]; ok && time.Now().Before(cached.expires) {
+		return cached.rules
+	}
+	rules, err := e.describeAuthRules(sess, lbName)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"lb":    lbName,
+			"error": err,
+		}).Error("Could not look up load balancer rules")
+	}
+	// the rules change about as often as the targets do
+	e.cache[lbName] = cachedAuthRules{rules, time.Now().Add(targetCacheTTL)}
+	return rules
+}
+
+func (e *AuthEnricher) enrich(data map[string]interface{}) {
+	if _, ok := data["auth_action"]; !ok {
+		return
+	}
+	elb, _ := data["elb"].(string)
+	priority, ok := data["matched_rule_priority"].(int64)
+	if elb == "" || !ok {
+		return
+	}
+	port, ok := requestPort(data)
+	if !ok {
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	name := lbName(elb)
+	sess, ok := e.sessions[name]
+	if !ok {
+		return
+	}
+	if provider, ok := e.lookup(name, sess)[authRuleKey{port, priority}]; ok {
+		data["auth_idp"] = provider.idp
+		data["auth_provider"] = provider.provider
+	}
+}
+
+func (e *AuthEnricher) enrichEvents(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		e.enrich(ev.Data)
+		out <- ev
+	}
+}
@@ -0,0 +1,93 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func TestAddAuthFields(t *testing.T) {
+	testCases := []struct {
+		data     map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"actions_executed": "forward", "elb_status_code": int64(200)}, ""},
+		{map[string]interface{}{"actions_executed": "authenticate,forward", "elb_status_code": int64(200)}, authAuthenticated},
+		{map[string]interface{}{"actions_executed": "waf,authenticate", "elb_status_code": int64(302)}, authRedirected},
+		{map[string]interface{}{"actions_executed": "authenticate", "elb_status_code": int64(401)}, authDenied},
+		{map[string]interface{}{"actions_executed": "authenticate", "elb_status_code": int64(500), "error_reason": "AuthTokenEpRequestTimeout"}, authError},
+		{map[string]interface{}{"actions_executed": "authenticate", "elb_status_code": int64(561)}, authError},
+	}
+	for _, tc := range testCases {
+		addAuthFields(tc.data)
+		if action, _ := tc.data["auth_action"].(string); action != tc.expected {
+			t.Errorf("%v: expected auth_action %q, got %q", tc.data, tc.expected, action)
+		}
+	}
+}
+
+func TestAuthActionProvider(t *testing.T) {
+	provider, ok := authActionProvider([]*elbv2.Action{
+		{Type: aws.String(elbv2.ActionTypeEnumAuthenticateCognito), AuthenticateCognitoConfig: &elbv2.AuthenticateCognitoActionConfig{UserPoolArn: aws.String("arn:aws:cognito-idp:us-east-1:123456789012:userpool/us-east-1_abc")}},
+		{Type: aws.String(elbv2.ActionTypeEnumForward)},
+	})
+	if !ok || provider != (authProvider{"cognito", "arn:aws:cognito-idp:us-east-1:123456789012:userpool/us-east-1_abc"}) {
+		t.Errorf("unexpected provider %+v", provider)
+	}
+	if _, ok := authActionProvider([]*elbv2.Action{{Type: aws.String(elbv2.ActionTypeEnumForward)}}); ok {
+		t.Error("expected no provider for rules which don't authenticate")
+	}
+}
+
+func TestAuthEnricher(t *testing.T) {
+	sess := session.Must(session.NewSession())
+	e := NewAuthEnricher()
+	e.Add("foo-alb", sess)
+
+	lookups := 0
+	e.describeAuthRules = func(s *session.Session, lbName string) (map[authRuleKey]authProvider, error) {
+		lookups++
+		return map[authRuleKey]authProvider{
+			{port: 443, priority: 0}: {"oidc", "https://accounts.example.com"},
+			{port: 443, priority: 2}: {"cognito", "arn:aws:cognito-idp:us-east-1:123456789012:userpool/us-east-1_abc"},
+		}, nil
+	}
+
+	event := func(request string, priority int64) map[string]interface{} {
+		data := map[string]interface{}{
+			"elb":                   "app/foo-alb/1db0c9806095122a",
+			"request":               request,
+			"matched_rule_priority": priority,
+			"actions_executed":      "authenticate,forward",
+			"elb_status_code":       int64(200),
+		}
+		addAuthFields(data)
+		e.enrich(data)
+		return data
+	}
+	if data := event("GET https://app.example.com:443/ HTTP/1.1", 0); data["auth_idp"] != "oidc" || data["auth_provider"] != "https://accounts.example.com" {
+		t.Errorf("expected the default rule's provider, got %v", data)
+	}
+	if data := event("GET https://app.example.com:443/admin HTTP/1.1", 2); data["auth_idp"] != "cognito" {
+		t.Errorf("expected the matched rule's provider, got %v", data)
+	}
+	if data := event("GET http://app.example.com:80/ HTTP/1.1", 0); data["auth_idp"] != nil {
+		t.Errorf("expected no provider for another listener, got %v", data)
+	}
+	if lookups != 1 {
+		t.Errorf("expected the rules to be looked up once, got %d", lookups)
+	}
+
+	data := map[string]interface{}{
+		"elb":                   "app/foo-alb/1db0c9806095122a",
+		"request":               "GET https://app.example.com:443/ HTTP/1.1",
+		"matched_rule_priority": int64(0),
+		"actions_executed":      "forward",
+	}
+	e.enrich(data)
+	if _, ok := data["auth_idp"]; ok {
+		t.Errorf("expected requests which weren't authenticated to be left alone, got %v", data)
+	}
+}
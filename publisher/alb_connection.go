@@ -0,0 +1,55 @@
+package publisher
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/httime"
+)
+
+// albConnectionFormat is the format of ALB connection logs, which record the
+// TLS handshake of each connection rather than its requests.
+var albConnectionFormat = mustCompileLineFormat(logFormat, AWSApplicationLoadBalancerConnectionFormat)
+
+// connectionLogKey matches the load balancer in a connection log object's
+// key, e.g. conn_log.123_elasticloadbalancing_us-east-1_app.my-lb.1db0c9806095122a_20231004T1800Z_10.0.0.1_abcd.log.gz
+var connectionLogKey = regexp.MustCompile(`^conn_log\..*_app\.([^.]+)\.([0-9a-f]+)_`)
+
+// connectionLogLB returns the load balancer whose connection log the object
+// is, as it's named in the elb field of access log events, or false if the
+// object isn't a connection log.
+func connectionLogLB(key string) (string, bool) {
+	m := connectionLogKey.FindStringSubmatch(path.Base(key))
+	if m == nil {
+		return "", false
+	}
+	return "app/" + m[1] + "/" + m[2], true
+}
+
+func parseConnectionLog(scanner *lineScanner, lbID string, out chan<- event.Event) error {
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		data, err := albConnectionFormat.parse(line)
+		if err != nil {
+			// lines logged before conn_trace_id was added end at
+			// tls_verify_status
+			if data, err = albConnectionFormat.parse(line + " -"); err != nil {
+				scanner.unparseable(line, err)
+				continue
+			}
+		}
+		data["elb"] = lbID
+		data["log_type"] = "connection"
+		out <- event.Event{
+			Timestamp: httime.GetTimestamp(data, "timestamp", albTimeFormat),
+			Data:      data,
+		}
+	}
+
+	return scanner.Err()
+}
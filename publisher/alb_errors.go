@@ -0,0 +1,80 @@
+package publisher
+
+import (
+	"strings"
+)
+
+// The error_class values.
+const (
+	errorClassClient = "client"
+	errorClassTarget = "target"
+	errorClassLB     = "lb"
+	errorClassAuth   = "auth"
+)
+
+// clientErrorReasons are the error_reason codes of Lambda targets which are
+// down to the request rather than the function, see
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#error-reason-codes
+var clientErrorReasons = map[string]bool{
+	"LambdaBadRequest":      true,
+	"LambdaRequestTooLarge": true,
+}
+
+// addErrorClass adds error_class to ALB events of requests which failed,
+// saying whose failure it was:
+//
+//   - auth, authenticating the user with the identity provider or Cognito
+//     failed, i.e. error_reason is one of the Auth codes or the load
+//     balancer responded with 401 or 561
+//   - target, the target failed: it responded with a 5xx, error_reason is a
+//     Lambda or Target code, or the load balancer responded with 502 or 504
+//     since the target closed the connection, sent a malformed response or
+//     timed out
+//   - client, the request was bad: the target or the load balancer
+//     responded with a 4xx, or the client closed the connection (460)
+//   - lb, the load balancer failed otherwise, e.g. a 503 for having no
+//     healthy targets, or an error_reason which isn't one of the above
+//
+// Requests which got a response below 400 have no error_class.
+func addErrorClass(data map[string]interface{}) {
+	if class := errorClass(data); class != "" {
+		data["error_class"] = class
+	}
+}
+
+func errorClass(data map[string]interface{}) string {
+	elbStatus, _ := data["elb_status_code"].(int64)
+	backendStatus, hasBackend := data["backend_status_code"].(int64)
+	if elbStatus < 400 && (!hasBackend || backendStatus < 400) {
+		return ""
+	}
+
+	if reason, ok := data["error_reason"].(string); ok {
+		switch {
+		case strings.HasPrefix(reason, "Auth"):
+			return errorClassAuth
+		case clientErrorReasons[reason]:
+			return errorClassClient
+		case strings.HasPrefix(reason, "Lambda"), strings.HasPrefix(reason, "Target"):
+			return errorClassTarget
+		}
+		return errorClassLB
+	}
+
+	switch {
+	case hasBackend && backendStatus >= 500:
+		return errorClassTarget
+	case hasBackend && backendStatus >= 400:
+		return errorClassClient
+	}
+	switch elbStatus {
+	case 401, 561:
+		return errorClassAuth
+	case 502, 504:
+		return errorClassTarget
+	}
+	if elbStatus < 500 {
+		return errorClassClient
+	}
+	return errorClassLB
+}
@@ -0,0 +1,66 @@
+package publisher
+
+import (
+	"testing"
+)
+
+func TestParseALBExtraFields(t *testing.T) {
+	line := `https 2026-10-14T09:00:57.975041Z app/my-lb/50dc6c495c0c9188 10.11.12.13:47882 - -1 -1 -1 502 - 766 272 "GET https://api.example.com:443/users/1 HTTP/1.1" "curl/7.79.1" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-1:000000000000:targetgroup/users/73e2d6bc24d8a067 "Root=1-5e71404d-84277a47a826ab3d2e844170" "api.example.com" "-" 0 2026-10-14T09:00:57.960000Z "waf,forward" "-" "LambdaInvalidResponse" "-" "-" "Acceptable" "-" TID_1ec4033e7f5c6e4c87dbc357ba6f1c7e`
+	data, n, err := albFormat.parsePrefix(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseALBExtraFields(line[n:], data)
+	expected := map[string]interface{}{
+		"actions_executed": "waf,forward",
+		"error_reason":     "LambdaInvalidResponse",
+		"classification":   "Acceptable",
+		"conn_trace_id":    "TID_1ec4033e7f5c6e4c87dbc357ba6f1c7e",
+	}
+	for field, v := range expected {
+		if data[field] != v {
+			t.Errorf("expected %s to be %v, got %v", field, v, data[field])
+		}
+	}
+	for _, field := range []string{"redirect_url", "target_port_list", "target_status_code_list", "classification_reason"} {
+		if _, ok := data[field]; ok {
+			t.Errorf("expected %s to be left out as -, got %v", field, data[field])
+		}
+	}
+
+	// lines from before the fields were added have none of them
+	data = map[string]interface{}{}
+	parseALBExtraFields("", data)
+	parseALBExtraFields(` "forward" "-"`, data)
+	if len(data) != 1 || data["actions_executed"] != "forward" {
+		t.Errorf("expected only actions_executed, got %v", data)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	testCases := []struct {
+		data     map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"elb_status_code": int64(200), "backend_status_code": int64(200)}, ""},
+		{map[string]interface{}{"elb_status_code": int64(302), "actions_executed": "redirect"}, ""},
+		{map[string]interface{}{"elb_status_code": int64(500), "error_reason": "AuthInvalidIdToken"}, errorClassAuth},
+		{map[string]interface{}{"elb_status_code": int64(561)}, errorClassAuth},
+		{map[string]interface{}{"elb_status_code": int64(401)}, errorClassAuth},
+		{map[string]interface{}{"elb_status_code": int64(502), "error_reason": "LambdaInvalidResponse"}, errorClassTarget},
+		{map[string]interface{}{"elb_status_code": int64(502), "error_reason": "TargetTLSNegotiationError"}, errorClassTarget},
+		{map[string]interface{}{"elb_status_code": int64(413), "error_reason": "LambdaRequestTooLarge"}, errorClassClient},
+		{map[string]interface{}{"elb_status_code": int64(500), "error_reason": "SomethingNew"}, errorClassLB},
+		{map[string]interface{}{"elb_status_code": int64(503), "backend_status_code": int64(503)}, errorClassTarget},
+		{map[string]interface{}{"elb_status_code": int64(404), "backend_status_code": int64(404)}, errorClassClient},
+		{map[string]interface{}{"elb_status_code": int64(504)}, errorClassTarget},
+		{map[string]interface{}{"elb_status_code": int64(460)}, errorClassClient},
+		{map[string]interface{}{"elb_status_code": int64(503)}, errorClassLB},
+	}
+	for _, tc := range testCases {
+		addErrorClass(tc.data)
+		if class, _ := tc.data["error_class"].(string); class != tc.expected {
+			t.Errorf("%v: expected error_class %q, got %q", tc.data, tc.expected, class)
+		}
+	}
+}
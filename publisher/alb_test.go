@@ -8,14 +8,16 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/honeycombio/dynsampler-go"
 	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
 	"github.com/honeycombio/honeyaws/state"
 	"github.com/honeycombio/honeytail/event"
 )
 
 func TestALBParseEvents(t *testing.T) {
 	elbPubisher := NewALBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"})
-	outCh := make(chan event.Event)
+	outCh := make(chan event.Event, 1)
 	tmpFile, err := ioutil.TempFile("", "")
 	if err != nil {
 		t.Fatal("Shouldn't have err but did: ", err)
@@ -61,6 +63,11 @@ func TestALBParseEvents(t *testing.T) {
 		"matched_rule_priority":    int64(0),
 		"chosen_cert_arn":          "certARN",
 		"target_group_arn":         "groupARN",
+		"target_timeout":           false,
+		"actions_executed":         "forward",
+		"target_port_list":         "10.11.12.13:80",
+		"target_status_code_list":  int64(201),
+		"error_class":              "target",
 	}
 	ev := <-outCh
 	close(outCh)
@@ -83,3 +90,152 @@ func TestALBParseEvents(t *testing.T) {
 		t.Fatalf("actual duration_ms: %v, expected: %v", ev.Data["duration_ms"], excpectedDurMs)
 	}
 }
+
+func TestALBParseConnectionLogEvents(t *testing.T) {
+	ep := NewALBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"})
+	outCh := make(chan event.Event, 2)
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	zipper := gzip.NewWriter(tmpFile)
+	if _, err := zipper.Write([]byte(`2023-10-04T17:59:44.588150Z 203.0.113.1 36280 443 TLSv1.2 ECDHE-RSA-AES128-GCM-SHA256 4.036 "CN=client.example.com,O=Example,C=US" NotBefore=2023-09-21T22:43:21Z;NotAfter=2026-06-17T22:43:21Z FEF257753A94ECBF Success TID_1ec4033e7f5c6e4c87dbc357ba6f1c7e
+2023-10-04T17:59:45.000000Z 203.0.113.2 36281 443 TLSv1.3 TLS_AES_128_GCM_SHA256 1.5 "-" - - Failed:UnmappedCert`)); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if err := zipper.Close(); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	obj := state.DownloadedObject{
+		Object:   "AWSLogs/123/elasticloadbalancing/us-east-1/2023/10/04/conn_log.123_elasticloadbalancing_us-east-1_app.my-lb.1db0c9806095122a_20231004T1800Z_10.0.0.1_abcd.log.gz",
+		Filename: tmpFile.Name(),
+	}
+	if err := ep.ParseEvents(obj, outCh); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	close(outCh)
+
+	expected := map[string]interface{}{
+		"client_ip":                      "203.0.113.1",
+		"client_port":                    int64(36280),
+		"listener_port":                  int64(443),
+		"tls_protocol":                   "TLSv1.2",
+		"tls_cipher":                     "ECDHE-RSA-AES128-GCM-SHA256",
+		"tls_handshake_latency":          4.036,
+		"leaf_client_cert_subject":       "CN=client.example.com,O=Example,C=US",
+		"leaf_client_cert_validity":      "NotBefore=2023-09-21T22:43:21Z;NotAfter=2026-06-17T22:43:21Z",
+		"leaf_client_cert_serial_number": "FEF257753A94ECBF",
+		"tls_verify_status":              "Success",
+		"conn_trace_id":                  "TID_1ec4033e7f5c6e4c87dbc357ba6f1c7e",
+		"elb":                            "app/my-lb/1db0c9806095122a",
+		"log_type":                       "connection",
+	}
+	ev := <-outCh
+	if !reflect.DeepEqual(ev.Data, expected) {
+		t.Errorf("Output did not match expected, got %v", ev.Data)
+	}
+	// the second line was logged before conn_trace_id was added
+	ev = <-outCh
+	if ev.Data["tls_verify_status"] != "Failed:UnmappedCert" || ev.Data["conn_trace_id"] != nil {
+		t.Errorf("Expected a line without a trace ID to parse, got %v", ev.Data)
+	}
+}
+
+func TestALBParseClassicELBEvents(t *testing.T) {
+	ep := NewALBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"})
+	outCh := make(chan event.Event, 1)
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write([]byte(`2017-07-31T20:30:57.975041Z spline-reticulation-lb 10.11.12.13:47882 10.3.47.87:8080 0.000021 0.010962 -1 504 504 766 17 "PUT https://api.simulation.io:443/reticulate/spline/1 HTTP/1.1" "libhoney-go/1.3.3" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2`)); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	obj := state.DownloadedObject{
+		Object:   "AWSLogs/123/elasticloadbalancing/us-east-1/2017/07/31/123_elasticloadbalancing_us-east-1_spline-reticulation-lb_20170731T2030Z_10.0.0.1_abcd.log",
+		Filename: tmpFile.Name(),
+	}
+	if err := ep.ParseEvents(obj, outCh); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	close(outCh)
+
+	ev := <-outCh
+	if ev.Data["elb"] != "spline-reticulation-lb" || ev.Data["backend_authority"] != "10.3.47.87:8080" || ev.Data["elb_status_code"] != int64(504) {
+		t.Errorf("Expected the classic ELB format to be parsed, got %v", ev.Data)
+	}
+
+	// ALB names can't be matched as classic ones
+	for _, key := range []string{
+		"123_elasticloadbalancing_us-east-1_app.my-lb.1db0c9806095122a_20231004T1800Z_10.0.0.1_abcd.log.gz",
+		"conn_log.123_elasticloadbalancing_us-east-1_app.my-lb.1db0c9806095122a_20231004T1800Z_10.0.0.1_abcd.log.gz",
+	} {
+		if classicELBKey.MatchString(key) {
+			t.Errorf("Expected %q not to be a classic ELB log", key)
+		}
+	}
+}
+
+func BenchmarkALBParseEvents(b *testing.B) {
+	benchmarkParseEvents(b, NewALBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"}),
+		`h2 2026-10-14T09:00:57.975041Z app/my-lb/50dc6c495c0c9188 10.11.12.13:47882 10.3.47.87:8080 0.000021 0.010962 0.000034 200 200 766 17 "GET https://api.example.com:443/users/1 HTTP/1.1" "curl/7.79.1" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-1:000000000000:targetgroup/users/73e2d6bc24d8a067 "Root=1-5e71404d-84277a47a826ab3d2e844170" "api.example.com" "-" 0 2026-10-14T09:00:57.960000Z "forward" "-" "-" "10.3.47.87:8080" "200"`)
+}
+
+func TestALBDynSampleOnRequestPath(t *testing.T) {
+	opt := &options.Options{SampleRate: 1, SamplerType: "simple"}
+	ep := NewALBEventParser(opt)
+	// only events keyed on their path as well as their status are kept
+	// for sure
+	ep.sampler = sampler.NewDynamicWith(&dynsampler.Static{
+		Rates:   map[string]int{"504_/reticulate/spline/1": 1},
+		Default: 1000000,
+	}, sampler.Keys(&options.Options{DynSampleKeys: []string{"elb_status_code,request_path"}}))
+
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString(`h2 2017-07-31T20:30:57.975041Z spline_reticulation_lb 10.11.12.13:47882 10.3.47.87:8080 0.000021 0.010962 -1 504 504 766 17 "PUT https://api.simulation.io:443/reticulate/spline/1 HTTP/1.1" "libhoney-go/1.3.3" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 groupARN "Root=1-5e71404d-84277a47a826ab3d2e844170" "ui-dogfood.honeycomb.io" "certARN" 0 2017-07-31T20:30:52.975041Z "forward" "-" "-" "10.11.12.13:80" "201"` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	rules, err := loadLiveRules(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as NewHoneycombPublisher wires them together
+	parsedCh := make(chan event.Event)
+	shapedCh := make(chan event.Event)
+	sampledCh := make(chan event.Event, 1)
+	go shapeRequests(parsedCh, shapedCh, opt, newReloadableRules(rules))
+	go func() {
+		ep.DynSample(shapedCh, sampledCh)
+		close(sampledCh)
+	}()
+	if err := ep.ParseEvents(state.DownloadedObject{Object: "foo", Filename: tmpFile.Name()}, parsedCh); err != nil {
+		t.Fatal(err)
+	}
+	close(parsedCh)
+
+	var sampled []event.Event
+	for ev := range sampledCh {
+		sampled = append(sampled, ev)
+	}
+	if len(sampled) != 1 || sampled[0].SampleRate != 1 {
+		t.Fatalf("expected the event to be sampled on its status and path, got %v", sampled)
+	}
+	if path := sampled[0].Data["request_path"]; path != "/reticulate/spline/1" {
+		t.Errorf("unexpected request_path %v", path)
+	}
+}
@@ -0,0 +1,280 @@
+package publisher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// A CloudWatch Logs subscription batches up to a megabyte of log events into
+// each record it sends to Firehose.
+const maxAPIGatewayRecord = 4 * 1024 * 1024
+
+// The time format of $context.requestTime.
+const apiGatewayTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Access log fields which are numbers, by the names the access log formats
+// in API Gateway's documentation give them, e.g. "status": "$context.status".
+// The formats quote every variable, so they're logged as strings.
+var apiGatewayNumbers = map[string]bool{
+	"status":             true,
+	"responseLength":     true,
+	"responseLatency":    true,
+	"integrationLatency": true,
+	"integrationStatus":  true,
+	"latency":            true,
+	"requestTimeEpoch":   true,
+}
+
+// cloudWatchLogsData is what a CloudWatch Logs subscription sends on, see
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/SubscriptionFilters.html
+type cloudWatchLogsData struct {
+	MessageType string `json:"messageType"`
+	LogGroup    string `json:"logGroup"`
+	LogStream   string `json:"logStream"`
+	LogEvents   []struct {
+		Timestamp int64  `json:"timestamp"`
+		Message   string `json:"message"`
+	} `json:"logEvents"`
+}
+
+type APIGatewayEventParser struct {
+	dynamicSampling
+}
+
+func NewAPIGatewayEventParser(opt *options.Options) *APIGatewayEventParser {
+	return &APIGatewayEventParser{dynamicSampling: newDynamicSampling(opt)}
+}
+
+// scanJSONValues splits the JSON objects out of data, whether they're on
+// lines of their own or concatenated, as Firehose concatenates the records it
+// delivers. Anything else is split by line.
+func scanJSONValues(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for start < len(data) && (data[start] == ' ' || data[start] == '\t' || data[start] == '\r' || data[start] == '\n') {
+		start++
+	}
+	if start == len(data) {
+		return start, nil, nil
+	}
+	if data[start] != '{' {
+		advance, token, err := bufio.ScanLines(data[start:], atEOF)
+		if advance > 0 {
+			advance += start
+		}
+		return advance, token, err
+	}
+
+	depth, inString, escaped := 0, false, false
+	for i := start; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1, data[start : i+1], nil
+			}
+		}
+	}
+	if atEOF {
+		// an object cut off, which won't parse
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
+
+// parseAPIGatewayRecord parses an access log record in a JSON format, leaving
+// out the variables which are "-", as they're logged when they don't apply.
+func parseAPIGatewayRecord(message string) (map[string]interface{}, error) {
+	var record map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(message))
+	dec.UseNumber()
+	if err := dec.Decode(&record); err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{}, len(record)+1)
+	for field, value := range record {
+		switch v := value.(type) {
+		case string:
+			if v == "-" {
+				continue
+			}
+			if apiGatewayNumbers[field] {
+				if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+					data[field] = n
+					continue
+				}
+			}
+			data[field] = v
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				data[field] = n
+			} else if f, err := v.Float64(); err == nil {
+				data[field] = f
+			}
+		default:
+			data[field] = v
+		}
+	}
+
+	// The request, for shaping like load balancers' requests are.
+	path, ok := data["path"].(string)
+	if !ok {
+		path, ok = data["resourcePath"].(string)
+	}
+	if method, hasMethod := data["httpMethod"].(string); hasMethod && ok {
+		protocol, _ := data["protocol"].(string)
+		if protocol == "" {
+			protocol = "HTTP/1.1"
+		}
+		data["request"] = method + " " + path + " " + protocol
+	}
+	return data, nil
+}
+
+// apiGatewayTimestamp returns when the request was made, from
+// $context.requestTimeEpoch or $context.requestTime, falling back to when the
+// log event was.
+func apiGatewayTimestamp(data map[string]interface{}, fallback time.Time) time.Time {
+	if epoch, ok := data["requestTimeEpoch"].(int64); ok {
+		return time.Unix(0, epoch*int64(time.Millisecond))
+	}
+	if requestTime, ok := data["requestTime"].(string); ok {
+		if t, err := time.Parse(apiGatewayTimeFormat, requestTime); err == nil {
+			return t
+		}
+	}
+	return fallback
+}
+
+// ParseEvents parses objects of access logs in a JSON format, delivered by
+// API Gateway straight to Firehose, by a CloudWatch Logs subscription to
+// Firehose, or exported from CloudWatch Logs, whose lines start with the time
+// of each log event.
+func (ep *APIGatewayEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	r, err := logbucket.Open(obj)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	scanner := newLineScanner(obj, r)
+	scanner.Buffer(nil, maxAPIGatewayRecord)
+	scanner.Split(scanJSONValues)
+
+	for scanner.Scan() {
+		token := scanner.Bytes()
+		if len(bytes.TrimSpace(token)) == 0 {
+			continue
+		}
+
+		messages := []string{string(token)}
+		var timestamps []time.Time
+		if token[0] == '{' && bytes.Contains(token, []byte(`"logEvents"`)) {
+			var logs cloudWatchLogsData
+			if err := json.Unmarshal(token, &logs); err != nil {
+				scanner.unparseable(string(token), err)
+				continue
+			}
+			// e.g. CONTROL_MESSAGE, checking the destination
+			if logs.MessageType != "DATA_MESSAGE" {
+				continue
+			}
+			messages = messages[:0]
+			for _, logEvent := range logs.LogEvents {
+				messages = append(messages, logEvent.Message)
+				timestamps = append(timestamps, time.Unix(0, logEvent.Timestamp*int64(time.Millisecond)))
+			}
+		} else if token[0] != '{' {
+			// exported: 2018-08-20T11:20:00.000Z {...}
+			line := string(token)
+			i := strings.IndexByte(line, ' ')
+			if i < 0 {
+				scanner.unparseable(line, fmt.Errorf("expected a timestamp and a log event"))
+				continue
+			}
+			t, err := time.Parse(time.RFC3339Nano, line[:i])
+			if err != nil {
+				scanner.unparseable(line, err)
+				continue
+			}
+			messages = []string{line[i+1:]}
+			timestamps = []time.Time{t}
+		}
+
+		for i, message := range messages {
+			data, err := parseAPIGatewayRecord(message)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"object": obj.Object,
+					"line":   scanner.lines,
+					"err":    err,
+				}).Debug("Could not parse API Gateway access log record")
+				scanner.unparseable(message, err)
+				continue
+			}
+			fallback := time.Now()
+			if i < len(timestamps) {
+				fallback = timestamps[i]
+			}
+			out <- event.Event{
+				Timestamp: apiGatewayTimestamp(data, fallback),
+				Data:      data,
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (ep *APIGatewayEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		// use the status and the route (or resource) to set the sample
+		// rate, so errors and quiet routes are kept more often
+		route := ev.Data["routeKey"]
+		if route == nil {
+			route = ev.Data["resourcePath"]
+		}
+		key := fmt.Sprintf("%v_%v", ev.Data["status"], route)
+
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
+		rate := ep.sampler.GetSampleRate(key)
+		if rate <= 0 {
+			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
+			rate = 1
+		}
+		if rand.Intn(rate) == 0 {
+			ev.SampleRate = rate
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+}
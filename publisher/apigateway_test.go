@@ -0,0 +1,102 @@
+package publisher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func parseAPIGateway(t *testing.T, contents []byte) []event.Event {
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(contents); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	ep := &APIGatewayEventParser{}
+	out := make(chan event.Event, 10)
+	obj := state.DownloadedObject{Object: "foo", Filename: tmpFile.Name()}
+	if err := ep.ParseEvents(obj, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var evs []event.Event
+	for ev := range out {
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+const apiGatewayRecord = `{ "requestId":"c6af9ac6-7b61-11e6-9a41-93e8deadbeef", "ip": "192.0.2.1", "caller":"-", "user":"-", "requestTime":"20/Aug/2018:11:20:00 +0000", "httpMethod":"GET", "resourcePath":"/pets/{id}", "path":"/prod/pets/12", "status":"200", "protocol":"HTTP/1.1", "responseLength":"42" }`
+
+func TestAPIGatewayRecord(t *testing.T) {
+	// delivered by Firehose, concatenated
+	evs := parseAPIGateway(t, []byte(apiGatewayRecord+apiGatewayRecord+"\n"))
+	if len(evs) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evs))
+	}
+	if expected := time.Date(2018, time.August, 20, 11, 20, 0, 0, time.UTC); !evs[0].Timestamp.Equal(expected) {
+		t.Errorf("expected timestamp %s, got %s", expected, evs[0].Timestamp)
+	}
+	data := evs[0].Data
+	for field, expected := range map[string]interface{}{
+		"ip":             "192.0.2.1",
+		"status":         int64(200),
+		"responseLength": int64(42),
+		"resourcePath":   "/pets/{id}",
+		"request":        "GET /prod/pets/12 HTTP/1.1",
+	} {
+		if data[field] != expected {
+			t.Errorf("expected %s to be %v, got %v", field, expected, data[field])
+		}
+	}
+	if _, ok := data["caller"]; ok {
+		t.Error("expected caller, which was -, to be left out")
+	}
+}
+
+func TestAPIGatewaySubscription(t *testing.T) {
+	// Firehose gzips each record a subscription sends it.
+	var buf bytes.Buffer
+	for _, message := range []string{"CONTROL_MESSAGE", "DATA_MESSAGE"} {
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(`{"messageType":"` + message + `","logGroup":"API-Gateway-Execution-Logs_abc123/prod","logStream":"s","logEvents":[{"id":"1","timestamp":1534764000000,"message":"{\"httpMethod\":\"POST\",\"routeKey\":\"POST /pets\",\"status\":\"201\"}"},{"id":"2","timestamp":1534764001000,"message":"not json"}]}`))
+		zw.Close()
+	}
+	evs := parseAPIGateway(t, buf.Bytes())
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(evs))
+	}
+	if evs[0].Data["routeKey"] != "POST /pets" || evs[0].Data["status"] != int64(201) {
+		t.Errorf("unexpected event %v", evs[0].Data)
+	}
+	if expected := time.Unix(1534764000, 0); !evs[0].Timestamp.Equal(expected) {
+		t.Errorf("expected the log event's timestamp %s, got %s", expected, evs[0].Timestamp)
+	}
+}
+
+func TestAPIGatewayExported(t *testing.T) {
+	evs := parseAPIGateway(t, []byte(`2018-08-20T11:20:00.000Z {"httpMethod":"GET","status":"404"}
+2018-08-20T11:20:01.000Z {"httpMethod":"GET","status":"200","requestTimeEpoch":"1534764001500"}
+`))
+	if len(evs) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evs))
+	}
+	if expected := time.Date(2018, time.August, 20, 11, 20, 0, 0, time.UTC); !evs[0].Timestamp.Equal(expected) {
+		t.Errorf("expected timestamp %s, got %s", expected, evs[0].Timestamp)
+	}
+	if expected := time.Unix(1534764001, 500000000); !evs[1].Timestamp.Equal(expected) {
+		t.Errorf("expected requestTimeEpoch %s, got %s", expected, evs[1].Timestamp)
+	}
+}
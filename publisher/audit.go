@@ -0,0 +1,123 @@
+package publisher
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditLog sends an event for every object published to --audit_dataset,
+// whether or not publishing it succeeded, so that it can be shown that each
+// object was processed. Methods on a nil AuditLog do nothing, for when
+// --audit_dataset isn't given.
+type AuditLog struct {
+	dataset string
+}
+
+// NewAuditLog returns the AuditLog for --audit_dataset, or nil if it isn't
+// given. Nothing is processed for real under --dry-run, so it's nil then too.
+func NewAuditLog(opt *options.Options) *AuditLog {
+	if opt.AuditDataset == "" || opt.DryRun {
+		return nil
+	}
+	return &AuditLog{dataset: opt.AuditDataset}
+}
+
+// objectAudit counts what happens to an object while it's published.
+type objectAudit struct {
+	object string
+	fields map[string]interface{}
+	size   int64
+	start  time.Time
+
+	// lines and parseErrors are only updated from the goroutine parsing
+	// the object, events from the stage counting them.
+	lines, parseErrors int64
+	events             int64
+}
+
+// start begins auditing the object, having the lines read from it and those
+// which couldn't be parsed counted on top of whatever else is done with them.
+func (a *AuditLog) start(obj *state.DownloadedObject) *objectAudit {
+	if a == nil {
+		return nil
+	}
+	audit := &objectAudit{object: obj.Object, fields: obj.Fields, start: time.Now(), size: obj.Size}
+	if obj.Body == nil {
+		if fi, err := os.Stat(obj.Filename); err == nil {
+			audit.size = fi.Size()
+		}
+	}
+
+	progress := obj.Progress
+	obj.Progress = func(lines int64) {
+		// lines before the one being handed along
+		audit.lines = lines + 1
+		if progress != nil {
+			progress(lines)
+		}
+	}
+	unparseable := obj.Unparseable
+	obj.Unparseable = func(line int64, text string, err error) {
+		audit.parseErrors++
+		if unparseable != nil {
+			unparseable(line, text, err)
+		}
+	}
+	return audit
+}
+
+// countEvents counts the events parsed from the object on their way through.
+func (audit *objectAudit) countEvents(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		atomic.AddInt64(&audit.events, 1)
+		out <- ev
+	}
+}
+
+func (audit *objectAudit) data(err error) map[string]interface{} {
+	data := map[string]interface{}{
+		"meta.type":    "object",
+		"object":       audit.object,
+		"size_bytes":   audit.size,
+		"lines":        audit.lines,
+		"parse_errors": audit.parseErrors,
+		"events":       atomic.LoadInt64(&audit.events),
+		"duration_ms":  float64(time.Since(audit.start)) / float64(time.Millisecond),
+		"success":      err == nil,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	// e.g. the load balancer and region the object is from
+	for k, v := range audit.fields {
+		if _, ok := data[k]; !ok {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+// record sends the object's audit event, once it's been published or has
+// failed to be.
+func (a *AuditLog) record(audit *objectAudit, err error) {
+	if a == nil {
+		return
+	}
+	libhEv := libhoney.NewEvent()
+	libhEv.Dataset = a.dataset
+	applyDestination(libhEv)
+	if err := libhEv.Add(audit.data(err)); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error adding data to audit event")
+		return
+	}
+	if err := libhEv.SendPresampled(); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error sending audit event")
+	}
+}
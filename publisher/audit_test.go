@@ -0,0 +1,79 @@
+package publisher
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestObjectAudit(t *testing.T) {
+	f, err := ioutil.TempFile("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("first\nbad\nthird\n")
+	f.Close()
+
+	var progress []int64
+	deadLetters := 0
+	obj := state.DownloadedObject{
+		Object:      "AWSLogs/123/elasticloadbalancing/foo.log.gz",
+		Filename:    f.Name(),
+		Fields:      map[string]interface{}{"aws_region": "us-east-1", "object": "not this"},
+		Progress:    func(lines int64) { progress = append(progress, lines) },
+		Unparseable: func(int64, string, error) { deadLetters++ },
+	}
+	a := &AuditLog{dataset: "honeyaws-ops"}
+	audit := a.start(&obj)
+
+	out := make(chan event.Event, 2)
+	in, done := through(out, audit.countEvents)
+	scanner := newLineScanner(obj, strings.NewReader("first\nbad\nthird\n"))
+	for scanner.Scan() {
+		if scanner.Text() == "bad" {
+			scanner.unparseable(scanner.Text(), errors.New("bad line"))
+			continue
+		}
+		in <- event.Event{Data: map[string]interface{}{}}
+	}
+	done()
+
+	if len(progress) != 3 || deadLetters != 1 {
+		t.Errorf("Expected progress and dead letters to still be reported, got %v and %d", progress, deadLetters)
+	}
+	data := audit.data(errors.New("boom"))
+	expected := map[string]interface{}{
+		"object":       obj.Object,
+		"size_bytes":   int64(16),
+		"lines":        int64(3),
+		"parse_errors": int64(1),
+		"events":       int64(2),
+		"success":      false,
+		"error":        "boom",
+		"aws_region":   "us-east-1",
+	}
+	for k, v := range expected {
+		if data[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, data[k])
+		}
+	}
+}
+
+func TestNewAuditLog(t *testing.T) {
+	if NewAuditLog(&options.Options{}) != nil {
+		t.Error("Expected no audit log without --audit_dataset")
+	}
+	if NewAuditLog(&options.Options{AuditDataset: "honeyaws-ops", DryRun: true}) != nil {
+		t.Error("Expected no audit log under --dry-run")
+	}
+	// methods on a nil AuditLog do nothing
+	var a *AuditLog
+	a.record(a.start(&state.DownloadedObject{}), nil)
+}
@@ -0,0 +1,69 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+const authTimeout = 10 * time.Second
+
+// CheckWriteKey checks with the Auth API that --api_host accepts --writekey,
+// through --http_proxy if it's given, for verify. It returns the slug of the
+// team the key is for, along with its environment, if it has one.
+func CheckWriteKey(opt *options.Options) (string, error) {
+	if opt.WriteKey == "" {
+		return "", fmt.Errorf("--writekey is not set")
+	}
+	transport, err := newTransport(opt.HTTPProxy)
+	if err != nil {
+		return "", fmt.Errorf("--http_proxy: %s", err)
+	}
+	u, err := url.Parse(opt.APIHost)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "/1/auth")
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Honeycomb-Team", opt.WriteKey)
+
+	client := &http.Client{Timeout: authTimeout, Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("the write key was rejected by %s", opt.APIHost)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("GET /1/auth: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var auth struct {
+		Team struct {
+			Slug string `json:"slug"`
+		} `json:"team"`
+		Environment struct {
+			Slug string `json:"slug"`
+		} `json:"environment"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return "", fmt.Errorf("GET /1/auth: %s", err)
+	}
+	if auth.Environment.Slug != "" {
+		return auth.Team.Slug + "/" + auth.Environment.Slug, nil
+	}
+	return auth.Team.Slug, nil
+}
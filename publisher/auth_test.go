@@ -0,0 +1,48 @@
+package publisher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+func TestCheckWriteKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/auth" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Header.Get("X-Honeycomb-Team") {
+		case "classic":
+			w.Write([]byte(`{"team": {"slug": "acme"}, "environment": {"slug": ""}}`))
+		case "abc123":
+			w.Write([]byte(`{"team": {"slug": "acme"}, "environment": {"slug": "production"}}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "unknown API key - check your credentials"}`))
+		}
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		writeKey string
+		team     string
+		ok       bool
+	}{
+		{"abc123", "acme/production", true},
+		{"classic", "acme", true},
+		{"revoked", "", false},
+		{"", "", false},
+	}
+	for _, tc := range testCases {
+		team, err := CheckWriteKey(&options.Options{APIHost: srv.URL, WriteKey: tc.writeKey})
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: expected ok %v, got %v", tc.writeKey, tc.ok, err)
+		}
+		if team != tc.team {
+			t.Errorf("%q: expected team %q, got %q", tc.writeKey, tc.team, team)
+		}
+	}
+}
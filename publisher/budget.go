@@ -0,0 +1,197 @@
+package publisher
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Budget caps what's sent to Honeycomb at --max-events-per-minute and
+// --max-bytes-per-hour, whatever the traffic. Each minute, the events which
+// have been sampled anyway are sampled again at the rate which would have
+// kept the last minute within the budgets, so a surge raises the effective
+// sample rate rather than the bill. Events beyond a budget before the rate
+// catches up are dropped until the minute, or hour, is over.
+type Budget struct {
+	maxEvents int64
+	maxBytes  int64
+
+	lock sync.Mutex
+	// rate is the sample rate applied on top of the events' own.
+	rate int
+
+	minute   time.Time
+	received int64
+	// receivedBytes are of the events received this minute, before
+	// they're sampled by the budget.
+	receivedBytes int64
+	sent          int64
+	dropped       int64
+
+	hour      time.Time
+	hourBytes int64
+}
+
+// NewBudget returns the Budget for --max-events-per-minute and
+// --max-bytes-per-hour, or nil if neither is set.
+func NewBudget(maxEvents, maxBytes int) (*Budget, error) {
+	if maxEvents < 0 || maxBytes < 0 {
+		return nil, fmt.Errorf("--max-events-per-minute and --max-bytes-per-hour should be positive, or 0 for no limit")
+	}
+	if maxEvents == 0 && maxBytes == 0 {
+		return nil, nil
+	}
+	return &Budget{maxEvents: int64(maxEvents), maxBytes: int64(maxBytes), rate: 1}, nil
+}
+
+// sample passes along the events within the budgets, with their sample rate
+// multiplied by the budget's, and reports each minute throttled.
+func (b *Budget) sample(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		keep, rate, throttled := b.admit(ev.Data, time.Now())
+		if throttled != nil {
+			b.report(throttled)
+		}
+		if !keep {
+			discard(ev)
+			continue
+		}
+		if ev.SampleRate < 1 {
+			ev.SampleRate = 1
+		}
+		ev.SampleRate *= rate
+		out <- ev
+	}
+	close(out)
+}
+
+// admit counts the event, returning whether to keep it and at which rate,
+// and the throttling of the minute before, if it's just over and was
+// throttled.
+func (b *Budget) admit(data map[string]interface{}, now time.Time) (bool, int, map[string]interface{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var throttled map[string]interface{}
+	if minute := now.Truncate(time.Minute); !minute.Equal(b.minute) {
+		throttled = b.nextMinute(minute)
+	}
+	if hour := now.Truncate(time.Hour); !hour.Equal(b.hour) {
+		b.hour = hour
+		b.hourBytes = 0
+	}
+
+	size := eventSize(data)
+	b.received++
+	b.receivedBytes += size
+	if b.rate > 1 && rand.Intn(b.rate) != 0 {
+		return false, 0, throttled
+	}
+	if (b.maxEvents > 0 && b.sent >= b.maxEvents) || (b.maxBytes > 0 && b.hourBytes+size > b.maxBytes) {
+		b.dropped++
+		return false, 0, throttled
+	}
+	b.sent++
+	b.hourBytes += size
+	return true, b.rate, throttled
+}
+
+// nextMinute starts the minute, choosing its rate from the events received
+// the minute before: enough to keep their number within
+// --max-events-per-minute, and their bytes within what's left of
+// --max-bytes-per-hour spread over the rest of the hour. It returns the
+// throttling of the minute before, if it was throttled.
+func (b *Budget) nextMinute(minute time.Time) map[string]interface{} {
+	var throttled map[string]interface{}
+	if b.rate > 1 || b.dropped > 0 {
+		throttled = map[string]interface{}{
+			"meta.type":                    "budget_throttled",
+			"budget.sample_rate":           b.rate,
+			"budget.events_received":       b.received,
+			"budget.events_sent":           b.sent,
+			"budget.events_dropped":        b.dropped,
+			"budget.hour_bytes_sent":       b.hourBytes,
+			"budget.max_events_per_minute": b.maxEvents,
+			"budget.max_bytes_per_hour":    b.maxBytes,
+		}
+	}
+
+	rate := 1
+	if b.maxEvents > 0 {
+		rate = maxInt(rate, ceilDiv(b.received, b.maxEvents))
+	}
+	if b.maxBytes > 0 && b.receivedBytes > 0 {
+		hourBytes := b.hourBytes
+		if !minute.Truncate(time.Hour).Equal(b.hour) {
+			hourBytes = 0
+		}
+		minutesLeft := int64(time.Hour-minute.Sub(minute.Truncate(time.Hour))) / int64(time.Minute)
+		if left := b.maxBytes - hourBytes; left > 0 {
+			rate = maxInt(rate, ceilDiv(b.receivedBytes*minutesLeft, left))
+		}
+	}
+
+	b.rate = rate
+	b.minute = minute
+	b.received, b.receivedBytes, b.sent, b.dropped = 0, 0, 0, 0
+	return throttled
+}
+
+// report logs the throttling of a minute, and sends it to Honeycomb so that
+// it's seen where the events went.
+func (b *Budget) report(throttled map[string]interface{}) {
+	logrus.WithFields(logrus.Fields{
+		"sample_rate": throttled["budget.sample_rate"],
+		"received":    throttled["budget.events_received"],
+		"sent":        throttled["budget.events_sent"],
+		"dropped":     throttled["budget.events_dropped"],
+	}).Warn("Throttled to keep within --max-events-per-minute or --max-bytes-per-hour")
+
+	libhEv := libhoney.NewEvent()
+	libhEv.Timestamp = time.Now()
+	applyDestination(libhEv)
+	if err := libhEv.Add(throttled); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error adding data to budget event")
+		return
+	}
+	if err := libhEv.SendPresampled(); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error sending budget event")
+	}
+}
+
+// eventSize approximates the size of the event's fields as JSON, the way
+// they're sent to Honeycomb.
+func eventSize(data map[string]interface{}) int64 {
+	size := int64(2)
+	for k, v := range data {
+		// quoted, with a colon and a comma
+		size += int64(len(k)) + 4
+		switch v := v.(type) {
+		case string:
+			size += int64(len(v)) + 2
+		case int64, float64, int:
+			size += 8
+		case bool:
+			size += 5
+		default:
+			size += int64(len(fmt.Sprint(v)))
+		}
+	}
+	return size
+}
+
+func ceilDiv(n, d int64) int {
+	return int((n + d - 1) / d)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,85 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBudget(t *testing.T) {
+	if b, err := NewBudget(0, 0); b != nil || err != nil {
+		t.Errorf("expected no budget by default, got %v %v", b, err)
+	}
+	if _, err := NewBudget(-1, 0); err == nil {
+		t.Error("expected a negative budget to be an error")
+	}
+}
+
+func TestBudgetEvents(t *testing.T) {
+	b, err := NewBudget(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minute := time.Date(2018, 8, 20, 11, 20, 0, 0, time.UTC)
+	admit := func(n int, now time.Time) (sent int, rate int, throttled map[string]interface{}) {
+		for i := 0; i < n; i++ {
+			keep, r, th := b.admit(map[string]interface{}{"elb": "lb"}, now)
+			if th != nil {
+				throttled = th
+			}
+			if keep {
+				sent++
+				rate = r
+			}
+		}
+		return sent, rate, throttled
+	}
+
+	if sent, rate, throttled := admit(30, minute); sent != 10 || rate != 1 || throttled != nil {
+		t.Errorf("expected the first minute to be cut off at the budget, got %d at %d, %v", sent, rate, throttled)
+	}
+	sent, rate, throttled := admit(30, minute.Add(time.Minute))
+	if throttled == nil || throttled["budget.events_dropped"] != int64(20) || throttled["meta.type"] != "budget_throttled" {
+		t.Errorf("expected the throttled minute to be reported, got %v", throttled)
+	}
+	if rate != 3 || sent > 10 {
+		t.Errorf("expected the next minute to be sampled at 3 to fit 30 events in 10, got %d at %d", sent, rate)
+	}
+
+	// once the traffic drops, so does the rate
+	admit(1, minute.Add(2*time.Minute))
+	if sent, rate, _ := admit(5, minute.Add(3*time.Minute)); sent != 5 || rate != 1 {
+		t.Errorf("expected the rate to drop back to 1, got %d at %d", sent, rate)
+	}
+}
+
+func TestBudgetBytes(t *testing.T) {
+	data := map[string]interface{}{"request": "GET http://example.com:80/ HTTP/1.1"}
+	size := eventSize(data)
+	// enough for 60 events an hour, 1 a minute
+	b, err := NewBudget(0, int(60*size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := time.Date(2018, 8, 20, 11, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		b.admit(data, hour)
+	}
+	if b.hourBytes != 4*size {
+		t.Errorf("expected the first minute to be sent, got %d bytes", b.hourBytes)
+	}
+	// 4 a minute for the rest of the hour would be too many
+	b.admit(data, hour.Add(time.Minute))
+	if b.rate < 4 {
+		t.Errorf("expected the rate to spread what's left of the hour's budget, got %d", b.rate)
+	}
+
+	// the hour's budget is spent
+	b.hourBytes = 60 * size
+	if keep, _, _ := b.admit(data, hour.Add(time.Minute)); keep {
+		t.Error("expected events beyond the hour's budget to be dropped")
+	}
+	b.admit(data, hour.Add(time.Hour))
+	if b.hourBytes > size {
+		t.Errorf("expected the next hour to have its budget back, got %d bytes spent", b.hourBytes)
+	}
+}
@@ -0,0 +1,155 @@
+package publisher
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+// The --cardinality_action values.
+const (
+	cardinalityHash     = "hash"
+	cardinalityTruncate = "truncate"
+)
+
+// cardinalityHashBuckets is how many values hashed values are folded into, so
+// the field gains at most this many more distinct values past the limit.
+const cardinalityHashBuckets = 256
+
+// cardinalityPrefixLen is how much of a value which isn't a path truncating
+// keeps.
+const cardinalityPrefixLen = 8
+
+type cardinalityKey struct {
+	dataset, field string
+}
+
+// CardinalityGuard keeps the fields in --cardinality_fields from having more
+// than --cardinality_limit distinct values in a dataset, which makes queries
+// grouping by them slow: once a field has that many, values it hasn't seen
+// yet are hashed into one of a few hundred buckets, or truncated, and a
+// warning event is sent to the dataset. Values are counted since startup.
+// Methods on a nil CardinalityGuard do nothing, for when --cardinality_fields
+// isn't given.
+type CardinalityGuard struct {
+	sync.Mutex
+	fields []string
+	limit  int
+	action string
+	seen   map[cardinalityKey]map[string]bool
+}
+
+// NewCardinalityGuard returns the guard for the fields, or nil if there
+// aren't any.
+func NewCardinalityGuard(fields []string, limit int, action string) (*CardinalityGuard, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	switch action {
+	case cardinalityHash, cardinalityTruncate:
+	default:
+		return nil, fmt.Errorf("--cardinality_action must be hash or truncate, got %q", action)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("--cardinality_limit must be positive, got %d", limit)
+	}
+	return &CardinalityGuard{
+		fields: fields,
+		limit:  limit,
+		action: action,
+		seen:   make(map[cardinalityKey]map[string]bool),
+	}, nil
+}
+
+// apply replaces the event's values of the guarded fields which would take
+// them over the limit in the dataset. It returns the fields which went over
+// the limit with this event, for warning about.
+func (g *CardinalityGuard) apply(dataset string, data map[string]interface{}) []string {
+	if g == nil {
+		return nil
+	}
+	g.Lock()
+	defer g.Unlock()
+	var exceeded []string
+	for _, field := range g.fields {
+		v, ok := data[field].(string)
+		if !ok {
+			continue
+		}
+		key := cardinalityKey{dataset, field}
+		seen, ok := g.seen[key]
+		if !ok {
+			seen = make(map[string]bool)
+			g.seen[key] = seen
+		}
+		if seen[v] {
+			continue
+		}
+		if len(seen) < g.limit {
+			seen[v] = true
+			if len(seen) == g.limit {
+				exceeded = append(exceeded, field)
+			}
+			continue
+		}
+		data[field] = g.replace(v)
+	}
+	return exceeded
+}
+
+func (g *CardinalityGuard) replace(v string) string {
+	if g.action == cardinalityTruncate {
+		return truncateValue(v)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(v))
+	return fmt.Sprintf("hashed-%02x", h.Sum32()%cardinalityHashBuckets)
+}
+
+// truncateValue keeps the first segment of a path, e.g. /users/123 is
+// /users/*, or the start of anything else.
+func truncateValue(v string) string {
+	if strings.HasPrefix(v, "/") {
+		if i := strings.IndexByte(v[1:], '/'); i >= 0 {
+			return v[:i+2] + "*"
+		}
+		return v
+	}
+	if len(v) > cardinalityPrefixLen {
+		return v[:cardinalityPrefixLen] + "*"
+	}
+	return v
+}
+
+// warn logs the field having reached the limit, and sends a warning event to
+// the dataset so it's seen where the values went.
+func (g *CardinalityGuard) warn(dataset, field string) {
+	logrus.WithFields(logrus.Fields{
+		"dataset": dataset,
+		"field":   field,
+		"limit":   g.limit,
+		"action":  g.action,
+	}).Warn("Field reached --cardinality_limit distinct values, new values will be replaced")
+
+	libhEv := libhoney.NewEvent()
+	libhEv.Timestamp = time.Now()
+	libhEv.Dataset = dataset
+	applyDestination(libhEv)
+	if err := libhEv.Add(map[string]interface{}{
+		"meta.type":          "cardinality_limit",
+		"cardinality.field":  field,
+		"cardinality.limit":  g.limit,
+		"cardinality.action": g.action,
+	}); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error adding data to cardinality warning event")
+		return
+	}
+	if err := libhEv.SendPresampled(); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error sending cardinality warning event")
+	}
+}
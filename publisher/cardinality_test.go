@@ -0,0 +1,64 @@
+package publisher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	g, err := NewCardinalityGuard([]string{"request_path"}, 2, cardinalityHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(dataset, path string) (interface{}, []string) {
+		data := map[string]interface{}{"request_path": path, "elb_status_code": int64(200)}
+		exceeded := g.apply(dataset, data)
+		return data["request_path"], exceeded
+	}
+
+	if v, exceeded := apply("a", "/one"); v != "/one" || exceeded != nil {
+		t.Errorf("expected values under the limit to be kept, got %v %v", v, exceeded)
+	}
+	if v, exceeded := apply("a", "/two"); v != "/two" || !reflect.DeepEqual(exceeded, []string{"request_path"}) {
+		t.Errorf("expected reaching the limit to be reported once, got %v %v", v, exceeded)
+	}
+	v, exceeded := apply("a", "/three")
+	if s, ok := v.(string); !ok || !strings.HasPrefix(s, "hashed-") || exceeded != nil {
+		t.Errorf("expected new values past the limit to be hashed, got %v %v", v, exceeded)
+	}
+	if again, _ := apply("a", "/three"); again != v {
+		t.Errorf("expected the same value to hash the same, got %v and %v", v, again)
+	}
+	if v, _ := apply("a", "/one"); v != "/one" {
+		t.Errorf("expected values seen before the limit to be kept, got %v", v)
+	}
+	// datasets are counted separately
+	if v, _ := apply("b", "/three"); v != "/three" {
+		t.Errorf("expected another dataset to have its own limit, got %v", v)
+	}
+
+	var nilGuard *CardinalityGuard
+	if nilGuard.apply("a", map[string]interface{}{"request_path": "/x"}) != nil {
+		t.Error("expected a nil guard to do nothing")
+	}
+	if g, err := NewCardinalityGuard(nil, 10, cardinalityHash); g != nil || err != nil {
+		t.Errorf("expected no guard without fields, got %v %v", g, err)
+	}
+	if _, err := NewCardinalityGuard([]string{"request_path"}, 10, "drop"); err == nil {
+		t.Error("expected an unknown action to be an error")
+	}
+}
+
+func TestTruncateValue(t *testing.T) {
+	for v, expected := range map[string]string{
+		"/users/123/orders": "/users/*",
+		"/users":            "/users",
+		"abcdefghijkl":      "abcdefgh*",
+		"short":             "short",
+	} {
+		if got := truncateValue(v); got != expected {
+			t.Errorf("%s: expected %s, got %s", v, expected, got)
+		}
+	}
+}
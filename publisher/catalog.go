@@ -0,0 +1,129 @@
+package publisher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Fetching the catalog from an endpoint gives up after this long, leaving the
+// catalog as it was.
+const catalogFetchTimeout = 30 * time.Second
+
+// ServiceCatalog adds metadata about the services behind load balancers and
+// target groups, such as who owns them, to their events, from the
+// --service_catalog YAML (or JSON) file or http(s):// endpoint, e.g.
+//
+//	my-lb:
+//	  owner: payments
+//	  tier: "1"
+//	  oncall: payments-primary
+//	ec2instances:
+//	  owner: search
+//
+// Each key is added to events as service_<key>. ALB events are looked up by
+// their target group name first, then by load balancer name. The catalog is
+// read again every --service_catalog_refresh seconds.
+type ServiceCatalog struct {
+	*sync.RWMutex
+	source   string
+	services map[string]map[string]string
+}
+
+// LoadServiceCatalog reads the catalog from a file or URL, returning nil if
+// none is given.
+func LoadServiceCatalog(source string) (*ServiceCatalog, error) {
+	if source == "" {
+		return nil, nil
+	}
+
+	c := &ServiceCatalog{RWMutex: &sync.RWMutex{}, source: source}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readCatalog(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+
+	client := &http.Client{Timeout: catalogFetchTimeout}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *ServiceCatalog) load() error {
+	data, err := readCatalog(c.source)
+	if err != nil {
+		return fmt.Errorf("Error reading service catalog: %s", err)
+	}
+
+	services := make(map[string]map[string]string)
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return fmt.Errorf("Error parsing service catalog: %s", err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.services = services
+	return nil
+}
+
+// refresh reads the catalog again every interval, for good. If reading it
+// fails, the catalog read last time keeps being used.
+func (c *ServiceCatalog) refresh(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := c.load(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"source": c.source,
+				"error":  err,
+			}).Error("Could not refresh --service_catalog, keeping the last one read")
+		}
+	}
+}
+
+func (c *ServiceCatalog) annotate(data map[string]interface{}) {
+	var names []string
+	if arn, ok := data["target_group_arn"].(string); ok {
+		if name := targetGroupName(arn); name != "" {
+			names = append(names, name)
+		}
+	}
+	if elb, ok := data["elb"].(string); ok {
+		names = append(names, elb, lbName(elb))
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	for _, name := range names {
+		if service, ok := c.services[name]; ok {
+			for k, v := range service {
+				data["service_"+k] = v
+			}
+			return
+		}
+	}
+}
+
+func (c *ServiceCatalog) annotateEvents(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		c.annotate(ev.Data)
+		out <- ev
+	}
+}
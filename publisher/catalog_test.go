@@ -0,0 +1,97 @@
+package publisher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestServiceCatalogFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, `
+my-lb:
+  owner: payments
+  tier: 1
+ec2instances:
+  owner: search
+  oncall: search-primary
+`)
+	f.Close()
+
+	c, err := LoadServiceCatalog(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		data     map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			map[string]interface{}{"elb": "app/my-lb/1db0c9806095122a"},
+			map[string]interface{}{"elb": "app/my-lb/1db0c9806095122a", "service_owner": "payments", "service_tier": "1"},
+		},
+		// the target group is more specific than the load balancer
+		{
+			map[string]interface{}{"elb": "app/my-lb/1db0c9806095122a", "target_group_arn": "arn:aws:elasticloadbalancing:us-east-1:729997878290:targetgroup/ec2instances/3bf8bbb3ab2b6080"},
+			map[string]interface{}{"elb": "app/my-lb/1db0c9806095122a", "target_group_arn": "arn:aws:elasticloadbalancing:us-east-1:729997878290:targetgroup/ec2instances/3bf8bbb3ab2b6080", "service_owner": "search", "service_oncall": "search-primary"},
+		},
+		{
+			map[string]interface{}{"elb": "other-lb"},
+			map[string]interface{}{"elb": "other-lb"},
+		},
+	}
+	for _, tc := range cases {
+		c.annotate(tc.data)
+		if !reflect.DeepEqual(tc.data, tc.expected) {
+			t.Errorf("got %v, expected %v", tc.data, tc.expected)
+		}
+	}
+}
+
+func TestServiceCatalogEndpoint(t *testing.T) {
+	body, status := `{"my-lb": {"owner": "payments"}}`, http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	c, err := LoadServiceCatalog(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"elb": "my-lb"}
+	c.annotate(data)
+	if data["service_owner"] != "payments" {
+		t.Errorf("expected the owner to be added, got %v", data)
+	}
+
+	// A failed refresh keeps the catalog read last time.
+	status = http.StatusInternalServerError
+	if err := c.load(); err == nil {
+		t.Error("expected an error status to fail loading the catalog")
+	}
+	data = map[string]interface{}{"elb": "my-lb"}
+	c.annotate(data)
+	if data["service_owner"] != "payments" {
+		t.Errorf("expected the owner to still be added, got %v", data)
+	}
+
+	status, body = http.StatusOK, `{"my-lb": {"owner": "billing"}}`
+	if err := c.load(); err != nil {
+		t.Fatal(err)
+	}
+	c.annotate(data)
+	if data["service_owner"] != "billing" {
+		t.Errorf("expected the refreshed owner, got %v", data)
+	}
+}
@@ -1,15 +1,14 @@
 package publisher
 
 import (
-	"bufio"
-	"compress/gzip"
+	"errors"
 	"fmt"
 	"math/rand"
-	"os"
 	"runtime"
 	"strings"
+	"time"
 
-	dynsampler "github.com/honeycombio/dynsampler-go"
+	"github.com/honeycombio/honeyaws/logbucket"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/sampler"
 	"github.com/honeycombio/honeyaws/state"
@@ -19,29 +18,23 @@ import (
 )
 
 type CloudFrontEventParser struct {
-	sampler dynsampler.Sampler
+	dynamicSampling
 }
 
 func NewCloudFrontEventParser(opt *options.Options) *CloudFrontEventParser {
-	s, err := sampler.NewSamplerFromOptions(opt)
-	if err != nil {
-		logrus.WithField("err", err).Fatal("couldn't build sampler from arguments")
-	}
-	ep := &CloudFrontEventParser{sampler: s}
-
-	if err := ep.sampler.Start(); err != nil {
-		logrus.WithField("err", err).Fatal("Couldn't start dynamic sampler")
-	}
-
-	return ep
+	return &CloudFrontEventParser{dynamicSampling: newDynamicSampling(opt)}
 }
 
+// cloudFrontTimeFormat is the format of the date and time fields once
+// they're joined.
+const cloudFrontTimeFormat = "2006-01-02T15:04:05"
+
 func (ep *CloudFrontEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
 	np := &nginx.Parser{}
 	err := np.Init(&nginx.Options{
 		ConfigFile:      formatFileName,
 		TimeFieldName:   "timestamp",
-		TimeFieldFormat: "2006-01-02T15:04:05",
+		TimeFieldFormat: cloudFrontTimeFormat,
 		LogFormatName:   AWSCloudFrontWebFormat,
 		NumParsers:      runtime.NumCPU(),
 	})
@@ -51,53 +44,112 @@ func (ep *CloudFrontEventParser) ParseEvents(obj state.DownloadedObject, out cha
 
 	linesCh := make(chan string)
 
-	go np.ProcessLines(linesCh, out, nil)
+	// ProcessLines returns once every line has been parsed and sent
+	// along, which lets us do the same
+	parsed := make(chan struct{})
+	go func() {
+		np.ProcessLines(linesCh, out, nil)
+		close(parsed)
+	}()
 
-	f, err := os.Open(obj.Filename)
+	r, err := logbucket.Open(obj)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
+	defer r.Close()
 
-	r, err := gzip.NewReader(f)
-	if err != nil {
-		return err
-	}
+	scanner := newLineScanner(obj, r)
+	check := lineChecker(obj, AWSCloudFrontWebFormat)
 
-	scanner := bufio.NewScanner(r)
+	// schema is the columns declared by the object's #Fields directive,
+	// which newer log versions add to (c-port, time-to-first-byte and so
+	// on). Objects without one are parsed with the fixed format.
+	var schema *w3cSchema
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.HasPrefix(line, w3cFieldsDirective) {
+			schema = parseW3CFields(line)
+			continue
+		}
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		splitLine := strings.Fields(line)
-
-		// Date and time are two separate fields instead of only one
-		// timestamp field, so join them together..
-		//
-		// We join together the first two items with "T" in between as
-		// a new first item and "delete" the second item.
-		//
-		// Yeah it's ugly, but we don't have many other options with
-		// the nginx parser and Amazon's quirky format.
-		//
-		// Example of Amazon's format:
-		// 2014-05-23 01:13:11
-		splitLine = append([]string{splitLine[0] + "T" + splitLine[1]}, splitLine[2:]...)
-
-		// nginx parser is fickle about whitespace, so the join ensures
-		// that only one space exists between fields
-		linesCh <- strings.Join(splitLine, " ")
+		if schema != nil {
+			data, ts, err := schema.parse(line, cloudFrontTimeFormat)
+			if err != nil {
+				scanner.unparseable(line, err)
+				continue
+			}
+			if ts.IsZero() {
+				ts = time.Now()
+			}
+			out <- event.Event{Timestamp: ts, Data: data}
+			continue
+		}
+
+		joined, ok := joinCloudFrontFields(line)
+		if !ok {
+			scanner.unparseable(line, errNoCloudFrontTime)
+			continue
+		}
+		if err := check(joined); err != nil {
+			scanner.unparseable(line, err)
+			continue
+		}
+		linesCh <- joined
 	}
 
 	close(linesCh)
+	<-parsed
 
 	return nil
 }
 
+var errNoCloudFrontTime = errors.New("line has no date and time fields")
+
+// joinCloudFrontFields rewrites a CloudFront log line the way the nginx parser
+// needs it. Date and time are two separate fields instead of only one
+// timestamp field, so they're joined with a "T", e.g. 2014-05-23 01:13:11
+// becomes 2014-05-23T01:13:11, and the nginx parser is fickle about
+// whitespace, so fields are separated by a single space rather than tabs.
+//
+// It's done in a single pass and allocation, since it's done to every line;
+// ok is false if there aren't even date and time fields.
+func joinCloudFrontFields(line string) (joined string, ok bool) {
+	var b strings.Builder
+	b.Grow(len(line))
+	field := 0
+	for i := 0; i < len(line); {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		start := i
+		for i < len(line) && !isSpace(line[i]) {
+			i++
+		}
+		if start == i {
+			break
+		}
+		switch field {
+		case 0:
+		case 1:
+			b.WriteByte('T')
+		default:
+			b.WriteByte(' ')
+		}
+		b.WriteString(line[start:i])
+		field++
+	}
+	return b.String(), field >= 2
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
 func (ep *CloudFrontEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
 	for ev := range in {
 		var key string
@@ -133,6 +185,11 @@ func (ep *CloudFrontEventParser) DynSample(in <-chan event.Event, out chan<- eve
 			}
 		}
 
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
 		rate := ep.sampler.GetSampleRate(key)
 		if rate <= 0 {
 			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
@@ -141,6 +198,8 @@ func (ep *CloudFrontEventParser) DynSample(in <-chan event.Event, out chan<- eve
 		if rand.Intn(rate) == 0 {
 			ev.SampleRate = rate
 			out <- ev
+		} else {
+			discard(ev)
 		}
 	}
 }
@@ -0,0 +1,158 @@
+package publisher
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// The fields available in CloudFront real-time logs, in the order they are
+// written out. See
+// https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/real-time-logs.html#understand-real-time-log-config-fields
+var CloudFrontRealtimeFields = []string{
+	"timestamp", "c-ip", "time-to-first-byte", "sc-status", "sc-bytes",
+	"cs-method", "cs-protocol", "cs-host", "cs-uri-stem", "cs-bytes",
+	"x-edge-location", "x-edge-request-id", "x-host-header", "time-taken",
+	"cs-protocol-version", "c-ip-version", "cs-user-agent", "cs-referer",
+	"cs-cookie", "cs-uri-query", "x-edge-response-result-type",
+	"x-forwarded-for", "ssl-protocol", "ssl-cipher", "x-edge-result-type",
+	"fle-encrypted-fields", "fle-status", "sc-content-type", "sc-content-len",
+	"sc-range-start", "sc-range-end", "c-port", "x-edge-detailed-result-type",
+	"c-country", "cs-accept-encoding", "cs-accept",
+	"cache-behavior-path-pattern", "cs-headers", "cs-header-names",
+	"cs-headers-count",
+}
+
+// Real-time logs are sampled on the same fields as standard logs.
+var cloudFrontRealtimeSampleKeys = []string{"sc_status", "cs_host", "x_edge_result_type"}
+
+// CloudFrontRealtimeEventParser parses CloudFront real-time log records, as
+// read from Kinesis by logbucket.KinesisConsumer. Unlike standard logs the
+// records are tab separated and only have the fields chosen in the real-time
+// log configuration.
+type CloudFrontRealtimeEventParser struct {
+	dynamicSampling
+	fields []string
+}
+
+func NewCloudFrontRealtimeEventParser(opt *options.Options) *CloudFrontRealtimeEventParser {
+	ep := &CloudFrontRealtimeEventParser{
+		dynamicSampling: newDynamicSampling(opt),
+		fields:          CloudFrontRealtimeFields,
+	}
+	if fields := realtimeFields(opt.RealtimeFields); len(fields) > 0 {
+		ep.fields = fields
+	}
+
+	return ep
+}
+
+func realtimeFields(opt []string) []string {
+	var fields []string
+	for _, f := range opt {
+		for _, field := range strings.Split(f, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// parseRecord turns a log record into an event, naming fields the same way as
+// they are for standard logs (e.g. sc_status instead of sc-status).
+func (ep *CloudFrontRealtimeEventParser) parseRecord(record string) (event.Event, bool) {
+	vals := strings.Split(record, "\t")
+	if len(vals) != len(ep.fields) {
+		logrus.WithFields(logrus.Fields{
+			"expected": len(ep.fields),
+			"actual":   len(vals),
+		}).Error("Real-time log record doesn't have the configured number of fields, check --realtime_fields")
+		metrics.ParseFailures.Inc()
+		return event.Event{}, false
+	}
+
+	ev := event.Event{
+		Timestamp: time.Now(),
+		Data:      make(map[string]interface{}, len(vals)),
+	}
+	for i, val := range vals {
+		if val == "-" || val == "" {
+			continue
+		}
+
+		field := ep.fields[i]
+		if field == "timestamp" {
+			// seconds since the epoch, with millisecond precision
+			if ts, err := strconv.ParseFloat(val, 64); err == nil {
+				sec, frac := math.Modf(ts)
+				ev.Timestamp = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+				continue
+			}
+		}
+
+		name := strings.Replace(field, "-", "_", -1)
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			ev.Data[name] = i
+		} else if f, err := strconv.ParseFloat(val, 64); err == nil {
+			ev.Data[name] = f
+		} else {
+			ev.Data[name] = val
+		}
+	}
+
+	return ev, true
+}
+
+func (ep *CloudFrontRealtimeEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	r, err := logbucket.Open(obj)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	scanner := newLineScanner(obj, r)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if ev, ok := ep.parseRecord(line); ok {
+			out <- ev
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (ep *CloudFrontRealtimeEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		keys := ep.sampler.Keys()
+		if len(keys) == 0 {
+			keys = cloudFrontRealtimeSampleKeys
+		}
+		rate := ep.sampler.GetSampleRate(sampler.Key(ev.Data, keys))
+		if rate <= 0 {
+			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
+			rate = 1
+		}
+		if rand.Intn(rate) == 0 {
+			ev.SampleRate = rate
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestCloudFrontRealtimeParseEvents(t *testing.T) {
+	opt := &options.Options{
+		SampleRate:     1,
+		SamplerType:    "simple",
+		RealtimeFields: []string{"timestamp,c-ip,sc-status,cs-host", "time-taken,x-edge-result-type,cs-referer"},
+	}
+	ep := NewCloudFrontRealtimeEventParser(opt)
+	outCh := make(chan event.Event, 1)
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write([]byte("1598996338.725\t192.0.2.10\t200\td111111abcdef8.cloudfront.net\t0.001\tHit\t-\n")); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	if err := ep.ParseEvents(state.DownloadedObject{Filename: tmpFile.Name()}, outCh); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	ev := <-outCh
+	expectedTime := time.Date(2020, 9, 1, 21, 38, 58, 725000000, time.UTC)
+	if ev.Timestamp.Sub(expectedTime).Round(time.Millisecond) != 0 {
+		t.Errorf("Expected timestamp %s, got %s", expectedTime, ev.Timestamp)
+	}
+
+	expected := map[string]interface{}{
+		"c_ip":               "192.0.2.10",
+		"sc_status":          int64(200),
+		"cs_host":            "d111111abcdef8.cloudfront.net",
+		"time_taken":         0.001,
+		"x_edge_result_type": "Hit",
+	}
+	if !reflect.DeepEqual(ev.Data, expected) {
+		t.Errorf("Output did not match expected: got %v, want %v", ev.Data, expected)
+	}
+}
+
+func TestCloudFrontRealtimeFieldMismatch(t *testing.T) {
+	ep := &CloudFrontRealtimeEventParser{fields: []string{"timestamp", "sc-status"}}
+	if _, ok := ep.parseRecord("1598996338.725\t200\tHit"); ok {
+		t.Error("Expected a record with extra fields to be rejected")
+	}
+}
@@ -0,0 +1,128 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+// cloudFrontLine is from the examples in the CloudFront docs, with fields
+// separated by tabs as they are in the logs.
+const cloudFrontLine = "2014-05-23\t01:13:11\tFRA2\t182\t192.0.2.10\tGET\td111111abcdef8.cloudfront.net\t/view/my/file.html\t200\twww.displaymyfiles.com\tMozilla/4.0%20(compatible;%20MSIE%205.0b1;%20Mac_PowerPC)\tzip=98101\t-\tRefreshHit\tMRVMF7KydIvxMWfJIglgwHQwZsbG2IhRJ07sn9AkKUFSHS9EXAMPLE==\td111111abcdef8.cloudfront.net\thttp\t120\t0.001\t-\t-\t-\tRefreshHit\tHTTP/1.1"
+
+func TestJoinCloudFrontFields(t *testing.T) {
+	for line, expected := range map[string]string{
+		"2014-05-23\t01:13:11\tFRA2\t182":  "2014-05-23T01:13:11 FRA2 182",
+		" 2014-05-23  01:13:11 \tFRA2\t\r": "2014-05-23T01:13:11 FRA2",
+		"2014-05-23\t01:13:11":             "2014-05-23T01:13:11",
+	} {
+		if joined, ok := joinCloudFrontFields(line); !ok || joined != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", line, expected, joined, ok)
+		}
+	}
+	for _, line := range []string{"2014-05-23", "   "} {
+		if _, ok := joinCloudFrontFields(line); ok {
+			t.Errorf("%q: expected a line without a date and time not to be joined", line)
+		}
+	}
+}
+
+func TestCloudFrontParseEvents(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("#Version: 1.0\n" + cloudFrontLine + "\nshort\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &unparseableLines{object: "cloudfront.log"}
+	obj := state.DownloadedObject{Object: u.object, Filename: f.Name(), Unparseable: u.add}
+	out := make(chan event.Event, 2)
+	if err := NewCloudFrontEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"}).ParseEvents(obj, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var events []event.Event
+	for ev := range out {
+		events = append(events, ev)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %v", events)
+	}
+	data := events[0].Data
+	if data["x_edge_location"] != "FRA2" || data["cs_uri_stem"] != "/view/my/file.html" || data["x_edge_response_result_type"] != "RefreshHit" {
+		t.Errorf("unexpected event %v", data)
+	}
+	if u.count != 1 {
+		t.Errorf("expected the short line to be unparseable, got %d", u.count)
+	}
+}
+
+func TestCloudFrontParseEventsWithHeader(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// a newer log version, with the fields after cs-protocol-version
+	header := "#Fields: date time x-edge-location sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status cs(Referer) cs(User-Agent) cs-uri-query cs(Cookie) x-edge-result-type x-edge-request-id x-host-header cs-protocol cs-bytes time-taken x-forwarded-for ssl-protocol ssl-cipher x-edge-response-result-type cs-protocol-version fle-status fle-encrypted-fields c-port time-to-first-byte x-edge-detailed-result-type sc-content-type sc-content-len sc-range-start sc-range-end"
+	line := cloudFrontLine + "\t-\t-\t11040\t0.001\tRefreshHit\ttext/html\t78\t-\t-"
+	if _, err := f.WriteString("#Version: 1.0\n" + header + "\n" + line + "\nshort\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &unparseableLines{object: "cloudfront.log"}
+	obj := state.DownloadedObject{Object: u.object, Filename: f.Name(), Unparseable: u.add}
+	out := make(chan event.Event, 2)
+	if err := NewCloudFrontEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"}).ParseEvents(obj, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var events []event.Event
+	for ev := range out {
+		events = append(events, ev)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %v", events)
+	}
+	ev := events[0]
+	if !ev.Timestamp.Equal(time.Date(2014, 5, 23, 1, 13, 11, 0, time.UTC)) {
+		t.Errorf("expected the date and time to be the timestamp, got %v", ev.Timestamp)
+	}
+	for k, v := range map[string]interface{}{
+		"x_edge_location":             "FRA2",
+		"cs_user_agent":               "Mozilla/4.0%20(compatible;%20MSIE%205.0b1;%20Mac_PowerPC)",
+		"sc_status":                   int64(200),
+		"c_port":                      int64(11040),
+		"time_to_first_byte":          0.001,
+		"x_edge_detailed_result_type": "RefreshHit",
+		"sc_content_type":             "text/html",
+	} {
+		if ev.Data[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, ev.Data[k])
+		}
+	}
+	if _, ok := ev.Data["fle_status"]; ok {
+		t.Error("expected fields which are - to be left out")
+	}
+	if u.count != 1 {
+		t.Errorf("expected the short line to be unparseable, got %d", u.count)
+	}
+}
+
+func BenchmarkCloudFrontParseEvents(b *testing.B) {
+	benchmarkParseEvents(b, NewCloudFrontEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"}), cloudFrontLine)
+}
@@ -1,15 +1,14 @@
 package publisher
 
 import (
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
-	"os"
+	"path"
 	"time"
 
-	dynsampler "github.com/honeycombio/dynsampler-go"
+	"github.com/honeycombio/honeyaws/logbucket"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/sampler"
 	"github.com/honeycombio/honeyaws/state"
@@ -44,11 +43,56 @@ type CloudTrailRecord struct {
 	UserAgent         string                 `json:"userAgent"`
 	Resources         []CloudTrailResource   `json:"resources"`
 	EventType         string                 `json:"eventType"`
+	ReadOnly          *bool                  `json:"readOnly"`
 	RequestParameters map[string]interface{} `json:"requestParameters"`
 }
 
 type CloudTrailEventParser struct {
-	sampler dynsampler.Sampler
+	dynamicSampling
+	filter cloudTrailFilter
+}
+
+// cloudTrailFilter picks the records worth sending from the everything
+// CloudTrail logs, by --event-source, --event-name and --exclude-readonly.
+// Sources and names match exactly, or as glob patterns such as Describe*.
+type cloudTrailFilter struct {
+	sources         []string
+	names           []string
+	excludeReadOnly bool
+}
+
+func newCloudTrailFilter(opt *options.Options) (cloudTrailFilter, error) {
+	for _, pattern := range append(append([]string(nil), opt.EventSources...), opt.EventNames...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return cloudTrailFilter{}, fmt.Errorf("%q is not a valid pattern: %s", pattern, err)
+		}
+	}
+	return cloudTrailFilter{
+		sources:         opt.EventSources,
+		names:           opt.EventNames,
+		excludeReadOnly: opt.ExcludeReadOnly,
+	}, nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keep returns whether the record passes the filter. Records which don't say
+// whether they're read-only are kept.
+func (f cloudTrailFilter) keep(r *CloudTrailRecord) bool {
+	if f.excludeReadOnly && r.ReadOnly != nil && *r.ReadOnly {
+		return false
+	}
+	return matchAny(f.sources, r.EventSource) && matchAny(f.names, r.EventName)
 }
 
 // Helper function for flattening cloud trail records
@@ -68,39 +112,30 @@ func flattenCloudTrailRecord(r *CloudTrailRecord) map[string]interface{} {
 	p["SourceIPAddress"] = r.SourceIPAddress
 	p["UserAgent"] = r.UserAgent
 	p["EventType"] = r.EventType
+	if r.ReadOnly != nil {
+		p["ReadOnly"] = *r.ReadOnly
+	}
 	p["Parameters"] = r.RequestParameters
 
 	return p
 }
 
 func NewCloudTrailEventParser(opt *options.Options) *CloudTrailEventParser {
-	s, err := sampler.NewSamplerFromOptions(opt)
+	filter, err := newCloudTrailFilter(opt)
 	if err != nil {
-		logrus.WithField("err", err).Fatal("couldn't build sampler from arguments")
-	}
-	ep := &CloudTrailEventParser{sampler: s}
-
-	if err := ep.sampler.Start(); err != nil {
-		logrus.WithField("err", err).Fatal("Couldn't start dynamic sampler")
+		logrus.WithField("err", err).Fatal("Couldn't parse --event-source or --event-name")
 	}
-
-	return ep
+	return &CloudTrailEventParser{dynamicSampling: newDynamicSampling(opt), filter: filter}
 }
 
 // we have to wrap events ourselves due to there being no existing parsers
 func (ep *CloudTrailEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
 
-	f, err := os.Open(obj.Filename)
+	r, err := logbucket.Open(obj)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
-
-	r, err := gzip.NewReader(f)
-	if err != nil {
-		return err
-	}
 	defer r.Close()
 
 	if err != nil {
@@ -127,6 +162,9 @@ func (ep *CloudTrailEventParser) ParseEvents(obj state.DownloadedObject, out cha
 	// TODO: do we want to thread?
 
 	for _, record := range rec.Records {
+		if !ep.filter.keep(&record) {
+			continue
+		}
 		t, err := time.Parse(timeFormat, record.EventTime)
 
 		if err != nil {
@@ -178,6 +216,11 @@ func (ep *CloudTrailEventParser) DynSample(in <-chan event.Event, out chan<- eve
 
 			}
 		}
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
 		rate := ep.sampler.GetSampleRate(key)
 		if rate <= 0 {
 			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
@@ -186,6 +229,8 @@ func (ep *CloudTrailEventParser) DynSample(in <-chan event.Event, out chan<- eve
 		if rand.Intn(rate) == 0 {
 			ev.SampleRate = rate
 			out <- ev
+		} else {
+			discard(ev)
 		}
 	}
 }
@@ -0,0 +1,72 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+const cloudTrailRecords = `{"Records":[
+{"eventTime":"2026-10-14T09:00:00Z","eventSource":"s3.amazonaws.com","eventName":"DeleteBucket","readOnly":false},
+{"eventTime":"2026-10-14T09:00:01Z","eventSource":"s3.amazonaws.com","eventName":"ListBuckets","readOnly":true},
+{"eventTime":"2026-10-14T09:00:02Z","eventSource":"ec2.amazonaws.com","eventName":"DescribeInstances","readOnly":true},
+{"eventTime":"2026-10-14T09:00:03Z","eventSource":"iam.amazonaws.com","eventName":"DeleteRole"}
+]}`
+
+func TestCloudTrailFilter(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString(cloudTrailRecords); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+	obj := state.DownloadedObject{Object: "foo", Filename: tmpFile.Name()}
+
+	for _, tc := range []struct {
+		opt      options.Options
+		expected []string
+	}{
+		{options.Options{}, []string{"DeleteBucket", "ListBuckets", "DescribeInstances", "DeleteRole"}},
+		{options.Options{EventSources: []string{"s3.amazonaws.com"}}, []string{"DeleteBucket", "ListBuckets"}},
+		{options.Options{EventNames: []string{"Delete*"}}, []string{"DeleteBucket", "DeleteRole"}},
+		{options.Options{ExcludeReadOnly: true}, []string{"DeleteBucket", "DeleteRole"}},
+		{options.Options{EventSources: []string{"s3.*", "ec2.*"}, ExcludeReadOnly: true}, []string{"DeleteBucket"}},
+	} {
+		filter, err := newCloudTrailFilter(&tc.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ep := &CloudTrailEventParser{filter: filter}
+		out := make(chan event.Event, 10)
+		if err := ep.ParseEvents(obj, out); err != nil {
+			t.Fatal(err)
+		}
+		close(out)
+
+		var names []string
+		for ev := range out {
+			names = append(names, ev.Data["EventName"].(string))
+		}
+		if len(names) != len(tc.expected) {
+			t.Errorf("%+v: expected %v, got %v", tc.opt, tc.expected, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != tc.expected[i] {
+				t.Errorf("%+v: expected %v, got %v", tc.opt, tc.expected, names)
+				break
+			}
+		}
+	}
+
+	if _, err := newCloudTrailFilter(&options.Options{EventNames: []string{"Delete["}}); err == nil {
+		t.Error("expected an invalid pattern to be an error")
+	}
+}
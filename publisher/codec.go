@@ -0,0 +1,97 @@
+package publisher
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codecs --spool_codec can compress spool files with.
+const (
+	CodecNone = "none"
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+	CodecLZ4  = "lz4"
+)
+
+// lz4Levels are lz4's compression levels, by the level they're chosen with.
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5,
+	lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// codec compresses files as they're written. The zero codec writes them as
+// they are.
+type codec struct {
+	name  string
+	level int
+}
+
+// newCodec checks the codec and level, where a level of 0 is the codec's
+// default: gzip's 6, zstd's 3 and lz4's fast.
+func newCodec(name string, level int) (codec, error) {
+	var min, max int
+	switch name {
+	case "", CodecNone:
+		return codec{}, nil
+	case CodecGzip:
+		min, max = gzip.BestSpeed, gzip.BestCompression
+	case CodecZstd:
+		min, max = 1, 22
+	case CodecLZ4:
+		min, max = 1, len(lz4Levels)-1
+	default:
+		return codec{}, fmt.Errorf("unknown codec %q, supported codecs are: none, gzip, zstd, lz4", name)
+	}
+	if level != 0 && (level < min || level > max) {
+		return codec{}, fmt.Errorf("%s compression level must be between %d and %d, got %d", name, min, max, level)
+	}
+	return codec{name: name, level: level}, nil
+}
+
+// ext is the extension of the files the codec writes, e.g. ".gz".
+func (c codec) ext() string {
+	switch c.name {
+	case CodecGzip:
+		return ".gz"
+	case CodecZstd:
+		return ".zst"
+	case CodecLZ4:
+		return ".lz4"
+	}
+	return ""
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// writer returns a writer compressing to w, which must be closed to finish
+// compressing.
+func (c codec) writer(w io.Writer) (io.WriteCloser, error) {
+	switch c.name {
+	case CodecGzip:
+		level := c.level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CodecZstd:
+		if c.level == 0 {
+			return zstd.NewWriter(w)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+	case CodecLZ4:
+		zw := lz4.NewWriter(w)
+		if err := zw.Apply(lz4.CompressionLevelOption(lz4Levels[c.level])); err != nil {
+			return nil, err
+		}
+		return zw, nil
+	}
+	return nopWriteCloser{w}, nil
+}
@@ -0,0 +1,62 @@
+package publisher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+func TestCodecs(t *testing.T) {
+	data := []byte(strings.Repeat(`{"data":{"elb_status_code":200}},`, 100))
+	readers := map[string]func(r io.Reader) (io.Reader, error){
+		CodecNone: func(r io.Reader) (io.Reader, error) { return r, nil },
+		CodecGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		CodecZstd: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		CodecLZ4:  func(r io.Reader) (io.Reader, error) { return lz4.NewReader(r), nil },
+	}
+
+	for name, newReader := range readers {
+		for _, level := range []int{0, 1, 9} {
+			c, err := newCodec(name, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			w, err := c.writer(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if name != CodecNone && buf.Len() >= len(data) {
+				t.Errorf("%s level %d: expected %d bytes to be compressed, got %d", name, level, len(data), buf.Len())
+			}
+
+			r, err := newReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := ioutil.ReadAll(r)
+			if err != nil || !bytes.Equal(decompressed, data) {
+				t.Errorf("%s level %d: round trip failed (%v)", name, level, err)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		level int
+	}{{"brotli", 0}, {CodecGzip, 10}, {CodecLZ4, 10}, {CodecZstd, 23}, {CodecZstd, -1}} {
+		if _, err := newCodec(tc.name, tc.level); err == nil {
+			t.Errorf("expected %s level %d to be rejected", tc.name, tc.level)
+		}
+	}
+}
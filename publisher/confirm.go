@@ -0,0 +1,250 @@
+package publisher
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/sirupsen/logrus"
+)
+
+// confirmTimeout is how long draining the publisher waits for the responses
+// to the events flushed, for the objects they're from to be confirmed.
+const confirmTimeout = 30 * time.Second
+
+// confirmKey is the field the events of an object being confirmed carry their
+// confirmedEvent in, until they're sent.
+const confirmKey = "honeyaws.confirm"
+
+// confirmation follows the events of an object published with --exactly_once
+// from when they're parsed until Honeycomb has accepted them, or they were
+// dropped on the way, e.g. by sampling. Progress through the object is only
+// recorded as far as every event parsed before it was, and the object is only
+// recorded as published once they all were, so that it's neither resumed from
+// further than Honeycomb got, nor published again once it has been.
+type confirmation struct {
+	sync.Mutex
+	stater  state.Confirmer
+	tracker *offsetTracker
+	object  string
+	pending *sync.WaitGroup
+
+	// added and settled are how many events have been parsed and how many
+	// of them were accepted or dropped since, and failed how many of those
+	// Honeycomb didn't accept however many times they were sent.
+	added, settled, failed int64
+
+	// checkpoints are the progress through the object waiting on its
+	// events to settle, oldest first.
+	checkpoints []checkpoint
+
+	// parsed is set once every event has been parsed, and ok if the
+	// object was parsed in full.
+	parsed, ok bool
+
+	// recordLock keeps the state written for the object in order, since
+	// it's written from whichever goroutine settles the event it was
+	// waiting on, and completed is set once the last has been.
+	recordLock sync.Mutex
+	completed  bool
+}
+
+// checkpoint is progress through the object, recorded once the events up to
+// the seq'th parsed have settled, settled of which already have.
+type checkpoint struct {
+	lines, seq, settled int64
+}
+
+// confirmedEvent is an event of the object, the seq'th parsed.
+type confirmedEvent struct {
+	c   *confirmation
+	seq int64
+}
+
+// newConfirmation starts confirming the events of the tracker's object, which
+// pending waits on until it's complete.
+func newConfirmation(stater state.Confirmer, tracker *offsetTracker, pending *sync.WaitGroup) *confirmation {
+	pending.Add(1)
+	return &confirmation{stater: stater, tracker: tracker, object: tracker.object, pending: pending}
+}
+
+// addEvents passes the object's events along, each carrying its place in the
+// object.
+func (c *confirmation) addEvents(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		c.Lock()
+		c.added++
+		ev.Data[confirmKey] = &confirmedEvent{c, c.added}
+		c.Unlock()
+		out <- ev
+	}
+}
+
+// checkpoint records progress through the object once its events parsed so
+// far have settled. The event parsed just before may not have been added yet,
+// so the next one is waited on as well.
+func (c *confirmation) checkpoint(lines int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.checkpoints = append(c.checkpoints, checkpoint{lines: lines, seq: c.added + 1, settled: c.settled})
+}
+
+// finish is called once every event of the object has been parsed, ok if it
+// was parsed in full.
+func (c *confirmation) finish(ok bool) {
+	c.Lock()
+	c.parsed, c.ok = true, ok
+	c.Unlock()
+	c.record(-1)
+}
+
+// settle records that the event was accepted, or dropped on the way, or that
+// it failed.
+func (e *confirmedEvent) settle(failed bool) {
+	c := e.c
+	c.Lock()
+	c.settled++
+	if failed {
+		c.failed++
+	}
+	// the newest checkpoint whose events have all settled, which the
+	// older ones are superseded by
+	lines, done := int64(-1), -1
+	for i := range c.checkpoints {
+		cp := &c.checkpoints[i]
+		if e.seq <= cp.seq {
+			cp.settled++
+		}
+		if cp.settled == cp.seq {
+			lines, done = cp.lines, i
+		}
+	}
+	c.checkpoints = c.checkpoints[done+1:]
+	c.Unlock()
+	c.record(lines)
+}
+
+// record writes the progress through the object, or once it's complete,
+// whether it was published.
+func (c *confirmation) record(lines int64) {
+	c.Lock()
+	complete := c.parsed && c.settled == c.added
+	published := c.ok && c.failed == 0
+	failed := c.failed
+	c.Unlock()
+	if lines < 0 && !complete {
+		return
+	}
+
+	c.recordLock.Lock()
+	defer c.recordLock.Unlock()
+	if c.completed {
+		return
+	}
+	if !complete {
+		c.tracker.record(lines)
+		return
+	}
+	c.completed = true
+	defer c.pending.Done()
+	c.tracker.done()
+	if failed > 0 {
+		logrus.WithFields(logrus.Fields{
+			"object": c.object,
+			"failed": failed,
+		}).Warn("Honeycomb didn't accept every event of the object, not recording it as published")
+	}
+	if !published {
+		return
+	}
+	if err := c.stater.SetPublished(c.object); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"object": c.object,
+			"error":  err,
+		}).Warn("Could not record object as published")
+	}
+}
+
+// takeConfirmation removes the event's place in its object from its fields,
+// if it has one, so that it isn't sent.
+func takeConfirmation(data map[string]interface{}) *confirmedEvent {
+	e, ok := data[confirmKey].(*confirmedEvent)
+	if ok {
+		delete(data, confirmKey)
+	}
+	return e
+}
+
+// pendingEvents is how many of the events parsed are still on their way to
+// Honeycomb, neither sent nor dropped yet, for Flush to wait on.
+var pendingEvents int64
+
+// countPending counts the events parsed on their way into the pipeline.
+func countPending(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		atomic.AddInt64(&pendingEvents, 1)
+		out <- ev
+	}
+}
+
+// discard settles an event dropped on its way to Honeycomb, e.g. by sampling,
+// since there'll be no response to it.
+func discard(ev event.Event) {
+	atomic.AddInt64(&pendingEvents, -1)
+	if e := takeConfirmation(ev.Data); e != nil {
+		e.settle(false)
+	}
+}
+
+// trackConfirmation keeps the event's place in its object in its Metadata,
+// alongside what's needed to retry it, to be settled by its response.
+func trackConfirmation(ev *libhoney.Event, e *confirmedEvent) {
+	if e == nil {
+		return
+	}
+	if retryable, ok := ev.Metadata.(*retryableEvent); ok {
+		retryable.confirm = e
+		return
+	}
+	ev.Metadata = e
+}
+
+// confirmResponse settles the event the response is for, unless it's being
+// sent again.
+func confirmResponse(resp transmission.Response, retrying bool) {
+	if retrying {
+		return
+	}
+	var e *confirmedEvent
+	switch m := resp.Metadata.(type) {
+	case *confirmedEvent:
+		e = m
+	case *retryableEvent:
+		e = m.confirm
+	}
+	if e != nil {
+		e.settle(responseError(resp) != "")
+	}
+}
+
+// awaitConfirmations waits up to timeout for the objects being confirmed to
+// be complete, returning whether they were. Those which aren't are resumed
+// from as far as Honeycomb got.
+func awaitConfirmations(pending *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		logrus.Warn("Honeycomb hasn't responded to every event sent, the objects they're from will be resumed")
+		return false
+	}
+}
@@ -0,0 +1,104 @@
+package publisher
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+// confirmEvents passes the events through the confirmation, returning their
+// places in the object.
+func confirmEvents(c *confirmation, n int) []*confirmedEvent {
+	in, out := make(chan event.Event), make(chan event.Event, n)
+	go c.addEvents(in, out)
+	var confirmed []*confirmedEvent
+	for i := 0; i < n; i++ {
+		in <- event.Event{Data: map[string]interface{}{"elb_status_code": int64(200)}}
+		confirmed = append(confirmed, takeConfirmation((<-out).Data))
+	}
+	close(in)
+	return confirmed
+}
+
+func TestConfirmation(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	tracker := &offsetTracker{stater: stater, object: "obj", recorded: time.Now()}
+	var pending sync.WaitGroup
+	c := newConfirmation(stater, tracker, &pending)
+	tracker.confirm = c
+
+	events := confirmEvents(c, 2)
+	c.checkpoint(2)
+	events = append(events, confirmEvents(c, 1)...)
+
+	events[1].settle(false)
+	events[0].settle(false)
+	if offsets, _ := stater.Offsets(); len(offsets) != 0 {
+		t.Errorf("expected progress to wait on the event after it, got %v", offsets)
+	}
+	// sampled out
+	discard(event.Event{Data: map[string]interface{}{confirmKey: events[2]}})
+	if offsets, _ := stater.Offsets(); offsets["obj"].Lines != 2 {
+		t.Errorf("expected progress to be recorded once its events settled, got %v", offsets)
+	}
+	if published, _ := stater.Published("obj"); published {
+		t.Error("expected the object not to be published until it's been parsed")
+	}
+
+	c.finish(true)
+	if published, _ := stater.Published("obj"); !published {
+		t.Error("expected the object to be published once its events were accepted")
+	}
+	if offsets, _ := stater.Offsets(); len(offsets) != 0 {
+		t.Errorf("expected the offset to be cleared once published, got %v", offsets)
+	}
+	if !awaitConfirmations(&pending, time.Second) {
+		t.Error("expected the confirmation to be complete")
+	}
+}
+
+func TestConfirmationFailed(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	tracker := &offsetTracker{stater: stater, object: "obj"}
+	var pending sync.WaitGroup
+	c := newConfirmation(stater, tracker, &pending)
+	tracker.confirm = c
+
+	events := confirmEvents(c, 2)
+	c.finish(true)
+	events[0].settle(true)
+	if awaitConfirmations(&pending, 10*time.Millisecond) {
+		t.Error("expected the confirmation to wait on every event")
+	}
+	events[1].settle(false)
+	if published, _ := stater.Published("obj"); published {
+		t.Error("expected an object with events Honeycomb didn't accept not to be published")
+	}
+	if !awaitConfirmations(&pending, time.Second) {
+		t.Error("expected the confirmation to be complete")
+	}
+}
+
+func TestConfirmResponse(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	var pending sync.WaitGroup
+	c := newConfirmation(stater, &offsetTracker{stater: stater, object: "obj"}, &pending)
+	events := confirmEvents(c, 2)
+	c.finish(true)
+
+	retryable := &retryableEvent{confirm: events[0]}
+	confirmResponse(transmission.Response{StatusCode: http.StatusServiceUnavailable, Metadata: retryable}, true)
+	confirmResponse(transmission.Response{StatusCode: http.StatusAccepted, Metadata: events[1]}, false)
+	if published, _ := stater.Published("obj"); published {
+		t.Error("expected the event being retried not to be settled")
+	}
+	confirmResponse(transmission.Response{StatusCode: http.StatusAccepted, Metadata: retryable}, false)
+	if published, _ := stater.Published("obj"); !published {
+		t.Error("expected the object to be published once the retry was accepted")
+	}
+}
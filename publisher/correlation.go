@@ -0,0 +1,82 @@
+package publisher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+// addCorrelationID adds correlation_id, which the application behind the
+// load balancer can work out for the same request, so that its events can be
+// joined with the load balancer's. It's the X-Ray ID of the X-Amzn-Trace-Id
+// header the load balancer logged and passed on: Self if it has one, as a
+// hop of a trace started elsewhere, Root otherwise. Without the header, e.g.
+// for classic ELBs, it's a hash of the client's IP, the event's timestamp and
+// the request's path, as logged.
+func addCorrelationID(ev *event.Event) {
+	if header, ok := ev.Data["trace_id"].(string); ok {
+		if id := amznTraceRequestID(header); id != "" {
+			ev.Data["correlation_id"] = id
+			return
+		}
+	}
+
+	ip := clientIP(ev.Data)
+	path := requestLinePath(ev.Data)
+	if ip == nil || path == "" {
+		return
+	}
+	key := strings.Join([]string{ip.String(), ev.Timestamp.UTC().Format(time.RFC3339Nano), path}, "|")
+	sum := sha256.Sum256([]byte(key))
+	ev.Data["correlation_id"] = hex.EncodeToString(sum[:16])
+}
+
+// amznTraceRequestID returns the ID of the request in an X-Amzn-Trace-Id
+// header, e.g. Self=1-67891234-12456789abcdef012345678;Root=1-67891233-abcdef012345678912345678,
+// or "" if it has neither Self nor Root.
+func amznTraceRequestID(header string) string {
+	var root string
+	for _, field := range strings.Split(header, ";") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Self":
+			return kv[1]
+		case "Root":
+			root = kv[1]
+		}
+	}
+	return root
+}
+
+// requestLinePath returns the path of the request, without the scheme, host
+// and query a load balancer logs it with, e.g. /users/42 of
+// GET https://example.com:443/users/42?page=2 HTTP/1.1.
+func requestLinePath(data map[string]interface{}) string {
+	request, ok := data["request"].(string)
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(request, " ")
+	if len(parts) != 3 {
+		return ""
+	}
+	uri := parts[1]
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+		j := strings.IndexByte(uri, '/')
+		if j < 0 {
+			return "/"
+		}
+		uri = uri[j:]
+	}
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		uri = uri[:i]
+	}
+	return uri
+}
@@ -0,0 +1,51 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestAddCorrelationID(t *testing.T) {
+	for _, tc := range []struct {
+		header, expected string
+	}{
+		{"Root=1-58337262-36d228ad5d99923122bbe354", "1-58337262-36d228ad5d99923122bbe354"},
+		{"Self=1-67891234-12456789abcdef012345678;Root=1-67891233-abcdef012345678912345678", "1-67891234-12456789abcdef012345678"},
+		{"Root=1-67891233-abcdef012345678912345678;Parent=53995c3f42cd8ad8;Sampled=1", "1-67891233-abcdef012345678912345678"},
+	} {
+		ev := event.Event{Data: map[string]interface{}{"trace_id": tc.header}}
+		addCorrelationID(&ev)
+		if ev.Data["correlation_id"] != tc.expected {
+			t.Errorf("expected %q to be correlated by %q, got %v", tc.header, tc.expected, ev.Data["correlation_id"])
+		}
+	}
+
+	ts := time.Date(2018, 8, 20, 11, 20, 0, 123456000, time.UTC)
+	classic := func(request string) event.Event {
+		return event.Event{Timestamp: ts, Data: map[string]interface{}{
+			"client_authority": "10.11.12.13:47882",
+			"request":          request,
+		}}
+	}
+	a := classic("GET http://example.com:80/users/42?page=2 HTTP/1.1")
+	b := classic("GET http://example.com:80/users/42 HTTP/1.1")
+	c := classic("GET http://example.com:80/users/43 HTTP/1.1")
+	for _, ev := range []*event.Event{&a, &b, &c} {
+		addCorrelationID(ev)
+	}
+	// sha256 of 10.11.12.13|2018-08-20T11:20:00.123456Z|/users/42
+	if a.Data["correlation_id"] != "b9c3840526025a3183c743a0ac52b5f2" {
+		t.Errorf("unexpected correlation_id without a trace header %v", a.Data["correlation_id"])
+	}
+	if a.Data["correlation_id"] != b.Data["correlation_id"] || a.Data["correlation_id"] == c.Data["correlation_id"] {
+		t.Errorf("expected the correlation_id to be of the client, timestamp and path, without the query, got %v", []interface{}{a.Data["correlation_id"], b.Data["correlation_id"], c.Data["correlation_id"]})
+	}
+
+	tcp := event.Event{Data: map[string]interface{}{"client_authority": "10.11.12.13:47882", "request": "- - - "}}
+	addCorrelationID(&tcp)
+	if _, ok := tcp.Data["correlation_id"]; ok {
+		t.Error("expected events without a request path not to be correlated")
+	}
+}
@@ -0,0 +1,78 @@
+package publisher
+
+// Bytes received from and sent to the client, as logged by load balancers
+// and by CloudFront.
+var transferFields = [][2]string{
+	{"received_bytes", "sent_bytes"},
+	{"cs_bytes", "sc_bytes"},
+}
+
+// pricingRegions are the locations AWS prices regions as, e.g. in the Cost
+// and Usage Report and the Price List API.
+var pricingRegions = map[string]string{
+	"us-east-1":      "US East (N. Virginia)",
+	"us-east-2":      "US East (Ohio)",
+	"us-west-1":      "US West (N. California)",
+	"us-west-2":      "US West (Oregon)",
+	"af-south-1":     "Africa (Cape Town)",
+	"ap-east-1":      "Asia Pacific (Hong Kong)",
+	"ap-south-1":     "Asia Pacific (Mumbai)",
+	"ap-south-2":     "Asia Pacific (Hyderabad)",
+	"ap-northeast-1": "Asia Pacific (Tokyo)",
+	"ap-northeast-2": "Asia Pacific (Seoul)",
+	"ap-northeast-3": "Asia Pacific (Osaka)",
+	"ap-southeast-1": "Asia Pacific (Singapore)",
+	"ap-southeast-2": "Asia Pacific (Sydney)",
+	"ap-southeast-3": "Asia Pacific (Jakarta)",
+	"ca-central-1":   "Canada (Central)",
+	"eu-central-1":   "EU (Frankfurt)",
+	"eu-central-2":   "EU (Zurich)",
+	"eu-west-1":      "EU (Ireland)",
+	"eu-west-2":      "EU (London)",
+	"eu-west-3":      "EU (Paris)",
+	"eu-south-1":     "EU (Milan)",
+	"eu-south-2":     "EU (Spain)",
+	"eu-north-1":     "EU (Stockholm)",
+	"me-south-1":     "Middle East (Bahrain)",
+	"me-central-1":   "Middle East (UAE)",
+	"sa-east-1":      "South America (Sao Paulo)",
+	"us-gov-west-1":  "AWS GovCloud (US-West)",
+	"us-gov-east-1":  "AWS GovCloud (US-East)",
+	"cn-north-1":     "China (Beijing)",
+	"cn-northwest-1": "China (Ningxia)",
+}
+
+func byteCount(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// addCostFields adds transfer_bytes, the bytes received and sent for the
+// request (or connection, for NLBs), and pricing_region, the location the
+// load balancer's region is priced as, for rough cost attribution. Regions
+// which aren't known are left as they are.
+func addCostFields(data map[string]interface{}) {
+	for _, fields := range transferFields {
+		received, ok := byteCount(data[fields[0]])
+		sent, ok2 := byteCount(data[fields[1]])
+		if ok || ok2 {
+			data["transfer_bytes"] = received + sent
+			break
+		}
+	}
+
+	if region, ok := data["aws_region"].(string); ok && region != "" {
+		if location, ok := pricingRegions[region]; ok {
+			data["pricing_region"] = location
+		} else {
+			data["pricing_region"] = region
+		}
+	}
+}
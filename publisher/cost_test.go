@@ -0,0 +1,35 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddCostFields(t *testing.T) {
+	cases := []struct {
+		data, expected map[string]interface{}
+	}{
+		{
+			map[string]interface{}{"received_bytes": int64(766), "sent_bytes": int64(17), "aws_region": "eu-west-1"},
+			map[string]interface{}{"received_bytes": int64(766), "sent_bytes": int64(17), "aws_region": "eu-west-1", "transfer_bytes": int64(783), "pricing_region": "EU (Ireland)"},
+		},
+		{
+			map[string]interface{}{"cs_bytes": int64(400), "sc_bytes": int64(2000)},
+			map[string]interface{}{"cs_bytes": int64(400), "sc_bytes": int64(2000), "transfer_bytes": int64(2400)},
+		},
+		{
+			map[string]interface{}{"sent_bytes": int64(17), "aws_region": "xx-space-1"},
+			map[string]interface{}{"sent_bytes": int64(17), "aws_region": "xx-space-1", "transfer_bytes": int64(17), "pricing_region": "xx-space-1"},
+		},
+		{
+			map[string]interface{}{"eventName": "GetObject"},
+			map[string]interface{}{"eventName": "GetObject"},
+		},
+	}
+	for _, c := range cases {
+		addCostFields(c.data)
+		if !reflect.DeepEqual(c.data, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, c.data)
+		}
+	}
+}
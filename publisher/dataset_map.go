@@ -0,0 +1,37 @@
+package publisher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseDatasetMap parses --dataset_map options of the form lb-name=dataset
+// into a map of load balancer name to dataset.
+func ParseDatasetMap(opt []string) (map[string]string, error) {
+	datasets := make(map[string]string)
+	for _, m := range opt {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid --dataset_map %q, expected lb-name=dataset", m)
+		}
+		datasets[parts[0]] = parts[1]
+	}
+	return datasets, nil
+}
+
+// datasetFor returns the dataset the event should be sent to, or "" for the
+// default. ALB and NLB events are logged with e.g. app/my-lb/1db0c9806095122a
+// as the load balancer, so they match on either that or just the name.
+func datasetFor(data map[string]interface{}, datasets map[string]string) string {
+	if len(datasets) == 0 {
+		return ""
+	}
+	elb, ok := data["elb"].(string)
+	if !ok {
+		return ""
+	}
+	if dataset, ok := datasets[elb]; ok {
+		return dataset
+	}
+	return datasets[lbName(elb)]
+}
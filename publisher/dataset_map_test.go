@@ -0,0 +1,35 @@
+package publisher
+
+import (
+	"testing"
+)
+
+func TestDatasetFor(t *testing.T) {
+	datasets, err := ParseDatasetMap([]string{
+		"classic-lb=classic",
+		"my-alb=alb-dataset",
+		"net/my-nlb/c6e77e28c25b2234=nlb-dataset",
+	})
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+
+	testCases := []struct {
+		elb, expected string
+	}{
+		{"classic-lb", "classic"},
+		{"app/my-alb/1db0c9806095122a", "alb-dataset"},
+		{"net/my-nlb/c6e77e28c25b2234", "nlb-dataset"},
+		{"net/my-nlb/0000000000000000", ""},
+		{"other-lb", ""},
+	}
+	for _, tc := range testCases {
+		if dataset := datasetFor(map[string]interface{}{"elb": tc.elb}, datasets); dataset != tc.expected {
+			t.Errorf("Expected %q to be sent to %q, got %q", tc.elb, tc.expected, dataset)
+		}
+	}
+
+	if _, err := ParseDatasetMap([]string{"my-alb"}); err == nil {
+		t.Error("Expected err for a mapping without a dataset")
+	}
+}
@@ -0,0 +1,198 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/sirupsen/logrus"
+)
+
+const datasetSetupTimeout = 10 * time.Second
+
+// column is a column of a Honeycomb dataset, as the Columns API has them.
+type column struct {
+	ID          string `json:"id,omitempty"`
+	KeyName     string `json:"key_name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// knownColumns are the fields whose types Honeycomb would otherwise infer
+// from the first event with them, which for a latency that's "-", or logged
+// as an integer, leaves the column typed wrong for good.
+var knownColumns = []column{
+	{KeyName: "request_processing_time", Type: "float", Description: "Seconds from the load balancer receiving the request to sending it to a target"},
+	{KeyName: "backend_processing_time", Type: "float", Description: "Seconds from the load balancer sending the request to the target starting to respond"},
+	{KeyName: "response_processing_time", Type: "float", Description: "Seconds from the load balancer receiving the response to starting to send it to the client"},
+	{KeyName: "total_time", Type: "float", Description: "Seconds the load balancer took over the request in total"},
+	{KeyName: "time_to_first_byte", Type: "float", Description: "Seconds until the first byte of the response was sent"},
+	{KeyName: "time_taken", Type: "float", Description: "Seconds CloudFront took to serve the request"},
+	{KeyName: "duration_ms", Type: "float", Description: "Milliseconds the request (or flow) took"},
+	{KeyName: "elb_status_code", Type: "integer", Description: "Status code of the load balancer's response"},
+	{KeyName: "backend_status_code", Type: "integer", Description: "Status code of the target's response"},
+	{KeyName: "sc_status", Type: "integer", Description: "Status code of CloudFront's response"},
+	{KeyName: "received_bytes", Type: "integer", Description: "Bytes received from the client"},
+	{KeyName: "sent_bytes", Type: "integer", Description: "Bytes sent to the client"},
+	{KeyName: "sc_bytes", Type: "integer", Description: "Bytes CloudFront sent to the client"},
+	{KeyName: "cs_bytes", Type: "integer", Description: "Bytes CloudFront received from the client"},
+}
+
+// DatasetSetup creates the datasets events are sent to, and their known
+// columns, with the Datasets and Columns APIs, for --setup-datasets.
+type DatasetSetup struct {
+	apiHost, writeKey string
+	client            *http.Client
+	// durationUnit is --duration-unit, which the durations' columns are
+	// described in.
+	durationUnit string
+}
+
+// NewDatasetSetup returns the DatasetSetup for --setup-datasets, or nil if it
+// isn't given or nothing is sent to Honeycomb.
+func NewDatasetSetup(opt *options.Options) *DatasetSetup {
+	if !opt.SetupDatasets || !opt.NeedsWriteKey() {
+		return nil
+	}
+	return &DatasetSetup{
+		apiHost:      opt.APIHost,
+		writeKey:     opt.WriteKey,
+		client:       &http.Client{Timeout: datasetSetupTimeout, Transport: honeycombTransport},
+		durationUnit: opt.DurationUnit,
+	}
+}
+
+// datasetNames returns the datasets events can be sent to: the default one,
+// and those in --dataset_map and the --config file.
+func datasetNames(opt *options.Options, datasets map[string]string) []string {
+	seen := map[string]bool{opt.Dataset: true}
+	names := []string{opt.Dataset}
+	var mapped []string
+	for _, name := range datasets {
+		if !seen[name] {
+			seen[name] = true
+			mapped = append(mapped, name)
+		}
+	}
+	sort.Strings(mapped)
+	return append(names, mapped...)
+}
+
+// Run sets up each of the datasets, logging rather than failing on errors,
+// since events can be sent regardless.
+func (s *DatasetSetup) Run(names []string) {
+	if s == nil {
+		return
+	}
+	for _, name := range names {
+		logger := logrus.WithField("dataset", name)
+		created, err := s.setup(name)
+		if err != nil {
+			logger.WithField("error", err).Error("Could not set up dataset")
+			continue
+		}
+		logger.WithField("columns_created", created).Info("Set up dataset")
+	}
+}
+
+// destination returns where the dataset's events are sent, which is where
+// it's set up.
+func (s *DatasetSetup) destination(name string) destination {
+	d := destination{apiHost: s.apiHost, writeKey: s.writeKey}
+	if own, ok := destinations[name]; ok {
+		if own.apiHost != "" {
+			d.apiHost = own.apiHost
+		}
+		if own.writeKey != "" {
+			d.writeKey = own.writeKey
+		}
+	}
+	return d
+}
+
+// setup creates the dataset, if it doesn't exist, and the known columns it
+// doesn't have yet, returning how many columns were created. Columns which
+// exist are left alone, even if they're typed differently, since changing
+// the type changes how the events already in them are queried.
+func (s *DatasetSetup) setup(name string) (int, error) {
+	dest := s.destination(name)
+	var dataset struct {
+		Slug string `json:"slug"`
+	}
+	// Creating a dataset which already exists returns it.
+	if err := s.do(dest, "POST", "/1/datasets", map[string]string{"name": name}, &dataset); err != nil {
+		return 0, err
+	}
+	if dataset.Slug == "" {
+		return 0, fmt.Errorf("no slug for dataset %q", name)
+	}
+
+	var columns []column
+	if err := s.do(dest, "GET", path.Join("/1/columns", url.PathEscape(dataset.Slug)), nil, &columns); err != nil {
+		return 0, err
+	}
+	existing := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		existing[c.KeyName] = true
+	}
+
+	created := 0
+	for _, c := range knownColumns {
+		if existing[c.KeyName] {
+			continue
+		}
+		c = durationColumn(c, s.durationUnit)
+		if err := s.do(dest, "POST", path.Join("/1/columns", url.PathEscape(dataset.Slug)), c, nil); err != nil {
+			return created, fmt.Errorf("creating column %s: %s", c.KeyName, err)
+		}
+		created++
+	}
+	return created, nil
+}
+
+// do sends the request to the Honeycomb API, JSON encoding the body and
+// decoding the response into out, if they aren't nil.
+func (s *DatasetSetup) do(dest destination, method, p string, body, out interface{}) error {
+	u, err := url.Parse(dest.apiHost)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join(u.Path, p)
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Honeycomb-Team", dest.writeKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, p, resp.Status, bytes.TrimSpace(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
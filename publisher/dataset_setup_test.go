@@ -0,0 +1,74 @@
+package publisher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+func TestDatasetSetup(t *testing.T) {
+	var created []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Honeycomb-Team") != "abc123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/1/datasets":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["name"] != "ALB Access" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"name": "ALB Access", "slug": "alb-access"}`))
+		case r.Method == "GET" && r.URL.Path == "/1/columns/alb-access":
+			// sent_bytes was already created by an event
+			w.Write([]byte(`[{"id": "c1", "key_name": "sent_bytes", "type": "string"}]`))
+		case r.Method == "POST" && r.URL.Path == "/1/columns/alb-access":
+			var c column
+			json.NewDecoder(r.Body).Decode(&c)
+			created = append(created, c.KeyName+":"+c.Type)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewDatasetSetup(&options.Options{SetupDatasets: true, APIHost: srv.URL, WriteKey: "abc123"})
+	n, err := s.setup("ALB Access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(knownColumns)-1 || len(created) != n {
+		t.Errorf("expected every known column but sent_bytes to be created, got %v", created)
+	}
+	for _, c := range created {
+		if c == "sent_bytes:integer" {
+			t.Error("expected the existing column to be left alone")
+		}
+	}
+	if created[0] != "request_processing_time:float" {
+		t.Errorf("expected latencies to be floats, got %s", created[0])
+	}
+
+	if _, err := s.setup("missing"); err == nil {
+		t.Error("expected an API error to be returned")
+	}
+}
+
+func TestDatasetNames(t *testing.T) {
+	names := datasetNames(&options.Options{Dataset: "aws-alb-access"}, map[string]string{"b": "b-access", "a": "a-access", "c": "aws-alb-access"})
+	if !reflect.DeepEqual(names, []string{"aws-alb-access", "a-access", "b-access"}) {
+		t.Errorf("unexpected dataset names %v", names)
+	}
+	if NewDatasetSetup(&options.Options{SetupDatasets: true, DryRun: true}) != nil {
+		t.Error("expected no dataset setup under --dry-run")
+	}
+}
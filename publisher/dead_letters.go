@@ -0,0 +1,188 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/honeycombio/gonx"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+// At most this many of an object's unparseable lines are written out; the
+// rest are only counted, so that an object in entirely the wrong format doesn't
+// have to be held in memory twice over.
+const maxDeadLetterLines = 10000
+
+// DeadLetterWriter writes the lines of each object which couldn't be parsed,
+// along with the object and line number, to --dead-letter-path: a local file
+// they're appended to, or an S3 prefix each object's are uploaded under. A
+// parse_failures event counting them is sent to Honeycomb too.
+type DeadLetterWriter struct {
+	// file is appended to, when writing to a local file.
+	file string
+	lock sync.Mutex
+
+	// bucket and prefix are uploaded to otherwise.
+	bucket, prefix string
+	uploader       s3manageriface.UploaderAPI
+}
+
+// NewDeadLetterWriter returns a writer for path, which is either a local file
+// or an s3://bucket/prefix URL.
+func NewDeadLetterWriter(sess *session.Session, path string) (*DeadLetterWriter, error) {
+	if !strings.HasPrefix(path, "s3://") {
+		return &DeadLetterWriter{file: path}, nil
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("dead letter path should be a local file or an s3://bucket/prefix URL, got %q", path)
+	}
+	return &DeadLetterWriter{
+		bucket:   u.Host,
+		prefix:   strings.TrimPrefix(u.Path, "/"),
+		uploader: s3manager.NewUploader(sess),
+	}, nil
+}
+
+// deadLetterLine is how each unparseable line is written out, one JSON object
+// per line.
+type deadLetterLine struct {
+	Object string `json:"object"`
+	Line   int64  `json:"line"`
+	Text   string `json:"text"`
+	Error  string `json:"error,omitempty"`
+}
+
+// unparseableLines collects an object's unparseable lines while it's parsed.
+// add is only called from the goroutine parsing the object.
+type unparseableLines struct {
+	object string
+	count  int
+	buf    bytes.Buffer
+}
+
+func (u *unparseableLines) add(line int64, text string, err error) {
+	u.count++
+	if u.count > maxDeadLetterLines {
+		return
+	}
+	l := deadLetterLine{Object: u.object, Line: line, Text: text}
+	if err != nil {
+		l.Error = err.Error()
+	}
+	// can't fail for strings and numbers
+	data, _ := json.Marshal(l)
+	u.buf.Write(data)
+	u.buf.WriteByte('\n')
+}
+
+// location returns where the object's unparseable lines are written to.
+func (w *DeadLetterWriter) location(object string) string {
+	if w.file != "" {
+		return w.file
+	}
+	return fmt.Sprintf("s3://%s/%s", w.bucket, w.key(object))
+}
+
+func (w *DeadLetterWriter) key(object string) string {
+	return path.Join(w.prefix, object) + ".jsonl"
+}
+
+func (w *DeadLetterWriter) write(u *unparseableLines) error {
+	if w.file == "" {
+		_, err := w.uploader.Upload(&s3manager.UploadInput{
+			Bucket:      aws.String(w.bucket),
+			Key:         aws.String(w.key(u.object)),
+			Body:        bytes.NewReader(u.buf.Bytes()),
+			ContentType: aws.String("application/x-ndjson"),
+		})
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	f, err := os.OpenFile(w.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(u.buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// record writes out the object's unparseable lines, if it had any, and sends
+// an event to Honeycomb counting them. Failing to doesn't fail publishing the
+// object, since its events have been sent regardless.
+func (w *DeadLetterWriter) record(u *unparseableLines) {
+	if w == nil || u.count == 0 {
+		return
+	}
+	location := w.location(u.object)
+	logger := logrus.WithFields(logrus.Fields{
+		"object":      u.object,
+		"lines":       u.count,
+		"dead_letter": location,
+	})
+	if u.count > maxDeadLetterLines {
+		logger.WithField("written", maxDeadLetterLines).Warn("Too many unparseable lines to write them all out")
+	}
+	if err := w.write(u); err != nil {
+		logger.WithField("error", err).Error("Could not write out unparseable lines")
+	} else {
+		logger.Info("Wrote out unparseable lines")
+	}
+
+	libhEv := libhoney.NewEvent()
+	applyDestination(libhEv)
+	if err := libhEv.Add(parseFailuresEvent(u, location)); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error adding data to parse failures event")
+		return
+	}
+	if err := libhEv.SendPresampled(); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error sending parse failures event")
+	}
+}
+
+func parseFailuresEvent(u *unparseableLines, location string) map[string]interface{} {
+	return map[string]interface{}{
+		"meta.type":      "parse_failures",
+		"object":         u.object,
+		"parse_failures": u.count,
+		"dead_letter":    location,
+	}
+}
+
+// lineChecker returns a func checking lines against the named nginx log format
+// before they're handed to the nginx parser, which drops those it can't parse
+// without saying so. Lines are only checked when the object's unparseable
+// lines are being recorded.
+func lineChecker(obj state.DownloadedObject, format string) func(line string) error {
+	if obj.Unparseable == nil {
+		return func(string) error { return nil }
+	}
+	p, err := gonx.NewNginxParser(bytes.NewReader(logFormat), format)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"format": format,
+			"error":  err,
+		}).Fatal("Can't initialize the line checker")
+	}
+	return func(line string) error {
+		_, err := p.ParseString(line)
+		return err
+	}
+}
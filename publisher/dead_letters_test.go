@@ -0,0 +1,94 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "elb.log")
+	if err := ioutil.WriteFile(logFile, []byte(`2017-07-31T20:30:57.975041Z spline_reticulation_lb 10.11.12.13:47882 10.3.47.87:8080 0.000021 0.010962 -1 504 504 766 17 "PUT https://api.simulation.io:443/reticulate/spline/1 HTTP/1.1" "libhoney-go/1.3.3" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2
+this is not an access log line
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &unparseableLines{object: "AWSLogs/elb.log"}
+	obj := state.DownloadedObject{Object: u.object, Filename: logFile, Unparseable: u.add}
+	out := make(chan event.Event, 2)
+	if err := NewELBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"}).ParseEvents(obj, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	if events := len(out); events != 1 {
+		t.Errorf("expected the good line to be parsed, got %d events", events)
+	}
+	if u.count != 1 {
+		t.Fatalf("expected 1 unparseable line, got %d", u.count)
+	}
+
+	w, err := NewDeadLetterWriter(nil, filepath.Join(dir, "dead-letters.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lines are appended, object after object
+	for i := 0; i < 2; i++ {
+		if err := w.write(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(w.location(u.object))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []deadLetterLine
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var l deadLetterLine
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 dead letter lines, got %v", lines)
+	}
+	if l := lines[0]; l.Object != "AWSLogs/elb.log" || l.Line != 2 || l.Text != "this is not an access log line" || l.Error == "" {
+		t.Errorf("unexpected dead letter line %+v", l)
+	}
+
+	data := parseFailuresEvent(u, w.location(u.object))
+	if data["meta.type"] != "parse_failures" || data["parse_failures"] != 1 {
+		t.Errorf("unexpected parse failures event %v", data)
+	}
+}
+
+func TestNewDeadLetterWriterS3(t *testing.T) {
+	w, err := NewDeadLetterWriter(session.Must(session.NewSession()), "s3://honeyaws-dead-letters/unparseable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := w.location("AWSLogs/elb.log.gz"); loc != "s3://honeyaws-dead-letters/unparseable/AWSLogs/elb.log.gz.jsonl" {
+		t.Errorf("unexpected location %s", loc)
+	}
+
+	if _, err := NewDeadLetterWriter(nil, "s3:///unparseable"); err == nil {
+		t.Error("expected a URL without a bucket to be rejected")
+	}
+}
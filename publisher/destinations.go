@@ -0,0 +1,75 @@
+package publisher
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go"
+)
+
+// destination is where the --config file sends a dataset's events.
+type destination struct {
+	apiHost, writeKey string
+}
+
+// destinations are the datasets sent somewhere other than --api_host with
+// --writekey, by name.
+var destinations map[string]destination
+
+// honeycombTransport is what requests to the Honeycomb API are sent with,
+// once libhoney is initialized.
+var honeycombTransport http.RoundTripper
+
+func newDestinations(configs map[string]options.DatasetConfig) map[string]destination {
+	if len(configs) == 0 {
+		return nil
+	}
+	d := make(map[string]destination, len(configs))
+	for name, config := range configs {
+		d[name] = destination{apiHost: config.APIHost, writeKey: config.WriteKey}
+	}
+	return d
+}
+
+// applyDestination points the event at where its dataset is sent: the API
+// host and write key the --config file gives the dataset, if any, or else the
+// fallback write key once we've failed over. Datasets with their own write key
+// don't fail over.
+func applyDestination(ev *libhoney.Event) {
+	d, ok := destinations[ev.Dataset]
+	if !ok {
+		failover.apply(ev)
+		return
+	}
+	d.apply(ev)
+}
+
+// apply points the event at the destination, failing over unless it has its
+// own write key.
+func (d destination) apply(ev *libhoney.Event) {
+	if d.writeKey != "" {
+		ev.WriteKey = d.writeKey
+	} else {
+		failover.apply(ev)
+	}
+	if d.apiHost != "" {
+		ev.APIHost = d.apiHost
+	}
+}
+
+// newTransport returns the transport to send to the Honeycomb API with,
+// through the proxy if one is given, or else the one in the HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY environment variables.
+func newTransport(proxy string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return t, nil
+}
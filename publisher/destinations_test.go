@@ -0,0 +1,61 @@
+package publisher
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go"
+)
+
+func TestApplyDestination(t *testing.T) {
+	defer func(d map[string]destination, f *writeKeyFailover) { destinations, failover = d, f }(destinations, failover)
+	destinations = newDestinations(map[string]options.DatasetConfig{
+		"eu-access":    {APIHost: "https://api.eu1.honeycomb.io/", WriteKey: "eu"},
+		"other-access": {APIHost: "https://honeycomb.internal/"},
+	})
+	failover = &writeKeyFailover{writeKey: "fallback", failedOver: true}
+
+	ev := &libhoney.Event{Dataset: "eu-access", WriteKey: "primary", APIHost: "https://api.honeycomb.io/"}
+	applyDestination(ev)
+	if ev.WriteKey != "eu" || ev.APIHost != "https://api.eu1.honeycomb.io/" {
+		t.Errorf("expected the dataset's own destination, without failing over, got %s %s", ev.WriteKey, ev.APIHost)
+	}
+
+	// a dataset with just its own host still fails over
+	ev = &libhoney.Event{Dataset: "other-access", WriteKey: "primary"}
+	applyDestination(ev)
+	if ev.WriteKey != "fallback" || ev.APIHost != "https://honeycomb.internal/" {
+		t.Errorf("expected the dataset's host with the fallback write key, got %s %s", ev.WriteKey, ev.APIHost)
+	}
+
+	ev = &libhoney.Event{Dataset: "aws-alb-access", WriteKey: "primary"}
+	applyDestination(ev)
+	if ev.WriteKey != "fallback" {
+		t.Errorf("expected other datasets to fail over, got %s", ev.WriteKey)
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://api.honeycomb.io/1/batch/foo", nil)
+
+	tr, err := newTransport("http://egress.internal:3128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := tr.Proxy(req); err != nil || u.String() != "http://egress.internal:3128" {
+		t.Errorf("expected --http_proxy to be used, got %v, %v", u, err)
+	}
+
+	// ProxyFromEnvironment reads the environment once per process, so
+	// only check there's a proxy func without --http_proxy.
+	if tr, err = newTransport(""); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Proxy == nil {
+		t.Error("expected the proxy to come from the environment")
+	}
+	if _, err := newTransport("http://[::1"); err == nil {
+		t.Error("expected an invalid --http_proxy to be an error")
+	}
+}
@@ -0,0 +1,209 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+// Distinct values of a field are only counted up to this many, so that a
+// high cardinality field (e.g. the request URL) doesn't use up memory.
+const maxDistinctValues = 1000
+
+// dryRunSender is a libhoney transmission.Sender for --dry-run that, instead
+// of sending events to Honeycomb, prints the first samples of them as JSON and
+// keeps statistics about their fields, to be reported once done. Events after
+// those are dropped.
+type dryRunSender struct {
+	sync.Mutex
+	out       io.Writer
+	samples   int
+	printed   int
+	fields    map[string]*fieldStats
+	responses chan transmission.Response
+	done      chan struct{}
+	// probe, if set, sends the first sample event of each dataset to
+	// Honeycomb, for --dry-run-probe.
+	probe *dryRunProbe
+}
+
+// fieldStats is what's known about a field of the sample events.
+type fieldStats struct {
+	events   int
+	types    map[string]bool
+	values   map[string]bool
+	min, max float64
+	numbers  int
+}
+
+type dryRunEvent struct {
+	Dataset    string                 `json:"dataset"`
+	Timestamp  time.Time              `json:"time"`
+	SampleRate uint                   `json:"samplerate"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+func newDryRunSender(out io.Writer, samples int) *dryRunSender {
+	if samples < 1 {
+		samples = 1
+	}
+	return &dryRunSender{
+		out:       out,
+		samples:   samples,
+		fields:    make(map[string]*fieldStats),
+		responses: make(chan transmission.Response, 2*samples),
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *dryRunSender) Start() error { return nil }
+func (s *dryRunSender) Stop() error  { return nil }
+func (s *dryRunSender) Flush() error { return nil }
+
+func (s *dryRunSender) Add(ev *transmission.Event) {
+	s.Lock()
+	defer s.Unlock()
+	if s.printed >= s.samples {
+		return
+	}
+
+	data, err := json.Marshal(dryRunEvent{
+		Dataset:    ev.Dataset,
+		Timestamp:  ev.Timestamp,
+		SampleRate: ev.SampleRate,
+		Data:       ev.Data,
+	})
+	if err != nil {
+		s.SendResponse(transmission.Response{Err: err, Metadata: ev.Metadata})
+		return
+	}
+	fmt.Fprintln(s.out, string(data))
+	s.observe(ev.Data)
+	s.probe.probe(ev)
+
+	s.printed++
+	if s.printed == s.samples {
+		close(s.done)
+	}
+	s.SendResponse(transmission.Response{StatusCode: http.StatusAccepted, Metadata: ev.Metadata})
+}
+
+func (s *dryRunSender) observe(data map[string]interface{}) {
+	for k, v := range data {
+		stats, ok := s.fields[k]
+		if !ok {
+			stats = &fieldStats{types: make(map[string]bool), values: make(map[string]bool)}
+			s.fields[k] = stats
+		}
+		stats.events++
+
+		var n float64
+		isNumber := true
+		switch v := v.(type) {
+		case int:
+			n = float64(v)
+		case int64:
+			n = float64(v)
+		case float64:
+			n = v
+		default:
+			isNumber = false
+		}
+		if isNumber {
+			stats.types["number"] = true
+			if stats.numbers == 0 || n < stats.min {
+				stats.min = n
+			}
+			if stats.numbers == 0 || n > stats.max {
+				stats.max = n
+			}
+			stats.numbers++
+		} else {
+			stats.types[fmt.Sprintf("%T", v)] = true
+		}
+
+		if len(stats.values) < maxDistinctValues {
+			stats.values[fmt.Sprint(v)] = true
+		}
+	}
+}
+
+// report prints how often each field was set in the sample events, its
+// types, how many distinct values it had and, for numbers, their range, and
+// how the probes went, returning an error if any of them were rejected.
+func (s *dryRunSender) report() error {
+	s.Lock()
+	defer s.Unlock()
+
+	names := make([]string, 0, len(s.fields))
+	for k := range s.fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(s.out, "\n%d sample events, %d fields\n\n", s.printed, len(names))
+	w := tabwriter.NewWriter(s.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tEVENTS\tTYPES\tDISTINCT\tMIN\tMAX")
+	for _, k := range names {
+		stats := s.fields[k]
+		types := make([]string, 0, len(stats.types))
+		for t := range stats.types {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+
+		distinct := fmt.Sprint(len(stats.values))
+		if len(stats.values) >= maxDistinctValues {
+			distinct += "+"
+		}
+		min, max := "", ""
+		if stats.numbers > 0 {
+			min, max = fmt.Sprint(stats.min), fmt.Sprint(stats.max)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", k, stats.events, strings.Join(types, ","), distinct, min, max)
+	}
+	w.Flush()
+	return s.probe.report(s.out)
+}
+
+func (s *dryRunSender) TxResponses() chan transmission.Response {
+	return s.responses
+}
+
+// SendResponse doesn't block if nothing is reading the responses, like the
+// default libhoney transmission.
+func (s *dryRunSender) SendResponse(resp transmission.Response) bool {
+	select {
+	case s.responses <- resp:
+		return false
+	default:
+		return true
+	}
+}
+
+// DryRunDone is closed once --dry-run has printed every sample event. It's
+// nil, so never closed, without --dry-run.
+func (hp *HoneycombPublisher) DryRunDone() <-chan struct{} {
+	if dryRun == nil {
+		return nil
+	}
+	return dryRun.done
+}
+
+// ReportDryRun prints statistics about the fields of the sample events
+// printed by --dry-run, if it's set, and how the --dry-run-probe probes went,
+// returning an error if any of them were rejected.
+func (hp *HoneycombPublisher) ReportDryRun() error {
+	if dryRun != nil {
+		return dryRun.report()
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+const probeTimeout = 10 * time.Second
+
+// Honeycomb's limits on an event, which it rejects (or truncates) events
+// beyond.
+const (
+	maxEventColumns = 2000
+	maxEventBytes   = 1 << 20
+	maxStringBytes  = 64 << 10
+)
+
+var (
+	classicKey      = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	datasetSlugSkip = regexp.MustCompile(`[^a-z0-9._-]+`)
+)
+
+// dryRunProbe sends the first of --dry-run's sample events to each dataset to
+// Honeycomb for real, for --dry-run-probe: whether they're accepted says that
+// the dataset name can be used, that the write key may send (and create
+// datasets) and that the events are within Honeycomb's limits, before a full
+// run finds out. Each probe is tagged meta.dry_run_probe, and sent with a
+// sample rate of 1, so it counts as the one event.
+type dryRunProbe struct {
+	apiHost, writeKey string
+	client            *http.Client
+	results           []probeResult
+	probed            map[string]bool
+}
+
+// probeResult is how the probe of a dataset went: the error, if it was
+// rejected, and what may be up, going by the key and the dataset name.
+type probeResult struct {
+	dataset string
+	key     string
+	err     error
+	hints   []string
+}
+
+func newDryRunProbe(opt *options.Options) (*dryRunProbe, error) {
+	if !opt.DryRunProbe {
+		return nil, nil
+	}
+	if !opt.DryRun {
+		return nil, fmt.Errorf("--dry-run-probe requires --dry-run")
+	}
+	if opt.WriteKey == "" {
+		return nil, fmt.Errorf("--dry-run-probe requires --writekey, the key the full run would send with")
+	}
+	return &dryRunProbe{
+		apiHost:  opt.APIHost,
+		writeKey: opt.WriteKey,
+		client:   &http.Client{Timeout: probeTimeout, Transport: honeycombTransport},
+		probed:   make(map[string]bool),
+	}, nil
+}
+
+// keyKind tells the kinds of Honeycomb API keys apart by their format: 32 hex
+// digits for Classic configuration keys, hcaic_ and hcaik_ prefixes for Classic
+// and environment ingest keys, and anything else for environment
+// configuration keys.
+func keyKind(key string) string {
+	switch {
+	case classicKey.MatchString(key):
+		return "Classic configuration key"
+	case strings.HasPrefix(key, "hcaic_"):
+		return "Classic ingest key"
+	case strings.HasPrefix(key, "hcaik_"), strings.HasPrefix(key, "hcxik_"):
+		return "environment ingest key"
+	}
+	return "environment configuration key"
+}
+
+// probe sends the event to its dataset, unless one has been already.
+func (p *dryRunProbe) probe(ev *transmission.Event) {
+	if p == nil || p.probed[ev.Dataset] {
+		return
+	}
+	p.probed[ev.Dataset] = true
+
+	apiHost, writeKey := p.apiHost, p.writeKey
+	if d, ok := destinations[ev.Dataset]; ok {
+		if d.writeKey != "" {
+			writeKey = d.writeKey
+		}
+		if d.apiHost != "" {
+			apiHost = d.apiHost
+		}
+	}
+	result := probeResult{dataset: ev.Dataset, key: keyKind(writeKey)}
+	result.hints = datasetHints(ev.Dataset, result.key)
+
+	data := make(map[string]interface{}, len(ev.Data)+1)
+	for k, v := range ev.Data {
+		data[k] = v
+	}
+	data["meta.dry_run_probe"] = true
+	body, err := json.Marshal(data)
+	if err != nil {
+		result.err = err
+	} else if result.err = eventLimits(data, len(body)); result.err == nil {
+		var status int
+		status, result.err = p.send(apiHost, writeKey, ev.Dataset, ev.Timestamp, body)
+		switch status {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			result.hints = append(result.hints, fmt.Sprintf("%s didn't let the %s send to the dataset: a Classic key only sends to Classic datasets, and an environment key to its own environment's, creating them only if it's allowed to", apiHost, result.key))
+		case http.StatusNotFound:
+			result.hints = append(result.hints, "the dataset doesn't exist, and the key isn't allowed to create it")
+		}
+	}
+	p.results = append(p.results, result)
+}
+
+// eventLimits returns an error if the event is beyond Honeycomb's limits.
+func eventLimits(data map[string]interface{}, size int) error {
+	if len(data) > maxEventColumns {
+		return fmt.Errorf("the event has %d fields, more than the %d columns Honeycomb allows an event", len(data), maxEventColumns)
+	}
+	if size > maxEventBytes {
+		return fmt.Errorf("the event is %d bytes, more than the %d Honeycomb allows", size, maxEventBytes)
+	}
+	for k, v := range data {
+		if s, ok := v.(string); ok && len(s) > maxStringBytes {
+			return fmt.Errorf("%s is %d bytes, more than the %d Honeycomb keeps of a string", k, len(s), maxStringBytes)
+		}
+	}
+	return nil
+}
+
+// datasetHints returns what may be wrong with the dataset name for the kind of
+// key: environments go by the dataset's slug, so the name sent may not be the
+// one the dataset ends up with.
+func datasetHints(dataset, key string) []string {
+	if strings.TrimSpace(dataset) != dataset || dataset == "" {
+		return []string{fmt.Sprintf("the dataset name %q has leading or trailing spaces", dataset)}
+	}
+	if strings.HasPrefix(key, "Classic") {
+		return nil
+	}
+	if slug := strings.Trim(datasetSlugSkip.ReplaceAllString(strings.ToLower(dataset), "-"), "-"); slug != dataset {
+		return []string{fmt.Sprintf("with an environment key the dataset is %q, not %q; Classic datasets keep their names as they are", slug, dataset)}
+	}
+	return nil
+}
+
+// send posts the event to the dataset with the Events API, returning the
+// response's status.
+func (p *dryRunProbe) send(apiHost, writeKey, dataset string, timestamp time.Time, body []byte) (int, error) {
+	u, err := url.Parse(apiHost)
+	if err != nil {
+		return 0, err
+	}
+	// resolved from the escaped path, so dataset names with slashes or
+	// spaces are escaped once
+	u, err = u.Parse(path.Join(u.EscapedPath(), "/1/events", url.PathEscape(dataset)))
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Honeycomb-Team", writeKey)
+	req.Header.Set("X-Honeycomb-Samplerate", "1")
+	req.Header.Set("X-Honeycomb-Event-Time", timestamp.Format(time.RFC3339Nano))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	return resp.StatusCode, nil
+}
+
+// report prints how each dataset's probe went, returning an error if any of
+// them was rejected.
+func (p *dryRunProbe) report(out io.Writer) error {
+	if p == nil {
+		return nil
+	}
+	fmt.Fprintf(out, "\n%d datasets probed\n\n", len(p.results))
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DATASET\tKEY\tRESULT")
+	failed := 0
+	for _, r := range p.results {
+		result := "accepted"
+		if r.err != nil {
+			result = "rejected: " + r.err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.dataset, r.key, result)
+	}
+	w.Flush()
+	for _, r := range p.results {
+		for _, hint := range r.hints {
+			fmt.Fprintf(out, "%s: %s\n", r.dataset, hint)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d datasets rejected the probe", failed, len(p.results))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func TestDryRunProbe(t *testing.T) {
+	sent := map[string]map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dataset := strings.TrimPrefix(r.URL.Path, "/1/events/")
+		if r.Header.Get("X-Honeycomb-Team") != "hcaik_01abcdefghijklmnopqrstuvwx" || dataset == "Other Team" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unknown API key - check your credentials"}`))
+			return
+		}
+		var data map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&data)
+		sent[dataset] = data
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	if _, err := newDryRunProbe(&options.Options{DryRunProbe: true, WriteKey: "hcaik_01abcdefghijklmnopqrstuvwx"}); err == nil {
+		t.Error("expected --dry-run-probe without --dry-run to be refused")
+	}
+	if _, err := newDryRunProbe(&options.Options{DryRunProbe: true, DryRun: true}); err == nil {
+		t.Error("expected --dry-run-probe without --writekey to be refused")
+	}
+	probe, err := newDryRunProbe(&options.Options{DryRunProbe: true, DryRun: true, APIHost: srv.URL, WriteKey: "hcaik_01abcdefghijklmnopqrstuvwx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	s := newDryRunSender(&out, 4)
+	s.probe = probe
+	for _, dataset := range []string{"aws-alb-access", "aws-alb-access", "AWS ELB Access", "Other Team"} {
+		s.Add(&transmission.Event{Dataset: dataset, Timestamp: time.Now(), SampleRate: 1, Data: map[string]interface{}{"elb_status_code": 200}})
+	}
+	if len(probe.results) != 3 {
+		t.Errorf("expected one probe per dataset, got %+v", probe.results)
+	}
+	if data := sent["aws-alb-access"]; data["meta.dry_run_probe"] != true || data["elb_status_code"] != float64(200) {
+		t.Errorf("expected the sample event to be sent tagged as a probe, got %v", data)
+	}
+
+	out.Reset()
+	err = s.report()
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 datasets") {
+		t.Errorf("expected the rejected probe to fail the dry run, got %v", err)
+	}
+	report := out.String()
+	for _, expected := range []string{
+		"aws-alb-access  environment ingest key  accepted",
+		"Other Team      environment ingest key  rejected: 401 Unauthorized",
+		`AWS ELB Access: with an environment key the dataset is "aws-elb-access", not "AWS ELB Access"`,
+		"Other Team: " + srv.URL + " didn't let the environment ingest key send to the dataset",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the report to contain %q, got:\n%s", expected, report)
+		}
+	}
+}
+
+func TestKeyKind(t *testing.T) {
+	for key, expected := range map[string]string{
+		"0123456789abcdef0123456789abcdef":                                 "Classic configuration key",
+		"hcaic_01abcdefghijklmnopqrstuvwx0123456789abcdefghijklmnopqrstuv": "Classic ingest key",
+		"hcaik_01abcdefghijklmnopqrstuvwx0123456789abcdefghijklmnopqrstuv": "environment ingest key",
+		"Abcdefghijklmnopqrstuv":                                           "environment configuration key",
+	} {
+		if kind := keyKind(key); kind != expected {
+			t.Errorf("expected %s to be a %s, got %s", key, expected, kind)
+		}
+	}
+	if hints := datasetHints("AWS ELB Access", keyKind("0123456789abcdef0123456789abcdef")); len(hints) != 0 {
+		t.Errorf("expected Classic datasets to keep their names, got %v", hints)
+	}
+}
+
+func TestEventLimits(t *testing.T) {
+	if err := eventLimits(map[string]interface{}{"request": "GET /"}, 100); err != nil {
+		t.Errorf("expected a small event to be within the limits, got %v", err)
+	}
+	wide := map[string]interface{}{}
+	for i := 0; i <= maxEventColumns; i++ {
+		wide[strings.Repeat("f", i+1)] = i
+	}
+	if err := eventLimits(wide, 100); err == nil {
+		t.Error("expected an event with too many fields to be beyond the limits")
+	}
+	if err := eventLimits(map[string]interface{}{"request": strings.Repeat("x", maxStringBytes+1)}, maxStringBytes+20); err == nil {
+		t.Error("expected an event with too long a string to be beyond the limits")
+	}
+	if err := eventLimits(map[string]interface{}{}, maxEventBytes+1); err == nil {
+		t.Error("expected too big an event to be beyond the limits")
+	}
+}
@@ -0,0 +1,56 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func TestDryRunSender(t *testing.T) {
+	var out bytes.Buffer
+	s := newDryRunSender(&out, 2)
+
+	for _, data := range []map[string]interface{}{
+		{"elb_status_code": 200, "request_path": "/users", "request_processing_time": 0.5},
+		{"elb_status_code": 503, "request_path": "/users"},
+		{"elb_status_code": 404, "request_path": "/missing"},
+	} {
+		s.Add(&transmission.Event{Dataset: "aws-alb-access", Timestamp: time.Now(), SampleRate: 1, Data: data})
+	}
+
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("expected the dry run to be done once the samples are printed")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected only 2 sample events to be printed, got %q", out.String())
+	}
+	var ev dryRunEvent
+	if err := json.Unmarshal([]byte(lines[0]), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Dataset != "aws-alb-access" || ev.Data["request_path"] != "/users" {
+		t.Errorf("unexpected sample event %s", lines[0])
+	}
+
+	out.Reset()
+	s.report()
+	report := out.String()
+	for _, expected := range []string{
+		"2 sample events, 3 fields",
+		"elb_status_code          2       number  2         200  503",
+		"request_path             2       string  1",
+		"request_processing_time  1       number  1         0.5  0.5",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the report to contain %q, got:\n%s", expected, report)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package publisher
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// The --duration-unit values.
+const (
+	durationMillis  = "ms"
+	durationSeconds = "s"
+)
+
+// durationFields are the fields which are durations, by the unit they're
+// logged in. ELB, ALB and CloudFront log seconds, while NLB and API Gateway
+// log milliseconds.
+var durationFields = map[string]time.Duration{
+	// ELB and ALB, and those addLatencyFields derives from them
+	"request_processing_time":  time.Second,
+	"backend_processing_time":  time.Second,
+	"response_processing_time": time.Second,
+	"total_time":               time.Second,
+	// CloudFront, which logs time_to_first_byte too
+	"time_to_first_byte": time.Second,
+	"time_taken":         time.Second,
+	// NLB
+	"connection_time":    time.Millisecond,
+	"tls_handshake_time": time.Millisecond,
+	// ALB connection logs
+	"tls_handshake_latency": time.Second,
+	// API Gateway
+	"responseLatency":    time.Millisecond,
+	"integrationLatency": time.Millisecond,
+	"latency":            time.Millisecond,
+}
+
+// durationUnits are the --duration-unit values, and how their descriptions
+// of columns start.
+var durationUnits = map[string]struct {
+	unit time.Duration
+	name string
+}{
+	durationMillis:  {time.Millisecond, "Milliseconds"},
+	durationSeconds: {time.Second, "Seconds"},
+}
+
+// convertDuration returns the duration v, logged in the field, in the unit, or
+// false if the unit isn't set, the field isn't one of durationFields or is
+// already logged in the unit, or v isn't a number, e.g. a "-" NLB logs for
+// connections which didn't complete. It goes by nanoseconds, so that e.g.
+// 0.010962 seconds is 10.962 milliseconds rather than 10.962000000000001.
+func convertDuration(field string, v interface{}, unit string) (float64, bool) {
+	to, ok := durationUnits[unit]
+	if !ok {
+		return 0, false
+	}
+	from, ok := durationFields[field]
+	if !ok || from == to.unit {
+		return 0, false
+	}
+	var n float64
+	switch v := v.(type) {
+	case float64:
+		n = v
+	case int64:
+		n = float64(v)
+	default:
+		return 0, false
+	}
+	ns := math.Round(n * float64(from))
+	return ns / float64(to.unit), true
+}
+
+// normalizeDurations sends the event's durations in the unit, for
+// --duration-unit. It's applied after the processing times which are -1
+// have been dropped, and after rollups and the sampler have seen them, so
+// those still go by seconds.
+func normalizeDurations(data map[string]interface{}, unit string) {
+	for field, v := range data {
+		if d, ok := convertDuration(field, v, unit); ok {
+			data[field] = d
+		}
+	}
+}
+
+// durationColumn returns the column, with its description in the unit if
+// it's a duration.
+func durationColumn(c column, unit string) column {
+	to, ok := durationUnits[unit]
+	from, isDuration := durationFields[c.KeyName]
+	if !ok || !isDuration || from == to.unit {
+		return c
+	}
+	for _, u := range durationUnits {
+		if u.unit == from && strings.HasPrefix(c.Description, u.name) {
+			c.Description = to.name + strings.TrimPrefix(c.Description, u.name)
+		}
+	}
+	return c
+}
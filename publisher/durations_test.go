@@ -0,0 +1,86 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestNormalizeDurations(t *testing.T) {
+	alb := func() map[string]interface{} {
+		return map[string]interface{}{
+			"request_processing_time":  0.000021,
+			"backend_processing_time":  0.010962,
+			"response_processing_time": int64(0),
+			"total_time":               0.010983,
+			"time_to_first_byte_pct":   99.8,
+			"elb_status_code":          int64(200),
+		}
+	}
+	data := alb()
+	normalizeDurations(data, durationMillis)
+	expected := map[string]interface{}{
+		"request_processing_time":  0.021,
+		"backend_processing_time":  10.962,
+		"response_processing_time": float64(0),
+		"total_time":               10.983,
+		"time_to_first_byte_pct":   99.8,
+		"elb_status_code":          int64(200),
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %v, got %v", expected, data)
+	}
+
+	data = alb()
+	normalizeDurations(data, durationSeconds)
+	if !reflect.DeepEqual(data, alb()) {
+		t.Errorf("expected seconds to be left as they are, got %v", data)
+	}
+
+	nlb := map[string]interface{}{
+		"connection_time":    int64(5),
+		"tls_handshake_time": "-",
+	}
+	normalizeDurations(nlb, durationSeconds)
+	if nlb["connection_time"] != 0.005 || nlb["tls_handshake_time"] != "-" {
+		t.Errorf("expected milliseconds in seconds and - as it is, got %v", nlb)
+	}
+
+	apiGateway := map[string]interface{}{"responseLatency": int64(1250), "integrationLatency": int64(1200)}
+	normalizeDurations(apiGateway, durationMillis)
+	if apiGateway["responseLatency"] != int64(1250) || apiGateway["integrationLatency"] != int64(1200) {
+		t.Errorf("expected milliseconds to be left as they are, got %v", apiGateway)
+	}
+}
+
+func TestDurationColumn(t *testing.T) {
+	c := column{KeyName: "backend_processing_time", Type: "float", Description: "Seconds from the load balancer sending the request to the target starting to respond"}
+	if d := durationColumn(c, durationMillis).Description; d != "Milliseconds from the load balancer sending the request to the target starting to respond" {
+		t.Errorf("expected the description in milliseconds, got %q", d)
+	}
+	if d := durationColumn(c, durationSeconds).Description; d != c.Description {
+		t.Errorf("expected the description as it is, got %q", d)
+	}
+	if d := durationColumn(c, "").Description; d != c.Description {
+		t.Errorf("expected the description as it is without --duration-unit, got %q", d)
+	}
+	status := column{KeyName: "elb_status_code", Type: "integer", Description: "Status code of the load balancer's response"}
+	if durationColumn(status, durationMillis) != status {
+		t.Error("expected columns which aren't durations to be left as they are")
+	}
+}
+
+func TestLatencyHeartbeatDurationUnit(t *testing.T) {
+	hb := newLatencyHeartbeat(durationMillis)
+	for i := 0; i < 10; i++ {
+		hb.add(event.Event{Data: map[string]interface{}{
+			"elb":                     "app/foo-alb/1db0c9806095122a",
+			"backend_processing_time": 0.25,
+		}})
+	}
+	heartbeats := hb.flush()
+	if len(heartbeats) != 1 || heartbeats[0]["backend_processing_time.p50"] != float64(250) {
+		t.Errorf("expected the percentiles in milliseconds, got %v", heartbeats)
+	}
+}
@@ -1,14 +1,12 @@
 package publisher
 
 import (
-	"bufio"
 	"fmt"
 	"math/rand"
-	"os"
 	"runtime"
 	"strings"
 
-	dynsampler "github.com/honeycombio/dynsampler-go"
+	"github.com/honeycombio/honeyaws/logbucket"
 	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeyaws/sampler"
 	"github.com/honeycombio/honeyaws/state"
@@ -18,22 +16,11 @@ import (
 )
 
 type ELBEventParser struct {
-	sampler dynsampler.Sampler
+	dynamicSampling
 }
 
 func NewELBEventParser(opt *options.Options) *ELBEventParser {
-	s, err := sampler.NewSamplerFromOptions(opt)
-	if err != nil {
-		logrus.WithField("err", err).Fatal("couldn't build sampler from arguments")
-	}
-
-	ep := &ELBEventParser{sampler: s}
-
-	if err := ep.sampler.Start(); err != nil {
-		logrus.WithField("err", err).Fatal("Couldn't start dynamic sampler")
-	}
-
-	return ep
+	return &ELBEventParser{dynamicSampling: newDynamicSampling(opt)}
 }
 
 func (ep *ELBEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
@@ -50,31 +37,68 @@ func (ep *ELBEventParser) ParseEvents(obj state.DownloadedObject, out chan<- eve
 	}
 
 	linesCh := make(chan string)
+	eventsCh := make(chan event.Event)
+
+	// ProcessLines returns once every line has been parsed and sent
+	// along, which lets us do the same
+	parsed := make(chan struct{})
+	go func() {
+		np.ProcessLines(linesCh, eventsCh, nil)
+		close(eventsCh)
+	}()
+	go func() {
+		for ev := range eventsCh {
+			markTCPEvent(&ev)
+			out <- ev
+		}
+		close(parsed)
+	}()
 
-	go np.ProcessLines(linesCh, out, nil)
-
-	f, err := os.Open(obj.Filename)
+	r, err := logbucket.Open(obj)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
+	defer r.Close()
 
-	scanner := bufio.NewScanner(f)
+	scanner := newLineScanner(obj, r)
+	check := lineChecker(obj, AWSElasticLoadBalancerFormat)
 
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
+		if err := check(line); err != nil {
+			scanner.unparseable(line, err)
+			continue
+		}
 		linesCh <- line
 	}
 
 	close(linesCh)
+	<-parsed
 
 	return scanner.Err()
 }
 
+// markTCPEvent tidies up events from TCP and SSL listeners, which are logged
+// with "- - - " as the request and no status codes or user agent: there's no
+// request to shape, so it's removed, and the connection is marked with its
+// listener protocol instead.
+func markTCPEvent(ev *event.Event) {
+	request, ok := ev.Data["request"].(string)
+	if !ok || strings.TrimSpace(request) != "- - -" {
+		return
+	}
+	delete(ev.Data, "request")
+	if _, ok := ev.Data["ssl_cipher"]; ok {
+		ev.Data["listener_protocol"] = "ssl"
+	} else {
+		ev.Data["listener_protocol"] = "tcp"
+	}
+}
+
 func (ep *ELBEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
 	for ev := range in {
 		// use backend_status_code and elb_status_code to set sample rate
@@ -112,6 +136,11 @@ func (ep *ELBEventParser) DynSample(in <-chan event.Event, out chan<- event.Even
 			}
 		}
 
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
 		rate := ep.sampler.GetSampleRate(key)
 		if rate <= 0 {
 			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
@@ -120,6 +149,8 @@ func (ep *ELBEventParser) DynSample(in <-chan event.Event, out chan<- event.Even
 		if rand.Intn(rate) == 0 {
 			ev.SampleRate = rate
 			out <- ev
+		} else {
+			discard(ev)
 		}
 	}
 }
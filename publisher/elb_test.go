@@ -14,7 +14,7 @@ import (
 
 func TestNginxParseEvents(t *testing.T) {
 	elbPubisher := NewELBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"})
-	outCh := make(chan event.Event)
+	outCh := make(chan event.Event, 1)
 	tmpFile, err := ioutil.TempFile("", "")
 	if err != nil {
 		t.Fatal("Shouldn't have err but did: ", err)
@@ -60,3 +60,52 @@ func TestNginxParseEvents(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestParseTCPEvents(t *testing.T) {
+	elbPublisher := NewELBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"})
+	outCh := make(chan event.Event, 2)
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write([]byte(`2015-05-13T23:39:43.945958Z my-loadbalancer 192.168.131.39:2817 10.0.0.1:80 0.001069 0.000028 0.000041 - - 82 305 "- - - " "-" - -
+2015-05-13T23:39:43.945958Z my-loadbalancer 192.168.131.39:2817 10.0.0.1:443 0.001065 0.000015 0.000023 - - 57 502 "- - - " "-" ECDHE-ECDSA-AES128-GCM-SHA256 TLSv1.2
+`)); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	obj := state.DownloadedObject{
+		Object:   "foo",
+		Filename: tmpFile.Name(),
+	}
+	if err := elbPublisher.ParseEvents(obj, outCh); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	close(outCh)
+
+	protocols := map[string]bool{}
+	for ev := range outCh {
+		if _, ok := ev.Data["request"]; ok {
+			t.Errorf("Expected TCP event not to have a request, got %v", ev.Data)
+		}
+		if _, ok := ev.Data["backend_processing_time"].(float64); !ok {
+			t.Errorf("Expected TCP event to have backend_processing_time, got %v", ev.Data)
+		}
+		if _, ok := ev.Data["sent_bytes"]; !ok {
+			t.Errorf("Expected TCP event to have sent_bytes, got %v", ev.Data)
+		}
+		protocol, _ := ev.Data["listener_protocol"].(string)
+		protocols[protocol] = true
+	}
+	if !protocols["tcp"] || !protocols["ssl"] {
+		t.Errorf("Expected a tcp and an ssl event, got %v", protocols)
+	}
+}
+
+func BenchmarkELBParseEvents(b *testing.B) {
+	benchmarkParseEvents(b, NewELBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"}),
+		`2017-07-31T20:30:57.975041Z spline_reticulation_lb 10.11.12.13:47882 10.3.47.87:8080 0.000021 0.010962 -1 504 504 766 17 "PUT https://api.simulation.io:443/reticulate/spline/1 HTTP/1.1" "libhoney-go/1.3.3" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2`)
+}
@@ -0,0 +1,59 @@
+package publisher
+
+import (
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+// Status codes of the response to the client, as load balancers, CloudFront
+// and API Gateway (in the access log formats of its documentation) log them.
+var statusFields = []string{"elb_status_code", "sc_status", "sc-status", "status"}
+
+// statusCode returns the status code of the response to the client, if the
+// event has one.
+func statusCode(data map[string]interface{}) (int64, bool) {
+	for _, field := range statusFields {
+		switch code := data[field].(type) {
+		case int64:
+			return code, true
+		case int:
+			return int64(code), true
+		case float64:
+			return int64(code), true
+		}
+	}
+	return 0, false
+}
+
+func isServerError(data map[string]interface{}) bool {
+	code, ok := statusCode(data)
+	return ok && code >= 500
+}
+
+// ErrorRate returns a func parsing an object with the parser, as it would be
+// published, returning the fraction of its requests which got a 5xx, for
+// --backfill_errors_first to estimate which hours of logs to backfill first.
+func ErrorRate(parser EventParser) func(obj state.DownloadedObject) (float64, error) {
+	return func(obj state.DownloadedObject) (float64, error) {
+		out := make(chan event.Event)
+		counted := make(chan struct{})
+		var events, errors int
+		go func() {
+			for ev := range out {
+				events++
+				if isServerError(ev.Data) {
+					errors++
+				}
+			}
+			close(counted)
+		}()
+
+		err := parser.ParseEvents(obj, out)
+		close(out)
+		<-counted
+		if err != nil || events == 0 {
+			return 0, err
+		}
+		return float64(errors) / float64(events), nil
+	}
+}
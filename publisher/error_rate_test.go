@@ -0,0 +1,36 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+// statusParser "parses" an event for each of its status codes.
+type statusParser []interface{}
+
+func (p statusParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	for _, code := range p {
+		out <- event.Event{Data: map[string]interface{}{"elb_status_code": code}}
+	}
+	return nil
+}
+
+func (p statusParser) DynSample(in <-chan event.Event, out chan<- event.Event) {}
+
+func TestErrorRate(t *testing.T) {
+	rate, err := ErrorRate(statusParser{int64(200), int64(503), int64(404), int64(502)})(state.DownloadedObject{})
+	if err != nil || rate != 0.5 {
+		t.Errorf("expected an error rate of 0.5, got %v (%v)", rate, err)
+	}
+
+	rate, err = ErrorRate(statusParser{})(state.DownloadedObject{})
+	if err != nil || rate != 0 {
+		t.Errorf("expected no events to be no errors, got %v (%v)", rate, err)
+	}
+
+	if !isServerError(map[string]interface{}{"sc-status": int64(504)}) {
+		t.Error("expected CloudFront's sc-status to be checked too")
+	}
+}
@@ -0,0 +1,67 @@
+package publisher
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+// FieldExtractors derive fields from others, e.g. customer_id from
+// request_path, with the extract rules from --config.
+type FieldExtractors []fieldExtractor
+
+type fieldExtractor struct {
+	field string
+	*regexp.Regexp
+}
+
+// ParseExtractRules compiles the rules, each of whose regexps must have at
+// least one named group to extract.
+func ParseExtractRules(rules []options.ExtractRule) (FieldExtractors, error) {
+	var parsed FieldExtractors
+	for _, rule := range rules {
+		if rule.Field == "" {
+			return nil, fmt.Errorf("extract rule %q has no field to extract from", rule.Regex)
+		}
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid regexp: %s", rule.Regex, err)
+		}
+		named := false
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				named = true
+			}
+		}
+		if !named {
+			return nil, fmt.Errorf("%q has no (?P<name>...) groups to extract", rule.Regex)
+		}
+		parsed = append(parsed, fieldExtractor{rule.Field, re})
+	}
+	return parsed, nil
+}
+
+// apply adds the named groups of each rule matching its field as fields.
+// Fields the event already has, whether from its log or an earlier rule, are
+// kept.
+func (e FieldExtractors) apply(data map[string]interface{}) {
+	for _, rule := range e {
+		value, ok := data[rule.field].(string)
+		if !ok {
+			continue
+		}
+		m := rule.FindStringSubmatch(value)
+		if m == nil {
+			continue
+		}
+		for i, name := range rule.SubexpNames() {
+			if name == "" || m[i] == "" {
+				continue
+			}
+			if _, ok := data[name]; !ok {
+				data[name] = m[i]
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+func TestFieldExtractors(t *testing.T) {
+	extractors, err := ParseExtractRules([]options.ExtractRule{
+		{Field: "request_path", Regex: `^/api/v2/customers/(?P<customer_id>[^/]+)(/orders/(?P<order_id>[0-9]+))?`},
+		{Field: "cs_uri_stem", Regex: `^/tenants/(?P<customer_id>[^/]+)/`},
+		{Field: "request_path", Regex: `^/api/(?P<api_version>v[0-9]+)/`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		data     map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			data: map[string]interface{}{"request_path": "/api/v2/customers/acme/orders/42"},
+			expected: map[string]interface{}{
+				"request_path": "/api/v2/customers/acme/orders/42",
+				"customer_id":  "acme",
+				"order_id":     "42",
+				"api_version":  "v2",
+			},
+		},
+		{
+			// unmatched groups aren't added
+			data: map[string]interface{}{"request_path": "/api/v2/customers/acme"},
+			expected: map[string]interface{}{
+				"request_path": "/api/v2/customers/acme",
+				"customer_id":  "acme",
+				"api_version":  "v2",
+			},
+		},
+		{
+			data: map[string]interface{}{"cs_uri_stem": "/tenants/globex/index.html"},
+			expected: map[string]interface{}{
+				"cs_uri_stem": "/tenants/globex/index.html",
+				"customer_id": "globex",
+			},
+		},
+		{
+			// the log's own fields are kept
+			data: map[string]interface{}{"request_path": "/api/v1/health", "api_version": "v3"},
+			expected: map[string]interface{}{
+				"request_path": "/api/v1/health",
+				"api_version":  "v3",
+			},
+		},
+		{
+			data:     map[string]interface{}{"request_path": int64(1)},
+			expected: map[string]interface{}{"request_path": int64(1)},
+		},
+	}
+	for _, tc := range testCases {
+		extractors.apply(tc.data)
+		if !reflect.DeepEqual(tc.data, tc.expected) {
+			t.Errorf("expected %v, got %v", tc.expected, tc.data)
+		}
+	}
+
+	for _, invalid := range []options.ExtractRule{
+		{Field: "request_path", Regex: `^/customers/([^/]+)`},
+		{Field: "request_path", Regex: `^/customers/(?P<customer_id>[^/]+`},
+		{Regex: `^/customers/(?P<customer_id>[^/]+)`},
+	} {
+		if _, err := ParseExtractRules([]options.ExtractRule{invalid}); err == nil {
+			t.Errorf("expected %+v to be invalid", invalid)
+		}
+	}
+}
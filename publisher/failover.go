@@ -0,0 +1,94 @@
+package publisher
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/sirupsen/logrus"
+)
+
+// writeKeyFailover watches the responses from Honeycomb and, once the primary
+// write key has been persistently rejected (revoked key, exhausted quota,
+// etc.), switches every event sent afterwards to the fallback write key and
+// API host.
+type writeKeyFailover struct {
+	sync.RWMutex
+	writeKey, apiHost string
+	threshold         int
+	consecutive       int
+	failedOver        bool
+}
+
+// isRejected reports whether the response means Honeycomb is refusing events
+// for the write key, rather than a transient network or server error.
+func isRejected(statusCode int) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+func (f *writeKeyFailover) active() bool {
+	f.RLock()
+	defer f.RUnlock()
+	return f.failedOver
+}
+
+// apply points the event at the fallback destination if we've failed over.
+func (f *writeKeyFailover) apply(ev *libhoney.Event) {
+	if f == nil || !f.active() {
+		return
+	}
+	ev.WriteKey = f.writeKey
+	if f.apiHost != "" {
+		ev.APIHost = f.apiHost
+	}
+}
+
+// observe records a response, and returns true if it caused a fail over.
+func (f *writeKeyFailover) observe(resp transmission.Response) bool {
+	f.Lock()
+	defer f.Unlock()
+	if f.failedOver || resp.StatusCode == 0 {
+		return false
+	}
+	if !isRejected(resp.StatusCode) {
+		f.consecutive = 0
+		return false
+	}
+	f.consecutive++
+	if f.consecutive < f.threshold {
+		return false
+	}
+	f.failedOver = true
+	return true
+}
+
+// failOver switches to the fallback immediately, e.g. when the primary write
+// key can't be verified on startup.
+func (f *writeKeyFailover) failOver() {
+	f.Lock()
+	f.failedOver = true
+	f.Unlock()
+	f.alert(0)
+}
+
+func (f *writeKeyFailover) alert(statusCode int) {
+	logrus.WithFields(logrus.Fields{
+		"status_code": statusCode,
+		"api_host":    f.apiHost,
+	}).Error("Honeycomb is persistently rejecting events for the primary write key, failing over to the fallback write key. Please check the primary write key!")
+
+	// Make some noise in Honeycomb as well, in case nobody is watching the
+	// logs.
+	ev := libhoney.NewEvent()
+	f.apply(ev)
+	ev.AddField("meta.type", "writekey_failover")
+	ev.AddField("status_code", statusCode)
+	if err := ev.SendPresampled(); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error sending failover event")
+	}
+}
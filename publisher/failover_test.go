@@ -0,0 +1,52 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func TestWriteKeyFailover(t *testing.T) {
+	f := &writeKeyFailover{
+		writeKey:  "fallback",
+		apiHost:   "https://api.eu1.honeycomb.io/",
+		threshold: 3,
+	}
+
+	responses := []struct {
+		statusCode int
+		failsOver  bool
+	}{
+		{401, false},
+		{401, false},
+		// a success resets the count
+		{202, false},
+		{401, false},
+		{429, false},
+		// network errors don't count either way
+		{0, false},
+		{403, true},
+		{401, false},
+	}
+
+	for i, r := range responses {
+		if f.observe(transmission.Response{StatusCode: r.statusCode}) != r.failsOver {
+			t.Errorf("response %d (%d): expected fail over to be %v", i, r.statusCode, r.failsOver)
+		}
+	}
+
+	ev := &libhoney.Event{WriteKey: "primary"}
+	f.apply(ev)
+	if ev.WriteKey != "fallback" || ev.APIHost != "https://api.eu1.honeycomb.io/" {
+		t.Errorf("event was not pointed at the fallback: %s %s", ev.WriteKey, ev.APIHost)
+	}
+
+	// a nil failover, i.e. no fallback configured, leaves events alone
+	var none *writeKeyFailover
+	ev = &libhoney.Event{WriteKey: "primary"}
+	none.apply(ev)
+	if ev.WriteKey != "primary" {
+		t.Error("event should not have been modified")
+	}
+}
@@ -0,0 +1,105 @@
+package publisher
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Fanout are the destinations the fanout section of the --config file sends a
+// copy of the events to, as well as where they're sent anyway.
+type Fanout []fanoutDestination
+
+// fanoutDestination is a dataset sent a copy of the events of its load
+// balancers, or of every event if it has none, keeping 1 in sampleRate.
+type fanoutDestination struct {
+	destination
+	dataset       string
+	sampleRate    int
+	loadBalancers map[string]bool
+}
+
+// ParseFanout parses the fanout section of the --config file.
+func ParseFanout(configs []options.FanoutConfig) (Fanout, error) {
+	var f Fanout
+	for i, config := range configs {
+		if config.Dataset == "" {
+			return nil, fmt.Errorf("Invalid fanout destination %d, it needs a dataset", i+1)
+		}
+		if config.SampleRate < 0 {
+			return nil, fmt.Errorf("Invalid fanout destination %q, the sample_rate should be a whole number of at least 1", config.Dataset)
+		}
+		d := fanoutDestination{
+			destination: destination{apiHost: config.APIHost, writeKey: config.WriteKey},
+			dataset:     config.Dataset,
+			sampleRate:  config.SampleRate,
+		}
+		if d.sampleRate == 0 {
+			d.sampleRate = 1
+		}
+		if len(config.LoadBalancers) > 0 {
+			d.loadBalancers = make(map[string]bool, len(config.LoadBalancers))
+			for _, name := range config.LoadBalancers {
+				d.loadBalancers[name] = true
+			}
+		}
+		f = append(f, d)
+	}
+	return f, nil
+}
+
+// copies reports whether the destination is sent a copy of the event. ALB and
+// NLB events match on the full name of their load balancer or just the name,
+// as with --dataset_map.
+func (d fanoutDestination) copies(data map[string]interface{}) bool {
+	if d.loadBalancers == nil {
+		return true
+	}
+	elb, ok := data["elb"].(string)
+	return ok && (d.loadBalancers[elb] || d.loadBalancers[lbName(elb)])
+}
+
+// send sends a copy of the event, as it's being sent anyway, to each
+// destination it's for, with its sample rate scaled by the destination's.
+// Copies are retried like any other event, but aren't followed by
+// --exactly_once.
+func (f Fanout) send(ev *libhoney.Event, data map[string]interface{}) {
+	for i := range f {
+		d := &f[i]
+		if !d.copies(data) || rand.Intn(d.sampleRate) != 0 {
+			continue
+		}
+		sampleRate := ev.SampleRate
+		if sampleRate == 0 {
+			sampleRate = 1
+		}
+		copied := libhoney.NewEvent()
+		copied.Timestamp = ev.Timestamp
+		copied.Dataset = d.dataset
+		copied.SampleRate = sampleRate * uint(d.sampleRate)
+		d.apply(copied)
+		if err := copied.Add(data); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"dataset": d.dataset,
+				"error":   err,
+			}).Error("Unexpected error adding data to libhoney event")
+			continue
+		}
+		trackRetries(copied, data)
+		if retryable, ok := copied.Metadata.(*retryableEvent); ok {
+			retryable.copyTo = &d.destination
+		}
+		if err := copied.SendPresampled(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"dataset": d.dataset,
+				"error":   err,
+			}).Error("Unexpected error event to libhoney send")
+			continue
+		}
+		metrics.EventsSent.Inc()
+	}
+}
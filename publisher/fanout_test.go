@@ -0,0 +1,76 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func TestParseFanout(t *testing.T) {
+	f, err := ParseFanout([]options.FanoutConfig{
+		{Dataset: "platform-access", SampleRate: 10},
+		{Dataset: "checkout-access", WriteKey: "checkout", LoadBalancers: []string{"checkout-alb"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f) != 2 || f[0].sampleRate != 10 || f[1].sampleRate != 1 || f[1].writeKey != "checkout" {
+		t.Errorf("unexpected fanout %+v", f)
+	}
+
+	alb := map[string]interface{}{"elb": "app/checkout-alb/1db0c9806095122a"}
+	other := map[string]interface{}{"elb": "app/search-alb/50dc6c495c0c9188"}
+	if !f[0].copies(other) || !f[0].copies(map[string]interface{}{}) {
+		t.Error("expected a destination without load balancers to be sent every event")
+	}
+	if !f[1].copies(alb) || f[1].copies(other) {
+		t.Error("expected a destination with load balancers to be sent just their events")
+	}
+
+	for _, configs := range [][]options.FanoutConfig{
+		{{WriteKey: "checkout"}},
+		{{Dataset: "platform-access", SampleRate: -1}},
+	} {
+		if _, err := ParseFanout(configs); err == nil {
+			t.Errorf("expected %+v to be invalid", configs)
+		}
+	}
+}
+
+func TestFanoutSend(t *testing.T) {
+	sender := &transmission.MockSender{}
+	if err := libhoney.Init(libhoney.Config{WriteKey: "primary", Dataset: "aws-alb-access", Transmission: sender}); err != nil {
+		t.Fatal(err)
+	}
+	defer libhoney.Close()
+
+	f, err := ParseFanout([]options.FanoutConfig{
+		{Dataset: "platform-access", SampleRate: 1},
+		{Dataset: "checkout-access", WriteKey: "checkout", APIHost: "https://api.eu1.honeycomb.io/", LoadBalancers: []string{"checkout-alb"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := libhoney.NewEvent()
+	ev.SampleRate = 5
+	f.send(ev, map[string]interface{}{"elb": "app/search-alb/50dc6c495c0c9188", "elb_status_code": 200})
+
+	events := sender.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected a copy for just the destination without load balancers, got %d", len(events))
+	}
+	if events[0].Dataset != "platform-access" || events[0].SampleRate != 5 || events[0].APIKey != "primary" {
+		t.Errorf("unexpected copy %+v", events[0])
+	}
+
+	f.send(ev, map[string]interface{}{"elb": "checkout-alb", "elb_status_code": 200})
+	events = sender.Events()
+	if len(events) != 3 {
+		t.Fatalf("expected a copy for both destinations, got %d", len(events))
+	}
+	if copied := events[2]; copied.Dataset != "checkout-access" || copied.APIKey != "checkout" || copied.APIHost != "https://api.eu1.honeycomb.io/" {
+		t.Errorf("expected the copy to go to the destination's own write key and host, got %+v", copied)
+	}
+}
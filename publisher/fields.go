@@ -0,0 +1,65 @@
+package publisher
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// FieldFilter prunes and renames the fields of events just before they're
+// sent, for --keep-fields, --drop-fields and --rename-field.
+type FieldFilter struct {
+	keep    []string
+	drop    []string
+	renames map[string]string
+}
+
+// ParseFieldFilter parses the fields to keep and drop, which may be glob
+// patterns such as request_path_*, and the fields to rename as old=new. It
+// returns nil if there's nothing to do.
+func ParseFieldFilter(keep, drop, renames []string) (*FieldFilter, error) {
+	if len(keep) == 0 && len(drop) == 0 && len(renames) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string{}, keep...), drop...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid field pattern %q: %s", pattern, err)
+		}
+	}
+
+	f := &FieldFilter{keep: keep, drop: drop, renames: make(map[string]string)}
+	for _, r := range renames {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid --rename-field %q, expected old=new", r)
+		}
+		f.renames[parts[0]] = parts[1]
+	}
+	return f, nil
+}
+
+// apply keeps only the fields matching --keep-fields, if any, then drops
+// those matching --drop-fields, then renames what's left, so fields are kept
+// and dropped by their names before renaming.
+func (f *FieldFilter) apply(data map[string]interface{}) {
+	if f == nil {
+		return
+	}
+	for field := range data {
+		if !matchAny(f.keep, field) || (len(f.drop) > 0 && matchAny(f.drop, field)) {
+			delete(data, field)
+		}
+	}
+
+	// Renamed all at once, so that e.g. a=b and b=a swap the two.
+	renamed := make(map[string]interface{})
+	for from, to := range f.renames {
+		if value, ok := data[from]; ok {
+			renamed[to] = value
+			delete(data, from)
+		}
+	}
+	for field, value := range renamed {
+		data[field] = value
+	}
+}
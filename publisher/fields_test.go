@@ -0,0 +1,79 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldFilter(t *testing.T) {
+	event := func() map[string]interface{} {
+		return map[string]interface{}{
+			"elb_status_code":   int64(200),
+			"request_path":      "/users/123",
+			"request_path_1":    "users",
+			"request_query":     "token=secret",
+			"client_authority":  "10.0.0.1:1234",
+			"backend_authority": "10.0.1.1:80",
+		}
+	}
+
+	testCases := []struct {
+		keep, drop, renames []string
+		expected            map[string]interface{}
+	}{
+		{
+			drop: []string{"request_query", "*_authority"},
+			expected: map[string]interface{}{
+				"elb_status_code": int64(200),
+				"request_path":    "/users/123",
+				"request_path_1":  "users",
+			},
+		},
+		{
+			keep: []string{"elb_status_code", "request_*"},
+			drop: []string{"request_query"},
+			expected: map[string]interface{}{
+				"elb_status_code": int64(200),
+				"request_path":    "/users/123",
+				"request_path_1":  "users",
+			},
+		},
+		{
+			keep:    []string{"elb_status_code", "request_path"},
+			renames: []string{"elb_status_code=http.status_code", "request_path=http.target", "missing=other"},
+			expected: map[string]interface{}{
+				"http.status_code": int64(200),
+				"http.target":      "/users/123",
+			},
+		},
+		{
+			keep:    []string{"*_authority"},
+			renames: []string{"client_authority=backend_authority", "backend_authority=client_authority"},
+			expected: map[string]interface{}{
+				"client_authority":  "10.0.1.1:80",
+				"backend_authority": "10.0.0.1:1234",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		f, err := ParseFieldFilter(tc.keep, tc.drop, tc.renames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := event()
+		f.apply(data)
+		if !reflect.DeepEqual(data, tc.expected) {
+			t.Errorf("keep %v, drop %v, rename %v: expected %v, got %v", tc.keep, tc.drop, tc.renames, tc.expected, data)
+		}
+	}
+
+	if f, err := ParseFieldFilter(nil, nil, nil); f != nil || err != nil {
+		t.Errorf("expected no filter without options, got %v (%v)", f, err)
+	}
+	if _, err := ParseFieldFilter(nil, []string{"request_["}, nil); err == nil {
+		t.Error("expected a bad pattern to be rejected")
+	}
+	if _, err := ParseFieldFilter(nil, nil, []string{"elb_status_code"}); err == nil {
+		t.Error("expected a rename without a new name to be rejected")
+	}
+}
@@ -0,0 +1,111 @@
+package publisher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Client IPs are only fingerprinted down to their network, so that clients
+// moving around a NAT pool or a v6 prefix still group together.
+var (
+	ipv4PrefixMask = net.CIDRMask(24, 32)
+	ipv6PrefixMask = net.CIDRMask(48, 128)
+)
+
+// Browsers are recognized by the first of these tokens in their user agent,
+// in this order since e.g. Edge and Chrome also claim to be Safari.
+var userAgentFamilies = []struct {
+	token, family string
+}{
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"chrome/", "Chrome"},
+	{"crios/", "Chrome"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"safari/", "Safari"},
+	{"msie ", "IE"},
+	{"trident/", "IE"},
+}
+
+// clientIP returns the IP of the client, whether logged with its port (ELB,
+// ALB and NLB) or without (CloudFront and WAF).
+func clientIP(data map[string]interface{}) net.IP {
+	if authority, ok := data["client_authority"].(string); ok {
+		if host, _, err := net.SplitHostPort(authority); err == nil {
+			return net.ParseIP(host)
+		}
+		return net.ParseIP(authority)
+	}
+	if ip, ok := data["c_ip"].(string); ok {
+		return net.ParseIP(ip)
+	}
+	if ip, ok := data["client_ip"].(string); ok {
+		return net.ParseIP(ip)
+	}
+	return nil
+}
+
+func ipPrefix(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(ipv4PrefixMask).String() + "/24"
+	}
+	return ip.Mask(ipv6PrefixMask).String() + "/48"
+}
+
+// userAgentFamily reduces a user agent to its browser family, or to the name
+// of the first product for anything else (e.g. curl, libhoney-go), leaving
+// out versions which change too often to group on.
+func userAgentFamily(ua string) string {
+	// CloudFront logs user agents URL encoded
+	if decoded, err := url.PathUnescape(ua); err == nil {
+		ua = decoded
+	}
+	lower := strings.ToLower(ua)
+	for _, f := range userAgentFamilies {
+		if strings.Contains(lower, f.token) {
+			return f.family
+		}
+	}
+	product := strings.Fields(ua)
+	if len(product) == 0 {
+		return ""
+	}
+	return strings.SplitN(product[0], "/", 2)[0]
+}
+
+func pathShape(data map[string]interface{}) string {
+	if shape, ok := data["request_shape"].(string); ok {
+		return strings.SplitN(shape, "?", 2)[0]
+	}
+	if stem, ok := data["cs_uri_stem"].(string); ok {
+		return stem
+	}
+	return ""
+}
+
+// addFingerprint adds request_fingerprint, a stable hash of the client's IP
+// prefix, user agent family and path shape, so that traffic which is likely
+// from the same actor can be grouped on without the raw IP.
+func addFingerprint(data map[string]interface{}) {
+	ua, _ := data["user_agent"].(string)
+	if ua == "" {
+		ua, _ = data["cs_user_agent"].(string)
+	}
+
+	prefix := ipPrefix(clientIP(data))
+	family := userAgentFamily(ua)
+	shape := pathShape(data)
+	if prefix == "" && family == "" && shape == "" {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{prefix, family, shape}, "|")))
+	data["request_fingerprint"] = hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,60 @@
+package publisher
+
+import (
+	"testing"
+)
+
+func TestUserAgentFamily(t *testing.T) {
+	testCases := []struct {
+		ua, expected string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36", "Chrome"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 Edg/91.0.864.59", "Edge"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Safari/605.1.15", "Safari"},
+		{"Mozilla/4.0%20(compatible;%20MSIE%205.0b1;%20Mac_PowerPC)", "IE"},
+		{"curl/7.64.1", "curl"},
+		{"libhoney-go/1.3.3", "libhoney-go"},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		if family := userAgentFamily(tc.ua); family != tc.expected {
+			t.Errorf("Expected family of %q to be %q, got %q", tc.ua, tc.expected, family)
+		}
+	}
+}
+
+func TestAddFingerprint(t *testing.T) {
+	ev := func(client, ua, shape string) map[string]interface{} {
+		return map[string]interface{}{
+			"client_authority": client,
+			"user_agent":       ua,
+			"request_shape":    shape,
+		}
+	}
+
+	a := ev("10.11.12.13:47882", "curl/7.64.1", "/users/:id?page=?")
+	b := ev("10.11.12.200:1234", "curl/7.79.0", "/users/:id")
+	c := ev("10.11.13.13:47882", "curl/7.64.1", "/users/:id")
+	for _, data := range []map[string]interface{}{a, b, c} {
+		addFingerprint(data)
+	}
+
+	if a["request_fingerprint"] != b["request_fingerprint"] {
+		t.Errorf("Expected the same network, user agent family and path shape to share a fingerprint, got %v and %v", a["request_fingerprint"], b["request_fingerprint"])
+	}
+	if a["request_fingerprint"] == c["request_fingerprint"] {
+		t.Errorf("Expected a different network to have a different fingerprint")
+	}
+
+	v6 := map[string]interface{}{"c_ip": "2001:db8:1234:5678::1", "cs_user_agent": "curl/7.64.1", "cs_uri_stem": "/index.html"}
+	addFingerprint(v6)
+	if fp, ok := v6["request_fingerprint"].(string); !ok || len(fp) != 16 {
+		t.Errorf("Expected CloudFront events to be fingerprinted, got %v", v6)
+	}
+
+	empty := map[string]interface{}{}
+	addFingerprint(empty)
+	if _, ok := empty["request_fingerprint"]; ok {
+		t.Error("Expected events with nothing to fingerprint not to have a fingerprint")
+	}
+}
@@ -0,0 +1,219 @@
+package publisher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// The fields of VPC Flow Logs' default (version 2) format, for objects
+// without a header line.
+var defaultFlowLogFields = []string{
+	"version", "account-id", "interface-id", "srcaddr", "dstaddr", "srcport", "dstport",
+	"protocol", "packets", "bytes", "start", "end", "action", "log-status",
+}
+
+// Names of flow log fields, e.g. pkt-srcaddr, which records' values (IPs,
+// IDs with digits in and "-") aren't like.
+var flowLogFieldName = regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+
+// Flow log fields which are numbers. Others which look like them, such as
+// account-id, aren't.
+var flowLogNumbers = map[string]bool{
+	"version":      true,
+	"srcport":      true,
+	"dstport":      true,
+	"protocol":     true,
+	"packets":      true,
+	"bytes":        true,
+	"start":        true,
+	"end":          true,
+	"tcp-flags":    true,
+	"traffic-path": true,
+}
+
+// IANA protocol numbers of the protocols flow logs are usually of.
+var flowLogProtocols = map[int64]string{
+	1:  "ICMP",
+	6:  "TCP",
+	17: "UDP",
+	58: "ICMPv6",
+}
+
+// TCP flags, as ORed together by flow logs for the packets of one flow in the
+// aggregation interval.
+var tcpFlags = []struct {
+	bit  int64
+	name string
+}{
+	{1, "FIN"},
+	{2, "SYN"},
+	{4, "RST"},
+	{8, "PSH"},
+	{16, "ACK"},
+	{32, "URG"},
+}
+
+type FlowLogEventParser struct {
+	dynamicSampling
+}
+
+func NewFlowLogEventParser(opt *options.Options) *FlowLogEventParser {
+	return &FlowLogEventParser{dynamicSampling: newDynamicSampling(opt)}
+}
+
+// flowLogHeader reads the fields the object's records have from its header
+// line, which flow logs delivered to S3 start with, in whichever (default or
+// custom) format the flow log was created with. The line is peeked at rather
+// than read, so an object streamed from S3 can still be read from the start.
+func flowLogHeader(br *bufio.Reader) ([]string, bool, error) {
+	first, err := br.Peek(br.Size())
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if i := bytes.IndexByte(first, '\n'); i >= 0 {
+		first = first[:i]
+	}
+	fields := strings.Fields(string(first))
+	if len(fields) == 0 {
+		return defaultFlowLogFields, false, nil
+	}
+	for _, field := range fields {
+		if !flowLogFieldName.MatchString(field) {
+			return defaultFlowLogFields, false, nil
+		}
+	}
+	return fields, true, nil
+}
+
+// parseFlowLogRecord parses a record with the fields, skipping those which
+// are "-", e.g. fields that don't apply to the record, or all of them for the
+// record of an interval without traffic.
+func parseFlowLogRecord(fields []string, line string) (map[string]interface{}, error) {
+	values := strings.Fields(line)
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(fields), len(values))
+	}
+
+	data := make(map[string]interface{}, len(fields)+3)
+	for i, field := range fields {
+		value := values[i]
+		if value == "-" {
+			continue
+		}
+		if flowLogNumbers[field] {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				data[field] = n
+				continue
+			}
+		}
+		data[field] = value
+	}
+
+	if protocol, ok := data["protocol"].(int64); ok {
+		if name, ok := flowLogProtocols[protocol]; ok {
+			data["protocol_name"] = name
+		}
+	}
+	if flags, ok := data["tcp-flags"].(int64); ok {
+		var names []string
+		for _, flag := range tcpFlags {
+			if flags&flag.bit != 0 {
+				names = append(names, flag.name)
+			}
+		}
+		data["tcp_flags_names"] = strings.Join(names, ",")
+	}
+	start, hasStart := data["start"].(int64)
+	if end, ok := data["end"].(int64); ok && hasStart && end >= start {
+		data["duration_ms"] = float64((end - start) * 1000)
+	}
+	return data, nil
+}
+
+func (ep *FlowLogEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	r, err := logbucket.Open(obj)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	fields, hasHeader, err := flowLogHeader(br)
+	if err != nil {
+		return err
+	}
+
+	scanner := newLineScanner(obj, br)
+
+	for scanner.Scan() {
+		if hasHeader && scanner.lines == 1 {
+			continue
+		}
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		data, err := parseFlowLogRecord(fields, line)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"object": obj.Object,
+				"line":   scanner.lines,
+				"err":    err,
+			}).Debug("Could not parse flow log record")
+			scanner.unparseable(line, err)
+			continue
+		}
+
+		t := time.Now()
+		if start, ok := data["start"].(int64); ok {
+			t = time.Unix(start, 0)
+		}
+		out <- event.Event{
+			Timestamp: t,
+			Data:      data,
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (ep *FlowLogEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		// Rejected traffic, and unusual protocols, are rare and
+		// interesting, so use the action and protocol to set the sample
+		// rate, per interface.
+		key := fmt.Sprintf("%v_%v_%v", ev.Data["action"], ev.Data["protocol"], ev.Data["interface-id"])
+
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
+		rate := ep.sampler.GetSampleRate(key)
+		if rate <= 0 {
+			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
+			rate = 1
+		}
+		if rand.Intn(rate) == 0 {
+			ev.SampleRate = rate
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func parseFlowLogs(t *testing.T, contents string, offset int64) []event.Event {
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	ep := &FlowLogEventParser{}
+	out := make(chan event.Event, 10)
+	obj := state.DownloadedObject{Object: "foo", Filename: tmpFile.Name(), Offset: offset}
+	if err := ep.ParseEvents(obj, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var evs []event.Event
+	for ev := range out {
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestFlowLogDefaultFormat(t *testing.T) {
+	evs := parseFlowLogs(t, `version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status
+2 123456789010 eni-1235b8ca123456789 172.31.16.139 172.31.16.21 20641 22 6 20 4249 1418530010 1418530070 ACCEPT OK
+2 123456789010 eni-1235b8ca123456789 - - - - - - - 1431280876 1431280934 - NODATA
+`, 0)
+	if len(evs) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evs))
+	}
+
+	data := evs[0].Data
+	for field, expected := range map[string]interface{}{
+		"srcaddr":       "172.31.16.139",
+		"dstport":       int64(22),
+		"bytes":         int64(4249),
+		"action":        "ACCEPT",
+		"account-id":    "123456789010",
+		"protocol_name": "TCP",
+		"duration_ms":   float64(60000),
+	} {
+		if data[field] != expected {
+			t.Errorf("expected %s to be %v, got %v", field, expected, data[field])
+		}
+	}
+	if evs[0].Timestamp.Unix() != 1418530010 {
+		t.Errorf("expected the event to be timestamped with start, got %v", evs[0].Timestamp)
+	}
+
+	if _, ok := evs[1].Data["srcaddr"]; ok || evs[1].Data["log-status"] != "NODATA" {
+		t.Errorf("expected - fields to be left out, got %v", evs[1].Data)
+	}
+}
+
+func TestFlowLogCustomFormat(t *testing.T) {
+	contents := `srcaddr dstaddr bytes action tcp-flags
+10.0.0.1 10.0.0.2 100 ACCEPT 18
+10.0.0.3 10.0.0.2 40 REJECT 2
+`
+	evs := parseFlowLogs(t, contents, 0)
+	if len(evs) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evs))
+	}
+	if evs[0].Data["tcp_flags_names"] != "SYN,ACK" || evs[0].Data["bytes"] != int64(100) {
+		t.Errorf("unexpected fields %v", evs[0].Data)
+	}
+
+	// Resuming after the first record shouldn't take the header for one.
+	evs = parseFlowLogs(t, contents, 2)
+	if len(evs) != 1 || evs[0].Data["action"] != "REJECT" {
+		t.Errorf("expected only the second record when resumed, got %v", evs)
+	}
+}
+
+func TestFlowLogWithoutHeader(t *testing.T) {
+	evs := parseFlowLogs(t, "2 123456789010 eni-1235b8ca123456789 172.31.9.69 172.31.9.12 49761 3389 6 20 4249 1418530010 1418530070 REJECT OK\n", 0)
+	if len(evs) != 1 || evs[0].Data["action"] != "REJECT" || evs[0].Data["dstport"] != int64(3389) {
+		t.Errorf("expected the default format without a header, got %v", evs)
+	}
+}
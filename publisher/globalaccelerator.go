@@ -0,0 +1,148 @@
+package publisher
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// The fields of Global Accelerator flow log records, in order. Records
+// written before the later fields were added end early, so only the fields
+// up to log-status are required.
+var globalAcceleratorFields = []string{
+	"version", "aws_account_id", "accelerator_id", "client_ip", "client_port",
+	"gip", "gip_port", "endpoint_ip", "endpoint_port", "protocol",
+	"ip_address_type", "packets", "bytes", "start_time", "end_time", "action",
+	"log-status", "globalaccelerator_source_ip", "globalaccelerator_source_port",
+	"endpoint_region", "globalaccelerator_region", "direction", "vpc_id",
+}
+
+const globalAcceleratorRequiredFields = 17
+
+// Global Accelerator flow log fields which are numbers. The version, e.g.
+// 1.0, isn't.
+var globalAcceleratorNumbers = map[string]bool{
+	"client_port":                   true,
+	"gip_port":                      true,
+	"endpoint_port":                 true,
+	"packets":                       true,
+	"bytes":                         true,
+	"start_time":                    true,
+	"end_time":                      true,
+	"globalaccelerator_source_port": true,
+}
+
+// GlobalAcceleratorEventParser parses the flow logs of Global Accelerator
+// accelerators, which record the traffic from clients to the accelerator's
+// static IPs (gip) and on to its endpoints, e.g. ALBs.
+type GlobalAcceleratorEventParser struct {
+	dynamicSampling
+}
+
+func NewGlobalAcceleratorEventParser(opt *options.Options) *GlobalAcceleratorEventParser {
+	return &GlobalAcceleratorEventParser{dynamicSampling: newDynamicSampling(opt)}
+}
+
+// parseGlobalAcceleratorRecord parses a flow log record, skipping fields which
+// are "-", e.g. the endpoint of traffic which didn't reach one.
+func parseGlobalAcceleratorRecord(line string) (map[string]interface{}, error) {
+	values := strings.Fields(line)
+	if len(values) < globalAcceleratorRequiredFields || len(values) > len(globalAcceleratorFields) {
+		return nil, fmt.Errorf("expected %d to %d fields, got %d", globalAcceleratorRequiredFields, len(globalAcceleratorFields), len(values))
+	}
+
+	data := make(map[string]interface{}, len(values)+1)
+	for i, value := range values {
+		if value == "-" {
+			continue
+		}
+		field := globalAcceleratorFields[i]
+		if globalAcceleratorNumbers[field] {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				data[field] = n
+				continue
+			}
+		}
+		data[field] = value
+	}
+
+	start, hasStart := data["start_time"].(int64)
+	if end, ok := data["end_time"].(int64); ok && hasStart && end >= start {
+		data["duration_ms"] = float64((end - start) * 1000)
+	}
+	return data, nil
+}
+
+func (ep *GlobalAcceleratorEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	r, err := logbucket.Open(obj)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	scanner := newLineScanner(obj, r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		// a header line, if there is one, names the fields
+		if line == "" || strings.HasPrefix(line, "version ") {
+			continue
+		}
+		data, err := parseGlobalAcceleratorRecord(line)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"object": obj.Object,
+				"line":   scanner.lines,
+				"err":    err,
+			}).Debug("Could not parse Global Accelerator flow log record")
+			scanner.unparseable(line, err)
+			continue
+		}
+
+		t := time.Now()
+		if start, ok := data["start_time"].(int64); ok {
+			t = time.Unix(start, 0)
+		}
+		out <- event.Event{
+			Timestamp: t,
+			Data:      data,
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (ep *GlobalAcceleratorEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		// Rejected traffic, and the endpoint regions with the least of
+		// it, are kept more often, per accelerator.
+		key := fmt.Sprintf("%v_%v_%v", ev.Data["action"], ev.Data["endpoint_region"], ev.Data["accelerator_id"])
+
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
+		rate := ep.sampler.GetSampleRate(key)
+		if rate <= 0 {
+			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
+			rate = 1
+		}
+		if rand.Intn(rate) == 0 {
+			ev.SampleRate = rate
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+}
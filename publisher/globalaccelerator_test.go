@@ -0,0 +1,76 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestGlobalAcceleratorParseEvents(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString(`1.0 123456789012 1234abcd-abcd-1234-abcd-1234abcdefgh 198.51.100.1 42980 192.0.2.1 443 10.0.0.51 443 TCP IPV4 4 346 1588730000 1588730060 ACCEPT OK 10.0.0.52 58418 us-east-1 us-west-2 INGRESS vpc-1234abcd
+1.0 123456789012 1234abcd-abcd-1234-abcd-1234abcdefgh 198.51.100.2 42981 192.0.2.1 443 - - TCP IPV4 1 40 1588730000 1588730060 REJECT OK
+not a flow log record
+`); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	ep := &GlobalAcceleratorEventParser{}
+	out := make(chan event.Event, 10)
+	var unparseable []string
+	obj := state.DownloadedObject{Object: "foo", Filename: tmpFile.Name(), Unparseable: func(line int64, text string, err error) {
+		unparseable = append(unparseable, text)
+	}}
+	if err := ep.ParseEvents(obj, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var evs []event.Event
+	for ev := range out {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 2 || len(unparseable) != 1 {
+		t.Fatalf("expected 2 events and a line which couldn't be parsed, got %d and %v", len(evs), unparseable)
+	}
+
+	data := evs[0].Data
+	for field, expected := range map[string]interface{}{
+		"version":                       "1.0",
+		"client_ip":                     "198.51.100.1",
+		"gip_port":                      int64(443),
+		"endpoint_ip":                   "10.0.0.51",
+		"protocol":                      "TCP",
+		"bytes":                         int64(346),
+		"action":                        "ACCEPT",
+		"endpoint_region":               "us-east-1",
+		"vpc_id":                        "vpc-1234abcd",
+		"duration_ms":                   float64(60000),
+		"aws_account_id":                "123456789012",
+		"ip_address_type":               "IPV4",
+		"direction":                     "INGRESS",
+		"globalaccelerator_source_port": int64(58418),
+	} {
+		if data[field] != expected {
+			t.Errorf("expected %s to be %v, got %v", field, expected, data[field])
+		}
+	}
+	if evs[0].Timestamp.Unix() != 1588730000 {
+		t.Errorf("expected the event to be timestamped with start_time, got %v", evs[0].Timestamp)
+	}
+
+	// written before the fields after log-status were added
+	if _, ok := evs[1].Data["endpoint_ip"]; ok || evs[1].Data["action"] != "REJECT" {
+		t.Errorf("expected - fields to be left out, got %v", evs[1].Data)
+	}
+	if _, ok := evs[1].Data["vpc_id"]; ok {
+		t.Errorf("expected the missing fields to be left out, got %v", evs[1].Data)
+	}
+}
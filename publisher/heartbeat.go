@@ -0,0 +1,116 @@
+package publisher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeyaws/sketch"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+// latencyHeartbeat keeps a t-digest of backend_processing_time per load
+// balancer for every parsed event, before sampling, and periodically reports
+// the percentiles as heartbeat events. This gives a cheap, accurate view of
+// latency even when the raw events are heavily sampled.
+type latencyHeartbeat struct {
+	sync.Mutex
+	digests map[string]*sketch.TDigest
+	// durationUnit is --duration-unit, which the percentiles are reported
+	// in, like the events' backend_processing_time.
+	durationUnit string
+}
+
+func newLatencyHeartbeat(durationUnit string) *latencyHeartbeat {
+	return &latencyHeartbeat{digests: make(map[string]*sketch.TDigest), durationUnit: durationUnit}
+}
+
+// observe records the latency of each event and passes it along unchanged.
+func (h *latencyHeartbeat) observe(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		h.add(ev)
+		out <- ev
+	}
+	close(out)
+}
+
+func (h *latencyHeartbeat) add(ev event.Event) {
+	elb, ok := ev.Data["elb"].(string)
+	if !ok {
+		return
+	}
+
+	var latency float64
+	switch t := ev.Data["backend_processing_time"].(type) {
+	case float64:
+		latency = t
+	case int64:
+		latency = float64(t)
+	default:
+		return
+	}
+	// -1 means the target timed out or disconnected
+	if latency < 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	td, ok := h.digests[elb]
+	if !ok {
+		td = sketch.NewTDigest(100)
+		h.digests[elb] = td
+	}
+	td.Add(latency)
+}
+
+// flush returns the heartbeat fields for each load balancer seen since the
+// last flush, and resets the digests.
+func (h *latencyHeartbeat) flush() []map[string]interface{} {
+	h.Lock()
+	digests := h.digests
+	h.digests = make(map[string]*sketch.TDigest)
+	h.Unlock()
+
+	var heartbeats []map[string]interface{}
+	for elb, td := range digests {
+		data := map[string]interface{}{
+			"meta.type":                     "heartbeat",
+			"elb":                           elb,
+			"backend_processing_time.count": td.Count(),
+		}
+		for _, p := range []struct {
+			name     string
+			quantile float64
+		}{{"p50", 0.5}, {"p95", 0.95}, {"p99", 0.99}} {
+			latency := td.Quantile(p.quantile)
+			if d, ok := convertDuration("backend_processing_time", latency, h.durationUnit); ok {
+				latency = d
+			}
+			data["backend_processing_time."+p.name] = latency
+		}
+		heartbeats = append(heartbeats, data)
+	}
+	return heartbeats
+}
+
+func (h *latencyHeartbeat) run(interval time.Duration, datasets map[string]string) {
+	ticker := time.NewTicker(interval).C
+	for range ticker {
+		for _, data := range h.flush() {
+			libhEv := libhoney.NewEvent()
+			if dataset := datasetFor(data, datasets); dataset != "" {
+				libhEv.Dataset = dataset
+			}
+			applyDestination(libhEv)
+			if err := libhEv.Add(data); err != nil {
+				logrus.WithField("error", err).Error("Unexpected error adding data to heartbeat event")
+				continue
+			}
+			if err := libhEv.SendPresampled(); err != nil {
+				logrus.WithField("error", err).Error("Unexpected error sending heartbeat event")
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestLatencyHeartbeatFlush(t *testing.T) {
+	hb := newLatencyHeartbeat("")
+	for i := 1; i <= 100; i++ {
+		hb.add(event.Event{Data: map[string]interface{}{
+			"elb":                     "app/foo-alb/1db0c9806095122a",
+			"backend_processing_time": float64(i) / 100,
+		}})
+	}
+	// timed out requests and events without a load balancer are ignored
+	hb.add(event.Event{Data: map[string]interface{}{
+		"elb":                     "app/foo-alb/1db0c9806095122a",
+		"backend_processing_time": int64(-1),
+	}})
+	hb.add(event.Event{Data: map[string]interface{}{
+		"backend_processing_time": 0.5,
+	}})
+
+	heartbeats := hb.flush()
+	if len(heartbeats) != 1 {
+		t.Fatalf("expected 1 heartbeat, got %d", len(heartbeats))
+	}
+	if heartbeats[0]["backend_processing_time.count"] != 100 {
+		t.Errorf("expected count of 100, got %v", heartbeats[0]["backend_processing_time.count"])
+	}
+	if p99 := heartbeats[0]["backend_processing_time.p99"].(float64); p99 < 0.97 || p99 > 1.0 {
+		t.Errorf("expected p99 of ~0.99, got %v", p99)
+	}
+
+	if len(hb.flush()) != 0 {
+		t.Error("expected digests to be reset after flush")
+	}
+}
@@ -0,0 +1,116 @@
+package publisher
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The --ip-handling values.
+const (
+	ipKeep     = "keep"
+	ipDrop     = "drop"
+	ipHash     = "hash"
+	ipTruncate = "truncate"
+)
+
+// clientIPFields are the fields client IPs are logged in: with their port by
+// ELB, ALB and NLB, without by CloudFront, WAF and ALB connection logs, and
+// as a list in X-Forwarded-For.
+var clientIPFields = []string{"client_authority", "c_ip", "client_ip", "x_forwarded_for"}
+
+// IPHandling anonymizes the client IPs in events, for --ip-handling: dropping
+// them, replacing them with a keyed hash (so a client can still be followed
+// without its IP being recoverable), or truncating them to their /24 (IPv4)
+// or /48 (IPv6). It's applied just before events are sent, after the fields
+// derived from the IPs, such as request_fingerprint, have been added. A nil
+// IPHandling keeps IPs as they are.
+type IPHandling struct {
+	action string
+	key    []byte
+}
+
+// NewIPHandling returns the IPHandling for the action, or nil for keep. IPs
+// are hashed with the key, or a random one if it's empty, in which case the
+// hashes change every run.
+func NewIPHandling(action, key string) (*IPHandling, error) {
+	switch action {
+	case "", ipKeep:
+		return nil, nil
+	case ipDrop, ipTruncate:
+	case ipHash:
+		if key == "" {
+			logrus.Warn("No --ip-hash-key given, so a random one is used and hashed client IPs won't match across restarts")
+			random := make([]byte, 32)
+			if _, err := rand.Read(random); err != nil {
+				return nil, err
+			}
+			return &IPHandling{action: action, key: random}, nil
+		}
+	default:
+		return nil, fmt.Errorf("--ip-handling must be keep, drop, hash or truncate, got %q", action)
+	}
+	return &IPHandling{action: action, key: []byte(key)}, nil
+}
+
+func (h *IPHandling) apply(data map[string]interface{}) {
+	if h == nil {
+		return
+	}
+	for _, field := range clientIPFields {
+		v, ok := data[field].(string)
+		if !ok {
+			continue
+		}
+		if h.action == ipDrop {
+			delete(data, field)
+			continue
+		}
+		switch field {
+		case "client_authority":
+			data[field] = h.authority(v)
+		case "x_forwarded_for":
+			ips := strings.Split(v, ",")
+			for i, ip := range ips {
+				ips[i] = h.ip(strings.TrimSpace(ip))
+			}
+			data[field] = strings.Join(ips, ",")
+		default:
+			data[field] = h.ip(v)
+		}
+	}
+}
+
+// authority anonymizes the IP of an ip:port, keeping the port.
+func (h *IPHandling) authority(authority string) string {
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		return h.ip(authority)
+	}
+	return net.JoinHostPort(h.ip(host), port)
+}
+
+// ip anonymizes the IP. Values which aren't IPs are hashed either way, since
+// there's no telling what's in them.
+func (h *IPHandling) ip(v string) string {
+	parsed := net.ParseIP(v)
+	if h.action == ipTruncate && parsed != nil {
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(ipv4PrefixMask).String()
+		}
+		return parsed.Mask(ipv6PrefixMask).String()
+	}
+	if parsed != nil {
+		// the same IP is hashed the same however it's written
+		v = parsed.String()
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"testing"
+)
+
+func ipTestEvent() map[string]interface{} {
+	return map[string]interface{}{
+		"client_authority": "203.0.113.77:47882",
+		"c_ip":             "2001:db8:85a3:1234:5678:8a2e:370:7334",
+		"x_forwarded_for":  "198.51.100.9, 10.0.0.1",
+		"elb":              "app/my-lb/50dc6c495c0c9188",
+	}
+}
+
+func TestIPHandlingTruncate(t *testing.T) {
+	h, err := NewIPHandling(ipTruncate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ipTestEvent()
+	h.apply(data)
+	for field, expected := range map[string]string{
+		"client_authority": "203.0.113.0:47882",
+		"c_ip":             "2001:db8:85a3::",
+		"x_forwarded_for":  "198.51.100.0,10.0.0.0",
+		"elb":              "app/my-lb/50dc6c495c0c9188",
+	} {
+		if data[field] != expected {
+			t.Errorf("%s: expected %s, got %v", field, expected, data[field])
+		}
+	}
+}
+
+func TestIPHandlingHash(t *testing.T) {
+	h, err := NewIPHandling(ipHash, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ipTestEvent()
+	h.apply(data)
+	if data["client_authority"] != h.ip("203.0.113.77")+":47882" || data["client_authority"] == "203.0.113.77:47882" {
+		t.Errorf("expected the IP to be hashed and the port kept, got %v", data["client_authority"])
+	}
+	// the same IP written differently hashes the same
+	if h.ip("2001:db8:0:0:0:0:0:1") != h.ip("2001:db8::1") {
+		t.Error("expected equivalent IPv6 addresses to hash the same")
+	}
+	other, _ := NewIPHandling(ipHash, "other secret")
+	if other.ip("203.0.113.77") == h.ip("203.0.113.77") {
+		t.Error("expected the hash to depend on the key")
+	}
+}
+
+func TestIPHandlingDropAndKeep(t *testing.T) {
+	h, err := NewIPHandling(ipDrop, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ipTestEvent()
+	h.apply(data)
+	if len(data) != 1 || data["elb"] == nil {
+		t.Errorf("expected only the IP fields to be dropped, got %v", data)
+	}
+
+	keep, err := NewIPHandling(ipKeep, "")
+	if keep != nil || err != nil {
+		t.Errorf("expected nothing to do to keep IPs, got %v %v", keep, err)
+	}
+	data = ipTestEvent()
+	keep.apply(data)
+	if data["client_authority"] != "203.0.113.77:47882" {
+		t.Errorf("expected IPs to be kept, got %v", data)
+	}
+	if _, err := NewIPHandling("encrypt", ""); err == nil {
+		t.Error("expected an unknown action to be an error")
+	}
+}
@@ -0,0 +1,51 @@
+package publisher
+
+// Each ALB request's latency is logged in three parts: request_processing_time
+// from receiving the request to sending it to the target,
+// backend_processing_time (target_processing_time in AWS's docs) from then
+// until the target started responding, and response_processing_time from then
+// until the response was on its way to the client.
+
+// numberField returns the field as a float64, if it's a number.
+func numberField(data map[string]interface{}, field string) (float64, bool) {
+	switch n := data[field].(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// addLatencyFields adds fields derived from an ALB event's processing times,
+// before the ones which are -1 are dropped:
+//
+//   - target_timeout, whether backend_processing_time was -1, i.e. the target
+//     timed out or closed the connection before responding
+//   - total_time, the sum of the three processing times
+//   - time_to_first_byte, the time until the target started responding, and
+//     time_to_first_byte_pct, how much of total_time that was as a percentage
+//
+// The times are only added when none of the processing times are -1.
+func addLatencyFields(data map[string]interface{}) {
+	request, ok := numberField(data, "request_processing_time")
+	if !ok {
+		return
+	}
+	backend, ok := numberField(data, "backend_processing_time")
+	if !ok {
+		return
+	}
+	data["target_timeout"] = backend == -1
+
+	response, ok := numberField(data, "response_processing_time")
+	if !ok || request < 0 || backend < 0 || response < 0 {
+		return
+	}
+	total := request + backend + response
+	data["total_time"] = total
+	data["time_to_first_byte"] = request + backend
+	if total > 0 {
+		data["time_to_first_byte_pct"] = (request + backend) / total * 100
+	}
+}
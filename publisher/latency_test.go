@@ -0,0 +1,43 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddLatencyFields(t *testing.T) {
+	for _, c := range []struct {
+		data, expected map[string]interface{}
+	}{
+		{
+			map[string]interface{}{"request_processing_time": 0.25, "backend_processing_time": 0.5, "response_processing_time": int64(1)},
+			map[string]interface{}{"target_timeout": false, "total_time": 1.75, "time_to_first_byte": 0.75, "time_to_first_byte_pct": 0.75 / 1.75 * 100},
+		},
+		{
+			map[string]interface{}{"request_processing_time": 0.25, "backend_processing_time": int64(-1), "response_processing_time": int64(-1)},
+			map[string]interface{}{"target_timeout": true},
+		},
+		{
+			// nothing to take a percentage of
+			map[string]interface{}{"request_processing_time": 0.0, "backend_processing_time": 0.0, "response_processing_time": 0.0},
+			map[string]interface{}{"target_timeout": false, "total_time": 0.0, "time_to_first_byte": 0.0},
+		},
+		{
+			// e.g. NLB events
+			map[string]interface{}{"connection_time": int64(12)},
+			map[string]interface{}{},
+		},
+	} {
+		data := make(map[string]interface{})
+		for k, v := range c.data {
+			data[k] = v
+		}
+		addLatencyFields(data)
+		for k := range c.data {
+			delete(data, k)
+		}
+		if !reflect.DeepEqual(data, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.data, c.expected, data)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package publisher
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lineFormat is an nginx log_format compiled for scanning log lines in place,
+// giving the same fields as gonx (and so the honeytail nginx parser) does by
+// matching a regexp against the line: each field runs up to the first
+// occurrence of the character after it in the format, the text in between
+// fields has to match exactly, and anything after the last field is ignored.
+// Parsing a line allocates only the event's map and the values in it, since
+// the fields are substrings of the line.
+type lineFormat struct {
+	name   string
+	fields []formatField
+
+	// trailer is the text which has to follow the last field.
+	trailer string
+}
+
+type formatField struct {
+	// literal is the text before the field.
+	literal string
+	name    string
+
+	// end is the character ending the field. If last is set the field can
+	// end the line instead, having no character after it in the format.
+	end  byte
+	last bool
+}
+
+// compileLineFormat compiles the named log_format from the nginx config.
+func compileLineFormat(config []byte, name string) (*lineFormat, error) {
+	start := []byte("log_format " + name + " '")
+	i := bytes.Index(config, start)
+	if i < 0 {
+		return nil, fmt.Errorf("log_format %s not found", name)
+	}
+	format := config[i+len(start):]
+	end := bytes.Index(format, []byte("';"))
+	if end < 0 {
+		return nil, fmt.Errorf("log_format %s isn't terminated", name)
+	}
+	return parseLineFormat(name, string(format[:end])), nil
+}
+
+func mustCompileLineFormat(config []byte, name string) *lineFormat {
+	f, err := compileLineFormat(config, name)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func parseLineFormat(name, format string) *lineFormat {
+	f := &lineFormat{name: name}
+	literal := 0
+	for i := 0; i < len(format); {
+		if format[i] != '$' {
+			i++
+			continue
+		}
+		nameEnd := i + 1
+		for nameEnd < len(format) && isFieldNameChar(format[nameEnd]) {
+			nameEnd++
+		}
+		if nameEnd == i+1 {
+			i++
+			continue
+		}
+		field := formatField{literal: format[literal:i], name: format[i+1 : nameEnd]}
+		if nameEnd < len(format) {
+			field.end = format[nameEnd]
+			literal = nameEnd + 1
+		} else {
+			// gonx adds a space to the end of the format, which
+			// is then optional.
+			field.end = ' '
+			field.last = true
+			literal = nameEnd
+		}
+		f.fields = append(f.fields, field)
+		i = literal
+	}
+	f.trailer = format[literal:]
+	return f
+}
+
+func isFieldNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// parse returns the fields of the line, typed as the nginx parser types them:
+// numbers are int64 or float64, and fields which are "-" are left out.
+func (f *lineFormat) parse(line string) (map[string]interface{}, error) {
+	data, _, err := f.parsePrefix(line)
+	return data, err
+}
+
+// parsePrefix parses the line like parse does, also returning where in the
+// line the format ended, for lines with more fields than it has.
+func (f *lineFormat) parsePrefix(line string) (map[string]interface{}, int, error) {
+	data := make(map[string]interface{}, len(f.fields))
+	pos := 0
+	for _, field := range f.fields {
+		if !strings.HasPrefix(line[pos:], field.literal) {
+			return nil, 0, fmt.Errorf("log line doesn't match the %s format before $%s", f.name, field.name)
+		}
+		pos += len(field.literal)
+		n := strings.IndexByte(line[pos:], field.end)
+		if n < 0 {
+			if !field.last {
+				return nil, 0, fmt.Errorf("log line doesn't match the %s format, $%s isn't ended by %q", f.name, field.name, field.end)
+			}
+			n = len(line) - pos
+		}
+		if v, ok := typedValue(line[pos : pos+n]); ok {
+			data[field.name] = v
+		}
+		pos += n
+		if pos < len(line) {
+			// past the end character
+			pos++
+		}
+	}
+	if !strings.HasPrefix(line[pos:], f.trailer) {
+		return nil, 0, fmt.Errorf("log line doesn't match the end of the %s format", f.name)
+	}
+	return data, pos + len(f.trailer), nil
+}
+
+// typedValue types a field the way honeytail does, checking that it looks
+// like a number before parsing it as one, since failing to parse allocates an
+// error.
+func typedValue(v string) (interface{}, bool) {
+	switch {
+	case strings.IndexByte(v, '.') >= 0:
+		if looksNumeric(v, true) {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, true
+			}
+		}
+	case v == "-":
+		return nil, false
+	default:
+		if looksNumeric(v, false) {
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i, true
+			}
+		}
+	}
+	return v, true
+}
+
+// looksNumeric reports whether v is made of the characters of an integer, or
+// a float if float is set, but not whether they're in the right order.
+func looksNumeric(v string, float bool) bool {
+	if v == "" {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c >= '0' && c <= '9', c == '-', c == '+':
+		case float && (c == '.' || c == 'e' || c == 'E'):
+		default:
+			return false
+		}
+	}
+	return true
+}
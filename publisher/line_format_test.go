@@ -0,0 +1,108 @@
+package publisher
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/httime"
+	"github.com/honeycombio/honeytail/parsers/nginx"
+)
+
+func TestParseLineFormat(t *testing.T) {
+	f := parseLineFormat("test", `$a [$b] "$c" $d`)
+	expected := []formatField{
+		{literal: "", name: "a", end: ' '},
+		{literal: "[", name: "b", end: ']'},
+		{literal: ` "`, name: "c", end: '"'},
+		{literal: " ", name: "d", end: ' ', last: true},
+	}
+	if !reflect.DeepEqual(f.fields, expected) || f.trailer != "" {
+		t.Errorf("unexpected format %+v", f)
+	}
+
+	data, err := f.parse(`1 [2.5] "x y" - ignored`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, map[string]interface{}{"a": int64(1), "b": 2.5, "c": "x y"}) {
+		t.Errorf("unexpected fields %v", data)
+	}
+
+	for _, line := range []string{`1 2.5 "x y" z`, `1 [2.5] "x y`, `1`} {
+		if _, err := f.parse(line); err == nil {
+			t.Errorf("%q: expected the line not to match", line)
+		}
+	}
+
+	if f := parseLineFormat("test", `"$a"`); f.trailer != "" || f.fields[0].end != '"' || f.fields[0].last {
+		t.Errorf("expected the closing quote to end the field, got %+v", f)
+	}
+}
+
+func TestTypedValue(t *testing.T) {
+	for v, expected := range map[string]interface{}{
+		"200":       int64(200),
+		"-1":        int64(-1),
+		"0.000021":  0.000021,
+		"1.5e3":     1500.0,
+		"1.2.3.4":   "1.2.3.4",
+		"10.0.0.1:": "10.0.0.1:",
+		"h2":        "h2",
+		"":          "",
+	} {
+		if typed, ok := typedValue(v); !ok || typed != expected {
+			t.Errorf("%q: expected %#v, got %#v", v, expected, typed)
+		}
+	}
+	if _, ok := typedValue("-"); ok {
+		t.Error(`expected "-" to be left out`)
+	}
+}
+
+// The ALB format has to give the same events as the nginx parser did.
+func TestALBFormatMatchesNginxParser(t *testing.T) {
+	lines := []string{
+		`h2 2017-07-31T20:30:57.975041Z spline_reticulation_lb 10.11.12.13:47882 10.3.47.87:8080 0.000021 0.010962 -1 504 504 766 17 "PUT https://api.simulation.io:443/reticulate/spline/1 HTTP/1.1" "libhoney-go/1.3.3" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 groupARN "Root=1-5e71404d-84277a47a826ab3d2e844170" "ui-dogfood.honeycomb.io" "certARN" 0 2017-07-31T20:30:52.975041Z "forward" "-" "-" "10.11.12.13:80" "201"`,
+		`http 2018-02-18T03:03:10.432026Z app/alb-test-2/ebd66bfd69677bfa 142.44.241.206:60000 172.31.21.134:80 0.001 0.001 0.000 200 200 70 248 "GET http://alb-test-2-265992175.us-east-1.elb.amazonaws.com:80/ HTTP/1.0" "https://getroot.sh survey" - - arn:aws:elasticloadbalancing:us-east-1:729997878290:targetgroup/ec2instances/3bf8bbb3ab2b6080 "Root=1-5a88eced-40876ce050d010360bfb23bd" "-" "-" 0 2018-02-18T03:03:10.431000Z`,
+		`ws 2018-02-18T03:03:10.432026Z app/alb-test-2/ebd66bfd69677bfa 142.44.241.206:60000 - -1 -1 -1 460 - 70 0 "- - - " "-" - - - "-" "-" "-" - 2018-02-18T03:03:10.431000Z`,
+		`https 2018-02-18T03:03:10.432026Z app/alb-test-2/ebd66bfd69677bfa 142.44.241.206:60000 172.31.21.134:80 0.001 0.001 0.000 200 200 70 248 "GET / HTTP/1.1" "a "quoted" agent" - -`,
+		`not an ALB log line`,
+	}
+
+	np := &nginx.Parser{}
+	if err := np.Init(&nginx.Options{
+		ConfigFile:      formatFileName,
+		TimeFieldName:   "timestamp",
+		TimeFieldFormat: albTimeFormat,
+		LogFormatName:   AWSApplicationLoadBalancerFormat,
+		NumParsers:      runtime.NumCPU(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		linesCh := make(chan string, 1)
+		eventsCh := make(chan event.Event, 1)
+		linesCh <- line
+		close(linesCh)
+		np.ProcessLines(linesCh, eventsCh, nil)
+		close(eventsCh)
+		expected, parsed := <-eventsCh
+
+		data, err := albFormat.parse(line)
+		if parsed != (err == nil) {
+			t.Errorf("%q: expected parsing to succeed to be %v, got %v", line, parsed, err)
+			continue
+		}
+		if !parsed {
+			continue
+		}
+		if ts := httime.GetTimestamp(data, "timestamp", albTimeFormat); !ts.Equal(expected.Timestamp) {
+			t.Errorf("%q: expected timestamp %s, got %s", line, expected.Timestamp, ts)
+		}
+		if !reflect.DeepEqual(data, expected.Data) {
+			t.Errorf("%q: expected %v, got %v", line, expected.Data, data)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/sirupsen/logrus"
+)
+
+const markerTimeout = 10 * time.Second
+
+// The types markers are created with, for them to be told apart (and
+// filtered) in Honeycomb.
+const (
+	markerTypeBackfill  = "honeyaws-backfill"
+	markerTypeDiscovery = "honeyaws-discovery"
+	markerTypeReplay    = "honeyaws-replay"
+)
+
+// Markers creates Honeycomb markers, with the Markers API, on the dataset the
+// events of a load balancer (or distribution, trail, etc.) are sent to when
+// ingesting it reaches a milestone: its backfill starting and finishing, or
+// it being newly discovered, or the replay of a --start-time range. Methods on
+// a nil Markers do nothing, for when --create-markers isn't given.
+type Markers struct {
+	apiHost, writeKey string
+	dataset           string
+	datasets          map[string]string
+	dryRun            bool
+	replayID          string
+	client            *http.Client
+}
+
+// marker is the body of a Markers API request.
+type marker struct {
+	Message   string `json:"message"`
+	Type      string `json:"type"`
+	StartTime int64  `json:"start_time"`
+}
+
+// NewMarkers returns the Markers for --create-markers, or nil if it isn't
+// given. Under --dry-run the markers are only logged.
+func NewMarkers(opt *options.Options, datasets map[string]string) *Markers {
+	if !opt.CreateMarkers {
+		return nil
+	}
+	return &Markers{
+		apiHost:  opt.APIHost,
+		writeKey: opt.WriteKey,
+		dataset:  opt.Dataset,
+		datasets: datasets,
+		dryRun:   opt.DryRun,
+		client:   &http.Client{Timeout: markerTimeout, Transport: honeycombTransport},
+	}
+}
+
+// NewReplayMarkers returns the Markers for the replay of a --start-time range,
+// which are created whether or not --create-markers is given, or nil if
+// nothing is being replayed.
+func NewReplayMarkers(opt *options.Options, datasets map[string]string) *Markers {
+	if opt.ReplayID == "" {
+		return nil
+	}
+	return &Markers{
+		apiHost:  opt.APIHost,
+		writeKey: opt.WriteKey,
+		dataset:  opt.Dataset,
+		datasets: datasets,
+		dryRun:   opt.DryRun,
+		replayID: opt.ReplayID,
+		client:   &http.Client{Timeout: markerTimeout, Transport: honeycombTransport},
+	}
+}
+
+// BackfillStarted marks the first listing of the entity's logs, which
+// backfills them, starting.
+func (m *Markers) BackfillStarted(entity string) {
+	m.create(entity, markerTypeBackfill, "Backfill of "+entity+" started")
+}
+
+// BackfillFinished marks the backfill of the entity's logs having been
+// queued.
+func (m *Markers) BackfillFinished(entity string) {
+	m.create(entity, markerTypeBackfill, "Backfill of "+entity+" finished")
+}
+
+// LoadBalancerDiscovered marks a load balancer being ingested since it was
+// found by rediscovery, rather than on startup.
+func (m *Markers) LoadBalancerDiscovered(lb string) {
+	m.create(lb, markerTypeDiscovery, "Discovered load balancer "+lb)
+}
+
+// ReplayStarted marks the replay of the range starting, at the start of the
+// range, where its events will show up.
+func (m *Markers) ReplayStarted(start, end time.Time) {
+	m.createReplay(start, fmt.Sprintf("Replay %s of %s to %s started", m.replayID, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)))
+}
+
+// ReplayFinished marks the replay of the range having been published, at the
+// end of the range.
+func (m *Markers) ReplayFinished(start, end time.Time) {
+	m.createReplay(end, fmt.Sprintf("Replay %s of %s to %s finished", m.replayID, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)))
+}
+
+// createReplay creates the replay marker on every dataset the replay's events
+// may be sent to, unless it's there already, e.g. from replaying the range
+// again with --force. Unlike create, it waits for them, since ingesting a
+// range exits once it's done.
+func (m *Markers) createReplay(at time.Time, message string) {
+	if m == nil {
+		return
+	}
+	mk := marker{Message: message, Type: markerTypeReplay, StartTime: at.Unix()}
+	for _, dataset := range m.allDatasets() {
+		logger := logrus.WithFields(logrus.Fields{
+			"dataset": dataset,
+			"type":    markerTypeReplay,
+			"message": message,
+		})
+		if m.dryRun {
+			logger.Info("Would create marker")
+			continue
+		}
+		existing, err := m.list(dataset)
+		if err != nil {
+			logger.WithField("error", err).Error("Could not list the markers")
+			continue
+		}
+		if hasMarker(existing, mk) {
+			logger.Debug("Marker already created")
+			continue
+		}
+		if err := m.post(dataset, mk); err != nil {
+			logger.WithField("error", err).Error("Could not create marker")
+			continue
+		}
+		logger.Debug("Created marker")
+	}
+}
+
+// allDatasets returns the default dataset and those of --dataset_map.
+func (m *Markers) allDatasets() []string {
+	seen := map[string]bool{m.dataset: true}
+	datasets := []string{m.dataset}
+	for _, dataset := range m.datasets {
+		if !seen[dataset] {
+			seen[dataset] = true
+			datasets = append(datasets, dataset)
+		}
+	}
+	sort.Strings(datasets[1:])
+	return datasets
+}
+
+func hasMarker(markers []marker, mk marker) bool {
+	for _, existing := range markers {
+		if existing == mk {
+			return true
+		}
+	}
+	return false
+}
+
+// datasetFor returns the dataset the entity's events are sent to.
+func (m *Markers) datasetFor(entity string) string {
+	if dataset := datasetFor(map[string]interface{}{"elb": entity}, m.datasets); dataset != "" {
+		return dataset
+	}
+	return m.dataset
+}
+
+// create creates the marker in the background, since ingesting shouldn't wait
+// on it. Failing to is logged and otherwise ignored.
+func (m *Markers) create(entity, markerType, message string) {
+	if m == nil {
+		return
+	}
+	dataset := m.datasetFor(entity)
+	logger := logrus.WithFields(logrus.Fields{
+		"dataset": dataset,
+		"type":    markerType,
+		"message": message,
+	})
+	if m.dryRun {
+		logger.Info("Would create marker")
+		return
+	}
+	mk := marker{Message: message, Type: markerType, StartTime: time.Now().Unix()}
+	go func() {
+		if err := m.post(dataset, mk); err != nil {
+			logger.WithField("error", err).Error("Could not create marker")
+			return
+		}
+		logger.Debug("Created marker")
+	}()
+}
+
+func (m *Markers) post(dataset string, mk marker) error {
+	body, err := json.Marshal(mk)
+	if err != nil {
+		return err
+	}
+	_, err = m.do("POST", dataset, body)
+	return err
+}
+
+// list returns the dataset's markers.
+func (m *Markers) list(dataset string) ([]marker, error) {
+	data, err := m.do("GET", dataset, nil)
+	if err != nil {
+		return nil, err
+	}
+	var markers []marker
+	if err := json.Unmarshal(data, &markers); err != nil {
+		return nil, fmt.Errorf("Unmarshalling the markers failed: %s", err)
+	}
+	return markers, nil
+}
+
+// do makes the Markers API request for the dataset, with the write key and
+// API host the dataset's events are sent with, returning the response body.
+func (m *Markers) do(method, dataset string, body []byte) ([]byte, error) {
+	apiHost, writeKey := m.apiHost, m.writeKey
+	if failover != nil && failover.active() {
+		writeKey = failover.writeKey
+		if failover.apiHost != "" {
+			apiHost = failover.apiHost
+		}
+	}
+	if d, ok := destinations[dataset]; ok {
+		if d.writeKey != "" {
+			writeKey = d.writeKey
+		}
+		if d.apiHost != "" {
+			apiHost = d.apiHost
+		}
+	}
+	u, err := url.Parse(apiHost)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "/1/markers", url.PathEscape(dataset))
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Honeycomb-Team", writeKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	return respBody, err
+}
@@ -0,0 +1,112 @@
+package publisher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+func TestMarkers(t *testing.T) {
+	if m := NewMarkers(&options.Options{}, nil); m != nil {
+		t.Fatalf("expected no markers without --create-markers, got %+v", m)
+	}
+	// and nothing happens with them
+	var m *Markers
+	m.LoadBalancerDiscovered("my-lb")
+
+	var (
+		gotPath, gotKey string
+		got             marker
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotKey = r.Header.Get("X-Honeycomb-Team")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"id":"2ktr6DERD7"}`))
+	}))
+	defer srv.Close()
+
+	m = NewMarkers(&options.Options{
+		CreateMarkers: true,
+		APIHost:       srv.URL + "/",
+		WriteKey:      "abc123",
+		Dataset:       "aws-alb-access",
+	}, map[string]string{"payments": "payments-alb"})
+
+	if dataset := m.datasetFor("app/payments/1db0c9806095122a"); dataset != "payments-alb" {
+		t.Errorf("expected the load balancer's dataset, got %s", dataset)
+	}
+	if dataset := m.datasetFor("other"); dataset != "aws-alb-access" {
+		t.Errorf("expected the default dataset, got %s", dataset)
+	}
+
+	if err := m.post("payments-alb", marker{Message: "Discovered load balancer payments", Type: markerTypeDiscovery, StartTime: 1500000000}); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/1/markers/payments-alb" || gotKey != "abc123" {
+		t.Errorf("unexpected request to %s with key %q", gotPath, gotKey)
+	}
+	if got.Message != "Discovered load balancer payments" || got.Type != markerTypeDiscovery || got.StartTime != 1500000000 {
+		t.Errorf("unexpected marker %+v", got)
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"unknown API key"}`, http.StatusUnauthorized)
+	})
+	if err := m.post("payments-alb", marker{}); err == nil {
+		t.Error("expected the rejected marker to be an error")
+	}
+}
+
+func TestReplayMarkers(t *testing.T) {
+	if m := NewReplayMarkers(&options.Options{}, nil); m != nil {
+		t.Fatalf("expected no replay markers without a replay, got %+v", m)
+	}
+
+	created := make(map[string][]marker)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dataset := strings.TrimPrefix(r.URL.Path, "/1/markers/")
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(created[dataset])
+			return
+		}
+		var mk marker
+		if err := json.NewDecoder(r.Body).Decode(&mk); err != nil {
+			t.Error(err)
+		}
+		created[dataset] = append(created[dataset], mk)
+		w.Write([]byte(`{"id":"2ktr6DERD7"}`))
+	}))
+	defer srv.Close()
+
+	m := NewReplayMarkers(&options.Options{
+		APIHost:  srv.URL,
+		WriteKey: "abc123",
+		Dataset:  "aws-alb-access",
+		ReplayID: "honeyalb-20180820T1400Z-20180820T1600Z-c1329555",
+	}, map[string]string{"payments": "payments-alb", "checkout": "payments-alb"})
+	start := time.Date(2018, 8, 20, 14, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	m.ReplayStarted(start, end)
+	m.ReplayFinished(start, end)
+	// replaying the range again with --force
+	m.ReplayStarted(start, end)
+	m.ReplayFinished(start, end)
+
+	for _, dataset := range []string{"aws-alb-access", "payments-alb"} {
+		markers := created[dataset]
+		if len(markers) != 2 || markers[0].StartTime != start.Unix() || markers[1].StartTime != end.Unix() || markers[0].Type != markerTypeReplay {
+			t.Fatalf("expected the start and end markers to be created on %s once, got %+v", dataset, markers)
+		}
+		if markers[0].Message != "Replay honeyalb-20180820T1400Z-20180820T1600Z-c1329555 of 2018-08-20T14:00:00Z to 2018-08-20T16:00:00Z started" {
+			t.Errorf("unexpected message %q", markers[0].Message)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package publisher
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers/nginx"
+	"github.com/sirupsen/logrus"
+)
+
+type NLBEventParser struct {
+	dynamicSampling
+}
+
+func NewNLBEventParser(opt *options.Options) *NLBEventParser {
+	return &NLBEventParser{dynamicSampling: newDynamicSampling(opt)}
+}
+
+func (ep *NLBEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	np := &nginx.Parser{}
+	err := np.Init(&nginx.Options{
+		ConfigFile:      formatFileName,
+		TimeFieldName:   "timestamp",
+		TimeFieldFormat: "2006-01-02T15:04:05",
+		LogFormatName:   AWSNetworkLoadBalancerFormat,
+		NumParsers:      runtime.NumCPU(),
+	})
+	if err != nil {
+		logrus.Fatal("Can't initialize the nginx parser")
+	}
+
+	linesCh := make(chan string)
+
+	// ProcessLines returns once every line has been parsed and sent
+	// along, which lets us do the same
+	parsed := make(chan struct{})
+	go func() {
+		np.ProcessLines(linesCh, out, nil)
+		close(parsed)
+	}()
+
+	r, err := logbucket.Open(obj)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	scanner := newLineScanner(obj, r)
+	check := lineChecker(obj, AWSNetworkLoadBalancerFormat)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := check(line); err != nil {
+			scanner.unparseable(line, err)
+			continue
+		}
+		linesCh <- line
+	}
+
+	close(linesCh)
+	<-parsed
+
+	return scanner.Err()
+}
+
+func (ep *NLBEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		// NLBs have no status codes, so use the TLS alert (if any) and
+		// the listener to set the sample rate
+		key := "-"
+		if tlsAlert, ok := ev.Data["incoming_tls_alert"]; ok {
+			key = fmt.Sprintf("%v", tlsAlert)
+		}
+		if listener, ok := ev.Data["listener"]; ok {
+			key = fmt.Sprintf("%s_%v", key, listener)
+		}
+
+		// Make sure sample rate is per-NLB
+		if elbName, ok := ev.Data["elb"]; ok {
+			if name, ok := elbName.(string); ok {
+				key = fmt.Sprintf("%s_%s", key, name)
+			}
+		}
+
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
+		rate := ep.sampler.GetSampleRate(key)
+		if rate <= 0 {
+			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
+			rate = 1
+		}
+		if rand.Intn(rate) == 0 {
+			ev.SampleRate = rate
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package publisher
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// Progress through an object is recorded at most this often, checking every
+// offsetCheckLines lines, so as not to write state for every line.
+const (
+	offsetInterval   = 10 * time.Second
+	offsetCheckLines = 1000
+)
+
+// lineScanner scans the lines of an object like a bufio.Scanner, skipping
+// those published before processing was interrupted and reporting progress
+// through the rest.
+type lineScanner struct {
+	*bufio.Scanner
+	obj   state.DownloadedObject
+	lines int64
+}
+
+func newLineScanner(obj state.DownloadedObject, r io.Reader) *lineScanner {
+	return &lineScanner{Scanner: bufio.NewScanner(r), obj: obj}
+}
+
+func (s *lineScanner) Scan() bool {
+	for s.Scanner.Scan() {
+		s.lines++
+		if s.lines <= s.obj.Offset {
+			continue
+		}
+		// every line before this one has been handed along
+		if s.obj.Progress != nil {
+			s.obj.Progress(s.lines - 1)
+		}
+		return true
+	}
+	return false
+}
+
+// unparseable reports the line just scanned as one which couldn't be parsed.
+func (s *lineScanner) unparseable(text string, err error) {
+	if s.obj.Unparseable != nil {
+		s.obj.Unparseable(s.lines, text, err)
+	}
+}
+
+// offsetTracker records how far publishing an object has got, so that it can
+// be resumed from there if the process dies.
+type offsetTracker struct {
+	stater   state.Stater
+	object   string
+	resumed  bool
+	recorded time.Time
+
+	// left is set when the object is left unfinished, to be resumed.
+	left bool
+
+	// lines is the latest progress, for flush, which may be called from
+	// another goroutine.
+	lines int64
+
+	// confirm, with --exactly_once, has progress recorded only once
+	// Honeycomb has accepted the events up to it.
+	confirm *confirmation
+}
+
+func (t *offsetTracker) progress(lines int64) {
+	atomic.StoreInt64(&t.lines, lines)
+	if lines == 0 || lines%offsetCheckLines != 0 || time.Since(t.recorded) < offsetInterval {
+		return
+	}
+	t.recorded = time.Now()
+	if t.confirm != nil {
+		t.confirm.checkpoint(lines)
+		return
+	}
+	t.record(lines)
+}
+
+// record records the progress through the object.
+func (t *offsetTracker) record(lines int64) {
+	if err := t.stater.SetOffset(t.object, lines); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"object": t.object,
+			"error":  err,
+		}).Error("Could not record progress through object")
+	}
+}
+
+// flush records the latest progress through the object, however recently it
+// was last recorded, for when the process is about to die. Progress waiting
+// on Honeycomb to accept the events up to it is left as it was.
+func (t *offsetTracker) flush() {
+	lines := atomic.LoadInt64(&t.lines)
+	if lines == 0 || t.confirm != nil {
+		return
+	}
+	t.record(lines)
+}
+
+// leave records the object as unfinished, as far as it got or from the
+// offset it was resumed from, so that it's resumed again rather than having
+// its offset cleared when done.
+func (t *offsetTracker) leave(from int64) {
+	t.left = true
+	lines := atomic.LoadInt64(&t.lines)
+	if lines < from {
+		lines = from
+	}
+	t.record(lines)
+}
+
+// done clears the offset once the object has been published, if there's one
+// to clear.
+func (t *offsetTracker) done() {
+	if t.left || !t.resumed && t.recorded.IsZero() {
+		return
+	}
+	if err := t.stater.ClearOffset(t.object); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"object": t.object,
+			"error":  err,
+		}).Error("Could not clear progress through object")
+	}
+}
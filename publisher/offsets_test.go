@@ -0,0 +1,74 @@
+package publisher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/state"
+)
+
+func TestLineScannerResumes(t *testing.T) {
+	var progress []int64
+	obj := state.DownloadedObject{
+		Object:   "obj",
+		Offset:   2,
+		Progress: func(lines int64) { progress = append(progress, lines) },
+	}
+
+	scanner := newLineScanner(obj, strings.NewReader("one\ntwo\nthree\nfour\n"))
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if !reflect.DeepEqual(lines, []string{"three", "four"}) {
+		t.Errorf("expected the first two lines to be skipped, got %v", lines)
+	}
+	if !reflect.DeepEqual(progress, []int64{2, 3}) {
+		t.Errorf("unexpected progress %v", progress)
+	}
+}
+
+func TestOffsetTracker(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	tracker := &offsetTracker{stater: stater, object: "obj"}
+
+	tracker.progress(999)
+	if offsets, _ := stater.Offsets(); len(offsets) != 0 {
+		t.Errorf("expected progress to only be checked every %d lines, got %v", offsetCheckLines, offsets)
+	}
+
+	tracker.progress(1000)
+	if offsets, _ := stater.Offsets(); offsets["obj"].Lines != 1000 {
+		t.Errorf("expected progress to be recorded, got %v", offsets)
+	}
+
+	// not again until offsetInterval has passed
+	tracker.progress(2000)
+	if offsets, _ := stater.Offsets(); offsets["obj"].Lines != 1000 {
+		t.Errorf("expected progress not to be recorded again so soon, got %v", offsets)
+	}
+
+	tracker.done()
+	if offsets, _ := stater.Offsets(); len(offsets) != 0 {
+		t.Errorf("expected the offset to be cleared once done, got %v", offsets)
+	}
+}
+
+func TestOffsetTrackerFlush(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	tracker := &offsetTracker{stater: stater, object: "obj"}
+
+	tracker.flush()
+	if offsets, _ := stater.Offsets(); len(offsets) != 0 {
+		t.Errorf("expected nothing to be recorded without progress, got %v", offsets)
+	}
+
+	tracker.progress(1000)
+	tracker.progress(1234)
+	tracker.flush()
+	if offsets, _ := stater.Offsets(); offsets["obj"].Lines != 1234 {
+		t.Errorf("expected the latest progress to be recorded, got %v", offsets)
+	}
+}
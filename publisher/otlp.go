@@ -0,0 +1,307 @@
+package publisher
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	otlpBatchSize     = 500
+	otlpFlushInterval = time.Second
+	otlpExportTimeout = 30 * time.Second
+)
+
+// otlpSender is a libhoney transmission.Sender for --output=otlp that,
+// instead of sending events to Honeycomb's events API, exports each as a span
+// over OTLP/gRPC, so that access log records show up in traces wherever
+// they're collected. The span is given the trace and parent IDs parsed from
+// X-Amzn-Trace-Id, when there are any.
+type otlpSender struct {
+	sync.Mutex
+	exporter  sdktrace.SpanExporter
+	batch     []*transmission.Event
+	responses chan transmission.Response
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// parseOTLPHeaders parses --otlp_headers, given as name=value.
+func parseOTLPHeaders(headers []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(headers))
+	for _, header := range headers {
+		kv := strings.SplitN(header, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%q is not a name=value header", header)
+		}
+		parsed[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return parsed, nil
+}
+
+func newOTLPSender(opt *options.Options) (*otlpSender, error) {
+	if opt.OTLPEndpoint == "" {
+		return nil, fmt.Errorf("--output=otlp requires --otlp_endpoint")
+	}
+	headers, err := parseOTLPHeaders(opt.OTLPHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(opt.OTLPEndpoint),
+		otlptracegrpc.WithHeaders(headers),
+	}
+	if opt.OTLPInsecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	// The connection is made in the background, so that an unreachable
+	// collector shows up as failed exports rather than failing startup.
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &otlpSender{
+		exporter:  exporter,
+		responses: make(chan transmission.Response, 2*otlpBatchSize),
+	}, nil
+}
+
+func (s *otlpSender) Start() error {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(otlpFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Flush()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *otlpSender) Stop() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	return s.Flush()
+}
+
+func (s *otlpSender) Add(ev *transmission.Event) {
+	s.Lock()
+	s.batch = append(s.batch, ev)
+	var full []*transmission.Event
+	if len(s.batch) >= otlpBatchSize {
+		full = s.batch
+		s.batch = nil
+	}
+	s.Unlock()
+
+	if full != nil {
+		s.export(full)
+	}
+}
+
+func (s *otlpSender) Flush() error {
+	s.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.export(batch)
+}
+
+// export exports a batch of spans, responding for each of its events like
+// Honeycomb's batch API would.
+func (s *otlpSender) export(batch []*transmission.Event) error {
+	spans := make(tracetest.SpanStubs, 0, len(batch))
+	for _, ev := range batch {
+		spans = append(spans, eventSpan(ev))
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+	defer cancel()
+	err := s.exporter.ExportSpans(ctx, spans.Snapshots())
+	for _, ev := range batch {
+		resp := transmission.Response{
+			Err:      err,
+			Duration: time.Since(start),
+			Metadata: ev.Metadata,
+		}
+		if err == nil {
+			resp.StatusCode = http.StatusAccepted
+		}
+		s.SendResponse(resp)
+	}
+	return err
+}
+
+func (s *otlpSender) TxResponses() chan transmission.Response {
+	return s.responses
+}
+
+// SendResponse doesn't block if nothing is reading the responses, like the
+// default libhoney transmission.
+func (s *otlpSender) SendResponse(resp transmission.Response) bool {
+	select {
+	case s.responses <- resp:
+		return false
+	default:
+		return true
+	}
+}
+
+// spanTraceID returns the trace ID of the event, in either format
+// --trace_id_format writes it in, or a new one if it doesn't have one.
+func spanTraceID(data map[string]interface{}) trace.TraceID {
+	if id, ok := data["trace.trace_id"].(string); ok {
+		if w3c := w3cTraceID(id); w3c != "" {
+			id = w3c
+		}
+		if traceID, err := trace.TraceIDFromHex(id); err == nil {
+			return traceID
+		}
+	}
+	var traceID trace.TraceID
+	rand.Read(traceID[:])
+	return traceID
+}
+
+// parseSpanID parses the span ID in the field. If it isn't set, or isn't a W3C
+// span ID (X-Ray's Self IDs aren't), it's a new one if generate is set and an
+// invalid one otherwise.
+func parseSpanID(data map[string]interface{}, field string, generate bool) trace.SpanID {
+	if id, ok := data[field].(string); ok {
+		if spanID, err := trace.SpanIDFromHex(id); err == nil {
+			return spanID
+		}
+	}
+	var spanID trace.SpanID
+	if generate {
+		rand.Read(spanID[:])
+	}
+	return spanID
+}
+
+func spanAttribute(k string, v interface{}) attribute.KeyValue {
+	switch v := v.(type) {
+	case string:
+		return attribute.String(k, v)
+	case int64:
+		return attribute.Int64(k, v)
+	case int:
+		return attribute.Int(k, v)
+	case float64:
+		return attribute.Float64(k, v)
+	case bool:
+		return attribute.Bool(k, v)
+	}
+	return attribute.String(k, fmt.Sprint(v))
+}
+
+// eventSpan converts an event into a server span named after its request,
+// lasting its duration_ms, with its fields as attributes. Requests which got
+// a 5xx from the load balancer are errors.
+func eventSpan(ev *transmission.Event) tracetest.SpanStub {
+	data := ev.Data
+	traceID := spanTraceID(data)
+
+	name := ev.Dataset
+	if n, ok := data["name"].(string); ok && n != "" {
+		name = n
+		if method, ok := data["request_method"].(string); ok && method != "" {
+			name = method + " " + n
+		}
+	}
+
+	end := ev.Timestamp
+	if durationMs, ok := data["duration_ms"].(float64); ok {
+		end = ev.Timestamp.Add(time.Duration(durationMs * float64(time.Millisecond)))
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, spanAttribute(k, data[k]))
+	}
+	// Honeycomb weights spans by this attribute, as it does events by
+	// their sample rate.
+	if ev.SampleRate > 1 {
+		attrs = append(attrs, attribute.Int64("SampleRate", int64(ev.SampleRate)))
+	}
+
+	var status sdktrace.Status
+	if code, ok := data["elb_status_code"].(int64); ok && code >= 500 {
+		status = sdktrace.Status{Code: codes.Error, Description: http.StatusText(int(code))}
+	}
+
+	serviceName := ev.Dataset
+	if s, ok := data["service_name"].(string); ok && s != "" {
+		serviceName = s
+	}
+	if s, ok := data[serviceNameField].(string); ok && s != "" {
+		serviceName = s
+	}
+
+	span := tracetest.SpanStub{
+		Name: name,
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     parseSpanID(data, "trace.span_id", true),
+			TraceFlags: trace.FlagsSampled,
+		}),
+		SpanKind:   trace.SpanKindServer,
+		StartTime:  ev.Timestamp,
+		EndTime:    end,
+		Attributes: attrs,
+		Status:     status,
+		Resource: resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		),
+		InstrumentationLibrary: instrumentation.Library{Name: "github.com/honeycombio/honeyaws"},
+	}
+	if parentID := parseSpanID(data, "trace.parent_id", false); parentID.IsValid() {
+		span.Parent = trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     parentID,
+			TraceFlags: trace.FlagsSampled,
+			Remote:     true,
+		})
+	}
+	return span
+}
@@ -0,0 +1,79 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEventSpan(t *testing.T) {
+	start := time.Date(2017, 7, 31, 20, 30, 52, 0, time.UTC)
+	span := eventSpan(&transmission.Event{
+		Dataset:    "aws-alb-access",
+		Timestamp:  start,
+		SampleRate: 4,
+		Data: map[string]interface{}{
+			"trace.trace_id":  "1-5759e988-bd862e3fe1be46a994272793",
+			"trace.span_id":   "1-67891234-12456789abcdef012345678",
+			"trace.parent_id": "53995c3f42cd8ad8",
+			"duration_ms":     1.5,
+			"elb_status_code": int64(504),
+			"request_method":  "PUT",
+			"name":            "/reticulate/spline/1",
+			"service_name":    "app/foo-alb/1db0c9806095122a",
+		},
+	})
+
+	if span.Name != "PUT /reticulate/spline/1" || span.SpanKind != trace.SpanKindServer {
+		t.Errorf("unexpected span %q of kind %v", span.Name, span.SpanKind)
+	}
+	if id := span.SpanContext.TraceID().String(); id != "5759e988bd862e3fe1be46a994272793" {
+		t.Errorf("unexpected trace ID %s", id)
+	}
+	if !span.SpanContext.SpanID().IsValid() {
+		t.Error("expected a span ID to be generated")
+	}
+	if span.Parent.SpanID().String() != "53995c3f42cd8ad8" || span.Parent.TraceID() != span.SpanContext.TraceID() || !span.Parent.IsRemote() {
+		t.Errorf("unexpected parent %v", span.Parent)
+	}
+	if d := span.EndTime.Sub(span.StartTime); d != 1500*time.Microsecond {
+		t.Errorf("unexpected duration %s", d)
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("expected a 504 to be an error, got %v", span.Status)
+	}
+	if v, ok := span.Resource.Set().Value("service.name"); !ok || v.AsString() != "app/foo-alb/1db0c9806095122a" {
+		t.Errorf("unexpected service name %v", v)
+	}
+
+	attrs := attribute.NewSet(span.Attributes...)
+	if v, _ := attrs.Value("elb_status_code"); v.AsInt64() != 504 {
+		t.Errorf("expected the fields as attributes, got %v", span.Attributes)
+	}
+	if v, _ := attrs.Value("SampleRate"); v.AsInt64() != 4 {
+		t.Errorf("expected the sample rate as an attribute, got %v", span.Attributes)
+	}
+
+	// events without a trace get a trace of their own
+	span = eventSpan(&transmission.Event{Dataset: "aws-elb-access", Timestamp: start, Data: map[string]interface{}{}})
+	if !span.SpanContext.TraceID().IsValid() || span.Parent.IsValid() || span.Name != "aws-elb-access" {
+		t.Errorf("unexpected span %v", span)
+	}
+}
+
+func TestParseOTLPHeaders(t *testing.T) {
+	headers, err := parseOTLPHeaders([]string{"x-honeycomb-team=abc=123", " x-honeycomb-dataset = alb "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["x-honeycomb-team"] != "abc=123" || headers["x-honeycomb-dataset"] != "alb" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+	if _, err := parseOTLPHeaders([]string{"x-honeycomb-team"}); err == nil {
+		t.Error("expected a header without a value to be rejected")
+	}
+}
@@ -0,0 +1,88 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// parsedEvent is a line written by parse.
+type parsedEvent struct {
+	Timestamp time.Time              `json:"time"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// ParseFiles parses log files, or stdin, with the parser, writing each event
+// to w as a line of JSON, with its time and its fields as the parser left
+// them, for the parse subcommand: nothing is sampled, shaped or sent. Lines
+// which couldn't be parsed are logged, and make it return an error once
+// every file has been parsed.
+func ParseFiles(w io.Writer, parser EventParser, paths []string) error {
+	downloadsCh := make(chan state.DownloadedObject)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- logbucket.LocalFiles(paths, downloadsCh)
+	}()
+
+	buffered := bufio.NewWriter(w)
+	unparseable, err := parseObjects(buffered, parser, downloadsCh)
+	if flushErr := buffered.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	if unparseable > 0 {
+		return fmt.Errorf("%d lines couldn't be parsed", unparseable)
+	}
+	return nil
+}
+
+// parseObjects writes the events parsed from each object to w, returning the
+// number of lines which couldn't be parsed.
+func parseObjects(w io.Writer, parser EventParser, objects <-chan state.DownloadedObject) (int, error) {
+	enc := json.NewEncoder(w)
+	unparseable := 0
+	for obj := range objects {
+		obj.Unparseable = func(line int64, text string, err error) {
+			unparseable++
+			logrus.WithFields(logrus.Fields{
+				"object": obj.Object,
+				"line":   line,
+				"error":  err,
+			}).Warn("Could not parse line")
+		}
+
+		out := make(chan event.Event)
+		written := make(chan error, 1)
+		go func() {
+			var err error
+			for ev := range out {
+				if err == nil {
+					err = enc.Encode(parsedEvent{ev.Timestamp, ev.Data})
+				}
+			}
+			written <- err
+		}()
+		err := parser.ParseEvents(obj, out)
+		close(out)
+		obj.Release()
+		if writeErr := <-written; writeErr != nil {
+			return unparseable, writeErr
+		}
+		if err != nil {
+			return unparseable, fmt.Errorf("Error parsing %s: %s", obj.Object, err)
+		}
+	}
+	return unparseable, nil
+}
@@ -0,0 +1,57 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+func TestParseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "alb.log")
+	lines := `h2 2017-07-31T20:30:57.975041Z app/spline-lb/1db0c9806095122a 10.11.12.13:47882 10.3.47.87:8080 0.000021 0.010962 -1 504 504 766 17 "PUT https://api.simulation.io:443/reticulate/spline/1 HTTP/1.1" "libhoney-go/1.3.3" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 groupARN "Root=1-5e71404d-84277a47a826ab3d2e844170" "ui-dogfood.honeycomb.io" "certARN" 0 2017-07-31T20:30:52.975041Z "forward" "-" "-" "10.11.12.13:80" "201"
+`
+	if err := ioutil.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser := NewALBEventParser(&options.Options{SampleRate: 1, SamplerType: "simple"})
+	var buf bytes.Buffer
+	if err := ParseFiles(&buf, parser, []string{path}); err != nil {
+		t.Fatal(err)
+	}
+	var ev parsedEvent
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("expected a line of JSON, got %q: %s", buf.String(), err)
+	}
+	if ev.Data["elb"] != "app/spline-lb/1db0c9806095122a" || ev.Data["elb_status_code"] != float64(504) {
+		t.Errorf("unexpected event: %v", ev.Data)
+	}
+	if ev.Timestamp.Format("2006-01-02T15:04:05.999999Z") != "2017-07-31T20:30:52.975041Z" {
+		t.Errorf("expected the request_creation_time as the time, got %v", ev.Timestamp)
+	}
+	// not shaped
+	if _, ok := ev.Data["request_path"]; ok {
+		t.Errorf("expected the event as parsed, got %v", ev.Data)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(lines+"not an ALB log line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := ParseFiles(&buf, parser, []string{path}); err == nil {
+		t.Error("expected an unparseable line to be an error")
+	}
+	if bytes.Count(buf.Bytes(), []byte("\n")) != 1 {
+		t.Errorf("expected the parseable line to still be written, got %q", buf.String())
+	}
+}
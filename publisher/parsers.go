@@ -0,0 +1,48 @@
+package publisher
+
+import (
+	"fmt"
+
+	"github.com/honeycombio/honeyaws/options"
+)
+
+// Log types understood by NewEventParser.
+const (
+	LogTypeELB        = "elb"
+	LogTypeALB        = "alb"
+	LogTypeNLB        = "nlb"
+	LogTypeCloudFront = "cloudfront"
+	LogTypeCloudTrail = "cloudtrail"
+	LogTypeFlowLogs   = "flowlogs"
+	LogTypeWAF        = "waf"
+	LogTypeAPIGateway = "apigateway"
+
+	LogTypeGlobalAccelerator = "globalaccelerator"
+)
+
+// NewEventParser returns the EventParser for the given log type, for callers
+// which only know what kind of logs they will be handling at runtime.
+func NewEventParser(opt *options.Options, logType string) (EventParser, error) {
+	switch logType {
+	case LogTypeELB:
+		return NewELBEventParser(opt), nil
+	case LogTypeALB:
+		return NewALBEventParser(opt), nil
+	case LogTypeNLB:
+		return NewNLBEventParser(opt), nil
+	case LogTypeCloudFront:
+		return NewCloudFrontEventParser(opt), nil
+	case LogTypeCloudTrail:
+		return NewCloudTrailEventParser(opt), nil
+	case LogTypeFlowLogs:
+		return NewFlowLogEventParser(opt), nil
+	case LogTypeWAF:
+		return NewWAFEventParser(opt), nil
+	case LogTypeAPIGateway:
+		return NewAPIGatewayEventParser(opt), nil
+	case LogTypeGlobalAccelerator:
+		return NewGlobalAcceleratorEventParser(opt), nil
+	default:
+		return nil, fmt.Errorf("unknown log type %q, supported types are: elb, alb, nlb, cloudfront, cloudtrail, flowlogs, waf, apigateway, globalaccelerator", logType)
+	}
+}
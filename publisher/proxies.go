@@ -0,0 +1,60 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// knownProxyField is set on events from clients which are known proxies,
+// e.g. a CDN in front of the load balancer, whose client IP is the proxy's
+// rather than that of whoever made the request.
+const knownProxyField = "client_is_known_proxy"
+
+// ProxyNets are the networks of known proxies, from --proxy_cidrs.
+type ProxyNets []*net.IPNet
+
+// ParseProxyNets parses CIDRs, e.g. 130.176.0.0/17, or single IPs.
+func ParseProxyNets(cidrs []string) (ProxyNets, error) {
+	var nets ProxyNets
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR or IP", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func (p ProxyNets) contains(ip net.IP) bool {
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// tag marks the event as being from a known proxy if its client IP is in
+// one of the networks.
+func (p ProxyNets) tag(data map[string]interface{}) {
+	if len(p) == 0 {
+		return
+	}
+	if ip := clientIP(data); ip != nil && p.contains(ip) {
+		data[knownProxyField] = true
+	}
+}
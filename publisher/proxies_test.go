@@ -0,0 +1,32 @@
+package publisher
+
+import "testing"
+
+func TestProxyNetsTag(t *testing.T) {
+	proxies, err := ParseProxyNets([]string{"130.176.0.0/17", " 2600:9000::/28", "10.1.2.3", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		data  map[string]interface{}
+		proxy bool
+	}{
+		{map[string]interface{}{"client_authority": "130.176.17.4:41234"}, true},
+		{map[string]interface{}{"client_authority": "[2600:9000:2001::1]:443"}, true},
+		{map[string]interface{}{"client_authority": "10.1.2.3:5000"}, true},
+		{map[string]interface{}{"c_ip": "130.176.200.1"}, false},
+		{map[string]interface{}{"client_authority": "10.1.2.4:5000"}, false},
+		{map[string]interface{}{"request": "GET / HTTP/1.1"}, false},
+	}
+	for _, c := range cases {
+		proxies.tag(c.data)
+		if _, ok := c.data[knownProxyField]; ok != c.proxy {
+			t.Errorf("%v: expected %s to be %v", c.data, knownProxyField, c.proxy)
+		}
+	}
+
+	if _, err := ParseProxyNets([]string{"130.176.0.0/33"}); err == nil {
+		t.Error("expected an invalid CIDR to be rejected")
+	}
+}
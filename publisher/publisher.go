@@ -1,24 +1,58 @@
 package publisher
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/honeycombio/honeyaws/crash"
+	"github.com/honeycombio/honeyaws/health"
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/metrics"
 	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/retry"
 	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeyaws/tracing"
 	"github.com/honeycombio/honeytail/event"
 	"github.com/honeycombio/libhoney-go"
-	"github.com/honeycombio/urlshaper"
+	"github.com/honeycombio/libhoney-go/transmission"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	AWSApplicationLoadBalancerFormat = "aws_alb"
 	AWSElasticLoadBalancerFormat     = "aws_elb"
 	AWSCloudFrontWebFormat           = "aws_cf_web"
+	AWSNetworkLoadBalancerFormat     = "aws_nlb"
+
+	AWSApplicationLoadBalancerConnectionFormat = "aws_alb_conn"
+)
+
+// An object taking longer than this to publish means the pipeline is wedged.
+const publishTimeout = 10 * time.Minute
+
+// shutdownPoll is how often Shutdown checks whether the objects being
+// published have finished.
+const shutdownPoll = 100 * time.Millisecond
+
+// flushPoll is how often Flush checks whether the events parsed have all been
+// sent or dropped.
+const flushPoll = 10 * time.Millisecond
+
+// How trace IDs are written in events, for --trace_id_format.
+const (
+	traceIDFormatXRay = "xray"
+	traceIDFormatW3C  = "w3c"
 )
 
 var (
@@ -30,17 +64,29 @@ var (
 	//
 	// Example CloudFront log format (aws_cf_web):
 	// 2014-05-23 01:13:11 FRA2 182 192.0.2.10 GET d111111abcdef8.cloudfront.net /view/my/file.html 200 www.displaymyfiles.com Mozilla/4.0%20(compatible;%20MSIE%205.0b1;%20Mac_PowerPC) - zip=98101 RefreshHit MRVMF7KydIvxMWfJIglgwHQwZsbG2IhRJ07sn9AkKUFSHS9EXAMPLE== d111111abcdef8.cloudfront.net http - 0.001 - - - RefreshHit HTTP/1.1
+	//
+	// Example NLB log format (aws_nlb):
+	// tls 2.0 2018-12-20T02:59:40 net/my-network-loadbalancer/c6e77e28c25b2234 g3d4b5e8bb8464cd 72.21.218.154:51341 172.100.100.185:443 5 2 98 246 - arn:aws:acm:us-east-2:671290407336:certificate/2a108f19-aded-46b0-8493-c63eb1ef4a99 - ECDHE-RSA-AES128-SHA tlsv12 - my-network-loadbalancer-c6e77e28c25b2234.elb.us-east-2.amazonaws.com h2 h2 "h2","http/1.1" 2020-04-01T08:51:42
+	//
+	// Example ALB connection log format (aws_alb_conn):
+	// 2023-10-04T17:59:44.588150Z 203.0.113.1 36280 443 TLSv1.2 ECDHE-RSA-AES128-GCM-SHA256 4.036 "CN=client.example.com,O=Example,C=US" NotBefore=2023-09-21T22:43:21Z;NotAfter=2026-06-17T22:43:21Z FEF257753A94ECBF Success TID_1ec4033e7f5c6e4c87dbc357ba6f1c7e
 
 	logFormat = []byte(fmt.Sprintf(
 		`log_format %s '$timestamp $elb $client_authority $backend_authority $request_processing_time $backend_processing_time $response_processing_time $elb_status_code $backend_status_code $received_bytes $sent_bytes "$request" "$user_agent" $ssl_cipher $ssl_protocol';
 log_format %s '$timestamp $x_edge_location $sc_bytes $c_ip $cs_method $cs_host $cs_uri_stem $sc_status $cs_referer $cs_user_agent $cs_uri_query $cs_cookie $x_edge_result_type $x_edge_request_id $x_host_header $cs_protocol $cs_bytes $time_taken $x_forwarded_for $ssl_protocol $ssl_cipher $x_edge_response_result_type $cs_protocol_version';
-log_format %s '$type $response_time $elb $client_authority $backend_authority $request_processing_time $backend_processing_time $response_processing_time $elb_status_code $backend_status_code $received_bytes $sent_bytes "$request" "$user_agent" $ssl_cipher $ssl_protocol $target_group_arn "$trace_id" "$domain_name" "$chosen_cert_arn" $matched_rule_priority $timestamp';`,
+log_format %s '$type $version $timestamp $elb $listener $client_authority $destination_authority $connection_time $tls_handshake_time $received_bytes $sent_bytes $incoming_tls_alert $chosen_cert_arn $chosen_cert_serial $tls_cipher $tls_protocol_version $tls_named_group $domain_name $alpn_fe_protocol $alpn_be_protocol $alpn_client_preference_list $tls_connection_creation_time';
+log_format %s '$type $response_time $elb $client_authority $backend_authority $request_processing_time $backend_processing_time $response_processing_time $elb_status_code $backend_status_code $received_bytes $sent_bytes "$request" "$user_agent" $ssl_cipher $ssl_protocol $target_group_arn "$trace_id" "$domain_name" "$chosen_cert_arn" $matched_rule_priority $timestamp';
+log_format %s '$timestamp $client_ip $client_port $listener_port $tls_protocol $tls_cipher $tls_handshake_latency "$leaf_client_cert_subject" $leaf_client_cert_validity $leaf_client_cert_serial_number $tls_verify_status $conn_trace_id';`,
 		AWSElasticLoadBalancerFormat,
 		AWSCloudFrontWebFormat,
+		AWSNetworkLoadBalancerFormat,
 		AWSApplicationLoadBalancerFormat,
+		AWSApplicationLoadBalancerConnectionFormat,
 	))
 	libhoneyInitialized = false
 	formatFileName      string
+	failover            *writeKeyFailover
+	dryRun              *dryRunSender
 )
 
 func init() {
@@ -92,6 +138,49 @@ type HoneycombPublisher struct {
 	SampleRate          int
 	FinishedObjects     chan string
 	parsedCh, sampledCh chan event.Event
+	sent                chan struct{}
+
+	// Enricher, if set, adds metadata about the targets of the requests
+	// to the events parsed from each object.
+	Enricher *TargetEnricher
+
+	// AuthEnricher, if set, adds the identity providers of the ALB
+	// authenticate actions which ran for the requests.
+	AuthEnricher *AuthEnricher
+
+	// Catalog, if set, adds metadata about the service behind each
+	// load balancer or target group to its events.
+	Catalog *ServiceCatalog
+
+	// DeadLetters, if set, writes out the lines of each object which
+	// couldn't be parsed.
+	DeadLetters *DeadLetterWriter
+
+	// Markers, if set, creates Honeycomb markers on ingest milestones.
+	Markers *Markers
+	// ReplayMarkers, if set, marks the replay of a --start-time range.
+	ReplayMarkers *Markers
+
+	// Audit, if set, sends an event about each object published.
+	Audit *AuditLog
+
+	// rollups, if set, aggregates the parsed events, see
+	// --rollup_interval.
+	rollups *rollups
+
+	// rules are the rules for the events which Reload replaces.
+	rules *reloadableRules
+
+	// exactlyOnce, for --exactly_once, has the events of each object
+	// confirmed, if the Stater is a Confirmer.
+	exactlyOnce bool
+	confirming  sync.WaitGroup
+
+	// publishing has the objects being published, since several can be
+	// published at once.
+	publishLock  sync.Mutex
+	publishing   map[*publishingObject]bool
+	shuttingDown bool
 }
 
 func NewHoneycombPublisher(opt *options.Options, stater state.Stater, eventParser EventParser) *HoneycombPublisher {
@@ -99,30 +188,278 @@ func NewHoneycombPublisher(opt *options.Options, stater state.Stater, eventParse
 		Stater:          stater,
 		EventParser:     eventParser,
 		FinishedObjects: make(chan string),
+		sent:            make(chan struct{}),
+		publishing:      make(map[*publishingObject]bool),
+		exactlyOnce:     opt.ExactlyOnce,
 	}
 
 	if !libhoneyInitialized {
 		hnyCfg := libhoney.Config{
-			MaxBatchSize:  500,
-			SendFrequency: 100 * time.Millisecond,
-			WriteKey:      opt.WriteKey,
-			Dataset:       opt.Dataset,
-			SampleRate:    uint(opt.SampleRate),
-			APIHost:       opt.APIHost,
-		}
-		libhoney.Init(hnyCfg)
+			MaxBatchSize:         uint(opt.BatchSize),
+			SendFrequency:        time.Duration(opt.BatchTimeout) * time.Millisecond,
+			MaxConcurrentBatches: uint(opt.ConcurrentBatches),
+			PendingWorkCapacity:  uint(opt.PendingWork),
+			WriteKey:             opt.WriteKey,
+			Dataset:              opt.Dataset,
+			SampleRate:           uint(opt.SampleRate),
+			APIHost:              opt.APIHost,
+			// every response settles an event, with
+			// --exactly_once
+			BlockOnResponse: opt.ExactlyOnce,
+		}
+		transport, err := newTransport(opt.HTTPProxy)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("Could not use --http_proxy")
+		}
+		hnyCfg.Transport = transport
+		honeycombTransport = transport
+		destinations = newDestinations(opt.DatasetConfigs)
+		probe, err := newDryRunProbe(opt)
+		if err != nil {
+			logrus.WithField("error", err).Fatal("Could not use --dry-run-probe")
+		}
+		if opt.DryRun {
+			dryRun = newDryRunSender(os.Stdout, opt.DryRunSamples)
+			dryRun.probe = probe
+			hnyCfg.Transmission = dryRun
+		} else if opt.Output == options.OutputOTLP {
+			sender, err := newOTLPSender(opt)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("Could not set up --output=otlp")
+			}
+			hnyCfg.Transmission = sender
+		} else if opt.SpoolDir != "" {
+			c, err := newCodec(opt.SpoolCodec, opt.SpoolLevel)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("Could not use --spool_codec")
+			}
+			hnyCfg.Transmission = newSpoolSender(opt.SpoolDir, c)
+		}
+		if len(opt.Sinks) > 0 && !opt.DryRun {
+			sender, err := newSinks(opt, hnyCfg)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("Could not use --sink")
+			}
+			hnyCfg.Transmission = sender
+		}
+		if err := libhoney.Init(hnyCfg); err != nil {
+			logrus.WithField("error", err).Fatal("Could not initialize libhoney")
+		}
 		libhoneyInitialized = true
-		if _, err := libhoney.VerifyAPIKey(hnyCfg); err != nil {
-			logrus.Fatal("Could not validate write key Honeycomb. Please double check your write key and try again.")
+		eventRetry = retry.New(opt.MaxRetries)
+
+		if opt.FallbackWriteKey != "" {
+			failover = &writeKeyFailover{
+				writeKey:  opt.FallbackWriteKey,
+				apiHost:   opt.FallbackAPIHost,
+				threshold: opt.FallbackAfter,
+			}
 		}
+		go watchResponses(libhoney.TxResponses())
+
+		if opt.DryRun || opt.Output == options.OutputOTLP || !opt.HoneycombSink() {
+			// Nothing is sent to Honeycomb's events API, so
+			// there's no write key to verify.
+		} else if opt.SpoolDir != "" {
+			// Honeycomb may well not be reachable from here, so
+			// don't try to verify the write key.
+			health.Ready("spool", func() error {
+				return checkSpoolDir(opt.SpoolDir)
+			})
+		} else {
+			health.Ready("honeycomb", func() error {
+				cfg := hnyCfg
+				if failover != nil && failover.active() {
+					cfg.WriteKey = failover.writeKey
+					if failover.apiHost != "" {
+						cfg.APIHost = failover.apiHost
+					}
+				}
+				_, err := libhoney.VerifyAPIKey(cfg)
+				return err
+			})
+
+			if _, err := libhoney.VerifyAPIKey(hnyCfg); err != nil {
+				if failover == nil {
+					logrus.Fatal("Could not validate write key Honeycomb. Please double check your write key and try again.")
+				}
+
+				fallbackCfg := hnyCfg
+				fallbackCfg.WriteKey = opt.FallbackWriteKey
+				if opt.FallbackAPIHost != "" {
+					fallbackCfg.APIHost = opt.FallbackAPIHost
+				}
+				if _, err := libhoney.VerifyAPIKey(fallbackCfg); err != nil {
+					logrus.Fatal("Could not validate the write key or the fallback write key with Honeycomb. Please double check your write keys and try again.")
+				}
+				failover.failOver()
+			}
+		}
+	}
+
+	health.Live("publisher", hp.checkPublishing)
+
+	datasets, err := ParseDatasetMap(opt.DatasetMap)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not parse --dataset_map")
 	}
 
+	// before any events are sent, for the columns not to be typed by them
+	NewDatasetSetup(opt).Run(datasetNames(opt, datasets))
+	hp.Markers = NewMarkers(opt, datasets)
+	hp.ReplayMarkers = NewReplayMarkers(opt, datasets)
+	hp.Audit = NewAuditLog(opt)
+
 	hp.parsedCh = make(chan event.Event)
 	hp.sampledCh = make(chan event.Event)
 
-	go sendEventsToHoneycomb(hp.sampledCh, opt.EdgeMode)
-	go hp.EventParser.DynSample(hp.parsedCh, hp.sampledCh)
+	// Parsed events are only counted when there's somewhere to report
+	// the count.
+	parsedCh := hp.parsedCh
+	if opt.MetricsAddr != "" {
+		parsedCh = make(chan event.Event)
+		go countEvents(hp.parsedCh, parsedCh)
+	}
+
+	// When heartbeats are enabled, latency is observed for every parsed
+	// event on the way to the sampler.
+	toSampleCh := parsedCh
+	if opt.HeartbeatInterval > 0 {
+		hb := newLatencyHeartbeat(opt.DurationUnit)
+		toSampleCh = make(chan event.Event)
+		go hb.observe(parsedCh, toSampleCh)
+		go hb.run(time.Duration(opt.HeartbeatInterval)*time.Second, datasets)
+	}
+
+	rules, err := loadLiveRules(opt)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not load the sample rates by status, field rules or --url_rules")
+	}
+	hp.rules = newReloadableRules(rules)
 
+	// Rollups are made from every parsed event too, and with
+	// --rollups_only they're all that's sent.
+	if opt.RollupsOnly && opt.RollupInterval <= 0 {
+		logrus.Fatal("--rollups_only requires --rollup_interval")
+	}
+	if opt.RollupInterval > 0 {
+		ru := newRollups(time.Duration(opt.RollupInterval)*time.Second, datasets)
+		for _, by := range opt.RollupBy {
+			switch by {
+			case "route":
+				ru.byRoute = true
+			case "status":
+				ru.byStatus = true
+			}
+		}
+		ru.setRules(rules)
+		ru.only = opt.RollupsOnly
+		rolledUpCh := make(chan event.Event)
+		go ru.observe(toSampleCh, rolledUpCh)
+		toSampleCh = rolledUpCh
+		go ru.run()
+		hp.rollups = ru
+	}
+
+	// With --start-time, the events from either side of the range in its
+	// objects are dropped before they're sampled.
+	start, end, err := opt.TimeRange(time.Now())
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not use --start-time")
+	}
+	if !start.IsZero() {
+		rangedCh := make(chan event.Event)
+		go keepTimeRange(toSampleCh, rangedCh, start, end)
+		toSampleCh = rangedCh
+	}
+
+	switch opt.Output {
+	case "", "honeycomb", options.OutputOTLP:
+	default:
+		logrus.WithField("output", opt.Output).Fatal("--output must be honeycomb or otlp")
+	}
+	switch opt.TraceIDFormat {
+	case "", traceIDFormatXRay, traceIDFormatW3C:
+	default:
+		logrus.WithField("format", opt.TraceIDFormat).Fatal("--trace_id_format must be xray or w3c")
+	}
+
+	proxies, err := ParseProxyNets(opt.ProxyCIDRs)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not parse --proxy_cidrs")
+	}
+	services, err := ParseServiceNamePatterns(opt.ServiceNameRegex)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not parse --service_name_regex")
+	}
+	extractors, err := ParseExtractRules(opt.ExtractRules)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not parse the extract rules of --config")
+	}
+	fanout, err := ParseFanout(opt.FanoutConfigs)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not parse the fanout destinations of --config")
+	}
+	static, err := StaticFields(opt.AddFields, opt.HostMetadata)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not parse --add-field")
+	}
+	// The events of a --start-time range can be told apart from those
+	// ingested of it before.
+	if opt.ReplayID != "" {
+		static["replay.id"] = opt.ReplayID
+	}
+	ipHandling, err := NewIPHandling(opt.IPHandling, opt.IPHashKey)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not use --ip-handling")
+	}
+	cardinality, err := NewCardinalityGuard(opt.CardinalityFields, opt.CardinalityLimit, opt.CardinalityAction)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not use --cardinality_fields")
+	}
+	budget, err := NewBudget(opt.EventsPerMinute, opt.BytesPerHour)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not use --max-events-per-minute or --max-bytes-per-hour")
+	}
+
+	hp.Catalog, err = LoadServiceCatalog(opt.ServiceCatalog)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("Could not load --service_catalog")
+	}
+	if hp.Catalog != nil && opt.CatalogRefresh > 0 {
+		go hp.Catalog.refresh(time.Duration(opt.CatalogRefresh) * time.Second)
+	}
+
+	// With --max-events-per-minute or --max-bytes-per-hour, the sampled
+	// events are sampled again to keep within the budget.
+	toSendCh := hp.sampledCh
+	if budget != nil {
+		budgetedCh := make(chan event.Event)
+		go budget.sample(hp.sampledCh, budgetedCh)
+		toSendCh = budgetedCh
+	}
+	go func() {
+		sendEventsToHoneycomb(toSendCh, opt, hp.rules, extractors, proxies, services, ipHandling, static, cardinality, datasets, fanout)
+		close(hp.sent)
+	}()
+	// The requests are shaped before sampling, so that the dynamic
+	// sampler can key on request_path and the like.
+	shapedCh := make(chan event.Event)
+	go shapeRequests(toSampleCh, shapedCh, opt, hp.rules)
+	toSampleCh = shapedCh
+	// Events with a status code given a sample rate by
+	// --sample-rate-by-status skip the dynamic sampler. They're looked
+	// for without it too, in case it's reloaded.
+	dynamicCh := make(chan event.Event)
+	go sampleByStatus(toSampleCh, dynamicCh, hp.sampledCh, hp.rules.statusRates)
+	toSampleCh = dynamicCh
+
+	go func() {
+		hp.EventParser.DynSample(toSampleCh, hp.sampledCh)
+		close(hp.sampledCh)
+	}()
+
+	crash.Watch(hp)
 	return hp
 }
 
@@ -226,42 +563,380 @@ func addTraceData(ev *event.Event, edgeMode bool) {
 	ev.Data["request.headers.x-amzn-trace-id"] = amznTraceID
 }
 
-func sendEventsToHoneycomb(in <-chan event.Event, edgeMode bool) {
-	shaper := requestShaper{&urlshaper.Parser{}}
+// w3cTraceID returns the X-Ray trace ID, e.g.
+// 1-5759e988-bd862e3fe1be46a994272793, in the W3C trace context format
+// OpenTelemetry uses, 5759e988bd862e3fe1be46a994272793, or "" if it isn't one.
+func w3cTraceID(id string) string {
+	parts := strings.Split(id, "-")
+	if len(parts) != 3 || parts[0] != "1" || len(parts[1]) != 8 || len(parts[2]) != 24 {
+		return ""
+	}
+	traceID := strings.ToLower(parts[1] + parts[2])
+	if _, err := hex.DecodeString(traceID); err != nil {
+		return ""
+	}
+	return traceID
+}
+
+// useW3CTraceIDs rewrites the trace ID parsed by addTraceData in the W3C
+// format, so that load balancer events join the traces of services which
+// pick up the X-Amzn-Trace-Id header with OpenTelemetry's X-Ray propagator.
+func useW3CTraceIDs(data map[string]interface{}) {
+	id, ok := data["trace.trace_id"].(string)
+	if !ok {
+		return
+	}
+	traceID := w3cTraceID(id)
+	if traceID == "" {
+		return
+	}
+	data["trace.trace_id"] = traceID
+	// the load balancer is the root span, with the trace's ID
+	if data["trace.span_id"] == id {
+		data["trace.span_id"] = traceID
+	}
+}
+
+// through runs events headed for out through the stage, returning the channel
+// to send them to instead, and a func to call once done sending, which waits
+// for the stage to pass along every event.
+func through(out chan<- event.Event, stage func(in <-chan event.Event, out chan<- event.Event)) (chan event.Event, func()) {
+	in := make(chan event.Event)
+	stageDone := make(chan struct{})
+	go func() {
+		stage(in, out)
+		close(stageDone)
+	}()
+	return in, func() {
+		close(in)
+		<-stageDone
+	}
+}
+
+// addFields adds the given fields to every event on the way through, leaving
+// any fields of the same name from the log line alone.
+func addFields(in <-chan event.Event, out chan<- event.Event, fields map[string]interface{}) {
+	for ev := range in {
+		for k, v := range fields {
+			if _, ok := ev.Data[k]; !ok {
+				ev.Data[k] = v
+			}
+		}
+		out <- ev
+	}
+}
+
+func countEvents(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		metrics.EventsParsed.Inc()
+		out <- ev
+	}
+	close(out)
+}
+
+// watchResponses keeps track of how Honeycomb responds to the events we send,
+// for metrics, to fail over to the fallback write key if need be, and to send
+// events again which might be accepted later.
+func watchResponses(responses chan transmission.Response) {
+	errs := newResponseErrors(responseErrorsInterval)
+	for resp := range responses {
+		if isQueueOverflow(resp) {
+			metrics.QueueOverflows.Inc()
+		} else {
+			metrics.ObserveResponse(resp.StatusCode, resp.Err)
+		}
+		errs.observe(resp, time.Now())
+		if failover != nil && failover.observe(resp) {
+			failover.alert(resp.StatusCode)
+		}
+		confirmResponse(resp, retryResponse(resp))
+	}
+}
+
+func sendEventsToHoneycomb(in <-chan event.Event, opt *options.Options, live *reloadableRules, extractors FieldExtractors, proxies ProxyNets, services ServiceNamePatterns, ipHandling *IPHandling, static map[string]interface{}, cardinality *CardinalityGuard, datasets map[string]string, fanout Fanout) {
+	window := newTimestampWindow(opt)
 	for ev := range in {
-		shaper.Shape("request", &ev)
+		confirm := takeConfirmation(ev.Data)
+		// the same rules throughout, even if they're reloaded
+		// meanwhile
+		current := live.load()
+		rules := current.urlRules
+		// from the URLs as the rules left them when the request
+		// was shaped
+		extractors.apply(ev.Data)
+		if opt.Fingerprint {
+			addFingerprint(ev.Data)
+		}
+		if opt.ParseUserAgent {
+			addUserAgentFields(ev.Data)
+		}
+		// before the client IP may be dropped
+		proxies.tag(ev.Data)
+		if opt.CostFields {
+			addCostFields(ev.Data)
+		}
+		services.infer(ev.Data)
+		if rules != nil {
+			rules.scrubClientIP(ev.Data)
+		}
+		ipHandling.apply(ev.Data)
+		window.check(&ev)
 		libhEv := libhoney.NewEvent()
 		libhEv.Timestamp = ev.Timestamp
 		libhEv.SampleRate = uint(ev.SampleRate)
+		if dataset := datasetFor(ev.Data, datasets); dataset != "" {
+			libhEv.Dataset = dataset
+		}
+		applyDestination(libhEv)
 		dropNegativeTimes(&ev)
-		addTraceData(&ev, edgeMode)
+		addTraceData(&ev, opt.EdgeMode)
+		if opt.TraceIDFormat == traceIDFormatW3C {
+			useW3CTraceIDs(ev.Data)
+		}
+		if opt.DurationUnit != "" {
+			normalizeDurations(ev.Data, opt.DurationUnit)
+		}
+		for _, field := range cardinality.apply(libhEv.Dataset, ev.Data) {
+			cardinality.warn(libhEv.Dataset, field)
+		}
+		for k, v := range static {
+			if _, ok := ev.Data[k]; !ok {
+				ev.Data[k] = v
+			}
+		}
+		current.fields.apply(ev.Data)
 		if err := libhEv.Add(ev.Data); err != nil {
 			logrus.WithFields(logrus.Fields{
 				"event": ev,
 				"error": err,
 			}).Error("Unexpected error adding data to libhoney event")
 		}
+		trackRetries(libhEv, ev.Data)
+		trackConfirmation(libhEv, confirm)
 		// sampling is handled by the nginx parser
 		if err := libhEv.SendPresampled(); err != nil {
 			logrus.WithFields(logrus.Fields{
 				"event": ev,
 				"error": err,
 			}).Error("Unexpected error event to libhoney send")
+			if confirm != nil {
+				confirm.settle(true)
+			}
+			atomic.AddInt64(&pendingEvents, -1)
+			continue
+		}
+		atomic.AddInt64(&pendingEvents, -1)
+		metrics.EventsSent.Inc()
+		fanout.send(libhEv, ev.Data)
+	}
+}
+
+// publishingObject is an object being published, since when, and how far
+// publishing it has got if that's being tracked.
+type publishingObject struct {
+	object  string
+	since   time.Time
+	tracker *offsetTracker
+}
+
+// startPublishing records that the object is being published, returning a
+// func to call once it's done, unless the publisher is shutting down.
+func (hp *HoneycombPublisher) startPublishing(obj state.DownloadedObject, tracker *offsetTracker) (func(), bool) {
+	hp.publishLock.Lock()
+	defer hp.publishLock.Unlock()
+	if hp.shuttingDown {
+		return nil, false
+	}
+	p := &publishingObject{object: obj.Object, since: time.Now(), tracker: tracker}
+	hp.publishing[p] = true
+	return func() {
+		hp.publishLock.Lock()
+		defer hp.publishLock.Unlock()
+		delete(hp.publishing, p)
+	}, true
+}
+
+// leaveUnfinished records an object handed over once shutting down as
+// unfinished, since it's already set as processed, so that it's resumed next
+// time instead.
+func (hp *HoneycombPublisher) leaveUnfinished(obj state.DownloadedObject) error {
+	obj.Release()
+	if hp.Stater == nil {
+		return fmt.Errorf("shutting down before publishing %s", obj.Object)
+	}
+	logrus.WithField("object", obj.Object).Info("Shutting down, leaving object to be resumed")
+	return hp.SetOffset(obj.Object, obj.Offset)
+}
+
+// InFlight returns the objects being published, and when each started.
+func (hp *HoneycombPublisher) InFlight() map[string]time.Time {
+	hp.publishLock.Lock()
+	defer hp.publishLock.Unlock()
+	inFlight := make(map[string]time.Time, len(hp.publishing))
+	for p := range hp.publishing {
+		inFlight[p.object] = p.since
+	}
+	return inFlight
+}
+
+// FlushState is for when the process is about to die: it sends the events
+// handed to libhoney so far, and records how far publishing each object has
+// got, so that they're resumed from there. Unlike Drain, events still on
+// their way through parsing and sampling are given up on, since the
+// publishers may still be parsing.
+func (hp *HoneycombPublisher) FlushState() {
+	libhoney.Flush()
+
+	hp.publishLock.Lock()
+	defer hp.publishLock.Unlock()
+	for p := range hp.publishing {
+		if p.tracker != nil {
+			p.tracker.flush()
+		}
+	}
+}
+
+// checkPublishing is the liveness check for the publisher.
+func (hp *HoneycombPublisher) checkPublishing() error {
+	hp.publishLock.Lock()
+	defer hp.publishLock.Unlock()
+	for p := range hp.publishing {
+		if time.Since(p.since) > publishTimeout {
+			return fmt.Errorf("publishing an object since %s", p.since.Format(time.RFC3339))
 		}
 	}
+	return nil
 }
 
-func (hp *HoneycombPublisher) Publish(downloadedObj state.DownloadedObject) error {
+func (hp *HoneycombPublisher) Publish(downloadedObj state.DownloadedObject) (err error) {
+	ctx := downloadedObj.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// this ends the object span started by the downloader, if any
+	defer trace.SpanFromContext(ctx).End()
+
+	ctx, publishSpan := tracing.Tracer().Start(ctx, "publish", trace.WithAttributes(
+		attribute.String("object.key", downloadedObj.Object),
+	))
+	defer publishSpan.End()
+
 	logrus.WithField("object", downloadedObj.Object).Debug("Parse events begin")
 
-	if err := hp.EventParser.ParseEvents(downloadedObj, hp.parsedCh); err != nil {
+	var tracker *offsetTracker
+	if hp.Stater != nil {
+		tracker = &offsetTracker{
+			stater:  hp.Stater,
+			object:  downloadedObj.Object,
+			resumed: downloadedObj.Resumed || downloadedObj.Offset > 0,
+		}
+		downloadedObj.Progress = tracker.progress
+	}
+	finish, ok := hp.startPublishing(downloadedObj, tracker)
+	if !ok {
+		return hp.leaveUnfinished(downloadedObj)
+	}
+	// With --exactly_once, the object is done with once Honeycomb has
+	// accepted its events, rather than once they're parsed. It's recorded
+	// as unfinished from the start, so that it's resumed if the process
+	// dies before then.
+	var confirm *confirmation
+	if confirmer, ok := hp.Stater.(state.Confirmer); ok && hp.exactlyOnce && tracker != nil {
+		confirm = newConfirmation(confirmer, tracker, &hp.confirming)
+		tracker.confirm = confirm
+		if !tracker.resumed {
+			tracker.record(0)
+			tracker.recorded = time.Now()
+		}
+	} else if tracker != nil {
+		defer tracker.done()
+	}
+	defer finish()
+	var unparseable *unparseableLines
+	if hp.DeadLetters != nil {
+		unparseable = &unparseableLines{object: downloadedObj.Object}
+		downloadedObj.Unparseable = unparseable.add
+	}
+	if downloadedObj.Offset > 0 {
+		logrus.WithFields(logrus.Fields{
+			"object": downloadedObj.Object,
+			"lines":  downloadedObj.Offset,
+		}).Info("Resuming object after the lines already published")
+	}
+	audit := hp.Audit.start(&downloadedObj)
+	// after the stages below have passed along the object's events
+	defer func() { hp.Audit.record(audit, err) }()
+
+	// last, for every event the stages below pass along to be counted
+	// before publishing the object is done
+	out, counted := through(hp.parsedCh, countPending)
+	defer counted()
+	if hp.Enricher != nil {
+		var done func()
+		out, done = through(out, hp.Enricher.enrichEvents)
+		defer done()
+	}
+	if hp.AuthEnricher != nil {
+		var done func()
+		out, done = through(out, hp.AuthEnricher.enrichEvents)
+		defer done()
+	}
+	if hp.Catalog != nil {
+		var done func()
+		out, done = through(out, hp.Catalog.annotateEvents)
+		defer done()
+	}
+	if len(downloadedObj.Fields) > 0 {
+		var done func()
+		out, done = through(out, func(in <-chan event.Event, out chan<- event.Event) {
+			addFields(in, out, downloadedObj.Fields)
+		})
+		defer done()
+	}
+	if audit != nil {
+		var done func()
+		out, done = through(out, audit.countEvents)
+		defer done()
+	}
+	// first, for progress through the object to be checkpointed in step
+	// with its events
+	parsed := false
+	if confirm != nil {
+		var done func()
+		out, done = through(out, confirm.addEvents)
+		defer func() {
+			done()
+			confirm.finish(parsed)
+		}()
+	}
+
+	_, parseSpan := tracing.Tracer().Start(ctx, "parse")
+	if err := hp.EventParser.ParseEvents(downloadedObj, out); err != nil {
+		parseSpan.RecordError(err)
+		parseSpan.SetStatus(codes.Error, err.Error())
+		parseSpan.End()
+		// The object was fine, reading it from S3 wasn't, so it's
+		// resumed from where it got to, as if the process had died.
+		var streamErr *logbucket.StreamError
+		if errors.As(err, &streamErr) && tracker != nil {
+			tracker.leave(downloadedObj.Offset)
+			return fmt.Errorf("%s, leaving object to be resumed", err)
+		}
+		metrics.ParseFailures.Inc()
 		return err
 	}
+	parseSpan.End()
+	parsed = true
+	hp.DeadLetters.record(unparseable)
 
 	logrus.WithField("object", downloadedObj.Object).Debug("Parse events end")
 
-	// Clean up the downloaded object.
+	// Clean up the downloaded object. Streamed objects have been closed
+	// by the parser.
 	// TODO: Should always be done?
+	if downloadedObj.Local || downloadedObj.Body != nil {
+		return nil
+	}
 	if err := os.Remove(downloadedObj.Filename); err != nil {
 		return fmt.Errorf("Error cleaning up downloaded object %s: %s", downloadedObj.Filename, err)
 	}
@@ -269,6 +944,99 @@ func (hp *HoneycombPublisher) Publish(downloadedObj state.DownloadedObject) erro
 	return nil
 }
 
+// PublishObjects publishes the objects sent on downloads, up to workers of
+// them at a time, until downloads is closed.
+func PublishObjects(p Publisher, downloads <-chan state.DownloadedObject, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for download := range downloads {
+				if err := publishRecovered(p, download); err != nil {
+					logrus.WithFields(logrus.Fields{
+						"object": download,
+						"error":  err,
+					}).Error("Cannot properly publish downloaded object")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// publishRecovered publishes the object, turning a panic into an error so
+// that an object tripping a bug in one of the parsers doesn't take down the
+// others being published.
+func publishRecovered(p Publisher, download state.DownloadedObject) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			download.Release()
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.Publish(download)
+}
+
+// Flush waits up to timeout for every event parsed so far to make its way
+// through sampling, and flushes them to Honeycomb, like Drain, but leaves the
+// publisher to publish more objects afterwards, e.g. for the next invocation
+// of a Lambda function. It returns whether every event made it through in
+// time.
+func (hp *HoneycombPublisher) Flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&pendingEvents) > 0 && time.Now().Before(deadline) {
+		time.Sleep(flushPoll)
+	}
+	done := atomic.LoadInt64(&pendingEvents) <= 0
+	if !done {
+		logrus.WithField("events", atomic.LoadInt64(&pendingEvents)).Warn("Events still being sampled, flushing those sent so far")
+	}
+	libhoney.Flush()
+	return done
+}
+
+// Drain stops the publisher from accepting any more objects, waits for every
+// event parsed so far to make its way through sampling, and flushes them to
+// Honeycomb. Unlike Close, libhoney can still be used afterwards, e.g. by a
+// new publisher.
+func (hp *HoneycombPublisher) Drain() {
+	close(hp.parsedCh)
+	<-hp.sent
+	if hp.rollups != nil {
+		hp.rollups.send()
+	}
+	libhoney.Flush()
+	awaitConfirmations(&hp.confirming, confirmTimeout)
+}
+
+// Shutdown is for exiting gracefully: it stops any more objects from being
+// published, waits up to timeout for those being published to finish, and
+// drains the publisher if they do. Otherwise it flushes what it can, and how
+// far publishing each unfinished object got, so that they're resumed from
+// there. It returns whether every object finished.
+func (hp *HoneycombPublisher) Shutdown(timeout time.Duration) bool {
+	hp.publishLock.Lock()
+	hp.shuttingDown = true
+	hp.publishLock.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for len(hp.InFlight()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(shutdownPoll)
+	}
+	if inFlight := hp.InFlight(); len(inFlight) > 0 {
+		logrus.WithField("objects", len(inFlight)).Warn("Objects still being published, they'll be resumed where they got to")
+		hp.FlushState()
+		return false
+	}
+	hp.Drain()
+	return true
+}
+
 // Close flushes outstanding sends
 func (hp *HoneycombPublisher) Close() {
 	libhoney.Close()
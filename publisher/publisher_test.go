@@ -1,11 +1,20 @@
 package publisher
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/honeycombio/honeyaws/state"
 	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/urlshaper"
 )
 
 func TestParseTraceData(t *testing.T) {
@@ -92,6 +101,36 @@ func TestParseTraceData(t *testing.T) {
 	}
 }
 
+func TestUseW3CTraceIDs(t *testing.T) {
+	cases := []struct {
+		data, expected map[string]interface{}
+	}{
+		{
+			map[string]interface{}{"trace.trace_id": "1-5759e988-bd862e3fe1be46a994272793", "trace.span_id": "1-67891234-12456789abcdef012345678", "trace.parent_id": "53995c3f42cd8ad8"},
+			map[string]interface{}{"trace.trace_id": "5759e988bd862e3fe1be46a994272793", "trace.span_id": "1-67891234-12456789abcdef012345678", "trace.parent_id": "53995c3f42cd8ad8"},
+		},
+		// the root span's ID is the trace's
+		{
+			map[string]interface{}{"trace.trace_id": "1-5A88ECED-40876CE050D010360BFB23BD", "trace.span_id": "1-5A88ECED-40876CE050D010360BFB23BD"},
+			map[string]interface{}{"trace.trace_id": "5a88eced40876ce050d010360bfb23bd", "trace.span_id": "5a88eced40876ce050d010360bfb23bd"},
+		},
+		{
+			map[string]interface{}{"trace.trace_id": "not-an-xray-id"},
+			map[string]interface{}{"trace.trace_id": "not-an-xray-id"},
+		},
+		{
+			map[string]interface{}{"elb": "app/foo-alb/1db0c9806095122a"},
+			map[string]interface{}{"elb": "app/foo-alb/1db0c9806095122a"},
+		},
+	}
+	for _, c := range cases {
+		useW3CTraceIDs(c.data)
+		if !reflect.DeepEqual(c.data, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, c.data)
+		}
+	}
+}
+
 func TestParseTraceDataEdgeMode(t *testing.T) {
 	testCases := []struct {
 		ev       event.Event
@@ -222,3 +261,187 @@ func TestDropNegativeTimes(t *testing.T) {
 		}
 	}
 }
+
+func TestAddFields(t *testing.T) {
+	in := make(chan event.Event, 2)
+	out := make(chan event.Event, 2)
+
+	in <- event.Event{Data: map[string]interface{}{"elb": "foo"}}
+	in <- event.Event{Data: map[string]interface{}{"elb": "bar", "aws_region": "us-west-2"}}
+	close(in)
+
+	addFields(in, out, map[string]interface{}{"aws_region": "eu-west-1"})
+
+	expected := []map[string]interface{}{
+		{"elb": "foo", "aws_region": "eu-west-1"},
+		{"elb": "bar", "aws_region": "us-west-2"},
+	}
+	for _, exp := range expected {
+		ev := <-out
+		if !reflect.DeepEqual(ev.Data, exp) {
+			t.Fatalf("Output did not match expected: got %v, want %v", ev.Data, exp)
+		}
+	}
+}
+
+func TestRequestShaperNoShape(t *testing.T) {
+	shaper := requestShaper{pr: &urlshaper.Parser{}, noShape: true}
+	ev := event.Event{Data: map[string]interface{}{
+		"request": "GET https://api.simulation.io:443/reticulate/spline/1?force=true HTTP/1.1",
+	}}
+	shaper.Shape("request", &ev)
+	expected := map[string]interface{}{
+		"request":                  "GET https://api.simulation.io:443/reticulate/spline/1?force=true HTTP/1.1",
+		"request_method":           "GET",
+		"request_protocol_version": "HTTP/1.1",
+	}
+	if !reflect.DeepEqual(ev.Data, expected) {
+		t.Errorf("Output did not match expected: got %v, want %v", ev.Data, expected)
+	}
+}
+
+type concurrencyPublisher struct {
+	sync.Mutex
+	current, max, published int
+}
+
+func (p *concurrencyPublisher) Publish(obj state.DownloadedObject) error {
+	p.Lock()
+	p.current++
+	if p.current > p.max {
+		p.max = p.current
+	}
+	p.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	p.Lock()
+	p.current--
+	p.published++
+	p.Unlock()
+	return nil
+}
+
+func TestPublishObjects(t *testing.T) {
+	p := &concurrencyPublisher{}
+	downloads := make(chan state.DownloadedObject)
+	go func() {
+		for i := 0; i < 12; i++ {
+			downloads <- state.DownloadedObject{Object: fmt.Sprintf("object-%d", i)}
+		}
+		close(downloads)
+	}()
+
+	PublishObjects(p, downloads, 3)
+
+	if p.published != 12 {
+		t.Errorf("expected 12 objects to be published, got %d", p.published)
+	}
+	if p.max != 3 {
+		t.Errorf("expected 3 objects to be published at once, got %d", p.max)
+	}
+}
+
+type panickingPublisher struct {
+	published int
+}
+
+func (p *panickingPublisher) Publish(obj state.DownloadedObject) error {
+	if obj.Object == "bad" {
+		panic("bad log line")
+	}
+	p.published++
+	return nil
+}
+
+func TestPublishObjectsRecovers(t *testing.T) {
+	p := &panickingPublisher{}
+	downloads := make(chan state.DownloadedObject, 3)
+	downloads <- state.DownloadedObject{Object: "good"}
+	downloads <- state.DownloadedObject{Object: "bad"}
+	downloads <- state.DownloadedObject{Object: "good"}
+	close(downloads)
+
+	PublishObjects(p, downloads, 1)
+
+	if p.published != 2 {
+		t.Errorf("expected publishing to carry on past a panic, got %d objects published", p.published)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	hp := &HoneycombPublisher{Stater: stater, publishing: make(map[*publishingObject]bool)}
+	finish, ok := hp.startPublishing(state.DownloadedObject{Object: "slow.log.gz", Filename: "slow"}, nil)
+	if !ok {
+		t.Fatal("expected to start publishing before shutting down")
+	}
+	defer finish()
+
+	if hp.Shutdown(10 * time.Millisecond) {
+		t.Error("expected shutting down to time out with an object still being published")
+	}
+
+	// Objects handed over since are left to be resumed.
+	obj := state.DownloadedObject{Object: "late.log.gz", Filename: "late", Offset: 1000, Local: true}
+	if err := hp.Publish(obj); err != nil {
+		t.Fatal(err)
+	}
+	offsets, _ := stater.Offsets()
+	if offsets["late.log.gz"].Lines != 1000 {
+		t.Errorf("expected the late object to be left unfinished, got %v", offsets)
+	}
+}
+
+type droppedConn struct{}
+
+func (droppedConn) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestPublishStreamError(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	hp := &HoneycombPublisher{Stater: stater, EventParser: &ALBEventParser{}, publishing: make(map[*publishingObject]bool)}
+	obj := state.DownloadedObject{Object: "dropped.log.gz", Body: ioutil.NopCloser(droppedConn{}), Offset: 1000, Resumed: true}
+	if err := hp.Publish(obj); err == nil {
+		t.Fatal("expected an error publishing an object whose stream dropped")
+	}
+	offsets, _ := stater.Offsets()
+	if offset, ok := offsets["dropped.log.gz"]; !ok || offset.Lines != 1000 {
+		t.Errorf("expected the object to be left to be resumed where it got to, got %v", offsets)
+	}
+}
+
+// benchmarkParseEvents parses an object of copies of line with ep, reporting
+// the throughput of log parsed along with the allocations.
+func benchmarkParseEvents(b *testing.B, ep EventParser, line string) {
+	const lines = 10000
+	f, err := ioutil.TempFile("", "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(strings.Repeat(line+"\n", lines)); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	obj := state.DownloadedObject{Object: "bench.log", Filename: f.Name()}
+	out := make(chan event.Event)
+	go func() {
+		for range out {
+		}
+	}()
+	defer close(out)
+
+	b.SetBytes(int64(lines * (len(line) + 1)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ep.ParseEvents(obj, out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
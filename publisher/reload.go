@@ -0,0 +1,153 @@
+package publisher
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
+	"github.com/honeycombio/urlshaper"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadedOptions are the options Reload applies to a running publisher. The
+// rest only take effect on restart.
+var reloadedOptions = []string{
+	"samplerate",
+	"sampler_type",
+	"sampler_interval",
+	"sampler_decay",
+	"dynsample_keys",
+	"sample-rate-by-status",
+	"keep-fields",
+	"drop-fields",
+	"rename-field",
+	"url_rules",
+}
+
+// liveRules are the rules for the events on their way to Honeycomb which can
+// be reloaded while the publisher is running: the sample rates by status, the
+// fields kept, dropped and renamed, and the --url_rules shaping and scrubbing
+// them.
+type liveRules struct {
+	urlRules    *URLRules
+	shaper      *urlshaper.Parser
+	fields      *FieldFilter
+	statusRates *StatusSampleRates
+}
+
+func loadLiveRules(opt *options.Options) (*liveRules, error) {
+	urlRules, err := LoadURLRules(opt.URLRules)
+	if err != nil {
+		return nil, fmt.Errorf("--url_rules: %s", err)
+	}
+	fields, err := ParseFieldFilter(opt.KeepFields, opt.DropFields, opt.RenameFields)
+	if err != nil {
+		return nil, err
+	}
+	statusRates, err := ParseStatusSampleRates(opt.StatusRates)
+	if err != nil {
+		return nil, err
+	}
+	return &liveRules{urlRules: urlRules, shaper: urlRules.parser(), fields: fields, statusRates: statusRates}, nil
+}
+
+// reloadableRules has the liveRules in use, which are replaced all at once, so
+// that no event is sent with some of the old rules and some of the new.
+type reloadableRules struct {
+	value atomic.Value
+}
+
+func newReloadableRules(rules *liveRules) *reloadableRules {
+	r := &reloadableRules{}
+	r.store(rules)
+	return r
+}
+
+func (r *reloadableRules) load() *liveRules {
+	return r.value.Load().(*liveRules)
+}
+
+func (r *reloadableRules) store(rules *liveRules) {
+	r.value.Store(rules)
+}
+
+// statusRates returns the sample rates by status in use.
+func (r *reloadableRules) statusRates() *StatusSampleRates {
+	return r.load().statusRates
+}
+
+// dynamicSampling is the dynamic sampler of an EventParser, embedded in it for
+// the publisher to reload.
+type dynamicSampling struct {
+	sampler *sampler.Dynamic
+}
+
+func newDynamicSampling(opt *options.Options) dynamicSampling {
+	s, err := sampler.NewDynamic(opt)
+	if err != nil {
+		logrus.WithField("err", err).Fatal("couldn't build sampler from arguments")
+	}
+	return dynamicSampling{s}
+}
+
+func (s dynamicSampling) dynamicSampler() *sampler.Dynamic {
+	return s.sampler
+}
+
+// Reload applies the reloadedOptions to the running publisher, e.g. on SIGHUP,
+// reading the --url_rules file again. Events already sampled are sent with the
+// rules they were sampled under. On an error, nothing is changed.
+func (hp *HoneycombPublisher) Reload(opt *options.Options) error {
+	rules, err := loadLiveRules(opt)
+	if err != nil {
+		return err
+	}
+	if ds, ok := hp.EventParser.(interface{ dynamicSampler() *sampler.Dynamic }); ok {
+		if err := ds.dynamicSampler().Reload(opt); err != nil {
+			return fmt.Errorf("--sampler_type: %s", err)
+		}
+	}
+	hp.rules.store(rules)
+	if hp.rollups != nil {
+		hp.rollups.setRules(rules)
+	}
+	return nil
+}
+
+// ReloadConfig reloads the publisher with the options read again from the
+// --config file by options.ReloadConfig, copying the reloadedOptions over to
+// opt once they're applied. It returns the options among those changed which
+// only take effect on restart.
+func (hp *HoneycombPublisher) ReloadConfig(opt, reloaded *options.Options, changed []string) ([]string, error) {
+	if err := hp.Reload(reloaded); err != nil {
+		return nil, err
+	}
+	opt.SampleRate = reloaded.SampleRate
+	opt.SamplerType = reloaded.SamplerType
+	opt.SamplerInterval = reloaded.SamplerInterval
+	opt.SamplerDecay = reloaded.SamplerDecay
+	opt.DynSampleKeys = reloaded.DynSampleKeys
+	opt.StatusRates = reloaded.StatusRates
+	opt.KeepFields = reloaded.KeepFields
+	opt.DropFields = reloaded.DropFields
+	opt.RenameFields = reloaded.RenameFields
+	opt.URLRules = reloaded.URLRules
+
+	var restart []string
+	for _, name := range changed {
+		if !reloadedOption(name) {
+			restart = append(restart, name)
+		}
+	}
+	return restart, nil
+}
+
+func reloadedOption(name string) bool {
+	for _, reloaded := range reloadedOptions {
+		if name == reloaded {
+			return true
+		}
+	}
+	return false
+}
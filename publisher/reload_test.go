@@ -0,0 +1,124 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	opt := &options.Options{SamplerType: "simple", SamplerInterval: 300, SampleRate: 1, URLRules: f.Name()}
+	rules, err := loadLiveRules(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := &HoneycombPublisher{
+		EventParser: NewALBEventParser(opt),
+		rules:       newReloadableRules(rules),
+		rollups:     newRollups(time.Minute, nil),
+	}
+	hp.rollups.byRoute = true
+	hp.rollups.setRules(rules)
+
+	if err := ioutil.WriteFile(f.Name(), []byte("path_patterns: [/users/:id]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := *opt
+	reloaded.StatusRates = "5xx=1"
+	reloaded.DropFields = []string{"user_agent"}
+	reloaded.DynSampleKeys = []string{"elb_status_code"}
+	if err := hp.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+
+	current := hp.rules.load()
+	if rate, ok := current.statusRates.rateFor(map[string]interface{}{"elb_status_code": int64(503)}); !ok || rate != 1 {
+		t.Error("expected the reloaded sample rates by status")
+	}
+	data := map[string]interface{}{"user_agent": "curl", "elb": "lb"}
+	current.fields.apply(data)
+	if _, ok := data["user_agent"]; ok {
+		t.Error("expected the reloaded fields to be dropped")
+	}
+	if route := hp.rollups.route(map[string]interface{}{"request": "GET /users/42 HTTP/1.1"}); route != "/users/:id" {
+		t.Errorf("expected the rollups to shape routes with the reloaded --url_rules, got %q", route)
+	}
+	if keys := hp.EventParser.(*ALBEventParser).sampler.Keys(); !reflect.DeepEqual(keys, []string{"elb_status_code"}) {
+		t.Errorf("expected the reloaded sampler keys, got %v", keys)
+	}
+
+	// nothing changes if any of it is invalid
+	invalid := reloaded
+	invalid.StatusRates = ""
+	invalid.RenameFields = []string{"user_agent"}
+	if err := hp.Reload(&invalid); err == nil {
+		t.Error("expected an invalid --rename-field to be an error")
+	}
+	invalid = reloaded
+	invalid.StatusRates = ""
+	invalid.SamplerType = "unknown"
+	if err := hp.Reload(&invalid); err == nil {
+		t.Error("expected an unknown --sampler_type to be an error")
+	}
+	if hp.rules.load() != current {
+		t.Error("expected the rules to be left as they were")
+	}
+}
+
+func TestSampleByStatusReloaded(t *testing.T) {
+	live := newReloadableRules(&liveRules{})
+	in := make(chan event.Event)
+	dynamic := make(chan event.Event, 2)
+	out := make(chan event.Event, 2)
+	go sampleByStatus(in, dynamic, out, live.statusRates)
+
+	in <- event.Event{Data: map[string]interface{}{"elb_status_code": int64(502)}}
+	// without sample rates by status, it's sampled dynamically
+	<-dynamic
+
+	rates, err := ParseStatusSampleRates("5xx=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	live.store(&liveRules{statusRates: rates})
+	in <- event.Event{Data: map[string]interface{}{"elb_status_code": int64(502)}}
+	close(in)
+	if ev := <-out; ev.SampleRate != 1 {
+		t.Errorf("expected the event after the reload to be sampled by status, got %d", ev.SampleRate)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	opt := &options.Options{SamplerType: "simple", SamplerInterval: 300, SampleRate: 1}
+	rules, err := loadLiveRules(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := &HoneycombPublisher{EventParser: NewELBEventParser(opt), rules: newReloadableRules(rules)}
+
+	reloaded := *opt
+	reloaded.SampleRate = 10
+	reloaded.ParseWorkers = 8
+	restart, err := hp.ReloadConfig(opt, &reloaded, []string{"samplerate", "parse_workers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restart, []string{"parse_workers"}) {
+		t.Errorf("expected just parse_workers to need a restart, got %v", restart)
+	}
+	if opt.SampleRate != 10 || opt.ParseWorkers != 0 {
+		t.Errorf("expected just the reloaded options to be copied over, got %+v", opt)
+	}
+}
@@ -0,0 +1,266 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/sirupsen/logrus"
+)
+
+// replayedSuffix is added to the dead letters being replayed, which are moved
+// aside so that the lines which still can't be parsed are written out again
+// in their place.
+const replayedSuffix = ".replayed"
+
+// ReplayLines publishes the lines written out to --dead-letter-path, a local
+// file or an s3://bucket/prefix URL, with p again, e.g. once the parser has
+// been fixed to handle them. Each object's lines are published as an object of
+// their own, up to workers of them at a time. The dead letters are moved aside
+// to <name>.replayed first, so that once the lines which still can't be
+// parsed are written out again, they're all that's left. It returns how many
+// objects' lines were replayed, and how many of those failed to be.
+func ReplayLines(p Publisher, sess *session.Session, path string, workers int) (int, int, error) {
+	w, err := NewDeadLetterWriter(sess, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var objs []state.DownloadedObject
+	if w.file != "" {
+		objs, err = replayFile(w.file)
+	} else {
+		objs, err = replayPrefix(s3.New(sess), w.bucket, w.prefix)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	failed := replayAll(len(objs), workers, func(i int) error {
+		err := publishRecovered(p, objs[i])
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"object": objs[i].Object,
+				"error":  err,
+			}).Error("Could not replay the object's unparseable lines")
+		}
+		return err
+	})
+	return len(objs), failed, nil
+}
+
+// replayFile moves the local dead letter file aside and reads the lines in it.
+func replayFile(file string) ([]state.DownloadedObject, error) {
+	replayed := file + replayedSuffix
+	if err := os.Rename(file, replayed); err != nil {
+		return nil, fmt.Errorf("Could not move the dead letters aside to replay them: %s", err)
+	}
+	f, err := os.Open(replayed)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readDeadLetters(f)
+}
+
+// replayPrefix reads the lines in each object's dead letter under the prefix,
+// moving each aside once it's been read.
+func replayPrefix(svc s3iface.S3API, bucket, prefix string) ([]state.DownloadedObject, error) {
+	var keys []string
+	if err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(*obj.Key, ".jsonl") {
+				keys = append(keys, *obj.Key)
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("Could not list the dead letters: %s", err)
+	}
+
+	var objs []state.DownloadedObject
+	for _, key := range keys {
+		resp, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Could not download the dead letters in %s: %s", key, err)
+		}
+		read, err := readDeadLetters(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		if err := moveAside(svc, bucket, key); err != nil {
+			return nil, fmt.Errorf("Could not move the dead letters in %s aside to replay them: %s", key, err)
+		}
+		objs = append(objs, read...)
+	}
+	return objs, nil
+}
+
+func moveAside(svc s3iface.S3API, bucket, key string) error {
+	source := (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+	if _, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key + replayedSuffix),
+		CopySource: aws.String(source),
+	}); err != nil {
+		return err
+	}
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// readDeadLetters reads dead letter lines, returning an object for each
+// object they're from, whose body is its lines in order. A line written out
+// more than once, by replaying it before, is only replayed once.
+func readDeadLetters(r io.Reader) ([]state.DownloadedObject, error) {
+	var objects []string
+	lines := make(map[string]map[int64]string)
+	dec := json.NewDecoder(r)
+	for {
+		var l deadLetterLine
+		if err := dec.Decode(&l); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Could not read the dead letters: %s", err)
+		}
+		if lines[l.Object] == nil {
+			lines[l.Object] = make(map[int64]string)
+			objects = append(objects, l.Object)
+		}
+		lines[l.Object][l.Line] = l.Text
+	}
+
+	objs := make([]state.DownloadedObject, 0, len(objects))
+	for _, object := range objects {
+		numbers := make([]int64, 0, len(lines[object]))
+		for n := range lines[object] {
+			numbers = append(numbers, n)
+		}
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+		var body strings.Builder
+		for _, n := range numbers {
+			body.WriteString(lines[object][n])
+			body.WriteByte('\n')
+		}
+		objs = append(objs, state.DownloadedObject{
+			Object: object,
+			Body:   ioutil.NopCloser(strings.NewReader(body.String())),
+			Local:  true,
+		})
+	}
+	return objs, nil
+}
+
+// ReplayObjects downloads the objects the stater recorded as dead letters,
+// those which match, and publishes them with p again, oldest first and up to
+// workers at a time, e.g. once an outage which kept them from being
+// downloaded however many times they were retried is over. The objects
+// replayed are no longer dead letters, while those which fail again stay
+// dead letters, with one more attempt and the new error. It returns how many
+// were replayed, and how many of those failed to be.
+func ReplayObjects(p Publisher, d state.DeadLetterer, match state.Matcher, download func(object string) (state.DownloadedObject, error), workers int) (int, int, error) {
+	letters, err := d.DeadLetters()
+	if err != nil {
+		return 0, 0, err
+	}
+	var objects []string
+	for object, letter := range letters {
+		if match(object, letter.Time) {
+			objects = append(objects, object)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return letters[objects[i]].Time.Before(letters[objects[j]].Time)
+	})
+
+	failed := replayAll(len(objects), workers, func(i int) error {
+		return replayObject(p, d, objects[i], letters[objects[i]], download)
+	})
+	return len(objects), failed, nil
+}
+
+func replayObject(p Publisher, d state.DeadLetterer, object string, letter state.DeadLetter, download func(object string) (state.DownloadedObject, error)) error {
+	obj, err := download(object)
+	if err == nil {
+		err = publishRecovered(p, obj)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"object": object,
+			"error":  err,
+		}).Error("Could not replay the object")
+		letter.Attempts++
+		letter.Error = err.Error()
+		letter.Time = time.Now()
+		if err := d.SetDeadLetter(object, letter); err != nil {
+			logrus.WithField("error", err).Error("Could not record the dead letter")
+		}
+		return err
+	}
+
+	logrus.WithField("object", object).Info("Replayed object")
+	if err := d.ClearDeadLetter(object); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"object": object,
+			"error":  err,
+		}).Error("Could not clear the dead letter of the object replayed")
+	}
+	return nil
+}
+
+// replayAll calls replay with each of n dead letters, up to workers at a
+// time, returning how many failed.
+func replayAll(n, workers int, replay func(i int) error) int {
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			indexes <- i
+		}
+		close(indexes)
+	}()
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := replay(i); err != nil {
+					lock.Lock()
+					failed++
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return failed
+}
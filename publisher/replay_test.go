@@ -0,0 +1,119 @@
+package publisher
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+)
+
+// bodyPublisher keeps the body of each object published.
+type bodyPublisher struct {
+	sync.Mutex
+	bodies map[string]string
+}
+
+func (p *bodyPublisher) Publish(obj state.DownloadedObject) error {
+	body, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.bodies[obj.Object] = string(body)
+	return nil
+}
+
+func TestReplayLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead-letters.jsonl")
+
+	w := &DeadLetterWriter{file: path}
+	a := &unparseableLines{object: "AWSLogs/a.log.gz"}
+	a.add(7, "line seven", nil)
+	a.add(3, "line three", nil)
+	b := &unparseableLines{object: "AWSLogs/b.log.gz"}
+	b.add(1, "line one", nil)
+	// replayed before, and written out again
+	again := &unparseableLines{object: "AWSLogs/a.log.gz"}
+	again.add(3, "line three", nil)
+	for _, u := range []*unparseableLines{a, b, again} {
+		if err := w.write(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &bodyPublisher{bodies: make(map[string]string)}
+	replayed, failed, err := ReplayLines(p, nil, path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 2 || failed != 0 {
+		t.Errorf("expected both objects' lines to be replayed, got %d (%d failed)", replayed, failed)
+	}
+	if body := p.bodies["AWSLogs/a.log.gz"]; body != "line three\nline seven\n" {
+		t.Errorf("expected the object's lines in order, once each, got %q", body)
+	}
+	if body := p.bodies["AWSLogs/b.log.gz"]; body != "line one\n" {
+		t.Errorf("unexpected lines %q", body)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the dead letters to be moved aside")
+	}
+	if _, err := os.Stat(path + ".replayed"); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, err := ReplayLines(p, nil, filepath.Join(dir, "missing.jsonl"), 1); err == nil {
+		t.Error("expected replaying dead letters which aren't there to be an error")
+	}
+}
+
+func TestReplayObjects(t *testing.T) {
+	stater := state.NewMemoryStater(1)
+	recorded := time.Now().Add(-time.Hour)
+	for _, object := range []string{"AWSLogs/a.log.gz", "AWSLogs/b.log.gz", "AWSLogs/c.log.gz"} {
+		if err := stater.SetDeadLetter(object, state.DeadLetter{Error: "RequestTimeout", Attempts: 4, Time: recorded}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &concurrencyPublisher{}
+	download := func(object string) (state.DownloadedObject, error) {
+		if object == "AWSLogs/b.log.gz" {
+			return state.DownloadedObject{}, errors.New("NoSuchKey")
+		}
+		return state.DownloadedObject{Object: object}, nil
+	}
+	match := func(object string, _ time.Time) bool { return object != "AWSLogs/c.log.gz" }
+	replayed, failed, err := ReplayObjects(p, stater, match, download, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 2 || failed != 1 || p.published != 1 {
+		t.Errorf("expected the matching objects to be replayed, one successfully, got %d (%d failed, %d published)", replayed, failed, p.published)
+	}
+
+	letters, err := stater.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := letters["AWSLogs/a.log.gz"]; ok {
+		t.Error("expected the object replayed to no longer be a dead letter")
+	}
+	if letter := letters["AWSLogs/b.log.gz"]; letter.Attempts != 5 || letter.Error != "NoSuchKey" || !letter.Time.After(recorded) {
+		t.Errorf("expected the object which failed again to be recorded as such, got %+v", letter)
+	}
+	if letter := letters["AWSLogs/c.log.gz"]; letter.Attempts != 4 {
+		t.Errorf("expected the object which didn't match to be left alone, got %+v", letter)
+	}
+}
@@ -3,13 +3,39 @@ package publisher
 import (
 	"strings"
 
+	"github.com/honeycombio/honeyaws/options"
 	"github.com/honeycombio/honeytail/event"
 	"github.com/honeycombio/urlshaper"
 	"github.com/sirupsen/logrus"
 )
 
+// shapeRequests shapes the request of each event, scrubbing it with the URL
+// rules, before it's sampled, so that the fields shaped from it can be
+// sampled on.
+func shapeRequests(in <-chan event.Event, out chan<- event.Event, opt *options.Options, live *reloadableRules) {
+	for ev := range in {
+		current := live.load()
+		// from the request as logged, before anything rewrites it
+		if opt.CorrelationID {
+			addCorrelationID(&ev)
+		}
+		rules := current.urlRules
+		shaper := requestShaper{current.shaper, rules, opt.NoShaping}
+		shaper.Shape("request", &ev)
+		if rules != nil {
+			rules.scrubCloudFront(ev.Data)
+		}
+		out <- ev
+	}
+	close(out)
+}
+
 type requestShaper struct {
-	pr *urlshaper.Parser
+	pr    *urlshaper.Parser
+	rules *URLRules
+
+	// noShape skips parsing the path with urlshaper, see --no_shaping.
+	noShape bool
 }
 
 // Nicked directly from github.com/honeycombio/honeytail/leash.go
@@ -28,6 +54,21 @@ func (rs *requestShaper) Shape(field string, ev *event.Event) {
 			path = parts[0]
 		}
 
+		// scrub the URL before anything else sees it, including
+		// the original field
+		if rs.rules != nil {
+			path = rs.rules.URI(path)
+			if len(parts) == 3 {
+				ev.Data[field] = strings.Join([]string{parts[0], path, parts[2]}, " ")
+			} else {
+				ev.Data[field] = path
+			}
+		}
+
+		if rs.noShape {
+			return
+		}
+
 		// next up, get all the goodies out of the path
 		res, err := rs.pr.Parse(path)
 		if err != nil {
@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/sirupsen/logrus"
+)
+
+// responseErrorsInterval is how often the errors sending events are logged,
+// rather than once per event, which under backfill would be thousands of
+// lines a second.
+const responseErrorsInterval = 30 * time.Second
+
+// queueOverflow is the error libhoney responds with for events it drops
+// without sending, because --pending-work-capacity events are waiting.
+const queueOverflow = "queue overflow"
+
+func isQueueOverflow(resp transmission.Response) bool {
+	return resp.StatusCode == 0 && resp.Err != nil && resp.Err.Error() == queueOverflow
+}
+
+// responseErrors counts the errors sending events, by what went wrong, and
+// logs them: the first as soon as it happens, and the rest as a summary once
+// per interval.
+type responseErrors struct {
+	sync.Mutex
+	interval time.Duration
+	counts   map[string]int
+	logged   time.Time
+}
+
+func newResponseErrors(interval time.Duration) *responseErrors {
+	return &responseErrors{interval: interval, counts: make(map[string]int)}
+}
+
+// responseError describes what went wrong sending the event, or returns ""
+// if it was accepted.
+func responseError(resp transmission.Response) string {
+	switch {
+	case isQueueOverflow(resp):
+		return queueOverflow
+	case resp.Err != nil:
+		return resp.Err.Error()
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	return ""
+}
+
+func (e *responseErrors) observe(resp transmission.Response, now time.Time) {
+	if counts := e.add(resp, now); counts != nil {
+		fields := logrus.Fields{"errors": counts}
+		if counts[queueOverflow] > 0 {
+			fields["hint"] = "raise --pending-work-capacity or --max-concurrent-batches"
+		}
+		logrus.WithFields(fields).Warn("Honeycomb didn't accept some events")
+	}
+}
+
+// add counts the response's error, returning the counts to log if they're
+// due, and resetting them.
+func (e *responseErrors) add(resp transmission.Response, now time.Time) map[string]int {
+	e.Lock()
+	defer e.Unlock()
+	if err := responseError(resp); err != "" {
+		e.counts[err]++
+	}
+	if len(e.counts) == 0 || now.Sub(e.logged) < e.interval {
+		return nil
+	}
+	counts := e.counts
+	e.counts = make(map[string]int)
+	e.logged = now
+	return counts
+}
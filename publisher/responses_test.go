@@ -0,0 +1,44 @@
+package publisher
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func TestResponseErrors(t *testing.T) {
+	e := newResponseErrors(time.Minute)
+	start := time.Now()
+
+	if counts := e.add(transmission.Response{StatusCode: 202}, start); counts != nil {
+		t.Errorf("expected accepted events not to be logged, got %v", counts)
+	}
+	// the first error is logged straight away
+	counts := e.add(transmission.Response{Err: errors.New(queueOverflow)}, start)
+	if !reflect.DeepEqual(counts, map[string]int{queueOverflow: 1}) {
+		t.Errorf("expected the first error to be logged, got %v", counts)
+	}
+
+	// the rest are summed up for the next interval
+	e.add(transmission.Response{Err: errors.New(queueOverflow)}, start.Add(time.Second))
+	e.add(transmission.Response{StatusCode: 400}, start.Add(2*time.Second))
+	if counts := e.add(transmission.Response{StatusCode: 400}, start.Add(3*time.Second)); counts != nil {
+		t.Errorf("expected errors within the interval to wait, got %v", counts)
+	}
+	counts = e.add(transmission.Response{StatusCode: 202}, start.Add(time.Minute))
+	if !reflect.DeepEqual(counts, map[string]int{queueOverflow: 1, "status 400": 2}) {
+		t.Errorf("expected the summary of the interval's errors, got %v", counts)
+	}
+}
+
+func TestIsQueueOverflow(t *testing.T) {
+	if !isQueueOverflow(transmission.Response{Err: errors.New("queue overflow")}) {
+		t.Error("expected libhoney's queue overflow to be recognized")
+	}
+	if isQueueOverflow(transmission.Response{Err: errors.New("connection refused")}) {
+		t.Error("expected network errors not to be queue overflows")
+	}
+}
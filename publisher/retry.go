@@ -0,0 +1,108 @@
+package publisher
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/retry"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/sirupsen/logrus"
+)
+
+// eventRetry is how many times, and how far apart, events Honeycomb responds
+// to with a 429 or 5xx are sent again.
+var eventRetry retry.Policy
+
+// retryableEvent is what's needed to send an event again, kept in its
+// Metadata until Honeycomb has responded to it.
+type retryableEvent struct {
+	data       map[string]interface{}
+	timestamp  time.Time
+	dataset    string
+	sampleRate uint
+	attempts   int
+
+	// confirm is the event's place in its object, with --exactly_once.
+	confirm *confirmedEvent
+
+	// copyTo is where the event is a copy for, if it's from the fanout
+	// section of the --config file.
+	copyTo *destination
+}
+
+// isRetryable reports whether Honeycomb might accept the event if it's sent
+// again later: it's being rate limited, or had a server error.
+func isRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// trackRetries keeps what's needed to send the event again in its Metadata,
+// if events are retried.
+func trackRetries(ev *libhoney.Event, data map[string]interface{}) {
+	if eventRetry.Retries <= 0 {
+		return
+	}
+	ev.Metadata = &retryableEvent{
+		data:       data,
+		timestamp:  ev.Timestamp,
+		dataset:    ev.Dataset,
+		sampleRate: ev.SampleRate,
+		attempts:   1,
+	}
+}
+
+// retryResponse sends the event the response is for again after backing off
+// if it's worth retrying, returning whether it will be.
+func retryResponse(resp transmission.Response) bool {
+	ev, ok := resp.Metadata.(*retryableEvent)
+	if !ok || !isRetryable(resp.StatusCode) {
+		return false
+	}
+	if ev.attempts > eventRetry.Retries {
+		metrics.EventsFailed.Inc()
+		logrus.WithFields(logrus.Fields{
+			"status_code": resp.StatusCode,
+			"attempts":    ev.attempts,
+			"dataset":     ev.dataset,
+		}).Error("Giving up on sending event to Honeycomb")
+		return false
+	}
+	time.AfterFunc(eventRetry.Backoff(ev.attempts), ev.send)
+	return true
+}
+
+// send sends the event again, to the fallback write key if we've failed over
+// since.
+func (ev *retryableEvent) send() {
+	ev.attempts++
+	libhEv := libhoney.NewEvent()
+	libhEv.Timestamp = ev.timestamp
+	libhEv.Dataset = ev.dataset
+	libhEv.SampleRate = ev.sampleRate
+	libhEv.Metadata = ev
+	if ev.copyTo != nil {
+		ev.copyTo.apply(libhEv)
+	} else {
+		applyDestination(libhEv)
+	}
+	if err := libhEv.Add(ev.data); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error adding data to libhoney event")
+		ev.fail()
+		return
+	}
+	if err := libhEv.SendPresampled(); err != nil {
+		logrus.WithField("error", err).Error("Unexpected error sending event to libhoney")
+		ev.fail()
+		return
+	}
+	metrics.EventsRetried.Inc()
+}
+
+// fail settles the event as failed, since there'll be no response to it.
+func (ev *retryableEvent) fail() {
+	if ev.confirm != nil {
+		ev.confirm.settle(true)
+	}
+}
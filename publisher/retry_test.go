@@ -0,0 +1,57 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/retry"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func TestRetryResponse(t *testing.T) {
+	defer func(p retry.Policy) { eventRetry = p }(eventRetry)
+
+	// nothing is kept when events aren't retried
+	eventRetry = retry.New(0)
+	ev := &libhoney.Event{}
+	trackRetries(ev, map[string]interface{}{"elb_status_code": 200})
+	if ev.Metadata != nil {
+		t.Errorf("expected no retry metadata, got %v", ev.Metadata)
+	}
+
+	eventRetry = retry.Policy{Retries: 2, Base: time.Hour, Max: time.Hour}
+	ev = &libhoney.Event{Dataset: "aws-elb-access", SampleRate: 10, Timestamp: time.Now()}
+	trackRetries(ev, map[string]interface{}{"elb_status_code": 200})
+	retryable, ok := ev.Metadata.(*retryableEvent)
+	if !ok || retryable.dataset != "aws-elb-access" || retryable.sampleRate != 10 || retryable.attempts != 1 {
+		t.Fatalf("unexpected retry metadata %+v", ev.Metadata)
+	}
+
+	for _, r := range []struct {
+		statusCode int
+		retried    bool
+	}{
+		{202, false},
+		// Honeycomb isn't going to change its mind
+		{400, false},
+		{401, false},
+		{429, true},
+		{500, true},
+		{503, true},
+	} {
+		if retryResponse(transmission.Response{StatusCode: r.statusCode, Metadata: retryable}) != r.retried {
+			t.Errorf("%d: expected retrying to be %v", r.statusCode, r.retried)
+		}
+	}
+
+	// other events sent to libhoney, e.g. failover alerts, aren't retried
+	if retryResponse(transmission.Response{StatusCode: 503}) {
+		t.Error("expected an event without retry metadata not to be retried")
+	}
+
+	retryable.attempts = 3
+	if retryResponse(transmission.Response{StatusCode: 503, Metadata: retryable}) {
+		t.Error("expected the event to be given up on after 2 retries")
+	}
+}
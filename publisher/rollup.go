@@ -0,0 +1,245 @@
+package publisher
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeyaws/sketch"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/urlshaper"
+	"github.com/sirupsen/logrus"
+)
+
+// latencyBounds are the upper bounds, in seconds, of the latency.le_* counts
+// of rollups: the requests which took at most that long.
+var latencyBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// rollupKey is the load balancer and window a rollup is of, and with
+// --rollup_by the route and status.
+type rollupKey struct {
+	elb    string
+	route  string
+	status int64
+	window time.Time
+}
+
+// rollup aggregates the requests to a load balancer in a window.
+type rollup struct {
+	requests                 int
+	sentBytes, receivedBytes int64
+	errors4xx, errors5xx     int
+	latency                  *sketch.TDigest
+	// under counts the requests at or under each of the latencyBounds.
+	under []int
+}
+
+// rollups aggregates every parsed event, before sampling, into a rollup per
+// load balancer per interval of the events' timestamps, and periodically
+// sends them as rollup events. Unlike the sampled events, their counts are
+// exact, which makes them cheap to keep for a long time.
+type rollups struct {
+	sync.Mutex
+	interval time.Duration
+	datasets map[string]string
+	windows  map[rollupKey]*rollup
+
+	// byRoute and byStatus break the rollups down further, for
+	// --rollup_by, with routes shaped as they would be for the events.
+	byRoute, byStatus bool
+	shaper            *urlshaper.Parser
+	rules             *URLRules
+
+	// only drops the events once they're rolled up, for --rollups_only.
+	only bool
+}
+
+func newRollups(interval time.Duration, datasets map[string]string) *rollups {
+	return &rollups{interval: interval, datasets: datasets, windows: make(map[rollupKey]*rollup)}
+}
+
+// observe adds each event to its rollup and passes it along unchanged, unless
+// only the rollups are sent.
+func (r *rollups) observe(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		r.add(ev)
+		if !r.only {
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+	close(out)
+}
+
+// setRules has the routes shaped with the rules from then on.
+func (r *rollups) setRules(rules *liveRules) {
+	r.Lock()
+	defer r.Unlock()
+	r.shaper, r.rules = rules.shaper, rules.urlRules
+}
+
+// route returns the shape of the request's path, e.g. /users/:id with a path
+// pattern of /users/:id, without shaping the event itself, which happens once
+// it's sampled.
+func (r *rollups) route(data map[string]interface{}) string {
+	request, ok := data["request"].(string)
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(request, " ")
+	path := parts[0]
+	if len(parts) == 3 {
+		path = parts[1]
+	}
+	if r.rules != nil {
+		path = r.rules.URI(path)
+	}
+	res, err := r.shaper.Parse(path)
+	if err != nil {
+		return ""
+	}
+	return res.PathShape
+}
+
+// requestLatency returns the time the request took, if it was logged: the
+// total_time of ALB events, or the sum of the processing times otherwise.
+func requestLatency(data map[string]interface{}) (float64, bool) {
+	if total, ok := numberField(data, "total_time"); ok {
+		return total, true
+	}
+	var total float64
+	for _, field := range []string{"request_processing_time", "backend_processing_time", "response_processing_time"} {
+		t, ok := numberField(data, field)
+		// -1 means the target timed out or disconnected
+		if !ok || t < 0 {
+			return 0, false
+		}
+		total += t
+	}
+	return total, true
+}
+
+func (r *rollups) add(ev event.Event) {
+	elb, ok := ev.Data["elb"].(string)
+	// connection log events are connections, not requests
+	if !ok || ev.Data["log_type"] == "connection" {
+		return
+	}
+	key := rollupKey{elb: elb, window: ev.Timestamp.UTC().Truncate(r.interval)}
+	status, hasStatus := numberField(ev.Data, "elb_status_code")
+	if r.byStatus && hasStatus {
+		key.status = int64(status)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	// under the lock, since the rules may be reloaded
+	if r.byRoute {
+		key.route = r.route(ev.Data)
+	}
+	ru, ok := r.windows[key]
+	if !ok {
+		ru = &rollup{latency: sketch.NewTDigest(100), under: make([]int, len(latencyBounds))}
+		r.windows[key] = ru
+	}
+	ru.requests++
+	if sent, ok := numberField(ev.Data, "sent_bytes"); ok {
+		ru.sentBytes += int64(sent)
+	}
+	if received, ok := numberField(ev.Data, "received_bytes"); ok {
+		ru.receivedBytes += int64(received)
+	}
+	if hasStatus {
+		switch {
+		case status >= 500:
+			ru.errors5xx++
+		case status >= 400:
+			ru.errors4xx++
+		}
+	}
+	if latency, ok := requestLatency(ev.Data); ok {
+		ru.latency.Add(latency)
+		for i, bound := range latencyBounds {
+			if latency <= bound {
+				ru.under[i]++
+			}
+		}
+	}
+}
+
+// flush returns a rollup event for each load balancer and window with events
+// since the last flush, and resets them. A window whose events are in
+// objects published either side of a flush gets a rollup event for each
+// part, whose counts add up.
+func (r *rollups) flush() []event.Event {
+	r.Lock()
+	windows := r.windows
+	r.windows = make(map[rollupKey]*rollup)
+	r.Unlock()
+
+	var events []event.Event
+	for key, ru := range windows {
+		data := map[string]interface{}{
+			"meta.type":       "rollup",
+			"elb":             key.elb,
+			"rollup_interval": r.interval.Seconds(),
+			"request_count":   ru.requests,
+			"sent_bytes":      ru.sentBytes,
+			"received_bytes":  ru.receivedBytes,
+			"error_count_4xx": ru.errors4xx,
+			"error_count_5xx": ru.errors5xx,
+			"latency.count":   ru.latency.Count(),
+		}
+		if r.byRoute {
+			data["route"] = key.route
+		}
+		if key.status != 0 {
+			data["elb_status_code"] = key.status
+		}
+		if ru.latency.Count() > 0 {
+			data["latency.min"] = ru.latency.Min()
+			data["latency.p50"] = ru.latency.Quantile(0.5)
+			data["latency.p75"] = ru.latency.Quantile(0.75)
+			data["latency.p90"] = ru.latency.Quantile(0.9)
+			data["latency.p95"] = ru.latency.Quantile(0.95)
+			data["latency.p99"] = ru.latency.Quantile(0.99)
+			data["latency.p999"] = ru.latency.Quantile(0.999)
+			data["latency.max"] = ru.latency.Max()
+			for i, bound := range latencyBounds {
+				data["latency.le_"+strconv.FormatFloat(bound, 'f', -1, 64)] = ru.under[i]
+			}
+		}
+		events = append(events, event.Event{Timestamp: key.window, Data: data})
+	}
+	return events
+}
+
+func (r *rollups) run() {
+	ticker := time.NewTicker(r.interval).C
+	for range ticker {
+		r.send()
+	}
+}
+
+// send flushes the rollups and sends them, e.g. every interval and once the
+// publisher is drained, so that the last ones aren't lost.
+func (r *rollups) send() {
+	for _, ev := range r.flush() {
+		libhEv := libhoney.NewEvent()
+		libhEv.Timestamp = ev.Timestamp
+		if dataset := datasetFor(ev.Data, r.datasets); dataset != "" {
+			libhEv.Dataset = dataset
+		}
+		applyDestination(libhEv)
+		if err := libhEv.Add(ev.Data); err != nil {
+			logrus.WithField("error", err).Error("Unexpected error adding data to rollup event")
+			continue
+		}
+		if err := libhEv.SendPresampled(); err != nil {
+			logrus.WithField("error", err).Error("Unexpected error sending rollup event")
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package publisher
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestRollupsFlush(t *testing.T) {
+	r := newRollups(time.Minute, nil)
+	minute := time.Date(2018, 8, 20, 11, 20, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		status := int64(200)
+		switch {
+		case i < 5:
+			status = 503
+		case i < 15:
+			status = 404
+		}
+		r.add(event.Event{Timestamp: minute.Add(time.Duration(i) * 500 * time.Millisecond), Data: map[string]interface{}{
+			"elb":             "app/foo-alb/1db0c9806095122a",
+			"elb_status_code": status,
+			"sent_bytes":      int64(1000),
+			"received_bytes":  int64(100),
+			"total_time":      float64(i+1) / 100,
+		}})
+	}
+	// a request in the next minute, whose target timed out
+	r.add(event.Event{Timestamp: minute.Add(time.Minute), Data: map[string]interface{}{
+		"elb":                      "app/foo-alb/1db0c9806095122a",
+		"request_processing_time":  0.001,
+		"backend_processing_time":  float64(-1),
+		"response_processing_time": float64(-1),
+	}})
+	// events without a load balancer aren't rolled up
+	r.add(event.Event{Timestamp: minute, Data: map[string]interface{}{"sc_status": int64(200)}})
+
+	events := r.flush()
+	if len(events) != 2 {
+		t.Fatalf("expected a rollup for each minute, got %d", len(events))
+	}
+	var first, second event.Event
+	for _, ev := range events {
+		if ev.Timestamp.Equal(minute) {
+			first = ev
+		} else {
+			second = ev
+		}
+	}
+	expected := map[string]interface{}{
+		"request_count":   100,
+		"sent_bytes":      int64(100000),
+		"received_bytes":  int64(10000),
+		"error_count_4xx": 10,
+		"error_count_5xx": 5,
+		"latency.count":   100,
+	}
+	for k, v := range expected {
+		if first.Data[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, first.Data[k])
+		}
+	}
+	if p99 := first.Data["latency.p99"].(float64); p99 < 0.97 || p99 > 1.0 {
+		t.Errorf("expected p99 of ~0.99, got %v", p99)
+	}
+	if second.Data["request_count"] != 1 || second.Data["latency.count"] != 0 {
+		t.Errorf("expected the timed out request to be counted without a latency, got %v", second.Data)
+	}
+	if _, ok := second.Data["latency.p50"]; ok {
+		t.Error("expected no percentiles without latencies")
+	}
+
+	if len(r.flush()) != 0 {
+		t.Error("expected rollups to be reset after flush")
+	}
+}
+
+func TestRollupsByRouteAndStatus(t *testing.T) {
+	r := newRollups(time.Minute, nil)
+	r.byRoute, r.byStatus = true, true
+	rules := &URLRules{PathPatterns: []string{"/users/:id"}}
+	r.shaper, r.rules = rules.parser(), rules
+	r.only = true
+
+	minute := time.Date(2018, 8, 20, 11, 20, 0, 0, time.UTC)
+	in := make(chan event.Event)
+	out := make(chan event.Event)
+	go r.observe(in, out)
+	for i, request := range []string{
+		"GET http://example.com:80/users/1 HTTP/1.1",
+		"GET http://example.com:80/users/2?page=3 HTTP/1.1",
+		"GET http://example.com:80/users/3 HTTP/1.1",
+		"GET http://example.com:80/health HTTP/1.1",
+	} {
+		status := int64(200)
+		if i == 2 {
+			status = 500
+		}
+		in <- event.Event{Timestamp: minute, Data: map[string]interface{}{
+			"elb":             "app/foo-alb/1db0c9806095122a",
+			"request":         request,
+			"elb_status_code": status,
+			"total_time":      float64(i+1) / 100,
+		}}
+	}
+	close(in)
+	for ev := range out {
+		t.Errorf("expected only the rollups to be sent, got %v", ev.Data)
+	}
+
+	rolledUp := make(map[string]map[string]interface{})
+	for _, ev := range r.flush() {
+		rolledUp[ev.Data["route"].(string)+" "+strconv.FormatInt(ev.Data["elb_status_code"].(int64), 10)] = ev.Data
+	}
+	if len(rolledUp) != 3 {
+		t.Fatalf("expected a rollup per route and status, got %v", rolledUp)
+	}
+	users := rolledUp["/users/:id 200"]
+	if users["request_count"] != 2 || users["latency.min"] != 0.01 || users["latency.max"] != 0.02 {
+		t.Errorf("unexpected rollup of /users/:id, got %v", users)
+	}
+	if users["latency.le_0.01"] != 1 || users["latency.le_0.025"] != 2 || users["latency.le_10"] != 2 {
+		t.Errorf("expected cumulative latency counts, got %v", users)
+	}
+	if rolledUp["/users/:id 500"]["error_count_5xx"] != 1 || rolledUp["/health 200"]["request_count"] != 1 {
+		t.Errorf("unexpected rollups, got %v", rolledUp)
+	}
+}
@@ -0,0 +1,81 @@
+package publisher
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	serviceNameField = "service.name"
+	// serviceNameGroup is the group of a --service_name_regex which is the
+	// service name.
+	serviceNameGroup = "service"
+)
+
+// ServiceNamePatterns infer services' names from the names of their target
+// groups and load balancers, by naming convention, from --service_name_regex.
+type ServiceNamePatterns []serviceNamePattern
+
+type serviceNamePattern struct {
+	*regexp.Regexp
+	// group is the index of the service group.
+	group int
+}
+
+// ParseServiceNamePatterns parses regexps with a group named service, e.g.
+// svc-(?P<service>[a-z]+)-prod.
+func ParseServiceNamePatterns(patterns []string) (ServiceNamePatterns, error) {
+	var parsed ServiceNamePatterns
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid regexp: %s", pattern, err)
+		}
+		group := -1
+		for i, name := range re.SubexpNames() {
+			if name == serviceNameGroup {
+				group = i
+			}
+		}
+		if group < 0 {
+			return nil, fmt.Errorf("%q has no (?P<%s>...) group", pattern, serviceNameGroup)
+		}
+		parsed = append(parsed, serviceNamePattern{re, group})
+	}
+	return parsed, nil
+}
+
+// match returns the service name the first pattern matching the name finds.
+func (p ServiceNamePatterns) match(name string) string {
+	for _, re := range p {
+		if m := re.FindStringSubmatch(name); m != nil {
+			if service := m[re.group]; service != "" {
+				return service
+			}
+		}
+	}
+	return ""
+}
+
+// infer sets service.name from the event's target group name, or failing that
+// its load balancer's, if a pattern matches either.
+func (p ServiceNamePatterns) infer(data map[string]interface{}) {
+	if len(p) == 0 {
+		return
+	}
+	var names []string
+	if arn, ok := data["target_group_arn"].(string); ok {
+		if name := targetGroupName(arn); name != "" {
+			names = append(names, name)
+		}
+	}
+	if elb, ok := data["elb"].(string); ok {
+		names = append(names, lbName(elb))
+	}
+	for _, name := range names {
+		if service := p.match(name); service != "" {
+			data[serviceNameField] = service
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package publisher
+
+import "testing"
+
+func TestServiceNamePatterns(t *testing.T) {
+	patterns, err := ParseServiceNamePatterns([]string{`^svc-(?P<service>[a-z]+)-prod$`, `^(?P<service>[a-z]+)-lb$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		data     map[string]interface{}
+		expected interface{}
+	}{
+		{map[string]interface{}{
+			"target_group_arn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/svc-orders-prod/73e2d6bc24d8a067",
+			"elb":              "app/svc-users-prod/50dc6c495c0c9188",
+		}, "orders"},
+		{map[string]interface{}{
+			"target_group_arn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/adhoc/73e2d6bc24d8a067",
+			"elb":              "app/svc-users-prod/50dc6c495c0c9188",
+		}, "users"},
+		{map[string]interface{}{"elb": "net/search-lb/50dc6c495c0c9188"}, "search"},
+		{map[string]interface{}{"elb": "app/svc-users-staging/50dc6c495c0c9188"}, nil},
+	} {
+		patterns.infer(tc.data)
+		if tc.data[serviceNameField] != tc.expected {
+			t.Errorf("expected service.name %v for %v", tc.expected, tc.data)
+		}
+	}
+
+	for _, pattern := range []string{`svc-([a-z]+)`, `svc-(?P<service>[a-z]+`} {
+		if _, err := ParseServiceNamePatterns([]string{pattern}); err == nil {
+			t.Errorf("expected %q to be an error", pattern)
+		}
+	}
+}
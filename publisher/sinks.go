@@ -0,0 +1,482 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Objects written to S3 are fewer and bigger than Kafka's batches,
+	// for them to be queried efficiently.
+	s3SinkBatchSize        = 10000
+	s3SinkFlushInterval    = time.Minute
+	kafkaSinkBatchSize     = 500
+	kafkaSinkFlushInterval = time.Second
+	kafkaSinkTimeout       = 30 * time.Second
+)
+
+// sinks is the libhoney transmission.Sender for --sink, which sends each event
+// to every sink: Honeycomb (as --output says), newline-delimited JSON objects
+// in S3, or a Kafka topic. The first sink's responses, Honeycomb's if it's one
+// of them, are the ones libhoney gets, for retries and --exactly_once to go
+// by, so events sent again after a retryable response only go to it. Copies
+// written to the other sinks aren't retried.
+type sinks struct {
+	primary transmission.Sender
+	copies  []transmission.Sender
+}
+
+// newSinks returns the sender for --sink, or the only sink if there's just
+// the one. cfg is for sending to Honeycomb, with its Transmission set if
+// --output or --spool_dir say events go elsewhere than the events API.
+func newSinks(opt *options.Options, cfg libhoney.Config) (transmission.Sender, error) {
+	var (
+		s         sinks
+		honeycomb bool
+		sess      *session.Session
+	)
+	for _, sink := range opt.Sinks {
+		var sender transmission.Sender
+		switch {
+		case sink == options.SinkHoneycomb:
+			if honeycomb {
+				return nil, fmt.Errorf("%s is given more than once", sink)
+			}
+			honeycomb = true
+			sender = cfg.Transmission
+			if sender == nil {
+				sender = newHoneycombTransmission(cfg)
+			}
+		case strings.HasPrefix(sink, "s3://"):
+			if sess == nil {
+				var err error
+				sess, err = session.NewSessionWithOptions(session.Options{
+					SharedConfigState: session.SharedConfigEnable,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+			s3, err := newS3Sink(s3manager.NewUploader(sess), sink)
+			if err != nil {
+				return nil, err
+			}
+			sender = s3
+		case strings.HasPrefix(sink, "kafka://"), strings.HasPrefix(sink, "kafka+https://"):
+			kafka, err := newKafkaSink(sink)
+			if err != nil {
+				return nil, err
+			}
+			sender = kafka
+		default:
+			return nil, fmt.Errorf("%q should be honeycomb, an s3://bucket/prefix URL or a kafka://host:port/topic URL", sink)
+		}
+
+		if sink == options.SinkHoneycomb && s.primary != nil {
+			s.copies = append(s.copies, s.primary)
+			s.primary = sender
+		} else if s.primary == nil {
+			s.primary = sender
+		} else {
+			s.copies = append(s.copies, sender)
+		}
+	}
+	if len(s.copies) == 0 {
+		return s.primary, nil
+	}
+	return &s, nil
+}
+
+// newHoneycombTransmission is the transmission libhoney would send events to
+// Honeycomb's events API with for cfg, were it not given one.
+func newHoneycombTransmission(cfg libhoney.Config) *transmission.Honeycomb {
+	t := &transmission.Honeycomb{
+		MaxBatchSize:         cfg.MaxBatchSize,
+		BatchTimeout:         cfg.SendFrequency,
+		MaxConcurrentBatches: cfg.MaxConcurrentBatches,
+		PendingWorkCapacity:  cfg.PendingWorkCapacity,
+		BlockOnResponse:      cfg.BlockOnResponse,
+		Transport:            cfg.Transport,
+		UserAgentAddition:    libhoney.UserAgentAddition,
+	}
+	if t.MaxBatchSize == 0 {
+		t.MaxBatchSize = libhoney.DefaultMaxBatchSize
+	}
+	if t.BatchTimeout == 0 {
+		t.BatchTimeout = libhoney.DefaultBatchTimeout
+	}
+	if t.MaxConcurrentBatches == 0 {
+		t.MaxConcurrentBatches = libhoney.DefaultMaxConcurrentBatches
+	}
+	if t.PendingWorkCapacity == 0 {
+		t.PendingWorkCapacity = libhoney.DefaultPendingWorkCapacity
+	}
+	return t
+}
+
+func (s *sinks) Start() error {
+	for _, sender := range append([]transmission.Sender{s.primary}, s.copies...) {
+		if err := sender.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sinks) Stop() error {
+	var firstErr error
+	for _, sender := range append([]transmission.Sender{s.primary}, s.copies...) {
+		if err := sender.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *sinks) Flush() error {
+	var firstErr error
+	for _, sender := range append([]transmission.Sender{s.primary}, s.copies...) {
+		if err := sender.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *sinks) Add(ev *transmission.Event) {
+	s.primary.Add(ev)
+	if retried, ok := ev.Metadata.(*retryableEvent); ok && retried.attempts > 1 {
+		return
+	}
+	for _, sender := range s.copies {
+		// the copies' responses aren't libhoney's, so they mustn't
+		// settle anything
+		c := *ev
+		c.Metadata = nil
+		sender.Add(&c)
+	}
+}
+
+func (s *sinks) TxResponses() chan transmission.Response {
+	return s.primary.TxResponses()
+}
+
+func (s *sinks) SendResponse(resp transmission.Response) bool {
+	return s.primary.SendResponse(resp)
+}
+
+// sinkRecord is how each event is written to the sinks besides Honeycomb, as
+// JSON.
+type sinkRecord struct {
+	Time       time.Time              `json:"time"`
+	Dataset    string                 `json:"dataset"`
+	SampleRate uint                   `json:"samplerate"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+func newSinkRecord(ev *transmission.Event) sinkRecord {
+	return sinkRecord{
+		Time:       ev.Timestamp,
+		Dataset:    ev.Dataset,
+		SampleRate: ev.SampleRate,
+		Data:       ev.Data,
+	}
+}
+
+// batchSink is a libhoney transmission.Sender for a sink besides Honeycomb,
+// which batches events by dataset, writing each batch once it has size events
+// or every interval, and responding for each of its events like Honeycomb's
+// batch API would, as the spool does. Batches which fail to be written are
+// logged and counted.
+type batchSink struct {
+	sync.Mutex
+	name      string
+	size      int
+	interval  time.Duration
+	write     func(dataset string, batch []*transmission.Event) error
+	batches   map[string][]*transmission.Event
+	responses chan transmission.Response
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newBatchSink(name string, size int, interval time.Duration, write func(dataset string, batch []*transmission.Event) error) *batchSink {
+	return &batchSink{
+		name:      name,
+		size:      size,
+		interval:  interval,
+		write:     write,
+		batches:   make(map[string][]*transmission.Event),
+		responses: make(chan transmission.Response, 2*size),
+	}
+}
+
+func (s *batchSink) Start() error {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Flush()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *batchSink) Stop() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	return s.Flush()
+}
+
+func (s *batchSink) Add(ev *transmission.Event) {
+	s.Lock()
+	s.batches[ev.Dataset] = append(s.batches[ev.Dataset], ev)
+	var full []*transmission.Event
+	if len(s.batches[ev.Dataset]) >= s.size {
+		full = s.batches[ev.Dataset]
+		delete(s.batches, ev.Dataset)
+	}
+	s.Unlock()
+
+	if full != nil {
+		s.send(ev.Dataset, full)
+	}
+}
+
+func (s *batchSink) Flush() error {
+	s.Lock()
+	batches := s.batches
+	s.batches = make(map[string][]*transmission.Event)
+	s.Unlock()
+
+	var firstErr error
+	for dataset, batch := range batches {
+		if err := s.send(dataset, batch); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *batchSink) send(dataset string, batch []*transmission.Event) error {
+	start := time.Now()
+	err := s.write(dataset, batch)
+	if err != nil {
+		metrics.SinkErrors.WithLabelValues(s.name).Add(float64(len(batch)))
+		logrus.WithFields(logrus.Fields{
+			"sink":    s.name,
+			"dataset": dataset,
+			"events":  len(batch),
+			"error":   err,
+		}).Error("Could not write events to the sink")
+	}
+	for _, ev := range batch {
+		resp := transmission.Response{
+			Err:      err,
+			Duration: time.Since(start),
+			Metadata: ev.Metadata,
+		}
+		if err == nil {
+			resp.StatusCode = http.StatusAccepted
+		}
+		s.SendResponse(resp)
+	}
+	return err
+}
+
+func (s *batchSink) TxResponses() chan transmission.Response {
+	return s.responses
+}
+
+// SendResponse doesn't block if nothing is reading the responses, like the
+// default libhoney transmission, which for the copies nothing is.
+func (s *batchSink) SendResponse(resp transmission.Response) bool {
+	select {
+	case s.responses <- resp:
+		return false
+	default:
+		return true
+	}
+}
+
+// s3Sink writes each batch of events to a gzipped object of newline-delimited
+// JSON under the prefix, at <dataset>/<yyyy>/<mm>/<dd>/<hh>/<time>-<seq>.jsonl.gz
+// by when it was written, in UTC, for the hours to be partitions of a table
+// over them, e.g. in Athena.
+type s3Sink struct {
+	bucket, prefix string
+	uploader       s3manageriface.UploaderAPI
+	codec          codec
+	seq            int64
+}
+
+func newS3Sink(uploader s3manageriface.UploaderAPI, sink string) (*batchSink, error) {
+	u, err := url.Parse(sink)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%q should be an s3://bucket/prefix URL", sink)
+	}
+	s := &s3Sink{
+		bucket:   u.Host,
+		prefix:   strings.TrimPrefix(u.Path, "/"),
+		uploader: uploader,
+		codec:    codec{name: CodecGzip},
+	}
+	return newBatchSink("s3", s3SinkBatchSize, s3SinkFlushInterval, s.write), nil
+}
+
+func (s *s3Sink) key(dataset string, t time.Time) string {
+	t = t.UTC()
+	name := fmt.Sprintf("%d-%06d.jsonl%s", t.UnixNano(), atomic.AddInt64(&s.seq, 1), s.codec.ext())
+	return path.Join(s.prefix, url.PathEscape(dataset), t.Format("2006/01/02/15"), name)
+}
+
+func (s *s3Sink) write(dataset string, batch []*transmission.Event) error {
+	var buf bytes.Buffer
+	w, err := s.codec.writer(&buf)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, ev := range batch {
+		if err := enc.Encode(newSinkRecord(ev)); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	_, err = s.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(dataset, time.Now())),
+		Body:        &buf,
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	return err
+}
+
+// kafkaSink produces each event as a record to a Kafka topic through a
+// Confluent REST Proxy (or anything else speaking its v2 API), keyed by its
+// dataset: kafka://host:port/topic over HTTP, kafka+https://host:port/topic
+// over HTTPS.
+type kafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+// kafkaRecords is the body of a REST Proxy produce request, with JSON
+// records.
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Key   string     `json:"key"`
+	Value sinkRecord `json:"value"`
+}
+
+// kafkaOffsets is the body of a REST Proxy produce response, with an offset
+// (or error) for each record.
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func newKafkaSink(sink string) (*batchSink, error) {
+	u, err := url.Parse(sink)
+	topic := ""
+	if err == nil {
+		topic = strings.Trim(u.Path, "/")
+	}
+	if err != nil || u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("%q should be a kafka://host:port/topic URL", sink)
+	}
+	scheme := "http"
+	if u.Scheme == "kafka+https" {
+		scheme = "https"
+	}
+	k := &kafkaSink{
+		url:    (&url.URL{Scheme: scheme, Host: u.Host, User: u.User, Path: "/topics/" + topic}).String(),
+		client: &http.Client{Timeout: kafkaSinkTimeout},
+	}
+	return newBatchSink("kafka", kafkaSinkBatchSize, kafkaSinkFlushInterval, k.write), nil
+}
+
+func (k *kafkaSink) write(dataset string, batch []*transmission.Event) error {
+	records := kafkaRecords{Records: make([]kafkaRecord, 0, len(batch))}
+	for _, ev := range batch {
+		records.Records = append(records.Records, kafkaRecord{Key: dataset, Value: newSinkRecord(ev)})
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("the Kafka REST Proxy responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var offsets kafkaOffsets
+	if err := json.NewDecoder(resp.Body).Decode(&offsets); err != nil {
+		return fmt.Errorf("could not read the Kafka REST Proxy's response: %s", err)
+	}
+	failed, firstErr := 0, ""
+	for _, offset := range offsets.Offsets {
+		if offset.ErrorCode != nil {
+			if failed == 0 {
+				firstErr = offset.Error
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d records weren't produced: %s", failed, len(batch), firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package publisher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+// fakeUploader keeps what's uploaded, by key.
+type fakeUploader struct {
+	uploads map[string][]byte
+}
+
+func (u *fakeUploader) Upload(in *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	data, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	u.uploads[aws.StringValue(in.Key)] = data
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (u *fakeUploader) UploadWithContext(ctx aws.Context, in *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	return u.Upload(in, opts...)
+}
+
+func TestNewSinks(t *testing.T) {
+	output := &transmission.MockSender{}
+	cfg := libhoney.Config{Transmission: output}
+
+	sender, err := newSinks(&options.Options{Sinks: []string{"honeycomb"}}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender != output {
+		t.Errorf("expected just the --output transmission, got %T", sender)
+	}
+
+	sender, err = newSinks(&options.Options{Sinks: []string{"kafka://localhost:8082/access-logs", "honeycomb"}}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := sender.(*sinks)
+	if !ok || s.primary != output || len(s.copies) != 1 {
+		t.Errorf("expected Honeycomb's responses to be libhoney's, with a copy to Kafka, got %+v", sender)
+	}
+
+	if sender, err := newSinks(&options.Options{Sinks: []string{"honeycomb"}}, libhoney.Config{}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := sender.(*transmission.Honeycomb); !ok {
+		t.Errorf("expected the events API without --output, got %T", sender)
+	}
+
+	for _, invalid := range [][]string{
+		{"honeycomb", "honeycomb"},
+		{"ftp://logs/access"},
+		{"kafka://localhost:8082"},
+		{"kafka://localhost:8082/access/logs"},
+		{"s3:///prefix"},
+	} {
+		if _, err := newSinks(&options.Options{Sinks: invalid}, cfg); err == nil {
+			t.Errorf("expected %v to be invalid", invalid)
+		}
+	}
+}
+
+func TestSinksAdd(t *testing.T) {
+	primary := &transmission.MockSender{}
+	copied := &transmission.MockSender{}
+	s := &sinks{primary: primary, copies: []transmission.Sender{copied}}
+
+	confirmed := &retryableEvent{attempts: 1}
+	s.Add(&transmission.Event{Dataset: "aws-alb-access", Metadata: confirmed})
+	s.Add(&transmission.Event{Dataset: "aws-alb-access", Metadata: &retryableEvent{attempts: 2}})
+
+	if events := primary.Events(); len(events) != 2 || events[0].Metadata != confirmed {
+		t.Errorf("expected every event to be sent to the first sink as it is, got %+v", events)
+	}
+	if events := copied.Events(); len(events) != 1 || events[0].Metadata != nil || events[0].Dataset != "aws-alb-access" {
+		t.Errorf("expected just the first attempt copied, without its metadata, got %+v", events)
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	uploader := &fakeUploader{uploads: make(map[string][]byte)}
+	sink, err := newS3Sink(uploader, "s3://data-lake/honeyaws/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2026, 10, 14, 9, 5, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		sink.Add(&transmission.Event{
+			Dataset:    "aws-alb-access",
+			SampleRate: 10,
+			Timestamp:  ts,
+			Data:       map[string]interface{}{"elb_status_code": 200 + i},
+		})
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploader.uploads) != 1 {
+		t.Fatalf("expected the batch to be uploaded as one object, got %d", len(uploader.uploads))
+	}
+	for key, data := range uploader.uploads {
+		if !strings.HasPrefix(key, "honeyaws/aws-alb-access/") || !strings.HasSuffix(key, ".jsonl.gz") {
+			t.Errorf("unexpected key %s", key)
+		}
+		r, err := gzip.NewReader(strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(r)
+		var records []sinkRecord
+		for scanner.Scan() {
+			var record sinkRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+		if len(records) != 2 || records[1].Data["elb_status_code"] != float64(201) || records[0].SampleRate != 10 || !records[0].Time.Equal(ts) {
+			t.Errorf("unexpected records %+v", records)
+		}
+	}
+	if resp := <-sink.TxResponses(); resp.StatusCode != http.StatusAccepted || resp.Err != nil {
+		t.Errorf("expected the events to be accepted, got %+v", resp)
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		produced kafkaRecords
+		failing  bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path != "/topics/access-logs" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&produced); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if failing {
+			fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":1},{"error_code":50003,"error":"Kafka error"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":1},{"partition":0,"offset":2}]}`)
+	}))
+	defer server.Close()
+
+	sink, err := newKafkaSink("kafka://" + strings.TrimPrefix(server.URL, "http://") + "/access-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := []*transmission.Event{
+		{Dataset: "aws-alb-access", Data: map[string]interface{}{"elb_status_code": 200}},
+		{Dataset: "aws-alb-access", Data: map[string]interface{}{"elb_status_code": 502}},
+	}
+	if err := sink.write("aws-alb-access", batch); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	if len(produced.Records) != 2 || produced.Records[0].Key != "aws-alb-access" || produced.Records[1].Value.Data["elb_status_code"] != float64(502) {
+		t.Errorf("unexpected records %+v", produced.Records)
+	}
+	failing = true
+	lock.Unlock()
+
+	if err := sink.write("aws-alb-access", batch); err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("expected the record which wasn't produced to fail the batch, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+const (
+	spoolBatchSize     = 500
+	spoolFlushInterval = time.Second
+)
+
+// spoolSender is a libhoney transmission.Sender that, instead of sending
+// events to Honeycomb, writes them to a spool directory for a forwarder
+// (e.g. on the one host allowed to talk to Honeycomb) to send on. Each file
+// is the body of a batch API request for the dataset it's in, i.e.
+// <dir>/<dataset>/<time>-<seq>.json should be POSTed to /1/batch/<dataset>,
+// with the codec's extension (e.g. .json.zst) if they're compressed.
+// Files are written under a .tmp name and renamed once complete, so
+// forwarders should skip those.
+type spoolSender struct {
+	sync.Mutex
+	dir       string
+	codec     codec
+	batches   map[string][]*transmission.Event
+	seq       int
+	responses chan transmission.Response
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newSpoolSender(dir string, c codec) *spoolSender {
+	return &spoolSender{
+		dir:       dir,
+		codec:     c,
+		batches:   make(map[string][]*transmission.Event),
+		responses: make(chan transmission.Response, 2*spoolBatchSize),
+	}
+}
+
+func (s *spoolSender) Start() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(spoolFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Flush()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *spoolSender) Stop() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	return s.Flush()
+}
+
+func (s *spoolSender) Add(ev *transmission.Event) {
+	s.Lock()
+	s.batches[ev.Dataset] = append(s.batches[ev.Dataset], ev)
+	var full []*transmission.Event
+	if len(s.batches[ev.Dataset]) >= spoolBatchSize {
+		full = s.batches[ev.Dataset]
+		delete(s.batches, ev.Dataset)
+	}
+	s.Unlock()
+
+	if full != nil {
+		s.write(ev.Dataset, full)
+	}
+}
+
+func (s *spoolSender) Flush() error {
+	s.Lock()
+	batches := s.batches
+	s.batches = make(map[string][]*transmission.Event)
+	s.Unlock()
+
+	var firstErr error
+	for dataset, batch := range batches {
+		if err := s.write(dataset, batch); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// write spools a batch, responding for each of its events like Honeycomb's
+// batch API would.
+func (s *spoolSender) write(dataset string, batch []*transmission.Event) error {
+	start := time.Now()
+	err := s.writeFile(dataset, batch)
+	for _, ev := range batch {
+		resp := transmission.Response{
+			Err:      err,
+			Duration: time.Since(start),
+			Metadata: ev.Metadata,
+		}
+		if err == nil {
+			resp.StatusCode = http.StatusAccepted
+		}
+		s.SendResponse(resp)
+	}
+	return err
+}
+
+func (s *spoolSender) writeFile(dataset string, batch []*transmission.Event) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.dir, url.PathEscape(dataset))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.seq++
+	name := fmt.Sprintf("%d-%06d.json%s", time.Now().UnixNano(), s.seq, s.codec.ext())
+	s.Unlock()
+
+	tmp := filepath.Join(dir, name+".tmp")
+	if err := s.writeCompressed(tmp, data); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+func (s *spoolSender) writeCompressed(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := s.codec.writer(f)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s *spoolSender) TxResponses() chan transmission.Response {
+	return s.responses
+}
+
+// SendResponse doesn't block if nothing is reading the responses, like the
+// default libhoney transmission.
+func (s *spoolSender) SendResponse(resp transmission.Response) bool {
+	select {
+	case s.responses <- resp:
+		return false
+	default:
+		return true
+	}
+}
+
+// checkSpoolDir is the readiness check used instead of verifying the write
+// key with Honeycomb when spooling, since Honeycomb may not be reachable.
+func checkSpoolDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
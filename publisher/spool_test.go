@@ -0,0 +1,133 @@
+package publisher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSpoolSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newSpoolSender(dir, codec{})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	s.Add(&transmission.Event{
+		Dataset:    "aws-alb-access",
+		SampleRate: 4,
+		Timestamp:  ts,
+		Data:       map[string]interface{}{"elb_status_code": 200},
+	})
+	s.Add(&transmission.Event{
+		Dataset: "payments/lb",
+		Data:    map[string]interface{}{"elb_status_code": 502},
+	})
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := <-s.TxResponses()
+		if resp.Err != nil || resp.StatusCode != 202 {
+			t.Errorf("expected a 202 response, got %d (%v)", resp.StatusCode, resp.Err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "aws-alb-access", "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one batch file, got %v (%v)", files, err)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch []struct {
+		Data       map[string]interface{} `json:"data"`
+		SampleRate uint                   `json:"samplerate"`
+		Time       time.Time              `json:"time"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 || batch[0].SampleRate != 4 || !batch[0].Time.Equal(ts) || batch[0].Data["elb_status_code"] != float64(200) {
+		t.Errorf("unexpected batch: %s", data)
+	}
+
+	// dataset names are escaped to stay in their own directory
+	if files, _ := filepath.Glob(filepath.Join(dir, "payments%2Flb", "*.json")); len(files) != 1 {
+		t.Errorf("expected one batch file for the escaped dataset, got %v", files)
+	}
+}
+
+func TestSpoolSenderBatchSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// not started, so only full batches are written until flushed
+	s := newSpoolSender(dir, codec{})
+	for i := 0; i < spoolBatchSize+1; i++ {
+		s.Add(&transmission.Event{Dataset: "d", Data: map[string]interface{}{"i": i}})
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "d", "*.json")); len(files) != 1 {
+		t.Errorf("expected one full batch file, got %d", len(files))
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "d", "*.json")); len(files) != 2 {
+		t.Errorf("expected two batch files after flushing, got %d", len(files))
+	}
+}
+
+func TestSpoolSenderCodec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := newCodec(CodecZstd, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSpoolSender(dir, c)
+	s.Add(&transmission.Event{Dataset: "d", Data: map[string]interface{}{"elb_status_code": 200}})
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "d", "*.json.zst"))
+	if len(files) != 1 {
+		t.Fatalf("expected one zstd compressed batch file, got %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var batch []map[string]interface{}
+	if err := json.NewDecoder(zr).Decode(&batch); err != nil || len(batch) != 1 {
+		t.Errorf("expected a batch of one event, got %v (%v)", batch, err)
+	}
+}
@@ -0,0 +1,92 @@
+package publisher
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/honeycombio/libhoney-go"
+	"github.com/sirupsen/logrus"
+)
+
+// imdsTimeout is how long to wait for the instance metadata service, which
+// isn't there at all off EC2.
+const imdsTimeout = time.Second
+
+// imdsEndpoint is where the instance metadata service is, if not the default.
+var imdsEndpoint string
+
+// ParseAddFields parses --add-field, as name=value.
+func ParseAddFields(addFields []string) (map[string]string, error) {
+	fields := make(map[string]string, len(addFields))
+	for _, f := range addFields {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid --add-field %q, expected name=value", f)
+		}
+		fields[parts[0]] = parts[1]
+	}
+	return fields, nil
+}
+
+// hostMetadata returns the fields --host-metadata adds to events: the
+// hostname, the instance ID if the agent is running on EC2, and the agent
+// and its version, as it tells Honeycomb in the user agent, e.g.
+// honeyalb/1.2.3.
+func hostMetadata() map[string]string {
+	fields := map[string]string{"meta.honeyaws_version": libhoney.UserAgentAddition}
+	if hostname, err := os.Hostname(); err == nil {
+		fields["meta.hostname"] = hostname
+	}
+	if instanceID := instanceID(); instanceID != "" {
+		fields["meta.instance_id"] = instanceID
+	}
+	return fields
+}
+
+// instanceID returns the ID of the EC2 instance the agent is running on, or
+// "" if it isn't running on one.
+func instanceID() string {
+	cfg := &aws.Config{
+		HTTPClient: &http.Client{Timeout: imdsTimeout},
+		MaxRetries: aws.Int(0),
+	}
+	if imdsEndpoint != "" {
+		cfg.Endpoint = aws.String(imdsEndpoint)
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return ""
+	}
+	id, err := ec2metadata.New(sess, cfg).GetMetadata("instance-id")
+	if err != nil {
+		logrus.WithField("error", err).Debug("Not adding meta.instance_id, the instance metadata service isn't available")
+		return ""
+	}
+	return id
+}
+
+// StaticFields returns the fields added to every event for --add-field and
+// --host-metadata, with --add-field taking precedence, since it's what was
+// asked for.
+func StaticFields(addFields []string, withHostMetadata bool) (map[string]interface{}, error) {
+	added, err := ParseAddFields(addFields)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if withHostMetadata {
+		for name, value := range hostMetadata() {
+			fields[name] = value
+		}
+	}
+	for name, value := range added {
+		fields[name] = value
+	}
+	return fields, nil
+}
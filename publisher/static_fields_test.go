@@ -0,0 +1,66 @@
+package publisher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseAddFields(t *testing.T) {
+	fields, err := ParseAddFields([]string{"env=prod", "team=infra", "query=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"env": "prod", "team": "infra", "query": "a=b", "empty": ""}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+
+	for _, invalid := range []string{"env", "=prod"} {
+		if _, err := ParseAddFields([]string{invalid}); err == nil {
+			t.Errorf("expected --add-field %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestHostMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+			w.Write([]byte("token"))
+		case "/latest/meta-data/instance-id":
+			w.Write([]byte("i-0123456789abcdef0"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer func(endpoint string) { imdsEndpoint = endpoint }(imdsEndpoint)
+	imdsEndpoint = srv.URL + "/latest"
+
+	fields := hostMetadata()
+	if fields["meta.instance_id"] != "i-0123456789abcdef0" {
+		t.Errorf("expected the instance ID, got %v", fields)
+	}
+	if fields["meta.hostname"] == "" {
+		t.Errorf("expected the hostname, got %v", fields)
+	}
+	if _, ok := fields["meta.honeyaws_version"]; !ok {
+		t.Errorf("expected the version, got %v", fields)
+	}
+
+	static, err := StaticFields([]string{"meta.hostname=ingest-1", "env=prod"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if static["meta.hostname"] != "ingest-1" || static["env"] != "prod" || static["meta.instance_id"] != "i-0123456789abcdef0" {
+		t.Errorf("expected --add-field over the host metadata, got %v", static)
+	}
+
+	// off EC2
+	srv.Close()
+	if fields := hostMetadata(); fields["meta.instance_id"] != "" {
+		t.Errorf("expected no instance ID without the metadata service, got %v", fields)
+	}
+}
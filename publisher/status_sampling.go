@@ -0,0 +1,92 @@
+package publisher
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+// StatusSampleRates are the fixed sample rates from --sample-rate-by-status
+// for responses with a status code, e.g. 404, or in a class of them, e.g. 5xx.
+// Events they don't cover are left to the dynamic sampler.
+type StatusSampleRates struct {
+	codes   map[int64]int
+	classes map[int64]int
+}
+
+// ParseStatusSampleRates parses comma separated status=rate pairs, e.g.
+// 5xx=1,4xx=5,2xx=100. A status is a code or a class of them; codes take
+// precedence over their class.
+func ParseStatusSampleRates(opt string) (*StatusSampleRates, error) {
+	if strings.TrimSpace(opt) == "" {
+		return nil, nil
+	}
+	r := &StatusSampleRates{codes: make(map[int64]int), classes: make(map[int64]int)}
+	for _, pair := range strings.Split(opt, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid --sample-rate-by-status %q, expected status=rate", pair)
+		}
+		rate, err := strconv.Atoi(parts[1])
+		if err != nil || rate < 1 {
+			return nil, fmt.Errorf("Invalid --sample-rate-by-status %q, the rate should be a whole number of at least 1", pair)
+		}
+		status := strings.ToLower(parts[0])
+		if len(status) == 3 && status[1:] == "xx" && status[0] >= '1' && status[0] <= '5' {
+			r.classes[int64(status[0]-'0')] = rate
+			continue
+		}
+		code, err := strconv.ParseInt(status, 10, 64)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("Invalid --sample-rate-by-status %q, expected a status code such as 404 or class such as 4xx", pair)
+		}
+		r.codes[code] = rate
+	}
+	return r, nil
+}
+
+// rateFor returns the sample rate for the event's status code, if there's
+// one for it.
+func (r *StatusSampleRates) rateFor(data map[string]interface{}) (int, bool) {
+	if r == nil {
+		return 0, false
+	}
+	code, ok := statusCode(data)
+	if !ok {
+		return 0, false
+	}
+	if rate, ok := r.codes[code]; ok {
+		return rate, true
+	}
+	rate, ok := r.classes[code/100]
+	return rate, ok
+}
+
+// sample samples the events with a status code which has a sample rate,
+// sending those kept to out, and sends the rest along to the dynamic
+// sampler. dynamic is closed once in is.
+func (r *StatusSampleRates) sample(in <-chan event.Event, dynamic, out chan<- event.Event) {
+	sampleByStatus(in, dynamic, out, func() *StatusSampleRates { return r })
+}
+
+// sampleByStatus samples the events as sample does, with whichever sample
+// rates are in use as each event comes along, since they may be reloaded.
+func sampleByStatus(in <-chan event.Event, dynamic, out chan<- event.Event, rates func() *StatusSampleRates) {
+	for ev := range in {
+		rate, ok := rates().rateFor(ev.Data)
+		if !ok {
+			dynamic <- ev
+			continue
+		}
+		if rand.Intn(rate) == 0 {
+			ev.SampleRate = rate
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+	close(dynamic)
+}
@@ -0,0 +1,67 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestParseStatusSampleRates(t *testing.T) {
+	r, err := ParseStatusSampleRates("5xx=1, 4xx=5,404=50,2XX=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		data map[string]interface{}
+		rate int
+		ok   bool
+	}{
+		{map[string]interface{}{"elb_status_code": int64(503)}, 1, true},
+		{map[string]interface{}{"elb_status_code": int64(400)}, 5, true},
+		{map[string]interface{}{"elb_status_code": int64(404)}, 50, true},
+		{map[string]interface{}{"sc_status": int64(200)}, 100, true},
+		{map[string]interface{}{"elb_status_code": int64(301)}, 0, false},
+		// e.g. NLB events
+		{map[string]interface{}{"connection_time": int64(12)}, 0, false},
+	} {
+		if rate, ok := r.rateFor(c.data); rate != c.rate || ok != c.ok {
+			t.Errorf("%v: expected %d, %v, got %d, %v", c.data, c.rate, c.ok, rate, ok)
+		}
+	}
+
+	if r, err := ParseStatusSampleRates(""); r != nil || err != nil {
+		t.Errorf("expected no rates, got %v, %v", r, err)
+	}
+	for _, opt := range []string{"5xx", "5xx=0", "5xx=x", "6xx=1", "99=1", "fast=1"} {
+		if _, err := ParseStatusSampleRates(opt); err == nil {
+			t.Errorf("%q: expected an error", opt)
+		}
+	}
+}
+
+func TestStatusSampleRatesSample(t *testing.T) {
+	r, err := ParseStatusSampleRates("5xx=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan event.Event, 2)
+	dynamic := make(chan event.Event, 2)
+	out := make(chan event.Event, 2)
+	in <- event.Event{Data: map[string]interface{}{"elb_status_code": int64(502)}}
+	in <- event.Event{Data: map[string]interface{}{"elb_status_code": int64(200)}}
+	close(in)
+	r.sample(in, dynamic, out)
+
+	if len(out) != 1 || len(dynamic) != 1 {
+		t.Fatalf("expected the 5xx to be kept and the 200 sampled dynamically, got %d and %d", len(out), len(dynamic))
+	}
+	if ev := <-out; ev.SampleRate != 1 {
+		t.Errorf("expected the event's sample rate to be set, got %d", ev.SampleRate)
+	}
+	if ev := <-dynamic; ev.Data["elb_status_code"] != int64(200) {
+		t.Errorf("unexpected event %v", ev.Data)
+	}
+	if _, ok := <-dynamic; ok {
+		t.Error("expected the dynamic sampler's channel to be closed")
+	}
+}
@@ -0,0 +1,157 @@
+package publisher
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// Targets come and go with deployments and autoscaling, so look them up again
+// every so often. Lookups which found nothing (e.g. IP targets which aren't
+// instances) are cached all the same, so they aren't retried on every event.
+const targetCacheTTL = 10 * time.Minute
+
+type targetKey struct {
+	sess *session.Session
+	ip   string
+}
+
+type cachedTarget struct {
+	fields  map[string]interface{}
+	expires time.Time
+}
+
+// TargetEnricher adds metadata about the targets requests were sent to, which
+// are only logged by IP and port, using the EC2 API of the account and region
+// of the load balancer the event came from. See --enrich_targets.
+type TargetEnricher struct {
+	*sync.Mutex
+	sessions map[string]*session.Session
+	cache    map[targetKey]cachedTarget
+
+	// describeInstance looks up the instance with the private IP, and
+	// is swapped out in tests.
+	describeInstance func(sess *session.Session, ip string) (*ec2.Instance, error)
+}
+
+func NewTargetEnricher() *TargetEnricher {
+	return &TargetEnricher{
+		Mutex:            &sync.Mutex{},
+		sessions:         make(map[string]*session.Session),
+		cache:            make(map[targetKey]cachedTarget),
+		describeInstance: describeInstance,
+	}
+}
+
+// Add registers the session to look up the targets of the named load
+// balancer with.
+func (e *TargetEnricher) Add(lbName string, sess *session.Session) {
+	e.Lock()
+	defer e.Unlock()
+	e.sessions[lbName] = sess
+}
+
+func describeInstance(sess *session.Session, ip string) (*ec2.Instance, error) {
+	resp, err := ec2.New(sess).DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("private-ip-address"),
+			Values: []*string{aws.String(ip)},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range resp.Reservations {
+		for _, instance := range res.Instances {
+			return instance, nil
+		}
+	}
+	return nil, nil
+}
+
+// lbName returns the name of the load balancer, which ALB and NLB events log
+// as e.g. app/my-lb/1db0c9806095122a.
+func lbName(elb string) string {
+	if parts := strings.Split(elb, "/"); len(parts) == 3 {
+		return parts[1]
+	}
+	return elb
+}
+
+// targetGroupName returns the name of the target group with the ARN, e.g.
+// arn:aws:elasticloadbalancing:region:account:targetgroup/name/id, or "".
+func targetGroupName(arn string) string {
+	if parts := strings.Split(arn, "/"); len(parts) == 3 {
+		return parts[1]
+	}
+	return ""
+}
+
+func (e *TargetEnricher) lookup(sess *session.Session, ip string) map[string]interface{} {
+	key := targetKey{sess, ip}
+	if cached, ok := e.cache[key]; ok && time.Now().Before(cached.expires) {
+		return cached.fields
+	}
+
+	fields := make(map[string]interface{})
+	instance, err := e.describeInstance(sess, ip)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"ip":    ip,
+			"error": err,
+		}).Error("Could not look up target instance")
+	}
+	if instance != nil {
+		fields["instance_id"] = aws.StringValue(instance.InstanceId)
+		if instance.Placement != nil {
+			fields["availability_zone"] = aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+		for _, tag := range instance.Tags {
+			if aws.StringValue(tag.Key) == "Name" {
+				fields["instance_name_tag"] = aws.StringValue(tag.Value)
+			}
+		}
+	}
+
+	e.cache[key] = cachedTarget{fields, time.Now().Add(targetCacheTTL)}
+	return fields
+}
+
+func (e *TargetEnricher) enrich(data map[string]interface{}) {
+	if arn, ok := data["target_group_arn"].(string); ok {
+		if name := targetGroupName(arn); name != "" {
+			data["target_group_name"] = name
+		}
+	}
+
+	elb, _ := data["elb"].(string)
+	authority, _ := data["backend_authority"].(string)
+	ip, _, err := net.SplitHostPort(authority)
+	if elb == "" || err != nil {
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	sess, ok := e.sessions[lbName(elb)]
+	if !ok {
+		return
+	}
+	for k, v := range e.lookup(sess, ip) {
+		data[k] = v
+	}
+}
+
+func (e *TargetEnricher) enrichEvents(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		e.enrich(ev.Data)
+		out <- ev
+	}
+}
@@ -0,0 +1,65 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestTargetEnricher(t *testing.T) {
+	sess := session.Must(session.NewSession())
+	e := NewTargetEnricher()
+	e.Add("foo-alb", sess)
+
+	lookups := 0
+	e.describeInstance = func(s *session.Session, ip string) (*ec2.Instance, error) {
+		lookups++
+		if s != sess || ip != "172.31.21.134" {
+			return nil, nil
+		}
+		return &ec2.Instance{
+			InstanceId: aws.String("i-0123456789abcdef0"),
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			Tags:       []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web-1")}},
+		}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		data := map[string]interface{}{
+			"elb":               "app/foo-alb/1db0c9806095122a",
+			"backend_authority": "172.31.21.134:80",
+			"target_group_arn":  "arn:aws:elasticloadbalancing:us-east-1:729997878290:targetgroup/ec2instances/3bf8bbb3ab2b6080",
+		}
+		e.enrich(data)
+		expected := map[string]interface{}{
+			"elb":               "app/foo-alb/1db0c9806095122a",
+			"backend_authority": "172.31.21.134:80",
+			"target_group_arn":  "arn:aws:elasticloadbalancing:us-east-1:729997878290:targetgroup/ec2instances/3bf8bbb3ab2b6080",
+			"target_group_name": "ec2instances",
+			"instance_id":       "i-0123456789abcdef0",
+			"availability_zone": "us-east-1a",
+			"instance_name_tag": "web-1",
+		}
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("Output did not match expected: got %v, want %v", data, expected)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected the target to be looked up once and cached, got %d lookups", lookups)
+	}
+
+	// Load balancers which weren't added, and targets which aren't
+	// instances, are left alone
+	for _, data := range []map[string]interface{}{
+		{"elb": "app/bar-alb/1db0c9806095122a", "backend_authority": "172.31.21.134:80"},
+		{"elb": "app/foo-alb/1db0c9806095122a", "backend_authority": "10.0.0.1:80"},
+	} {
+		e.enrich(data)
+		if _, ok := data["instance_id"]; ok {
+			t.Errorf("Expected no instance to be found for %v", data)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package publisher
+
+import (
+	"time"
+
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	originalTimestampField = "original_timestamp"
+	// Events outside the window are warned about at most this often.
+	timestampWarnInterval = time.Minute
+)
+
+// timestampWindow checks that events' timestamps, taken from the logs, are
+// within the window Honeycomb accepts them in, which is bounded by the
+// dataset's retention in the past and by clock skew in the future. Events
+// outside it are otherwise rejected without the agent hearing of it, e.g. when
+// backfilling from an old inventory, so they're counted and warned about, and
+// with --restamp sent with the time they're published instead.
+type timestampWindow struct {
+	maxAge  time.Duration
+	maxSkew time.Duration
+	restamp bool
+	now     func() time.Time
+
+	past, future int
+	lastWarned   time.Time
+}
+
+func newTimestampWindow(opt *options.Options) *timestampWindow {
+	return &timestampWindow{
+		maxAge:  time.Duration(opt.MaxEventAgeHr) * time.Hour,
+		maxSkew: time.Duration(opt.MaxEventSkew) * time.Second,
+		restamp: opt.Restamp,
+		now:     time.Now,
+	}
+}
+
+// check counts the event if its timestamp is outside the window, and
+// restamps it if enabled, keeping its timestamp as original_timestamp.
+func (w *timestampWindow) check(ev *event.Event) {
+	if w == nil || ev.Timestamp.IsZero() {
+		return
+	}
+	now := w.now()
+
+	switch {
+	case w.maxAge > 0 && ev.Timestamp.Before(now.Add(-w.maxAge)):
+		w.past++
+		metrics.EventsOutOfWindow.WithLabelValues("past").Inc()
+	case w.maxSkew > 0 && ev.Timestamp.After(now.Add(w.maxSkew)):
+		w.future++
+		metrics.EventsOutOfWindow.WithLabelValues("future").Inc()
+	default:
+		return
+	}
+
+	if w.restamp {
+		ev.Data[originalTimestampField] = ev.Timestamp.UTC().Format(time.RFC3339Nano)
+		ev.Timestamp = now
+	}
+
+	if now.Sub(w.lastWarned) >= timestampWarnInterval {
+		msg := "Events have timestamps outside of the window Honeycomb accepts, and may be dropped; pass --restamp to send them with the current time"
+		if w.restamp {
+			msg = "Events have timestamps outside of the window Honeycomb accepts, and were sent with the current time and their own as original_timestamp"
+		}
+		logrus.WithFields(logrus.Fields{
+			"too_old":       w.past,
+			"in_the_future": w.future,
+			"max_age":       w.maxAge,
+			"max_skew":      w.maxSkew,
+		}).Warn(msg)
+		w.past, w.future = 0, 0
+		w.lastWarned = now
+	}
+}
+
+// keepTimeRange passes on the events from between start and end only, since
+// the objects with logs from the range have logs from either side of it too.
+func keepTimeRange(in <-chan event.Event, out chan<- event.Event, start, end time.Time) {
+	for ev := range in {
+		if ev.Timestamp.Before(start) || !ev.Timestamp.Before(end) {
+			discard(ev)
+			continue
+		}
+		out <- ev
+	}
+	close(out)
+}
@@ -0,0 +1,70 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/metrics"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTimestampWindow(t *testing.T) {
+	now := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	old := now.Add(-61 * 24 * time.Hour)
+
+	w := newTimestampWindow(&options.Options{MaxEventAgeHr: 1440, MaxEventSkew: 300})
+	w.now = func() time.Time { return now }
+
+	past := testutil.ToFloat64(metrics.EventsOutOfWindow.WithLabelValues("past"))
+	future := testutil.ToFloat64(metrics.EventsOutOfWindow.WithLabelValues("future"))
+
+	for _, ts := range []time.Time{now.Add(-time.Hour), now.Add(time.Minute), old, now.Add(time.Hour)} {
+		ev := event.Event{Timestamp: ts, Data: map[string]interface{}{}}
+		w.check(&ev)
+		if !ev.Timestamp.Equal(ts) || ev.Data[originalTimestampField] != nil {
+			t.Errorf("expected %v not to be restamped without --restamp", ts)
+		}
+	}
+	if n := testutil.ToFloat64(metrics.EventsOutOfWindow.WithLabelValues("past")) - past; n != 1 {
+		t.Errorf("expected 1 event too old, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.EventsOutOfWindow.WithLabelValues("future")) - future; n != 1 {
+		t.Errorf("expected 1 event in the future, got %v", n)
+	}
+
+	w.restamp = true
+	ev := event.Event{Timestamp: old, Data: map[string]interface{}{}}
+	w.check(&ev)
+	if !ev.Timestamp.Equal(now) || ev.Data[originalTimestampField] != old.Format(time.RFC3339Nano) {
+		t.Errorf("expected the event to be restamped, got %v %v", ev.Timestamp, ev.Data)
+	}
+
+	ev = event.Event{Timestamp: old, Data: map[string]interface{}{}}
+	newTimestampWindow(&options.Options{}).check(&ev)
+	if !ev.Timestamp.Equal(old) {
+		t.Error("expected no window to allow any timestamp")
+	}
+}
+
+func TestKeepTimeRange(t *testing.T) {
+	start := time.Date(2018, 8, 20, 14, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	in := make(chan event.Event, 4)
+	for _, ts := range []time.Time{start.Add(-time.Second), start, end.Add(-time.Second), end} {
+		in <- event.Event{Timestamp: ts, Data: map[string]interface{}{}}
+	}
+	close(in)
+	out := make(chan event.Event, 4)
+	keepTimeRange(in, out, start, end)
+
+	var kept []time.Time
+	for ev := range out {
+		kept = append(kept, ev.Timestamp)
+	}
+	if len(kept) != 2 || !kept[0].Equal(start) || !kept[1].Equal(end.Add(-time.Second)) {
+		t.Errorf("expected the events from 14:00 up to 16:00, got %v", kept)
+	}
+}
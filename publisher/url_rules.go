@@ -0,0 +1,217 @@
+package publisher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/honeycombio/urlshaper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const redacted = "REDACTED"
+
+// URLRules scrub and normalize the URLs in events before they're sent to
+// Honeycomb, as configured in the --url_rules YAML file, e.g.
+//
+//	path_patterns:
+//	  - /users/:id
+//	path_rules:
+//	  - match: /users/[0-9]+
+//	    replace: /users/:id
+//	query_allowlist: [page, sort, token]
+//	redact_params: [token]
+//	redact_patterns:
+//	  - "[0-9]{16}"
+//	drop_client_ip: true
+//
+// Path patterns are used for request_shape (and extract request_path_id,
+// etc.), the other rules change the URLs themselves.
+type URLRules struct {
+	// PathPatterns are urlshaper patterns for shaping paths.
+	PathPatterns []string `yaml:"path_patterns"`
+
+	// PathRules replace each match of a regex in the path, in order.
+	PathRules []PathRule `yaml:"path_rules"`
+
+	// QueryAllowlist, if set, drops every other query parameter.
+	QueryAllowlist []string `yaml:"query_allowlist"`
+
+	// RedactParams have their values replaced with REDACTED.
+	RedactParams []string `yaml:"redact_params"`
+
+	// RedactPatterns are regexes replaced with REDACTED anywhere in the
+	// URL, after the other rules have been applied.
+	RedactPatterns []string `yaml:"redact_patterns"`
+
+	// DropClientIP removes the client's IP from events, after it has been
+	// used for request_fingerprint.
+	DropClientIP bool `yaml:"drop_client_ip"`
+
+	pathRules      []*regexp.Regexp
+	allow, redact  map[string]bool
+	redactPatterns []*regexp.Regexp
+}
+
+type PathRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// LoadURLRules reads the rules from a YAML file, returning nil if no file is
+// given.
+func LoadURLRules(filename string) (*URLRules, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading URL rules file: %s", err)
+	}
+
+	rules := &URLRules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, fmt.Errorf("Error parsing URL rules file: %s", err)
+	}
+	if err := rules.compile(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (r *URLRules) compile() error {
+	for _, rule := range r.PathRules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return fmt.Errorf("Invalid path rule %q: %s", rule.Match, err)
+		}
+		r.pathRules = append(r.pathRules, re)
+	}
+	for _, pat := range r.RedactPatterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return fmt.Errorf("Invalid redact pattern %q: %s", pat, err)
+		}
+		r.redactPatterns = append(r.redactPatterns, re)
+	}
+
+	r.allow = make(map[string]bool)
+	for _, p := range r.QueryAllowlist {
+		r.allow[p] = true
+	}
+	r.redact = make(map[string]bool)
+	for _, p := range r.RedactParams {
+		r.redact[p] = true
+	}
+
+	return nil
+}
+
+func (r *URLRules) parser() *urlshaper.Parser {
+	pr := &urlshaper.Parser{}
+	if r == nil {
+		return pr
+	}
+	for _, pat := range r.PathPatterns {
+		pr.Patterns = append(pr.Patterns, &urlshaper.Pattern{Pat: pat})
+	}
+	return pr
+}
+
+// Path applies the path rules to a path.
+func (r *URLRules) Path(path string) string {
+	for i, re := range r.pathRules {
+		path = re.ReplaceAllString(path, r.PathRules[i].Replace)
+	}
+	return path
+}
+
+// Query applies the allowlist and redaction to a raw query string, without
+// otherwise changing it.
+func (r *URLRules) Query(query string) string {
+	if query == "" {
+		return query
+	}
+
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		rawName := param
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			rawName = param[:i]
+		}
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+
+		if len(r.allow) > 0 && !r.allow[name] {
+			continue
+		}
+		if r.redact[name] {
+			param = rawName + "=" + redacted
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
+
+func (r *URLRules) redactPatternsIn(s string) string {
+	for _, re := range r.redactPatterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// URI applies all of the rules to a URI, which may be a path or a full URL as
+// in ALB logs (e.g. http://example.com:80/users/123?a=b).
+func (r *URLRules) URI(uri string) string {
+	prefix, rest := "", uri
+	if i := strings.Index(uri, "://"); i >= 0 {
+		if j := strings.IndexByte(uri[i+3:], '/'); j >= 0 {
+			prefix, rest = uri[:i+3+j], uri[i+3+j:]
+		} else {
+			prefix, rest = uri, ""
+		}
+	}
+
+	path, query := rest, ""
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		path, query = rest[:i], rest[i+1:]
+	}
+
+	uri = prefix + r.Path(path)
+	if query = r.Query(query); query != "" {
+		uri += "?" + query
+	}
+	return r.redactPatternsIn(uri)
+}
+
+// scrubCloudFront applies the rules to the path and query fields of
+// CloudFront events, which aren't shaped.
+func (r *URLRules) scrubCloudFront(data map[string]interface{}) {
+	if stem, ok := data["cs_uri_stem"].(string); ok {
+		data["cs_uri_stem"] = r.redactPatternsIn(r.Path(stem))
+	}
+	if query, ok := data["cs_uri_query"].(string); ok {
+		if query = r.redactPatternsIn(r.Query(query)); query != "" {
+			data["cs_uri_query"] = query
+		} else {
+			delete(data, "cs_uri_query")
+		}
+	}
+}
+
+// scrubClientIP drops the fields with the client's IP in them, if configured
+// to.
+func (r *URLRules) scrubClientIP(data map[string]interface{}) {
+	if !r.DropClientIP {
+		return
+	}
+	for _, field := range []string{"client_authority", "c_ip", "client_ip", "x_forwarded_for"} {
+		delete(data, field)
+	}
+}
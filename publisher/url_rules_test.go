@@ -0,0 +1,100 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func loadTestURLRules(t *testing.T, config string) *URLRules {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write([]byte(config)); err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	f.Close()
+
+	rules, err := LoadURLRules(f.Name())
+	if err != nil {
+		t.Fatal("Shouldn't have err but did: ", err)
+	}
+	return rules
+}
+
+func TestURLRules(t *testing.T) {
+	rules := loadTestURLRules(t, `
+path_patterns:
+  - /users/:id
+path_rules:
+  - match: /orders/[0-9a-f-]{36}
+    replace: /orders/:uuid
+query_allowlist: [page, token]
+redact_params: [token]
+redact_patterns:
+  - "[0-9]{16}"
+`)
+
+	testCases := []struct {
+		uri, expected string
+	}{
+		{"/orders/8f14e45f-ceea-467f-a0e6-2f3c4b1a7e3d/items", "/orders/:uuid/items"},
+		{"http://example.com:80/orders/8f14e45f-ceea-467f-a0e6-2f3c4b1a7e3d?page=2&session=abc", "http://example.com:80/orders/:uuid?page=2"},
+		{"/login?token=secret&page=1", "/login?token=REDACTED&page=1"},
+		{"/cards/4111111111111111", "/cards/REDACTED"},
+		{"/search?q=foo", "/search"},
+		{"https://example.com", "https://example.com"},
+	}
+	for _, tc := range testCases {
+		if uri := rules.URI(tc.uri); uri != tc.expected {
+			t.Errorf("Expected %q to be scrubbed to %q, got %q", tc.uri, tc.expected, uri)
+		}
+	}
+
+	// The original request field is scrubbed too, and the path patterns
+	// are used for shaping
+	shaper := requestShaper{rules.parser(), rules, false}
+	ev := event.Event{Data: map[string]interface{}{
+		"request": "GET http://example.com:80/users/42?token=secret HTTP/1.1",
+	}}
+	shaper.Shape("request", &ev)
+	expected := map[string]interface{}{
+		"request":                  "GET http://example.com:80/users/42?token=REDACTED HTTP/1.1",
+		"request_method":           "GET",
+		"request_protocol_version": "HTTP/1.1",
+		"request_uri":              "http://example.com:80/users/42?token=REDACTED",
+		"request_path":             "/users/42",
+		"request_query":            "token=REDACTED",
+		"request_shape":            "/users/:id?token=?",
+		"request_queryshape":       "token=?",
+		"request_path_id":          "42",
+	}
+	if !reflect.DeepEqual(ev.Data, expected) {
+		t.Errorf("Output did not match expected: got %v, want %v", ev.Data, expected)
+	}
+}
+
+func TestURLRulesCloudFront(t *testing.T) {
+	rules := loadTestURLRules(t, `
+query_allowlist: [page]
+drop_client_ip: true
+`)
+	data := map[string]interface{}{
+		"cs_uri_stem":  "/index.html",
+		"cs_uri_query": "session=abc",
+		"c_ip":         "192.0.2.10",
+	}
+	rules.scrubCloudFront(data)
+	if _, ok := data["cs_uri_query"]; ok {
+		t.Errorf("Expected query with no allowed parameters to be dropped, got %v", data)
+	}
+	rules.scrubClientIP(data)
+	if _, ok := data["c_ip"]; ok {
+		t.Errorf("Expected client IP to be dropped, got %v", data)
+	}
+}
@@ -0,0 +1,177 @@
+package publisher
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Operating systems are recognized by the first of these tokens in the user
+// agent, in this order since e.g. Android also claims to be Linux, and iOS
+// to be like Mac OS X.
+var userAgentOSes = []struct {
+	token, os string
+}{
+	{"windows phone", "Windows Phone"},
+	{"windows", "Windows"},
+	{"android", "Android"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"cros", "Chrome OS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+// Crawlers, monitors and headless browsers have one of these in their user
+// agent, e.g. Googlebot/2.1.
+var botTokens = []string{
+	"bot", "crawl", "spider", "slurp", "facebookexternalhit", "mediapartners-google",
+	"headlesschrome", "lighthouse", "pingdom",
+}
+
+// userAgent is what can be told about a client from its user agent.
+type userAgent struct {
+	browser, version, os, deviceType string
+	bot                              bool
+}
+
+// productVersion returns the version following the token, e.g. 91.0.4472.124
+// for chrome/ in ... Chrome/91.0.4472.124 Safari/537.36, where lower is the
+// lowercased user agent.
+func productVersion(ua, lower, token string) string {
+	i := strings.Index(lower, token)
+	if i < 0 {
+		return ""
+	}
+	version := ua[i+len(token):]
+	if end := strings.IndexAny(version, " ;)"); end >= 0 {
+		version = version[:end]
+	}
+	return version
+}
+
+// botProduct returns the name and version of the product in the user agent
+// which is a bot, e.g. Googlebot and 2.1 for
+// Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html).
+func botProduct(ua string) (string, string) {
+	for _, product := range strings.FieldsFunc(ua, func(r rune) bool {
+		return r == ' ' || r == ';' || r == '(' || r == ')'
+	}) {
+		if strings.HasPrefix(product, "+") || strings.Contains(product, "://") {
+			// a link to the bot's documentation
+			continue
+		}
+		lower := strings.ToLower(product)
+		for _, token := range botTokens {
+			if strings.Contains(lower, token) {
+				parts := strings.SplitN(product, "/", 2)
+				if len(parts) == 2 {
+					return parts[0], parts[1]
+				}
+				return parts[0], ""
+			}
+		}
+	}
+	return "", ""
+}
+
+// parseUserAgent picks the browser, its version, the OS and the kind of
+// device out of a user agent. It only knows the common browsers and OSes,
+// leaving what it can't tell empty.
+func parseUserAgent(ua string) userAgent {
+	// CloudFront logs user agents URL encoded
+	if decoded, err := url.PathUnescape(ua); err == nil {
+		ua = decoded
+	}
+	lower := strings.ToLower(ua)
+
+	var parsed userAgent
+	for _, token := range botTokens {
+		if strings.Contains(lower, token) {
+			parsed.bot = true
+			break
+		}
+	}
+
+	for _, f := range userAgentFamilies {
+		if strings.Contains(lower, f.token) {
+			parsed.browser = f.family
+			switch {
+			case f.family == "Safari":
+				// Safari/605.1.15 is the WebKit build
+				parsed.version = productVersion(ua, lower, "version/")
+			case f.token == "trident/":
+				parsed.version = productVersion(ua, lower, "rv:")
+			default:
+				parsed.version = productVersion(ua, lower, f.token)
+			}
+			break
+		}
+	}
+	if parsed.bot {
+		if name, version := botProduct(ua); name != "" {
+			parsed.browser, parsed.version = name, version
+		}
+	}
+	if parsed.browser == "" {
+		// e.g. curl/7.64.1
+		if product := strings.Fields(ua); len(product) > 0 {
+			parts := strings.SplitN(product[0], "/", 2)
+			parsed.browser = parts[0]
+			if len(parts) == 2 {
+				parsed.version = parts[1]
+			}
+		}
+	}
+
+	for _, o := range userAgentOSes {
+		if strings.Contains(lower, o.token) {
+			parsed.os = o.os
+			break
+		}
+	}
+
+	switch {
+	case parsed.bot:
+		parsed.deviceType = "bot"
+	case strings.Contains(lower, "ipad") || strings.Contains(lower, "tablet") ||
+		parsed.os == "Android" && !strings.Contains(lower, "mobile"):
+		parsed.deviceType = "tablet"
+	case strings.Contains(lower, "mobi") || strings.Contains(lower, "iphone") ||
+		strings.Contains(lower, "ipod") || parsed.os == "Windows Phone":
+		parsed.deviceType = "mobile"
+	case parsed.os != "":
+		parsed.deviceType = "desktop"
+	default:
+		parsed.deviceType = "other"
+	}
+	return parsed
+}
+
+// addUserAgentFields adds ua_browser, ua_browser_version, ua_os,
+// ua_device_type and ua_is_bot, parsed from the user agent of ELB, ALB and
+// CloudFront events.
+func addUserAgentFields(data map[string]interface{}) {
+	ua, _ := data["user_agent"].(string)
+	if ua == "" {
+		ua, _ = data["cs_user_agent"].(string)
+	}
+	// logged as - when there wasn't one
+	if ua == "" || ua == "-" {
+		return
+	}
+
+	parsed := parseUserAgent(ua)
+	for field, value := range map[string]string{
+		"ua_browser":         parsed.browser,
+		"ua_browser_version": parsed.version,
+		"ua_os":              parsed.os,
+		"ua_device_type":     parsed.deviceType,
+	} {
+		if value != "" {
+			data[field] = value
+		}
+	}
+	data["ua_is_bot"] = parsed.bot
+}
@@ -0,0 +1,52 @@
+package publisher
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	testCases := []struct {
+		ua       string
+		expected userAgent
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+			userAgent{"Chrome", "91.0.4472.124", "Windows", "desktop", false}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 14_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Mobile/15E148 Safari/604.1",
+			userAgent{"Safari", "14.1.1", "iOS", "mobile", false}},
+		{"Mozilla/5.0 (iPad; CPU OS 14_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/91.0.4472.80 Mobile/15E148 Safari/604.1",
+			userAgent{"Chrome", "91.0.4472.80", "iOS", "tablet", false}},
+		{"Mozilla/5.0 (Linux; Android 11; SM-T870) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.120 Safari/537.36",
+			userAgent{"Chrome", "91.0.4472.120", "Android", "tablet", false}},
+		{"Mozilla/5.0 (Linux; Android 11; Pixel 5) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.120 Mobile Safari/537.36",
+			userAgent{"Chrome", "91.0.4472.120", "Android", "mobile", false}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:89.0) Gecko/20100101 Firefox/89.0",
+			userAgent{"Firefox", "89.0", "macOS", "desktop", false}},
+		{"Mozilla/5.0 (Windows NT 10.0; Trident/7.0; rv:11.0) like Gecko",
+			userAgent{"IE", "11.0", "Windows", "desktop", false}},
+		{"Mozilla/5.0%20(compatible;%20Googlebot/2.1;%20+http://www.google.com/bot.html)",
+			userAgent{"Googlebot", "2.1", "", "bot", true}},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/91.0.4472.114 Safari/537.36",
+			userAgent{"HeadlessChrome", "91.0.4472.114", "Linux", "bot", true}},
+		{"curl/7.64.1", userAgent{"curl", "7.64.1", "", "other", false}},
+	}
+	for _, tc := range testCases {
+		if parsed := parseUserAgent(tc.ua); parsed != tc.expected {
+			t.Errorf("Expected %q to be parsed as %+v, got %+v", tc.ua, tc.expected, parsed)
+		}
+	}
+}
+
+func TestAddUserAgentFields(t *testing.T) {
+	data := map[string]interface{}{"cs_user_agent": "curl/7.64.1"}
+	addUserAgentFields(data)
+	if data["ua_browser"] != "curl" || data["ua_is_bot"] != false || data["ua_device_type"] != "other" {
+		t.Errorf("unexpected fields %v", data)
+	}
+	if _, ok := data["ua_os"]; ok {
+		t.Errorf("expected an unknown OS to be left out, got %v", data["ua_os"])
+	}
+
+	data = map[string]interface{}{"user_agent": "-"}
+	addUserAgentFields(data)
+	if len(data) != 1 {
+		t.Errorf("expected no fields without a user agent, got %v", data)
+	}
+}
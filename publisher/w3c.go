@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// w3cFieldsDirective declares the columns of the lines after it in a W3C
+// extended log format object, e.g. CloudFront's standard logs.
+const w3cFieldsDirective = "#Fields:"
+
+// w3cSchema is the columns of a W3C extended log format object, as declared
+// by its #Fields directive. It's built per object (and rebuilt if an object
+// declares its fields again part way through), so lines are parsed by the
+// names of their columns rather than their positions: columns AWS adds are
+// picked up, ones it removes are left out, and a line that doesn't have the
+// declared columns is unparseable instead of being misaligned.
+type w3cSchema struct {
+	fields []string
+
+	// dateCol and timeCol are the indexes of the date and time columns,
+	// which are joined into the event's timestamp, or -1 if there aren't
+	// any.
+	dateCol, timeCol int
+}
+
+// parseW3CFields builds the schema declared by a #Fields directive. Fields
+// are named the way the nginx parser names the columns of the fixed formats:
+// lower case, with dashes and parentheses replaced, e.g. cs(User-Agent) is
+// cs_user_agent.
+func parseW3CFields(directive string) *w3cSchema {
+	s := &w3cSchema{dateCol: -1, timeCol: -1}
+	for i, f := range strings.Fields(strings.TrimPrefix(directive, w3cFieldsDirective)) {
+		f = strings.ToLower(f)
+		switch f {
+		case "date":
+			s.dateCol = i
+		case "time":
+			s.timeCol = i
+		}
+		f = strings.Replace(f, "(", "_", -1)
+		f = strings.Replace(f, ")", "", -1)
+		s.fields = append(s.fields, strings.Replace(f, "-", "_", -1))
+	}
+	return s
+}
+
+// parse returns the fields of the line, typed the way the nginx parser types
+// them, and its timestamp, which is the zero time if the line has no date and
+// time. Fields are separated by tabs or spaces; ones without a value are "-",
+// and are left out.
+func (s *w3cSchema) parse(line, timeFormat string) (map[string]interface{}, time.Time, error) {
+	vals := strings.Fields(line)
+	if len(vals) != len(s.fields) {
+		return nil, time.Time{}, fmt.Errorf("line has %d fields, but the #Fields directive declares %d", len(vals), len(s.fields))
+	}
+
+	var ts time.Time
+	hasTime := s.dateCol >= 0 && s.timeCol >= 0
+	if hasTime {
+		t, err := time.Parse(timeFormat, vals[s.dateCol]+"T"+vals[s.timeCol])
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		ts = t
+	}
+	data := make(map[string]interface{}, len(vals))
+	for i, val := range vals {
+		if hasTime && (i == s.dateCol || i == s.timeCol) {
+			continue
+		}
+		if v, ok := typedValue(val); ok {
+			data[s.fields[i]] = v
+		}
+	}
+	return data, ts, nil
+}
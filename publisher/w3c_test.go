@@ -0,0 +1,46 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseW3CFields(t *testing.T) {
+	s := parseW3CFields("#Fields: date time x-edge-location cs(User-Agent) c-port time-to-first-byte")
+	expected := []string{"date", "time", "x_edge_location", "cs_user_agent", "c_port", "time_to_first_byte"}
+	if !reflect.DeepEqual(s.fields, expected) || s.dateCol != 0 || s.timeCol != 1 {
+		t.Errorf("expected %v with date and time first, got %+v", expected, s)
+	}
+}
+
+func TestW3CSchemaParse(t *testing.T) {
+	// columns can be in any order, including the date and time
+	s := parseW3CFields("#Fields: x-edge-location time sc-status date time-taken fle-status")
+	data, ts, err := s.parse("FRA2\t01:13:11\t200\t2014-05-23\t0.001\t-", cloudFrontTimeFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"x_edge_location": "FRA2",
+		"sc_status":       int64(200),
+		"time_taken":      0.001,
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %v, got %v", expected, data)
+	}
+	if !ts.Equal(time.Date(2014, 5, 23, 1, 13, 11, 0, time.UTC)) {
+		t.Errorf("expected the date and time to be the timestamp, got %v", ts)
+	}
+
+	// a line that doesn't match its header isn't misaligned
+	if _, _, err := s.parse("FRA2\t01:13:11\t200\t2014-05-23\t0.001", cloudFrontTimeFormat); err == nil {
+		t.Error("expected a line missing a column to be an error")
+	}
+
+	// without a date and time the timestamp is left to the caller
+	s = parseW3CFields("#Fields: c-ip sc-status")
+	if data, ts, err := s.parse("192.0.2.10 404", cloudFrontTimeFormat); err != nil || !ts.IsZero() || data["sc_status"] != int64(404) {
+		t.Errorf("unexpected %v, %v, %v", data, ts, err)
+	}
+}
@@ -0,0 +1,279 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeyaws/logbucket"
+	"github.com/honeycombio/honeyaws/options"
+	"github.com/honeycombio/honeyaws/sampler"
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+	"github.com/sirupsen/logrus"
+)
+
+// WAF log records can have a lot of headers and rule matches in them, more
+// than fit in bufio.Scanner's default buffer.
+const maxWAFRecord = 1024 * 1024
+
+type WAFRuleMatch struct {
+	ConditionType    string   `json:"conditionType"`
+	SensitivityLevel string   `json:"sensitivityLevel"`
+	Location         string   `json:"location"`
+	MatchedData      []string `json:"matchedData"`
+}
+
+type WAFRule struct {
+	RuleID           string         `json:"ruleId"`
+	Action           string         `json:"action"`
+	RuleMatchDetails []WAFRuleMatch `json:"ruleMatchDetails"`
+}
+
+type WAFRuleGroup struct {
+	RuleGroupID                 string    `json:"ruleGroupId"`
+	TerminatingRule             *WAFRule  `json:"terminatingRule"`
+	NonTerminatingMatchingRules []WAFRule `json:"nonTerminatingMatchingRules"`
+}
+
+type WAFRateBasedRule struct {
+	RateBasedRuleID   string `json:"rateBasedRuleId"`
+	RateBasedRuleName string `json:"rateBasedRuleName"`
+	LimitKey          string `json:"limitKey"`
+	MaxRateAllowed    int64  `json:"maxRateAllowed"`
+}
+
+type WAFHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type WAFHTTPRequest struct {
+	ClientIP    string      `json:"clientIp"`
+	Country     string      `json:"country"`
+	Headers     []WAFHeader `json:"headers"`
+	URI         string      `json:"uri"`
+	Args        string      `json:"args"`
+	HTTPVersion string      `json:"httpVersion"`
+	HTTPMethod  string      `json:"httpMethod"`
+	RequestID   string      `json:"requestId"`
+}
+
+type WAFLabel struct {
+	Name string `json:"name"`
+}
+
+type WAFChallengeResponse struct {
+	ResponseCode   int64  `json:"responseCode"`
+	SolveTimestamp int64  `json:"solveTimestamp"`
+	FailureReason  string `json:"failureReason"`
+}
+
+// WAFRecord is one request in a WAF log, see
+// https://docs.aws.amazon.com/waf/latest/developerguide/logging-fields.html
+type WAFRecord struct {
+	Timestamp                   int64                 `json:"timestamp"`
+	FormatVersion               int64                 `json:"formatVersion"`
+	WebACLID                    string                `json:"webaclId"`
+	TerminatingRuleID           string                `json:"terminatingRuleId"`
+	TerminatingRuleType         string                `json:"terminatingRuleType"`
+	Action                      string                `json:"action"`
+	TerminatingRuleMatchDetails []WAFRuleMatch        `json:"terminatingRuleMatchDetails"`
+	HTTPSourceName              string                `json:"httpSourceName"`
+	HTTPSourceID                string                `json:"httpSourceId"`
+	RuleGroupList               []WAFRuleGroup        `json:"ruleGroupList"`
+	RateBasedRuleList           []WAFRateBasedRule    `json:"rateBasedRuleList"`
+	NonTerminatingMatchingRules []WAFRule             `json:"nonTerminatingMatchingRules"`
+	ResponseCodeSent            *int64                `json:"responseCodeSent"`
+	HTTPRequest                 WAFHTTPRequest        `json:"httpRequest"`
+	Labels                      []WAFLabel            `json:"labels"`
+	CaptchaResponse             *WAFChallengeResponse `json:"captchaResponse"`
+	ChallengeResponse           *WAFChallengeResponse `json:"challengeResponse"`
+	JA3Fingerprint              string                `json:"ja3Fingerprint"`
+}
+
+type WAFEventParser struct {
+	dynamicSampling
+}
+
+func NewWAFEventParser(opt *options.Options) *WAFEventParser {
+	return &WAFEventParser{dynamicSampling: newDynamicSampling(opt)}
+}
+
+// webACLName returns the name of the web ACL from its ARN, e.g.
+// arn:aws:wafv2:us-east-1:12345:regional/webacl/my-acl/a1b2c3.
+func webACLName(arn string) string {
+	parts := strings.Split(arn, "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// addRuleMatches adds the conditions the rule matched on, where they matched
+// and what, comma separated, under the prefix.
+func addRuleMatches(data map[string]interface{}, prefix string, matches []WAFRuleMatch) {
+	var conditions, locations, matched []string
+	for _, m := range matches {
+		conditions = append(conditions, m.ConditionType)
+		locations = append(locations, m.Location)
+		matched = append(matched, strings.Join(m.MatchedData, " "))
+	}
+	if len(matches) > 0 {
+		data[prefix+"_condition_types"] = strings.Join(conditions, ",")
+		data[prefix+"_locations"] = strings.Join(locations, ",")
+		data[prefix+"_matched_data"] = strings.Join(matched, ",")
+	}
+}
+
+func addChallengeResponse(data map[string]interface{}, prefix string, r *WAFChallengeResponse) {
+	if r == nil {
+		return
+	}
+	data[prefix+"_response_code"] = r.ResponseCode
+	if r.FailureReason != "" {
+		data[prefix+"_failure_reason"] = r.FailureReason
+	}
+}
+
+// flattenWAFRecord flattens the record into fields, joining the rules which
+// matched it into comma separated lists. The request is in the fields ALB
+// events have it in, with the headers as request.headers.*, so that it's
+// shaped like theirs, and blocked requests can be found by the same queries.
+func flattenWAFRecord(r *WAFRecord) map[string]interface{} {
+	data := map[string]interface{}{
+		"web_acl_id":            r.WebACLID,
+		"web_acl_name":          webACLName(r.WebACLID),
+		"action":                r.Action,
+		"terminating_rule_id":   r.TerminatingRuleID,
+		"terminating_rule_type": r.TerminatingRuleType,
+		"http_source_name":      r.HTTPSourceName,
+		"http_source_id":        r.HTTPSourceID,
+		"format_version":        r.FormatVersion,
+		"client_ip":             r.HTTPRequest.ClientIP,
+		"country":               r.HTTPRequest.Country,
+		"request_id":            r.HTTPRequest.RequestID,
+	}
+	addRuleMatches(data, "terminating_rule_match", r.TerminatingRuleMatchDetails)
+	if r.ResponseCodeSent != nil {
+		data["response_code_sent"] = *r.ResponseCodeSent
+	}
+	if r.JA3Fingerprint != "" {
+		data["ja3_fingerprint"] = r.JA3Fingerprint
+	}
+	addChallengeResponse(data, "captcha", r.CaptchaResponse)
+	addChallengeResponse(data, "challenge", r.ChallengeResponse)
+
+	// Rules which matched without terminating, e.g. COUNT rules, both of
+	// the web ACL and of its rule groups.
+	var nonTerminating []string
+	for _, rule := range r.NonTerminatingMatchingRules {
+		nonTerminating = append(nonTerminating, rule.RuleID)
+	}
+	for _, group := range r.RuleGroupList {
+		if group.TerminatingRule != nil {
+			data["rule_group_id"] = group.RuleGroupID
+			data["rule_group_terminating_rule_id"] = group.TerminatingRule.RuleID
+			data["rule_group_terminating_rule_action"] = group.TerminatingRule.Action
+			addRuleMatches(data, "rule_group_terminating_rule_match", group.TerminatingRule.RuleMatchDetails)
+		}
+		for _, rule := range group.NonTerminatingMatchingRules {
+			nonTerminating = append(nonTerminating, rule.RuleID)
+		}
+	}
+	if len(nonTerminating) > 0 {
+		data["non_terminating_rule_ids"] = strings.Join(nonTerminating, ",")
+	}
+	var rateBased []string
+	for _, rule := range r.RateBasedRuleList {
+		rateBased = append(rateBased, rule.RateBasedRuleName)
+	}
+	if len(rateBased) > 0 {
+		data["rate_based_rule_names"] = strings.Join(rateBased, ",")
+	}
+	var labels []string
+	for _, label := range r.Labels {
+		labels = append(labels, label.Name)
+	}
+	if len(labels) > 0 {
+		data["labels"] = strings.Join(labels, ",")
+	}
+
+	uri := r.HTTPRequest.URI
+	if r.HTTPRequest.Args != "" {
+		uri += "?" + r.HTTPRequest.Args
+	}
+	data["request"] = strings.Join([]string{r.HTTPRequest.HTTPMethod, uri, r.HTTPRequest.HTTPVersion}, " ")
+	for _, header := range r.HTTPRequest.Headers {
+		name := strings.ToLower(header.Name)
+		data["request.headers."+name] = header.Value
+		if name == "user-agent" {
+			data["user_agent"] = header.Value
+		}
+	}
+	return data
+}
+
+func (ep *WAFEventParser) ParseEvents(obj state.DownloadedObject, out chan<- event.Event) error {
+	r, err := logbucket.Open(obj)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	scanner := newLineScanner(obj, r)
+	scanner.Buffer(nil, maxWAFRecord)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var record WAFRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"object": obj.Object,
+				"line":   scanner.lines,
+				"err":    err,
+			}).Debug("Could not parse WAF log record")
+			scanner.unparseable(line, err)
+			continue
+		}
+
+		out <- event.Event{
+			Timestamp: time.Unix(0, record.Timestamp*int64(time.Millisecond)),
+			Data:      flattenWAFRecord(&record),
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (ep *WAFEventParser) DynSample(in <-chan event.Event, out chan<- event.Event) {
+	for ev := range in {
+		// Blocked requests, and the rules blocking them, are rare and
+		// interesting next to the bulk of allowed traffic, so use the
+		// action and terminating rule to set the sample rate.
+		key := fmt.Sprintf("%v_%v", ev.Data["action"], ev.Data["terminating_rule_id"])
+
+		// Keys configured with --dynsample_keys replace the defaults
+		if keys := ep.sampler.Keys(); len(keys) > 0 {
+			key = sampler.Key(ev.Data, keys)
+		}
+
+		rate := ep.sampler.GetSampleRate(key)
+		if rate <= 0 {
+			logrus.WithField("rate", rate).Error("Sample should not be less than zero")
+			rate = 1
+		}
+		if rand.Intn(rate) == 0 {
+			ev.SampleRate = rate
+			out <- ev
+		} else {
+			discard(ev)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeyaws/state"
+	"github.com/honeycombio/honeytail/event"
+)
+
+const wafRecord = `{"timestamp":1576280412771,"formatVersion":1,"webaclId":"arn:aws:wafv2:ap-southeast-2:111122223333:regional/webacl/STMTest/1EXAMPLE-2ARN-3ARN-4ARN-123456EXAMPLE","terminatingRuleId":"STMTest_SQLi_XSS","terminatingRuleType":"REGULAR","action":"BLOCK","terminatingRuleMatchDetails":[{"conditionType":"SQL_INJECTION","sensitivityLevel":"HIGH","location":"HEADER","matchedData":["10","AND","1"]}],"httpSourceName":"ALB","httpSourceId":"app/my-lb/a1b2c3","ruleGroupList":[{"ruleGroupId":"AWS#AWSManagedRulesCommonRuleSet","terminatingRule":null,"nonTerminatingMatchingRules":[{"ruleId":"SizeRestrictions_QUERYSTRING","action":"COUNT"}]}],"rateBasedRuleList":[],"nonTerminatingMatchingRules":[],"responseCodeSent":403,"httpRequest":{"clientIp":"1.1.1.1","country":"AU","headers":[{"name":"Host","value":"localhost:1989"},{"name":"User-Agent","value":"curl/7.61.1"}],"uri":"/myUri","args":"id=10","httpVersion":"HTTP/1.1","httpMethod":"GET","requestId":"rid"},"labels":[{"name":"awswaf:managed:aws:core-rule-set:SizeRestrictions_QueryString"}]}
+`
+
+func TestWAFParseEvents(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString(wafRecord + "not json\n"); err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	var unparseable []int64
+	ep := &WAFEventParser{}
+	out := make(chan event.Event, 10)
+	obj := state.DownloadedObject{Object: "foo", Filename: tmpFile.Name(), Unparseable: func(line int64, text string, err error) {
+		unparseable = append(unparseable, line)
+	}}
+	if err := ep.ParseEvents(obj, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var evs []event.Event
+	for ev := range out {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 1 || len(unparseable) != 1 || unparseable[0] != 2 {
+		t.Fatalf("expected 1 event and line 2 to be unparseable, got %d and %v", len(evs), unparseable)
+	}
+	if expected := time.Unix(1576280412, 771000000); !evs[0].Timestamp.Equal(expected) {
+		t.Errorf("expected timestamp %s, got %s", expected, evs[0].Timestamp)
+	}
+
+	data := evs[0].Data
+	for field, expected := range map[string]interface{}{
+		"web_acl_name":                           "STMTest",
+		"action":                                 "BLOCK",
+		"terminating_rule_id":                    "STMTest_SQLi_XSS",
+		"terminating_rule_match_condition_types": "SQL_INJECTION",
+		"terminating_rule_match_locations":       "HEADER",
+		"terminating_rule_match_matched_data":    "10 AND 1",
+		"non_terminating_rule_ids":               "SizeRestrictions_QUERYSTRING",
+		"labels":                                 "awswaf:managed:aws:core-rule-set:SizeRestrictions_QueryString",
+		"response_code_sent":                     int64(403),
+		"client_ip":                              "1.1.1.1",
+		"country":                                "AU",
+		"request":                                "GET /myUri?id=10 HTTP/1.1",
+		"request.headers.host":                   "localhost:1989",
+		"user_agent":                             "curl/7.61.1",
+	} {
+		if data[field] != expected {
+			t.Errorf("expected %s to be %v, got %v", field, expected, data[field])
+		}
+	}
+	if _, ok := data["rule_group_id"]; ok {
+		t.Error("expected no rule_group_id without a rule group terminating the request")
+	}
+}
@@ -0,0 +1,60 @@
+// Package retry retries operations which can fail transiently, such as
+// downloading objects from S3 and sending events to Honeycomb, backing off
+// exponentially between attempts.
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	BaseDelay = time.Second
+	MaxDelay  = time.Minute
+)
+
+// Policy is how many times to retry, and how long to back off for.
+type Policy struct {
+	Retries   int
+	Base, Max time.Duration
+}
+
+// New returns a policy retrying up to retries times, backing off from
+// BaseDelay up to MaxDelay.
+func New(retries int) Policy {
+	return Policy{Retries: retries, Base: BaseDelay, Max: MaxDelay}
+}
+
+// Backoff returns how long to wait before the nth retry, starting from 1:
+// Base doubled for each retry before it, up to Max. Half of it is jitter, so
+// that everything which failed at once doesn't all retry at once too.
+func (p Policy) Backoff(n int) time.Duration {
+	d := p.Max
+	if n < 1 {
+		n = 1
+	}
+	if n < 32 {
+		if exp := p.Base << uint(n-1); exp > 0 && exp < p.Max {
+			d = exp
+		}
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Do calls fn until it succeeds, it has been retried p.Retries times, or stop
+// is closed while backing off. It returns how many times fn was called, and
+// its last error.
+func (p Policy) Do(stop <-chan struct{}, fn func() error) (int, error) {
+	for attempts := 1; ; attempts++ {
+		err := fn()
+		if err == nil || attempts > p.Retries {
+			return attempts, err
+		}
+		select {
+		case <-time.After(p.Backoff(attempts)):
+		case <-stop:
+			return attempts, err
+		}
+	}
+}
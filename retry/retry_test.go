@@ -0,0 +1,60 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := New(5)
+	for n, max := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		7: time.Minute,
+		// doubling this many times would overflow
+		100: time.Minute,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := p.Backoff(n); d < max/2 || d > max {
+				t.Fatalf("retry %d: expected a backoff between %s and %s, got %s", n, max/2, max, d)
+			}
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := Policy{Retries: 3, Base: time.Millisecond, Max: 4 * time.Millisecond}
+	errFailed := errors.New("failed")
+
+	calls := 0
+	attempts, err := p.Do(nil, func() error {
+		calls++
+		if calls < 3 {
+			return errFailed
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("expected to succeed on the 3rd attempt, got %d attempts (%v)", attempts, err)
+	}
+
+	attempts, err = p.Do(nil, func() error { return errFailed })
+	if err != errFailed || attempts != 4 {
+		t.Errorf("expected to give up after 3 retries, got %d attempts (%v)", attempts, err)
+	}
+
+	// stopping gives up without waiting out the backoff
+	stop := make(chan struct{})
+	close(stop)
+	p.Base, p.Max = time.Hour, time.Hour
+	attempts, err = p.Do(stop, func() error { return errFailed })
+	if err != errFailed || attempts != 1 {
+		t.Errorf("expected to give up once stopped, got %d attempts (%v)", attempts, err)
+	}
+
+	if attempts, _ := New(0).Do(nil, func() error { return errFailed }); attempts != 1 {
+		t.Errorf("expected no retries, got %d attempts", attempts)
+	}
+}
@@ -2,6 +2,8 @@ package sampler
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 
 	dynsampler "github.com/honeycombio/dynsampler-go"
 	"github.com/honeycombio/honeyaws/options"
@@ -31,3 +33,108 @@ func NewSamplerFromOptions(opt *options.Options) (dynsampler.Sampler, error) {
 		return nil, ErrUnknownSamplerType
 	}
 }
+
+// Keys returns the fields from --dynsample_keys, which may be given as a comma
+// separated list, repeated, or both.
+func Keys(opt *options.Options) []string {
+	var keys []string
+	for _, k := range opt.DynSampleKeys {
+		for _, key := range strings.Split(k, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Key builds the dynamic sampler key for an event out of the values of the
+// given fields, using "-" for any that aren't present.
+func Key(data map[string]interface{}, keys []string) string {
+	vals := make([]string, len(keys))
+	for i, k := range keys {
+		if v, ok := data[k]; ok {
+			vals[i] = fmt.Sprintf("%v", v)
+		} else {
+			vals[i] = "-"
+		}
+	}
+	return strings.Join(vals, "_")
+}
+
+// settings are the options a dynamic sampler is built from.
+type settings struct {
+	samplerType string
+	rate        int
+	interval    int
+	decay       float64
+}
+
+func settingsOf(opt *options.Options) settings {
+	return settings{opt.SamplerType, opt.SampleRate, opt.SamplerInterval, opt.SamplerDecay}
+}
+
+// Dynamic is the dynamic sampler built from the options, along with the
+// --dynsample_keys, which Reload replaces while events are being sampled.
+type Dynamic struct {
+	lock     sync.RWMutex
+	sampler  dynsampler.Sampler
+	settings settings
+	keys     []string
+}
+
+// NewDynamic builds and starts the dynamic sampler for the options.
+func NewDynamic(opt *options.Options) (*Dynamic, error) {
+	d := &Dynamic{}
+	if err := d.Reload(opt); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewDynamicWith wraps an already started sampler, e.g. a dynsampler.Static
+// with rates known up front, to sample events on the keys given.
+func NewDynamicWith(s dynsampler.Sampler, keys []string) *Dynamic {
+	return &Dynamic{sampler: s, keys: keys}
+}
+
+// Reload replaces the sampler and keys with those of the options. The sampler
+// is only rebuilt, losing the rates it has learned, if its own options have
+// changed. On an error, the sampler is left as it was.
+func (d *Dynamic) Reload(opt *options.Options) error {
+	d.lock.RLock()
+	s, current := d.sampler, d.settings
+	d.lock.RUnlock()
+
+	if s == nil || settingsOf(opt) != current {
+		var err error
+		if s, err = NewSamplerFromOptions(opt); err != nil {
+			return err
+		}
+		// dynsampler has no way to stop the sampler being replaced,
+		// so its goroutine carries on, unused
+		if err := s.Start(); err != nil {
+			return err
+		}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.sampler, d.settings, d.keys = s, settingsOf(opt), Keys(opt)
+	return nil
+}
+
+// Keys returns the --dynsample_keys, if any.
+func (d *Dynamic) Keys() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.keys
+}
+
+// GetSampleRate returns the sample rate for the key.
+func (d *Dynamic) GetSampleRate(key string) int {
+	d.lock.RLock()
+	s := d.sampler
+	d.lock.RUnlock()
+	return s.GetSampleRate(key)
+}
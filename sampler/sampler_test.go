@@ -1,6 +1,7 @@
 package sampler
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/honeycombio/dynsampler-go"
@@ -41,3 +42,48 @@ func TestNewSamplerFromOptions(t *testing.T) {
 		t.Error("got EMASampleRate sampler without correct values")
 	}
 }
+
+func TestKeys(t *testing.T) {
+	opt := &options.Options{DynSampleKeys: []string{"elb_status_code, request_path", "elb"}}
+	keys := Keys(opt)
+	expected := []string{"elb_status_code", "request_path", "elb"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("got keys %v, expected %v", keys, expected)
+	}
+
+	if keys := Keys(&options.Options{}); len(keys) != 0 {
+		t.Errorf("expected no keys by default, got %v", keys)
+	}
+}
+
+func TestDynamicReload(t *testing.T) {
+	opt := &options.Options{SamplerType: SamplerTypeSimple, SamplerInterval: 300, SampleRate: 1}
+	d, err := NewDynamic(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := d.sampler
+
+	reloaded := *opt
+	reloaded.DynSampleKeys = []string{"elb_status_code"}
+	if err := d.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if d.sampler != s || !reflect.DeepEqual(d.Keys(), []string{"elb_status_code"}) {
+		t.Error("expected new keys to keep the sampler and what it has learned")
+	}
+
+	reloaded.SampleRate = 10
+	if err := d.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if d.sampler == s || d.sampler.(*dynsampler.AvgSampleRate).GoalSampleRate != 10 {
+		t.Error("expected a new sample rate to rebuild the sampler")
+	}
+
+	s = d.sampler
+	reloaded.SamplerType = "unknown"
+	if err := d.Reload(&reloaded); err != ErrUnknownSamplerType || d.sampler != s {
+		t.Errorf("expected an unknown sampler type to leave the sampler as it was, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package sketch provides streaming summaries of values which are too
+// numerous to keep around in full.
+package sketch
+
+import (
+	"math"
+	"sort"
+)
+
+type centroid struct {
+	mean, count float64
+}
+
+// TDigest is a merging t-digest (see https://arxiv.org/abs/1902.04023) which
+// estimates quantiles of a stream of values in bounded memory, with better
+// accuracy towards the tails (p99, etc.) than in the middle.
+//
+// A TDigest is not safe for concurrent use.
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	unmerged    []centroid
+	count       float64
+	min, max    float64
+}
+
+// NewTDigest returns an empty digest. Higher compression keeps more
+// centroids around, trading memory for accuracy. 100 is a good default.
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{compression: compression}
+}
+
+// Add records an observation.
+func (t *TDigest) Add(x float64) {
+	if t.count == 0 || x < t.min {
+		t.min = x
+	}
+	if t.count == 0 || x > t.max {
+		t.max = x
+	}
+	t.unmerged = append(t.unmerged, centroid{mean: x, count: 1})
+	t.count++
+	if len(t.unmerged) >= int(5*t.compression) {
+		t.merge()
+	}
+}
+
+// Count returns the number of observations recorded.
+func (t *TDigest) Count() int {
+	return int(t.count)
+}
+
+// Min returns the smallest observation, or 0 if nothing has been recorded.
+func (t *TDigest) Min() float64 {
+	return t.min
+}
+
+// Max returns the largest observation, or 0 if nothing has been recorded.
+func (t *TDigest) Max() float64 {
+	return t.max
+}
+
+func (t *TDigest) merge() {
+	if len(t.unmerged) == 0 {
+		return
+	}
+
+	all := append(t.centroids, t.unmerged...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := []centroid{all[0]}
+	// weight of all the centroids before the one being built up
+	soFar := 0.0
+	for _, c := range all[1:] {
+		cur := &merged[len(merged)-1]
+		q := (soFar + (cur.count+c.count)/2) / t.count
+		limit := 4 * t.count * q * (1 - q) / t.compression
+		if cur.count+c.count <= limit {
+			cur.mean += (c.mean - cur.mean) * c.count / (cur.count + c.count)
+			cur.count += c.count
+		} else {
+			soFar += cur.count
+			merged = append(merged, c)
+		}
+	}
+
+	t.centroids = merged
+	t.unmerged = t.unmerged[:0]
+}
+
+// Quantile returns the estimated value at quantile q (between 0 and 1), or
+// NaN if nothing has been recorded.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.merge()
+	if t.count == 0 {
+		return math.NaN()
+	}
+	if len(t.centroids) == 1 {
+		return t.centroids[0].mean
+	}
+
+	target := q * t.count
+	cum := 0.0
+	for i, c := range t.centroids {
+		center := cum + c.count/2
+		if target < center {
+			if i == 0 {
+				return t.min + (c.mean-t.min)*target/center
+			}
+			prev := t.centroids[i-1]
+			prevCenter := cum - prev.count/2
+			return prev.mean + (c.mean-prev.mean)*(target-prevCenter)/(center-prevCenter)
+		}
+		cum += c.count
+	}
+
+	last := t.centroids[len(t.centroids)-1]
+	lastCenter := t.count - last.count/2
+	if target >= t.count || lastCenter == t.count {
+		return t.max
+	}
+	return last.mean + (t.max-last.mean)*(target-lastCenter)/(t.count-lastCenter)
+}
@@ -0,0 +1,43 @@
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTDigestQuantiles(t *testing.T) {
+	td := NewTDigest(100)
+	if !math.IsNaN(td.Quantile(0.5)) {
+		t.Error("expected NaN quantile for empty digest")
+	}
+
+	// shuffled 0..9999 so that the digest sees them out of order
+	for _, v := range rand.Perm(10000) {
+		td.Add(float64(v))
+	}
+
+	if td.Count() != 10000 {
+		t.Errorf("expected count of 10000, got %d", td.Count())
+	}
+	if td.Min() != 0 || td.Max() != 9999 {
+		t.Errorf("expected a min of 0 and max of 9999, got %v and %v", td.Min(), td.Max())
+	}
+
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		expected := q * 10000
+		actual := td.Quantile(q)
+		// within 1% of the range
+		if math.Abs(actual-expected) > 100 {
+			t.Errorf("quantile %v: expected ~%v, got %v", q, expected, actual)
+		}
+	}
+}
+
+func TestTDigestSingleValue(t *testing.T) {
+	td := NewTDigest(100)
+	td.Add(0.25)
+	if td.Quantile(0.99) != 0.25 {
+		t.Errorf("expected 0.25, got %v", td.Quantile(0.99))
+	}
+}
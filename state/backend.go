@@ -0,0 +1,34 @@
+package state
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// NewBackendStater returns a Stater keeping state in the server at the given
+// URL, by its scheme: redis:// (or rediss://) for Redis, and postgres:// (or
+// postgresql://) for PostgreSQL. Like --highavail with DynamoDB, this lets
+// several instances share state.
+func NewBackendStater(backend, service string, backfillHrs int) (Stater, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid state backend URL: %s", err)
+	}
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		stater, err := NewRedisStater(backend, service, backfillHrs)
+		if err != nil {
+			return nil, fmt.Errorf("Could not connect to Redis: %s", err)
+		}
+		return stater, nil
+	case "postgres", "postgresql":
+		stater, err := NewPostgresStater(backend, service, backfillHrs)
+		if err != nil {
+			return nil, fmt.Errorf("Could not set up the %s table in PostgreSQL: %s", PostgresTableName, err)
+		}
+		return stater, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported state backend %q, expected a redis:// or postgres:// URL", u.Scheme)
+}
@@ -0,0 +1,229 @@
+package state
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Log objects are named for the time they cover: ELB, ALB, NLB and CloudTrail
+// logs for the end of their 5 minute interval, e.g.
+// ..._app.my-lb.1db0c9806095122a_20180820T1120Z_10.0.0.1_abcd.log.gz, and
+// CloudFront logs for their hour, e.g. E123.2018-08-20-11.abcd.gz.
+var (
+	intervalKeyTime = regexp.MustCompile(`_(\d{8}T\d{4}Z)_`)
+	hourKeyTime     = regexp.MustCompile(`\.(\d{4}-\d{2}-\d{2}-\d{2})\.`)
+)
+
+// KeyTime returns the time the object's logs are for, going by its key, and
+// whether the key has one.
+func KeyTime(key string) (time.Time, bool) {
+	if m := intervalKeyTime.FindStringSubmatch(key); m != nil {
+		if t, err := time.Parse("20060102T1504Z", m[1]); err == nil {
+			return t, true
+		}
+	}
+	if m := hourKeyTime.FindStringSubmatch(key); m != nil {
+		if t, err := time.Parse("2006-01-02-15", m[1]); err == nil {
+			// the logs are for the whole hour
+			return t.Add(time.Hour), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Matcher picks the objects state list lists and state reset forgets, by their
+// keys and when they were recorded.
+type Matcher func(object string, recorded time.Time) bool
+
+// NewMatcher matches the objects of the load balancer (or distribution, flow
+// log or web ACL) with the name, going by the name being in their keys between
+// separators, e.g. my-lb in ..._app.my-lb.1db0c9806095122a_20180820T1120Z_...,
+// whose logs are from since on. Objects whose keys have no time go by when
+// they were recorded instead. An empty name or zero since matches every
+// object.
+func NewMatcher(name string, since time.Time) Matcher {
+	var nameRe *regexp.Regexp
+	if name != "" {
+		nameRe = regexp.MustCompile(`(^|[/_.])` + regexp.QuoteMeta(name) + `([/_.]|$)`)
+	}
+	return func(object string, recorded time.Time) bool {
+		if nameRe != nil && !nameRe.MatchString(object) {
+			return false
+		}
+		if since.IsZero() {
+			return true
+		}
+		t, ok := KeyTime(object)
+		if !ok {
+			t = recorded
+		}
+		return !t.Before(since)
+	}
+}
+
+// Resetter is implemented by Staters which can forget objects, for `state
+// reset`, so that they're processed again the next time they're listed
+// within the backfill interval.
+type Resetter interface {
+	// Reset deletes the processed records, offsets, dead letters and
+	// missing records of the objects matching, and the cursors of the prefixes they were listed
+	// under, returning how many were deleted.
+	Reset(match Matcher) (int, error)
+}
+
+// objectState is what's recorded of an object.
+type objectState struct {
+	processed  time.Time
+	offset     *Offset
+	deadLetter *DeadLetter
+	missing    *Missing
+}
+
+// status is the object's state, when it was recorded and its details, for
+// state list.
+func (o objectState) status() (string, time.Time, string) {
+	switch {
+	case o.deadLetter != nil:
+		return "dead-letter", o.deadLetter.Time, fmt.Sprintf("%d attempts: %s", o.deadLetter.Attempts, o.deadLetter.Error)
+	case o.missing != nil:
+		return "missing", o.missing.Time, fmt.Sprintf("%d checks: %s", o.missing.Checks, o.missing.Error)
+	case o.offset != nil:
+		return "partial", o.offset.Time, fmt.Sprintf("%d lines published", o.offset.Lines)
+	}
+	return "processed", o.processed, ""
+}
+
+// objectStates returns what's recorded of each object: processed within the
+// backfill interval, published in part, or failed.
+func objectStates(s Stater) (map[string]*objectState, error) {
+	processed, err := s.ProcessedObjects()
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := s.Offsets()
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[string]*objectState, len(processed))
+	get := func(object string) *objectState {
+		if objects[object] == nil {
+			objects[object] = &objectState{}
+		}
+		return objects[object]
+	}
+	for object, t := range processed {
+		get(object).processed = t
+	}
+	for object, offset := range offsets {
+		offset := offset
+		get(object).offset = &offset
+	}
+	if d, ok := s.(DeadLetterer); ok {
+		letters, err := d.DeadLetters()
+		if err != nil {
+			return nil, err
+		}
+		for object, letter := range letters {
+			letter := letter
+			get(object).deadLetter = &letter
+		}
+	}
+	if r, ok := s.(Rechecker); ok {
+		missing, err := r.MissingObjects()
+		if err != nil {
+			return nil, err
+		}
+		for object, m := range missing {
+			m := m
+			get(object).missing = &m
+		}
+	}
+	return objects, nil
+}
+
+// PrintObjects writes the objects matching to w a line each, oldest first:
+// the object, whether it was processed, published in part (partial), failed
+// (dead-letter) or wasn't found (missing), when, and how many lines were
+// published or why it failed.
+func PrintObjects(s Stater, w io.Writer, match Matcher) error {
+	objects, err := objectStates(s)
+	if err != nil {
+		return err
+	}
+
+	type line struct {
+		object, status, detail string
+		time                   time.Time
+	}
+	var lines []line
+	for object, o := range objects {
+		status, t, detail := o.status()
+		if match(object, t) {
+			lines = append(lines, line{object, status, detail, t})
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if !lines[i].time.Equal(lines[j].time) {
+			return lines[i].time.Before(lines[j].time)
+		}
+		return lines[i].object < lines[j].object
+	})
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, strings.TrimRight(strings.Join([]string{l.object, l.status, l.time.Format(time.RFC3339), l.detail}, "\t"), "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintObject writes everything recorded of the object to w, for state show.
+func PrintObject(s Stater, w io.Writer, object string) error {
+	objects, err := objectStates(s)
+	if err != nil {
+		return err
+	}
+	o, ok := objects[object]
+	if !ok {
+		return fmt.Errorf("Nothing is recorded of %q within the backfill interval", object)
+	}
+
+	fields := [][2]string{{"object", object}}
+	if t, ok := KeyTime(object); ok {
+		fields = append(fields, [2]string{"logs", t.Format(time.RFC3339)})
+	}
+	fields = append(fields, [2]string{"processed", formatTime(o.processed)})
+	if o.offset != nil {
+		fields = append(fields, [2]string{"offset", fmt.Sprintf("%d lines published at %s", o.offset.Lines, o.offset.Time.Format(time.RFC3339))})
+	}
+	if o.deadLetter != nil {
+		fields = append(fields, [2]string{"dead letter", fmt.Sprintf("%s after %d attempts: %s", o.deadLetter.Time.Format(time.RFC3339), o.deadLetter.Attempts, o.deadLetter.Error)})
+	}
+	if o.missing != nil {
+		fields = append(fields, [2]string{"missing", fmt.Sprintf("%s after %d checks: %s", o.missing.Time.Format(time.RFC3339), o.missing.Checks, o.missing.Error)})
+	}
+	for _, f := range fields {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetCursors returns the prefixes which any of the objects were listed
+// under, whose cursors have to go for them to be listed again.
+func forgetCursors(prefixes []string, objects []string) []string {
+	var forget []string
+	for _, prefix := range prefixes {
+		for _, object := range objects {
+			if strings.HasPrefix(object, prefix) {
+				forget = append(forget, prefix)
+				break
+			}
+		}
+	}
+	return forget
+}
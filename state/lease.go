@@ -0,0 +1,150 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	leaseKeyPrefix = "lease:"
+
+	// leasePartition puts leases in the partition index under a
+	// partition of their own, so that they can be listed without reading
+	// the processed objects, which are partitioned by hour.
+	leasePartition = "leases"
+)
+
+// Leaser is implemented by Staters which can lease out work between the
+// instances sharing them, for --shard.
+type Leaser interface {
+	// Lease takes the named lease for owner until the time, unless
+	// someone else holds it, renewing it if owner does already. It
+	// returns whether owner holds it now.
+	Lease(name, owner string, until time.Time) (bool, error)
+
+	// ReleaseLease gives up the lease, if owner holds it.
+	ReleaseLease(name, owner string) error
+
+	// Leases returns the leases whose names start with the prefix, and
+	// when each was last taken or renewed.
+	Leases(prefix string) (map[string]time.Time, error)
+}
+
+// Leases are kept in the table under their own keys, with the holder and
+// when the lease runs out in seconds since the epoch, so that taking one is
+// a single conditional write.
+func (d *DynamoDBStater) Lease(name, owner string, until time.Time) (bool, error) {
+	svc := dynamodb.New(d.Session)
+
+	now := time.Now()
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object:   leaseKeyPrefix + name,
+		Time:       now,
+		TTL:        until.Add(TTLDefault).Unix(),
+		Partition:  leasePartition,
+		Owner:      owner,
+		LeaseUntil: until.Unix(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{
+		Item:                     obj,
+		TableName:                aws.String(d.TableName),
+		ConditionExpression:      aws.String("attribute_not_exists(S3Object) OR #owner = :owner OR #until < :now"),
+		ExpressionAttributeNames: map[string]*string{"#owner": aws.String("Owner"), "#until": aws.String("LeaseUntil")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+			":now":   {N: aws.String(fmt.Sprint(now.Unix()))},
+		},
+	}); err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		return false, fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return true, nil
+}
+
+func (d *DynamoDBStater) ReleaseLease(name, owner string) error {
+	svc := dynamodb.New(d.Session)
+
+	if _, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(leaseKeyPrefix + name)},
+		},
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]*string{"#owner": aws.String("Owner")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":owner": {S: aws.String(owner)}},
+	}); err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil
+		}
+		return fmt.Errorf("DeleteItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Leases are read from their partition of the partition index, or scanned
+// for in a table without it.
+func (d *DynamoDBStater) Leases(prefix string) (map[string]time.Time, error) {
+	leases := make(map[string]time.Time)
+	add := func(items []map[string]*dynamodb.AttributeValue) error {
+		var recs []Record
+		if err := dynamodbattribute.UnmarshalListOfMaps(items, &recs); err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if name := strings.TrimPrefix(rec.S3Object, leaseKeyPrefix); strings.HasPrefix(name, prefix) {
+				leases[name] = rec.Time
+			}
+		}
+		return nil
+	}
+
+	svc := dynamodb.New(d.Session)
+	var unmarshalErr error
+	var err error
+	if d.partitioned {
+		err = svc.QueryPages(&dynamodb.QueryInput{
+			TableName:                aws.String(d.TableName),
+			IndexName:                aws.String(DynamoPartitionIndex),
+			KeyConditionExpression:   aws.String("#partition = :partition AND begins_with(S3Object, :prefix)"),
+			ExpressionAttributeNames: map[string]*string{"#partition": aws.String("Partition")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":partition": {S: aws.String(leasePartition)},
+				":prefix":    {S: aws.String(leaseKeyPrefix + prefix)},
+			},
+		}, func(page *dynamodb.QueryOutput, last bool) bool {
+			unmarshalErr = add(page.Items)
+			return unmarshalErr == nil
+		})
+	} else {
+		err = svc.ScanPages(&dynamodb.ScanInput{
+			TableName:                 aws.String(d.TableName),
+			FilterExpression:          aws.String("begins_with(S3Object, :prefix)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":prefix": {S: aws.String(leaseKeyPrefix + prefix)}},
+		}, func(page *dynamodb.ScanOutput, last bool) bool {
+			unmarshalErr = add(page.Items)
+			return unmarshalErr == nil
+		})
+	}
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		return leases, fmt.Errorf("Error reading leases from DynamoDB, %v", err)
+	}
+
+	return leases, nil
+}
@@ -0,0 +1,34 @@
+package state
+
+import "time"
+
+// Processed objects are partitioned by the hour they were processed in, so
+// that only the partitions within the backfill interval need to be read, and
+// older ones can be dropped whole.
+const partitionFormat = "2006-01-02T15"
+
+func partition(t time.Time) string {
+	return t.UTC().Format(partitionFormat)
+}
+
+// partitions returns the partitions which objects processed within the
+// interval before now are in, oldest first.
+func partitions(now time.Time, interval time.Duration) []string {
+	now = now.UTC()
+	var ps []string
+	for t := now.Add(-interval).Truncate(time.Hour); !t.After(now); t = t.Add(time.Hour) {
+		ps = append(ps, partition(t))
+	}
+	return ps
+}
+
+// partitionExpired reports whether every object in the partition was
+// processed longer than the interval before now. Partitions which can't be
+// parsed aren't ours to remove.
+func partitionExpired(p string, now time.Time, interval time.Duration) bool {
+	start, err := time.Parse(partitionFormat, p)
+	if err != nil {
+		return false
+	}
+	return now.Sub(start.Add(time.Hour)) > interval
+}
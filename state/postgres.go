@@ -0,0 +1,427 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// Registers the postgres driver with database/sql.
+	_ "github.com/lib/pq"
+)
+
+const PostgresTableName = "honeyaws_state"
+
+// Processed objects, cursors, offsets, dead letters, contents, progress,
+// published objects and replays all go in the one table, told apart by their
+// kind.
+const postgresSchema = `CREATE TABLE IF NOT EXISTS ` + PostgresTableName + ` (
+	service text NOT NULL,
+	kind text NOT NULL,
+	key text NOT NULL,
+	last_key text NOT NULL DEFAULT '',
+	lines bigint NOT NULL DEFAULT 0,
+	error text NOT NULL DEFAULT '',
+	time timestamptz NOT NULL,
+	PRIMARY KEY (service, kind, key)
+)`
+
+// Tables created before dead letters were kept need their error column.
+const postgresMigration = `ALTER TABLE ` + PostgresTableName + ` ADD COLUMN IF NOT EXISTS error text NOT NULL DEFAULT ''`
+
+const (
+	kindProcessed  = "processed"
+	kindCursor     = "cursor"
+	kindOffset     = "offset"
+	kindDeadLetter = "dead_letter"
+	kindMissing    = "missing"
+	kindContent    = "content"
+	kindProgress   = "progress"
+	kindPublished  = "published"
+	kindReplay     = "replay"
+)
+
+// PostgresStater keeps processing state in a PostgreSQL table, so that it can
+// be shared between instances the same way as with DynamoDBStater. The table
+// is created if it doesn't exist yet.
+type PostgresStater struct {
+	DB               *sql.DB
+	Service          string
+	BackfillInterval time.Duration
+}
+
+func NewPostgresStater(url, service string, backfillHrs int) (*PostgresStater, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	stater := &PostgresStater{
+		DB:               db,
+		Service:          service,
+		BackfillInterval: time.Hour * time.Duration(backfillHrs),
+	}
+
+	if _, err := db.Exec(postgresSchema); err != nil {
+		return stater, err
+	}
+	if _, err := db.Exec(postgresMigration); err != nil {
+		return stater, err
+	}
+
+	return stater, nil
+}
+
+func (p *PostgresStater) ProcessedObjects() (map[string]time.Time, error) {
+	objs := make(map[string]time.Time)
+
+	// Reap old state (outside of the "backfill interval"), otherwise the
+	// table will grow indefinitely. Replays are kept.
+	if _, err := p.DB.Exec(`DELETE FROM `+PostgresTableName+` WHERE service = $1 AND time < $2 AND kind <> $3`,
+		p.Service, time.Now().Add(-p.BackfillInterval), kindReplay); err != nil {
+		return objs, fmt.Errorf("Reaping old state failed: %s", err)
+	}
+
+	rows, err := p.DB.Query(`SELECT key, time FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2`,
+		p.Service, kindProcessed)
+	if err != nil {
+		return objs, fmt.Errorf("Querying processed objects failed: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key string
+			t   time.Time
+		)
+		if err := rows.Scan(&key, &t); err != nil {
+			return objs, fmt.Errorf("Scanning processed object failed: %s", err)
+		}
+		objs[key] = t
+	}
+
+	return objs, rows.Err()
+}
+
+func (p *PostgresStater) SetProcessed(object string) error {
+	// If the object exists, no write happens, like the conditional put to
+	// DynamoDB.
+	res, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, time) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`, p.Service, kindProcessed, object, time.Now())
+	if err != nil {
+		return fmt.Errorf("Insert failed: %s", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("Item exists in PostgreSQL: %s", object)
+	}
+
+	return nil
+}
+
+func (p *PostgresStater) ClearProcessed(object string) error {
+	if _, err := p.DB.Exec(`DELETE FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2 AND key = $3`,
+		p.Service, kindProcessed, object); err != nil {
+		return fmt.Errorf("Delete failed: %s", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresStater) Cursor(prefix string) (string, error) {
+	var cursor string
+	err := p.DB.QueryRow(`SELECT last_key FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2 AND key = $3`,
+		p.Service, kindCursor, prefix).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Querying cursor failed: %s", err)
+	}
+
+	return cursor, nil
+}
+
+func (p *PostgresStater) SetCursor(prefix, key string) error {
+	// Only ever move the cursor forward, in case another instance has
+	// already listed further. Keys are compared bytewise, as S3 orders
+	// them, rather than by the database's collation.
+	if _, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` AS s (service, kind, key, last_key, time) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (service, kind, key) DO UPDATE SET last_key = EXCLUDED.last_key, time = EXCLUDED.time
+		WHERE s.last_key COLLATE "C" < EXCLUDED.last_key COLLATE "C"`,
+		p.Service, kindCursor, prefix, key, time.Now()); err != nil {
+		return fmt.Errorf("Upsert failed: %s", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresStater) Offsets() (map[string]Offset, error) {
+	offsets := make(map[string]Offset)
+
+	rows, err := p.DB.Query(`SELECT key, lines, time FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2`,
+		p.Service, kindOffset)
+	if err != nil {
+		return offsets, fmt.Errorf("Querying offsets failed: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key    string
+			offset Offset
+		)
+		if err := rows.Scan(&key, &offset.Lines, &offset.Time); err != nil {
+			return offsets, fmt.Errorf("Scanning offset failed: %s", err)
+		}
+		offsets[key] = offset
+	}
+
+	return offsets, rows.Err()
+}
+
+func (p *PostgresStater) SetOffset(object string, lines int64) error {
+	if _, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, lines, time) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (service, kind, key) DO UPDATE SET lines = EXCLUDED.lines, time = EXCLUDED.time`,
+		p.Service, kindOffset, object, lines, time.Now()); err != nil {
+		return fmt.Errorf("Upsert failed: %s", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresStater) ClearOffset(object string) error {
+	if _, err := p.DB.Exec(`DELETE FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2 AND key = $3`,
+		p.Service, kindOffset, object); err != nil {
+		return fmt.Errorf("Delete failed: %s", err)
+	}
+
+	return nil
+}
+
+// Dead letters keep how many attempts were made in lines.
+func (p *PostgresStater) DeadLetters() (map[string]DeadLetter, error) {
+	letters := make(map[string]DeadLetter)
+
+	rows, err := p.DB.Query(`SELECT key, lines, error, time FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2`,
+		p.Service, kindDeadLetter)
+	if err != nil {
+		return letters, fmt.Errorf("Querying dead letters failed: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key    string
+			letter DeadLetter
+		)
+		if err := rows.Scan(&key, &letter.Attempts, &letter.Error, &letter.Time); err != nil {
+			return letters, fmt.Errorf("Scanning dead letter failed: %s", err)
+		}
+		letters[key] = letter
+	}
+
+	return letters, rows.Err()
+}
+
+func (p *PostgresStater) SetDeadLetter(object string, letter DeadLetter) error {
+	if _, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, lines, error, time) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (service, kind, key) DO UPDATE SET lines = EXCLUDED.lines, error = EXCLUDED.error, time = EXCLUDED.time`,
+		p.Service, kindDeadLetter, object, letter.Attempts, letter.Error, letter.Time); err != nil {
+		return fmt.Errorf("Upsert failed: %s", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresStater) ClearDeadLetter(object string) error {
+	if _, err := p.DB.Exec(`DELETE FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2 AND key = $3`,
+		p.Service, kindDeadLetter, object); err != nil {
+		return fmt.Errorf("Delete failed: %s", err)
+	}
+
+	return nil
+}
+
+// Missing objects keep how many checks were made in lines, like dead letters.
+func (p *PostgresStater) MissingObjects() (map[string]Missing, error) {
+	missing := make(map[string]Missing)
+
+	rows, err := p.DB.Query(`SELECT key, lines, error, time FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2`,
+		p.Service, kindMissing)
+	if err != nil {
+		return missing, fmt.Errorf("Querying missing objects failed: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key string
+			m   Missing
+		)
+		if err := rows.Scan(&key, &m.Checks, &m.Error, &m.Time); err != nil {
+			return missing, fmt.Errorf("Scanning missing object failed: %s", err)
+		}
+		missing[key] = m
+	}
+
+	return missing, rows.Err()
+}
+
+func (p *PostgresStater) SetMissing(object string, missing Missing) error {
+	if _, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, lines, error, time) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (service, kind, key) DO UPDATE SET lines = EXCLUDED.lines, error = EXCLUDED.error, time = EXCLUDED.time`,
+		p.Service, kindMissing, object, missing.Checks, missing.Error, missing.Time); err != nil {
+		return fmt.Errorf("Upsert failed: %s", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresStater) ClearMissing(object string) error {
+	if _, err := p.DB.Exec(`DELETE FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2 AND key = $3`,
+		p.Service, kindMissing, object); err != nil {
+		return fmt.Errorf("Delete failed: %s", err)
+	}
+
+	return nil
+}
+
+// Progress is kept JSON encoded in error, rather than needing columns of its
+// own, and reaped along with processed objects once it's no longer being
+// recorded.
+func (p *PostgresStater) Progress() (map[string]Progress, error) {
+	progress := make(map[string]Progress)
+
+	rows, err := p.DB.Query(`SELECT key, error FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2`,
+		p.Service, kindProgress)
+	if err != nil {
+		return progress, fmt.Errorf("Querying progress failed: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, data string
+		if err := rows.Scan(&key, &data); err != nil {
+			return progress, fmt.Errorf("Scanning progress failed: %s", err)
+		}
+		var pr Progress
+		if err := json.Unmarshal([]byte(data), &pr); err != nil {
+			return progress, fmt.Errorf("Unmarshalling progress of %s failed: %s", key, err)
+		}
+		progress[key] = pr
+	}
+
+	return progress, rows.Err()
+}
+
+func (p *PostgresStater) SetProgress(entity string, progress Progress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	if _, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, error, time) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (service, kind, key) DO UPDATE SET error = EXCLUDED.error, time = EXCLUDED.time`,
+		p.Service, kindProgress, entity, string(data), progress.Time); err != nil {
+		return fmt.Errorf("Upsert failed: %s", err)
+	}
+
+	return nil
+}
+
+// Replays are kept JSON encoded in error too, and aren't reaped.
+func (p *PostgresStater) Replays() (map[string]Replay, error) {
+	replays := make(map[string]Replay)
+
+	rows, err := p.DB.Query(`SELECT key, error FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2`,
+		p.Service, kindReplay)
+	if err != nil {
+		return replays, fmt.Errorf("Querying replays failed: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, data string
+		if err := rows.Scan(&key, &data); err != nil {
+			return replays, fmt.Errorf("Scanning replay failed: %s", err)
+		}
+		var r Replay
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return replays, fmt.Errorf("Unmarshalling replay %s failed: %s", key, err)
+		}
+		replays[key] = r
+	}
+
+	return replays, rows.Err()
+}
+
+func (p *PostgresStater) SetReplay(id string, replay Replay) error {
+	data, err := json.Marshal(replay)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	if _, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, error, time) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (service, kind, key) DO UPDATE SET error = EXCLUDED.error, time = EXCLUDED.time`,
+		p.Service, kindReplay, id, string(data), replay.Started); err != nil {
+		return fmt.Errorf("Upsert failed: %s", err)
+	}
+
+	return nil
+}
+
+// Contents are keyed by their ETags, with the object first processed with
+// them in last_key, and reaped along with processed objects.
+func (p *PostgresStater) SetContentProcessed(etag, object string) (string, error) {
+	if _, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, last_key, time) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING`, p.Service, kindContent, etag, object, time.Now()); err != nil {
+		return "", fmt.Errorf("Insert failed: %s", err)
+	}
+
+	var first string
+	if err := p.DB.QueryRow(`SELECT last_key FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2 AND key = $3`,
+		p.Service, kindContent, etag).Scan(&first); err != nil {
+		return "", fmt.Errorf("Querying content failed: %s", err)
+	}
+
+	return first, nil
+}
+
+// Published objects are reaped along with processed objects, like contents.
+func (p *PostgresStater) Published(object string) (bool, error) {
+	var published bool
+	if err := p.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+PostgresTableName+` WHERE service = $1 AND kind = $2 AND key = $3)`,
+		p.Service, kindPublished, object).Scan(&published); err != nil {
+		return false, fmt.Errorf("Querying published object failed: %s", err)
+	}
+
+	return published, nil
+}
+
+func (p *PostgresStater) SetPublished(object string) error {
+	res, err := p.DB.Exec(`INSERT INTO `+PostgresTableName+` (service, kind, key, time) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`, p.Service, kindPublished, object, time.Now())
+	if err != nil {
+		return fmt.Errorf("Insert failed: %s", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("Object already published: %s", object)
+	}
+
+	return nil
+}
+
+// An offset is claimed by updating it only if it's as it was read, which only
+// one instance can do.
+func (p *PostgresStater) ClaimOffset(object string, offset Offset) error {
+	res, err := p.DB.Exec(`UPDATE `+PostgresTableName+` SET time = $4 WHERE service = $1 AND kind = $2 AND key = $3 AND time = $5`,
+		p.Service, kindOffset, object, time.Now(), offset.Time)
+	if err != nil {
+		return fmt.Errorf("Update failed: %s", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("Offset already claimed: %s", object)
+	}
+
+	return nil
+}
+
+func (p *PostgresStater) Cleanup(before time.Time) (int, error) {
+	res, err := p.DB.Exec(`DELETE FROM `+PostgresTableName+` WHERE service = $1 AND time < $2 AND kind <> $3`, p.Service, before, kindReplay)
+	if err != nil {
+		return 0, fmt.Errorf("Delete failed: %s", err)
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
@@ -0,0 +1,519 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Only ever move a cursor forward, in case another instance has already
+// listed further.
+var setCursorScript = redis.NewScript(1, `
+local cursor = redis.call("GET", KEYS[1])
+if not cursor or cursor < ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+end
+return 0
+`)
+
+// Only claim an offset which hasn't changed since it was read, in case another
+// instance claimed it first.
+var claimOffsetScript = redis.NewScript(1, `
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+return 1
+`)
+
+// RedisStater keeps processing state in Redis, under keys prefixed with
+// honeyaws:<service>:, so that it can be shared between instances the same way
+// as with DynamoDBStater.
+type RedisStater struct {
+	Pool             *redis.Pool
+	Service          string
+	BackfillInterval time.Duration
+}
+
+func NewRedisStater(url, service string, backfillHrs int) (*RedisStater, error) {
+	stater := &RedisStater{
+		Pool: &redis.Pool{
+			MaxIdle:     4,
+			IdleTimeout: 5 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(url)
+			},
+		},
+		Service:          service,
+		BackfillInterval: time.Hour * time.Duration(backfillHrs),
+	}
+
+	conn := stater.Pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return stater, err
+	}
+
+	return stater, nil
+}
+
+func (r *RedisStater) key(name string) string {
+	return "honeyaws:" + r.Service + ":" + name
+}
+
+// Processed objects are kept in a sorted set, scored by when they were
+// processed in milliseconds.
+func (r *RedisStater) ProcessedObjects() (map[string]time.Time, error) {
+	objs := make(map[string]time.Time)
+
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	// Reap old objects (outside of the "backfill interval"), otherwise the
+	// set will grow indefinitely.
+	oldest := time.Now().Add(-r.BackfillInterval).UnixNano() / int64(time.Millisecond)
+	if _, err := conn.Do("ZREMRANGEBYSCORE", r.key("processed"), "-inf", oldest); err != nil {
+		return objs, fmt.Errorf("ZREMRANGEBYSCORE failed: %s", err)
+	}
+
+	values, err := redis.Strings(conn.Do("ZRANGE", r.key("processed"), 0, -1, "WITHSCORES"))
+	if err != nil {
+		return objs, fmt.Errorf("ZRANGE failed: %s", err)
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		ms, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return objs, fmt.Errorf("Parsing score of %s failed: %s", values[i], err)
+		}
+		objs[values[i]] = time.Unix(0, int64(ms)*int64(time.Millisecond))
+	}
+
+	return objs, nil
+}
+
+func (r *RedisStater) SetProcessed(object string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	// NX makes this fail if another instance got there first, like the
+	// conditional put to DynamoDB.
+	added, err := redis.Int(conn.Do("ZADD", r.key("processed"), "NX", time.Now().UnixNano()/int64(time.Millisecond), object))
+	if err != nil {
+		return fmt.Errorf("ZADD failed: %s", err)
+	}
+	if added == 0 {
+		return fmt.Errorf("Item exists in Redis: %s", object)
+	}
+
+	return nil
+}
+
+func (r *RedisStater) ClearProcessed(object string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("ZREM", r.key("processed"), object); err != nil {
+		return fmt.Errorf("ZREM failed: %s", err)
+	}
+
+	return nil
+}
+
+// Cursors are kept under a key of their own per prefix, which expire once the
+// prefix is outside of the backfill interval.
+func (r *RedisStater) Cursor(prefix string) (string, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	cursor, err := redis.String(conn.Do("GET", r.key("cursor:"+prefix)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("GET failed: %s", err)
+	}
+
+	return cursor, nil
+}
+
+func (r *RedisStater) SetCursor(prefix, key string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	if _, err := setCursorScript.Do(conn, r.key("cursor:"+prefix), key, int64(r.BackfillInterval/time.Second)); err != nil {
+		return fmt.Errorf("Setting cursor failed: %s", err)
+	}
+
+	return nil
+}
+
+// Offsets are kept in a hash of the objects to their JSON encoded Offset.
+func (r *RedisStater) Offsets() (map[string]Offset, error) {
+	offsets := make(map[string]Offset)
+
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.key("offsets")))
+	if err != nil {
+		return offsets, fmt.Errorf("HGETALL failed: %s", err)
+	}
+	for object, value := range values {
+		var offset Offset
+		if err := json.Unmarshal([]byte(value), &offset); err != nil {
+			return offsets, fmt.Errorf("Unmarshalling offset of %s failed: %s", object, err)
+		}
+		offsets[object] = offset
+	}
+
+	return offsets, nil
+}
+
+func (r *RedisStater) SetOffset(object string, lines int64) error {
+	offsets, err := r.Offsets()
+	if err != nil {
+		return err
+	}
+
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	for k, v := range offsets {
+		if time.Since(v.Time) > r.BackfillInterval {
+			if _, err := conn.Do("HDEL", r.key("offsets"), k); err != nil {
+				return fmt.Errorf("HDEL failed: %s", err)
+			}
+		}
+	}
+
+	data, err := json.Marshal(Offset{Lines: lines, Time: time.Now()})
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	if _, err := conn.Do("HSET", r.key("offsets"), object, data); err != nil {
+		return fmt.Errorf("HSET failed: %s", err)
+	}
+
+	return nil
+}
+
+func (r *RedisStater) ClearOffset(object string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("HDEL", r.key("offsets"), object); err != nil {
+		return fmt.Errorf("HDEL failed: %s", err)
+	}
+
+	return nil
+}
+
+// Dead letters are kept in a hash of the objects to their JSON encoded
+// DeadLetter, like offsets.
+func (r *RedisStater) DeadLetters() (map[string]DeadLetter, error) {
+	letters := make(map[string]DeadLetter)
+
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.key("dead-letters")))
+	if err != nil {
+		return letters, fmt.Errorf("HGETALL failed: %s", err)
+	}
+	for object, value := range values {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(value), &letter); err != nil {
+			return letters, fmt.Errorf("Unmarshalling dead letter of %s failed: %s", object, err)
+		}
+		letters[object] = letter
+	}
+
+	return letters, nil
+}
+
+func (r *RedisStater) SetDeadLetter(object string, letter DeadLetter) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	if _, err := conn.Do("HSET", r.key("dead-letters"), object, data); err != nil {
+		return fmt.Errorf("HSET failed: %s", err)
+	}
+
+	return nil
+}
+
+func (r *RedisStater) ClearDeadLetter(object string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("HDEL", r.key("dead-letters"), object); err != nil {
+		return fmt.Errorf("HDEL failed: %s", err)
+	}
+
+	return nil
+}
+
+// Missing objects are kept in a hash of the objects to their JSON encoded
+// Missing, like dead letters.
+func (r *RedisStater) MissingObjects() (map[string]Missing, error) {
+	missing := make(map[string]Missing)
+
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.key("missing")))
+	if err != nil {
+		return missing, fmt.Errorf("HGETALL failed: %s", err)
+	}
+	for object, value := range values {
+		var m Missing
+		if err := json.Unmarshal([]byte(value), &m); err != nil {
+			return missing, fmt.Errorf("Unmarshalling missing object %s failed: %s", object, err)
+		}
+		missing[object] = m
+	}
+
+	return missing, nil
+}
+
+func (r *RedisStater) SetMissing(object string, missing Missing) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(missing)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	if _, err := conn.Do("HSET", r.key("missing"), object, data); err != nil {
+		return fmt.Errorf("HSET failed: %s", err)
+	}
+
+	return nil
+}
+
+func (r *RedisStater) ClearMissing(object string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("HDEL", r.key("missing"), object); err != nil {
+		return fmt.Errorf("HDEL failed: %s", err)
+	}
+
+	return nil
+}
+
+// Progress is kept in a hash of the load balancers (or distributions, or
+// trails) to their JSON encoded Progress, like dead letters.
+func (r *RedisStater) Progress() (map[string]Progress, error) {
+	progress := make(map[string]Progress)
+
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.key("progress")))
+	if err != nil {
+		return progress, fmt.Errorf("HGETALL failed: %s", err)
+	}
+	for entity, value := range values {
+		var p Progress
+		if err := json.Unmarshal([]byte(value), &p); err != nil {
+			return progress, fmt.Errorf("Unmarshalling progress of %s failed: %s", entity, err)
+		}
+		progress[entity] = p
+	}
+
+	return progress, nil
+}
+
+func (r *RedisStater) SetProgress(entity string, progress Progress) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	if _, err := conn.Do("HSET", r.key("progress"), entity, data); err != nil {
+		return fmt.Errorf("HSET failed: %s", err)
+	}
+
+	return nil
+}
+
+// Replays are kept in a hash of their IDs to their JSON encoded Replay, like
+// progress, which Cleanup leaves alone.
+func (r *RedisStater) Replays() (map[string]Replay, error) {
+	replays := make(map[string]Replay)
+
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.key("replays")))
+	if err != nil {
+		return replays, fmt.Errorf("HGETALL failed: %s", err)
+	}
+	for id, value := range values {
+		var rp Replay
+		if err := json.Unmarshal([]byte(value), &rp); err != nil {
+			return replays, fmt.Errorf("Unmarshalling replay %s failed: %s", id, err)
+		}
+		replays[id] = rp
+	}
+
+	return replays, nil
+}
+
+func (r *RedisStater) SetReplay(id string, replay Replay) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(replay)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	if _, err := conn.Do("HSET", r.key("replays"), id, data); err != nil {
+		return fmt.Errorf("HSET failed: %s", err)
+	}
+
+	return nil
+}
+
+// Contents are kept under a key of their own per ETag, which expire once
+// copies of the object would be outside of the backfill interval, like
+// cursors. NX keeps the object which got there first.
+func (r *RedisStater) SetContentProcessed(etag, object string) (string, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	key := r.key("content:" + etag)
+	_, err := redis.String(conn.Do("SET", key, object, "NX", "EX", int64(r.BackfillInterval/time.Second)))
+	if err == nil {
+		return object, nil
+	}
+	if err != redis.ErrNil {
+		return "", fmt.Errorf("SET failed: %s", err)
+	}
+
+	first, err := redis.String(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		// expired in between, so there's no telling
+		return object, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("GET failed: %s", err)
+	}
+	return first, nil
+}
+
+// Published objects are kept under a key of their own each, which expire
+// once the object is outside of the backfill interval, like contents.
+func (r *RedisStater) Published(object string) (bool, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	published, err := redis.Bool(conn.Do("EXISTS", r.key("published:"+object)))
+	if err != nil {
+		return false, fmt.Errorf("EXISTS failed: %s", err)
+	}
+	return published, nil
+}
+
+func (r *RedisStater) SetPublished(object string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	_, err := redis.String(conn.Do("SET", r.key("published:"+object), time.Now().Unix(), "NX", "EX", int64(r.BackfillInterval/time.Second)))
+	if err == redis.ErrNil {
+		return fmt.Errorf("Object already published: %s", object)
+	}
+	if err != nil {
+		return fmt.Errorf("SET failed: %s", err)
+	}
+
+	return nil
+}
+
+// Offsets are claimed by comparing what was read with what's in the hash, as
+// it was encoded.
+func (r *RedisStater) ClaimOffset(object string, offset Offset) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	seen, err := json.Marshal(offset)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	data, err := json.Marshal(Offset{Lines: offset.Lines, Time: time.Now()})
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	claimed, err := redis.Int(claimOffsetScript.Do(conn, r.key("offsets"), object, seen, data))
+	if err != nil {
+		return fmt.Errorf("Claiming offset failed: %s", err)
+	}
+	if claimed == 0 {
+		return fmt.Errorf("Offset already claimed: %s", object)
+	}
+
+	return nil
+}
+
+// Cursors, contents and published objects aren't counted, since they expire
+// by themselves.
+func (r *RedisStater) Cleanup(before time.Time) (int, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	deleted, err := redis.Int(conn.Do("ZREMRANGEBYSCORE", r.key("processed"), "-inf", "("+strconv.FormatInt(before.UnixNano()/int64(time.Millisecond), 10)))
+	if err != nil {
+		return 0, fmt.Errorf("ZREMRANGEBYSCORE failed: %s", err)
+	}
+
+	offsets, err := r.Offsets()
+	if err != nil {
+		return deleted, err
+	}
+	for k, v := range offsets {
+		if v.Time.Before(before) {
+			if _, err := conn.Do("HDEL", r.key("offsets"), k); err != nil {
+				return deleted, fmt.Errorf("HDEL failed: %s", err)
+			}
+			deleted++
+		}
+	}
+
+	letters, err := r.DeadLetters()
+	if err != nil {
+		return deleted, err
+	}
+	for k, v := range letters {
+		if v.Time.Before(before) {
+			if _, err := conn.Do("HDEL", r.key("dead-letters"), k); err != nil {
+				return deleted, fmt.Errorf("HDEL failed: %s", err)
+			}
+			deleted++
+		}
+	}
+
+	missing, err := r.MissingObjects()
+	if err != nil {
+		return deleted, err
+	}
+	for k, v := range missing {
+		if v.Time.Before(before) {
+			if _, err := conn.Do("HDEL", r.key("missing"), k); err != nil {
+				return deleted, fmt.Errorf("HDEL failed: %s", err)
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -1,11 +1,15 @@
 package state
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,9 +22,28 @@ import (
 )
 
 const (
-	stateFileFormat = "%s-state.json"
-	DynamoTableName = "HoneyAWSAccessLogBuckets"
-	TTLDefault      = time.Hour * 24 * 7
+	stateFileFormat      = "%s-state.json"
+	partitionFileFormat  = "%s-state-%s.json"
+	cursorFileFormat     = "%s-cursors.json"
+	offsetFileFormat     = "%s-offsets.json"
+	deadLetterFileFormat = "%s-dead-letters.json"
+	missingFileFormat    = "%s-missing.json"
+	contentFileFormat    = "%s-contents.json"
+	progressFileFormat   = "%s-progress.json"
+	publishedFileFormat  = "%s-published.json"
+	replayFileFormat     = "%s-replays.json"
+	cursorKeyPrefix      = "cursor:"
+	offsetKeyPrefix      = "offset:"
+	deadLetterKeyPrefix  = "dead-letter:"
+	missingKeyPrefix     = "missing:"
+	contentKeyPrefix     = "content:"
+	progressKeyPrefix    = "progress:"
+	publishedKeyPrefix   = "published:"
+	replayKeyPrefix      = "replay:"
+	partitionedKey       = "partitioned:kinds"
+	DynamoTableName      = "HoneyAWSAccessLogBuckets"
+	DynamoPartitionIndex = "PartitionIndex"
+	TTLDefault           = time.Hour * 24 * 7
 )
 
 // Stater lets us gain insight into the current state of object processing. It
@@ -34,211 +57,2781 @@ type Stater interface {
 	// SetProcessed indicates that downloading, processing, and sending the
 	// object to Honeycomb has been completed successfully.
 	SetProcessed(object string) error
+
+	// Offsets returns how many lines into each object publishing got, for
+	// objects which were left unfinished, e.g. by the process dying
+	// part way through a big one.
+	Offsets() (map[string]Offset, error)
+
+	// SetOffset records that the first lines of the object have been
+	// published.
+	SetOffset(object string, lines int64) error
+
+	// ClearOffset records that the object has been published in full.
+	ClearOffset(object string) error
+}
+
+// Cursorer is implemented by Staters which can also remember how far into a
+// prefix the bucket has been listed, so that the next listing can start after
+// that key instead of listing (and skipping) everything again.
+type Cursorer interface {
+	// Cursor returns the key to start listing the prefix after, or "" if
+	// there isn't one.
+	Cursor(prefix string) (string, error)
+
+	// SetCursor records that every key up to and including key has been
+	// listed under the prefix.
+	SetCursor(prefix, key string) error
+}
+
+// Cleaner is implemented by Staters which can delete old state on demand, for
+// `state cleanup`, e.g. when DynamoDB TTL isn't enabled on the table.
+type Cleaner interface {
+	// Cleanup deletes every processed object, cursor and offset recorded
+	// before the time, returning how many were deleted.
+	Cleanup(before time.Time) (int, error)
+}
+
+// DeadLetterer is implemented by Staters which can record the objects which
+// failed to be downloaded however many times they were retried, so that they
+// can be looked into rather than silently lost.
+type DeadLetterer interface {
+	// DeadLetters returns the objects which permanently failed.
+	DeadLetters() (map[string]DeadLetter, error)
+
+	// SetDeadLetter records that the object permanently failed.
+	SetDeadLetter(object string, letter DeadLetter) error
+
+	// ClearDeadLetter forgets the object's failure, once it's been
+	// replayed.
+	ClearDeadLetter(object string) error
+}
+
+// Rechecker is implemented by Staters which can record the objects which
+// weren't found when they were downloaded, e.g. listed before S3 would serve
+// them, while they're checked for again, so that the re-checks carry on after
+// a restart and the objects which never turn up are reported rather than
+// forgotten.
+type Rechecker interface {
+	// MissingObjects returns the objects which were found to be missing.
+	MissingObjects() (map[string]Missing, error)
+
+	// SetMissing records that the object was found to be missing.
+	SetMissing(object string, missing Missing) error
+
+	// ClearMissing forgets that the object was missing, once it's turned
+	// up.
+	ClearMissing(object string) error
+}
+
+// Unclaimer is implemented by Staters which can forget that an object was
+// processed, so that an object claimed by setting it processed before it's
+// downloaded, e.g. by a Lambda invocation, can be retried if publishing it
+// fails, rather than being skipped as already processed.
+type Unclaimer interface {
+	// ClearProcessed forgets that the object was processed.
+	ClearProcessed(object string) error
+}
+
+// Deduper is implemented by Staters which can also remember the contents of
+// the objects processed, by their S3 ETags, so that an object is only
+// published once even when it's found under more than one key, e.g. in a
+// bucket and a replica of it.
+type Deduper interface {
+	// SetContentProcessed records that the object, whose contents have
+	// the ETag, is being processed, returning the object first recorded
+	// with the ETag: the object itself, unless it's a copy.
+	SetContentProcessed(etag, object string) (string, error)
+}
+
+// Confirmer is implemented by Staters which can record the objects Honeycomb
+// accepted every event of, and hand an unfinished object over to one instance
+// to resume, for --exactly_once: an object published in full isn't resumed and
+// published again after a crash, and two instances don't both resume one.
+type Confirmer interface {
+	// Published returns whether every event of the object was accepted,
+	// within the backfill interval.
+	Published(object string) (bool, error)
+
+	// SetPublished records that every event of the object was accepted,
+	// failing if it already had been, i.e. it was published twice.
+	SetPublished(object string) error
+
+	// ClaimOffset takes over resuming the unfinished object by recording
+	// its offset again, failing if the offset has changed since it was
+	// read, e.g. because another instance claimed it first.
+	ClaimOffset(object string, offset Offset) error
+}
+
+// Progresser is implemented by Staters which can also record how far
+// backfilling each load balancer (or distribution, or trail) has got, so that
+// `status` can report on it from outside of the process doing it.
+type Progresser interface {
+	// Progress returns the latest progress of each.
+	Progress() (map[string]Progress, error)
+
+	// SetProgress records the latest progress of the load balancer (or
+	// distribution, or trail).
+	SetProgress(entity string, progress Progress) error
+}
+
+// Replayer is implemented by Staters which can also record the time ranges of
+// logs ingested again with --start-time, so that a range isn't ingested (and
+// paid for) twice by accident. Replays aren't expired or cleaned up with the
+// rest of the state.
+type Replayer interface {
+	// Replays returns every replay recorded, by their IDs.
+	Replays() (map[string]Replay, error)
+
+	// SetReplay records the replay, or its having finished.
+	SetReplay(id string, replay Replay) error
+}
+
+// contentRecord is the object first processed with some contents, and when.
+type contentRecord struct {
+	Object string
+	Time   time.Time
+}
+
+// Cursor is the last key listed under a prefix, and when it was listed.
+type Cursor struct {
+	Key  string
+	Time time.Time
+}
+
+// Offset is how many lines of an object had been published, and when.
+type Offset struct {
+	Lines int64
+	Time  time.Time
+}
+
+// Progress is how far backfilling had got, and when: how many of the objects
+// in the backfill window have been processed since it started and how many
+// are left, the time of the oldest of those left, and when it's expected to
+// be done going by how fast it's been so far. Oldest and ETA are zero once
+// there's nothing left, and ETA until there's a rate to go by.
+type Progress struct {
+	Processed int64
+	Remaining int64
+	Oldest    time.Time
+	ETA       time.Time
+	Time      time.Time
+}
+
+// Replay is a time range of logs ingested again, what of them, going by the
+// Scope, e.g. the load balancers named, and when it started and finished.
+// Finished is zero until it has, or if it never did.
+type Replay struct {
+	Scope    string
+	Start    time.Time
+	End      time.Time
+	Started  time.Time
+	Finished time.Time
+}
+
+// DeadLetter is why an object permanently failed, after how many attempts,
+// and when.
+type DeadLetter struct {
+	Error    string
+	Attempts int
+	Time     time.Time
+}
+
+// Missing is why an object was last found to be missing, after how many
+// checks for it, and when.
+type Missing struct {
+	Error  string
+	Checks int
+	Time   time.Time
+}
+
+// PrintProgress writes the stater's progress to w a line each, by load
+// balancer (or distribution, or trail): objects processed and remaining, the
+// oldest remaining, the ETA and when the progress was recorded, followed by
+// the totals.
+func PrintProgress(s Stater, w io.Writer) error {
+	p, ok := s.(Progresser)
+	if !ok {
+		return fmt.Errorf("The state backend doesn't keep backfill progress")
+	}
+	progress, err := p.Progress()
+	if err != nil {
+		return err
+	}
+
+	entities := make([]string, 0, len(progress))
+	for entity := range progress {
+		entities = append(entities, entity)
+	}
+	sort.Strings(entities)
+	var total Progress
+	for _, entity := range entities {
+		pr := progress[entity]
+		if _, err := fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", entity, pr.Processed, pr.Remaining,
+			formatTime(pr.Oldest), formatTime(pr.ETA), pr.Time.Format(time.RFC3339)); err != nil {
+			return err
+		}
+		total.Processed += pr.Processed
+		total.Remaining += pr.Remaining
+		if !pr.Oldest.IsZero() && (total.Oldest.IsZero() || pr.Oldest.Before(total.Oldest)) {
+			total.Oldest = pr.Oldest
+		}
+		if pr.ETA.After(total.ETA) {
+			total.ETA = pr.ETA
+		}
+	}
+	_, err = fmt.Fprintf(w, "total\t%d\t%d\t%s\t%s\n", total.Processed, total.Remaining, formatTime(total.Oldest), formatTime(total.ETA))
+	return err
+}
+
+// formatTime is the time in RFC 3339, or - if it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// PrintDeadLetters writes the stater's dead letters to w a line each, oldest
+// first: the object, when it failed, after how many attempts, and the error.
+func PrintDeadLetters(s Stater, w io.Writer) error {
+	d, ok := s.(DeadLetterer)
+	if !ok {
+		return fmt.Errorf("The state backend doesn't keep dead letters")
+	}
+	letters, err := d.DeadLetters()
+	if err != nil {
+		return err
+	}
+
+	objects := make([]string, 0, len(letters))
+	for object := range letters {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return letters[objects[i]].Time.Before(letters[objects[j]].Time)
+	})
+	for _, object := range objects {
+		letter := letters[object]
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", object, letter.Time.Format(time.RFC3339), letter.Attempts, letter.Error); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintMissing writes the objects the stater recorded as missing to w a line
+// each, oldest first: the object, when it was last checked for, after how many
+// checks, and the error.
+func PrintMissing(s Stater, w io.Writer) error {
+	r, ok := s.(Rechecker)
+	if !ok {
+		return fmt.Errorf("The state backend doesn't keep missing objects")
+	}
+	missing, err := r.MissingObjects()
+	if err != nil {
+		return err
+	}
+
+	objects := make([]string, 0, len(missing))
+	for object := range missing {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return missing[objects[i]].Time.Before(missing[objects[j]].Time)
+	})
+	for _, object := range objects {
+		m := missing[object]
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", object, m.Time.Format(time.RFC3339), m.Checks, m.Error); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Used to communicate between the various pieces which are relying on state
 // information.
 type DownloadedObject struct {
 	Object, Filename string
+
+	// Body, if set, is the object streamed from S3 as it's read, rather than
+	// downloaded to Filename first. It can only be read once.
+	Body io.ReadCloser
+
+	// Size is how big the object is as stored, which is compressed, if it's
+	// known.
+	Size int64
+
+	// Offset is how many lines of the object were published before
+	// processing was interrupted, which are skipped when resuming it.
+	Offset int64
+
+	// Resumed is set when the object was left unfinished, which it can be
+	// without any lines having been published.
+	Resumed bool
+
+	// Progress, if set, is called with how many lines of the object have
+	// been read and handed along so far.
+	Progress func(lines int64)
+
+	// Unparseable, if set, is called with the number and text of each
+	// line of the object which couldn't be parsed.
+	Unparseable func(line int64, text string, err error)
+
+	// Fields are added to every event parsed from the object.
+	Fields map[string]interface{}
+
+	// Context carries the trace for the object through the pipeline, if
+	// there is one.
+	Context context.Context
+
+	// Local is set for files ingested from the local filesystem, which are
+	// left in place once published rather than removed like downloads.
+	Local bool
+}
+
+// Release closes the object's body if it's being streamed, or removes the
+// file it was downloaded to, once it's done with. Local files are left in
+// place.
+func (o DownloadedObject) Release() error {
+	if o.Body != nil {
+		return o.Body.Close()
+	}
+	if o.Local {
+		return nil
+	}
+	return os.Remove(o.Filename)
 }
 
 type DynamoDBStater struct {
 	Session          *session.Session
+	TableName        string
 	BackfillInterval time.Duration
+
+	// partitioned is whether the table has the partition index, which
+	// processed objects are looked up with once scanUntil has passed.
+	// Until then, objects processed by earlier versions, which aren't
+	// in the index, may still be within the backfill interval.
+	partitioned bool
+	scanUntil   time.Time
+	// kindsScanUntil is when the records of each kind, e.g. offsets,
+	// which are kept in their own partition of the index, can be queried
+	// there rather than scanned for: once records written before they
+	// were partitioned, which aren't in the index, have expired.
+	kindsScanUntil time.Time
 }
 
-func NewDynamoDBStater(session *session.Session, backfillHrs int) (*DynamoDBStater, error) {
+func NewDynamoDBStater(session *session.Session, tableName string, backfillHrs int) (*DynamoDBStater, error) {
 	stater := &DynamoDBStater{
 		Session:          session,
+		TableName:        tableName,
 		BackfillInterval: time.Hour * time.Duration(backfillHrs),
 	}
 
 	svc := dynamodb.New(session)
 	input := &dynamodb.DescribeTableInput{
-		TableName: aws.String(DynamoTableName),
+		TableName: aws.String(tableName),
 	}
-	_, err := svc.DescribeTable(input)
+	resp, err := svc.DescribeTable(input)
 	if err != nil {
 		// For some reason, we cannot write to
 		// the table or access it
 		return stater, err
 	}
 
-	return stater, nil
-}
+	stater.partitioned = hasPartitionIndex(resp.Table, true)
+	stater.scanUntil = time.Now().Add(stater.BackfillInterval)
+	if !stater.partitioned {
+		logrus.WithField("tableName", tableName).Info("DynamoDB table has no partition index, scanning it for processed objects instead. Use --create_table to add the index.")
+		return stater, nil
+	}
 
-// Used for unmarshaling and adding objects to DynamoDB
-type Record struct {
-	S3Object string
-	Time     time.Time
-	TTL      int64 //future date formatted as unix seconds-since-epoch
+	since, err := partitionedSince(svc, tableName)
+	if err != nil {
+		return stater, err
+	}
+	stater.kindsScanUntil = since.Add(TTLDefault)
+
+	return stater, nil
 }
 
-// list of processed objects
-func (d *DynamoDBStater) ProcessedObjects() (map[string]time.Time, error) {
-	objs := make(map[string]time.Time)
+// partitionedSince returns when the records of each kind were first kept in
+// their own partition, recording that it's now if they weren't yet. The
+// record doesn't expire.
+func partitionedSince(svc *dynamodb.DynamoDB, tableName string) (time.Time, error) {
+	now := time.Now()
+	obj, err := dynamodbattribute.MarshalMap(struct {
+		S3Object string
+		Time     time.Time
+	}{partitionedKey, now})
+	if err != nil {
+		return now, fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:                obj,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(S3Object)"),
+	})
+	if err == nil {
+		return now, nil
+	}
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return now, fmt.Errorf("PutItem failed: %s", err)
+	}
 
-	var records []Record
+	resp, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(partitionedKey)},
+		},
+	})
+	if err != nil {
+		return now, fmt.Errorf("GetItem failed: %s", err)
+	}
+	var rec Record
+	if err := dynamodbattribute.UnmarshalMap(resp.Item, &rec); err != nil {
+		return now, fmt.Errorf("Unmarshalling DynamoDB object failed: %s", err)
+	}
+	return rec.Time, nil
+}
 
+// kindRecords returns the records of the kind with the key prefix, e.g.
+// offsets, querying their partition of the index for their keys and getting
+// the records with those, so that reading them takes as long however big the
+// table has grown. Without the index, or while records written before they
+// were partitioned may be about, the table is scanned for them instead.
+func (d *DynamoDBStater) kindRecords(keyPrefix string) ([]Record, error) {
 	svc := dynamodb.New(d.Session)
-	err := svc.ScanPages(&dynamodb.ScanInput{
-		TableName: aws.String(DynamoTableName),
-	}, func(logs *dynamodb.ScanOutput, last bool) bool {
-		recs := []Record{}
-
-		err := dynamodbattribute.UnmarshalListOfMaps(logs.Items, &recs)
+	if !d.partitioned || time.Now().Before(d.kindsScanUntil) {
+		return d.scanKind(svc, keyPrefix)
+	}
 
-		// break out of function
-		if err != nil {
-			logrus.WithField("error", err).Debug("Failed to unmarshal DynamoDB Scan Items")
+	var keys []map[string]*dynamodb.AttributeValue
+	var unmarshalErr error
+	err := svc.QueryPages(&dynamodb.QueryInput{
+		TableName:                 aws.String(d.TableName),
+		IndexName:                 aws.String(DynamoPartitionIndex),
+		KeyConditionExpression:    aws.String("#partition = :partition"),
+		ExpressionAttributeNames:  map[string]*string{"#partition": aws.String("Partition")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":partition": {S: aws.String(keyPrefix)}},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		var recs []Record
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &recs); unmarshalErr != nil {
 			return false
 		}
-
-		records = append(records, recs...)
-		for _, rec := range records {
-			// break out of the scan, we've reached the end of our
-			// backfill interval
-			if time.Since(rec.Time) > d.BackfillInterval {
-				return false
-			}
+		for _, rec := range recs {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"S3Object": {S: aws.String(rec.S3Object)},
+			})
 		}
-
 		return true
 	})
-
-	if err != nil {
-		return objs, fmt.Errorf("Error scanning DynamoDB, %v", err)
+	if err == nil {
+		err = unmarshalErr
 	}
-
-	for _, record := range records {
-		objs[record.S3Object] = record.Time
+	if err != nil {
+		return nil, fmt.Errorf("Error querying DynamoDB, %v", err)
 	}
 
-	return objs, nil
+	return d.getKeys(svc, keys)
 }
 
-func (d *DynamoDBStater) SetProcessed(s3object string) error {
+// The index only has the keys and times of the records, so the records are
+// got in batches as big as BatchGetItem allows.
+const dynamoGetBatchSize = 100
 
-	svc := dynamodb.New(d.Session)
+// getKeys returns the records with the keys, leaving out any which have been
+// deleted since their keys were read.
+func (d *DynamoDBStater) getKeys(svc *dynamodb.DynamoDB, keys []map[string]*dynamodb.AttributeValue) ([]Record, error) {
+	var records []Record
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > dynamoGetBatchSize {
+			n = dynamoGetBatchSize
+		}
+		unprocessed := map[string]*dynamodb.KeysAndAttributes{d.TableName: {Keys: keys[:n]}}
+		keys = keys[n:]
 
-	objMap := Record{
-		S3Object: s3object,
-		Time:     time.Now(),
-		TTL:      time.Now().Add(TTLDefault).Unix(), //
+		for len(unprocessed) > 0 {
+			resp, err := svc.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: unprocessed})
+			if err != nil {
+				return records, fmt.Errorf("BatchGetItem failed: %s", err)
+			}
+			var recs []Record
+			if err := dynamodbattribute.UnmarshalListOfMaps(resp.Responses[d.TableName], &recs); err != nil {
+				return records, fmt.Errorf("Unmarshalling DynamoDB object failed: %s", err)
+			}
+			records = append(records, recs...)
+			unprocessed = resp.UnprocessedKeys
+			if len(unprocessed) > 0 {
+				// Throttled, back off a little before retrying.
+				time.Sleep(time.Second)
+			}
+		}
 	}
+	return records, nil
+}
 
-	obj, err := dynamodbattribute.MarshalMap(objMap)
-
+// scanKind scans the table for the records with the key prefix.
+func (d *DynamoDBStater) scanKind(svc *dynamodb.DynamoDB, keyPrefix string) ([]Record, error) {
+	var records []Record
+	var unmarshalErr error
+	err := svc.ScanPages(&dynamodb.ScanInput{
+		TableName:                 aws.String(d.TableName),
+		FilterExpression:          aws.String("begins_with(S3Object, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":prefix": {S: aws.String(keyPrefix)}},
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		var recs []Record
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &recs); unmarshalErr != nil {
+			return false
+		}
+		records = append(records, recs...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
 	if err != nil {
-		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+		return records, fmt.Errorf("Error scanning DynamoDB, %v", err)
 	}
+	return records, nil
+}
 
-	// add object to dynamodb using conditional
-	// if the object exists, no write happens
-	input := &dynamodb.PutItemInput{
-		Item:                obj,
-		TableName:           aws.String(DynamoTableName),
-		ConditionExpression: aws.String("attribute_not_exists(S3Object)"),
+// hasPartitionIndex reports whether the table has the partition index,
+// optionally only if it's ready to be queried.
+func hasPartitionIndex(table *dynamodb.TableDescription, active bool) bool {
+	if table == nil {
+		return false
 	}
-
-	_, err = svc.PutItem(input)
-	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			// we want this to happen if object already exists
-			if awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
-				return fmt.Errorf("Item exists in Dynamo: %s", err)
-			} else {
-				return fmt.Errorf("PutItem failed: %s", err)
-			}
-
+	for _, index := range table.GlobalSecondaryIndexes {
+		if aws.StringValue(index.IndexName) == DynamoPartitionIndex {
+			return !active || aws.StringValue(index.IndexStatus) == dynamodb.IndexStatusActive
 		}
-		// if it is the conditional check, we can just pop out and
-		// ignore this object!
-		return nil
 	}
-
-	return nil
+	return false
 }
 
-// FileStater is an implementation for indicating processing state using the
-// local filesystem for backing storage.
-type FileStater struct {
-	*sync.Mutex
-	StateDir         string
-	Service          string
-	BackfillInterval time.Duration
+// partitionIndex is a sparse index of processed objects by the hour they were
+// processed in, and of the offset, dead letter, missing and progress records
+// each in a partition of their own, named for their key prefix. Cursors and
+// the rest are left out of it.
+func partitionIndex() *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(DynamoPartitionIndex),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("Partition"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String("S3Object"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType:   aws.String(dynamodb.ProjectionTypeInclude),
+			NonKeyAttributes: []*string{aws.String("Time")},
+		},
+	}
 }
 
-func NewFileStater(stateDir, service string, backfillHrs int) *FileStater {
-	return &FileStater{
-		Mutex:            &sync.Mutex{},
-		StateDir:         stateDir,
-		Service:          service,
-		BackfillInterval: time.Hour * time.Duration(backfillHrs),
+// CreateTableInput is the table for DynamoDBStater, as CreateDynamoDBTable
+// creates it and bootstrap prints it, with the tags given.
+func CreateTableInput(tableName string, tags map[string]string) *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("S3Object"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("Partition"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("S3Object"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{partitionIndex()},
+		BillingMode:            aws.String(dynamodb.BillingModePayPerRequest),
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		input.Tags = append(input.Tags, &dynamodb.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return input
 }
 
-func (f *FileStater) stateFile() string {
-	return filepath.Join(f.StateDir, fmt.Sprintf(stateFileFormat, f.Service))
+// TimeToLiveSpecification expires the table's items at their TTL attribute.
+func TimeToLiveSpecification() *dynamodb.TimeToLiveSpecification {
+	return &dynamodb.TimeToLiveSpecification{
+		AttributeName: aws.String("TTL"),
+		Enabled:       aws.Bool(true),
+	}
 }
 
-func (f *FileStater) processedObjects() (map[string]time.Time, error) {
-	objs := make(map[string]time.Time)
-
-	if _, err := os.Stat(f.stateFile()); os.IsNotExist(err) {
-		// make sure file exists first run
-		if err := ioutil.WriteFile(f.stateFile(), []byte(`{}`), 0644); err != nil {
-			return objs, fmt.Errorf("Error writing file: %s", err)
-		}
+// verifyKey is the key CheckDynamoDBTable reads and conditionally writes,
+// which no S3 object will have.
+const verifyKey = "verify:"
 
-		return objs, nil
-	}
-
-	data, err := ioutil.ReadFile(f.stateFile())
+// CheckDynamoDBTable checks that the table for DynamoDBStater exists and can
+// be read and written, for verify. Nothing is written: the write is
+// conditional on an item which doesn't exist, so it fails the check once
+// it's been allowed.
+func CheckDynamoDBTable(session *session.Session, tableName string) error {
+	svc := dynamodb.New(session)
+	resp, err := svc.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
 	if err != nil {
-		return objs, fmt.Errorf("Error reading object cursor file: %s", err)
+		return fmt.Errorf("DescribeTable failed: %s", err)
 	}
 
-	if err := json.Unmarshal(data, &objs); err != nil {
-		return objs, fmt.Errorf("Unmarshalling state file JSON failed: %s", err)
+	key := make(map[string]*dynamodb.AttributeValue)
+	for _, k := range resp.Table.KeySchema {
+		key[aws.StringValue(k.AttributeName)] = &dynamodb.AttributeValue{S: aws.String(verifyKey)}
+	}
+	if _, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       key,
+	}); err != nil {
+		return fmt.Errorf("GetItem failed: %s", err)
 	}
 
-	return objs, nil
-}
-
-func (f *FileStater) ProcessedObjects() (map[string]time.Time, error) {
-	f.Lock()
-	defer f.Unlock()
-	return f.processedObjects()
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                key,
+		ConditionExpression: aws.String("attribute_exists(S3Object)"),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	if err == nil {
+		return fmt.Errorf("PutItem of %s unexpectedly succeeded", verifyKey)
+	}
+	return fmt.Errorf("PutItem failed: %s", err)
 }
 
-func (f *FileStater) SetProcessed(object string) error {
-	f.Lock()
-	defer f.Unlock()
-
-	processedObjects, err := f.processedObjects()
+// CheckDynamoDBTableTags checks that the table for DynamoDBStater has the
+// tags given (--aws_tags), for verify, e.g. when it wasn't created with them.
+func CheckDynamoDBTableTags(session *session.Session, tableName string, tags map[string]string) error {
+	svc := dynamodb.New(session)
+	resp, err := svc.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("DescribeTable failed: %s", err)
 	}
 
-	// Reap old objects (outside of the "backfill interval"), otherwise the
-	// state file will grow indefinitely.
-	for k, v := range processedObjects {
-		if time.Since(v) > f.BackfillInterval {
-			delete(processedObjects, k)
+	has := make(map[string]string)
+	input := &dynamodb.ListTagsOfResourceInput{ResourceArn: resp.Table.TableArn}
+	for {
+		page, err := svc.ListTagsOfResource(input)
+		if err != nil {
+			return fmt.Errorf("ListTagsOfResource failed: %s", err)
 		}
+		for _, tag := range page.Tags {
+			has[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if page.NextToken == nil {
+			break
+		}
+		input.NextToken = page.NextToken
 	}
 
-	processedObjects[object] = time.Now()
-
-	processedData, err := json.Marshal(processedObjects)
-	if err != nil {
-		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	var missing []string
+	for k, v := range tags {
+		if tag, ok := has[k]; !ok || tag != v {
+			missing = append(missing, k+"="+v)
+		}
 	}
-
-	if err := ioutil.WriteFile(f.stateFile(), processedData, 0644); err != nil {
-		return fmt.Errorf("Writing file failed: %s", err)
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("The table doesn't have the tags %s", strings.Join(missing, ","))
+	}
+	return nil
+}
+
+// CreateDynamoDBTable creates the table for DynamoDBStater, with on-demand
+// billing and TTL enabled on the TTL attribute, as the CloudFormation template
+// does, and the tags given (--aws_tags). A table which already exists only has
+// the partition index added to it if it's missing.
+func CreateDynamoDBTable(session *session.Session, tableName string, tags map[string]string) error {
+	svc := dynamodb.New(session)
+
+	if _, err := svc.CreateTable(CreateTableInput(tableName, tags)); err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceInUseException {
+			return addPartitionIndex(svc, tableName)
+		}
+		return fmt.Errorf("CreateTable failed: %s", err)
+	}
+
+	logrus.WithField("tableName", tableName).Info("Waiting for the DynamoDB table to be created")
+	if err := svc.WaitUntilTableExists(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	}); err != nil {
+		return fmt.Errorf("Waiting for table failed: %s", err)
+	}
+
+	if _, err := svc.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName:               aws.String(tableName),
+		TimeToLiveSpecification: TimeToLiveSpecification(),
+	}); err != nil {
+		return fmt.Errorf("UpdateTimeToLive failed: %s", err)
+	}
+
+	return nil
+}
+
+// addPartitionIndex adds the partition index to a table created before there
+// was one. DynamoDB builds it in the background, and it's used once it's
+// active.
+func addPartitionIndex(svc *dynamodb.DynamoDB, tableName string) error {
+	resp, err := svc.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("DescribeTable failed: %s", err)
+	}
+	if hasPartitionIndex(resp.Table, false) {
+		return nil
+	}
+
+	create := &dynamodb.CreateGlobalSecondaryIndexAction{
+		IndexName:  partitionIndex().IndexName,
+		KeySchema:  partitionIndex().KeySchema,
+		Projection: partitionIndex().Projection,
+	}
+	// Indexes of provisioned tables need throughput of their own.
+	if summary := resp.Table.BillingModeSummary; summary == nil || aws.StringValue(summary.BillingMode) != dynamodb.BillingModePayPerRequest {
+		create.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  resp.Table.ProvisionedThroughput.ReadCapacityUnits,
+			WriteCapacityUnits: resp.Table.ProvisionedThroughput.WriteCapacityUnits,
+		}
+	}
+
+	if _, err := svc.UpdateTable(&dynamodb.UpdateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("Partition"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{{Create: create}},
+	}); err != nil {
+		return fmt.Errorf("UpdateTable failed: %s", err)
+	}
+	logrus.WithField("tableName", tableName).Info("Adding the partition index to the DynamoDB table, it will be used once DynamoDB has built it")
+
+	return nil
+}
+
+// processedTTL is how long records of processed objects are kept for. Objects
+// are only queued while they're within the backfill interval, and they're
+// processed after they were logged, so the records aren't needed once the
+// interval has passed since. The extra hour allows for DynamoDB deleting
+// expired items lazily.
+func (d *DynamoDBStater) processedTTL() time.Duration {
+	if d.BackfillInterval <= 0 {
+		return TTLDefault
+	}
+	return d.BackfillInterval + time.Hour
+}
+
+// Used for unmarshaling and adding objects to DynamoDB
+type Record struct {
+	S3Object string
+	Time     time.Time
+	TTL      int64  //future date formatted as unix seconds-since-epoch
+	Cursor   string `dynamodbav:",omitempty"`
+	Lines    int64  `dynamodbav:",omitempty"`
+	Error    string `dynamodbav:",omitempty"`
+	Attempts int    `dynamodbav:",omitempty"`
+
+	// Original is the object first processed with a content record's
+	// ETag.
+	Original string `dynamodbav:",omitempty"`
+
+	// Progress is a progress record's Progress, JSON encoded.
+	Progress string `dynamodbav:",omitempty"`
+
+	// Replay is a replay record's Replay, JSON encoded.
+	Replay string `dynamodbav:",omitempty"`
+
+	// Partition is the hour processed objects were processed in, or the
+	// key prefix of the records kept in a partition of their own, for the
+	// partition index.
+	Partition string `dynamodbav:",omitempty"`
+
+	// Owner holds leases until LeaseUntil, in seconds since the epoch.
+	Owner      string `dynamodbav:",omitempty"`
+	LeaseUntil int64  `dynamodbav:",omitempty"`
+}
+
+// ProcessedObjects queries the partitions within the backfill interval, so it
+// takes as long for an agent which has been running for months as for one
+// which started an hour ago, falling back to scanning the table without the
+// partition index.
+func (d *DynamoDBStater) ProcessedObjects() (map[string]time.Time, error) {
+	if !d.partitioned || time.Now().Before(d.scanUntil) {
+		return d.scanProcessedObjects()
+	}
+
+	objs := make(map[string]time.Time)
+	svc := dynamodb.New(d.Session)
+	for _, p := range partitions(time.Now(), d.BackfillInterval) {
+		var unmarshalErr error
+		err := svc.QueryPages(&dynamodb.QueryInput{
+			TableName:                 aws.String(d.TableName),
+			IndexName:                 aws.String(DynamoPartitionIndex),
+			KeyConditionExpression:    aws.String("#partition = :partition"),
+			ExpressionAttributeNames:  map[string]*string{"#partition": aws.String("Partition")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":partition": {S: aws.String(p)}},
+		}, func(page *dynamodb.QueryOutput, last bool) bool {
+			var recs []Record
+			if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &recs); unmarshalErr != nil {
+				return false
+			}
+			for _, rec := range recs {
+				objs[rec.S3Object] = rec.Time
+			}
+			return true
+		})
+		if err == nil {
+			err = unmarshalErr
+		}
+		if err != nil {
+			return objs, fmt.Errorf("Error querying DynamoDB, %v", err)
+		}
+	}
+
+	return objs, nil
+}
+
+// list of processed objects
+func (d *DynamoDBStater) scanProcessedObjects() (map[string]time.Time, error) {
+	objs := make(map[string]time.Time)
+
+	var records []Record
+
+	svc := dynamodb.New(d.Session)
+	err := svc.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(d.TableName),
+	}, func(logs *dynamodb.ScanOutput, last bool) bool {
+		recs := []Record{}
+
+		err := dynamodbattribute.UnmarshalListOfMaps(logs.Items, &recs)
+
+		// break out of function
+		if err != nil {
+			logrus.WithField("error", err).Debug("Failed to unmarshal DynamoDB Scan Items")
+			return false
+		}
+
+		records = append(records, recs...)
+		for _, rec := range records {
+			// break out of the scan, we've reached the end of our
+			// backfill interval
+			if time.Since(rec.Time) > d.BackfillInterval {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return objs, fmt.Errorf("Error scanning DynamoDB, %v", err)
+	}
+
+	for _, record := range records {
+		if record.Cursor != "" || strings.HasPrefix(record.S3Object, offsetKeyPrefix) || strings.HasPrefix(record.S3Object, deadLetterKeyPrefix) || strings.HasPrefix(record.S3Object, missingKeyPrefix) || strings.HasPrefix(record.S3Object, leaseKeyPrefix) || strings.HasPrefix(record.S3Object, contentKeyPrefix) || strings.HasPrefix(record.S3Object, progressKeyPrefix) || strings.HasPrefix(record.S3Object, publishedKeyPrefix) || strings.HasPrefix(record.S3Object, replayKeyPrefix) || record.S3Object == partitionedKey {
+			continue
+		}
+		objs[record.S3Object] = record.Time
+	}
+
+	return objs, nil
+}
+
+func (d *DynamoDBStater) SetProcessed(s3object string) error {
+
+	svc := dynamodb.New(d.Session)
+
+	now := time.Now()
+	objMap := Record{
+		S3Object:  s3object,
+		Time:      now,
+		TTL:       now.Add(d.processedTTL()).Unix(),
+		Partition: partition(now),
+	}
+
+	obj, err := dynamodbattribute.MarshalMap(objMap)
+
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	// add object to dynamodb using conditional
+	// if the object exists, no write happens
+	input := &dynamodb.PutItemInput{
+		Item:                obj,
+		TableName:           aws.String(d.TableName),
+		ConditionExpression: aws.String("attribute_not_exists(S3Object)"),
+	}
+
+	_, err = svc.PutItem(input)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			// we want this to happen if object already exists
+			if awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				return fmt.Errorf("Item exists in Dynamo: %s", err)
+			} else {
+				return fmt.Errorf("PutItem failed: %s", err)
+			}
+
+		}
+		// if it is the conditional check, we can just pop out and
+		// ignore this object!
+		return nil
+	}
+
+	return nil
+}
+
+func (d *DynamoDBStater) ClearProcessed(object string) error {
+	svc := dynamodb.New(d.Session)
+
+	if _, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(object)},
+		},
+	}); err != nil {
+		return fmt.Errorf("DeleteItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Cursors are kept in the same table as the processed objects, under a key
+// no S3 object will have.
+func (d *DynamoDBStater) Cursor(prefix string) (string, error) {
+	svc := dynamodb.New(d.Session)
+
+	resp, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(d.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(cursorKeyPrefix + prefix)},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("GetItem failed: %s", err)
+	}
+
+	var rec Record
+	if err := dynamodbattribute.UnmarshalMap(resp.Item, &rec); err != nil {
+		return "", fmt.Errorf("Unmarshalling DynamoDB object failed: %s", err)
+	}
+
+	return rec.Cursor, nil
+}
+
+func (d *DynamoDBStater) SetCursor(prefix, key string) error {
+	svc := dynamodb.New(d.Session)
+
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object: cursorKeyPrefix + prefix,
+		Time:     time.Now(),
+		TTL:      time.Now().Add(TTLDefault).Unix(),
+		Cursor:   key,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	// Only ever move the cursor forward, in case another instance has
+	// already listed further.
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{
+		Item:                      obj,
+		TableName:                 aws.String(d.TableName),
+		ConditionExpression:       aws.String("attribute_not_exists(S3Object) OR #cursor < :cursor"),
+		ExpressionAttributeNames:  map[string]*string{"#cursor": aws.String("Cursor")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":cursor": {S: aws.String(key)}},
+	}); err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil
+		}
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Offsets are kept in the same table too, under their own keys so that
+// recording them doesn't disturb the objects' processed records.
+func (d *DynamoDBStater) Offsets() (map[string]Offset, error) {
+	offsets := make(map[string]Offset)
+
+	recs, err := d.kindRecords(offsetKeyPrefix)
+	for _, rec := range recs {
+		offsets[strings.TrimPrefix(rec.S3Object, offsetKeyPrefix)] = Offset{Lines: rec.Lines, Time: rec.Time}
+	}
+	return offsets, err
+}
+
+func (d *DynamoDBStater) SetOffset(object string, lines int64) error {
+	svc := dynamodb.New(d.Session)
+
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object:  offsetKeyPrefix + object,
+		Time:      time.Now(),
+		TTL:       time.Now().Add(TTLDefault).Unix(),
+		Lines:     lines,
+		Partition: offsetKeyPrefix,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{
+		Item:      obj,
+		TableName: aws.String(d.TableName),
+	}); err != nil {
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return nil
+}
+
+func (d *DynamoDBStater) ClearOffset(object string) error {
+	svc := dynamodb.New(d.Session)
+
+	if _, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(offsetKeyPrefix + object)},
+		},
+	}); err != nil {
+		return fmt.Errorf("DeleteItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Dead letters are kept in the table as well, expiring like offsets.
+func (d *DynamoDBStater) DeadLetters() (map[string]DeadLetter, error) {
+	letters := make(map[string]DeadLetter)
+
+	recs, err := d.kindRecords(deadLetterKeyPrefix)
+	for _, rec := range recs {
+		letters[strings.TrimPrefix(rec.S3Object, deadLetterKeyPrefix)] = DeadLetter{Error: rec.Error, Attempts: rec.Attempts, Time: rec.Time}
+	}
+	return letters, err
+}
+
+func (d *DynamoDBStater) SetDeadLetter(object string, letter DeadLetter) error {
+	svc := dynamodb.New(d.Session)
+
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object:  deadLetterKeyPrefix + object,
+		Time:      letter.Time,
+		TTL:       letter.Time.Add(TTLDefault).Unix(),
+		Error:     letter.Error,
+		Attempts:  letter.Attempts,
+		Partition: deadLetterKeyPrefix,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{
+		Item:      obj,
+		TableName: aws.String(d.TableName),
+	}); err != nil {
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return nil
+}
+
+func (d *DynamoDBStater) ClearDeadLetter(object string) error {
+	svc := dynamodb.New(d.Session)
+
+	if _, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(deadLetterKeyPrefix + object)},
+		},
+	}); err != nil {
+		return fmt.Errorf("DeleteItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Missing objects are kept in the table as well, with how many checks were
+// made in Attempts, expiring like dead letters.
+func (d *DynamoDBStater) MissingObjects() (map[string]Missing, error) {
+	missing := make(map[string]Missing)
+
+	recs, err := d.kindRecords(missingKeyPrefix)
+	for _, rec := range recs {
+		missing[strings.TrimPrefix(rec.S3Object, missingKeyPrefix)] = Missing{Error: rec.Error, Checks: rec.Attempts, Time: rec.Time}
+	}
+	return missing, err
+}
+
+func (d *DynamoDBStater) SetMissing(object string, missing Missing) error {
+	svc := dynamodb.New(d.Session)
+
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object:  missingKeyPrefix + object,
+		Time:      missing.Time,
+		TTL:       missing.Time.Add(TTLDefault).Unix(),
+		Error:     missing.Error,
+		Attempts:  missing.Checks,
+		Partition: missingKeyPrefix,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{
+		Item:      obj,
+		TableName: aws.String(d.TableName),
+	}); err != nil {
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return nil
+}
+
+func (d *DynamoDBStater) ClearMissing(object string) error {
+	svc := dynamodb.New(d.Session)
+
+	if _, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(missingKeyPrefix + object)},
+		},
+	}); err != nil {
+		return fmt.Errorf("DeleteItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Progress is kept in the table as well, expiring like offsets once it's no
+// longer being recorded.
+func (d *DynamoDBStater) Progress() (map[string]Progress, error) {
+	progress := make(map[string]Progress)
+
+	recs, err := d.kindRecords(progressKeyPrefix)
+	if err != nil {
+		return progress, err
+	}
+	for _, rec := range recs {
+		var p Progress
+		if err := json.Unmarshal([]byte(rec.Progress), &p); err != nil {
+			return progress, fmt.Errorf("Unmarshalling progress failed: %s", err)
+		}
+		progress[strings.TrimPrefix(rec.S3Object, progressKeyPrefix)] = p
+	}
+	return progress, nil
+}
+
+func (d *DynamoDBStater) SetProgress(entity string, progress Progress) error {
+	svc := dynamodb.New(d.Session)
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object:  progressKeyPrefix + entity,
+		Time:      progress.Time,
+		TTL:       progress.Time.Add(TTLDefault).Unix(),
+		Progress:  string(data),
+		Partition: progressKeyPrefix,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{
+		Item:      obj,
+		TableName: aws.String(d.TableName),
+	}); err != nil {
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Replays are kept in the table without a TTL, in a partition of their own.
+func (d *DynamoDBStater) Replays() (map[string]Replay, error) {
+	replays := make(map[string]Replay)
+
+	recs, err := d.kindRecords(replayKeyPrefix)
+	if err != nil {
+		return replays, err
+	}
+	for _, rec := range recs {
+		var r Replay
+		if err := json.Unmarshal([]byte(rec.Replay), &r); err != nil {
+			return replays, fmt.Errorf("Unmarshalling replay failed: %s", err)
+		}
+		replays[strings.TrimPrefix(rec.S3Object, replayKeyPrefix)] = r
+	}
+	return replays, nil
+}
+
+func (d *DynamoDBStater) SetReplay(id string, replay Replay) error {
+	svc := dynamodb.New(d.Session)
+
+	data, err := json.Marshal(replay)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object:  replayKeyPrefix + id,
+		Time:      replay.Started,
+		Replay:    string(data),
+		Partition: replayKeyPrefix,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+	delete(obj, "TTL")
+
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{
+		Item:      obj,
+		TableName: aws.String(d.TableName),
+	}); err != nil {
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+	return nil
+}
+
+// Contents are kept in the table under their ETags, expiring like processed
+// objects, since copies are listed within the backfill interval too.
+func (d *DynamoDBStater) SetContentProcessed(etag, object string) (string, error) {
+	svc := dynamodb.New(d.Session)
+
+	now := time.Now()
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object: contentKeyPrefix + etag,
+		Time:     now,
+		TTL:      now.Add(d.processedTTL()).Unix(),
+		Original: object,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:                obj,
+		TableName:           aws.String(d.TableName),
+		ConditionExpression: aws.String("attribute_not_exists(S3Object)"),
+	})
+	if err == nil {
+		return object, nil
+	}
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return "", fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	resp, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.TableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(contentKeyPrefix + etag)},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("GetItem failed: %s", err)
+	}
+	var rec Record
+	if err := dynamodbattribute.UnmarshalMap(resp.Item, &rec); err != nil {
+		return "", fmt.Errorf("Unmarshalling DynamoDB object failed: %s", err)
+	}
+	return rec.Original, nil
+}
+
+// Published objects are kept in the table under their own keys, expiring like
+// processed objects, which is the window they're published once within.
+func (d *DynamoDBStater) Published(object string) (bool, error) {
+	svc := dynamodb.New(d.Session)
+
+	resp, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.TableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"S3Object": {S: aws.String(publishedKeyPrefix + object)},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("GetItem failed: %s", err)
+	}
+	return len(resp.Item) > 0, nil
+}
+
+func (d *DynamoDBStater) SetPublished(object string) error {
+	svc := dynamodb.New(d.Session)
+
+	now := time.Now()
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object: publishedKeyPrefix + object,
+		Time:     now,
+		TTL:      now.Add(d.processedTTL()).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:                obj,
+		TableName:           aws.String(d.TableName),
+		ConditionExpression: aws.String("attribute_not_exists(S3Object)"),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return fmt.Errorf("Object already published: %s", object)
+	}
+	if err != nil {
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// An offset is claimed with a conditional put, so that of the instances which
+// read it, only the first to claim it resumes the object.
+func (d *DynamoDBStater) ClaimOffset(object string, offset Offset) error {
+	svc := dynamodb.New(d.Session)
+
+	obj, err := dynamodbattribute.MarshalMap(Record{
+		S3Object:  offsetKeyPrefix + object,
+		Time:      time.Now(),
+		TTL:       time.Now().Add(TTLDefault).Unix(),
+		Lines:     offset.Lines,
+		Partition: offsetKeyPrefix,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+	seen, err := dynamodbattribute.Marshal(offset.Time)
+	if err != nil {
+		return fmt.Errorf("Marshalling DynamoDB object failed: %s", err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:                     obj,
+		TableName:                aws.String(d.TableName),
+		ConditionExpression:      aws.String("#time = :seen"),
+		ExpressionAttributeNames: map[string]*string{"#time": aws.String("Time")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":seen": seen,
+		},
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return fmt.Errorf("Offset already claimed: %s", object)
+	}
+	if err != nil {
+		return fmt.Errorf("PutItem failed: %s", err)
+	}
+
+	return nil
+}
+
+// Old items are deleted in batches as big as BatchWriteItem allows.
+const dynamoBatchSize = 25
+
+func (d *DynamoDBStater) Cleanup(before time.Time) (int, error) {
+	svc := dynamodb.New(d.Session)
+
+	var keys []map[string]*dynamodb.AttributeValue
+	var scanErr error
+	err := svc.ScanPages(&dynamodb.ScanInput{
+		TableName:            aws.String(d.TableName),
+		ProjectionExpression: aws.String("S3Object, #time"),
+		ExpressionAttributeNames: map[string]*string{
+			"#time": aws.String("Time"),
+		},
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		var recs []Record
+		if scanErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &recs); scanErr != nil {
+			return false
+		}
+		for _, rec := range recs {
+			if rec.Time.Before(before) && rec.S3Object != partitionedKey && !strings.HasPrefix(rec.S3Object, replayKeyPrefix) {
+				keys = append(keys, map[string]*dynamodb.AttributeValue{
+					"S3Object": {S: aws.String(rec.S3Object)},
+				})
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error scanning DynamoDB, %v", err)
+	}
+
+	return d.deleteKeys(svc, keys)
+}
+
+// deleteKeys deletes the items with the keys, returning how many were
+// deleted.
+func (d *DynamoDBStater) deleteKeys(svc *dynamodb.DynamoDB, keys []map[string]*dynamodb.AttributeValue) (int, error) {
+	deleted := 0
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > dynamoBatchSize {
+			n = dynamoBatchSize
+		}
+		requests := make([]*dynamodb.WriteRequest, n)
+		for i, key := range keys[:n] {
+			requests[i] = &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: key}}
+		}
+		keys = keys[n:]
+
+		unprocessed := map[string][]*dynamodb.WriteRequest{d.TableName: requests}
+		for len(unprocessed) > 0 {
+			resp, err := svc.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: unprocessed})
+			if err != nil {
+				return deleted, fmt.Errorf("BatchWriteItem failed: %s", err)
+			}
+			deleted += len(unprocessed[d.TableName]) - len(resp.UnprocessedItems[d.TableName])
+			unprocessed = resp.UnprocessedItems
+			if len(unprocessed) > 0 {
+				// Throttled, back off a little before retrying.
+				time.Sleep(time.Second)
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+func (d *DynamoDBStater) Reset(match Matcher) (int, error) {
+	svc := dynamodb.New(d.Session)
+
+	var (
+		keys     []string
+		objects  []string
+		prefixes []string
+		scanErr  error
+	)
+	err := svc.ScanPages(&dynamodb.ScanInput{
+		TableName:            aws.String(d.TableName),
+		ProjectionExpression: aws.String("S3Object, #time"),
+		ExpressionAttributeNames: map[string]*string{
+			"#time": aws.String("Time"),
+		},
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		var recs []Record
+		if scanErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &recs); scanErr != nil {
+			return false
+		}
+		for _, rec := range recs {
+			object := rec.S3Object
+			switch {
+			case strings.HasPrefix(object, cursorKeyPrefix):
+				prefixes = append(prefixes, strings.TrimPrefix(object, cursorKeyPrefix))
+				continue
+			case strings.HasPrefix(object, offsetKeyPrefix):
+				object = strings.TrimPrefix(object, offsetKeyPrefix)
+			case strings.HasPrefix(object, deadLetterKeyPrefix):
+				object = strings.TrimPrefix(object, deadLetterKeyPrefix)
+			case strings.HasPrefix(object, missingKeyPrefix):
+				object = strings.TrimPrefix(object, missingKeyPrefix)
+			case strings.HasPrefix(object, publishedKeyPrefix):
+				object = strings.TrimPrefix(object, publishedKeyPrefix)
+			case strings.HasPrefix(object, leaseKeyPrefix), strings.HasPrefix(object, contentKeyPrefix), strings.HasPrefix(object, progressKeyPrefix), strings.HasPrefix(object, replayKeyPrefix), object == partitionedKey:
+				continue
+			}
+			if match(object, rec.Time) {
+				keys = append(keys, rec.S3Object)
+				objects = append(objects, object)
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error scanning DynamoDB, %v", err)
+	}
+
+	for _, prefix := range forgetCursors(prefixes, objects) {
+		keys = append(keys, cursorKeyPrefix+prefix)
+	}
+	items := make([]map[string]*dynamodb.AttributeValue, len(keys))
+	for i, key := range keys {
+		items[i] = map[string]*dynamodb.AttributeValue{"S3Object": {S: aws.String(key)}}
+	}
+	return d.deleteKeys(svc, items)
+}
+
+// FileStater is an implementation for indicating processing state using the
+// local filesystem for backing storage.
+type FileStater struct {
+	*sync.Mutex
+	StateDir         string
+	Service          string
+	BackfillInterval time.Duration
+}
+
+func NewFileStater(stateDir, service string, backfillHrs int) *FileStater {
+	return &FileStater{
+		Mutex:            &sync.Mutex{},
+		StateDir:         stateDir,
+		Service:          service,
+		BackfillInterval: time.Hour * time.Duration(backfillHrs),
+	}
+}
+
+// stateFile is where processed objects were kept before they were
+// partitioned, which is still read until its objects have all expired.
+func (f *FileStater) stateFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(stateFileFormat, f.Service))
+}
+
+func (f *FileStater) partitionFile(partition string) string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(partitionFileFormat, f.Service, partition))
+}
+
+// partitionFiles returns every partition file there is, by partition. The
+// directory is read rather than globbed, since the --statedir may have glob
+// metacharacters in it, which are escaped differently on Windows.
+func (f *FileStater) partitionFiles() (map[string]string, error) {
+	dir := f.StateDir
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	suffix := filepath.Ext(partitionFileFormat)
+	prefix := strings.TrimSuffix(fmt.Sprintf(partitionFileFormat, f.Service, ""), suffix)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		files[strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)] = filepath.Join(f.StateDir, name)
+	}
+	return files, nil
+}
+
+func readObjects(filename string) (map[string]time.Time, error) {
+	objs := make(map[string]time.Time)
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return objs, nil
+	}
+	if err != nil {
+		return objs, fmt.Errorf("Error reading state file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &objs); err != nil {
+		return objs, fmt.Errorf("Unmarshalling state file JSON failed: %s", err)
+	}
+
+	return objs, nil
+}
+
+func writeObjects(filename string, objs map[string]time.Time) error {
+	data, err := json.Marshal(objs)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+
+	return nil
+}
+
+// processedObjects reads only the partitions within the backfill interval,
+// so it takes as long for an agent which has been running for months as for
+// one which started an hour ago.
+func (f *FileStater) processedObjects() (map[string]time.Time, error) {
+	objs, err := readObjects(f.stateFile())
+	if err != nil {
+		return objs, err
+	}
+
+	for _, partition := range partitions(time.Now(), f.BackfillInterval) {
+		partitionObjs, err := readObjects(f.partitionFile(partition))
+		if err != nil {
+			return objs, err
+		}
+		for k, v := range partitionObjs {
+			objs[k] = v
+		}
+	}
+
+	return objs, nil
+}
+
+func (f *FileStater) ProcessedObjects() (map[string]time.Time, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.processedObjects()
+}
+
+// reap removes partitions, and the old state file, once they're entirely
+// outside of the backfill interval, otherwise state would grow indefinitely.
+func (f *FileStater) reap(now time.Time) error {
+	files, err := f.partitionFiles()
+	if err != nil {
+		return err
+	}
+	for partition, filename := range files {
+		if partitionExpired(partition, now, f.BackfillInterval) {
+			if err := os.Remove(filename); err != nil {
+				return fmt.Errorf("Removing expired state failed: %s", err)
+			}
+		}
+	}
+
+	objs, err := readObjects(f.stateFile())
+	if err != nil || len(objs) == 0 {
+		return err
+	}
+	for _, v := range objs {
+		if now.Sub(v) <= f.BackfillInterval {
+			return nil
+		}
+	}
+	return os.Remove(f.stateFile())
+}
+
+func (f *FileStater) SetProcessed(object string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	now := time.Now()
+	if err := f.reap(now); err != nil {
+		return err
+	}
+
+	filename := f.partitionFile(partition(now))
+	objs, err := readObjects(filename)
+	if err != nil {
+		return err
+	}
+	objs[object] = now
+
+	return writeObjects(filename, objs)
+}
+
+// ClearProcessed goes through every partition, since the object may have been
+// processed in an earlier one than the current.
+func (f *FileStater) ClearProcessed(object string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	files, err := f.partitionFiles()
+	if err != nil {
+		return err
+	}
+	files[""] = f.stateFile()
+	for _, filename := range files {
+		objs, err := readObjects(filename)
+		if err != nil {
+			return err
+		}
+		if _, ok := objs[object]; !ok {
+			continue
+		}
+		delete(objs, object)
+		if err := writeObjects(filename, objs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FileStater) cursorFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(cursorFileFormat, f.Service))
+}
+
+func (f *FileStater) cursors() (map[string]Cursor, error) {
+	cursors := make(map[string]Cursor)
+
+	data, err := ioutil.ReadFile(f.cursorFile())
+	if os.IsNotExist(err) {
+		return cursors, nil
+	}
+	if err != nil {
+		return cursors, fmt.Errorf("Error reading cursor file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		return cursors, fmt.Errorf("Unmarshalling cursor file JSON failed: %s", err)
+	}
+
+	return cursors, nil
+}
+
+func (f *FileStater) Cursor(prefix string) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	cursors, err := f.cursors()
+	if err != nil {
+		return "", err
+	}
+
+	return cursors[prefix].Key, nil
+}
+
+func (f *FileStater) SetCursor(prefix, key string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	cursors, err := f.cursors()
+	if err != nil {
+		return err
+	}
+
+	// Prefixes are per-day, so old ones can be reaped the same way as
+	// processed objects are.
+	for k, v := range cursors {
+		if time.Since(v.Time) > f.BackfillInterval {
+			delete(cursors, k)
+		}
+	}
+
+	if key <= cursors[prefix].Key {
+		return nil
+	}
+	cursors[prefix] = Cursor{Key: key, Time: time.Now()}
+
+	return f.writeCursors(cursors)
+}
+
+func (f *FileStater) writeCursors(cursors map[string]Cursor) error {
+	data, err := json.Marshal(cursors)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+
+	if err := ioutil.WriteFile(f.cursorFile(), data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+
+	return nil
+}
+
+func (f *FileStater) offsetFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(offsetFileFormat, f.Service))
+}
+
+func (f *FileStater) offsets() (map[string]Offset, error) {
+	offsets := make(map[string]Offset)
+
+	data, err := ioutil.ReadFile(f.offsetFile())
+	if os.IsNotExist(err) {
+		return offsets, nil
+	}
+	if err != nil {
+		return offsets, fmt.Errorf("Error reading offset file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		return offsets, fmt.Errorf("Unmarshalling offset file JSON failed: %s", err)
+	}
+
+	return offsets, nil
+}
+
+func (f *FileStater) writeOffsets(offsets map[string]Offset) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+
+	if err := ioutil.WriteFile(f.offsetFile(), data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+
+	return nil
+}
+
+func (f *FileStater) Offsets() (map[string]Offset, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.offsets()
+}
+
+func (f *FileStater) SetOffset(object string, lines int64) error {
+	f.Lock()
+	defer f.Unlock()
+
+	offsets, err := f.offsets()
+	if err != nil {
+		return err
+	}
+
+	for k, v := range offsets {
+		if time.Since(v.Time) > f.BackfillInterval {
+			delete(offsets, k)
+		}
+	}
+	offsets[object] = Offset{Lines: lines, Time: time.Now()}
+
+	return f.writeOffsets(offsets)
+}
+
+func (f *FileStater) ClearOffset(object string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	offsets, err := f.offsets()
+	if err != nil {
+		return err
+	}
+	if _, ok := offsets[object]; !ok {
+		return nil
+	}
+	delete(offsets, object)
+
+	return f.writeOffsets(offsets)
+}
+
+func (f *FileStater) deadLetterFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(deadLetterFileFormat, f.Service))
+}
+
+func (f *FileStater) deadLetters() (map[string]DeadLetter, error) {
+	letters := make(map[string]DeadLetter)
+
+	data, err := ioutil.ReadFile(f.deadLetterFile())
+	if os.IsNotExist(err) {
+		return letters, nil
+	}
+	if err != nil {
+		return letters, fmt.Errorf("Error reading dead letter file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &letters); err != nil {
+		return letters, fmt.Errorf("Unmarshalling dead letter file JSON failed: %s", err)
+	}
+
+	return letters, nil
+}
+
+func (f *FileStater) writeDeadLetters(letters map[string]DeadLetter) error {
+	data, err := json.Marshal(letters)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+
+	if err := ioutil.WriteFile(f.deadLetterFile(), data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+
+	return nil
+}
+
+func (f *FileStater) DeadLetters() (map[string]DeadLetter, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.deadLetters()
+}
+
+// Dead letters are kept until they're cleaned up, unlike offsets, so that
+// they're still there to be looked into after the backfill interval.
+func (f *FileStater) SetDeadLetter(object string, letter DeadLetter) error {
+	f.Lock()
+	defer f.Unlock()
+
+	letters, err := f.deadLetters()
+	if err != nil {
+		return err
+	}
+	letters[object] = letter
+
+	return f.writeDeadLetters(letters)
+}
+
+func (f *FileStater) ClearDeadLetter(object string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	letters, err := f.deadLetters()
+	if err != nil {
+		return err
+	}
+	if _, ok := letters[object]; !ok {
+		return nil
+	}
+	delete(letters, object)
+
+	return f.writeDeadLetters(letters)
+}
+
+func (f *FileStater) missingFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(missingFileFormat, f.Service))
+}
+
+func (f *FileStater) missing() (map[string]Missing, error) {
+	missing := make(map[string]Missing)
+
+	data, err := ioutil.ReadFile(f.missingFile())
+	if os.IsNotExist(err) {
+		return missing, nil
+	}
+	if err != nil {
+		return missing, fmt.Errorf("Error reading missing object file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &missing); err != nil {
+		return missing, fmt.Errorf("Unmarshalling missing object file JSON failed: %s", err)
+	}
+
+	return missing, nil
+}
+
+func (f *FileStater) writeMissing(missing map[string]Missing) error {
+	data, err := json.Marshal(missing)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+
+	if err := ioutil.WriteFile(f.missingFile(), data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+
+	return nil
+}
+
+func (f *FileStater) MissingObjects() (map[string]Missing, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.missing()
+}
+
+// Missing objects are kept until they turn up or are cleaned up, like dead
+// letters.
+func (f *FileStater) SetMissing(object string, missing Missing) error {
+	f.Lock()
+	defer f.Unlock()
+
+	objects, err := f.missing()
+	if err != nil {
+		return err
+	}
+	objects[object] = missing
+
+	return f.writeMissing(objects)
+}
+
+func (f *FileStater) ClearMissing(object string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	objects, err := f.missing()
+	if err != nil {
+		return err
+	}
+	if _, ok := objects[object]; !ok {
+		return nil
+	}
+	delete(objects, object)
+
+	return f.writeMissing(objects)
+}
+
+func (f *FileStater) progressFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(progressFileFormat, f.Service))
+}
+
+func (f *FileStater) Progress() (map[string]Progress, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.progress()
+}
+
+func (f *FileStater) progress() (map[string]Progress, error) {
+	progress := make(map[string]Progress)
+
+	data, err := ioutil.ReadFile(f.progressFile())
+	if os.IsNotExist(err) {
+		return progress, nil
+	}
+	if err != nil {
+		return progress, fmt.Errorf("Error reading progress file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return progress, fmt.Errorf("Unmarshalling progress file JSON failed: %s", err)
+	}
+
+	return progress, nil
+}
+
+// Progress is kept for each load balancer the latest was recorded for within
+// the backfill interval. The file is written alongside and renamed, since
+// status may read it at any time.
+func (f *FileStater) SetProgress(entity string, p Progress) error {
+	f.Lock()
+	defer f.Unlock()
+
+	progress, err := f.progress()
+	if err != nil {
+		return err
+	}
+	for k, v := range progress {
+		if time.Since(v.Time) > f.BackfillInterval {
+			delete(progress, k)
+		}
+	}
+	progress[entity] = p
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	tmp := f.progressFile() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+	if err := os.Rename(tmp, f.progressFile()); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+
+	return nil
+}
+
+func (f *FileStater) replayFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(replayFileFormat, f.Service))
+}
+
+func (f *FileStater) Replays() (map[string]Replay, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.replays()
+}
+
+func (f *FileStater) replays() (map[string]Replay, error) {
+	replays := make(map[string]Replay)
+
+	data, err := ioutil.ReadFile(f.replayFile())
+	if os.IsNotExist(err) {
+		return replays, nil
+	}
+	if err != nil {
+		return replays, fmt.Errorf("Error reading replays file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &replays); err != nil {
+		return replays, fmt.Errorf("Unmarshalling replays file JSON failed: %s", err)
+	}
+
+	return replays, nil
+}
+
+// Replays are all kept, however long ago they were, written alongside and
+// renamed like progress.
+func (f *FileStater) SetReplay(id string, replay Replay) error {
+	f.Lock()
+	defer f.Unlock()
+
+	replays, err := f.replays()
+	if err != nil {
+		return err
+	}
+	replays[id] = replay
+
+	data, err := json.Marshal(replays)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+	tmp := f.replayFile() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+	if err := os.Rename(tmp, f.replayFile()); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+	return nil
+}
+
+func (f *FileStater) contentFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(contentFileFormat, f.Service))
+}
+
+func (f *FileStater) contents() (map[string]contentRecord, error) {
+	contents := make(map[string]contentRecord)
+
+	data, err := ioutil.ReadFile(f.contentFile())
+	if os.IsNotExist(err) {
+		return contents, nil
+	}
+	if err != nil {
+		return contents, fmt.Errorf("Error reading content file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return contents, fmt.Errorf("Unmarshalling content file JSON failed: %s", err)
+	}
+
+	return contents, nil
+}
+
+func (f *FileStater) writeContents(contents map[string]contentRecord) error {
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return fmt.Errorf("Marshalling JSON failed: %s", err)
+	}
+
+	if err := ioutil.WriteFile(f.contentFile(), data, 0644); err != nil {
+		return fmt.Errorf("Writing file failed: %s", err)
+	}
+
+	return nil
+}
+
+func (f *FileStater) SetContentProcessed(etag, object string) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	contents, err := f.contents()
+	if err != nil {
+		return "", err
+	}
+	if c, ok := contents[etag]; ok && time.Since(c.Time) <= f.BackfillInterval {
+		return c.Object, nil
+	}
+
+	// Reaped the same way as processed objects.
+	for k, v := range contents {
+		if time.Since(v.Time) > f.BackfillInterval {
+			delete(contents, k)
+		}
+	}
+	contents[etag] = contentRecord{Object: object, Time: time.Now()}
+
+	return object, f.writeContents(contents)
+}
+
+func (f *FileStater) publishedFile() string {
+	return filepath.Join(f.StateDir, fmt.Sprintf(publishedFileFormat, f.Service))
+}
+
+func (f *FileStater) Published(object string) (bool, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	published, err := readObjects(f.publishedFile())
+	if err != nil {
+		return false, err
+	}
+	t, ok := published[object]
+	return ok && time.Since(t) <= f.BackfillInterval, nil
+}
+
+// Published objects are kept in a file of their own, reaped the same way as
+// processed objects.
+func (f *FileStater) SetPublished(object string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	published, err := readObjects(f.publishedFile())
+	if err != nil {
+		return err
+	}
+	if t, ok := published[object]; ok && time.Since(t) <= f.BackfillInterval {
+		return fmt.Errorf("Object already published: %s", object)
+	}
+	for k, v := range published {
+		if time.Since(v) > f.BackfillInterval {
+			delete(published, k)
+		}
+	}
+	published[object] = time.Now()
+
+	return writeObjects(f.publishedFile(), published)
+}
+
+func (f *FileStater) ClaimOffset(object string, offset Offset) error {
+	f.Lock()
+	defer f.Unlock()
+
+	offsets, err := f.offsets()
+	if err != nil {
+		return err
+	}
+	if !offsets[object].Time.Equal(offset.Time) {
+		return fmt.Errorf("Offset already claimed: %s", object)
+	}
+	offsets[object] = Offset{Lines: offset.Lines, Time: time.Now()}
+
+	return f.writeOffsets(offsets)
+}
+
+func (f *FileStater) Cleanup(before time.Time) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	deleted := 0
+
+	files, err := f.partitionFiles()
+	if err != nil {
+		return deleted, err
+	}
+	files[""] = f.stateFile()
+	for _, filename := range files {
+		objs, err := readObjects(filename)
+		if err != nil {
+			return deleted, err
+		}
+		n := len(objs)
+		for k, v := range objs {
+			if v.Before(before) {
+				delete(objs, k)
+			}
+		}
+		if len(objs) == n {
+			continue
+		}
+		if len(objs) == 0 {
+			err = os.Remove(filename)
+		} else {
+			err = writeObjects(filename, objs)
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n - len(objs)
+	}
+
+	cursors, err := f.cursors()
+	if err != nil {
+		return deleted, err
+	}
+	n := len(cursors)
+	for k, v := range cursors {
+		if v.Time.Before(before) {
+			delete(cursors, k)
+		}
+	}
+	if len(cursors) < n {
+		if err := f.writeCursors(cursors); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(cursors)
+	}
+
+	offsets, err := f.offsets()
+	if err != nil {
+		return deleted, err
+	}
+	n = len(offsets)
+	for k, v := range offsets {
+		if v.Time.Before(before) {
+			delete(offsets, k)
+		}
+	}
+	if len(offsets) < n {
+		if err := f.writeOffsets(offsets); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(offsets)
+	}
+
+	letters, err := f.deadLetters()
+	if err != nil {
+		return deleted, err
+	}
+	n = len(letters)
+	for k, v := range letters {
+		if v.Time.Before(before) {
+			delete(letters, k)
+		}
+	}
+	if len(letters) < n {
+		if err := f.writeDeadLetters(letters); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(letters)
+	}
+
+	missing, err := f.missing()
+	if err != nil {
+		return deleted, err
+	}
+	n = len(missing)
+	for k, v := range missing {
+		if v.Time.Before(before) {
+			delete(missing, k)
+		}
+	}
+	if len(missing) < n {
+		if err := f.writeMissing(missing); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(missing)
+	}
+
+	contents, err := f.contents()
+	if err != nil {
+		return deleted, err
+	}
+	n = len(contents)
+	for k, v := range contents {
+		if v.Time.Before(before) {
+			delete(contents, k)
+		}
+	}
+	if len(contents) < n {
+		if err := f.writeContents(contents); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(contents)
+	}
+
+	published, err := readObjects(f.publishedFile())
+	if err != nil {
+		return deleted, err
+	}
+	n = len(published)
+	for k, v := range published {
+		if v.Before(before) {
+			delete(published, k)
+		}
+	}
+	if len(published) < n {
+		if err := writeObjects(f.publishedFile(), published); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(published)
+	}
+
+	return deleted, nil
+}
+
+// Reset goes through every partition, not just those within the backfill
+// interval, as Cleanup does.
+func (f *FileStater) Reset(match Matcher) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	deleted := 0
+	var objects []string
+
+	files, err := f.partitionFiles()
+	if err != nil {
+		return deleted, err
+	}
+	files[""] = f.stateFile()
+	for _, filename := range files {
+		objs, err := readObjects(filename)
+		if err != nil {
+			return deleted, err
+		}
+		n := len(objs)
+		for k, v := range objs {
+			if match(k, v) {
+				delete(objs, k)
+				objects = append(objects, k)
+			}
+		}
+		if len(objs) == n {
+			continue
+		}
+		if len(objs) == 0 {
+			err = os.Remove(filename)
+		} else {
+			err = writeObjects(filename, objs)
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n - len(objs)
+	}
+
+	offsets, err := f.offsets()
+	if err != nil {
+		return deleted, err
+	}
+	n := len(offsets)
+	for k, v := range offsets {
+		if match(k, v.Time) {
+			delete(offsets, k)
+			objects = append(objects, k)
+		}
+	}
+	if len(offsets) < n {
+		if err := f.writeOffsets(offsets); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(offsets)
 	}
 
+	letters, err := f.deadLetters()
+	if err != nil {
+		return deleted, err
+	}
+	n = len(letters)
+	for k, v := range letters {
+		if match(k, v.Time) {
+			delete(letters, k)
+			objects = append(objects, k)
+		}
+	}
+	if len(letters) < n {
+		if err := f.writeDeadLetters(letters); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(letters)
+	}
+
+	missing, err := f.missing()
+	if err != nil {
+		return deleted, err
+	}
+	n = len(missing)
+	for k, v := range missing {
+		if match(k, v.Time) {
+			delete(missing, k)
+			objects = append(objects, k)
+		}
+	}
+	if len(missing) < n {
+		if err := f.writeMissing(missing); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(missing)
+	}
+
+	published, err := readObjects(f.publishedFile())
+	if err != nil {
+		return deleted, err
+	}
+	n = len(published)
+	for k, v := range published {
+		if match(k, v) {
+			delete(published, k)
+			objects = append(objects, k)
+		}
+	}
+	if len(published) < n {
+		if err := writeObjects(f.publishedFile(), published); err != nil {
+			return deleted, err
+		}
+		deleted += n - len(published)
+	}
+
+	cursors, err := f.cursors()
+	if err != nil {
+		return deleted, err
+	}
+	prefixes := make([]string, 0, len(cursors))
+	for prefix := range cursors {
+		prefixes = append(prefixes, prefix)
+	}
+	forget := forgetCursors(prefixes, objects)
+	if len(forget) > 0 {
+		for _, prefix := range forget {
+			delete(cursors, prefix)
+		}
+		if err := f.writeCursors(cursors); err != nil {
+			return deleted, err
+		}
+		deleted += len(forget)
+	}
+
+	return deleted, nil
+}
+
+// MemoryStater tracks processing state in memory only, for environments such
+// as AWS Lambda which have no durable local filesystem. State is lost when the
+// process exits.
+type MemoryStater struct {
+	*sync.Mutex
+	BackfillInterval time.Duration
+	processed        map[string]time.Time
+	cursors          map[string]Cursor
+	offsets          map[string]Offset
+	deadLetters      map[string]DeadLetter
+	missing          map[string]Missing
+	contents         map[string]contentRecord
+	progress         map[string]Progress
+	published        map[string]time.Time
+	replays          map[string]Replay
+}
+
+func NewMemoryStater(backfillHrs int) *MemoryStater {
+	return &MemoryStater{
+		Mutex:            &sync.Mutex{},
+		BackfillInterval: time.Hour * time.Duration(backfillHrs),
+		processed:        make(map[string]time.Time),
+		cursors:          make(map[string]Cursor),
+		offsets:          make(map[string]Offset),
+		deadLetters:      make(map[string]DeadLetter),
+		missing:          make(map[string]Missing),
+		contents:         make(map[string]contentRecord),
+		progress:         make(map[string]Progress),
+		published:        make(map[string]time.Time),
+		replays:          make(map[string]Replay),
+	}
+}
+
+func (m *MemoryStater) ProcessedObjects() (map[string]time.Time, error) {
+	m.Lock()
+	defer m.Unlock()
+	objs := make(map[string]time.Time, len(m.processed))
+	for k, v := range m.processed {
+		objs[k] = v
+	}
+	return objs, nil
+}
+
+func (m *MemoryStater) SetProcessed(object string) error {
+	m.Lock()
+	defer m.Unlock()
+	for k, v := range m.processed {
+		if time.Since(v) > m.BackfillInterval {
+			delete(m.processed, k)
+		}
+	}
+	m.processed[object] = time.Now()
+	return nil
+}
+
+func (m *MemoryStater) ClearProcessed(object string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.processed, object)
+	return nil
+}
+
+func (m *MemoryStater) Cursor(prefix string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.cursors[prefix].Key, nil
+}
+
+func (m *MemoryStater) SetCursor(prefix, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	for k, v := range m.cursors {
+		if time.Since(v.Time) > m.BackfillInterval {
+			delete(m.cursors, k)
+		}
+	}
+	if key > m.cursors[prefix].Key {
+		m.cursors[prefix] = Cursor{Key: key, Time: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemoryStater) Offsets() (map[string]Offset, error) {
+	m.Lock()
+	defer m.Unlock()
+	offsets := make(map[string]Offset, len(m.offsets))
+	for k, v := range m.offsets {
+		offsets[k] = v
+	}
+	return offsets, nil
+}
+
+func (m *MemoryStater) SetOffset(object string, lines int64) error {
+	m.Lock()
+	defer m.Unlock()
+	m.offsets[object] = Offset{Lines: lines, Time: time.Now()}
+	return nil
+}
+
+func (m *MemoryStater) ClearOffset(object string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.offsets, object)
+	return nil
+}
+
+func (m *MemoryStater) DeadLetters() (map[string]DeadLetter, error) {
+	m.Lock()
+	defer m.Unlock()
+	letters := make(map[string]DeadLetter, len(m.deadLetters))
+	for k, v := range m.deadLetters {
+		letters[k] = v
+	}
+	return letters, nil
+}
+
+func (m *MemoryStater) SetDeadLetter(object string, letter DeadLetter) error {
+	m.Lock()
+	defer m.Unlock()
+	m.deadLetters[object] = letter
+	return nil
+}
+
+func (m *MemoryStater) ClearDeadLetter(object string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.deadLetters, object)
+	return nil
+}
+
+func (m *MemoryStater) MissingObjects() (map[string]Missing, error) {
+	m.Lock()
+	defer m.Unlock()
+	missing := make(map[string]Missing, len(m.missing))
+	for k, v := range m.missing {
+		missing[k] = v
+	}
+	return missing, nil
+}
+
+func (m *MemoryStater) SetMissing(object string, missing Missing) error {
+	m.Lock()
+	defer m.Unlock()
+	m.missing[object] = missing
+	return nil
+}
+
+func (m *MemoryStater) ClearMissing(object string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.missing, object)
+	return nil
+}
+
+func (m *MemoryStater) Progress() (map[string]Progress, error) {
+	m.Lock()
+	defer m.Unlock()
+	progress := make(map[string]Progress, len(m.progress))
+	for k, v := range m.progress {
+		progress[k] = v
+	}
+	return progress, nil
+}
+
+func (m *MemoryStater) SetProgress(entity string, progress Progress) error {
+	m.Lock()
+	defer m.Unlock()
+	m.progress[entity] = progress
+	return nil
+}
+
+func (m *MemoryStater) Replays() (map[string]Replay, error) {
+	m.Lock()
+	defer m.Unlock()
+	replays := make(map[string]Replay, len(m.replays))
+	for k, v := range m.replays {
+		replays[k] = v
+	}
+	return replays, nil
+}
+
+func (m *MemoryStater) SetReplay(id string, replay Replay) error {
+	m.Lock()
+	defer m.Unlock()
+	m.replays[id] = replay
+	return nil
+}
+
+func (m *MemoryStater) SetContentProcessed(etag, object string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	for k, v := range m.contents {
+		if time.Since(v.Time) > m.BackfillInterval {
+			delete(m.contents, k)
+		}
+	}
+	if c, ok := m.contents[etag]; ok {
+		return c.Object, nil
+	}
+	m.contents[etag] = contentRecord{Object: object, Time: time.Now()}
+	return object, nil
+}
+
+func (m *MemoryStater) Published(object string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	t, ok := m.published[object]
+	return ok && time.Since(t) <= m.BackfillInterval, nil
+}
+
+func (m *MemoryStater) SetPublished(object string) error {
+	m.Lock()
+	defer m.Unlock()
+	for k, v := range m.published {
+		if time.Since(v) > m.BackfillInterval {
+			delete(m.published, k)
+		}
+	}
+	if _, ok := m.published[object]; ok {
+		return fmt.Errorf("Object already published: %s", object)
+	}
+	m.published[object] = time.Now()
+	return nil
+}
+
+func (m *MemoryStater) ClaimOffset(object string, offset Offset) error {
+	m.Lock()
+	defer m.Unlock()
+	if !m.offsets[object].Time.Equal(offset.Time) {
+		return fmt.Errorf("Offset already claimed: %s", object)
+	}
+	m.offsets[object] = Offset{Lines: offset.Lines, Time: time.Now()}
 	return nil
 }
+
+func (m *MemoryStater) Cleanup(before time.Time) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	deleted := 0
+	for k, v := range m.processed {
+		if v.Before(before) {
+			delete(m.processed, k)
+			deleted++
+		}
+	}
+	for k, v := range m.cursors {
+		if v.Time.Before(before) {
+			delete(m.cursors, k)
+			deleted++
+		}
+	}
+	for k, v := range m.offsets {
+		if v.Time.Before(before) {
+			delete(m.offsets, k)
+			deleted++
+		}
+	}
+	for k, v := range m.deadLetters {
+		if v.Time.Before(before) {
+			delete(m.deadLetters, k)
+			deleted++
+		}
+	}
+	for k, v := range m.missing {
+		if v.Time.Before(before) {
+			delete(m.missing, k)
+			deleted++
+		}
+	}
+	for k, v := range m.contents {
+		if v.Time.Before(before) {
+			delete(m.contents, k)
+			deleted++
+		}
+	}
+	for k, v := range m.published {
+		if v.Before(before) {
+			delete(m.published, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,51 @@
+// Package tracing instruments the agent's own pipeline (listing, downloading,
+// parsing and publishing each object) with OpenTelemetry, so that the
+// performance of the agent itself can be investigated.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/honeycombio/honeyaws"
+
+// Tracer returns the tracer used throughout the agent. Until Init is called,
+// it's a no-op.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Init exports spans over OTLP/HTTP to the given endpoint (host:port), and
+// returns a function which flushes any remaining spans on shutdown.
+func Init(endpoint string, insecure bool, serviceName, version string) (func(), error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(version),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func() {
+		tp.Shutdown(context.Background())
+	}, nil
+}
@@ -0,0 +1,139 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Filter is a Query API filter, e.g. {"elb", "contains", "app/my-lb/"}.
+type Filter struct {
+	Column string      `json:"column"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+type querySpec struct {
+	Calculations      []map[string]string `json:"calculations"`
+	Filters           []Filter            `json:"filters,omitempty"`
+	FilterCombination string              `json:"filter_combination,omitempty"`
+	StartTime         int64               `json:"start_time"`
+	EndTime           int64               `json:"end_time"`
+}
+
+type queryResult struct {
+	ID       string `json:"id"`
+	Complete bool   `json:"complete"`
+	Data     struct {
+		Results []struct {
+			Data map[string]float64 `json:"data"`
+		} `json:"results"`
+	} `json:"data"`
+}
+
+// QueryClient runs queries with Honeycomb's Query API, which needs an API key
+// with the Run Queries permission rather than a write key.
+type QueryClient struct {
+	APIHost string
+	Key     string
+	Client  *http.Client
+
+	// PollInterval is how long to wait between checking whether a query
+	// has finished running.
+	PollInterval time.Duration
+}
+
+func NewQueryClient(apiHost, key string) *QueryClient {
+	return &QueryClient{
+		APIHost:      apiHost,
+		Key:          key,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		PollInterval: time.Second,
+	}
+}
+
+// Count returns the number of events in the dataset between start and end
+// matching any of the filters. Honeycomb weights COUNT by each event's sample
+// rate, so this is its estimate of the events there were before sampling.
+func (c *QueryClient) Count(dataset string, filters []Filter, start, end time.Time) (float64, error) {
+	spec := querySpec{
+		Calculations: []map[string]string{{"op": "COUNT"}},
+		Filters:      filters,
+		StartTime:    start.Unix(),
+		EndTime:      end.Unix(),
+	}
+	if len(filters) > 1 {
+		spec.FilterCombination = "OR"
+	}
+
+	var query struct {
+		ID string `json:"id"`
+	}
+	if err := c.do("POST", "/1/queries/"+url.PathEscape(dataset), spec, &query); err != nil {
+		return 0, fmt.Errorf("creating query: %s", err)
+	}
+
+	var result queryResult
+	err := c.do("POST", "/1/query_results/"+url.PathEscape(dataset), map[string]interface{}{
+		"query_id":       query.ID,
+		"disable_series": true,
+	}, &result)
+	if err != nil {
+		return 0, fmt.Errorf("running query: %s", err)
+	}
+	for tries := 0; !result.Complete; tries++ {
+		if tries == 60 {
+			return 0, fmt.Errorf("query %s didn't complete", result.ID)
+		}
+		time.Sleep(c.PollInterval)
+		if err := c.do("GET", "/1/query_results/"+url.PathEscape(dataset)+"/"+result.ID, nil, &result); err != nil {
+			return 0, fmt.Errorf("getting query result: %s", err)
+		}
+	}
+
+	// There are no results at all when no events matched.
+	if len(result.Data.Results) == 0 {
+		return 0, nil
+	}
+	return result.Data.Results[0].Data["COUNT"], nil
+}
+
+func (c *QueryClient) do(method, p string, body, out interface{}) error {
+	u, err := url.Parse(c.APIHost)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join(u.Path, p)
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u.String(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Honeycomb-Team", c.Key)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	return json.Unmarshal(respBody, out)
+}
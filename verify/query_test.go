@@ -0,0 +1,53 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCount(t *testing.T) {
+	polls := 0
+	var spec querySpec
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Honeycomb-Team") != "querykey" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/1/queries/aws-elb-access":
+			json.NewDecoder(r.Body).Decode(&spec)
+			w.Write([]byte(`{"id":"q1"}`))
+		case r.Method == "POST" && r.URL.Path == "/1/query_results/aws-elb-access":
+			w.Write([]byte(`{"id":"r1","complete":false}`))
+		case r.Method == "GET" && r.URL.Path == "/1/query_results/aws-elb-access/r1":
+			polls++
+			w.Write([]byte(`{"id":"r1","complete":true,"data":{"results":[{"data":{"COUNT":1234}}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewQueryClient(srv.URL, "querykey")
+	c.PollInterval = time.Millisecond
+	start := time.Unix(1600000000, 0)
+	filters := []Filter{{"elb", "contains", "app/a/"}, {"elb", "contains", "app/b/"}}
+	count, err := c.Count("aws-elb-access", filters, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1234 || polls != 1 {
+		t.Errorf("expected a COUNT of 1234 after polling once, got %v after %d", count, polls)
+	}
+	if spec.FilterCombination != "OR" || spec.StartTime != 1600000000 || spec.EndTime != 1600003600 {
+		t.Errorf("unexpected query %+v", spec)
+	}
+
+	c.Key = "writekey"
+	if _, err := c.Count("aws-elb-access", filters, start, start.Add(time.Hour)); err == nil {
+		t.Error("expected an error with a key that can't run queries")
+	}
+}
@@ -0,0 +1,120 @@
+// Package verify checks that sampling is statistically sound end to end, by
+// comparing the events counted in the logs with Honeycomb's estimate of them
+// from the sampled events it received, weighted by their sample rates.
+package verify
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// maxSigmas is how many standard errors away from the logs' count Honeycomb's
+// estimate can be before it's reported as drift. Sampling alone puts it that
+// far out less than 0.3% of the time.
+const maxSigmas = 3
+
+// minDrift is the relative difference below which Honeycomb's estimate is
+// never reported as drift, e.g. when nothing is sampled and so there's no
+// sampling error to go by.
+const minDrift = 0.001
+
+// Tally counts the events parsed from the logs, and those kept by sampling at
+// which rates.
+type Tally struct {
+	sync.Mutex
+	Parsed   int64
+	Kept     int64
+	Weighted float64
+
+	// variance is that of the weighted count as an estimate of the
+	// parsed count: each event kept at rate r stands in for r events,
+	// with a variance of r(r-1).
+	variance float64
+}
+
+// Parse counts an event parsed from the logs, before sampling.
+func (t *Tally) Parse() {
+	t.Lock()
+	t.Parsed++
+	t.Unlock()
+}
+
+// Keep counts an event kept by sampling at the rate.
+func (t *Tally) Keep(rate int) {
+	t.Lock()
+	t.Kept++
+	t.Weighted += float64(rate)
+	t.variance += float64(rate) * float64(rate-1)
+	t.Unlock()
+}
+
+// StdErr is the standard error of the weighted count.
+func (t *Tally) StdErr() float64 {
+	t.Lock()
+	defer t.Unlock()
+	return math.Sqrt(t.variance)
+}
+
+// Report compares the tally with Honeycomb's count of the same events.
+type Report struct {
+	Parsed    int64
+	Kept      int64
+	Dropped   int64
+	Expected  float64
+	Honeycomb float64
+	StdErr    float64
+}
+
+func Compare(t *Tally, honeycomb float64) Report {
+	stdErr := t.StdErr()
+	t.Lock()
+	defer t.Unlock()
+	return Report{
+		Parsed:    t.Parsed,
+		Kept:      t.Kept,
+		Dropped:   t.Parsed - t.Kept,
+		Expected:  t.Weighted,
+		Honeycomb: honeycomb,
+		StdErr:    stdErr,
+	}
+}
+
+// Drift is how far Honeycomb's count is from the logs', relative to them.
+func (r Report) Drift() float64 {
+	if r.Parsed == 0 {
+		if r.Honeycomb == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (r.Honeycomb - float64(r.Parsed)) / float64(r.Parsed)
+}
+
+// Sigmas is how many standard errors Honeycomb's count is from the logs'.
+func (r Report) Sigmas() float64 {
+	diff := math.Abs(r.Honeycomb - float64(r.Parsed))
+	if r.StdErr == 0 {
+		if diff == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return diff / r.StdErr
+}
+
+// Drifted reports whether Honeycomb's count is further from the logs' than
+// sampling accounts for.
+func (r Report) Drifted() bool {
+	return r.Sigmas() > maxSigmas && math.Abs(r.Drift()) > minDrift
+}
+
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Parsed from logs:\t%d\n", r.Parsed)
+	fmt.Fprintf(w, "Kept:\t\t\t%d\n", r.Kept)
+	fmt.Fprintf(w, "Dropped:\t\t%d\n", r.Dropped)
+	fmt.Fprintf(w, "Expected in Honeycomb:\t%.0f ± %.0f\n", r.Expected, r.StdErr)
+	fmt.Fprintf(w, "Honeycomb COUNT:\t%.0f\n", r.Honeycomb)
+	fmt.Fprintf(w, "Drift:\t\t\t%+.2f%% (%.1f standard errors)\n", r.Drift()*100, r.Sigmas())
+}
@@ -0,0 +1,39 @@
+package verify
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tally := &Tally{}
+	for i := 0; i < 10000; i++ {
+		tally.Parse()
+	}
+	for i := 0; i < 1000; i++ {
+		tally.Keep(10)
+	}
+
+	// Each event kept at a rate of 10 has a variance of 90, so the
+	// standard error is sqrt(90000) = 300.
+	report := Compare(tally, 10600)
+	if report.Dropped != 9000 || report.StdErr != 300 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.Drifted() {
+		t.Errorf("expected 2 standard errors out to be within sampling error, got %v", report.Sigmas())
+	}
+
+	if report = Compare(tally, 12000); !report.Drifted() {
+		t.Errorf("expected %+.2f%% to be drift", report.Drift()*100)
+	}
+
+	unsampled := &Tally{}
+	for i := 0; i < 100000; i++ {
+		unsampled.Parse()
+		unsampled.Keep(1)
+	}
+	if report = Compare(unsampled, 99990); report.Drifted() {
+		t.Error("expected a tiny difference without sampling not to be drift")
+	}
+	if report = Compare(unsampled, 90000); !report.Drifted() {
+		t.Error("expected missing events without sampling to be drift")
+	}
+}